@@ -0,0 +1,63 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+
+	api "github.com/pulcy/robin-api"
+)
+
+const (
+	defaultApiClientTimeout = 30 * time.Second
+	defaultApiClientRetries = 3
+)
+
+// apiClientArgs holds the options of commands that use the API of a Robin instance.
+type apiClientArgs struct {
+	token              string
+	timeout            time.Duration
+	retries            int
+	caCertFile         string
+	certFile           string
+	keyFile            string
+	insecureSkipVerify bool
+}
+
+// addFlags adds the flags of the API client options to the given set.
+func (a *apiClientArgs) addFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&a.token, "api-token", "", "If set, API requests are authorized with this token")
+	flags.DurationVar(&a.timeout, "api-timeout", defaultApiClientTimeout, "Timeout of an API request, including retries (0 means none)")
+	flags.IntVar(&a.retries, "api-retries", defaultApiClientRetries, "Number of retries of API requests that fail with a connection error or 5xx status")
+	flags.StringVar(&a.caCertFile, "api-ca-cert", "", "If set, the certificate of the API must be signed by a CA in this PEM file")
+	flags.StringVar(&a.certFile, "api-cert", "", "Client certificate (PEM) sent to the API (requires --api-key)")
+	flags.StringVar(&a.keyFile, "api-key", "", "Private key (PEM) of --api-cert")
+	flags.BoolVar(&a.insecureSkipVerify, "api-insecure-skip-verify", false, "If set, the certificate of the API is not verified")
+}
+
+// clientConfig returns the configuration of an API client.
+func (a *apiClientArgs) clientConfig() api.ClientConfig {
+	return api.ClientConfig{
+		Token:              a.token,
+		Timeout:            a.timeout,
+		MaxRetries:         a.retries,
+		CACertFile:         a.caCertFile,
+		CertFile:           a.certFile,
+		KeyFile:            a.keyFile,
+		InsecureSkipVerify: a.insecureSkipVerify,
+	}
+}
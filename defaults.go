@@ -26,6 +26,7 @@ const (
 
 const (
 	defaultBackend           = "etcd"
+	defaultEtcdAPIVersion    = 2
 	defaultStatsPort         = 7088
 	defaultStatsSslCert      = ""
 	defaultSslCertsFolder    = "/certs/"
@@ -43,6 +44,10 @@ const (
 	defaultPrivateKeyPathTmpl   = "~/.pulcy/acme/private-key.pem"
 	defaultRegistrationPathTmpl = "~/.pulcy/acme/registration.json"
 	defaultTmpCertificatePath   = "/tmp/certificates"
+
+	defaultAcmeChallengeStore     = "etcd"
+	defaultAcmeRepository         = "etcd"
+	defaultAcmeChallengeNamespace = "default"
 )
 
 const (
@@ -1,12 +1,16 @@
 package client
 
+import "context"
+
 type (
 	// Client is an interface that represents a kubernetes client
 	Client interface {
 		ConfigMapInterface
+		CustomResourceInterface
 		DaemonSetInterface
 		DeploymentInterface
 		EndpointsInterface
+		EndpointSliceInterface
 		HorizontalPodAutoscalerInterface
 		IngressInterface
 		JobInterface
@@ -27,5 +31,7 @@ type (
 	WatchOptions struct {
 		ListOptions
 		ResourceVersion string
+		// Context ends the watch when it is done (optional)
+		Context context.Context
 	}
 )
@@ -0,0 +1,9 @@
+package client
+
+type (
+	// CustomResourceInterface has methods to work with resources defined by a CustomResourceDefinition.
+	// Since their types are not known to this client, events contain the raw objects.
+	CustomResourceInterface interface {
+		WatchCustomResources(group, version, plural, namespace string, opts *WatchOptions, events chan WatchEvent) error
+	}
+)
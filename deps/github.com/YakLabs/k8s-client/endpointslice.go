@@ -0,0 +1,81 @@
+package client
+
+const (
+	// LabelServiceName is the label of an EndpointSlice that contains the name of its service.
+	LabelServiceName = "kubernetes.io/service-name"
+)
+
+type (
+	// EndpointSliceInterface has methods to work with EndpointSlice resources.
+	EndpointSliceInterface interface {
+		CreateEndpointSlice(namespace string, item *EndpointSlice) (*EndpointSlice, error)
+		GetEndpointSlice(namespace, name string) (result *EndpointSlice, err error)
+		ListEndpointSlices(namespace string, opts *ListOptions) (*EndpointSliceList, error)
+		WatchEndpointSlices(namespace string, opts *WatchOptions, events chan EndpointSliceWatchEvent) error
+		DeleteEndpointSlice(namespace, name string) error
+		UpdateEndpointSlice(namespace string, item *EndpointSlice) (*EndpointSlice, error)
+	}
+
+	EndpointSliceWatchEvent interface {
+		Type() WatchEventType
+		Object() (*EndpointSlice, error)
+	}
+
+	// EndpointSlice represents a subset of the endpoints that implement a service.
+	// The slices of a service are labeled with the name of the service (see LabelServiceName).
+	EndpointSlice struct {
+		TypeMeta   `json:",inline"`
+		ObjectMeta `json:"metadata,omitempty"`
+
+		// Type of address carried by this EndpointSlice (IPv4, IPv6 or FQDN).
+		AddressType string `json:"addressType"`
+		// List of unique endpoints in this slice.
+		Endpoints []Endpoint `json:"endpoints"`
+		// List of network ports exposed by each endpoint in this slice.
+		Ports []EndpointSlicePort `json:"ports,omitempty"`
+	}
+
+	// Endpoint represents a single logical "backend" implementing a service.
+	Endpoint struct {
+		// Addresses of this endpoint (all fungible).
+		Addresses []string `json:"addresses"`
+		// Current state of the endpoint.
+		Conditions EndpointConditions `json:"conditions,omitempty"`
+		// The Hostname of this endpoint
+		Hostname *string `json:"hostname,omitempty"`
+		// Reference to object providing the endpoint.
+		TargetRef *ObjectReference `json:"targetRef,omitempty"`
+		// Optional: Node hosting this endpoint.
+		NodeName *string `json:"nodeName,omitempty"`
+		// Optional: Zone in which this endpoint exists.
+		Zone *string `json:"zone,omitempty"`
+	}
+
+	// EndpointConditions represents the current condition of an endpoint.
+	// A nil condition means unknown.
+	EndpointConditions struct {
+		// Ready indicates that this endpoint is prepared to receive traffic.
+		Ready *bool `json:"ready,omitempty"`
+		// Serving is identical to ready except that it is set regardless of the terminating state of endpoints.
+		Serving *bool `json:"serving,omitempty"`
+		// Terminating indicates that this endpoint is terminating.
+		Terminating *bool `json:"terminating,omitempty"`
+	}
+
+	EndpointSlicePort struct {
+		// The name of this port (corresponds to ServicePort.Name).
+		Name *string `json:"name,omitempty"`
+		// The IP protocol for this port. Must be UDP, TCP or SCTP. Default is TCP.
+		Protocol *string `json:"protocol,omitempty"`
+		// The port number of the endpoint.
+		Port *int32 `json:"port,omitempty"`
+	}
+
+	// EndpointSliceList holds a list of endpoint slices.
+	EndpointSliceList struct {
+		TypeMeta `json:",inline"`
+		ListMeta `json:"metadata,omitempty"`
+
+		Items []EndpointSlice `json:"items"`
+	}
+)
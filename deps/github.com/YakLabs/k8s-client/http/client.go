@@ -2,6 +2,7 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
@@ -30,6 +31,7 @@ import (
 //go:generate ./make-type Service v1
 //go:generate ./make-type ServiceAccount v1
 //go:generate ./make-type Endpoints v1 -
+//go:generate ./make-type EndpointSlice discovery.k8s.io/v1
 
 const (
 	tokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
@@ -319,11 +321,18 @@ func (c *Client) do(method, path string, in interface{}, out interface{}, codes
 	return resp.StatusCode, nil
 }
 
-func (c *Client) doWatch(method, path string, in interface{}, out chan k8s.WatchEvent, codes ...int) (int, error) {
+// doWatch runs a watch request and sends its events to the given channel until the watch ends.
+// The request is canceled (ending the watch) when the given context is done.
+func (c *Client) doWatch(ctx context.Context, method, path string, in interface{}, out chan k8s.WatchEvent, codes ...int) (int, error) {
+	if out != nil {
+		// Nothing is sent after the watch ends, so let the receiver know
+		defer close(out)
+	}
 	req, err := c.newRequest(method, path, in)
 	if err != nil {
 		return 0, err
 	}
+	req = req.WithContext(ctx)
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -396,6 +405,14 @@ func listOptionsQuery(opts *k8s.ListOptions, val url.Values) string {
 	return val.Encode()
 }
 
+// watchOptionsContext returns the context that ends a watch with the given options.
+func watchOptionsContext(opts *k8s.WatchOptions) context.Context {
+	if opts != nil && opts.Context != nil {
+		return opts.Context
+	}
+	return context.Background()
+}
+
 func watchOptionsQuery(opts *k8s.WatchOptions) string {
 	val := url.Values{}
 	val.Set("watch", "true")
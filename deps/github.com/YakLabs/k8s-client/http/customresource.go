@@ -0,0 +1,25 @@
+package http
+
+import (
+	k8s "github.com/YakLabs/k8s-client"
+	"github.com/pkg/errors"
+)
+
+func customResourceGeneratePath(group, version, plural, namespace string) string {
+	if namespace == "" {
+		return "/apis/" + group + "/" + version + "/" + plural
+	}
+	return "/apis/" + group + "/" + version + "/namespaces/" + namespace + "/" + plural
+}
+
+// WatchCustomResources watches all changes of custom resources with given group, version & plural name in a namespace
+func (c *Client) WatchCustomResources(group, version, plural, namespace string, opts *k8s.WatchOptions, events chan k8s.WatchEvent) error {
+	if events == nil {
+		return errors.New("events must not be nil")
+	}
+	_, err := c.doWatch(watchOptionsContext(opts), "GET", customResourceGeneratePath(group, version, plural, namespace)+"?"+watchOptionsQuery(opts), nil, events)
+	if err != nil {
+		return errors.Wrap(err, "failed to watch "+plural)
+	}
+	return nil
+}
@@ -91,7 +91,7 @@ func (c *Client) WatchDaemonSets(namespace string, opts *k8s.WatchOptions, event
 		}
 		close(events)
 	}()
-	_, err := c.doWatch("GET", daemonsetGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
+	_, err := c.doWatch(watchOptionsContext(opts), "GET", daemonsetGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
 	if err != nil {
 		return errors.Wrap(err, "failed to watch DaemonSets")
 	}
@@ -91,7 +91,7 @@ func (c *Client) WatchEndpoints(namespace string, opts *k8s.WatchOptions, events
 		}
 		close(events)
 	}()
-	_, err := c.doWatch("GET", endpointsGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
+	_, err := c.doWatch(watchOptionsContext(opts), "GET", endpointsGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
 	if err != nil {
 		return errors.Wrap(err, "failed to watch Endpointss")
 	}
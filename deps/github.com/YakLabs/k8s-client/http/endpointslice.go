@@ -0,0 +1,121 @@
+package http
+
+import (
+	k8s "github.com/YakLabs/k8s-client"
+	"github.com/pkg/errors"
+)
+
+type (
+	watchEventEndpointSlice struct {
+		raw    k8s.WatchEvent
+		object *k8s.EndpointSlice
+	}
+)
+
+func (w *watchEventEndpointSlice) Type() k8s.WatchEventType {
+	return w.raw.Type
+}
+
+func (w *watchEventEndpointSlice) Object() (*k8s.EndpointSlice, error) {
+	if w.object != nil {
+		return w.object, nil
+	}
+	if w.raw.Type == k8s.WatchEventTypeError {
+		var status k8s.Status
+		if err := w.raw.UnmarshalObject(&status); err != nil {
+			return nil, errors.Wrap(err, "failed to decode Status")
+		}
+		return nil, &status
+	}
+	var object k8s.EndpointSlice
+	if err := w.raw.UnmarshalObject(&object); err != nil {
+		return nil, errors.Wrap(err, "failed to decode EndpointSlice")
+	}
+	w.object = &object
+	return &object, nil
+}
+
+func endpointsliceGeneratePath(namespace, name string) string {
+	if namespace == "" && name == "" {
+		return "/apis/discovery.k8s.io/v1/endpointslices"
+	}
+	if name == "" {
+		return "/apis/discovery.k8s.io/v1/namespaces/" + namespace + "/endpointslices"
+	}
+	return "/apis/discovery.k8s.io/v1/namespaces/" + namespace + "/endpointslices/" + name
+}
+
+// GetEndpointSlice fetches a single EndpointSlice
+func (c *Client) GetEndpointSlice(namespace, name string) (*k8s.EndpointSlice, error) {
+	var out k8s.EndpointSlice
+	_, err := c.do("GET", endpointsliceGeneratePath(namespace, name), nil, &out)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get EndpointSlice")
+	}
+	return &out, nil
+}
+
+// CreateEndpointSlice creates a new EndpointSlice. This will fail if it already exists.
+func (c *Client) CreateEndpointSlice(namespace string, item *k8s.EndpointSlice) (*k8s.EndpointSlice, error) {
+	item.TypeMeta.Kind = "EndpointSlice"
+	item.TypeMeta.APIVersion = "discovery.k8s.io/v1"
+	item.ObjectMeta.Namespace = namespace
+
+	var out k8s.EndpointSlice
+	_, err := c.do("POST", endpointsliceGeneratePath(namespace, ""), item, &out, 201)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create EndpointSlice")
+	}
+	return &out, nil
+}
+
+// ListEndpointSlices lists all EndpointSlices in a namespace
+func (c *Client) ListEndpointSlices(namespace string, opts *k8s.ListOptions) (*k8s.EndpointSliceList, error) {
+	var out k8s.EndpointSliceList
+	_, err := c.do("GET", endpointsliceGeneratePath(namespace, "")+"?"+listOptionsQuery(opts, nil), nil, &out)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list EndpointSlices")
+	}
+	return &out, nil
+}
+
+// WatchEndpointSlices watches all EndpointSlice changes in a namespace
+func (c *Client) WatchEndpointSlices(namespace string, opts *k8s.WatchOptions, events chan k8s.EndpointSliceWatchEvent) error {
+	if events == nil {
+		return errors.New("events must not be nil")
+	}
+	rawEvents := make(chan k8s.WatchEvent)
+	go func() {
+		for rawEvent := range rawEvents {
+			events <- &watchEventEndpointSlice{raw: rawEvent}
+		}
+		close(events)
+	}()
+	_, err := c.doWatch(watchOptionsContext(opts), "GET", endpointsliceGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
+	if err != nil {
+		return errors.Wrap(err, "failed to watch EndpointSlices")
+	}
+	return nil
+}
+
+// DeleteEndpointSlice deletes a single EndpointSlice. It will error if the EndpointSlice does not exist.
+func (c *Client) DeleteEndpointSlice(namespace, name string) error {
+	_, err := c.do("DELETE", endpointsliceGeneratePath(namespace, name), nil, nil)
+	return errors.Wrap(err, "failed to delete EndpointSlice")
+}
+
+// UpdateEndpointSlice will update in place a single EndpointSlice. Generally, you should call
+// Get and then use that object for updates to ensure resource versions
+// avoid update conflicts
+func (c *Client) UpdateEndpointSlice(namespace string, item *k8s.EndpointSlice) (*k8s.EndpointSlice, error) {
+	item.TypeMeta.Kind = "EndpointSlice"
+	item.TypeMeta.APIVersion = "discovery.k8s.io/v1"
+	item.ObjectMeta.Namespace = namespace
+
+	var out k8s.EndpointSlice
+	_, err := c.do("PUT", endpointsliceGeneratePath(namespace, item.Name), item, &out)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update EndpointSlice")
+	}
+	return &out, nil
+}
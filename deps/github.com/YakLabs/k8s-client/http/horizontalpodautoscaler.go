@@ -91,7 +91,7 @@ func (c *Client) WatchHorizontalPodAutoscalers(namespace string, opts *k8s.Watch
 		}
 		close(events)
 	}()
-	_, err := c.doWatch("GET", horizontalpodautoscalerGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
+	_, err := c.doWatch(watchOptionsContext(opts), "GET", horizontalpodautoscalerGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
 	if err != nil {
 		return errors.Wrap(err, "failed to watch HorizontalPodAutoscalers")
 	}
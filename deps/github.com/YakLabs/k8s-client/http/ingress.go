@@ -91,7 +91,7 @@ func (c *Client) WatchIngresses(namespace string, opts *k8s.WatchOptions, events
 		}
 		close(events)
 	}()
-	_, err := c.doWatch("GET", ingressGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
+	_, err := c.doWatch(watchOptionsContext(opts), "GET", ingressGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
 	if err != nil {
 		return errors.Wrap(err, "failed to watch Ingresss")
 	}
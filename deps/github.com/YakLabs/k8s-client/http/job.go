@@ -91,7 +91,7 @@ func (c *Client) WatchJobs(namespace string, opts *k8s.WatchOptions, events chan
 		}
 		close(events)
 	}()
-	_, err := c.doWatch("GET", jobGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
+	_, err := c.doWatch(watchOptionsContext(opts), "GET", jobGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
 	if err != nil {
 		return errors.Wrap(err, "failed to watch Jobs")
 	}
@@ -80,7 +80,7 @@ func (c *Client) WatchNamespaces(opts *k8s.WatchOptions, events chan k8s.Namespa
 		}
 		close(events)
 	}()
-	_, err := c.doWatch("GET", "/api/v1/namespaces?"+watchOptionsQuery(opts), nil, rawEvents)
+	_, err := c.doWatch(watchOptionsContext(opts), "GET", "/api/v1/namespaces?"+watchOptionsQuery(opts), nil, rawEvents)
 	if err != nil {
 		return errors.Wrap(err, "failed to watch Namespaces")
 	}
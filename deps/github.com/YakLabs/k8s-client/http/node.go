@@ -80,7 +80,7 @@ func (c *Client) WatchNodes(opts *k8s.WatchOptions, events chan k8s.NodeWatchEve
 		}
 		close(events)
 	}()
-	_, err := c.doWatch("GET", "/api/v1/nodes?"+watchOptionsQuery(opts), nil, rawEvents)
+	_, err := c.doWatch(watchOptionsContext(opts), "GET", "/api/v1/nodes?"+watchOptionsQuery(opts), nil, rawEvents)
 	if err != nil {
 		return errors.Wrap(err, "failed to watch Nodes")
 	}
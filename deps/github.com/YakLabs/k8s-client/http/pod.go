@@ -91,7 +91,7 @@ func (c *Client) WatchPods(namespace string, opts *k8s.WatchOptions, events chan
 		}
 		close(events)
 	}()
-	_, err := c.doWatch("GET", podGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
+	_, err := c.doWatch(watchOptionsContext(opts), "GET", podGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
 	if err != nil {
 		return errors.Wrap(err, "failed to watch Pods")
 	}
@@ -91,7 +91,7 @@ func (c *Client) WatchReplicaSets(namespace string, opts *k8s.WatchOptions, even
 		}
 		close(events)
 	}()
-	_, err := c.doWatch("GET", replicasetGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
+	_, err := c.doWatch(watchOptionsContext(opts), "GET", replicasetGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
 	if err != nil {
 		return errors.Wrap(err, "failed to watch ReplicaSets")
 	}
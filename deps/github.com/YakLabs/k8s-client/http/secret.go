@@ -91,7 +91,7 @@ func (c *Client) WatchSecrets(namespace string, opts *k8s.WatchOptions, events c
 		}
 		close(events)
 	}()
-	_, err := c.doWatch("GET", secretGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
+	_, err := c.doWatch(watchOptionsContext(opts), "GET", secretGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
 	if err != nil {
 		return errors.Wrap(err, "failed to watch Secrets")
 	}
@@ -91,7 +91,7 @@ func (c *Client) WatchServices(namespace string, opts *k8s.WatchOptions, events
 		}
 		close(events)
 	}()
-	_, err := c.doWatch("GET", serviceGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
+	_, err := c.doWatch(watchOptionsContext(opts), "GET", serviceGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
 	if err != nil {
 		return errors.Wrap(err, "failed to watch Services")
 	}
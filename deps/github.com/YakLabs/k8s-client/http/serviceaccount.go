@@ -91,7 +91,7 @@ func (c *Client) WatchServiceAccounts(namespace string, opts *k8s.WatchOptions,
 		}
 		close(events)
 	}()
-	_, err := c.doWatch("GET", serviceaccountGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
+	_, err := c.doWatch(watchOptionsContext(opts), "GET", serviceaccountGeneratePath(namespace, "")+"?"+watchOptionsQuery(opts), nil, rawEvents)
 	if err != nil {
 		return errors.Wrap(err, "failed to watch ServiceAccounts")
 	}
@@ -53,6 +53,10 @@ type (
 		// A list of host rules used to configure the Ingress.
 		// If unspecified, or no rule matches, all traffic is sent to the default backend.
 		Rules []IngressRule `json:"rules,omitempty"`
+
+		// The name of the IngressClass cluster resource.
+		// It is used to select the ingress controller that implements the Ingress.
+		IngressClassName *string `json:"ingressClassName,omitempty"`
 	}
 
 	// IngressBackend describes all endpoints for a given service and port.
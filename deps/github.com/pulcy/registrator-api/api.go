@@ -14,12 +14,16 @@
 
 package api
 
+import (
+	"golang.org/x/net/context"
+)
+
 type API interface {
 	// Watch for changes in the services tree and return where there is a change.
 	Watch() error
 
 	// Load all registered services
-	Services() ([]Service, error)
+	Services(ctx context.Context) ([]Service, error)
 }
 
 type Service struct {
@@ -28,7 +32,17 @@ type Service struct {
 	Instances   []ServiceInstance
 }
 
+// Well known tags of service instances
+const (
+	TagRole   = "role"   // Role of the instance (primary|replica)
+	TagHealth = "health" // Health of the instance (healthy|draining|unhealthy)
+	TagWeight = "weight" // Relative weight of the instance (0-256, 0 means draining)
+	TagZone   = "zone"   // Availability zone of the instance
+	TagNode   = "node"   // Name of the node the instance runs on
+)
+
 type ServiceInstance struct {
-	IP   string // IP address to connect to to reach the service instance
-	Port int    // Port to connect to to reach the service instance
+	IP   string            // IP address to connect to to reach the service instance
+	Port int               // Port to connect to to reach the service instance
+	Tags map[string]string // Metadata of the service instance (e.g. role=primary)
 }
@@ -15,7 +15,9 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"path"
 	"strconv"
 	"strings"
@@ -74,13 +76,13 @@ func (c *registratorClient) Watch() error {
 }
 
 // Load all registered services
-func (c *registratorClient) Services() ([]Service, error) {
+func (c *registratorClient) Services(ctx context.Context) ([]Service, error) {
 	keyAPI := client.NewKeysAPI(c.client)
 	options := &client.GetOptions{
 		Recursive: true,
 		Sort:      false,
 	}
-	resp, err := keyAPI.Get(context.Background(), c.prefix, options)
+	resp, err := keyAPI.Get(ctx, c.prefix, options)
 	if err != nil {
 		return nil, maskAny(err)
 	}
@@ -89,50 +91,97 @@ func (c *registratorClient) Services() ([]Service, error) {
 		return list, nil
 	}
 	for _, serviceNode := range resp.Node.Nodes {
-		serviceName := path.Base(serviceNode.Key)
-		partialServices := make(map[int]*Service)
-		for _, instanceNode := range serviceNode.Nodes {
-			uniqueID := path.Base(instanceNode.Key)
-			parts := strings.Split(uniqueID, ":")
-			if len(parts) < 3 {
-				c.Logger.Warning("UniqueID malformed: '%s'", uniqueID)
-				continue
-			}
-			port, err := strconv.Atoi(parts[2])
-			if err != nil {
-				c.Logger.Warning("Failed to parse port: '%s'", parts[2])
-				continue
-			}
-			instance, err := c.parseServiceInstance(instanceNode.Value)
-			if err != nil {
-				c.Logger.Warning("Failed to parse instance '%s': %#v", instanceNode.Value, err)
-				continue
-			}
-			s, ok := partialServices[port]
-			if !ok {
-				s = &Service{ServiceName: stripPortFromServiceName(serviceName, port), ServicePort: port}
-				partialServices[port] = s
-			}
-			s.Instances = append(s.Instances, instance)
-
-			// Register instance as separate service
-			instanceName := parts[1]
-			if strings.HasPrefix(instanceName, serviceName+"-") {
-				s := Service{ServiceName: instanceName, ServicePort: port}
-				s.Instances = append(s.Instances, instance)
-				list = append(list, s)
-			}
-		}
-		for _, v := range partialServices {
-			list = append(list, *v)
+		var instances []instanceNode
+		for _, node := range serviceNode.Nodes {
+			instances = append(instances, instanceNode{UniqueID: path.Base(node.Key), Value: node.Value})
 		}
+		list = append(list, parseServices(c.Logger, path.Base(serviceNode.Key), instances)...)
 	}
 
 	return list, nil
 }
 
-// parseServiceInstance parses a string in the format of "<ip>':'<port>" into a ServiceInstance.
-func (c *registratorClient) parseServiceInstance(s string) (ServiceInstance, error) {
+// instanceNode is a service instance as stored by registrator, under <service-name>/<unique-id>.
+type instanceNode struct {
+	UniqueID string // <host>:<instance-name>:<port>
+	Value    string // <ip>:<port>[?<tags>] or a JSON encoded jsonServiceInstance
+}
+
+// jsonServiceInstance is the JSON format of a service instance value.
+// Health, weight, zone & node are stored as tags of the parsed instance and
+// take precedence over tags with the same name.
+type jsonServiceInstance struct {
+	IP     string            `json:"ip"`
+	Port   int               `json:"port"`
+	Tags   map[string]string `json:"tags,omitempty"`
+	Role   string            `json:"role,omitempty"`
+	Health string            `json:"health,omitempty"`
+	Weight *int              `json:"weight,omitempty"`
+	Zone   string            `json:"zone,omitempty"`
+	Node   string            `json:"node,omitempty"`
+}
+
+// parseServices creates the services (one per port) of the given instances of the service with given name.
+func parseServices(logger *logging.Logger, serviceName string, instances []instanceNode) []Service {
+	var list []Service
+	partialServices := make(map[int]*Service)
+	for _, instanceNode := range instances {
+		uniqueID := instanceNode.UniqueID
+		parts := strings.Split(uniqueID, ":")
+		if len(parts) < 3 {
+			logger.Warning("UniqueID malformed: '%s'", uniqueID)
+			continue
+		}
+		port, err := strconv.Atoi(parts[2])
+		if err != nil {
+			logger.Warning("Failed to parse port: '%s'", parts[2])
+			continue
+		}
+		instance, err := parseServiceInstance(instanceNode.Value)
+		if err != nil {
+			logger.Warning("Failed to parse instance '%s': %#v", instanceNode.Value, err)
+			continue
+		}
+		s, ok := partialServices[port]
+		if !ok {
+			s = &Service{ServiceName: stripPortFromServiceName(serviceName, port), ServicePort: port}
+			partialServices[port] = s
+		}
+		s.Instances = append(s.Instances, instance)
+
+		// Register instance as separate service
+		instanceName := parts[1]
+		if strings.HasPrefix(instanceName, serviceName+"-") {
+			s := Service{ServiceName: instanceName, ServicePort: port}
+			s.Instances = append(s.Instances, instance)
+			list = append(list, s)
+		}
+	}
+	for _, v := range partialServices {
+		list = append(list, *v)
+	}
+	return list
+}
+
+// parseServiceInstance parses a string in the format of "<ip>':'<port>['?'<tags>]" into a ServiceInstance.
+// Tags are encoded as a query string (e.g. "10.0.0.1:5432?role=primary").
+// A string starting with '{' is parsed as a JSON encoded jsonServiceInstance.
+func parseServiceInstance(s string) (ServiceInstance, error) {
+	if strings.HasPrefix(strings.TrimSpace(s), "{") {
+		return parseJSONServiceInstance(s)
+	}
+	var tags map[string]string
+	if index := strings.Index(s, "?"); index >= 0 {
+		values, err := url.ParseQuery(s[index+1:])
+		if err != nil {
+			return ServiceInstance{}, maskAny(fmt.Errorf("Invalid service instance tags in '%s'", s))
+		}
+		tags = make(map[string]string)
+		for key := range values {
+			tags[key] = values.Get(key)
+		}
+		s = s[:index]
+	}
 	parts := strings.Split(s, ":")
 	if len(parts) != 2 {
 		return ServiceInstance{}, maskAny(fmt.Errorf("Invalid service instance '%s'", s))
@@ -144,6 +193,45 @@ func (c *registratorClient) parseServiceInstance(s string) (ServiceInstance, err
 	return ServiceInstance{
 		IP:   parts[0],
 		Port: port,
+		Tags: tags,
+	}, nil
+}
+
+// parseJSONServiceInstance parses a JSON encoded jsonServiceInstance into a ServiceInstance.
+func parseJSONServiceInstance(s string) (ServiceInstance, error) {
+	var raw jsonServiceInstance
+	if err := json.Unmarshal([]byte(s), &raw); err != nil {
+		return ServiceInstance{}, maskAny(fmt.Errorf("Invalid service instance '%s': %v", s, err))
+	}
+	if raw.IP == "" {
+		return ServiceInstance{}, maskAny(fmt.Errorf("Missing IP in service instance '%s'", s))
+	}
+	if raw.Port <= 0 || raw.Port > 65535 {
+		return ServiceInstance{}, maskAny(fmt.Errorf("Invalid service instance port %d in '%s'", raw.Port, s))
+	}
+	tags := make(map[string]string)
+	for key, value := range raw.Tags {
+		tags[key] = value
+	}
+	setTag := func(key, value string) {
+		if value != "" {
+			tags[key] = value
+		}
+	}
+	setTag(TagRole, raw.Role)
+	setTag(TagHealth, raw.Health)
+	if raw.Weight != nil {
+		setTag(TagWeight, strconv.Itoa(*raw.Weight))
+	}
+	setTag(TagZone, raw.Zone)
+	setTag(TagNode, raw.Node)
+	if len(tags) == 0 {
+		tags = nil
+	}
+	return ServiceInstance{
+		IP:   raw.IP,
+		Port: raw.Port,
+		Tags: tags,
 	}, nil
 }
 
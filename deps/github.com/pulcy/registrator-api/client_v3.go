@@ -0,0 +1,107 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/juju/errgo"
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+)
+
+var (
+	watchClosedError = errgo.New("watch closed")
+)
+
+type registratorClientV3 struct {
+	client      *clientv3.Client
+	watchChan   clientv3.WatchChan
+	watchCancel context.CancelFunc
+	Logger      *logging.Logger
+	prefix      string
+}
+
+// NewRegistratorClientV3 creates an API that reads the services registered by registrator
+// using the ETCD v3 API.
+func NewRegistratorClientV3(etcdClient *clientv3.Client, etcdPath string, logger *logging.Logger) (API, error) {
+	if etcdPath == "" {
+		etcdPath = DefaultEtcdPath
+	}
+	if logger == nil {
+		logger = logging.MustGetLogger("registrator-api")
+	}
+	return &registratorClientV3{
+		client: etcdClient,
+		prefix: strings.TrimSuffix(etcdPath, "/") + "/",
+		Logger: logger,
+	}, nil
+}
+
+// Watch for changes in the services tree and return where there is a change.
+func (c *registratorClientV3) Watch() error {
+	if c.watchChan == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		c.watchChan = c.client.Watch(ctx, c.prefix, clientv3.WithPrefix())
+		c.watchCancel = cancel
+	}
+	resp, ok := <-c.watchChan
+	if !ok {
+		c.resetWatch()
+		return maskAny(watchClosedError)
+	}
+	if err := resp.Err(); err != nil {
+		c.resetWatch()
+		return maskAny(err)
+	}
+	return nil
+}
+
+// resetWatch cancels the current watch, so the next call to Watch starts a new one.
+func (c *registratorClientV3) resetWatch() {
+	if c.watchCancel != nil {
+		c.watchCancel()
+	}
+	c.watchChan = nil
+	c.watchCancel = nil
+}
+
+// Load all registered services
+func (c *registratorClientV3) Services(ctx context.Context) ([]Service, error) {
+	resp, err := c.client.Get(ctx, c.prefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	// Keys are formatted as <prefix>/<service-name>/<unique-id>
+	var serviceNames []string
+	instances := make(map[string][]instanceNode)
+	for _, kv := range resp.Kvs {
+		parts := strings.Split(strings.TrimPrefix(string(kv.Key), c.prefix), "/")
+		if len(parts) != 2 {
+			continue
+		}
+		serviceName := parts[0]
+		if _, ok := instances[serviceName]; !ok {
+			serviceNames = append(serviceNames, serviceName)
+		}
+		instances[serviceName] = append(instances[serviceName], instanceNode{UniqueID: parts[1], Value: string(kv.Value)})
+	}
+	var list []Service
+	for _, serviceName := range serviceNames {
+		list = append(list, parseServices(c.Logger, serviceName, instances[serviceName])...)
+	}
+	return list, nil
+}
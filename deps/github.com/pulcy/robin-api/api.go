@@ -31,3 +31,68 @@ type API interface {
 	// If the ID is not found, an IDNotFoundError is returned.
 	Get(id string) (FrontendRecord, error)
 }
+
+// VersionedAPI is implemented by API's that support optimistic concurrency control
+// using the version (ETag) of frontend records.
+type VersionedAPI interface {
+	// GetVersioned returns the frontend record for the given id and its current version.
+	// If the ID is not found, an IDNotFoundError is returned.
+	GetVersioned(id string) (FrontendRecord, string, error)
+
+	// Update replaces the frontend record with given ID.
+	// If the ID is not found, an IDNotFoundError is returned.
+	// If version is not empty and does not match the current version, a VersionMismatchError is returned.
+	Update(id string, record FrontendRecord, version string) error
+
+	// RemoveVersioned removes the frontend with given ID.
+	// If the ID is not found, an IDNotFoundError is returned.
+	// If version is not empty and does not match the current version, a VersionMismatchError is returned.
+	RemoveVersioned(id string, version string) error
+}
+
+// TemplateAPI is implemented by API's that support frontend record templates.
+// A template is a frontend record in which string values can contain variables (`{{.name}}`).
+type TemplateAPI interface {
+	// AddTemplate adds or replaces the template with given name.
+	AddTemplate(name string, tmpl FrontendRecord) error
+
+	// RemoveTemplate removes the template with given name.
+	// If the name is not found, an IDNotFoundError is returned.
+	RemoveTemplate(name string) error
+
+	// AllTemplates returns a map of all known templates mapped by their name.
+	AllTemplates() (map[string]FrontendRecord, error)
+
+	// GetTemplate returns the template with given name.
+	// If the name is not found, an IDNotFoundError is returned.
+	GetTemplate(name string) (FrontendRecord, error)
+
+	// AddFromTemplate instantiates the requested template and adds the resulting frontend record.
+	// If the given ID already exists, a DuplicateIDError is returned.
+	AddFromTemplate(req FromTemplateRequest) (FrontendRecord, error)
+}
+
+// InstanceAPI is implemented by API's that allow services to register their instances
+// directly (instead of through registrator).
+type InstanceAPI interface {
+	// RegisterInstance registers (or refreshes the TTL of) an instance of the service with given name.
+	RegisterInstance(serviceName string, record InstanceRecord) error
+
+	// DeregisterInstance removes the instance with given ID of the service with given name.
+	// If the ID is not found, an IDNotFoundError is returned.
+	DeregisterInstance(serviceName, id string) error
+}
+
+// ScheduleAPI is implemented by API's that support changes of frontend records
+// that are applied automatically at a given time.
+type ScheduleAPI interface {
+	// ScheduleChange registers the given change and returns it (with its ID).
+	ScheduleChange(change ScheduledChange) (ScheduledChange, error)
+
+	// ScheduledChanges returns all changes that have not been applied yet, ordered by time.
+	ScheduledChanges() ([]ScheduledChange, error)
+
+	// CancelScheduledChange removes the change with given ID.
+	// If the ID is not found, an IDNotFoundError is returned.
+	CancelScheduledChange(id string) error
+}
@@ -1,23 +1,97 @@
 package api
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pulcy/rest-kit"
 )
 
+const (
+	defaultClientTimeout = 30 * time.Second // Timeout of requests of clients created without a ClientConfig
+)
+
 type client struct {
 	rc *restkit.RestClient
 }
 
 // NewClient creates a new API implementation for the given base URL.
+// The returned API also implements VersionedAPI, TemplateAPI, InstanceAPI, ScheduleAPI and ContextAPI.
 func NewClient(baseURL *url.URL) (API, error) {
 	return &client{
-		rc: restkit.NewRestClient(baseURL),
+		rc: newRestClient(baseURL),
 	}, nil
 }
 
+// NewClientWithToken creates a new API implementation for the given base URL that
+// authorizes all requests with the given (admin or tenant) API token.
+func NewClientWithToken(baseURL *url.URL, token string) (API, error) {
+	c := &client{
+		rc: newRestClient(baseURL),
+	}
+	c.setToken(token)
+	return c, nil
+}
+
+// NewClientWithConfig creates a new API implementation for the given base URL with
+// the timeout, retry, TLS & token options of the given config.
+func NewClientWithConfig(baseURL *url.URL, config ClientConfig) (API, error) {
+	httpClient, err := NewHTTPClient(config)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	rc := restkit.NewRestClient(baseURL)
+	rc.HTTPClient = httpClient
+	c := &client{
+		rc: rc,
+	}
+	if config.Token != "" {
+		c.setToken(config.Token)
+	}
+	return c, nil
+}
+
+// newRestClient creates a REST client for the given base URL whose requests time out after defaultClientTimeout.
+func newRestClient(baseURL *url.URL) *restkit.RestClient {
+	rc := restkit.NewRestClient(baseURL)
+	rc.HTTPClient = &http.Client{Timeout: defaultClientTimeout}
+	return rc
+}
+
+// WithContext returns a copy of the client whose requests are canceled when the given context is done.
+func (c *client) WithContext(ctx context.Context) API {
+	rc := *c.rc
+	buildRequest := c.rc.RequestBuilder
+	rc.RequestBuilder = func(method, path string, query url.Values, reqBody interface{}) (*http.Request, error) {
+		req, err := buildRequest(method, path, query, reqBody)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		return req.WithContext(ctx), nil
+	}
+	return &client{
+		rc: &rc,
+	}
+}
+
+// setToken authorizes all requests with the given token.
+func (c *client) setToken(token string) {
+	buildRequest := c.rc.RequestBuilder
+	c.rc.RequestBuilder = func(method, path string, query url.Values, reqBody interface{}) (*http.Request, error) {
+		req, err := buildRequest(method, path, query, reqBody)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req, nil
+	}
+}
+
 // Add adds a given frontend record with given ID to the list of frontends.
 // If the given ID already exists, a DuplicateIDError is returned.
 func (c *client) Add(id string, record FrontendRecord) error {
@@ -54,3 +128,158 @@ func (c *client) Get(id string) (FrontendRecord, error) {
 	}
 	return result, nil
 }
+
+// GetVersioned returns the frontend record for the given id and its current version.
+// If the ID is not found, an IDNotFoundError is returned.
+func (c *client) GetVersioned(id string) (FrontendRecord, string, error) {
+	var result FrontendRecord
+	resp, err := c.do("GET", fmt.Sprintf("/v1/frontend/%s", id), nil, "", &result)
+	if err != nil {
+		return FrontendRecord{}, "", maskAny(err)
+	}
+	return result, ParseETag(resp.Header.Get("ETag")), nil
+}
+
+// Update replaces the frontend record with given ID.
+// If the ID is not found, an IDNotFoundError is returned.
+// If version is not empty and does not match the current version, a VersionMismatchError is returned.
+func (c *client) Update(id string, record FrontendRecord, version string) error {
+	if _, err := c.do("PUT", fmt.Sprintf("/v1/frontend/%s", id), record, version, nil); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// RemoveVersioned removes the frontend with given ID.
+// If the ID is not found, an IDNotFoundError is returned.
+// If version is not empty and does not match the current version, a VersionMismatchError is returned.
+func (c *client) RemoveVersioned(id string, version string) error {
+	if _, err := c.do("DELETE", fmt.Sprintf("/v1/frontend/%s", id), nil, version, nil); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// AddTemplate adds or replaces the template with given name.
+func (c *client) AddTemplate(name string, tmpl FrontendRecord) error {
+	if err := c.rc.Request("POST", fmt.Sprintf("/v1/template/%s", name), nil, tmpl, nil); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// RemoveTemplate removes the template with given name.
+// If the name is not found, an IDNotFoundError is returned.
+func (c *client) RemoveTemplate(name string) error {
+	if err := c.rc.Request("DELETE", fmt.Sprintf("/v1/template/%s", name), nil, nil, nil); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// AllTemplates returns a map of all known templates mapped by their name.
+func (c *client) AllTemplates() (map[string]FrontendRecord, error) {
+	var result map[string]FrontendRecord
+	if err := c.rc.Request("GET", "/v1/template", nil, nil, &result); err != nil {
+		return nil, maskAny(err)
+	}
+	return result, nil
+}
+
+// GetTemplate returns the template with given name.
+// If the name is not found, an IDNotFoundError is returned.
+func (c *client) GetTemplate(name string) (FrontendRecord, error) {
+	var result FrontendRecord
+	if err := c.rc.Request("GET", fmt.Sprintf("/v1/template/%s", name), nil, nil, &result); err != nil {
+		return FrontendRecord{}, maskAny(err)
+	}
+	return result, nil
+}
+
+// AddFromTemplate instantiates the requested template and adds the resulting frontend record.
+// If the given ID already exists, a DuplicateIDError is returned.
+func (c *client) AddFromTemplate(req FromTemplateRequest) (FrontendRecord, error) {
+	var result FrontendRecord
+	if err := c.rc.Request("POST", "/v1/frontend/from-template", nil, req, &result); err != nil {
+		return FrontendRecord{}, maskAny(err)
+	}
+	return result, nil
+}
+
+// RegisterInstance registers (or refreshes the TTL of) an instance of the service with given name.
+func (c *client) RegisterInstance(serviceName string, record InstanceRecord) error {
+	if err := c.rc.Request("POST", fmt.Sprintf("/v1/service/%s/instances", serviceName), nil, record, nil); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// DeregisterInstance removes the instance with given ID of the service with given name.
+// If the ID is not found, an IDNotFoundError is returned.
+func (c *client) DeregisterInstance(serviceName, id string) error {
+	if err := c.rc.Request("DELETE", fmt.Sprintf("/v1/service/%s/instances/%s", serviceName, id), nil, nil, nil); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// ScheduleChange registers the given change and returns it (with its ID).
+func (c *client) ScheduleChange(change ScheduledChange) (ScheduledChange, error) {
+	var result ScheduledChange
+	if err := c.rc.Request("POST", "/v1/schedule", nil, change, &result); err != nil {
+		return ScheduledChange{}, maskAny(err)
+	}
+	return result, nil
+}
+
+// ScheduledChanges returns all changes that have not been applied yet, ordered by time.
+func (c *client) ScheduledChanges() ([]ScheduledChange, error) {
+	var result []ScheduledChange
+	if err := c.rc.Request("GET", "/v1/schedule", nil, nil, &result); err != nil {
+		return nil, maskAny(err)
+	}
+	return result, nil
+}
+
+// CancelScheduledChange removes the change with given ID.
+// If the ID is not found, an IDNotFoundError is returned.
+func (c *client) CancelScheduledChange(id string) error {
+	if err := c.rc.Request("DELETE", fmt.Sprintf("/v1/schedule/%s", id), nil, nil, nil); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// do performs a request with an optional If-Match header.
+func (c *client) do(method, path string, reqBody interface{}, version string, result interface{}) (*http.Response, error) {
+	req, err := c.rc.RequestBuilder(method, path, nil, reqBody)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if version != "" {
+		req.Header.Set("If-Match", FormatETag(version))
+	}
+	resp, err := c.rc.Do(req)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if err := c.rc.ResponseParser(resp, result); err != nil {
+		return nil, maskAny(err)
+	}
+	return resp, nil
+}
+
+// FormatETag converts a version into an ETag header value.
+func FormatETag(version string) string {
+	return strconv.Quote(version)
+}
+
+// ParseETag converts an ETag (or If-Match) header value into a version.
+// It returns an empty string for an empty value or '*'.
+func ParseETag(value string) string {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "W/")
+	if value == "*" {
+		return ""
+	}
+	return strings.Trim(value, `"`)
+}
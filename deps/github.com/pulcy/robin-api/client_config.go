@@ -0,0 +1,119 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultRetryBackoff    = 500 * time.Millisecond
+	defaultMaxRetryBackoff = 10 * time.Second
+)
+
+// ClientConfig holds the options of a client created by NewClientWithConfig.
+type ClientConfig struct {
+	Token           string        // If set, all requests are authorized with this (admin or tenant) API token
+	Timeout         time.Duration // Timeout of a request, including its retries (0 means none)
+	MaxRetries      int           // Number of retries of requests that fail with a connection error or 5xx status
+	RetryBackoff    time.Duration // Delay before the first retry, doubled for every next retry (0 means 500ms)
+	MaxRetryBackoff time.Duration // Maximum delay between retries (0 means 10s)
+
+	CACertFile         string // If set, the server certificate must be signed by a CA in this PEM file
+	CertFile           string // If set, this client certificate (PEM) is sent to the server (requires KeyFile)
+	KeyFile            string // Private key (PEM) of CertFile
+	InsecureSkipVerify bool   // If set, the server certificate is not verified
+}
+
+// ContextAPI is implemented by clients of the API that can bind their requests to a context.
+type ContextAPI interface {
+	// WithContext returns a copy of the client whose requests are canceled when the given context is done.
+	WithContext(ctx context.Context) API
+}
+
+// NewHTTPClient creates an HTTP client with the timeout, retry & TLS options of the given config.
+func NewHTTPClient(config ClientConfig) (*http.Client, error) {
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   10 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	var rt http.RoundTripper = transport
+	if config.MaxRetries > 0 {
+		rt = &retryTransport{
+			transport:  transport,
+			maxRetries: config.MaxRetries,
+			backoff:    durationOrDefault(config.RetryBackoff, defaultRetryBackoff),
+			maxBackoff: durationOrDefault(config.MaxRetryBackoff, defaultMaxRetryBackoff),
+		}
+	}
+	return &http.Client{
+		Transport: rt,
+		Timeout:   config.Timeout,
+	}, nil
+}
+
+// tlsConfig creates the TLS configuration of the client (nil if no TLS option is set).
+func (config ClientConfig) tlsConfig() (*tls.Config, error) {
+	if config.CACertFile == "" && config.CertFile == "" && config.KeyFile == "" && !config.InsecureSkipVerify {
+		return nil, nil
+	}
+	result := &tls.Config{
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+	if config.CACertFile != "" {
+		pem, err := ioutil.ReadFile(config.CACertFile)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, maskAny(fmt.Errorf("no certificates found in %s", config.CACertFile))
+		}
+		result.RootCAs = pool
+	}
+	if config.CertFile != "" || config.KeyFile != "" {
+		if config.CertFile == "" || config.KeyFile == "" {
+			return nil, maskAny(fmt.Errorf("client certificate and key must both be set"))
+		}
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		result.Certificates = []tls.Certificate{cert}
+	}
+	return result, nil
+}
+
+func durationOrDefault(d, defaultValue time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return defaultValue
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// retryTransport retries requests that fail with a connection error or a 5xx status, with exponential backoff.
+// Requests that may not be repeated safely (POST) are only retried when no connection could be made.
+type retryTransport struct {
+	transport  http.RoundTripper
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Buffer the body, so every attempt can send it again.
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	backoff := t.backoff
+	for attempt := 0; ; attempt++ {
+		attemptReq, err := newAttempt(req, body)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := t.transport.RoundTrip(attemptReq)
+		if attempt >= t.maxRetries || !t.shouldRetry(req, resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		backoff *= 2
+		if backoff > t.maxBackoff {
+			backoff = t.maxBackoff
+		}
+	}
+}
+
+// newAttempt creates a copy of the given request that sends the given (buffered) body.
+func newAttempt(req *http.Request, body []byte) (*http.Request, error) {
+	var attemptReq *http.Request
+	var err error
+	if req.Body == nil {
+		attemptReq, err = http.NewRequest(req.Method, req.URL.String(), nil)
+	} else {
+		attemptReq, err = http.NewRequest(req.Method, req.URL.String(), bytes.NewReader(body))
+	}
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range req.Header {
+		attemptReq.Header[k] = v
+	}
+	attemptReq.Host = req.Host
+	return attemptReq.WithContext(req.Context()), nil
+}
+
+// shouldRetry returns true if the given request must be retried after the given result.
+func (t *retryTransport) shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		if req.Context().Err() != nil {
+			return false
+		}
+		if isIdempotent(req.Method) {
+			return true
+		}
+		return isDialError(err)
+	}
+	return resp.StatusCode >= 500 && isIdempotent(req.Method)
+}
+
+// isDialError returns true if the given error indicates that no connection could be made.
+func isDialError(err error) bool {
+	for {
+		switch e := err.(type) {
+		case *url.Error:
+			err = e.Err
+		case *net.OpError:
+			return e.Op == "dial"
+		default:
+			return false
+		}
+	}
+}
+
+// isIdempotent returns true if requests with the given method can safely be repeated.
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
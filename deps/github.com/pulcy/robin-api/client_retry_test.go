@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTransport returns the next of its results for every request and records the request bodies.
+type fakeTransport struct {
+	results      []fakeResult
+	bodies       []string
+	contentTypes []string
+}
+
+type fakeResult struct {
+	status int
+	err    error
+}
+
+func (t *fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := ""
+	if req.Body != nil {
+		raw, _ := ioutil.ReadAll(req.Body)
+		body = string(raw)
+	}
+	t.bodies = append(t.bodies, body)
+	t.contentTypes = append(t.contentTypes, req.Header.Get("Content-Type"))
+	r := t.results[0]
+	if len(t.results) > 1 {
+		t.results = t.results[1:]
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &http.Response{StatusCode: r.status, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+}
+
+var (
+	dialError  = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	resetError = &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset")}
+)
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		Method   string
+		Results  []fakeResult
+		Attempts int
+		Status   int // 0 means an error is expected
+	}{
+		{"GET", []fakeResult{{status: 200}}, 1, 200},
+		{"GET", []fakeResult{{status: 503}, {status: 502}, {status: 200}}, 3, 200},
+		{"GET", []fakeResult{{status: 503}}, 4, 503}, // 1 attempt + 3 retries
+		{"GET", []fakeResult{{err: resetError}, {status: 200}}, 2, 200},
+		{"GET", []fakeResult{{status: 404}}, 1, 404},
+		{"PUT", []fakeResult{{status: 500}, {status: 200}}, 2, 200},
+		{"POST", []fakeResult{{status: 500}}, 1, 500},
+		{"POST", []fakeResult{{err: resetError}}, 1, 0},
+		{"POST", []fakeResult{{err: dialError}, {status: 201}}, 2, 201},
+	}
+	for _, test := range tests {
+		ft := &fakeTransport{results: test.Results}
+		rt := &retryTransport{transport: ft, maxRetries: 3, backoff: time.Millisecond, maxBackoff: 2 * time.Millisecond}
+		req, _ := http.NewRequest(test.Method, "http://robin/v1/frontend/web", strings.NewReader("body"))
+		resp, err := rt.RoundTrip(req)
+		if len(ft.bodies) != test.Attempts {
+			t.Errorf("%s %v: expected %d attempts, got %d", test.Method, test.Results, test.Attempts, len(ft.bodies))
+		}
+		for _, body := range ft.bodies {
+			if body != "body" {
+				t.Errorf("%s %v: expected every attempt to send the body, got '%s'", test.Method, test.Results, body)
+			}
+		}
+		if test.Status == 0 {
+			if err == nil {
+				t.Errorf("%s %v: expected error, got status %d", test.Method, test.Results, resp.StatusCode)
+			}
+		} else if err != nil || resp.StatusCode != test.Status {
+			t.Errorf("%s %v: expected status %d, got %v (%v)", test.Method, test.Results, test.Status, resp, err)
+		}
+	}
+}
+
+func TestRetryTransportBufferedBody(t *testing.T) {
+	ft := &fakeTransport{results: []fakeResult{{status: 503}, {status: 200}}}
+	rt := &retryTransport{transport: ft, maxRetries: 3, backoff: time.Millisecond, maxBackoff: time.Millisecond}
+	req, _ := http.NewRequest("PUT", "http://robin/v1/frontend/web", ioutil.NopCloser(strings.NewReader("body")))
+	req.Header.Set("Content-Type", "application/json")
+	// The body can only be read once, so it is buffered and sent again on the retry
+	resp, err := rt.RoundTrip(req)
+	if err != nil || resp.StatusCode != 200 || len(ft.bodies) != 2 {
+		t.Fatalf("Expected 2 attempts with status 200, got %d attempts, %v (%v)", len(ft.bodies), resp, err)
+	}
+	for _, body := range ft.bodies {
+		if body != "body" {
+			t.Errorf("Expected every attempt to send the body, got '%s'", body)
+		}
+	}
+	for _, contentType := range ft.contentTypes {
+		if contentType != "application/json" {
+			t.Errorf("Expected every attempt to send the headers, got '%s'", contentType)
+		}
+	}
+}
+
+func TestIsDialError(t *testing.T) {
+	tests := []struct {
+		Err      error
+		Expected bool
+	}{
+		{dialError, true},
+		{resetError, false},
+		{&url.Error{Op: "Post", URL: "http://robin", Err: dialError}, true},
+		{&url.Error{Op: "Post", URL: "http://robin", Err: resetError}, false},
+		{errors.New("boom"), false},
+	}
+	for _, test := range tests {
+		if result := isDialError(test.Err); result != test.Expected {
+			t.Errorf("Expected %v for %v, got %v", test.Expected, test.Err, result)
+		}
+	}
+}
+
+func TestRetryTransportBackoff(t *testing.T) {
+	ft := &fakeTransport{results: []fakeResult{{status: 503}}}
+	rt := &retryTransport{transport: ft, maxRetries: 4, backoff: 10 * time.Millisecond, maxBackoff: 20 * time.Millisecond}
+	req, _ := http.NewRequest("GET", "http://robin/v1/frontend", nil)
+	start := time.Now()
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip failed: %#v", err)
+	}
+	// Delays are 10, 20, 20, 20ms
+	if elapsed := time.Since(start); elapsed < 70*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected retries to take about 70ms, took %s", elapsed)
+	}
+}
+
+func TestRetryTransportCanceled(t *testing.T) {
+	ft := &fakeTransport{results: []fakeResult{{status: 503}}}
+	rt := &retryTransport{transport: ft, maxRetries: 10, backoff: time.Hour, maxBackoff: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", "http://robin/v1/frontend", nil)
+	if _, err := rt.RoundTrip(req.WithContext(ctx)); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %#v", err)
+	}
+	if len(ft.bodies) != 1 {
+		t.Errorf("Expected 1 attempt, got %d", len(ft.bodies))
+	}
+}
@@ -0,0 +1,18 @@
+package api
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNewClientTimeout(t *testing.T) {
+	baseURL, _ := url.Parse("http://localhost:8056")
+	c1, _ := NewClient(baseURL)
+	c2, _ := NewClientWithToken(baseURL, "token")
+	for _, c := range []API{c1, c2} {
+		httpClient := c.(*client).rc.HTTPClient
+		if httpClient == nil || httpClient.Timeout != defaultClientTimeout {
+			t.Errorf("Expected HTTP client with timeout %s, got %#v", defaultClientTimeout, httpClient)
+		}
+	}
+}
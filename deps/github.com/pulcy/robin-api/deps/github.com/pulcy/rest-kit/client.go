@@ -28,6 +28,9 @@ import (
 type RestClient struct {
 	baseURL *url.URL
 
+	// HTTPClient is used to perform requests (nil means http.DefaultClient)
+	HTTPClient *http.Client
+
 	RequestBuilder func(method, path string, query url.Values, reqBody interface{}) (*http.Request, error)
 	ResultParser   func(resp *http.Response, body []byte, result interface{}) error
 	ErrorParser    func(resp *http.Response, body []byte) error
@@ -56,7 +59,7 @@ func (c *RestClient) Request(method, path string, query url.Values, reqBody inte
 	if err != nil {
 		return maskAny(err)
 	}
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.Do(req)
 	if err != nil {
 		return maskAny(err)
 	}
@@ -67,6 +70,14 @@ func (c *RestClient) Request(method, path string, query url.Values, reqBody inte
 	return nil
 }
 
+// Do sends the given request using the HTTPClient of the client.
+func (c *RestClient) Do(req *http.Request) (*http.Response, error) {
+	if c.HTTPClient != nil {
+		return c.HTTPClient.Do(req)
+	}
+	return http.DefaultClient.Do(req)
+}
+
 // DefaultRequestBuilder implements the default RequestBuilder behavior.
 func (c *RestClient) DefaultRequestBuilder(method, path string, query url.Values, reqBody interface{}) (*http.Request, error) {
 	url := *c.baseURL
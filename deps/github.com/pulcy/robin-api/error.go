@@ -20,14 +20,20 @@ import (
 )
 
 const (
-	codeDuplicateID = 1
-	codeValidation  = 2
+	codeDuplicateID     = 1
+	codeValidation      = 2
+	codeVersionMismatch = 3
+	codePortConflict    = 4
 )
 
 var (
 	IDNotFoundError  = restkit.NotFoundError("not found", 0)
 	DuplicateIDError = restkit.BadRequestError("duplicate ID", codeDuplicateID)
 	ValidationError  = restkit.BadRequestError("validation", codeValidation)
+	// VersionMismatchError is returned when the given version (ETag) does not match the current version.
+	VersionMismatchError = restkit.PreconditionFailedError("version mismatch", codeVersionMismatch)
+	// PortConflictError is returned when a frontend claims an edge port that is used with another mode or visibility.
+	PortConflictError = restkit.BadRequestError("edge port conflict", codePortConflict)
 
 	maskAny = errgo.MaskFunc(errgo.Any)
 )
@@ -46,3 +52,13 @@ func IsDuplicateID(err error) bool {
 func IsValidation(err error) bool {
 	return restkit.IsStatusBadRequest(err) && restkit.IsErrorResponseWithCode(err, codeValidation)
 }
+
+// IsVersionMismatch returns true if the cause of the given error is VersionMismatchError.
+func IsVersionMismatch(err error) bool {
+	return restkit.IsStatusPreconditionFailed(err) && restkit.IsErrorResponseWithCode(err, codeVersionMismatch)
+}
+
+// IsPortConflict returns true if the cause of the given error is PortConflictError.
+func IsPortConflict(err error) bool {
+	return restkit.IsStatusBadRequest(err) && restkit.IsErrorResponseWithCode(err, codePortConflict)
+}
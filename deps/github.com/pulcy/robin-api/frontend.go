@@ -14,20 +14,87 @@
 
 package api
 
-import "github.com/juju/errgo"
+import (
+	"net"
+	"strconv"
+
+	"github.com/juju/errgo"
+)
 
 const (
 	maxPort = 64 * 1024
+	// MaxInstanceWeight is the highest relative weight of an instance.
+	MaxInstanceWeight = 256
+
+	// RolePrimary is the role of the instance that handles writes.
+	RolePrimary = "primary"
+	// RoleReplica is the role of instances that handle reads only.
+	RoleReplica = "replica"
+
+	// TrapActionTarpit holds requests for a trap path for a while, then refuses them.
+	TrapActionTarpit = "tarpit"
+	// TrapActionBan bans the source IP of requests for a trap path.
+	TrapActionBan = "ban"
+
+	// ConnectionModeKeepAlive keeps connections to clients & servers open between requests.
+	ConnectionModeKeepAlive = "keep-alive"
+	// ConnectionModeServerClose keeps connections to clients open, but closes connections to servers after each response (default).
+	ConnectionModeServerClose = "server-close"
+	// ConnectionModeClose closes connections to clients & servers after each response.
+	ConnectionModeClose = "close"
+	// ConnectionModeTunnel only processes the first request of a connection, the rest is forwarded as is.
+	ConnectionModeTunnel = "tunnel"
 )
 
 type FrontendRecord struct {
-	Selectors       []FrontendSelectorRecord `json:"selectors"`
-	Service         string                   `json:"service,omitempty"`
-	Mode            string                   `json:"mode,omitempty"` // http|tcp
-	HttpCheckPath   string                   `json:"http-check-path,omitempty"`
-	HttpCheckMethod string                   `json:"http-check-method,omitempty"`
-	Sticky          bool                     `json:"sticky,omitempty"`
-	Backup          bool                     `json:"backup,omitempty"`
+	Selectors          []FrontendSelectorRecord `json:"selectors"`
+	Service            string                   `json:"service,omitempty"`
+	Mode               string                   `json:"mode,omitempty"` // http|tcp|mail
+	HttpCheckPath      string                   `json:"http-check-path,omitempty"`
+	HttpCheckMethod    string                   `json:"http-check-method,omitempty"`
+	HttpCheckHost      string                   `json:"http-check-host,omitempty"`     // Host header of health checks
+	HttpCheckPort      int                      `json:"http-check-port,omitempty"`     // Port used for health checks (defaults to the service port)
+	HttpCheckInterval  string                   `json:"http-check-interval,omitempty"` // Interval between health checks (e.g. 5s)
+	TcpCheck           string                   `json:"tcp-check,omitempty"`           // Protocol level health check of tcp services (mysql|redis|pgsql)
+	TcpCheckUser       string                   `json:"tcp-check-user,omitempty"`      // User used by mysql & pgsql health checks
+	Sticky             bool                     `json:"sticky,omitempty"`
+	HashOn             string                   `json:"hash-on,omitempty"`             // If set, requests are balanced on a consistent hash of this key (path|header:<name>|url-param:<name>|cookie:<name>|jwt-claim:<name>, http mode only)
+	HashBalanceFactor  int                      `json:"hash-balance-factor,omitempty"` // If set, no server gets more than this percentage of the average load (bounded load, at least 100)
+	Backup             bool                     `json:"backup,omitempty"`
+	SendProxy          bool                     `json:"send-proxy,omitempty"`           // If set, connections to the servers start with a PROXY protocol header (tcp & mail mode only)
+	Grpc               bool                     `json:"grpc,omitempty"`                 // If set, the service speaks gRPC (HTTP/2 end-to-end, http mode only)
+	BackupInstances    []string                 `json:"backup-instances,omitempty"`     // Instances (ip or ip:port) that are backup only servers
+	StaticInstances    []string                 `json:"static-instances,omitempty"`     // Additional instances (ip or ip:port) that are merged with the discovered instances
+	InstanceWeights    map[string]int           `json:"instance-weights,omitempty"`     // Relative weight (0-256, 0 means draining) per instance (ip or ip:port)
+	ExternalURL        string                   `json:"external-url,omitempty"`         // If set, requests are forwarded to this external URL (http|https://host[:port]) instead of discovered instances
+	Filters            []string                 `json:"filters,omitempty"`              // Names of SPOE agents (configured on the load-balancer) that requests & responses are passed through, in order (http mode only)
+	LuaActions         []string                 `json:"lua-actions,omitempty"`          // Names of Lua actions (registered by scripts loaded by the load-balancer) that are applied to requests, in order (http mode only)
+	Blocklists         []string                 `json:"blocklists,omitempty"`           // Names of IP blocklists (configured on the load-balancer) whose source IPs are refused
+	TrapPaths          []string                 `json:"trap-paths,omitempty"`           // Paths (prefixes) that are never requested legitimately, e.g. /admin.php (http mode only)
+	TrapAction         string                   `json:"trap-action,omitempty"`          // Action taken on requests for a trap path: tarpit (default) or ban (the source IP)
+	BodyScan           *BodyScanRecord          `json:"body-scan,omitempty"`            // If set, request bodies are scanned (e.g. by an ICAP server) before they are forwarded (http mode only)
+	ConnectionMode     string                   `json:"connection-mode,omitempty"`      // How HTTP connections are handled: keep-alive|server-close (default)|close|tunnel (http mode only)
+	ExposeSensitive    bool                     `json:"expose-sensitive,omitempty"`     // If set, sensitive paths (e.g. /metrics) are not protected by the load-balancer (http mode only)
+	Split              []SplitRecord            `json:"split,omitempty"`                // If set, this percentage of the traffic is sent to other services (the remainder goes to this service)
+	AllBackups         bool                     `json:"all-backups,omitempty"`          // If set, all backup servers are used at once (instead of the first one)
+	MinActive          int                      `json:"min-active,omitempty"`           // If set, backups are promoted when fewer than this number of primary servers are up
+	MinInstances       int                      `json:"min-instances,omitempty"`        // If set, a maintenance page is served when fewer than this number of healthy instances are available
+	MaxConn            int                      `json:"max-conn,omitempty"`             // If set, the maximum number of concurrent connections per server (others are queued)
+	FullConn           int                      `json:"full-conn,omitempty"`            // If set, the number of concurrent connections at which the backend is considered full
+	QueueTimeout       string                   `json:"queue-timeout,omitempty"`        // If set, the maximum time a request is queued waiting for a server (e.g. 10s)
+	MetadataHeaders    map[string]string        `json:"metadata-headers,omitempty"`     // Response headers (value) set to the metadata (key) of the instance that served the request (http mode only)
+	ZoneAware          bool                     `json:"zone-aware,omitempty"`           // If set, instances in the zone of the load-balancer are preferred, others are used as backup
+	AgentCheckPort     int                      `json:"agent-check-port,omitempty"`     // If set, servers report their state & weight through an agent on this port
+	AgentCheckInterval string                   `json:"agent-check-interval,omitempty"` // Interval between agent checks (e.g. 5s)
+	ProbeType          string                   `json:"probe-type,omitempty"`           // If set, Robin itself probes all instances (http|tcp)
+	ProbePath          string                   `json:"probe-path,omitempty"`           // Path of HTTP probes (defaults to /)
+	ProbePort          int                      `json:"probe-port,omitempty"`           // Port used for probes (defaults to the instance port)
+	ProbeInterval      string                   `json:"probe-interval,omitempty"`       // Interval between probes (e.g. 5s)
+	Private            *PrivateFrontendRecord   `json:"private,omitempty"`              // If set, the service is also registered privately, with selectors & health checks of its own
+	EdgeGroup          string                   `json:"edge-group,omitempty"`           // Name of the group of load-balancers that serve this record
+	Tenant             string                   `json:"tenant,omitempty"`               // Tenant that owns this record (set by the load-balancer, derived from where the record is stored)
+	Owner              string                   `json:"owner,omitempty"`                // Team or person responsible for this record
+	Labels             map[string]string        `json:"labels,omitempty"`               // Free-form metadata, not used by the load-balancer itself
 }
 
 // Validate checks the given object for invalid values.
@@ -36,10 +103,214 @@ func (r FrontendRecord) Validate() error {
 		return maskAny(errgo.WithCausef(nil, ValidationError, "service must be set"))
 	}
 	switch r.Mode {
-	case "", "http", "tcp":
+	case "", "http", "tcp", "mail":
 	// OK
 	default:
-		return maskAny(errgo.WithCausef(nil, ValidationError, "mode must be http|tcp"))
+		return maskAny(errgo.WithCausef(nil, ValidationError, "mode must be http|tcp|mail"))
+	}
+	if err := ValidateName(r.Service); err != nil {
+		return maskAny(err)
+	}
+	if r.EdgeGroup != "" {
+		if err := ValidateName(r.EdgeGroup); err != nil {
+			return maskAny(err)
+		}
+	}
+	if r.Tenant != "" {
+		if err := ValidateName(r.Tenant); err != nil {
+			return maskAny(err)
+		}
+	}
+	if err := validateHttpCheck(r.HttpCheckPath, r.HttpCheckMethod); err != nil {
+		return maskAny(err)
+	}
+	if r.HttpCheckHost != "" {
+		if err := ValidateDomain(r.HttpCheckHost); err != nil {
+			return maskAny(err)
+		}
+	}
+	if r.HttpCheckPort < 0 || r.HttpCheckPort > maxPort {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "http-check-port must be between 0-%d", maxPort))
+	}
+	if r.HttpCheckInterval != "" {
+		if err := validateInterval(r.HttpCheckInterval); err != nil {
+			return maskAny(err)
+		}
+	}
+	if r.SendProxy && r.Mode != "tcp" && r.Mode != "mail" {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "send-proxy requires mode tcp or mail"))
+	}
+	if r.HashOn != "" {
+		if r.Mode != "" && r.Mode != "http" {
+			return maskAny(errgo.WithCausef(nil, ValidationError, "hash-on requires mode http"))
+		}
+		if r.Sticky {
+			return maskAny(errgo.WithCausef(nil, ValidationError, "hash-on cannot be combined with sticky"))
+		}
+		if err := validateHashOn(r.HashOn); err != nil {
+			return maskAny(err)
+		}
+	}
+	if r.HashBalanceFactor != 0 {
+		if r.HashOn == "" {
+			return maskAny(errgo.WithCausef(nil, ValidationError, "hash-balance-factor requires hash-on"))
+		}
+		if r.HashBalanceFactor < 100 {
+			return maskAny(errgo.WithCausef(nil, ValidationError, "hash-balance-factor must be at least 100"))
+		}
+	}
+	if r.Grpc && r.Mode != "" && r.Mode != "http" {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "grpc requires mode http"))
+	}
+	if len(r.Filters) > 0 && r.Mode != "" && r.Mode != "http" {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "filters requires mode http"))
+	}
+	for _, name := range r.Filters {
+		if err := ValidateName(name); err != nil {
+			return maskAny(err)
+		}
+	}
+	if len(r.LuaActions) > 0 && r.Mode != "" && r.Mode != "http" {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "lua-actions requires mode http"))
+	}
+	for _, name := range r.LuaActions {
+		if err := validateLuaAction(name); err != nil {
+			return maskAny(err)
+		}
+	}
+	for _, name := range r.Blocklists {
+		if err := ValidateName(name); err != nil {
+			return maskAny(err)
+		}
+	}
+	if err := validateTraps(r.Mode, r.TrapPaths, r.TrapAction); err != nil {
+		return maskAny(err)
+	}
+	if r.BodyScan != nil {
+		if r.Mode != "" && r.Mode != "http" {
+			return maskAny(errgo.WithCausef(nil, ValidationError, "body-scan requires mode http"))
+		}
+		if err := r.BodyScan.Validate(); err != nil {
+			return maskAny(err)
+		}
+	}
+	switch r.ConnectionMode {
+	case "", ConnectionModeKeepAlive, ConnectionModeServerClose, ConnectionModeClose, ConnectionModeTunnel:
+	default:
+		return maskAny(errgo.WithCausef(nil, ValidationError, "connection-mode must be keep-alive|server-close|close|tunnel, got '%s'", r.ConnectionMode))
+	}
+	if r.ConnectionMode != "" && r.Mode != "" && r.Mode != "http" {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "connection-mode requires mode http"))
+	}
+	if r.ExposeSensitive && r.Mode != "" && r.Mode != "http" {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "expose-sensitive requires mode http"))
+	}
+	if len(r.MetadataHeaders) > 0 && r.Mode != "" && r.Mode != "http" {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "metadata-headers requires mode http"))
+	}
+	for key, header := range r.MetadataHeaders {
+		if err := validateMetadataHeader(key, header); err != nil {
+			return maskAny(err)
+		}
+	}
+	if r.Mode == "mail" {
+		for _, sr := range r.Selectors {
+			if sr.FrontendPort == 0 {
+				return maskAny(errgo.WithCausef(nil, ValidationError, "mode mail requires frontend-port in all selectors"))
+			}
+		}
+	}
+	if err := validateTcpCheck(r.Mode, r.TcpCheck, r.TcpCheckUser); err != nil {
+		return maskAny(err)
+	}
+	if r.AgentCheckPort < 0 || r.AgentCheckPort > maxPort {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "agent-check-port must be between 0-%d", maxPort))
+	}
+	if r.AgentCheckInterval != "" {
+		if r.AgentCheckPort == 0 {
+			return maskAny(errgo.WithCausef(nil, ValidationError, "agent-check-interval requires agent-check-port"))
+		}
+		if err := validateInterval(r.AgentCheckInterval); err != nil {
+			return maskAny(err)
+		}
+	}
+	for _, instance := range r.BackupInstances {
+		if err := validateInstance(instance); err != nil {
+			return maskAny(err)
+		}
+	}
+	for _, instance := range r.StaticInstances {
+		if err := validateInstance(instance); err != nil {
+			return maskAny(err)
+		}
+	}
+	for instance, weight := range r.InstanceWeights {
+		if err := validateInstance(instance); err != nil {
+			return maskAny(err)
+		}
+		if err := validateInstanceWeight(weight); err != nil {
+			return maskAny(err)
+		}
+	}
+	total := 0
+	for _, sr := range r.Split {
+		if err := sr.Validate(); err != nil {
+			return maskAny(err)
+		}
+		total += sr.Weight
+	}
+	if total > 100 {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "weights of split cannot exceed 100"))
+	}
+	if len(r.Split) > 0 && r.ExternalURL != "" {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "split cannot be combined with external-url"))
+	}
+	if r.ExternalURL != "" {
+		if _, _, _, err := ParseExternalURL(r.ExternalURL); err != nil {
+			return maskAny(err)
+		}
+		if len(r.StaticInstances) > 0 || len(r.BackupInstances) > 0 {
+			return maskAny(errgo.WithCausef(nil, ValidationError, "external-url cannot be combined with static-instances or backup-instances"))
+		}
+	}
+	if r.MinActive < 0 {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "min-active must be positive"))
+	}
+	if r.MinInstances < 0 {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "min-instances must be positive"))
+	}
+	if r.MaxConn < 0 {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "max-conn must be positive"))
+	}
+	if r.FullConn < 0 {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "full-conn must be positive"))
+	}
+	if r.QueueTimeout != "" {
+		if err := validateInterval(r.QueueTimeout); err != nil {
+			return maskAny(err)
+		}
+	}
+	if (r.AllBackups || r.MinActive > 0) && !r.Backup && len(r.BackupInstances) == 0 {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "all-backups and min-active require backup or backup-instances"))
+	}
+	if err := validateProbe(r.ProbeType, r.ProbePath, r.ProbePort, r.ProbeInterval); err != nil {
+		return maskAny(err)
+	}
+	if r.Owner != "" {
+		if err := validateOwner(r.Owner); err != nil {
+			return maskAny(err)
+		}
+	}
+	for key, value := range r.Labels {
+		if err := ValidateLabel(key, value); err != nil {
+			return maskAny(err)
+		}
+	}
+	if r.Private != nil {
+		private, _ := r.PrivateFrontend()
+		if err := private.Validate(); err != nil {
+			return maskAny(errgo.WithCausef(nil, ValidationError, "private: %v", err))
+		}
 	}
 	if len(r.Selectors) == 0 {
 		return maskAny(errgo.WithCausef(nil, ValidationError, "at least 1 selector must be set"))
@@ -48,20 +319,88 @@ func (r FrontendRecord) Validate() error {
 		if err := sr.Validate(); err != nil {
 			return maskAny(err)
 		}
+		if sr.Role != "" && r.Mode != "tcp" {
+			return maskAny(errgo.WithCausef(nil, ValidationError, "role requires mode tcp"))
+		}
+		if sr.Quota != nil && r.Mode != "" && r.Mode != "http" {
+			return maskAny(errgo.WithCausef(nil, ValidationError, "quota requires mode http"))
+		}
 	}
 	return nil
 }
 
+// PrivateFrontend returns the record of the private registration declared in the private section of the given record.
+// It is a copy of the given record with the selectors & health checks of that section.
+// It returns false when the record has no private section.
+func (r FrontendRecord) PrivateFrontend() (FrontendRecord, bool) {
+	if r.Private == nil {
+		return FrontendRecord{}, false
+	}
+	p := *r.Private
+	result := r
+	result.Private = nil
+	result.Selectors = make([]FrontendSelectorRecord, 0, len(p.Selectors))
+	for _, sel := range p.Selectors {
+		sel.Private = true
+		result.Selectors = append(result.Selectors, sel)
+	}
+	if p.HasHttpCheck() {
+		result.HttpCheckPath = p.HttpCheckPath
+		result.HttpCheckMethod = p.HttpCheckMethod
+		result.HttpCheckHost = p.HttpCheckHost
+		result.HttpCheckPort = p.HttpCheckPort
+		result.HttpCheckInterval = p.HttpCheckInterval
+	}
+	if p.TcpCheck != "" {
+		result.TcpCheck = p.TcpCheck
+		result.TcpCheckUser = p.TcpCheckUser
+	}
+	return result, true
+}
+
+// AllSelectors returns the selectors of the given record, followed by those of its private section (if any).
+func (r FrontendRecord) AllSelectors() []FrontendSelectorRecord {
+	if private, ok := r.PrivateFrontend(); ok {
+		return append(append([]FrontendSelectorRecord{}, r.Selectors...), private.Selectors...)
+	}
+	return r.Selectors
+}
+
+// InstanceWeight returns the weight of the instance with given ip & port, if set in InstanceWeights.
+// A weight set for ip:port takes precedence over a weight set for the ip.
+func (r FrontendRecord) InstanceWeight(ip string, port int) (int, bool) {
+	if weight, ok := r.InstanceWeights[net.JoinHostPort(ip, strconv.Itoa(port))]; ok {
+		return weight, true
+	}
+	weight, ok := r.InstanceWeights[ip]
+	return weight, ok
+}
+
+// HasHttpCheck returns true if any of the HTTP health check settings is set.
+func (r FrontendRecord) HasHttpCheck() bool {
+	return r.HttpCheckPath != "" || r.HttpCheckMethod != "" || r.HttpCheckHost != "" || r.HttpCheckPort != 0 || r.HttpCheckInterval != ""
+}
+
 type FrontendSelectorRecord struct {
-	Weight       int           `json:"weight,omitempty"`
-	Domain       string        `json:"domain,omitempty"`
-	PathPrefix   string        `json:"path-prefix,omitempty"`
-	SslCert      string        `json:"ssl-cert,omitempty"`
-	ServicePort  int           `json:"port,omitempty"`
-	FrontendPort int           `json:"frontend-port,omitempty"`
-	Private      bool          `json:"private,omitempty"`
-	Users        []UserRecord  `json:"users,omitempty"`
-	RewriteRules []RewriteRule `json:"rewrite-rules,omitempty"`
+	Weight           int               `json:"weight,omitempty"`
+	Domain           string            `json:"domain,omitempty"` // Domain name or wildcard domain (*.example.com)
+	PathPrefix       string            `json:"path-prefix,omitempty"`
+	SslCert          string            `json:"ssl-cert,omitempty"`
+	ServicePort      int               `json:"port,omitempty"`
+	FrontendPort     int               `json:"frontend-port,omitempty"`
+	Private          bool              `json:"private,omitempty"`
+	Users            []UserRecord      `json:"users,omitempty"`
+	RewriteRules     []RewriteRule     `json:"rewrite-rules,omitempty"`
+	AnyOf            []Condition       `json:"any-of,omitempty"`            // If set, at least one of these conditions must match
+	NoneOf           []Condition       `json:"none-of,omitempty"`           // If set, none of these conditions may match
+	CanonicalHost    string            `json:"canonical-host,omitempty"`    // If set, requests for another host are redirected to this host
+	Role             string            `json:"role,omitempty"`              // If set, only instances with this role are used (primary|replica, tcp mode only)
+	RequestTimeout   string            `json:"request-timeout,omitempty"`   // If set, overrides the server timeout of matching requests (e.g. 5m)
+	InstanceMetadata map[string]string `json:"instance-metadata,omitempty"` // If set, only instances with all of this metadata (e.g. version=v2) are used
+	Cache            *CacheRecord      `json:"cache,omitempty"`             // If set, responses to matching requests are cached by the load-balancer
+	AuthAgent        string            `json:"auth-agent,omitempty"`        // If set, matching requests must be allowed by this SPOE agent (configured on the load-balancer)
+	Quota            *QuotaRecord      `json:"quota,omitempty"`             // If set, the traffic per domain is limited (http mode only)
+	AllowedSources   []string          `json:"allowed-sources,omitempty"`   // If set, only requests from these IP addresses or CIDR ranges match (private selectors only)
 }
 
 // Validate checks the given object for invalid values.
@@ -75,8 +414,23 @@ func (r FrontendSelectorRecord) Validate() error {
 	if r.FrontendPort < 0 || r.FrontendPort > maxPort {
 		return maskAny(errgo.WithCausef(nil, ValidationError, "frontend-port must be between 0-%d", maxPort))
 	}
-	if r.Domain == "" && r.PathPrefix == "" && r.FrontendPort == 0 {
-		return maskAny(errgo.WithCausef(nil, ValidationError, "domain, path-prefix or frontend-port must be set"))
+	if r.Domain == "" && r.PathPrefix == "" && r.FrontendPort == 0 && len(r.AnyOf) == 0 {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "domain, path-prefix, frontend-port or any-of must be set"))
+	}
+	if r.Domain != "" {
+		if err := ValidateSelectorDomain(r.Domain); err != nil {
+			return maskAny(err)
+		}
+	}
+	if r.PathPrefix != "" {
+		if err := ValidatePath(r.PathPrefix); err != nil {
+			return maskAny(err)
+		}
+	}
+	if r.SslCert != "" {
+		if err := ValidateName(r.SslCert); err != nil {
+			return maskAny(err)
+		}
 	}
 	for _, ur := range r.Users {
 		if err := ur.Validate(); err != nil {
@@ -88,6 +442,80 @@ func (r FrontendSelectorRecord) Validate() error {
 			return maskAny(err)
 		}
 	}
+	if r.CanonicalHost != "" {
+		if err := ValidateDomain(r.CanonicalHost); err != nil {
+			return maskAny(err)
+		}
+	}
+	for _, c := range append(append([]Condition{}, r.AnyOf...), r.NoneOf...) {
+		if err := c.Validate(); err != nil {
+			return maskAny(err)
+		}
+	}
+	if r.RequestTimeout != "" {
+		if err := validateInterval(r.RequestTimeout); err != nil {
+			return maskAny(err)
+		}
+	}
+	if r.Cache != nil {
+		if err := r.Cache.Validate(); err != nil {
+			return maskAny(err)
+		}
+	}
+	if r.AuthAgent != "" {
+		if err := ValidateName(r.AuthAgent); err != nil {
+			return maskAny(err)
+		}
+	}
+	if r.Quota != nil {
+		if err := r.Quota.Validate(); err != nil {
+			return maskAny(err)
+		}
+	}
+	if len(r.AllowedSources) > 0 && !r.Private {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "allowed-sources requires private"))
+	}
+	for _, source := range r.AllowedSources {
+		if err := validateSource(source); err != nil {
+			return maskAny(err)
+		}
+	}
+	for key, value := range r.InstanceMetadata {
+		if err := ValidateLabel(key, value); err != nil {
+			return maskAny(err)
+		}
+	}
+	switch r.Role {
+	case "", RolePrimary, RoleReplica:
+	// OK
+	default:
+		return maskAny(errgo.WithCausef(nil, ValidationError, "role must be %s|%s", RolePrimary, RoleReplica))
+	}
+	return nil
+}
+
+// Condition is an additional condition of a selector.
+// If both domain and path-prefix are set, both must match.
+type Condition struct {
+	Domain     string `json:"domain,omitempty"`
+	PathPrefix string `json:"path-prefix,omitempty"`
+}
+
+// Validate checks the given object for invalid values.
+func (c Condition) Validate() error {
+	if c.Domain == "" && c.PathPrefix == "" {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "domain or path-prefix of condition must be set"))
+	}
+	if c.Domain != "" {
+		if err := ValidateSelectorDomain(c.Domain); err != nil {
+			return maskAny(err)
+		}
+	}
+	if c.PathPrefix != "" {
+		if err := ValidatePath(c.PathPrefix); err != nil {
+			return maskAny(err)
+		}
+	}
 	return nil
 }
 
@@ -104,13 +532,18 @@ func (r UserRecord) Validate() error {
 	if r.PasswordHash == "" {
 		return maskAny(errgo.WithCausef(nil, ValidationError, "pwhash must be set"))
 	}
+	if err := validateUser(r.Name, r.PasswordHash); err != nil {
+		return maskAny(err)
+	}
 	return nil
 }
 
 type RewriteRule struct {
 	PathPrefix       string `json:"path-prefix,omitempty"`        // Add this to the start of the request path.
 	RemovePathPrefix string `json:"remove-path-prefix,omitempty"` // Remove this from the start of the request path.
-	Domain           string `json:"domain,omitempty"`             // Redirect to this domain
+	Domain           string `json:"domain,omitempty"`             // Redirect to this domain (keeping path & query string)
+	DropQuery        bool   `json:"drop-query,omitempty"`         // Remove the query string when redirecting to domain
+	DropPath         bool   `json:"drop-path,omitempty"`          // Redirect to the root of domain
 }
 
 // Validate checks the given object for invalid values.
@@ -118,8 +551,119 @@ func (r RewriteRule) Validate() error {
 	if r.PathPrefix == "" && r.RemovePathPrefix == "" && r.Domain == "" {
 		return maskAny(errgo.WithCausef(nil, ValidationError, "at least 1 property must be set"))
 	}
+	if (r.DropQuery || r.DropPath) && r.Domain == "" {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "drop-query and drop-path require domain"))
+	}
 	if r.PathPrefix != "" && r.RemovePathPrefix != "" {
 		return maskAny(errgo.WithCausef(nil, ValidationError, "path-prefix and remove-path-prefix cannot be set both"))
 	}
+	for _, p := range []string{r.PathPrefix, r.RemovePathPrefix} {
+		if p != "" {
+			if err := ValidatePath(p); err != nil {
+				return maskAny(err)
+			}
+		}
+	}
+	if r.Domain != "" {
+		if err := ValidateDomain(r.Domain); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// CacheRecord holds the cache settings of a selector.
+type CacheRecord struct {
+	MaxAge        int  `json:"max-age"`                   // Time (in seconds) responses are cached
+	MaxObjectSize int  `json:"max-object-size,omitempty"` // If set, larger responses (in bytes) are not cached
+	Vary          bool `json:"vary,omitempty"`            // If set, a variant is cached per value of the headers in the Vary response header (accept-encoding, referer & origin only)
+}
+
+// Validate checks the given object for invalid values.
+func (c CacheRecord) Validate() error {
+	if c.MaxAge <= 0 {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "max-age of cache must be positive"))
+	}
+	if c.MaxObjectSize < 0 {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "max-object-size of cache must be positive"))
+	}
+	return nil
+}
+
+// QuotaRecord limits the traffic of a selector per domain.
+// Requests that exceed the quota are refused with status 429.
+type QuotaRecord struct {
+	RequestsPerDay  int   `json:"requests-per-day,omitempty"`  // If set, the maximum number of requests per domain in 24 hours
+	BandwidthPerDay int64 `json:"bandwidth-per-day,omitempty"` // If set, the maximum number of response bytes per domain in 24 hours
+}
+
+// Validate checks the given object for invalid values.
+func (q QuotaRecord) Validate() error {
+	if q.RequestsPerDay < 0 || q.BandwidthPerDay < 0 {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "quota cannot be negative"))
+	}
+	if q.RequestsPerDay == 0 && q.BandwidthPerDay == 0 {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "quota must limit requests-per-day or bandwidth-per-day"))
+	}
+	return nil
+}
+
+// BodyScanRecord specifies how request bodies (uploads) are scanned before they are forwarded.
+// Bodies are passed to a SPOE agent that scans them, typically by passing them on to an ICAP server (e.g. ClamAV).
+// Requests with infected bodies are refused with status 403.
+type BodyScanRecord struct {
+	Agent       string `json:"agent"`                   // Name of the SPOE agent (configured on the load-balancer) that scans bodies
+	MaxBodySize int    `json:"max-body-size,omitempty"` // If set, larger bodies (in bytes) are not scanned
+	FailOpen    bool   `json:"fail-open,omitempty"`     // If set, bodies that cannot be scanned (agent failure or too large) are forwarded, otherwise refused
+}
+
+// Validate checks the given object for invalid values.
+func (r BodyScanRecord) Validate() error {
+	if err := ValidateName(r.Agent); err != nil {
+		return maskAny(err)
+	}
+	if r.MaxBodySize < 0 {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "max-body-size of body-scan must be positive"))
+	}
+	return nil
+}
+
+// PrivateFrontendRecord declares the private registration of a service next to the (public) selectors of its frontend record,
+// so a single record registers both, each with their own edge ports (frontend-port), certificates (ssl-cert) & health checks.
+// Health checks that are not set here are taken from the frontend record.
+type PrivateFrontendRecord struct {
+	Selectors         []FrontendSelectorRecord `json:"selectors"`                 // Selectors of the private registration (private is implied)
+	HttpCheckPath     string                   `json:"http-check-path,omitempty"` // If any of the http-check settings is set, they replace those of the record
+	HttpCheckMethod   string                   `json:"http-check-method,omitempty"`
+	HttpCheckHost     string                   `json:"http-check-host,omitempty"`
+	HttpCheckPort     int                      `json:"http-check-port,omitempty"`
+	HttpCheckInterval string                   `json:"http-check-interval,omitempty"`
+	TcpCheck          string                   `json:"tcp-check,omitempty"` // If set, replaces the tcp-check (and tcp-check-user) of the record
+	TcpCheckUser      string                   `json:"tcp-check-user,omitempty"`
+}
+
+// HasHttpCheck returns true if any of the HTTP health check settings is set.
+func (r PrivateFrontendRecord) HasHttpCheck() bool {
+	return r.HttpCheckPath != "" || r.HttpCheckMethod != "" || r.HttpCheckHost != "" || r.HttpCheckPort != 0 || r.HttpCheckInterval != ""
+}
+
+// SplitRecord sends a percentage of the traffic of a frontend to another service.
+type SplitRecord struct {
+	Service string `json:"service"`        // Name of the service receiving the traffic
+	Port    int    `json:"port,omitempty"` // Port of the service (only needed when the service is registered on multiple ports)
+	Weight  int    `json:"weight"`         // Percentage of the traffic (1-100)
+}
+
+// Validate checks the given object for invalid values.
+func (r SplitRecord) Validate() error {
+	if err := ValidateName(r.Service); err != nil {
+		return maskAny(err)
+	}
+	if r.Port < 0 || r.Port > maxPort {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "port of split must be between 0-%d", maxPort))
+	}
+	if r.Weight < 1 || r.Weight > 100 {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "weight of split must be between 1-100"))
+	}
 	return nil
 }
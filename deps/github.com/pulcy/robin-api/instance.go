@@ -0,0 +1,77 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/juju/errgo"
+)
+
+const (
+	// DefaultInstanceTTL is the time (in seconds) an instance registration lives without a heartbeat.
+	DefaultInstanceTTL = 30
+	maxInstanceTTL     = 24 * 60 * 60
+)
+
+// InstanceRecord is the body of a POST /v1/service/{name}/instances request.
+// Posting the same record again (before its TTL expires) acts as a heartbeat.
+type InstanceRecord struct {
+	ID     string            `json:"id,omitempty"`     // Unique ID of the instance (defaults to a value derived from ip & port)
+	IP     string            `json:"ip"`               // IP address to connect to to reach the instance
+	Port   int               `json:"port"`             // Port to connect to to reach the instance
+	Tags   map[string]string `json:"tags,omitempty"`   // Metadata of the instance (e.g. role=primary, version=v2)
+	Weight *int              `json:"weight,omitempty"` // Relative weight of the instance (0-256, 0 means draining)
+	TTL    int               `json:"ttl,omitempty"`    // Time (in seconds) the registration lives without a heartbeat (defaults to DefaultInstanceTTL)
+}
+
+// Validate checks the given object for invalid values.
+func (r InstanceRecord) Validate() error {
+	if r.ID != "" {
+		if err := ValidateName(r.ID); err != nil {
+			return maskAny(err)
+		}
+	}
+	if net.ParseIP(r.IP) == nil {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "invalid ip '%s'", r.IP))
+	}
+	if r.Port <= 0 || r.Port > maxPort {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "port must be between 1-%d", maxPort))
+	}
+	for key, value := range r.Tags {
+		if err := ValidateLabel(key, value); err != nil {
+			return maskAny(err)
+		}
+	}
+	if r.Weight != nil {
+		if err := validateInstanceWeight(*r.Weight); err != nil {
+			return maskAny(err)
+		}
+	}
+	if r.TTL < 0 || r.TTL > maxInstanceTTL {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "ttl must be between 0-%d", maxInstanceTTL))
+	}
+	return nil
+}
+
+// InstanceID returns the ID of the instance, derived from its ip & port if not set.
+func (r InstanceRecord) InstanceID() string {
+	if r.ID != "" {
+		return r.ID
+	}
+	return fmt.Sprintf("%s-%d", strings.NewReplacer(".", "_", ":", "_").Replace(r.IP), r.Port)
+}
@@ -0,0 +1,314 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errgo"
+)
+
+const (
+	// WildcardDomainPrefix is the prefix of a selector domain that matches all subdomains of the remainder.
+	WildcardDomainPrefix = "*."
+
+	maxDomainLength     = 253
+	maxLabelKeyLength   = 63
+	maxLabelValueLength = 63
+)
+
+var (
+	// Values are interpolated in the load-balancer configuration, so only allow characters
+	// that have no special meaning there (no whitespace, quotes, backslashes, '#' or braces).
+	domainRegexp       = regexp.MustCompile(`^[A-Za-z0-9_]([A-Za-z0-9_-]*[A-Za-z0-9_])?(\.[A-Za-z0-9_]([A-Za-z0-9_-]*[A-Za-z0-9_])?)*$`)
+	pathRegexp         = regexp.MustCompile(`^/[A-Za-z0-9._~/%@:=&;!-]*$`)
+	checkPathRegexp    = regexp.MustCompile(`^/[A-Za-z0-9._~/%@:=&;!?-]*$`)
+	methodRegexp       = regexp.MustCompile(`^[A-Z]+$`)
+	nameRegexp         = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	userNameRegexp     = regexp.MustCompile(`^[A-Za-z0-9._@-]+$`)
+	passwordHashRegexp = regexp.MustCompile(`^[A-Za-z0-9./$=+-]+$`)
+	ownerRegexp        = regexp.MustCompile(`^[A-Za-z0-9._@-]+$`)
+	labelKeyRegexp     = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+	labelValueRegexp   = regexp.MustCompile(`^[A-Za-z0-9._-]*$`)
+	intervalRegexp     = regexp.MustCompile(`^[1-9][0-9]*(us|ms|s|m|h|d)?$`)
+	headerNameRegexp   = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+	hashOnRegexp       = regexp.MustCompile(`^(path|(header|url-param|cookie|jwt-claim):[A-Za-z0-9_.-]+)$`)
+	luaActionRegexp    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// ValidateDomain checks that the given domain name is safe to use.
+func ValidateDomain(domain string) error {
+	if len(domain) > maxDomainLength || !domainRegexp.MatchString(domain) {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "invalid domain '%s'", domain))
+	}
+	return nil
+}
+
+// ValidateSelectorDomain checks that the given domain of a selector is safe to use.
+// Besides normal domain names, it accepts wildcard domains (`*.example.com`).
+func ValidateSelectorDomain(domain string) error {
+	if strings.HasPrefix(domain, WildcardDomainPrefix) {
+		if err := ValidateDomain(strings.TrimPrefix(domain, WildcardDomainPrefix)); err != nil {
+			return maskAny(errgo.WithCausef(nil, ValidationError, "invalid wildcard domain '%s'", domain))
+		}
+		return nil
+	}
+	return maskAny(ValidateDomain(domain))
+}
+
+// ValidatePath checks that the given path (prefix) is safe to use.
+// It must start with a '/'.
+func ValidatePath(path string) error {
+	if !pathRegexp.MatchString(path) {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "invalid path '%s'", path))
+	}
+	return nil
+}
+
+// ValidateName checks that the given name (of a service, certificate or group) is safe to use.
+func ValidateName(name string) error {
+	if !nameRegexp.MatchString(name) {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "invalid name '%s'", name))
+	}
+	return nil
+}
+
+// validateHttpCheck checks the given HTTP health check path & method.
+func validateHttpCheck(path, method string) error {
+	if path != "" && !checkPathRegexp.MatchString(path) {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "invalid http-check-path '%s'", path))
+	}
+	if method != "" && !methodRegexp.MatchString(method) {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "invalid http-check-method '%s'", method))
+	}
+	return nil
+}
+
+// validateTcpCheck checks the given protocol level health check settings.
+func validateTcpCheck(mode, check, user string) error {
+	switch check {
+	case "":
+		if user != "" {
+			return maskAny(errgo.WithCausef(nil, ValidationError, "tcp-check-user requires tcp-check"))
+		}
+		return nil
+	case "mysql", "redis", "pgsql":
+	// OK
+	default:
+		return maskAny(errgo.WithCausef(nil, ValidationError, "tcp-check must be mysql|redis|pgsql"))
+	}
+	if mode != "tcp" {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "tcp-check requires mode tcp"))
+	}
+	if check == "pgsql" && user == "" {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "tcp-check pgsql requires tcp-check-user"))
+	}
+	if check == "redis" && user != "" {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "tcp-check redis does not support tcp-check-user"))
+	}
+	if user != "" && !userNameRegexp.MatchString(user) {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "invalid tcp-check-user '%s'", user))
+	}
+	return nil
+}
+
+// validateInterval checks the given time interval (number with optional unit).
+func validateInterval(interval string) error {
+	if !intervalRegexp.MatchString(interval) {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "invalid interval '%s'", interval))
+	}
+	return nil
+}
+
+// validateInstance checks the given instance address (ip or ip:port).
+func validateInstance(instance string) error {
+	host := instance
+	if h, port, err := net.SplitHostPort(instance); err == nil {
+		if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > maxPort {
+			return maskAny(errgo.WithCausef(nil, ValidationError, "invalid port in instance '%s'", instance))
+		}
+		host = h
+	}
+	if net.ParseIP(host) == nil {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "invalid instance '%s'", instance))
+	}
+	return nil
+}
+
+// validateInstanceWeight checks the given relative weight of an instance.
+func validateInstanceWeight(weight int) error {
+	if weight < 0 || weight > MaxInstanceWeight {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "instance weight must be between 0-%d", MaxInstanceWeight))
+	}
+	return nil
+}
+
+// ParseExternalURL checks the given external URL (http|https://host[:port]) and returns
+// its host, port (defaulting to the port of the scheme) and whether it uses SSL.
+func ParseExternalURL(rawURL string) (string, int, bool, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", 0, false, maskAny(errgo.WithCausef(nil, ValidationError, "invalid external-url '%s'", rawURL))
+	}
+	var ssl bool
+	var port int
+	switch u.Scheme {
+	case "http":
+		port = 80
+	case "https":
+		ssl, port = true, 443
+	default:
+		return "", 0, false, maskAny(errgo.WithCausef(nil, ValidationError, "external-url must use http or https"))
+	}
+	if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", 0, false, maskAny(errgo.WithCausef(nil, ValidationError, "external-url cannot contain user info, path, query or fragment"))
+	}
+	host := u.Hostname()
+	if err := ValidateDomain(host); err != nil {
+		return "", 0, false, maskAny(err)
+	}
+	if p := u.Port(); p != "" {
+		port, err = strconv.Atoi(p)
+		if err != nil || port <= 0 || port > maxPort {
+			return "", 0, false, maskAny(errgo.WithCausef(nil, ValidationError, "invalid port in external-url '%s'", rawURL))
+		}
+	}
+	return host, port, ssl, nil
+}
+
+// validateProbe checks the given probe settings.
+func validateProbe(probeType, path string, port int, interval string) error {
+	switch probeType {
+	case "":
+		if path != "" || port != 0 || interval != "" {
+			return maskAny(errgo.WithCausef(nil, ValidationError, "probe settings require probe-type"))
+		}
+		return nil
+	case "http", "tcp":
+	// OK
+	default:
+		return maskAny(errgo.WithCausef(nil, ValidationError, "probe-type must be http|tcp"))
+	}
+	if path != "" {
+		if probeType != "http" {
+			return maskAny(errgo.WithCausef(nil, ValidationError, "probe-path requires probe-type http"))
+		}
+		if !checkPathRegexp.MatchString(path) {
+			return maskAny(errgo.WithCausef(nil, ValidationError, "invalid probe-path '%s'", path))
+		}
+	}
+	if port < 0 || port > maxPort {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "probe-port must be between 0-%d", maxPort))
+	}
+	if interval != "" {
+		if err := validateInterval(interval); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// validateUser checks the given user name & password hash.
+func validateUser(name, passwordHash string) error {
+	if !userNameRegexp.MatchString(name) {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "invalid user name '%s'", name))
+	}
+	if !passwordHashRegexp.MatchString(passwordHash) {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "invalid pwhash for user '%s'", name))
+	}
+	return nil
+}
+
+// ValidateLabel checks that the given label key & value are safe to use.
+func ValidateLabel(key, value string) error {
+	if len(key) > maxLabelKeyLength || !labelKeyRegexp.MatchString(key) {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "invalid label key '%s'", key))
+	}
+	if len(value) > maxLabelValueLength || !labelValueRegexp.MatchString(value) {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "invalid value for label '%s'", key))
+	}
+	return nil
+}
+
+// validateMetadataHeader checks that the given instance metadata key can be sent in the given response header.
+func validateMetadataHeader(key, header string) error {
+	if len(key) > maxLabelKeyLength || !labelKeyRegexp.MatchString(key) {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "invalid metadata key '%s'", key))
+	}
+	if !headerNameRegexp.MatchString(header) {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "invalid header name '%s' for metadata '%s'", header, key))
+	}
+	return nil
+}
+
+// validateHashOn checks the given balance hash key.
+func validateHashOn(hashOn string) error {
+	if !hashOnRegexp.MatchString(hashOn) {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "hash-on must be path, header:<name>, url-param:<name>, cookie:<name> or jwt-claim:<name>"))
+	}
+	return nil
+}
+
+// validateLuaAction checks the given Lua action name.
+func validateLuaAction(name string) error {
+	if !luaActionRegexp.MatchString(name) {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "invalid lua action '%s'", name))
+	}
+	return nil
+}
+
+// validateSource checks the given IP address or CIDR range.
+func validateSource(source string) error {
+	if net.ParseIP(source) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(source); err == nil {
+		return nil
+	}
+	return maskAny(errgo.WithCausef(nil, ValidationError, "invalid source '%s', expected an IP address or CIDR range", source))
+}
+
+// validateTraps checks the given trap paths & action.
+func validateTraps(mode string, paths []string, action string) error {
+	if len(paths) > 0 && mode != "" && mode != "http" {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "trap-paths requires mode http"))
+	}
+	for _, path := range paths {
+		if !pathRegexp.MatchString(path) {
+			return maskAny(errgo.WithCausef(nil, ValidationError, "invalid trap path '%s'", path))
+		}
+	}
+	switch action {
+	case "", TrapActionTarpit, TrapActionBan:
+	default:
+		return maskAny(errgo.WithCausef(nil, ValidationError, "trap-action must be tarpit|ban, got '%s'", action))
+	}
+	if action != "" && len(paths) == 0 {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "trap-action requires trap-paths"))
+	}
+	return nil
+}
+
+// validateOwner checks the given owner of a record.
+func validateOwner(owner string) error {
+	if !ownerRegexp.MatchString(owner) {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "invalid owner '%s'", owner))
+	}
+	return nil
+}
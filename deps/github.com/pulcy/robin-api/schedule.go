@@ -0,0 +1,68 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"time"
+
+	"github.com/juju/errgo"
+)
+
+const (
+	// ScheduleActionSet adds or replaces a frontend record.
+	ScheduleActionSet = "set"
+	// ScheduleActionRemove removes a frontend record.
+	ScheduleActionRemove = "remove"
+)
+
+// ScheduledChange is a change of a frontend record that is applied at a given time.
+type ScheduledChange struct {
+	ID         string          `json:"id,omitempty"`     // Unique ID of the change (assigned when scheduled)
+	FrontendID string          `json:"frontend-id"`      // ID of the frontend record that is changed
+	At         time.Time       `json:"at"`               // Time at which the change is applied
+	Action     string          `json:"action"`           // set|remove
+	Record     *FrontendRecord `json:"record,omitempty"` // New frontend record (set only)
+}
+
+// Validate checks the given object for invalid values.
+func (c ScheduledChange) Validate() error {
+	if c.ID != "" {
+		if err := ValidateName(c.ID); err != nil {
+			return maskAny(err)
+		}
+	}
+	if err := ValidateName(c.FrontendID); err != nil {
+		return maskAny(err)
+	}
+	if c.At.IsZero() {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "at must be set"))
+	}
+	switch c.Action {
+	case ScheduleActionSet:
+		if c.Record == nil {
+			return maskAny(errgo.WithCausef(nil, ValidationError, "action %s requires a record", c.Action))
+		}
+		if err := c.Record.Validate(); err != nil {
+			return maskAny(err)
+		}
+	case ScheduleActionRemove:
+		if c.Record != nil {
+			return maskAny(errgo.WithCausef(nil, ValidationError, "action %s cannot have a record", c.Action))
+		}
+	default:
+		return maskAny(errgo.WithCausef(nil, ValidationError, "action must be %s|%s", ScheduleActionSet, ScheduleActionRemove))
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"text/template"
+
+	"github.com/juju/errgo"
+)
+
+// FromTemplateRequest is the body of a POST /v1/frontend/from-template request.
+type FromTemplateRequest struct {
+	ID        string            `json:"id"`                  // ID of the frontend record to create
+	Template  string            `json:"template"`            // Name of the template to instantiate
+	Variables map[string]string `json:"variables,omitempty"` // Values for the variables used in the template
+}
+
+// ValidateTemplate checks that all values of the given template record can be parsed.
+func ValidateTemplate(tmpl FrontendRecord) error {
+	if tmpl.Service == "" {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "service must be set"))
+	}
+	if len(tmpl.Selectors) == 0 {
+		return maskAny(errgo.WithCausef(nil, ValidationError, "at least 1 selector must be set"))
+	}
+	if _, err := substituteRecord(tmpl, func(s string) (string, error) {
+		_, err := parseTemplate(s)
+		return s, err
+	}); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// InstantiateTemplate creates a frontend record from the given template by substituting
+// the given variables (`{{.name}}`) in all of its string values.
+// The resulting record is validated.
+func InstantiateTemplate(tmpl FrontendRecord, variables map[string]string) (FrontendRecord, error) {
+	record, err := substituteRecord(tmpl, func(s string) (string, error) {
+		t, err := parseTemplate(s)
+		if err != nil {
+			return "", maskAny(err)
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, variables); err != nil {
+			return "", maskAny(errgo.WithCausef(nil, ValidationError, "cannot instantiate '%s': %v", s, err))
+		}
+		return buf.String(), nil
+	})
+	if err != nil {
+		return FrontendRecord{}, maskAny(err)
+	}
+	if err := record.Validate(); err != nil {
+		return FrontendRecord{}, maskAny(err)
+	}
+	return record, nil
+}
+
+func parseTemplate(s string) (*template.Template, error) {
+	t, err := template.New("value").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return nil, maskAny(errgo.WithCausef(nil, ValidationError, "invalid template '%s': %v", s, err))
+	}
+	return t, nil
+}
+
+// substituteRecord calls the given function for every string value (containing a variable)
+// of the given record and returns a record containing the results.
+func substituteRecord(record FrontendRecord, substitute func(string) (string, error)) (FrontendRecord, error) {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return FrontendRecord{}, maskAny(err)
+	}
+	var tree interface{}
+	if err := json.Unmarshal(raw, &tree); err != nil {
+		return FrontendRecord{}, maskAny(err)
+	}
+	if tree, err = substituteValue(tree, substitute); err != nil {
+		return FrontendRecord{}, maskAny(err)
+	}
+	if raw, err = json.Marshal(tree); err != nil {
+		return FrontendRecord{}, maskAny(err)
+	}
+	var result FrontendRecord
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return FrontendRecord{}, maskAny(err)
+	}
+	return result, nil
+}
+
+func substituteValue(value interface{}, substitute func(string) (string, error)) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if !strings.Contains(v, "{{") {
+			return v, nil
+		}
+		return substitute(v)
+	case []interface{}:
+		for i, x := range v {
+			y, err := substituteValue(x, substitute)
+			if err != nil {
+				return nil, maskAny(err)
+			}
+			v[i] = y
+		}
+	case map[string]interface{}:
+		for k, x := range v {
+			y, err := substituteValue(x, substitute)
+			if err != nil {
+				return nil, maskAny(err)
+			}
+			v[k] = y
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,315 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	api "github.com/pulcy/robin-api"
+
+	"github.com/pulcy/robin/service"
+)
+
+var (
+	cmdDiff = &cobra.Command{
+		Use:   "diff",
+		Short: "Compare the frontend records, routes & HAProxy configs of two Robin instances",
+		Long:  "Compare the frontend records, routes & HAProxy configs of two Robin instances. Exits with code 1 if they differ.",
+		Run:   cmdDiffRun,
+	}
+
+	diffArgs struct {
+		from   string
+		to     string
+		client apiClientArgs
+	}
+)
+
+// snapshot is the routing state of a single Robin instance.
+type snapshot struct {
+	Frontends map[string]api.FrontendRecord
+	Routes    []service.Route
+	Config    string
+}
+
+func init() {
+	cmdDiff.Flags().StringVar(&diffArgs.from, "from", "", "API URL of the first instance (e.g. http://lb-a:8056)")
+	cmdDiff.Flags().StringVar(&diffArgs.to, "to", "", "API URL of the second instance (e.g. http://lb-b:8056)")
+	diffArgs.client.addFlags(cmdDiff.Flags())
+	cmdMain.AddCommand(cmdDiff)
+}
+
+func cmdDiffRun(cmd *cobra.Command, args []string) {
+	if diffArgs.from == "" || diffArgs.to == "" {
+		Exitf("Please specify --from and --to")
+	}
+	from, err := fetchSnapshot(diffArgs.from, diffArgs.client.clientConfig())
+	if err != nil {
+		Exitf("Cannot fetch %s: %v", diffArgs.from, err)
+	}
+	to, err := fetchSnapshot(diffArgs.to, diffArgs.client.clientConfig())
+	if err != nil {
+		Exitf("Cannot fetch %s: %v", diffArgs.to, err)
+	}
+
+	lines := diffFrontends(from.Frontends, to.Frontends)
+	lines = append(lines, diffRoutes(from.Routes, to.Routes)...)
+	lines = append(lines, diffConfigs(from.Config, to.Config)...)
+	if len(lines) == 0 {
+		fmt.Println("No differences")
+		return
+	}
+	fmt.Printf("--- %s\n+++ %s\n", diffArgs.from, diffArgs.to)
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	os.Exit(1)
+}
+
+// fetchSnapshot fetches the frontend records, routes & HAProxy config of the instance with given API URL.
+func fetchSnapshot(rawURL string, config api.ClientConfig) (snapshot, error) {
+	baseURL, err := url.Parse(rawURL)
+	if err != nil {
+		return snapshot{}, err
+	}
+	client, err := api.NewClientWithConfig(baseURL, config)
+	if err != nil {
+		return snapshot{}, err
+	}
+	frontends, err := client.All()
+	if err != nil {
+		return snapshot{}, err
+	}
+
+	rawRoutes, err := fetchAPI(rawURL, "/v1/config/routes", config)
+	if err != nil {
+		return snapshot{}, err
+	}
+	var routes []service.Route
+	if err := json.Unmarshal(rawRoutes, &routes); err != nil {
+		return snapshot{}, err
+	}
+	rawConfig, err := fetchAPI(rawURL, "/v1/config", config)
+	if err != nil {
+		return snapshot{}, err
+	}
+	return snapshot{Frontends: frontends, Routes: routes, Config: string(rawConfig)}, nil
+}
+
+// fetchAPI performs a GET request on the given path of the instance with given API URL
+// and returns the response body.
+func fetchAPI(rawURL, path string, config api.ClientConfig) ([]byte, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(rawURL, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+config.Token)
+	}
+	httpClient, err := api.NewHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s returned status %d", path, resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// diffFrontends returns the differences between two sets of frontend records, per record & field.
+func diffFrontends(from, to map[string]api.FrontendRecord) []string {
+	ids := []string{}
+	for id := range from {
+		ids = append(ids, id)
+	}
+	for id := range to {
+		if _, ok := from[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	result := []string{}
+	for _, id := range ids {
+		a, inFrom := from[id]
+		b, inTo := to[id]
+		switch {
+		case !inTo:
+			result = append(result, fmt.Sprintf("- frontend %s", id))
+		case !inFrom:
+			result = append(result, fmt.Sprintf("+ frontend %s", id))
+		default:
+			fieldsA, fieldsB := recordFields(a), recordFields(b)
+			for _, name := range unionKeys(fieldsA, fieldsB) {
+				if !reflect.DeepEqual(fieldsA[name], fieldsB[name]) {
+					result = append(result, fmt.Sprintf("~ frontend %s %s: %s -> %s", id, name, toJSON(fieldsA[name]), toJSON(fieldsB[name])))
+				}
+			}
+		}
+	}
+	return result
+}
+
+// diffRoutes returns the differences between two sets of routes, keyed by frontend, domain, path prefix,
+// backend & weight, so the individual routes of weighted & split services are compared.
+func diffRoutes(from, to []service.Route) []string {
+	index := func(routes []service.Route) map[string]interface{} {
+		result := make(map[string]interface{})
+		for _, r := range routes {
+			key := fmt.Sprintf("%s %s%s %s (weight %d)", r.Frontend, r.Domain, r.PathPrefix, r.Backend, r.Weight)
+			result[key] = fmt.Sprintf("%s:%d", r.Service, r.ServicePort)
+		}
+		return result
+	}
+	a, b := index(from), index(to)
+	result := []string{}
+	for _, key := range unionKeys(a, b) {
+		va, inFrom := a[key]
+		vb, inTo := b[key]
+		switch {
+		case !inTo:
+			result = append(result, fmt.Sprintf("- route %s -> %s", key, va))
+		case !inFrom:
+			result = append(result, fmt.Sprintf("+ route %s -> %s", key, vb))
+		case va != vb:
+			result = append(result, fmt.Sprintf("~ route %s: %s -> %s", key, va, vb))
+		}
+	}
+	return result
+}
+
+// diffConfigs returns the differences between two HAProxy configs, per section.
+// The lines of sections that exist in both configs are compared in order.
+func diffConfigs(from, to string) []string {
+	a, b := configSections(from), configSections(to)
+	result := []string{}
+	for _, name := range unionKeys(a, b) {
+		la, inFrom := a[name]
+		lb, inTo := b[name]
+		switch {
+		case !inTo:
+			result = append(result, fmt.Sprintf("- config %s", name))
+		case !inFrom:
+			result = append(result, fmt.Sprintf("+ config %s", name))
+		default:
+			for _, line := range diffLines(la.([]string), lb.([]string)) {
+				result = append(result, fmt.Sprintf("~ config %s: %s", name, line))
+			}
+		}
+	}
+	return result
+}
+
+// configSections splits the given HAProxy config into the (trimmed, non-empty) lines per section header.
+func configSections(config string) map[string]interface{} {
+	result := make(map[string]interface{})
+	name := ""
+	for _, line := range strings.Split(config, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if trimmed == line {
+			name = trimmed
+			result[name] = []string{}
+			continue
+		}
+		lines, _ := result[name].([]string)
+		result[name] = append(lines, trimmed)
+	}
+	return result
+}
+
+// diffLines returns the lines that are removed from (-) or added to (+) the first list
+// to get the second list, based on their longest common subsequence.
+func diffLines(from, to []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of from[i:] and to[j:]
+	lcs := make([][]int, len(from)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	result := []string{}
+	i, j := 0, 0
+	for i < len(from) || j < len(to) {
+		switch {
+		case i < len(from) && j < len(to) && from[i] == to[j]:
+			i++
+			j++
+		case j == len(to) || (i < len(from) && lcs[i+1][j] >= lcs[i][j+1]):
+			result = append(result, "- "+from[i])
+			i++
+		default:
+			result = append(result, "+ "+to[j])
+			j++
+		}
+	}
+	return result
+}
+
+// recordFields returns the (JSON) fields of the given record.
+func recordFields(record api.FrontendRecord) map[string]interface{} {
+	result := make(map[string]interface{})
+	raw, _ := json.Marshal(record)
+	json.Unmarshal(raw, &result)
+	return result
+}
+
+func unionKeys(a, b map[string]interface{}) []string {
+	result := []string{}
+	for key := range a {
+		result = append(result, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			result = append(result, key)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func toJSON(v interface{}) string {
+	if v == nil {
+		return "<none>"
+	}
+	raw, _ := json.Marshal(v)
+	return string(raw)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	api "github.com/pulcy/robin-api"
+
+	"github.com/pulcy/robin/service"
+)
+
+func TestDiffFrontends(t *testing.T) {
+	from := map[string]api.FrontendRecord{
+		"web": api.FrontendRecord{Service: "web", Mode: "http"},
+		"old": api.FrontendRecord{Service: "old"},
+	}
+	to := map[string]api.FrontendRecord{
+		"web": api.FrontendRecord{Service: "web", Mode: "tcp"},
+		"new": api.FrontendRecord{Service: "new"},
+	}
+	expected := []string{
+		"+ frontend new",
+		"- frontend old",
+		`~ frontend web mode: "http" -> "tcp"`,
+	}
+	if lines := diffFrontends(from, to); fmt.Sprintf("%q", lines) != fmt.Sprintf("%q", expected) {
+		t.Errorf("Expected %q, got %q", expected, lines)
+	}
+}
+
+func TestDiffRoutes(t *testing.T) {
+	// A weighted service split over 2 backends for the same domain & path
+	from := []service.Route{
+		service.Route{Frontend: "public_http_in_80", Domain: "foo.com", Backend: "backend_web_80", Weight: 90, Service: "web", ServicePort: 80},
+		service.Route{Frontend: "public_http_in_80", Domain: "foo.com", Backend: "backend_web_canary_80", Weight: 10, Service: "web-canary", ServicePort: 80},
+	}
+	to := []service.Route{
+		service.Route{Frontend: "public_http_in_80", Domain: "foo.com", Backend: "backend_web_80", Weight: 50, Service: "web", ServicePort: 80},
+		service.Route{Frontend: "public_http_in_80", Domain: "foo.com", Backend: "backend_web_canary_80", Weight: 10, Service: "web-canary", ServicePort: 8080},
+	}
+	expected := []string{
+		"+ route public_http_in_80 foo.com backend_web_80 (weight 50) -> web:80",
+		"- route public_http_in_80 foo.com backend_web_80 (weight 90) -> web:80",
+		"~ route public_http_in_80 foo.com backend_web_canary_80 (weight 10): web-canary:80 -> web-canary:8080",
+	}
+	if lines := diffRoutes(from, to); fmt.Sprintf("%q", lines) != fmt.Sprintf("%q", expected) {
+		t.Errorf("Expected %q, got %q", expected, lines)
+	}
+	if lines := diffRoutes(from, from); len(lines) != 0 {
+		t.Errorf("Expected no differences, got %q", lines)
+	}
+}
+
+func TestDiffConfigs(t *testing.T) {
+	from := "global\n    daemon\n\nfrontend public_http_in_80\n    bind *:80\n    mode http\n    use_backend a if acl1\n    use_backend b if acl2\n\nbackend a\n    server a1 10.0.0.1:80\n\nbackend b\n    server b1 10.0.0.2:80\n"
+	to := "global\n    daemon\n\nfrontend public_http_in_80\n    bind *:80\n    mode http\n    use_backend b if acl2\n    use_backend a if acl1\n\nbackend b\n    server b1 10.0.0.2:80\n\nbackend c\n    server c1 10.0.0.3:80\n"
+	expected := []string{
+		"- config backend a",
+		"+ config backend c",
+		"~ config frontend public_http_in_80: - use_backend a if acl1",
+		"~ config frontend public_http_in_80: + use_backend a if acl1",
+	}
+	if lines := diffConfigs(from, to); fmt.Sprintf("%q", lines) != fmt.Sprintf("%q", expected) {
+		t.Errorf("Expected %q, got %q", expected, lines)
+	}
+	if lines := diffConfigs(from, from); len(lines) != 0 {
+		t.Errorf("Expected no differences, got %q", lines)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	api "github.com/pulcy/robin-api"
+
+	"github.com/pulcy/robin/importer"
+)
+
+var (
+	cmdFrontendImport = &cobra.Command{
+		Use:   "import",
+		Short: "Generate frontend records from docker-compose files or Kubernetes manifests",
+		Long:  "Generate frontend records from docker-compose files or Kubernetes manifests. Both can be in YAML or JSON notation.",
+		Run:   cmdFrontendImportRun,
+	}
+
+	frontendImportArgs struct {
+		composePath    string
+		kubernetesPath string
+		apiURL         string
+		client         apiClientArgs
+	}
+)
+
+func init() {
+	cmdFrontendImport.Flags().StringVar(&frontendImportArgs.composePath, "compose", "", "Path of a docker-compose file. Services are exposed using robin.* labels")
+	cmdFrontendImport.Flags().StringVar(&frontendImportArgs.kubernetesPath, "kubernetes", "", "Path of a Kubernetes manifest containing Ingress resources")
+	cmdFrontendImport.Flags().StringVar(&frontendImportArgs.apiURL, "api", "", "If set, the generated records are added using the API at this URL (e.g. http://localhost:8056)")
+	frontendImportArgs.client.addFlags(cmdFrontendImport.Flags())
+	cmdFrontend.AddCommand(cmdFrontendImport)
+}
+
+func cmdFrontendImportRun(cmd *cobra.Command, args []string) {
+	var path string
+	var importFunc func([]byte) (map[string]api.FrontendRecord, error)
+	switch {
+	case frontendImportArgs.composePath != "" && frontendImportArgs.kubernetesPath != "":
+		Exitf("Please specify either --compose or --kubernetes")
+	case frontendImportArgs.composePath != "":
+		path, importFunc = frontendImportArgs.composePath, importer.ImportCompose
+	case frontendImportArgs.kubernetesPath != "":
+		path, importFunc = frontendImportArgs.kubernetesPath, importer.ImportKubernetes
+	default:
+		Exitf("Please specify --compose or --kubernetes")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		Exitf("Cannot read %s: %v", path, err)
+	}
+	records, err := importFunc(data)
+	if err != nil {
+		Exitf("Cannot import %s: %v", path, err)
+	}
+
+	if frontendImportArgs.apiURL == "" {
+		raw, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			Exitf("Cannot encode records: %v", err)
+		}
+		fmt.Println(string(raw))
+		return
+	}
+
+	apiURL, err := url.Parse(frontendImportArgs.apiURL)
+	if err != nil {
+		Exitf("Invalid --api: %v", err)
+	}
+	client, err := api.NewClientWithConfig(apiURL, frontendImportArgs.client.clientConfig())
+	if err != nil {
+		Exitf("Cannot create API client: %v", err)
+	}
+	ids := make([]string, 0, len(records))
+	for id := range records {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if err := client.Add(id, records[id]); err != nil {
+			Exitf("Cannot add frontend record %s: %v", id, err)
+		}
+		fmt.Printf("Added frontend record %s\n", id)
+	}
+}
@@ -15,6 +15,7 @@
 package haproxy
 
 import (
+	"fmt"
 	"strings"
 )
 
@@ -58,6 +59,32 @@ func (c *Config) Render() string {
 	return strings.Join(lines, "\n")
 }
 
+// Validate checks that no section name or option contains control characters
+// (such as newlines) that would break out of its line in the rendered configuration.
+func (c *Config) Validate() error {
+	for _, s := range c.sections {
+		if containsControlCharacter(s.name) {
+			return maskAny(fmt.Errorf("section %q contains control characters", s.name))
+		}
+		for _, o := range s.options {
+			if containsControlCharacter(o) {
+				return maskAny(fmt.Errorf("option %q in section %q contains control characters", o, s.name))
+			}
+		}
+	}
+	return nil
+}
+
+// containsControlCharacter returns true if the given string contains an ASCII control character.
+func containsControlCharacter(s string) bool {
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
 // Add appends the given options to this section
 func (s *Section) Add(options ...string) {
 	s.options = append(s.options, options...)
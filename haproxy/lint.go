@@ -0,0 +1,156 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package haproxy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// reqrepMethodPrefix is the part of a reqrep regex that matches the request method.
+	reqrepMethodPrefix = `^([^\ :]*)\ `
+	// reqrepMethodReplacement is the part of a reqrep replacement that restores the request method.
+	reqrepMethodReplacement = `\1\ `
+)
+
+var (
+	// Directives removed in HAProxy 2.1 that cannot be rewritten automatically.
+	removedRegexDirectives = map[string]struct{}{
+		"reqdel": {}, "reqidel": {}, "reqirep": {},
+		"reqallow": {}, "reqiallow": {}, "reqdeny": {}, "reqideny": {},
+		"reqpass": {}, "reqipass": {}, "reqtarpit": {}, "reqitarpit": {},
+		"rspadd": {}, "rspdel": {}, "rspidel": {}, "rsprep": {}, "rspirep": {},
+		"rspdeny": {}, "rspideny": {},
+	}
+
+	// Literal path part (no regex meta characters or converter argument separators).
+	literalPathRegexp = regexp.MustCompile(`^[A-Za-z0-9/_\-~]*$`)
+)
+
+// Lint checks the given rendered configuration against the given HAProxy version.
+// Incompatible directives are rewritten into an equivalent form if possible.
+// An error is returned when an incompatible directive cannot be rewritten.
+// The returned list contains a description of all rewrites.
+// If the version is unknown, the configuration is returned unmodified.
+func Lint(config string, version Version) (string, []string, error) {
+	if version.IsUnknown() {
+		return config, nil, nil
+	}
+	lines := strings.Split(config, "\n")
+	var rewrites []string
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " \t")
+		indent := line[:len(line)-len(trimmed)]
+		directive, args := splitFirstArg(trimmed)
+		if directive == "http-request" {
+			// Use action as directive
+			action, actionArgs := splitFirstArg(args)
+			directive, args = directive+" "+action, actionArgs
+		}
+
+		var replacement string
+		var err error
+		switch {
+		case directive == "reqadd" && version.AtLeast(2, 0):
+			replacement, err = rewriteReqadd(args)
+		case directive == "reqrep" && version.AtLeast(2, 0):
+			replacement, err = rewriteReqrep(args)
+		case directive == "http-request set-path" && !version.AtLeast(1, 6):
+			replacement, err = rewriteSetPath(args)
+		default:
+			if _, found := removedRegexDirectives[directive]; found && version.AtLeast(2, 1) {
+				err = fmt.Errorf("'%s' is not supported by HAProxy %s", directive, version)
+			}
+		}
+		if err != nil {
+			return "", nil, maskAny(fmt.Errorf("line %d: %v", i+1, err))
+		}
+		if replacement != "" {
+			lines[i] = indent + replacement
+			rewrites = append(rewrites, fmt.Sprintf("line %d: '%s' -> '%s'", i+1, trimmed, replacement))
+		}
+	}
+	return strings.Join(lines, "\n"), rewrites, nil
+}
+
+// rewriteReqadd converts `reqadd Name:\ value [cond]` into `http-request add-header Name value [cond]`.
+func rewriteReqadd(args string) (string, error) {
+	header, cond := splitFirstArg(args)
+	parts := strings.SplitN(header, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", maskAny(fmt.Errorf("cannot rewrite 'reqadd %s'", args))
+	}
+	value := strings.TrimPrefix(parts[1], `\ `)
+	return joinArgs("http-request add-header", parts[0], value, cond), nil
+}
+
+// rewriteReqrep converts a path rewrite like `reqrep ^([^\ :]*)\ /prefix/(.*) \1\ /\2 [cond]`
+// into `http-request set-path %[path,regsub(^/prefix/,/)] [cond]`.
+func rewriteReqrep(args string) (string, error) {
+	regex, rest := splitFirstArg(args)
+	subst, cond := splitFirstArg(rest)
+	failed := maskAny(fmt.Errorf("cannot rewrite 'reqrep %s'", args))
+	if !strings.HasPrefix(regex, reqrepMethodPrefix) || !strings.HasPrefix(subst, reqrepMethodReplacement) {
+		return "", failed
+	}
+	if !strings.HasSuffix(regex, "(.*)") || !strings.HasSuffix(subst, `\2`) {
+		return "", failed
+	}
+	from := strings.TrimSuffix(strings.TrimPrefix(regex, reqrepMethodPrefix), "(.*)")
+	to := strings.TrimSuffix(strings.TrimPrefix(subst, reqrepMethodReplacement), `\2`)
+	if !literalPathRegexp.MatchString(from) || !literalPathRegexp.MatchString(to) {
+		return "", failed
+	}
+	return joinArgs("http-request set-path", fmt.Sprintf("%%[path,regsub(^%s,%s)]", from, to), cond), nil
+}
+
+// rewriteSetPath converts `http-request set-path prefix%[path] [cond]` into
+// `reqrep ^([^\ :]*)\ (.*) \1\ prefix\2 [cond]` for HAProxy versions before 1.6.
+func rewriteSetPath(args string) (string, error) {
+	format, cond := splitFirstArg(args)
+	prefix := strings.TrimSuffix(format, "%[path]")
+	if !strings.HasSuffix(format, "%[path]") || !literalPathRegexp.MatchString(prefix) {
+		return "", maskAny(fmt.Errorf("cannot rewrite 'http-request set-path %s'", args))
+	}
+	return joinArgs("reqrep", reqrepMethodPrefix+"(.*)", reqrepMethodReplacement+prefix+`\2`, cond), nil
+}
+
+// splitFirstArg splits the given line into its first argument and the remainder.
+// Spaces escaped with a backslash are considered part of the argument.
+func splitFirstArg(s string) (string, string) {
+	s = strings.TrimLeft(s, " \t")
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++ // Skip escaped character
+		case ' ', '\t':
+			return s[:i], strings.TrimLeft(s[i:], " \t")
+		}
+	}
+	return s, ""
+}
+
+// joinArgs joins all non-empty arguments with a single space.
+func joinArgs(args ...string) string {
+	var result []string
+	for _, a := range args {
+		if a != "" {
+			result = append(result, a)
+		}
+	}
+	return strings.Join(result, " ")
+}
@@ -0,0 +1,68 @@
+package haproxy
+
+import (
+	"testing"
+)
+
+type lintTest struct {
+	Version  string
+	Input    string
+	Expected string
+	Error    bool
+}
+
+var (
+	lintTests = []lintTest{
+		lintTest{
+			Version:  "1.7",
+			Input:    `    reqadd X-Forwarded-Port:\ %[dst_port]`,
+			Expected: `    reqadd X-Forwarded-Port:\ %[dst_port]`,
+		},
+		lintTest{
+			Version:  "2.4",
+			Input:    `    reqadd X-Forwarded-Proto:\ https if { ssl_fc }`,
+			Expected: `    http-request add-header X-Forwarded-Proto https if { ssl_fc }`,
+		},
+		lintTest{
+			Version:  "2.4",
+			Input:    `    reqrep ^([^\ :]*)\ /api/(.*)     \1\ /\2  if acl1 acl2`,
+			Expected: `    http-request set-path %[path,regsub(^/api/,/)] if acl1 acl2`,
+		},
+		lintTest{
+			Version: "2.4",
+			Input:   `    reqrep ^Host:\ (.*) Host:\ foo`,
+			Error:   true,
+		},
+		lintTest{
+			Version: "2.4",
+			Input:   `    rspadd X-Foo:\ bar`,
+			Error:   true,
+		},
+		lintTest{
+			Version:  "HA-Proxy version 1.5.18 2016/05/10",
+			Input:    `    http-request set-path /api%[path] if acl1`,
+			Expected: `    reqrep ^([^\ :]*)\ (.*) \1\ /api\2 if acl1`,
+		},
+	}
+)
+
+func TestLint(t *testing.T) {
+	for _, test := range lintTests {
+		version, err := ParseVersion(test.Version)
+		if err != nil {
+			t.Fatalf("Cannot parse version %s: %#v", test.Version, err)
+		}
+		result, _, err := Lint(test.Input, version)
+		if test.Error {
+			if err == nil {
+				t.Errorf("Expected error for `%s` on %s, got none", test.Input, version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for `%s` on %s: %#v", test.Input, version, err)
+		} else if result != test.Expected {
+			t.Errorf("Lint of `%s` on %s: expected `%s` got `%s`", test.Input, version, test.Expected, result)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package haproxy
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	runtimeTimeout   = time.Second * 5
+	runtimeBatchSize = 100 // Maximum number of commands sent at once
+
+	// Fields of the `show stat` CSV output
+	statCurrentSessionsField = 4
+	statTotalSessionsField   = 7
+	statBytesInField         = 8
+	statBytesOutField        = 9
+	statTypeField            = 32
+	statTotalRequestsField   = 48
+	statTypeFrontend         = "0"
+	statTypeBackend          = "1"
+)
+
+var (
+	intervalRegexp = regexp.MustCompile(`^([0-9]+)(us|ms|s|m|h|d)?$`)
+	// Matches the response of `prepare acl`
+	newACLVersionRegexp = regexp.MustCompile(`New version created: ([0-9]+)`)
+)
+
+// RuntimeClient sends commands to the runtime API (stats socket) of HAProxy.
+type RuntimeClient struct {
+	SocketPath string // Path of the unix socket configured with `stats socket <path> level admin`
+}
+
+// Execute sends a single command to HAProxy and returns its response.
+func (c RuntimeClient) Execute(command string) (string, error) {
+	conn, err := net.DialTimeout("unix", c.SocketPath, runtimeTimeout)
+	if err != nil {
+		return "", maskAny(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(runtimeTimeout))
+	if _, err := conn.Write([]byte(command + "\n")); err != nil {
+		return "", maskAny(err)
+	}
+	response, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return "", maskAny(err)
+	}
+	return strings.TrimSpace(string(response)), nil
+}
+
+// SetServerState puts the given server in ready (up) or maintenance (down) state.
+func (c RuntimeClient) SetServerState(backend, server string, up bool) error {
+	state := "maint"
+	if up {
+		state = "ready"
+	}
+	return maskAny(c.setServerState(backend, server, state))
+}
+
+// SetServerDrain puts the given server in drain state (no new sessions) or back in ready state.
+func (c RuntimeClient) SetServerDrain(backend, server string, drain bool) error {
+	state := "ready"
+	if drain {
+		state = "drain"
+	}
+	return maskAny(c.setServerState(backend, server, state))
+}
+
+func (c RuntimeClient) setServerState(backend, server, state string) error {
+	response, err := c.Execute(fmt.Sprintf("set server %s/%s state %s", backend, server, state))
+	if err != nil {
+		return maskAny(err)
+	}
+	// HAProxy responds with an empty line on success
+	if response != "" {
+		return maskAny(fmt.Errorf("set server %s/%s failed: %s", backend, server, response))
+	}
+	return nil
+}
+
+// CurrentSessions returns the number of sessions that are currently active on all frontends.
+func (c RuntimeClient) CurrentSessions() (int, error) {
+	rows, err := c.showStat()
+	if err != nil {
+		return 0, maskAny(err)
+	}
+	total := 0
+	for _, row := range rows {
+		if len(row) <= statTypeField || row[statTypeField] != statTypeFrontend {
+			continue
+		}
+		scur, err := strconv.Atoi(row[statCurrentSessionsField])
+		if err != nil {
+			return 0, maskAny(err)
+		}
+		total += scur
+	}
+	return total, nil
+}
+
+// BackendStat holds the cumulative traffic counters of a backend since HAProxy started.
+type BackendStat struct {
+	Name     string
+	Requests int64 // HTTP requests (sessions for tcp backends)
+	BytesIn  int64
+	BytesOut int64
+}
+
+// BackendStats returns the traffic counters of all backends.
+func (c RuntimeClient) BackendStats() ([]BackendStat, error) {
+	rows, err := c.showStat()
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return parseBackendStats(rows), nil
+}
+
+func parseBackendStats(rows [][]string) []BackendStat {
+	field := func(row []string, index int) int64 {
+		if index >= len(row) {
+			return 0
+		}
+		value, _ := strconv.ParseInt(row[index], 10, 64)
+		return value
+	}
+	var result []BackendStat
+	for _, row := range rows {
+		if len(row) <= statTypeField || row[statTypeField] != statTypeBackend {
+			continue
+		}
+		stat := BackendStat{
+			Name:     row[0],
+			Requests: field(row, statTotalRequestsField),
+			BytesIn:  field(row, statBytesInField),
+			BytesOut: field(row, statBytesOutField),
+		}
+		if stat.Requests == 0 {
+			// Not an HTTP backend
+			stat.Requests = field(row, statTotalSessionsField)
+		}
+		result = append(result, stat)
+	}
+	return result
+}
+
+// showStat returns the rows of the `show stat` CSV output.
+func (c RuntimeClient) showStat() ([][]string, error) {
+	response, err := c.Execute("show stat")
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	reader := csv.NewReader(strings.NewReader(response))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return rows, nil
+}
+
+// TableEntry is an entry of a stick table.
+type TableEntry struct {
+	Key    string
+	Values map[string]int64 // Stored data (e.g. http_req_rate) by name, without period
+}
+
+// ShowTable returns all entries of the stick table with given name.
+// If the table does not exist, no entries are returned.
+func (c RuntimeClient) ShowTable(name string) ([]TableEntry, error) {
+	response, err := c.Execute(fmt.Sprintf("show table %s", name))
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if strings.HasPrefix(response, "No such table") {
+		return nil, nil
+	}
+	return parseTable(response), nil
+}
+
+// SetTableEntry sets the given data type of the entry with given key in a stick table,
+// creating the entry if needed.
+func (c RuntimeClient) SetTableEntry(name, key, dataType string, value int64) error {
+	return maskAny(c.executeNoResponse(fmt.Sprintf("set table %s key %s data.%s %d", name, key, dataType, value)))
+}
+
+// ClearTableEntry removes the entry with given key from a stick table.
+func (c RuntimeClient) ClearTableEntry(name, key string) error {
+	return maskAny(c.executeNoResponse(fmt.Sprintf("clear table %s key %s", name, key)))
+}
+
+// ReplaceACL atomically replaces all patterns of the ACL loaded from the file with given path.
+// The patterns are added to a new version of the ACL, which is only used once all patterns
+// have been added. This requires HAProxy 2.4 or later, use SyncACL for older versions.
+func (c RuntimeClient) ReplaceACL(path string, patterns []string) error {
+	response, err := c.Execute(fmt.Sprintf("prepare acl %s", path))
+	if err != nil {
+		return maskAny(err)
+	}
+	m := newACLVersionRegexp.FindStringSubmatch(response)
+	if m == nil {
+		return maskAny(fmt.Errorf("prepare acl %s failed: %s", path, response))
+	}
+	version := m[1]
+	if err := c.executeBatched(patterns, func(pattern string) string {
+		return fmt.Sprintf("add acl @%s %s %s", version, path, pattern)
+	}); err != nil {
+		return maskAny(err)
+	}
+	return maskAny(c.executeNoResponse(fmt.Sprintf("commit acl @%s %s", version, path)))
+}
+
+// SyncACL updates the patterns of the ACL loaded from the file with given path to the given patterns.
+// Missing patterns are added before stale patterns are removed, so the ACL never lacks
+// a pattern that is in both the old and the new list.
+func (c RuntimeClient) SyncACL(path string, patterns []string) error {
+	response, err := c.Execute(fmt.Sprintf("show acl %s", path))
+	if err != nil {
+		return maskAny(err)
+	}
+	current := parseACLPatterns(response)
+	wanted := make(map[string]struct{})
+	var added []string
+	for _, pattern := range patterns {
+		wanted[pattern] = struct{}{}
+		if _, ok := current[pattern]; !ok {
+			added = append(added, pattern)
+		}
+	}
+	var removed []string
+	for pattern := range current {
+		if _, ok := wanted[pattern]; !ok {
+			removed = append(removed, pattern)
+		}
+	}
+	sort.Strings(removed)
+	if err := c.executeBatched(added, func(pattern string) string {
+		return fmt.Sprintf("add acl %s %s", path, pattern)
+	}); err != nil {
+		return maskAny(err)
+	}
+	return maskAny(c.executeBatched(removed, func(pattern string) string {
+		return fmt.Sprintf("del acl %s %s", path, pattern)
+	}))
+}
+
+// parseACLPatterns parses the response of a `show acl <path>` command.
+// Entries look like `0x55d1c3c2a0e0 192.0.2.0/24`.
+func parseACLPatterns(response string) map[string]struct{} {
+	result := make(map[string]struct{})
+	for _, line := range strings.Split(response, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			result[fields[1]] = struct{}{}
+		}
+	}
+	return result
+}
+
+// executeBatched executes the commands created for the given arguments in batches
+// to limit the number of connections.
+func (c RuntimeClient) executeBatched(args []string, command func(string) string) error {
+	for start := 0; start < len(args); start += runtimeBatchSize {
+		end := start + runtimeBatchSize
+		if end > len(args) {
+			end = len(args)
+		}
+		commands := make([]string, 0, end-start)
+		for _, arg := range args[start:end] {
+			commands = append(commands, command(arg))
+		}
+		if err := c.executeNoResponse(strings.Join(commands, ";")); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// executeNoResponse executes a command for which HAProxy responds with an empty line on success.
+func (c RuntimeClient) executeNoResponse(command string) error {
+	response, err := c.Execute(command)
+	if err != nil {
+		return maskAny(err)
+	}
+	if response != "" {
+		return maskAny(fmt.Errorf("%s failed: %s", command, response))
+	}
+	return nil
+}
+
+// parseTable parses the response of a `show table <name>` command.
+// Entries look like `0x55d1c3c2a0e0: key=foo.com use=0 exp=86399000 http_req_rate(86400000)=5`.
+func parseTable(response string) []TableEntry {
+	var result []TableEntry
+	for _, line := range strings.Split(response, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry := TableEntry{Values: make(map[string]int64)}
+		for _, field := range strings.Fields(line) {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			if parts[0] == "key" {
+				entry.Key = parts[1]
+				continue
+			}
+			name := parts[0]
+			if i := strings.Index(name, "("); i > 0 {
+				name = name[:i]
+			}
+			if value, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+				entry.Values[name] = value
+			}
+		}
+		if entry.Key != "" {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// ParseInterval parses a time value in HAProxy format (number with optional unit, default ms).
+func ParseInterval(s string) (time.Duration, error) {
+	m := intervalRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return 0, maskAny(fmt.Errorf("Invalid interval '%s'", s))
+	}
+	value, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, maskAny(err)
+	}
+	unit := map[string]time.Duration{
+		"":   time.Millisecond,
+		"us": time.Microsecond,
+		"ms": time.Millisecond,
+		"s":  time.Second,
+		"m":  time.Minute,
+		"h":  time.Hour,
+		"d":  time.Hour * 24,
+	}[m[2]]
+	return time.Duration(value) * unit, nil
+}
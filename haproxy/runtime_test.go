@@ -0,0 +1,172 @@
+package haproxy
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTable(t *testing.T) {
+	response := `# table: quota_domains, type: string, size:102400, used:2
+0x55d1c3c2a0e0: key=foo.com use=0 exp=86399000 http_req_rate(86400000)=5 bytes_out_rate(86400000)=1234
+0x55d1c3c2a1f0: key=bar.com use=1 exp=86000000 http_req_rate(86400000)=17 bytes_out_rate(86400000)=0`
+	entries := parseTable(response)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Key != "foo.com" || entries[0].Values["http_req_rate"] != 5 || entries[0].Values["bytes_out_rate"] != 1234 {
+		t.Errorf("Unexpected first entry %#v", entries[0])
+	}
+	if entries[1].Key != "bar.com" || entries[1].Values["http_req_rate"] != 17 || entries[1].Values["use"] != 1 {
+		t.Errorf("Unexpected second entry %#v", entries[1])
+	}
+}
+
+// fakeACLSocket serves a minimal HAProxy runtime API that supports the ACL commands
+// on a single ACL. It returns the socket path & a function that returns the current patterns.
+func fakeACLSocket(t *testing.T, initial []string) (string, func() []string, func()) {
+	dir, err := ioutil.TempDir("", "runtime")
+	if err != nil {
+		t.Fatalf("TempDir failed: %#v", err)
+	}
+	socketPath := filepath.Join(dir, "haproxy.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Listen failed: %#v", err)
+	}
+	var mutex sync.Mutex
+	current := append([]string{}, initial...)
+	var prepared []string
+	patterns := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		result := append([]string{}, current...)
+		sort.Strings(result)
+		return result
+	}
+	handle := func(command string) string {
+		fields := strings.Fields(command)
+		switch {
+		case len(fields) == 3 && fields[0] == "show" && fields[1] == "acl":
+			lines := []string{}
+			for i, p := range current {
+				lines = append(lines, fmt.Sprintf("0x%x %s", i, p))
+			}
+			return strings.Join(lines, "\n")
+		case len(fields) == 3 && fields[0] == "prepare":
+			prepared = []string{}
+			return "New version created: 2"
+		case len(fields) == 5 && fields[0] == "add" && fields[2] == "@2":
+			prepared = append(prepared, fields[4])
+		case len(fields) == 4 && fields[0] == "add":
+			current = append(current, fields[3])
+		case len(fields) == 4 && fields[0] == "del":
+			for i, p := range current {
+				if p == fields[3] {
+					current = append(current[:i], current[i+1:]...)
+					break
+				}
+			}
+		case len(fields) == 4 && fields[0] == "commit" && fields[2] == "@2":
+			current = prepared
+		default:
+			return "Unknown command"
+		}
+		return ""
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			request, _ := bufio.NewReader(conn).ReadString('\n')
+			responses := []string{}
+			mutex.Lock()
+			for _, command := range strings.Split(strings.TrimSpace(request), ";") {
+				if response := handle(command); response != "" {
+					responses = append(responses, response)
+				}
+			}
+			mutex.Unlock()
+			conn.Write([]byte(strings.Join(responses, "\n") + "\n"))
+			conn.Close()
+		}
+	}()
+	return socketPath, patterns, func() {
+		listener.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestReplaceACL(t *testing.T) {
+	socketPath, patterns, cleanup := fakeACLSocket(t, []string{"10.0.0.1", "10.0.0.2"})
+	defer cleanup()
+	c := RuntimeClient{SocketPath: socketPath}
+	wanted := []string{}
+	for i := 0; i < runtimeBatchSize+10; i++ {
+		wanted = append(wanted, fmt.Sprintf("192.0.2.%d", i))
+	}
+	if err := c.ReplaceACL("/data/blocklist.acl", wanted); err != nil {
+		t.Fatalf("ReplaceACL failed: %#v", err)
+	}
+	sort.Strings(wanted)
+	if result := patterns(); !reflect.DeepEqual(result, wanted) {
+		t.Errorf("Expected %v, got %v", wanted, result)
+	}
+}
+
+func TestSyncACL(t *testing.T) {
+	socketPath, patterns, cleanup := fakeACLSocket(t, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"})
+	defer cleanup()
+	c := RuntimeClient{SocketPath: socketPath}
+	wanted := []string{"10.0.0.2", "10.0.0.4", "192.0.2.0/24"}
+	if err := c.SyncACL("/data/blocklist.acl", wanted); err != nil {
+		t.Fatalf("SyncACL failed: %#v", err)
+	}
+	if result := patterns(); !reflect.DeepEqual(result, wanted) {
+		t.Errorf("Expected %v, got %v", wanted, result)
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	tests := []struct {
+		Input    string
+		Expected time.Duration
+		Valid    bool
+	}{
+		{"500", time.Millisecond * 500, true},
+		{"250us", time.Microsecond * 250, true},
+		{"100ms", time.Millisecond * 100, true},
+		{"10s", time.Second * 10, true},
+		{"5m", time.Minute * 5, true},
+		{"2h", time.Hour * 2, true},
+		{"1d", time.Hour * 24, true},
+		{"", 0, false},
+		{"1.5s", 0, false},
+		{"-1s", 0, false},
+		{"10 s", 0, false},
+		{"10w", 0, false},
+	}
+	for _, test := range tests {
+		d, err := ParseInterval(test.Input)
+		if !test.Valid {
+			if err == nil {
+				t.Errorf("Expected error for '%s', got %s", test.Input, d)
+			}
+		} else if err != nil {
+			t.Errorf("Expected success for '%s', got %#v", test.Input, err)
+		} else if d != test.Expected {
+			t.Errorf("Expected %s for '%s', got %s", test.Expected, test.Input, d)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package haproxy
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+var (
+	versionRegexp = regexp.MustCompile(`(\d+)\.(\d+)(\.\d+)?`)
+)
+
+// Version holds the major & minor version of HAProxy.
+// The zero value means "unknown".
+type Version struct {
+	Major int
+	Minor int
+}
+
+// ParseVersion parses a version string like "1.7", "2.4.3" or the output
+// of `haproxy -v` ("HA-Proxy version 1.7.5 2017/04/03").
+func ParseVersion(s string) (Version, error) {
+	m := versionRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return Version{}, maskAny(fmt.Errorf("Invalid HAProxy version '%s'", s))
+	}
+	major, err := strconv.Atoi(m[1])
+	if err != nil {
+		return Version{}, maskAny(err)
+	}
+	minor, err := strconv.Atoi(m[2])
+	if err != nil {
+		return Version{}, maskAny(err)
+	}
+	return Version{Major: major, Minor: minor}, nil
+}
+
+// DetectVersion runs the given haproxy executable to find its version.
+func DetectVersion(haproxyPath string) (Version, error) {
+	output, err := exec.Command(haproxyPath, "-v").CombinedOutput()
+	if err != nil {
+		return Version{}, maskAny(err)
+	}
+	v, err := ParseVersion(string(output))
+	if err != nil {
+		return Version{}, maskAny(err)
+	}
+	return v, nil
+}
+
+// IsUnknown returns true if the version has not been set.
+func (v Version) IsUnknown() bool {
+	return v.Major == 0 && v.Minor == 0
+}
+
+// AtLeast returns true if the version is equal to or higher than the given major.minor.
+func (v Version) AtLeast(major, minor int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	return v.Minor >= minor
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	api "github.com/pulcy/robin-api"
+)
+
+const (
+	// Labels of compose services that describe how a service is exposed by Robin
+	composeDomainLabel     = "robin.domain"      // Comma separated list of domains
+	composePathPrefixLabel = "robin.path-prefix" // Path prefix to match on
+	composePortLabel       = "robin.port"        // Port of the service (defaults to the first exposed port)
+	composePrivateLabel    = "robin.private"     // If "true", the service is only exposed on the private network
+	composeSslCertLabel    = "robin.ssl-cert"    // Name of the SSL certificate
+	composeModeLabel       = "robin.mode"        // http|tcp
+)
+
+// composeFile holds the parts of a docker-compose file that are relevant for frontend records.
+type composeFile struct {
+	Services map[string]composeService `json:"services"`
+}
+
+type composeService struct {
+	Labels composeLabels   `json:"labels,omitempty"`
+	Ports  []composeString `json:"ports,omitempty"`
+	Expose []composeString `json:"expose,omitempty"`
+}
+
+// composeLabels accepts labels in both the map and the list (key=value) notation.
+type composeLabels map[string]string
+
+func (l *composeLabels) UnmarshalJSON(data []byte) error {
+	var m map[string]string
+	if err := json.Unmarshal(data, &m); err == nil {
+		*l = m
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return maskAny(err)
+	}
+	*l = make(map[string]string)
+	for _, entry := range list {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) == 2 {
+			(*l)[parts[0]] = parts[1]
+		} else {
+			(*l)[parts[0]] = ""
+		}
+	}
+	return nil
+}
+
+// composeString accepts both strings and numbers (ports can be written as either).
+type composeString string
+
+func (s *composeString) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*s = composeString(str)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return maskAny(err)
+	}
+	*s = composeString(n.String())
+	return nil
+}
+
+// ImportCompose creates frontend records for all services in the given (YAML or JSON) docker-compose file
+// that have a `robin.domain` or `robin.path-prefix` label.
+func ImportCompose(data []byte) (map[string]api.FrontendRecord, error) {
+	docs, err := yamlDocuments(data)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if len(docs) != 1 {
+		return nil, maskAny(fmt.Errorf("expected a single document, got %d", len(docs)))
+	}
+	var file composeFile
+	if err := json.Unmarshal(docs[0], &file); err != nil {
+		return nil, maskAny(err)
+	}
+	names := make([]string, 0, len(file.Services))
+	for name := range file.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make(map[string]api.FrontendRecord)
+	for _, name := range names {
+		svc := file.Services[name]
+		domains := svc.Labels[composeDomainLabel]
+		pathPrefix := svc.Labels[composePathPrefixLabel]
+		if domains == "" && pathPrefix == "" {
+			continue
+		}
+		port, err := composeServicePort(svc)
+		if err != nil {
+			return nil, maskAny(fmt.Errorf("service %s: %v", name, err))
+		}
+		record := api.FrontendRecord{
+			Service: name,
+			Mode:    svc.Labels[composeModeLabel],
+		}
+		for _, domain := range strings.Split(domains, ",") {
+			record.Selectors = append(record.Selectors, api.FrontendSelectorRecord{
+				Domain:      strings.TrimSpace(domain),
+				PathPrefix:  pathPrefix,
+				SslCert:     svc.Labels[composeSslCertLabel],
+				ServicePort: port,
+				Private:     svc.Labels[composePrivateLabel] == "true",
+			})
+		}
+		if err := record.Validate(); err != nil {
+			return nil, maskAny(fmt.Errorf("service %s results in an invalid record: %v", name, err))
+		}
+		result[name] = record
+	}
+	return result, nil
+}
+
+// composeServicePort returns the port of the given service, taken from its `robin.port` label,
+// or its first exposed port.
+func composeServicePort(svc composeService) (int, error) {
+	raw := svc.Labels[composePortLabel]
+	if raw == "" {
+		// Ports are formatted as [[ip:]host-port:]container-port[/protocol]
+		var candidates []composeString
+		candidates = append(candidates, svc.Ports...)
+		candidates = append(candidates, svc.Expose...)
+		if len(candidates) == 0 {
+			return 0, maskAny(fmt.Errorf("no %s label and no ports", composePortLabel))
+		}
+		raw = string(candidates[0])
+		if i := strings.LastIndex(raw, ":"); i >= 0 {
+			raw = raw[i+1:]
+		}
+		raw = strings.SplitN(raw, "/", 2)[0]
+	}
+	port, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, maskAny(fmt.Errorf("invalid port '%s'", raw))
+	}
+	return port, nil
+}
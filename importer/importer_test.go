@@ -0,0 +1,70 @@
+package importer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	api "github.com/pulcy/robin-api"
+)
+
+func TestImport(t *testing.T) {
+	composeExpected := map[string]api.FrontendRecord{
+		"api": api.FrontendRecord{
+			Service: "api",
+			Selectors: []api.FrontendSelectorRecord{
+				{Domain: "example.com", PathPrefix: "/api/", ServicePort: 5000, Private: true},
+			},
+		},
+		"web": api.FrontendRecord{
+			Service: "web",
+			Selectors: []api.FrontendSelectorRecord{
+				{Domain: "example.com", SslCert: "example.pem", ServicePort: 80},
+				{Domain: "www.example.com", SslCert: "example.pem", ServicePort: 80},
+			},
+		},
+	}
+	ingressExpected := map[string]api.FrontendRecord{
+		"shop-frontend-web-80": api.FrontendRecord{
+			Service: "web",
+			Mode:    "http",
+			Selectors: []api.FrontendSelectorRecord{
+				{Domain: "shop.example.com", ServicePort: 80},
+				{Domain: "www.shop.example.com", ServicePort: 80},
+			},
+		},
+		"shop-frontend-api-8080": api.FrontendRecord{
+			Service: "api",
+			Mode:    "http",
+			Selectors: []api.FrontendSelectorRecord{
+				{Domain: "shop.example.com", PathPrefix: "/api", ServicePort: 8080},
+			},
+		},
+	}
+	tests := []struct {
+		Path     string
+		Import   func([]byte) (map[string]api.FrontendRecord, error)
+		Expected map[string]api.FrontendRecord
+	}{
+		{Path: "./fixtures/compose.json", Import: ImportCompose, Expected: composeExpected},
+		{Path: "./fixtures/compose.yaml", Import: ImportCompose, Expected: composeExpected},
+		{Path: "./fixtures/ingress.json", Import: ImportKubernetes, Expected: ingressExpected},
+		{Path: "./fixtures/ingress.yaml", Import: ImportKubernetes, Expected: ingressExpected},
+	}
+	for _, test := range tests {
+		data, err := ioutil.ReadFile(test.Path)
+		if err != nil {
+			t.Fatalf("Cannot read %s: %#v", test.Path, err)
+		}
+		result, err := test.Import(data)
+		if err != nil {
+			t.Errorf("Import of %s failed: %#v", test.Path, err)
+			continue
+		}
+		expected, _ := json.Marshal(test.Expected)
+		actual, _ := json.Marshal(result)
+		if string(expected) != string(actual) {
+			t.Errorf("Unexpected import of %s: expected\n%s\ngot\n%s", test.Path, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	k8s "github.com/YakLabs/k8s-client"
+	api "github.com/pulcy/robin-api"
+
+	"github.com/pulcy/robin/service/backend"
+)
+
+// kubernetesObject is used to detect the kind of a kubernetes manifest.
+type kubernetesObject struct {
+	Kind  string            `json:"kind"`
+	Items []json.RawMessage `json:"items,omitempty"`
+}
+
+// ImportKubernetes creates frontend records for all Ingress resources in the given (YAML or JSON) manifest.
+// Every document of the manifest can be a single Ingress, or a List or IngressList containing Ingress resources.
+// Other resources are ignored.
+func ImportKubernetes(data []byte) (map[string]api.FrontendRecord, error) {
+	docs, err := yamlDocuments(data)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	result := make(map[string]api.FrontendRecord)
+	for _, doc := range docs {
+		if err := importKubernetesObject(doc, result); err != nil {
+			return nil, maskAny(err)
+		}
+	}
+	return result, nil
+}
+
+func importKubernetesObject(data []byte, result map[string]api.FrontendRecord) error {
+	var obj kubernetesObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return maskAny(err)
+	}
+	switch obj.Kind {
+	case "List", "IngressList":
+		for _, item := range obj.Items {
+			if err := importKubernetesObject(item, result); err != nil {
+				return maskAny(err)
+			}
+		}
+	case "Ingress":
+		var i k8s.Ingress
+		if err := json.Unmarshal(data, &i); err != nil {
+			return maskAny(err)
+		}
+		records, err := importIngress(i)
+		if err != nil {
+			return maskAny(err)
+		}
+		for id, record := range records {
+			result[id] = record
+		}
+	}
+	return nil
+}
+
+// importIngress creates frontend records for the given ingress, one per backend service (and port).
+// If the ingress has a frontend records annotation, those records are used as is.
+func importIngress(i k8s.Ingress) (map[string]api.FrontendRecord, error) {
+	result := make(map[string]api.FrontendRecord)
+	idPrefix := i.Name
+	if ns := i.GetNamespace(); ns != "" {
+		idPrefix = ns + "-" + i.Name
+	}
+	if raw, found := i.GetAnnotations()[backend.RobinFrontendRecordsAnnotationKey]; found {
+		var records []api.FrontendRecord
+		if err := json.Unmarshal([]byte(raw), &records); err != nil {
+			return nil, maskAny(err)
+		}
+		for index, record := range records {
+			result[fmt.Sprintf("%s-%d", idPrefix, index)] = record
+		}
+		return result, nil
+	}
+	if i.Spec == nil {
+		return result, nil
+	}
+	for _, rule := range i.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, httpPath := range rule.HTTP.Paths {
+			port := httpPath.Backend.ServicePort.IntValue()
+			if port == 0 {
+				return nil, maskAny(fmt.Errorf("ingress %s uses a named port for service %s, which cannot be imported", i.Name, httpPath.Backend.ServiceName))
+			}
+			id := fmt.Sprintf("%s-%s-%d", idPrefix, httpPath.Backend.ServiceName, port)
+			record, ok := result[id]
+			if !ok {
+				record = api.FrontendRecord{
+					Service: httpPath.Backend.ServiceName,
+					Mode:    "http",
+				}
+			}
+			selector := api.FrontendSelectorRecord{
+				Domain:      rule.Host,
+				ServicePort: port,
+			}
+			if strings.TrimPrefix(httpPath.Path, "/") != "" {
+				selector.PathPrefix = httpPath.Path
+			}
+			record.Selectors = append(record.Selectors, selector)
+			result[id] = record
+		}
+	}
+	for id, record := range result {
+		if err := record.Validate(); err != nil {
+			return nil, maskAny(fmt.Errorf("ingress %s results in an invalid record %s: %v", i.Name, id, err))
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// yamlDocuments splits the given YAML (or JSON) data into its documents and converts each of them to JSON.
+// Empty documents are skipped.
+func yamlDocuments(data []byte) ([][]byte, error) {
+	var docs [][]byte
+	var current bytes.Buffer
+	flush := func() error {
+		if strings.TrimSpace(current.String()) == "" {
+			current.Reset()
+			return nil
+		}
+		doc, err := yaml.YAMLToJSON(current.Bytes())
+		if err != nil {
+			return maskAny(err)
+		}
+		current.Reset()
+		docs = append(docs, doc)
+		return nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "---") && strings.TrimSpace(strings.TrimPrefix(line, "---")) == "" {
+			if err := flush(); err != nil {
+				return nil, maskAny(err)
+			}
+			continue
+		}
+		current.WriteString(line)
+		current.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, maskAny(err)
+	}
+	if err := flush(); err != nil {
+		return nil, maskAny(err)
+	}
+	return docs, nil
+}
@@ -28,6 +28,7 @@ const (
 	// pxname,svname,qcur,qmax,scur,smax,slim,stot,bin,bout,dreq,dresp,ereq,econ,eresp,wretr,wredis,status,weight,act,bck,chkfail,chkdown,lastchg,downtime,qlimit,pid,iid,sid,throttle,lbtot,tracked,type,rate,rate_lim,rate_max,check_status,check_code,check_duration,hrsp_1xx,hrsp_2xx,hrsp_3xx,hrsp_4xx,hrsp_5xx,hrsp_other,hanafail,req_rate,req_rate_max,req_tot,cli_abrt,srv_abrt,comp_in,comp_out,comp_byp,comp_rsp,lastsess,
 	expectedCsvFieldCount = 52
 	statusField           = 17
+	activeServersField    = 19
 )
 
 var (
@@ -104,6 +105,7 @@ var (
 		18: newServerMetric("weight", "Current weight of the server.", nil),
 		21: newServerMetric("check_failures_total", "Total number of failed health checks.", nil),
 		24: newServerMetric("downtime_seconds_total", "Total downtime in seconds.", nil),
+		25: newServerMetric("queue_limit", "Configured maximum number of queued requests assigned to this server.", nil),
 		33: newServerMetric("current_session_rate", "Current number of sessions per second over last elapsed second.", nil),
 		35: newServerMetric("max_session_rate", "Maximum observed number of sessions per second.", nil),
 		38: newServerMetric("check_duration_milliseconds", "Previously run health check duration, in milliseconds", nil),
@@ -113,19 +115,28 @@ var (
 		42: newServerMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "4xx"}),
 		43: newServerMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "5xx"}),
 		44: newServerMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "other"}),
+		58: newServerMetric("queue_time_average_milliseconds", "Average time spent in the queue over the last 1024 requests, in milliseconds.", nil),
 	}
 )
 
+// OldProcessesFunc returns the number of HAProxy processes replaced by a reload
+// and the number of server connections they still serve per backend.
+type OldProcessesFunc func() (int, map[string]int)
+
 // Exporter collects HAProxy stats from the given URI and exports them using
 // the prometheus metrics package.
 type Exporter struct {
 	Logger *logging.Logger
 
-	URI   string
-	mutex sync.RWMutex
-	fetch func() (io.ReadCloser, error)
+	URI          string
+	MinInstances func() map[string]int // If set, provides the minimum number of healthy servers per backend
+	OldProcesses OldProcessesFunc      // If set, provides the connections of HAProxy processes replaced by a reload
+	mutex        sync.RWMutex
+	fetch        func() (io.ReadCloser, error)
 
 	up                                             prometheus.Gauge
+	backendDegraded, backendOldConnections         *prometheus.GaugeVec
+	oldProcesses                                   prometheus.Gauge
 	totalScrapes, csvParseFailures                 prometheus.Counter
 	frontendMetrics, backendMetrics, serverMetrics map[int]*prometheus.GaugeVec
 }
@@ -166,6 +177,13 @@ func NewExporter(log *logging.Logger, uri string, selectedServerMetrics map[int]
 			Name:      "exporter_csv_parse_failures",
 			Help:      "Number of errors while parsing CSV.",
 		}),
+		oldProcesses: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "old_processes",
+			Help:      "Number of HAProxy processes replaced by a reload that are still running.",
+		}),
+		backendOldConnections: newBackendMetric("old_process_connections", "Number of server connections still served by HAProxy processes replaced by a reload.", nil),
+		backendDegraded:       newBackendMetric("degraded", "1 if the backend has fewer healthy servers than its configured minimum (the maintenance page is served), 0 otherwise.", nil),
 		frontendMetrics: map[int]*prometheus.GaugeVec{
 			4:  newFrontendMetric("current_sessions", "Current number of active sessions.", nil),
 			5:  newFrontendMetric("max_sessions", "Maximum observed number of active sessions.", nil),
@@ -201,6 +219,7 @@ func NewExporter(log *logging.Logger, uri string, selectedServerMetrics map[int]
 			16: newBackendMetric("redispatch_warnings_total", "Total of redispatch warnings.", nil),
 			17: newBackendMetric("up", "Current health status of the backend (1 = UP, 0 = DOWN).", nil),
 			18: newBackendMetric("weight", "Total weight of the servers in the backend.", nil),
+			19: newBackendMetric("active_servers", "Number of active servers that are up.", nil),
 			33: newBackendMetric("current_session_rate", "Current number of sessions per second over last elapsed second.", nil),
 			35: newBackendMetric("max_session_rate", "Maximum number of sessions per second.", nil),
 			39: newBackendMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "1xx"}),
@@ -209,6 +228,7 @@ func NewExporter(log *logging.Logger, uri string, selectedServerMetrics map[int]
 			42: newBackendMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "4xx"}),
 			43: newBackendMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "5xx"}),
 			44: newBackendMetric("http_responses_total", "Total of HTTP responses.", prometheus.Labels{"code": "other"}),
+			58: newBackendMetric("queue_time_average_milliseconds", "Average time spent in the queue over the last 1024 requests, in milliseconds.", nil),
 		},
 		serverMetrics: selectedServerMetrics,
 	}, nil
@@ -223,12 +243,15 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	for _, m := range e.backendMetrics {
 		m.Describe(ch)
 	}
+	e.backendDegraded.Describe(ch)
+	e.backendOldConnections.Describe(ch)
 	for _, m := range e.serverMetrics {
 		m.Describe(ch)
 	}
 	ch <- e.up.Desc()
 	ch <- e.totalScrapes.Desc()
 	ch <- e.csvParseFailures.Desc()
+	ch <- e.oldProcesses.Desc()
 }
 
 // Collect fetches the stats from configured HAProxy location and delivers them
@@ -239,10 +262,12 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 
 	e.resetMetrics()
 	e.scrape()
+	e.exportOldProcesses()
 
 	ch <- e.up
 	ch <- e.totalScrapes
 	ch <- e.csvParseFailures
+	ch <- e.oldProcesses
 	e.collectMetrics(ch)
 }
 
@@ -331,6 +356,8 @@ func (e *Exporter) resetMetrics() {
 	for _, m := range e.backendMetrics {
 		m.Reset()
 	}
+	e.backendDegraded.Reset()
+	e.backendOldConnections.Reset()
 	for _, m := range e.serverMetrics {
 		m.Reset()
 	}
@@ -343,6 +370,8 @@ func (e *Exporter) collectMetrics(metrics chan<- prometheus.Metric) {
 	for _, m := range e.backendMetrics {
 		m.Collect(metrics)
 	}
+	e.backendDegraded.Collect(metrics)
+	e.backendOldConnections.Collect(metrics)
 	for _, m := range e.serverMetrics {
 		m.Collect(metrics)
 	}
@@ -369,11 +398,46 @@ func (e *Exporter) parseRow(csvRow []string) {
 		e.exportCsvFields(e.frontendMetrics, csvRow, pxname)
 	case backend:
 		e.exportCsvFields(e.backendMetrics, csvRow, pxname)
+		e.exportDegraded(csvRow, pxname)
 	case server:
 		e.exportCsvFields(e.serverMetrics, csvRow, pxname, svname)
 	}
 }
 
+// exportDegraded sets the degraded state of the given backend, if it has a minimum number of healthy servers.
+func (e *Exporter) exportDegraded(csvRow []string, backend string) {
+	if e.MinInstances == nil {
+		return
+	}
+	minInstances := e.MinInstances()[backend]
+	if minInstances == 0 {
+		return
+	}
+	active, err := strconv.Atoi(csvRow[activeServersField])
+	if err != nil {
+		e.Logger.Errorf("Can't parse CSV field value %s: %v", csvRow[activeServersField], err)
+		e.csvParseFailures.Inc()
+		return
+	}
+	degraded := 0.0
+	if active < minInstances {
+		degraded = 1
+	}
+	e.backendDegraded.WithLabelValues(backend).Set(degraded)
+}
+
+// exportOldProcesses sets the number of connections still served by HAProxy processes replaced by a reload.
+func (e *Exporter) exportOldProcesses() {
+	if e.OldProcesses == nil {
+		return
+	}
+	processes, connections := e.OldProcesses()
+	e.oldProcesses.Set(float64(processes))
+	for backend, count := range connections {
+		e.backendOldConnections.WithLabelValues(backend).Set(float64(count))
+	}
+}
+
 func parseStatusField(value string) int64 {
 	switch value {
 	case "UP", "UP 1/3", "UP 2/3", "OPEN", "no check":
@@ -386,6 +450,10 @@ func parseStatusField(value string) int64 {
 
 func (e *Exporter) exportCsvFields(metrics map[int]*prometheus.GaugeVec, csvRow []string, labels ...string) {
 	for fieldIdx, metric := range metrics {
+		if fieldIdx >= len(csvRow) {
+			// Field not reported by this version of HAProxy
+			continue
+		}
 		valueStr := csvRow[fieldIdx]
 		if valueStr == "" {
 			continue
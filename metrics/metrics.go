@@ -3,12 +3,16 @@ package metrics
 import (
 	"fmt"
 	"net/http"
+	"net/http/pprof"
+	"os"
 	"time"
 
 	"github.com/op/go-logging"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/pulcy/macaron-utils"
 	"gopkg.in/macaron.v1"
+
+	"github.com/pulcy/robin/middleware/compression"
 )
 
 const (
@@ -23,6 +27,13 @@ type MetricsConfig struct {
 	Host          string
 	Port          int
 	HaproxyCSVURI string
+	TlsLogAddress string                // If set, HAProxy TLS logs are received on this (UDP) address
+	MinInstances  func() map[string]int // If set, provides the minimum number of healthy servers per backend
+	OldProcesses  OldProcessesFunc      // If set, provides the connections of HAProxy processes replaced by a reload
+	Compress      bool                  // If set, responses are gzip compressed for clients that accept it
+
+	RuntimeMetrics bool // If set, Go runtime & process metrics are served
+	Pprof          bool // If set, Go runtime profiling data is served under /debug/pprof/
 }
 
 func StartMetricsListener(config MetricsConfig, log *logging.Logger) error {
@@ -31,15 +42,32 @@ func StartMetricsListener(config MetricsConfig, log *logging.Logger) error {
 		if err != nil {
 			return maskAny(err)
 		}
+		exporter.MinInstances = config.MinInstances
+		exporter.OldProcesses = config.OldProcesses
 		prometheus.MustRegister(exporter)
 	} else {
 		log.Info("Skipping HAProxy CSV stats: no HaproxyCSVURI configured")
 	}
 
-	handler, err := setupMetricsRoutes(config.ProjectName, config.ProjectVersion, config.ProjectBuild)
+	if config.TlsLogAddress != "" {
+		if err := StartTLSStatsListener(log, config.TlsLogAddress); err != nil {
+			return maskAny(err)
+		}
+	}
+
+	if config.RuntimeMetrics {
+		if err := registerRuntimeMetrics(); err != nil {
+			return maskAny(err)
+		}
+	}
+
+	handler, err := setupMetricsRoutes(config)
 	if err != nil {
 		return maskAny(fmt.Errorf("Failed to setup metrics routes: %#v", err))
 	}
+	if config.Compress {
+		handler = compression.Handler(handler)
+	}
 
 	addr := fmt.Sprintf("%s:%d", config.Host, config.Port)
 
@@ -53,8 +81,25 @@ func StartMetricsListener(config MetricsConfig, log *logging.Logger) error {
 	return nil
 }
 
+// registerRuntimeMetrics registers the collectors of Go runtime & process metrics in the default registry.
+// Collectors that are already registered are ignored.
+func registerRuntimeMetrics() error {
+	collectors := []prometheus.Collector{
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(os.Getpid(), ""),
+	}
+	for _, c := range collectors {
+		if err := prometheus.Register(c); err != nil {
+			if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+				return maskAny(err)
+			}
+		}
+	}
+	return nil
+}
+
 // setupMetricsRoutes prepares all routes for reading metrics.
-func setupMetricsRoutes(projectName, projectVersion, projectBuild string) (http.Handler, error) {
+func setupMetricsRoutes(config MetricsConfig) (http.Handler, error) {
 	m := macaron.New()
 	m.Use(macaron.Recovery())
 	m.Use(macaron.Renderer(macaron.RenderOptions{
@@ -65,11 +110,21 @@ func setupMetricsRoutes(projectName, projectVersion, projectBuild string) (http.
 	}))
 
 	m.SetAutoHead(true)
-	m.Get("/", utils.ServerInfo(projectName, projectVersion, projectBuild))
+	m.Get("/", utils.ServerInfo(config.ProjectName, config.ProjectVersion, config.ProjectBuild))
 	m.Get("/metrics", prometheus.Handler())
 	/*m.Get("/rules", func(ctx *macaron.Context) {
 		ctx.ServeFileContent("./rules.txt")
 	})*/
 
-	return m, nil
+	if !config.Pprof {
+		return m, nil
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/", m)
+	return mux, nil
 }
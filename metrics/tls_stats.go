@@ -0,0 +1,145 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	tlsLogPrefix        = "tls sni="
+	tlsNoSNI            = "none"
+	maxSyslogPacketSize = 8192
+	readErrorDelay      = time.Second
+)
+
+var (
+	tlsHandshakesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tls_handshakes_total",
+			Help:      "Total number of successful TLS handshakes per SNI domain, protocol, cipher & session resumption.",
+		},
+		[]string{"domain", "protocol", "cipher", "resumed"},
+	)
+	tlsHandshakeFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tls_handshake_failures_total",
+			Help:      "Total number of failed TLS handshakes per frontend.",
+		},
+		[]string{"frontend"},
+	)
+
+	// Matches HAProxy connection error logs like:
+	// 1.2.3.4:5678 [15/Oct/2016:12:00:00.123] secure-public_http_in_80/1: SSL handshake failure
+	handshakeFailureRegexp = regexp.MustCompile(`\] ([^/ ]+)/[^:]*: SSL handshake failure`)
+)
+
+// tlsLogEntry holds the TLS related information parsed from a single HAProxy log message.
+type tlsLogEntry struct {
+	Domain   string
+	Protocol string
+	Cipher   string
+	Resumed  bool
+	Frontend string // Only set for handshake failures
+	Failure  bool
+}
+
+// StartTLSStatsListener listens for HAProxy syslog messages (UDP) on the given address and
+// turns TLS connection logs into prometheus metrics.
+func StartTLSStatsListener(log *logging.Logger, address string) error {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return maskAny(err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return maskAny(err)
+	}
+	prometheus.MustRegister(tlsHandshakesTotal)
+	prometheus.MustRegister(tlsHandshakeFailuresTotal)
+
+	log.Infof("Listening for HAProxy TLS logs on %s", address)
+	go func() {
+		defer conn.Close()
+		buf := make([]byte, maxSyslogPacketSize)
+		for {
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				log.Errorf("Failed to read HAProxy log message: %#v", err)
+				time.Sleep(readErrorDelay)
+				continue
+			}
+			entry, ok := parseTLSLogMessage(string(buf[:n]))
+			if !ok {
+				continue
+			}
+			if entry.Failure {
+				tlsHandshakeFailuresTotal.WithLabelValues(entry.Frontend).Inc()
+			} else {
+				tlsHandshakesTotal.WithLabelValues(entry.Domain, entry.Protocol, entry.Cipher, strconv.FormatBool(entry.Resumed)).Inc()
+			}
+		}
+	}()
+	return nil
+}
+
+// parseTLSLogMessage parses a syslog message send by HAProxy.
+// It returns false if the message does not contain TLS information or is logged for
+// a repeated request on an already counted connection.
+func parseTLSLogMessage(msg string) (tlsLogEntry, bool) {
+	msg = strings.TrimSpace(msg)
+	if idx := strings.Index(msg, tlsLogPrefix); idx >= 0 {
+		entry := tlsLogEntry{}
+		repeat := false
+		for _, field := range strings.Fields(msg[idx+len("tls "):]) {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			switch parts[0] {
+			case "sni":
+				entry.Domain = strings.ToLower(parts[1])
+			case "protocol":
+				entry.Protocol = parts[1]
+			case "cipher":
+				entry.Cipher = parts[1]
+			case "resumed":
+				entry.Resumed = parts[1] == "1"
+			case "repeat":
+				repeat = parts[1] == "1"
+			}
+		}
+		if repeat {
+			return tlsLogEntry{}, false
+		}
+		if entry.Domain == "" || entry.Domain == "-" {
+			entry.Domain = tlsNoSNI
+		}
+		return entry, true
+	}
+	if m := handshakeFailureRegexp.FindStringSubmatch(msg); m != nil {
+		return tlsLogEntry{Frontend: m[1], Failure: true}, true
+	}
+	return tlsLogEntry{}, false
+}
@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"testing"
+)
+
+func TestParseTLSLogMessage(t *testing.T) {
+	tests := []struct {
+		Message  string
+		Expected tlsLogEntry
+		Ok       bool
+	}{
+		{
+			Message:  "<134>Oct 15 12:00:00 haproxy[1]: tls sni=Foo.com protocol=TLSv1.2 cipher=ECDHE-RSA-AES128-GCM-SHA256 resumed=0 repeat=-\n",
+			Expected: tlsLogEntry{Domain: "foo.com", Protocol: "TLSv1.2", Cipher: "ECDHE-RSA-AES128-GCM-SHA256"},
+			Ok:       true,
+		},
+		{
+			Message:  "<134>Oct 15 12:00:00 haproxy[1]: tls sni=- protocol=TLSv1.3 cipher=TLS_AES_256_GCM_SHA384 resumed=1 repeat=-",
+			Expected: tlsLogEntry{Domain: tlsNoSNI, Protocol: "TLSv1.3", Cipher: "TLS_AES_256_GCM_SHA384", Resumed: true},
+			Ok:       true,
+		},
+		{
+			// Access log line with TLS details
+			Message:  "<142>Oct 15 12:00:00 haproxy[1]: 1.2.3.4:5678 [15/Oct/2026:12:00:00.123] secure-public_http_in_80~ web/s1 0/0/1/2/3 200 512 - - ---- 1/1/0/0/0 0/0 \"GET / HTTP/1.1\" tls sni=foo.com protocol=TLSv1.2 cipher=AES128-SHA resumed=0 repeat=-",
+			Expected: tlsLogEntry{Domain: "foo.com", Protocol: "TLSv1.2", Cipher: "AES128-SHA"},
+			Ok:       true,
+		},
+		{
+			// Repeated request on a connection that has already been counted
+			Message: "<134>Oct 15 12:00:00 haproxy[1]: tls sni=foo.com protocol=TLSv1.2 cipher=AES128-SHA resumed=0 repeat=1",
+			Ok:      false,
+		},
+		{
+			Message:  "<134>Oct 15 12:00:00 haproxy[1]: 1.2.3.4:5678 [15/Oct/2016:12:00:00.123] secure-public_http_in_80/1: SSL handshake failure",
+			Expected: tlsLogEntry{Frontend: "secure-public_http_in_80", Failure: true},
+			Ok:       true,
+		},
+		{
+			Message: "<133>Oct 15 12:00:00 haproxy[1]: Proxy secure-public_http_in_80 started.",
+			Ok:      false,
+		},
+	}
+	for _, test := range tests {
+		entry, ok := parseTLSLogMessage(test.Message)
+		if ok != test.Ok {
+			t.Errorf("Expected ok=%v for '%s', got %v", test.Ok, test.Message, ok)
+		} else if entry != test.Expected {
+			t.Errorf("Expected %#v for '%s', got %#v", test.Expected, test.Message, entry)
+		}
+	}
+}
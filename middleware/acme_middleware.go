@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/pulcy/rest-kit"
+
+	"github.com/pulcy/robin/service/acme"
+)
+
+// AcmeStatus handles a GET /v1/acme/status request
+func (m *Middleware) AcmeStatus(res http.ResponseWriter, req *http.Request) error {
+	result := []acme.DomainRenewalStatus{}
+	if m.Renewal != nil {
+		result = m.Renewal.Status()
+	}
+	return restkit.JSON(res, result, http.StatusOK)
+}
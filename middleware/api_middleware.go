@@ -2,25 +2,51 @@ package middleware
 
 import (
 	"net/http"
+	"strconv"
 
+	"github.com/juju/errgo"
 	"github.com/pulcy/rest-kit"
 	api "github.com/pulcy/robin-api"
 	"gopkg.in/macaron.v1"
 )
 
-// All handles an API.All request
-func (m *Middleware) All(res http.ResponseWriter, req *http.Request) error {
-	result, err := m.Service.All()
+const (
+	ifMatchHeader = "If-Match"
+)
+
+// All handles an API.All request.
+// The result can be filtered (service, domain, mode, public, owner, label), paged (offset, limit)
+// and reduced to selected fields (fields) using query parameters.
+func (m *Middleware) All(svc api.API, res http.ResponseWriter, req *http.Request) error {
+	query, err := parseFrontendQuery(req.URL.Query())
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	all, err := svc.All()
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	result, total, err := query.Apply(all)
 	if err != nil {
 		return m.mapError(res, maskAny(err))
 	}
+	res.Header().Set(totalCountHeader, strconv.Itoa(total))
 	return restkit.JSON(res, result, http.StatusOK)
 }
 
-// Get handles an API.Get request
-func (m *Middleware) Get(ctx *macaron.Context, res http.ResponseWriter, req *http.Request) error {
+// Get handles an API.Get request.
+// If the service supports versioning, the version of the record is returned in an ETag header.
+func (m *Middleware) Get(ctx *macaron.Context, svc api.API, res http.ResponseWriter, req *http.Request) error {
 	id := ctx.Params("id")
-	result, err := m.Service.Get(id)
+	if vs, ok := svc.(api.VersionedAPI); ok {
+		result, version, err := vs.GetVersioned(id)
+		if err != nil {
+			return m.mapError(res, maskAny(err))
+		}
+		res.Header().Set("ETag", api.FormatETag(version))
+		return restkit.JSON(res, result, http.StatusOK)
+	}
+	result, err := svc.Get(id)
 	if err != nil {
 		return m.mapError(res, maskAny(err))
 	}
@@ -28,26 +54,57 @@ func (m *Middleware) Get(ctx *macaron.Context, res http.ResponseWriter, req *htt
 }
 
 // Add handles an API.Add request
-func (m *Middleware) Add(ctx *macaron.Context, res http.ResponseWriter, req *http.Request) error {
+func (m *Middleware) Add(ctx *macaron.Context, svc api.API, res http.ResponseWriter, req *http.Request) error {
+	id := ctx.Params("id")
+	var record api.FrontendRecord
+	if err := parseBody(req, &record); err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	err := svc.Add(id, record)
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	result := map[string]string{
+		"status": "ok",
+	}
+	return restkit.JSON(res, result, http.StatusOK)
+}
+
+// Update handles an API.Update request.
+// An If-Match header is used to detect concurrent modifications.
+func (m *Middleware) Update(ctx *macaron.Context, svc api.API, res http.ResponseWriter, req *http.Request) error {
 	id := ctx.Params("id")
 	var record api.FrontendRecord
 	if err := parseBody(req, &record); err != nil {
 		return m.mapError(res, maskAny(err))
 	}
-	err := m.Service.Add(id, record)
+	vs, version, err := m.versionedService(svc, req)
 	if err != nil {
 		return m.mapError(res, maskAny(err))
 	}
+	if err := vs.Update(id, record, version); err != nil {
+		return m.mapError(res, maskAny(err))
+	}
 	result := map[string]string{
 		"status": "ok",
 	}
 	return restkit.JSON(res, result, http.StatusOK)
 }
 
-// Remove handles an API.Remove request
-func (m *Middleware) Remove(ctx *macaron.Context, res http.ResponseWriter, req *http.Request) error {
+// Remove handles an API.Remove request.
+// An If-Match header is used to detect concurrent modifications.
+func (m *Middleware) Remove(ctx *macaron.Context, svc api.API, res http.ResponseWriter, req *http.Request) error {
 	id := ctx.Params("id")
-	err := m.Service.Remove(id)
+	var err error
+	if req.Header.Get(ifMatchHeader) == "" && !m.RequireIfMatch {
+		err = svc.Remove(id)
+	} else {
+		var vs api.VersionedAPI
+		var version string
+		if vs, version, err = m.versionedService(svc, req); err == nil {
+			err = vs.RemoveVersioned(id, version)
+		}
+	}
 	if err != nil {
 		return m.mapError(res, maskAny(err))
 	}
@@ -56,3 +113,17 @@ func (m *Middleware) Remove(ctx *macaron.Context, res http.ResponseWriter, req *
 	}
 	return restkit.JSON(res, result, http.StatusOK)
 }
+
+// versionedService returns the service as VersionedAPI together with the version
+// found in the If-Match header of the given request.
+func (m *Middleware) versionedService(svc api.API, req *http.Request) (api.VersionedAPI, string, error) {
+	vs, ok := svc.(api.VersionedAPI)
+	if !ok {
+		return nil, "", maskAny(errgo.WithCausef(nil, api.ValidationError, "versioning is not supported by this backend"))
+	}
+	ifMatch := req.Header.Get(ifMatchHeader)
+	if ifMatch == "" && m.RequireIfMatch {
+		return nil, "", maskAny(errgo.WithCausef(nil, api.VersionMismatchError, "%s header is required", ifMatchHeader))
+	}
+	return vs, api.ParseETag(ifMatch), nil
+}
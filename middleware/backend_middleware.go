@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/pulcy/rest-kit"
+
+	"github.com/pulcy/robin/service/backend"
+)
+
+// BackendStatus handles a GET /v1/backend/status request.
+// It fails with 503 while the backend is not ready, so a broken watch or failing loads are noticed.
+func (m *Middleware) BackendStatus(res http.ResponseWriter, req *http.Request) error {
+	if m.Backend == nil {
+		return restkit.JSON(res, backend.BackendStatus{}, http.StatusServiceUnavailable)
+	}
+	status := m.Backend.Status()
+	if !status.Ready {
+		return restkit.JSON(res, status, http.StatusServiceUnavailable)
+	}
+	return restkit.JSON(res, status, http.StatusOK)
+}
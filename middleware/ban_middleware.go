@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pulcy/rest-kit"
+	"gopkg.in/macaron.v1"
+)
+
+// banRequest is the body of a POST /v1/bans request.
+type banRequest struct {
+	IP       string `json:"ip"`
+	Duration string `json:"duration,omitempty"` // Go duration (empty means the configured ban duration)
+}
+
+// Bans handles a GET /v1/bans request.
+// It returns all banned source IPs.
+func (m *Middleware) Bans(res http.ResponseWriter, req *http.Request) error {
+	if m.BanManager == nil {
+		return m.mapError(res, banningDisabledError())
+	}
+	return restkit.JSON(res, m.BanManager.Bans(), http.StatusOK)
+}
+
+// Ban handles a POST /v1/bans request.
+// It bans a source IP until the ban expires.
+func (m *Middleware) Ban(res http.ResponseWriter, req *http.Request) error {
+	if m.BanManager == nil {
+		return m.mapError(res, banningDisabledError())
+	}
+	var input banRequest
+	if err := parseBody(req, &input); err != nil {
+		return m.mapError(res, restkit.BadRequestError(err.Error(), 0))
+	}
+	var duration time.Duration
+	if input.Duration != "" {
+		var err error
+		duration, err = time.ParseDuration(input.Duration)
+		if err != nil {
+			return m.mapError(res, restkit.BadRequestError(err.Error(), 0))
+		}
+	}
+	result, err := m.BanManager.Ban(input.IP, duration)
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	return restkit.JSON(res, result, http.StatusOK)
+}
+
+// Unban handles a DELETE /v1/bans/:ip request.
+func (m *Middleware) Unban(ctx *macaron.Context, res http.ResponseWriter, req *http.Request) error {
+	if m.BanManager == nil {
+		return m.mapError(res, banningDisabledError())
+	}
+	if err := m.BanManager.Unban(ctx.Params("ip")); err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	result := map[string]string{
+		"status": "ok",
+	}
+	return restkit.JSON(res, result, http.StatusOK)
+}
+
+func banningDisabledError() error {
+	return restkit.PreconditionFailedError("Banning requires a HAProxy runtime socket and --ban-failures", 0)
+}
@@ -0,0 +1,101 @@
+package compression
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Handler returns a handler that gzip compresses the responses of the given handler
+// for requests that accept it (Accept-Encoding).
+// Responses that are already encoded by the given handler are left untouched.
+func Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if req.Method == "HEAD" || !acceptsGzip(req) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		h.ServeHTTP(gw, req)
+	})
+}
+
+// acceptsGzip returns true if the Accept-Encoding header of the given request allows gzip.
+func acceptsGzip(req *http.Request) bool {
+	for _, header := range req.Header["Accept-Encoding"] {
+		for _, part := range strings.Split(header, ",") {
+			fields := strings.Split(part, ";")
+			coding := strings.ToLower(strings.TrimSpace(fields[0]))
+			if coding != "gzip" && coding != "*" {
+				continue
+			}
+			rejected := false
+			for _, param := range fields[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+						rejected = true
+					}
+				}
+			}
+			if !rejected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter compresses everything written to it, unless the status or headers indicate otherwise.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	header := w.Header()
+	if status != http.StatusNoContent && status != http.StatusNotModified && header.Get("Content-Encoding") == "" {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			// Detect the content type on the uncompressed data
+			w.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz == nil {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.gz.Write(data)
+}
+
+// Flush sends all data that is compressed so far to the client.
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
@@ -0,0 +1,105 @@
+package compression
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		AcceptEncoding []string
+		Expected       bool
+	}{
+		{nil, false},
+		{[]string{"gzip"}, true},
+		{[]string{"deflate, GZIP;q=0.5"}, true},
+		{[]string{"*"}, true},
+		{[]string{"gzip;q=0"}, false},
+		{[]string{"gzip; q=0.0, br"}, false},
+		{[]string{"br", "gzip"}, true},
+		{[]string{"identity"}, false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header["Accept-Encoding"] = test.AcceptEncoding
+		if result := acceptsGzip(req); result != test.Expected {
+			t.Errorf("Accept-Encoding %v: expected %v, got %v", test.AcceptEncoding, test.Expected, result)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	body := "<html><body>hello</body></html>"
+	handler := Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/encoded":
+			w.Header().Set("Content-Encoding", "br")
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Length", "31")
+		w.Write([]byte(body))
+	}))
+
+	// Compressed
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if enc := rec.Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("Expected gzip encoding, got '%s'", enc)
+	}
+	if l := rec.Header().Get("Content-Length"); l != "" {
+		t.Errorf("Expected no Content-Length, got '%s'", l)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Expected content type of uncompressed body, got '%s'", ct)
+	}
+	if vary := rec.Header().Get("Vary"); vary != "Accept-Encoding" {
+		t.Errorf("Expected Vary header, got '%s'", vary)
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("Cannot read gzip body: %#v", err)
+	}
+	if raw, err := ioutil.ReadAll(gz); err != nil || string(raw) != body {
+		t.Errorf("Expected body '%s', got '%s' (%v)", body, string(raw), err)
+	}
+
+	// Not compressed
+	tests := []struct {
+		Method         string
+		Path           string
+		AcceptEncoding string
+		Status         int
+		Encoding       string
+		Body           string
+	}{
+		{"GET", "/", "", http.StatusOK, "", body},
+		{"GET", "/", "gzip;q=0", http.StatusOK, "", body},
+		{"HEAD", "/", "gzip", http.StatusOK, "", ""},
+		{"GET", "/encoded", "gzip", http.StatusOK, "br", body},
+		{"GET", "/empty", "gzip", http.StatusNoContent, "", ""},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.Method, test.Path, nil)
+		if test.AcceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", test.AcceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != test.Status {
+			t.Errorf("%s %s: expected status %d, got %d", test.Method, test.Path, test.Status, rec.Code)
+		}
+		if enc := rec.Header().Get("Content-Encoding"); enc != test.Encoding {
+			t.Errorf("%s %s (%s): expected encoding '%s', got '%s'", test.Method, test.Path, test.AcceptEncoding, test.Encoding, enc)
+		}
+		if test.Method == "GET" && rec.Body.String() != test.Body {
+			t.Errorf("%s %s (%s): expected body '%s', got '%s'", test.Method, test.Path, test.AcceptEncoding, test.Body, rec.Body.String())
+		}
+	}
+}
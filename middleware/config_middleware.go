@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/pulcy/rest-kit"
+
+	"github.com/pulcy/robin/service"
+)
+
+// Routes handles a GET /v1/config/routes request.
+// It returns the routes of the current configuration, per frontend in order of precedence.
+func (m *Middleware) Routes(res http.ResponseWriter, req *http.Request) error {
+	result := []service.Route{}
+	if m.Config != nil {
+		if routes := m.Config.Routes(); routes != nil {
+			result = routes
+		}
+	}
+	return restkit.JSON(res, result, http.StatusOK)
+}
+
+// RenderedConfig handles a GET /v1/config request.
+// It returns the content of the HAProxy configuration that is currently in use (as plain text).
+func (m *Middleware) RenderedConfig(res http.ResponseWriter, req *http.Request) error {
+	config := ""
+	if m.Config != nil {
+		config = m.Config.Config()
+	}
+	res.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	res.WriteHeader(http.StatusOK)
+	_, err := res.Write([]byte(config))
+	return err
+}
+
+// Conflicts handles a GET /v1/diagnostics/conflicts request.
+// It returns the routes of the current configuration that are shadowed by another route.
+func (m *Middleware) Conflicts(res http.ResponseWriter, req *http.Request) error {
+	result := []service.RouteConflict{}
+	if m.Config != nil {
+		if conflicts := m.Config.Conflicts(); conflicts != nil {
+			result = conflicts
+		}
+	}
+	return restkit.JSON(res, result, http.StatusOK)
+}
+
+// OldProcesses handles a GET /v1/diagnostics/old-processes request.
+// It returns the HAProxy processes that have been replaced by a reload, with the connections they still serve.
+func (m *Middleware) OldProcesses(res http.ResponseWriter, req *http.Request) error {
+	result := []service.OldProcess{}
+	if m.Config != nil {
+		result = m.Config.OldProcesses()
+	}
+	return restkit.JSON(res, result, http.StatusOK)
+}
+
+// SimulateRoute handles a GET /v1/route?host=<host>&path=<path>&scheme=<http|https>&private=<bool> request.
+// It returns the service & backend that handle such a request, with the applied rewrites & auth requirements.
+func (m *Middleware) SimulateRoute(res http.ResponseWriter, req *http.Request) error {
+	query := req.URL.Query()
+	routeReq := service.RouteRequest{
+		Host:    query.Get("host"),
+		Path:    query.Get("path"),
+		Scheme:  query.Get("scheme"),
+		Private: query.Get("private") == "true",
+	}
+	if routeReq.Host == "" {
+		return m.mapError(res, restkit.BadRequestError("host is required", 0))
+	}
+	switch routeReq.Scheme {
+	case "":
+		routeReq.Scheme = "http"
+	case "http", "https":
+	default:
+		return m.mapError(res, restkit.BadRequestError("scheme must be http or https", 0))
+	}
+	if m.Config == nil {
+		return m.mapError(res, restkit.PreconditionFailedError("No configuration available", 0))
+	}
+	return restkit.JSON(res, m.Config.SimulateRoute(routeReq), http.StatusOK)
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pulcy/rest-kit"
+
+	"github.com/pulcy/robin/service"
+)
+
+const (
+	defaultDrainTimeout = time.Second * 30
+)
+
+// Drain handles a POST /v1/drain request.
+// It puts all servers in drain state and waits (up to the `timeout` query parameter)
+// for the active sessions to finish.
+func (m *Middleware) Drain(res http.ResponseWriter, req *http.Request) error {
+	if m.Drainer == nil {
+		return m.mapError(res, restkit.PreconditionFailedError("Draining requires a HAProxy runtime socket", 0))
+	}
+	timeout := defaultDrainTimeout
+	if raw := req.URL.Query().Get("timeout"); raw != "" {
+		var err error
+		timeout, err = time.ParseDuration(raw)
+		if err != nil {
+			return m.mapError(res, restkit.BadRequestError(err.Error(), 0))
+		}
+	}
+	status, err := m.Drainer.Drain(timeout)
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	return restkit.JSON(res, status, http.StatusOK)
+}
+
+// Undrain handles a POST /v1/undrain request.
+// It puts all servers back in ready state.
+func (m *Middleware) Undrain(res http.ResponseWriter, req *http.Request) error {
+	if m.Drainer == nil {
+		return m.mapError(res, restkit.PreconditionFailedError("Draining requires a HAProxy runtime socket", 0))
+	}
+	if err := m.Drainer.Undrain(); err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	return restkit.JSON(res, service.DrainStatus{}, http.StatusOK)
+}
+
+// Ready handles a GET /v1/ready request.
+// It fails with 503 while the node is draining, so orchestrators stop sending traffic to it.
+func (m *Middleware) Ready(res http.ResponseWriter, req *http.Request) error {
+	status := service.DrainStatus{}
+	if m.Drainer != nil && m.Drainer.IsDraining() {
+		status.Draining = true
+		return restkit.JSON(res, status, http.StatusServiceUnavailable)
+	}
+	return restkit.JSON(res, status, http.StatusOK)
+}
@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/juju/errgo"
+	logging "github.com/op/go-logging"
+	api "github.com/pulcy/robin-api"
+)
+
+// fakeAPI is an api.API (and api.VersionedAPI) that keeps all frontend records in memory.
+// The version of a record is increased on every change.
+type fakeAPI struct {
+	mutex    sync.Mutex
+	records  map[string]api.FrontendRecord
+	versions map[string]int
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{records: make(map[string]api.FrontendRecord), versions: make(map[string]int)}
+}
+
+func (f *fakeAPI) Add(id string, record api.FrontendRecord) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := record.Validate(); err != nil {
+		return maskAny(err)
+	}
+	if _, ok := f.records[id]; ok {
+		return maskAny(errgo.WithCausef(nil, api.DuplicateIDError, "'%s' already exists", id))
+	}
+	f.records[id] = record
+	f.versions[id]++
+	return nil
+}
+
+func (f *fakeAPI) Remove(id string) error {
+	return f.RemoveVersioned(id, "")
+}
+
+func (f *fakeAPI) All() (map[string]api.FrontendRecord, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	result := make(map[string]api.FrontendRecord)
+	for id, record := range f.records {
+		result[id] = record
+	}
+	return result, nil
+}
+
+func (f *fakeAPI) Get(id string) (api.FrontendRecord, error) {
+	record, _, err := f.GetVersioned(id)
+	return record, maskAny(err)
+}
+
+func (f *fakeAPI) GetVersioned(id string) (api.FrontendRecord, string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	record, ok := f.records[id]
+	if !ok {
+		return api.FrontendRecord{}, "", maskAny(errgo.WithCausef(nil, api.IDNotFoundError, "'%s' not found", id))
+	}
+	return record, strconv.Itoa(f.versions[id]), nil
+}
+
+func (f *fakeAPI) Update(id string, record api.FrontendRecord, version string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.checkVersion(id, version); err != nil {
+		return maskAny(err)
+	}
+	if err := record.Validate(); err != nil {
+		return maskAny(err)
+	}
+	f.records[id] = record
+	f.versions[id]++
+	return nil
+}
+
+func (f *fakeAPI) RemoveVersioned(id string, version string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.checkVersion(id, version); err != nil {
+		return maskAny(err)
+	}
+	delete(f.records, id)
+	return nil
+}
+
+func (f *fakeAPI) checkVersion(id string, version string) error {
+	if _, ok := f.records[id]; !ok {
+		return maskAny(errgo.WithCausef(nil, api.IDNotFoundError, "'%s' not found", id))
+	}
+	if version != "" && version != strconv.Itoa(f.versions[id]) {
+		return maskAny(errgo.WithCausef(nil, api.VersionMismatchError, "'%s' has changed", id))
+	}
+	return nil
+}
+
+// newTestMiddleware creates a middleware that serves the given (fake) API.
+func newTestMiddleware(svc api.API) *Middleware {
+	return &Middleware{
+		Logger:  logging.MustGetLogger("test"),
+		Service: svc,
+	}
+}
+
+// serve sends a request (with optional token & body) to the given handler and returns the recorded response.
+func serve(h http.Handler, method, path, token, body string, header ...string) *httptest.ResponseRecorder {
+	var reqBody io.Reader
+	if body != "" {
+		reqBody = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, reqBody)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errgo"
+	api "github.com/pulcy/robin-api"
+)
+
+const (
+	totalCountHeader = "X-Total-Count"
+)
+
+var (
+	// JSON field names of api.FrontendRecord that can be selected
+	frontendFields = map[string]struct{}{
+		"selectors":         struct{}{},
+		"service":           struct{}{},
+		"mode":              struct{}{},
+		"http-check-path":   struct{}{},
+		"http-check-method": struct{}{},
+		"sticky":            struct{}{},
+		"backup":            struct{}{},
+		"edge-group":        struct{}{},
+		"owner":             struct{}{},
+		"labels":            struct{}{},
+	}
+)
+
+// frontendQuery holds the filter, pagination & field selection options of a GET /v1/frontend request.
+type frontendQuery struct {
+	Service string
+	Domain  string
+	Mode    string
+	Owner   string
+	Labels  map[string]*string // Label key -> required value (nil means label must exist)
+	Public  *bool
+	Offset  int
+	Limit   int      // 0 means no limit
+	Fields  []string // Empty means all fields
+}
+
+// parseFrontendQuery parses the query parameters of a GET /v1/frontend request.
+func parseFrontendQuery(values url.Values) (frontendQuery, error) {
+	q := frontendQuery{
+		Service: values.Get("service"),
+		Domain:  strings.ToLower(values.Get("domain")),
+		Mode:    values.Get("mode"),
+		Owner:   values.Get("owner"),
+	}
+	for _, v := range values["label"] {
+		if q.Labels == nil {
+			q.Labels = make(map[string]*string)
+		}
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) == 2 {
+			q.Labels[parts[0]] = &parts[1]
+		} else {
+			q.Labels[parts[0]] = nil
+		}
+	}
+	if v := values.Get("public"); v != "" {
+		public, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, maskAny(errgo.WithCausef(nil, api.ValidationError, "invalid public '%s'", v))
+		}
+		q.Public = &public
+	}
+	var err error
+	if q.Offset, err = parseNonNegativeInt(values, "offset"); err != nil {
+		return q, maskAny(err)
+	}
+	if q.Limit, err = parseNonNegativeInt(values, "limit"); err != nil {
+		return q, maskAny(err)
+	}
+	if v := values.Get("fields"); v != "" {
+		for _, f := range strings.Split(v, ",") {
+			f = strings.TrimSpace(f)
+			if _, ok := frontendFields[f]; !ok {
+				return q, maskAny(errgo.WithCausef(nil, api.ValidationError, "unknown field '%s'", f))
+			}
+			q.Fields = append(q.Fields, f)
+		}
+	}
+	return q, nil
+}
+
+func parseNonNegativeInt(values url.Values, key string) (int, error) {
+	v := values.Get(key)
+	if v == "" {
+		return 0, nil
+	}
+	result, err := strconv.Atoi(v)
+	if err != nil || result < 0 {
+		return 0, maskAny(errgo.WithCausef(nil, api.ValidationError, "invalid %s '%s'", key, v))
+	}
+	return result, nil
+}
+
+// Matches returns true if the given record passes all filters of the query.
+func (q frontendQuery) Matches(record api.FrontendRecord) bool {
+	if q.Service != "" && record.Service != q.Service {
+		return false
+	}
+	if q.Owner != "" && record.Owner != q.Owner {
+		return false
+	}
+	for key, value := range q.Labels {
+		actual, found := record.Labels[key]
+		if !found || (value != nil && actual != *value) {
+			return false
+		}
+	}
+	if q.Mode != "" {
+		mode := record.Mode
+		if mode == "" {
+			mode = "http"
+		}
+		if mode != q.Mode {
+			return false
+		}
+	}
+	if q.Domain == "" && q.Public == nil {
+		return true
+	}
+	for _, sel := range record.AllSelectors() {
+		if q.Domain != "" && strings.ToLower(sel.Domain) != q.Domain {
+			continue
+		}
+		if q.Public != nil && sel.Private == *q.Public {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// Apply filters the given records, selects a page of the result (ordered by ID)
+// and removes all fields that are not selected.
+// It returns the resulting records and the total number of records that matched the filters.
+func (q frontendQuery) Apply(records map[string]api.FrontendRecord) (map[string]interface{}, int, error) {
+	var ids []string
+	for id, record := range records {
+		if q.Matches(record) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	total := len(ids)
+	if q.Offset >= len(ids) {
+		ids = nil
+	} else {
+		ids = ids[q.Offset:]
+	}
+	if q.Limit > 0 && q.Limit < len(ids) {
+		ids = ids[:q.Limit]
+	}
+
+	result := make(map[string]interface{})
+	for _, id := range ids {
+		record := records[id]
+		if len(q.Fields) == 0 {
+			result[id] = record
+			continue
+		}
+		selected, err := selectFields(record, q.Fields)
+		if err != nil {
+			return nil, 0, maskAny(err)
+		}
+		result[id] = selected
+	}
+	return result, total, nil
+}
+
+// selectFields returns a JSON object containing only the given fields of the given record.
+func selectFields(record api.FrontendRecord, fields []string) (map[string]interface{}, error) {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	var all map[string]interface{}
+	if err := json.Unmarshal(raw, &all); err != nil {
+		return nil, maskAny(err)
+	}
+	result := make(map[string]interface{})
+	for _, f := range fields {
+		if v, ok := all[f]; ok {
+			result[f] = v
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+
+	api "github.com/pulcy/robin-api"
+)
+
+var (
+	frontendQueryRecords = map[string]api.FrontendRecord{
+		"web": api.FrontendRecord{
+			Service: "web",
+			Owner:   "team-a",
+			Labels:  map[string]string{"env": "prod"},
+			Selectors: []api.FrontendSelectorRecord{
+				api.FrontendSelectorRecord{Domain: "Foo.com"},
+			},
+		},
+		"web-private": api.FrontendRecord{
+			Service: "web",
+			Owner:   "team-a",
+			Labels:  map[string]string{"env": "dev"},
+			Selectors: []api.FrontendSelectorRecord{
+				api.FrontendSelectorRecord{Domain: "web.private", Private: true},
+			},
+		},
+		"db": api.FrontendRecord{
+			Service: "db",
+			Mode:    "tcp",
+			Owner:   "team-b",
+			Selectors: []api.FrontendSelectorRecord{
+				api.FrontendSelectorRecord{FrontendPort: 5432, Private: true},
+			},
+		},
+	}
+)
+
+func TestParseFrontendQueryErrors(t *testing.T) {
+	tests := []string{
+		"public=maybe",
+		"offset=-1",
+		"limit=ten",
+		"fields=service,unknown",
+	}
+	for _, test := range tests {
+		values, err := url.ParseQuery(test)
+		if err != nil {
+			t.Fatalf("Cannot parse query %s: %#v", test, err)
+		}
+		if _, err := parseFrontendQuery(values); !api.IsValidation(err) {
+			t.Errorf("Expected validation error for %s, got %#v", test, err)
+		}
+	}
+}
+
+func TestFrontendQueryFilters(t *testing.T) {
+	tests := []struct {
+		Query    string
+		Expected []string
+	}{
+		{"", []string{"db", "web", "web-private"}},
+		{"service=web", []string{"web", "web-private"}},
+		{"domain=foo.COM", []string{"web"}},
+		{"mode=http", []string{"web", "web-private"}},
+		{"mode=tcp", []string{"db"}},
+		{"public=true", []string{"web"}},
+		{"public=false", []string{"db", "web-private"}},
+		{"owner=team-b", []string{"db"}},
+		{"label=env", []string{"web", "web-private"}},
+		{"label=env=dev", []string{"web-private"}},
+		{"service=web&public=false", []string{"web-private"}},
+	}
+	for _, test := range tests {
+		values, _ := url.ParseQuery(test.Query)
+		q, err := parseFrontendQuery(values)
+		if err != nil {
+			t.Fatalf("parseFrontendQuery(%s) failed: %#v", test.Query, err)
+		}
+		result, total, err := q.Apply(frontendQueryRecords)
+		if err != nil {
+			t.Fatalf("Apply(%s) failed: %#v", test.Query, err)
+		}
+		var ids []string
+		for id := range result {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		if !reflect.DeepEqual(ids, test.Expected) || total != len(test.Expected) {
+			t.Errorf("Query '%s': expected %v, got %v (total %d)", test.Query, test.Expected, ids, total)
+		}
+	}
+}
+
+func TestFrontendQueryPagination(t *testing.T) {
+	tests := []struct {
+		Query    string
+		Expected []string
+	}{
+		{"limit=2", []string{"db", "web"}},
+		{"offset=1&limit=1", []string{"web"}},
+		{"offset=2", []string{"web-private"}},
+		{"offset=5", nil},
+	}
+	for _, test := range tests {
+		values, _ := url.ParseQuery(test.Query)
+		q, err := parseFrontendQuery(values)
+		if err != nil {
+			t.Fatalf("parseFrontendQuery(%s) failed: %#v", test.Query, err)
+		}
+		result, total, err := q.Apply(frontendQueryRecords)
+		if err != nil {
+			t.Fatalf("Apply(%s) failed: %#v", test.Query, err)
+		}
+		var ids []string
+		for id := range result {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		if !reflect.DeepEqual(ids, test.Expected) {
+			t.Errorf("Query '%s': expected %v, got %v", test.Query, test.Expected, ids)
+		}
+		if total != len(frontendQueryRecords) {
+			t.Errorf("Query '%s': expected total %d, got %d", test.Query, len(frontendQueryRecords), total)
+		}
+	}
+}
+
+func TestFrontendQueryFields(t *testing.T) {
+	values, _ := url.ParseQuery("service=db&fields=service,mode,owner")
+	q, err := parseFrontendQuery(values)
+	if err != nil {
+		t.Fatalf("parseFrontendQuery failed: %#v", err)
+	}
+	result, _, err := q.Apply(frontendQueryRecords)
+	if err != nil {
+		t.Fatalf("Apply failed: %#v", err)
+	}
+	expected := map[string]interface{}{
+		"db": map[string]interface{}{"service": "db", "mode": "tcp", "owner": "team-b"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, result)
+	}
+}
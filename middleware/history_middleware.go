@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pulcy/rest-kit"
+)
+
+// History handles a GET /v1/history?since=...&service=... request.
+// It returns the changes of the routing model (oldest first), optionally since a time (RFC3339)
+// and limited to a single service.
+func (m *Middleware) History(res http.ResponseWriter, req *http.Request) error {
+	if m.HistoryInspector == nil {
+		return m.mapError(res, restkit.PreconditionFailedError("History is not available", 0))
+	}
+	query := req.URL.Query()
+	var since time.Time
+	if raw := query.Get("since"); raw != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return m.mapError(res, restkit.BadRequestError(err.Error(), 0))
+		}
+	}
+	return restkit.JSON(res, m.HistoryInspector.History(since, query.Get("service")), http.StatusOK)
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+	"plugin"
+
+	"github.com/juju/errgo"
+	"gopkg.in/macaron.v1"
+)
+
+const (
+	// PluginSymbol is the name of the function an API plugin must export.
+	// Its type must be `func(*middleware.Hooks) error`.
+	PluginSymbol = "RegisterAPIHooks"
+)
+
+// Hooks extend the API server with custom middleware (e.g. authentication, request logging, CORS)
+// and additional routes.
+type Hooks struct {
+	middleware []func(http.Handler) http.Handler
+	routes     []route
+}
+
+type route struct {
+	method  string
+	path    string
+	handler http.Handler
+}
+
+// Use adds a middleware that wraps all requests to the API server.
+// The middleware that is added first, is called first.
+func (h *Hooks) Use(mw func(http.Handler) http.Handler) {
+	h.middleware = append(h.middleware, mw)
+}
+
+// Handle adds a route to the API server.
+// The path can contain macaron parameters (e.g. `/v1/custom/:id`).
+// Requests for these routes are authorized just like requests for the builtin routes.
+func (h *Hooks) Handle(method, path string, handler http.Handler) {
+	h.routes = append(h.routes, route{method: method, path: path, handler: handler})
+}
+
+// LoadPlugin opens the Go plugin at the given path and lets it register its hooks.
+func (h *Hooks) LoadPlugin(path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return maskAny(err)
+	}
+	sym, err := p.Lookup(PluginSymbol)
+	if err != nil {
+		return maskAny(err)
+	}
+	register, ok := sym.(func(*Hooks) error)
+	if !ok {
+		return maskAny(errgo.Newf("%s in plugin %s has type %T, expected func(*middleware.Hooks) error", PluginSymbol, path, sym))
+	}
+	if err := register(h); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// setupRoutes adds all hooked routes to the given app.
+func (h *Hooks) setupRoutes(mac *macaron.Macaron) {
+	for _, r := range h.routes {
+		mac.Handle(r.method, r.path, []macaron.Handler{r.handler.ServeHTTP})
+	}
+}
+
+// wrap wraps the given handler in all hooked middleware.
+func (h *Hooks) wrap(handler http.Handler) http.Handler {
+	for i := len(h.middleware) - 1; i >= 0; i-- {
+		handler = h.middleware[i](handler)
+	}
+	return handler
+}
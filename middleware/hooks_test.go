@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	hooks := &Hooks{}
+	var order []string
+	for _, name := range []string{"first", "second"} {
+		name := name
+		hooks.Use(func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				order = append(order, name)
+				w.Header().Add("X-Hook", name)
+				h.ServeHTTP(w, req)
+			})
+		})
+	}
+	hooks.Handle("GET", "/v1/custom/:id", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("custom " + req.URL.Path))
+	}))
+	m := newTestMiddleware(newFakeAPI())
+	m.APIToken = "secret"
+	m.Hooks = hooks
+	h := m.SetupRoutes("robin", "test", "test")
+
+	// Hooked routes are served
+	rec := serve(h, "GET", "/v1/custom/foo", "secret", "")
+	if rec.Code != http.StatusOK || rec.Body.String() != "custom /v1/custom/foo" {
+		t.Errorf("Expected custom route to be served, got %d '%s'", rec.Code, rec.Body.String())
+	}
+	// Hooked middleware is called in the order it was added
+	if strings.Join(order, ",") != "first,second" {
+		t.Errorf("Expected middleware order first,second, got %v", order)
+	}
+	if got := rec.Header()["X-Hook"]; strings.Join(got, ",") != "first,second" {
+		t.Errorf("Expected X-Hook headers first,second, got %v", got)
+	}
+
+	// Hooked routes are authorized like builtin routes
+	if rec := serve(h, "GET", "/v1/custom/foo", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected unauthorized without token, got %d", rec.Code)
+	}
+	if rec := serve(h, "GET", "/v1/custom/foo", "wrong", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected unauthorized with wrong token, got %d", rec.Code)
+	}
+
+	// Hooked middleware also wraps builtin routes
+	order = nil
+	if rec := serve(h, "GET", "/v1/frontend", "secret", ""); rec.Code != http.StatusOK || len(rec.Header()["X-Hook"]) != 2 {
+		t.Errorf("Expected builtin route wrapped by hooks, got %d %v", rec.Code, rec.Header()["X-Hook"])
+	}
+	if len(order) != 2 {
+		t.Errorf("Expected both middleware called for builtin route, got %v", order)
+	}
+}
+
+func TestLoadPluginMissing(t *testing.T) {
+	hooks := &Hooks{}
+	if err := hooks.LoadPlugin("/does/not/exist.so"); err == nil {
+		t.Errorf("Expected error for missing plugin")
+	}
+	if len(hooks.middleware) != 0 || len(hooks.routes) != 0 {
+		t.Errorf("Expected no hooks after failed load")
+	}
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/juju/errgo"
+	"github.com/pulcy/rest-kit"
+	api "github.com/pulcy/robin-api"
+	"gopkg.in/macaron.v1"
+)
+
+// RegisterInstance handles a POST /v1/service/:name/instances request.
+// Posting the same instance again (before its TTL expires) acts as a heartbeat.
+func (m *Middleware) RegisterInstance(ctx *macaron.Context, res http.ResponseWriter, req *http.Request) error {
+	is, err := m.instanceService()
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	var record api.InstanceRecord
+	if err := parseBody(req, &record); err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	if err := is.RegisterInstance(ctx.Params("name"), record); err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	result := map[string]string{
+		"status": "ok",
+		"id":     record.InstanceID(),
+	}
+	return restkit.JSON(res, result, http.StatusOK)
+}
+
+// DeregisterInstance handles a DELETE /v1/service/:name/instances/:id request.
+func (m *Middleware) DeregisterInstance(ctx *macaron.Context, res http.ResponseWriter, req *http.Request) error {
+	is, err := m.instanceService()
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	if err := is.DeregisterInstance(ctx.Params("name"), ctx.Params("id")); err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	result := map[string]string{
+		"status": "ok",
+	}
+	return restkit.JSON(res, result, http.StatusOK)
+}
+
+// instanceService returns the service as InstanceAPI.
+func (m *Middleware) instanceService() (api.InstanceAPI, error) {
+	is, ok := m.Service.(api.InstanceAPI)
+	if !ok {
+		return nil, maskAny(errgo.WithCausef(nil, api.ValidationError, "instance registration is not supported by this backend"))
+	}
+	return is, nil
+}
@@ -11,6 +11,12 @@ import (
 	"gopkg.in/macaron.v1"
 
 	"github.com/pulcy/robin-api"
+
+	"github.com/pulcy/robin/middleware/compression"
+	"github.com/pulcy/robin/service"
+	"github.com/pulcy/robin/service/accounting"
+	"github.com/pulcy/robin/service/acme"
+	"github.com/pulcy/robin/service/backend"
 )
 
 var (
@@ -20,6 +26,32 @@ var (
 type Middleware struct {
 	Logger  *logging.Logger
 	Service api.API
+	Renewal acme.RenewalMonitor
+	Config  service.ConfigInspector
+	Drainer service.Drainer        // If set, the node can be drained through the API
+	Quotas  service.QuotaInspector // If set, the traffic counted for quotas is available through the API
+
+	// If set, usage reports (per service) are available through the API
+	Accounting accounting.Accountant
+	// If set, banned source IPs can be listed, added & removed through the API
+	BanManager service.BanManager
+	// If set, the recent changes of the routing model are available through the API
+	HistoryInspector service.HistoryInspector
+	// If set, the status of the backend (last load, watch errors) is available through the API
+	Backend backend.StatusReporter
+
+	// If set, PUT & DELETE requests on frontends must contain an If-Match header
+	RequireIfMatch bool
+	// If set, responses are gzip compressed for clients that accept it
+	Compress bool
+
+	// If set, requests must contain this token (or a tenant token) in an Authorization header
+	APIToken string
+	// Tenants that can manage their own frontend records using one of their tokens
+	Tenants []backend.Tenant
+
+	// If set, custom middleware & routes are added to the API server
+	Hooks *Hooks
 }
 
 func (m *Middleware) SetupRoutes(projectName, projectVersion, projectBuild string) http.Handler {
@@ -31,6 +63,7 @@ func (m *Middleware) SetupRoutes(projectName, projectVersion, projectBuild strin
 	mac.Use(macaron.Recovery())
 	mac.Use(macaron.Renderer())
 	mac.Map(m.Service)
+	mac.Use(m.authorize)
 	mac.SetAutoHead(true)
 
 	// Alive ping
@@ -39,13 +72,71 @@ func (m *Middleware) SetupRoutes(projectName, projectVersion, projectBuild strin
 	// Our API
 	mac.Get("/v1/frontend", m.All)
 	mac.Post("/v1/frontend/:id", m.Add)
+	mac.Put("/v1/frontend/:id", m.Update)
 	mac.Delete("/v1/frontend/:id", m.Remove)
 	mac.Get("/v1/frontend/:id", m.Get)
+	mac.Post("/v1/frontend/from-template", m.AddFromTemplate)
+
+	// Templates
+	mac.Get("/v1/template", m.AllTemplates)
+	mac.Post("/v1/template/:name", m.AddTemplate)
+	mac.Delete("/v1/template/:name", m.RemoveTemplate)
+	mac.Get("/v1/template/:name", m.GetTemplate)
+
+	// Instances (registrator-less registration)
+	mac.Post("/v1/service/:name/instances", m.RegisterInstance)
+	mac.Delete("/v1/service/:name/instances/:id", m.DeregisterInstance)
+
+	// Scheduled changes
+	mac.Get("/v1/schedule", m.ScheduledChanges)
+	mac.Post("/v1/schedule", m.ScheduleChange)
+	mac.Delete("/v1/schedule/:id", m.CancelScheduledChange)
+
+	// Configuration
+	mac.Get("/v1/config", m.RenderedConfig)
+	mac.Get("/v1/config/routes", m.Routes)
+	mac.Get("/v1/route", m.SimulateRoute)
+	mac.Get("/v1/diagnostics/conflicts", m.Conflicts)
+	mac.Get("/v1/diagnostics/old-processes", m.OldProcesses)
+	mac.Get("/v1/history", m.History)
+
+	// Soft shutdown
+	mac.Post("/v1/drain", m.Drain)
+	mac.Post("/v1/undrain", m.Undrain)
+	mac.Get("/v1/ready", m.Ready)
+
+	// Quotas
+	mac.Get("/v1/quota/usage", m.QuotaUsage)
+
+	// Usage accounting
+	mac.Get("/v1/usage", m.Usage)
+
+	// Banned source IPs
+	mac.Get("/v1/bans", m.Bans)
+	mac.Post("/v1/bans", m.Ban)
+	mac.Delete("/v1/bans/:ip", m.Unban)
+
+	// ACME
+	mac.Get("/v1/acme/status", m.AcmeStatus)
+
+	// Backend
+	mac.Get("/v1/backend/status", m.BackendStatus)
+
+	// Version 2 of our API
+	m.setupV2Routes(mac)
 
 	// Home
 	mac.Get("/", utils.ServerInfo(projectName, projectVersion, projectBuild))
 
-	return mac
+	var handler http.Handler = mac
+	if m.Hooks != nil {
+		m.Hooks.setupRoutes(mac)
+		handler = m.Hooks.wrap(handler)
+	}
+	if m.Compress {
+		return compression.Handler(handler)
+	}
+	return handler
 
 	// receive api
 }
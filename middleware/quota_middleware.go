@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/pulcy/rest-kit"
+)
+
+// QuotaUsage handles a GET /v1/quota/usage request.
+// It returns the traffic (in the last 24 hours) of all domains and tenants that have a quota.
+func (m *Middleware) QuotaUsage(res http.ResponseWriter, req *http.Request) error {
+	if m.Quotas == nil {
+		return m.mapError(res, restkit.PreconditionFailedError("Quota usage requires a HAProxy runtime socket", 0))
+	}
+	result, err := m.Quotas.QuotaUsage()
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	return restkit.JSON(res, result, http.StatusOK)
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/juju/errgo"
+	"github.com/pulcy/rest-kit"
+	api "github.com/pulcy/robin-api"
+	"gopkg.in/macaron.v1"
+)
+
+// ScheduledChanges handles a GET /v1/schedule request.
+func (m *Middleware) ScheduledChanges(ctx *macaron.Context, res http.ResponseWriter, req *http.Request) error {
+	ss, err := m.scheduleService()
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	result, err := ss.ScheduledChanges()
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	return restkit.JSON(res, result, http.StatusOK)
+}
+
+// ScheduleChange handles a POST /v1/schedule request.
+func (m *Middleware) ScheduleChange(ctx *macaron.Context, res http.ResponseWriter, req *http.Request) error {
+	ss, err := m.scheduleService()
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	var change api.ScheduledChange
+	if err := parseBody(req, &change); err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	result, err := ss.ScheduleChange(change)
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	return restkit.JSON(res, result, http.StatusOK)
+}
+
+// CancelScheduledChange handles a DELETE /v1/schedule/:id request.
+func (m *Middleware) CancelScheduledChange(ctx *macaron.Context, res http.ResponseWriter, req *http.Request) error {
+	ss, err := m.scheduleService()
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	if err := ss.CancelScheduledChange(ctx.Params("id")); err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	result := map[string]string{
+		"status": "ok",
+	}
+	return restkit.JSON(res, result, http.StatusOK)
+}
+
+// scheduleService returns the service as ScheduleAPI.
+func (m *Middleware) scheduleService() (api.ScheduleAPI, error) {
+	ss, ok := m.Service.(api.ScheduleAPI)
+	if !ok {
+		return nil, maskAny(errgo.WithCausef(nil, api.ValidationError, "scheduled changes are not supported by this backend"))
+	}
+	return ss, nil
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/juju/errgo"
+	"github.com/pulcy/rest-kit"
+	api "github.com/pulcy/robin-api"
+	"gopkg.in/macaron.v1"
+)
+
+// AllTemplates handles an API.AllTemplates request
+func (m *Middleware) AllTemplates(res http.ResponseWriter, req *http.Request) error {
+	ts, err := m.templateService()
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	result, err := ts.AllTemplates()
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	return restkit.JSON(res, result, http.StatusOK)
+}
+
+// GetTemplate handles an API.GetTemplate request
+func (m *Middleware) GetTemplate(ctx *macaron.Context, res http.ResponseWriter, req *http.Request) error {
+	ts, err := m.templateService()
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	result, err := ts.GetTemplate(ctx.Params("name"))
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	return restkit.JSON(res, result, http.StatusOK)
+}
+
+// AddTemplate handles an API.AddTemplate request
+func (m *Middleware) AddTemplate(ctx *macaron.Context, res http.ResponseWriter, req *http.Request) error {
+	ts, err := m.templateService()
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	var tmpl api.FrontendRecord
+	if err := parseBody(req, &tmpl); err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	if err := ts.AddTemplate(ctx.Params("name"), tmpl); err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	result := map[string]string{
+		"status": "ok",
+	}
+	return restkit.JSON(res, result, http.StatusOK)
+}
+
+// RemoveTemplate handles an API.RemoveTemplate request
+func (m *Middleware) RemoveTemplate(ctx *macaron.Context, res http.ResponseWriter, req *http.Request) error {
+	ts, err := m.templateService()
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	if err := ts.RemoveTemplate(ctx.Params("name")); err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	result := map[string]string{
+		"status": "ok",
+	}
+	return restkit.JSON(res, result, http.StatusOK)
+}
+
+// AddFromTemplate handles an API.AddFromTemplate request.
+// The created frontend record is returned.
+func (m *Middleware) AddFromTemplate(res http.ResponseWriter, req *http.Request) error {
+	ts, err := m.templateService()
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	var ftReq api.FromTemplateRequest
+	if err := parseBody(req, &ftReq); err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	result, err := ts.AddFromTemplate(ftReq)
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	return restkit.JSON(res, result, http.StatusOK)
+}
+
+// templateService returns the service as TemplateAPI.
+func (m *Middleware) templateService() (api.TemplateAPI, error) {
+	ts, ok := m.Service.(api.TemplateAPI)
+	if !ok {
+		return nil, maskAny(errgo.WithCausef(nil, api.ValidationError, "templates are not supported by this backend"))
+	}
+	return ts, nil
+}
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/juju/errgo"
+	"github.com/pulcy/rest-kit"
+	api "github.com/pulcy/robin-api"
+	"gopkg.in/macaron.v1"
+
+	"github.com/pulcy/robin/service/backend"
+)
+
+var (
+	unauthorizedError = restkit.UnauthorizedError("unauthorized", 0)
+	forbiddenError    = restkit.ForbiddenError("forbidden", 0)
+
+	// Paths that can be requested without a token
+	publicPaths = map[string]struct{}{
+		"/":         struct{}{},
+		"/v1/ping":  struct{}{},
+		"/v1/ready": struct{}{},
+	}
+)
+
+// authorize checks the API token of the request (if tokens are configured) and
+// maps the api.API that the request operates on.
+// Requests with a tenant token can only access the frontend records of that tenant.
+func (m *Middleware) authorize(ctx *macaron.Context, res http.ResponseWriter, req *http.Request) {
+	ctx.MapTo(m.Service, (*api.API)(nil))
+	if m.APIToken == "" && len(m.Tenants) == 0 {
+		return
+	}
+	if _, ok := publicPaths[req.URL.Path]; ok {
+		return
+	}
+	token := bearerToken(req)
+	if token != "" && tokenEqual(token, m.APIToken) {
+		return
+	}
+	if tenant, ok := m.tenantByToken(token); ok {
+		if !isTenantPath(req.URL.Path) {
+			m.requestError(res, req, maskAny(errgo.WithCausef(nil, forbiddenError, "tenant '%s' cannot access %s", tenant.Name, req.URL.Path)))
+			return
+		}
+		tb, ok := m.Service.(backend.TenantBackend)
+		if !ok {
+			m.requestError(res, req, maskAny(errgo.WithCausef(nil, api.ValidationError, "tenants are not supported by this backend")))
+			return
+		}
+		ctx.MapTo(tb.ForTenant(tenant), (*api.API)(nil))
+		return
+	}
+	m.requestError(res, req, maskAny(errgo.WithCausef(nil, unauthorizedError, "invalid or missing API token")))
+}
+
+// tenantByToken returns the tenant that owns the given token.
+func (m *Middleware) tenantByToken(token string) (backend.Tenant, bool) {
+	if token == "" {
+		return backend.Tenant{}, false
+	}
+	for _, t := range m.Tenants {
+		for _, x := range t.Tokens {
+			if tokenEqual(x, token) {
+				return t, true
+			}
+		}
+	}
+	return backend.Tenant{}, false
+}
+
+// tokenEqual compares the given tokens in constant time.
+func tokenEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// isTenantPath returns true if tenants are allowed to request the given path.
+func isTenantPath(path string) bool {
+	if path == "/v2/frontends" || strings.HasPrefix(path, "/v2/frontends/") {
+		return true
+	}
+	return (path == "/v1/frontend" || strings.HasPrefix(path, "/v1/frontend/")) && path != "/v1/frontend/from-template"
+}
+
+// bearerToken returns the token found in the Authorization header of the given request.
+func bearerToken(req *http.Request) string {
+	const prefix = "Bearer "
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return ""
+	}
+	return strings.TrimSpace(auth[len(prefix):])
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/pulcy/rest-kit"
+
+	"github.com/pulcy/robin/service/accounting"
+)
+
+// Usage handles a GET /v1/usage?service=...&period=... request.
+// It returns the traffic of a service (or all services) per hour, day (default) or month.
+func (m *Middleware) Usage(res http.ResponseWriter, req *http.Request) error {
+	if m.Accounting == nil {
+		return m.mapError(res, restkit.PreconditionFailedError("Usage reports require a usage file", 0))
+	}
+	query := req.URL.Query()
+	period, err := accounting.ParsePeriod(query.Get("period"))
+	if err != nil {
+		return m.mapError(res, restkit.BadRequestError(err.Error(), 0))
+	}
+	result, err := m.Accounting.Usage(query.Get("service"), period)
+	if err != nil {
+		return m.mapError(res, maskAny(err))
+	}
+	return restkit.JSON(res, result, http.StatusOK)
+}
@@ -0,0 +1,389 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errgo"
+	"github.com/pulcy/rest-kit"
+	api "github.com/pulcy/robin-api"
+	"gopkg.in/macaron.v1"
+
+	"github.com/pulcy/robin/service"
+	"github.com/pulcy/robin/service/acme"
+	"github.com/pulcy/robin/service/backend"
+)
+
+const (
+	v2Prefix = "/v2/"
+)
+
+// v2Response is the body of all successful /v2 responses.
+type v2Response struct {
+	Data interface{} `json:"data"`
+	Page *v2Page     `json:"page,omitempty"` // Only set for collections
+}
+
+// v2Page describes the part of a collection that is returned.
+type v2Page struct {
+	Total  int `json:"total"` // Number of items that match the filters
+	Offset int `json:"offset"`
+	Limit  int `json:"limit,omitempty"` // 0 means no limit
+}
+
+// v2ErrorResponse is the body of all failed /v2 responses.
+type v2ErrorResponse struct {
+	Error struct {
+		Status  int    `json:"status"`         // HTTP status code
+		Code    int    `json:"code,omitempty"` // Error code of the robin API (if any)
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// v2Frontend is a frontend record together with its ID.
+type v2Frontend struct {
+	ID      string `json:"id"`
+	Version string `json:"version,omitempty"` // Only set if the backend supports versioning
+	api.FrontendRecord
+}
+
+// v2Instance is an instance of a service of the current configuration.
+type v2Instance struct {
+	Service  string                  `json:"service"`
+	EdgePort int                     `json:"edge-port"`
+	Public   bool                    `json:"public"`
+	Instance backend.ServiceInstance `json:"instance"`
+}
+
+// setupV2Routes adds the routes of the /v2 API.
+// Every response contains a `data` field (with a `page` field for collections) or an `error` field.
+// Collections are paged using the offset & limit query parameters.
+func (m *Middleware) setupV2Routes(mac *macaron.Macaron) {
+	mac.Get("/v2/frontends", m.V2Frontends)
+	mac.Get("/v2/frontends/:id", m.V2Frontend)
+	mac.Post("/v2/frontends/:id", m.V2AddFrontend)
+	mac.Put("/v2/frontends/:id", m.V2UpdateFrontend)
+	mac.Delete("/v2/frontends/:id", m.V2RemoveFrontend)
+
+	mac.Get("/v2/services", m.V2Services)
+	mac.Get("/v2/instances", m.V2Instances)
+	mac.Get("/v2/backends", m.V2Backends)
+	mac.Get("/v2/backends/:name", m.V2Backend)
+	mac.Get("/v2/certificates", m.V2Certificates)
+	mac.Get("/v2/certificates/:domain", m.V2Certificate)
+}
+
+// V2Frontends handles a GET /v2/frontends request.
+// The result can be filtered with the same query parameters as GET /v1/frontend and is ordered by ID.
+func (m *Middleware) V2Frontends(svc api.API, res http.ResponseWriter, req *http.Request) error {
+	query, err := parseFrontendQuery(req.URL.Query())
+	if err != nil {
+		return m.v2Error(res, maskAny(err))
+	}
+	all, err := svc.All()
+	if err != nil {
+		return m.v2Error(res, maskAny(err))
+	}
+	var ids []string
+	for id, record := range all {
+		if query.Matches(record) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	page := v2Page{Total: len(ids), Offset: query.Offset, Limit: query.Limit}
+	first, last := page.bounds()
+	result := []interface{}{}
+	for _, id := range ids[first:last] {
+		if len(query.Fields) == 0 {
+			result = append(result, v2Frontend{ID: id, FrontendRecord: all[id]})
+			continue
+		}
+		selected, err := selectFields(all[id], query.Fields)
+		if err != nil {
+			return m.v2Error(res, maskAny(err))
+		}
+		selected["id"] = id
+		result = append(result, selected)
+	}
+	return v2JSON(res, result, &page, http.StatusOK)
+}
+
+// V2Frontend handles a GET /v2/frontends/:id request.
+// If the backend supports versioning, the version of the record is also returned in an ETag header.
+func (m *Middleware) V2Frontend(ctx *macaron.Context, svc api.API, res http.ResponseWriter, req *http.Request) error {
+	result := v2Frontend{ID: ctx.Params("id")}
+	var err error
+	if vs, ok := svc.(api.VersionedAPI); ok {
+		result.FrontendRecord, result.Version, err = vs.GetVersioned(result.ID)
+		if err == nil {
+			res.Header().Set("ETag", api.FormatETag(result.Version))
+		}
+	} else {
+		result.FrontendRecord, err = svc.Get(result.ID)
+	}
+	if err != nil {
+		return m.v2Error(res, maskAny(err))
+	}
+	return v2JSON(res, result, nil, http.StatusOK)
+}
+
+// V2AddFrontend handles a POST /v2/frontends/:id request.
+func (m *Middleware) V2AddFrontend(ctx *macaron.Context, svc api.API, res http.ResponseWriter, req *http.Request) error {
+	result := v2Frontend{ID: ctx.Params("id")}
+	if err := parseBody(req, &result.FrontendRecord); err != nil {
+		return m.v2Error(res, maskAny(errgo.WithCausef(nil, api.ValidationError, "invalid body: %v", err)))
+	}
+	if err := svc.Add(result.ID, result.FrontendRecord); err != nil {
+		return m.v2Error(res, maskAny(err))
+	}
+	return v2JSON(res, result, nil, http.StatusCreated)
+}
+
+// V2UpdateFrontend handles a PUT /v2/frontends/:id request.
+// An If-Match header is used to detect concurrent modifications.
+func (m *Middleware) V2UpdateFrontend(ctx *macaron.Context, svc api.API, res http.ResponseWriter, req *http.Request) error {
+	result := v2Frontend{ID: ctx.Params("id")}
+	if err := parseBody(req, &result.FrontendRecord); err != nil {
+		return m.v2Error(res, maskAny(errgo.WithCausef(nil, api.ValidationError, "invalid body: %v", err)))
+	}
+	vs, version, err := m.versionedService(svc, req)
+	if err != nil {
+		return m.v2Error(res, maskAny(err))
+	}
+	if err := vs.Update(result.ID, result.FrontendRecord, version); err != nil {
+		return m.v2Error(res, maskAny(err))
+	}
+	return v2JSON(res, result, nil, http.StatusOK)
+}
+
+// V2RemoveFrontend handles a DELETE /v2/frontends/:id request.
+// An If-Match header is used to detect concurrent modifications.
+func (m *Middleware) V2RemoveFrontend(ctx *macaron.Context, svc api.API, res http.ResponseWriter, req *http.Request) error {
+	id := ctx.Params("id")
+	var err error
+	if req.Header.Get(ifMatchHeader) == "" && !m.RequireIfMatch {
+		err = svc.Remove(id)
+	} else {
+		var vs api.VersionedAPI
+		var version string
+		if vs, version, err = m.versionedService(svc, req); err == nil {
+			err = vs.RemoveVersioned(id, version)
+		}
+	}
+	if err != nil {
+		return m.v2Error(res, maskAny(err))
+	}
+	return v2JSON(res, map[string]string{"id": id}, nil, http.StatusOK)
+}
+
+// V2Services handles a GET /v2/services?name=...&mode=...&public=... request.
+// It returns the services of the current configuration, as computed from the backend.
+func (m *Middleware) V2Services(res http.ResponseWriter, req *http.Request) error {
+	query := req.URL.Query()
+	page, err := parseV2Page(query)
+	if err != nil {
+		return m.v2Error(res, maskAny(err))
+	}
+	public, err := parseOptionalBool(query, "public")
+	if err != nil {
+		return m.v2Error(res, maskAny(err))
+	}
+	result := backend.ServiceRegistrations{}
+	for _, sr := range m.currentServices() {
+		if name := query.Get("name"); name != "" && sr.ServiceName != name {
+			continue
+		}
+		if mode := query.Get("mode"); mode != "" && sr.Mode != mode {
+			continue
+		}
+		if public != nil && sr.Public != *public {
+			continue
+		}
+		result = append(result, sr)
+	}
+	page.Total = len(result)
+	first, last := page.bounds()
+	return v2JSON(res, result[first:last], &page, http.StatusOK)
+}
+
+// V2Instances handles a GET /v2/instances?service=...&health=... request.
+// It returns the instances of all services of the current configuration.
+func (m *Middleware) V2Instances(res http.ResponseWriter, req *http.Request) error {
+	query := req.URL.Query()
+	page, err := parseV2Page(query)
+	if err != nil {
+		return m.v2Error(res, maskAny(err))
+	}
+	health, filterHealth := query["health"]
+	result := []v2Instance{}
+	for _, sr := range m.currentServices() {
+		if name := query.Get("service"); name != "" && sr.ServiceName != name {
+			continue
+		}
+		for _, si := range sr.Instances {
+			if filterHealth && si.Health != health[0] {
+				continue
+			}
+			result = append(result, v2Instance{
+				Service:  sr.ServiceName,
+				EdgePort: sr.EdgePort,
+				Public:   sr.Public,
+				Instance: si,
+			})
+		}
+	}
+	page.Total = len(result)
+	first, last := page.bounds()
+	return v2JSON(res, result[first:last], &page, http.StatusOK)
+}
+
+// V2Backends handles a GET /v2/backends?service=...&frontend=... request.
+// It returns the HAProxy backends of the current configuration, ordered by name.
+func (m *Middleware) V2Backends(res http.ResponseWriter, req *http.Request) error {
+	query := req.URL.Query()
+	page, err := parseV2Page(query)
+	if err != nil {
+		return m.v2Error(res, maskAny(err))
+	}
+	result := []service.Backend{}
+	if m.Config != nil {
+		for _, b := range m.Config.Backends() {
+			if name := query.Get("service"); name != "" && b.Service != name {
+				continue
+			}
+			if f := query.Get("frontend"); f != "" && b.Frontend != f {
+				continue
+			}
+			result = append(result, b)
+		}
+	}
+	page.Total = len(result)
+	first, last := page.bounds()
+	return v2JSON(res, result[first:last], &page, http.StatusOK)
+}
+
+// V2Backend handles a GET /v2/backends/:name request.
+func (m *Middleware) V2Backend(ctx *macaron.Context, res http.ResponseWriter, req *http.Request) error {
+	name := ctx.Params("name")
+	if m.Config != nil {
+		for _, b := range m.Config.Backends() {
+			if b.Name == name {
+				return v2JSON(res, b, nil, http.StatusOK)
+			}
+		}
+	}
+	return m.v2Error(res, restkit.NotFoundError("backend '"+name+"' not found", 0))
+}
+
+// V2Certificates handles a GET /v2/certificates request.
+// It returns the renewal status of all ACME certificates.
+func (m *Middleware) V2Certificates(res http.ResponseWriter, req *http.Request) error {
+	page, err := parseV2Page(req.URL.Query())
+	if err != nil {
+		return m.v2Error(res, maskAny(err))
+	}
+	result := []acme.DomainRenewalStatus{}
+	if m.Renewal != nil {
+		result = append(result, m.Renewal.Status()...)
+	}
+	sort.Sort(renewalStatusesByDomain(result))
+	page.Total = len(result)
+	first, last := page.bounds()
+	return v2JSON(res, result[first:last], &page, http.StatusOK)
+}
+
+// renewalStatusesByDomain sorts a list of certificate renewal statuses by domain.
+type renewalStatusesByDomain []acme.DomainRenewalStatus
+
+func (l renewalStatusesByDomain) Len() int           { return len(l) }
+func (l renewalStatusesByDomain) Less(i, j int) bool { return l[i].Domain < l[j].Domain }
+func (l renewalStatusesByDomain) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// V2Certificate handles a GET /v2/certificates/:domain request.
+func (m *Middleware) V2Certificate(ctx *macaron.Context, res http.ResponseWriter, req *http.Request) error {
+	domain := strings.ToLower(ctx.Params("domain"))
+	if m.Renewal != nil {
+		for _, status := range m.Renewal.Status() {
+			if strings.ToLower(status.Domain) == domain {
+				return v2JSON(res, status, nil, http.StatusOK)
+			}
+		}
+	}
+	return m.v2Error(res, restkit.NotFoundError("certificate for '"+domain+"' not found", 0))
+}
+
+// currentServices returns the services of the current configuration (if any).
+func (m *Middleware) currentServices() backend.ServiceRegistrations {
+	if m.Config == nil {
+		return nil
+	}
+	return m.Config.Services()
+}
+
+// parseV2Page parses the offset & limit query parameters.
+func parseV2Page(values url.Values) (v2Page, error) {
+	var page v2Page
+	var err error
+	if page.Offset, err = parseNonNegativeInt(values, "offset"); err != nil {
+		return page, maskAny(err)
+	}
+	if page.Limit, err = parseNonNegativeInt(values, "limit"); err != nil {
+		return page, maskAny(err)
+	}
+	return page, nil
+}
+
+// bounds returns the range [first, last) of the items in the page.
+func (p v2Page) bounds() (int, int) {
+	first := p.Offset
+	if first > p.Total {
+		first = p.Total
+	}
+	last := p.Total
+	if p.Limit > 0 && first+p.Limit < last {
+		last = first + p.Limit
+	}
+	return first, last
+}
+
+func parseOptionalBool(values url.Values, key string) (*bool, error) {
+	v := values.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+	result, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, maskAny(errgo.WithCausef(nil, api.ValidationError, "invalid %s '%s'", key, v))
+	}
+	return &result, nil
+}
+
+// v2JSON sends the given data (and page) as a /v2 response.
+func v2JSON(res http.ResponseWriter, data interface{}, page *v2Page, code int) error {
+	return restkit.JSON(res, v2Response{Data: data, Page: page}, code)
+}
+
+// v2Error sends the given error as a /v2 response.
+func (m *Middleware) v2Error(res http.ResponseWriter, err error) error {
+	m.Logger.Debugf("Error: %#v", err)
+	er := restkit.NewErrorResponseFromError(err)
+	var result v2ErrorResponse
+	result.Error.Status = er.HTTPStatusCode()
+	result.Error.Message = er.TheError.Message
+	if er.TheError.Code > 0 {
+		result.Error.Code = er.TheError.Code
+	}
+	return restkit.JSON(res, result, result.Error.Status)
+}
+
+// requestError sends the given error in the format of the API version of the given request.
+func (m *Middleware) requestError(res http.ResponseWriter, req *http.Request, err error) error {
+	if strings.HasPrefix(req.URL.Path, v2Prefix) {
+		return m.v2Error(res, err)
+	}
+	return m.mapError(res, err)
+}
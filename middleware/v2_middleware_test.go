@@ -0,0 +1,237 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	api "github.com/pulcy/robin-api"
+
+	"github.com/pulcy/robin/service"
+	"github.com/pulcy/robin/service/acme"
+	"github.com/pulcy/robin/service/backend"
+)
+
+// fakeConfig is a service.ConfigInspector that returns fixed services & backends.
+type fakeConfig struct {
+	service.ConfigInspector
+	services backend.ServiceRegistrations
+	backends []service.Backend
+}
+
+func (c fakeConfig) Services() backend.ServiceRegistrations { return c.services }
+func (c fakeConfig) Backends() []service.Backend            { return c.backends }
+
+// fakeRenewal is an acme.RenewalMonitor that returns a fixed status.
+type fakeRenewal struct {
+	acme.RenewalMonitor
+	status []acme.DomainRenewalStatus
+}
+
+func (r fakeRenewal) Status() []acme.DomainRenewalStatus { return r.status }
+
+// v2Result is the decoded body of a /v2 response.
+type v2Result struct {
+	Data  json.RawMessage  `json:"data"`
+	Page  *v2Page          `json:"page"`
+	Error *json.RawMessage `json:"error"`
+}
+
+func decodeV2(t *testing.T, body []byte) v2Result {
+	var result v2Result
+	if err := json.Unmarshal(body, &result); err != nil {
+		t.Fatalf("Cannot decode /v2 response '%s': %#v", string(body), err)
+	}
+	return result
+}
+
+func TestV2Frontends(t *testing.T) {
+	svc := newFakeAPI()
+	for _, id := range []string{"c", "a", "b"} {
+		if err := svc.Add(id, api.FrontendRecord{
+			Service:   "web-" + id,
+			Selectors: []api.FrontendSelectorRecord{api.FrontendSelectorRecord{Domain: id + ".com"}},
+		}); err != nil {
+			t.Fatalf("Add failed: %#v", err)
+		}
+	}
+	h := newTestMiddleware(svc).SetupRoutes("robin", "test", "test")
+
+	// Collections are ordered by ID and paged
+	rec := serve(h, "GET", "/v2/frontends?offset=1&limit=1", "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	result := decodeV2(t, rec.Body.Bytes())
+	var frontends []v2Frontend
+	if err := json.Unmarshal(result.Data, &frontends); err != nil {
+		t.Fatalf("Cannot decode frontends: %#v", err)
+	}
+	if len(frontends) != 1 || frontends[0].ID != "b" || frontends[0].Service != "web-b" {
+		t.Errorf("Expected frontend b, got %#v", frontends)
+	}
+	if expected := (v2Page{Total: 3, Offset: 1, Limit: 1}); result.Page == nil || *result.Page != expected {
+		t.Errorf("Expected page %#v, got %#v", expected, result.Page)
+	}
+
+	// Selected fields always contain the ID
+	rec = serve(h, "GET", "/v2/frontends?service=web-a&fields=service", "", "")
+	result = decodeV2(t, rec.Body.Bytes())
+	var selected []map[string]interface{}
+	json.Unmarshal(result.Data, &selected)
+	if expected := []map[string]interface{}{{"id": "a", "service": "web-a"}}; !reflect.DeepEqual(selected, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, selected)
+	}
+
+	// Invalid queries return a /v2 error
+	rec = serve(h, "GET", "/v2/frontends?limit=-1", "", "")
+	if result := decodeV2(t, rec.Body.Bytes()); rec.Code != http.StatusBadRequest || result.Error == nil {
+		t.Errorf("Expected /v2 validation error, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestV2FrontendLifecycle(t *testing.T) {
+	svc := newFakeAPI()
+	m := newTestMiddleware(svc)
+	m.RequireIfMatch = true
+	h := m.SetupRoutes("robin", "test", "test")
+	record := `{"service": "web", "selectors": [{"domain": "foo.com"}]}`
+
+	rec := serve(h, "POST", "/v2/frontends/web", "", record)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve(h, "POST", "/v2/frontends/web", "", record); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for duplicate ID, got %d", rec.Code)
+	}
+	if rec := serve(h, "POST", "/v2/frontends/other", "", "{invalid"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid body, got %d", rec.Code)
+	}
+
+	// Get returns the version in the body and in an ETag header
+	rec = serve(h, "GET", "/v2/frontends/web", "", "")
+	var frontend v2Frontend
+	json.Unmarshal(decodeV2(t, rec.Body.Bytes()).Data, &frontend)
+	if frontend.ID != "web" || frontend.Version != "1" || rec.Header().Get("ETag") != api.FormatETag("1") {
+		t.Errorf("Unexpected frontend %#v (ETag %s)", frontend, rec.Header().Get("ETag"))
+	}
+	if rec := serve(h, "GET", "/v2/frontends/missing", "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rec.Code)
+	}
+
+	// Updates require a matching If-Match header
+	updated := `{"service": "web", "selectors": [{"domain": "bar.com"}]}`
+	if rec := serve(h, "PUT", "/v2/frontends/web", "", updated); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412 without If-Match, got %d", rec.Code)
+	}
+	if rec := serve(h, "PUT", "/v2/frontends/web", "", updated, "If-Match", api.FormatETag("7")); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412 for old version, got %d", rec.Code)
+	}
+	if rec := serve(h, "PUT", "/v2/frontends/web", "", updated, "If-Match", api.FormatETag("1")); rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for update, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if record, _ := svc.Get("web"); record.Selectors[0].Domain != "bar.com" {
+		t.Errorf("Expected updated record, got %#v", record)
+	}
+
+	// Removals require a matching If-Match header
+	if rec := serve(h, "DELETE", "/v2/frontends/web", "", ""); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412 without If-Match, got %d", rec.Code)
+	}
+	if rec := serve(h, "DELETE", "/v2/frontends/web", "", "", "If-Match", api.FormatETag("2")); rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for removal, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := svc.Get("web"); !api.IsIDNotFound(err) {
+		t.Errorf("Expected record to be removed, got %#v", err)
+	}
+}
+
+func TestV2Configuration(t *testing.T) {
+	expiration := time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC)
+	m := newTestMiddleware(newFakeAPI())
+	m.Config = fakeConfig{
+		services: backend.ServiceRegistrations{
+			backend.ServiceRegistration{ServiceName: "web", EdgePort: 80, Public: true, Mode: "http", Instances: backend.ServiceInstances{
+				backend.ServiceInstance{IP: "10.0.0.1", Port: 8080, Health: backend.HealthHealthy},
+				backend.ServiceInstance{IP: "10.0.0.2", Port: 8080, Health: backend.HealthDraining},
+			}},
+			backend.ServiceRegistration{ServiceName: "db", EdgePort: 5432, Mode: "tcp", Instances: backend.ServiceInstances{
+				backend.ServiceInstance{IP: "10.0.0.3", Port: 5432, Health: backend.HealthHealthy},
+			}},
+		},
+		backends: []service.Backend{
+			service.Backend{Name: "db_5432", Service: "db", Frontend: "private_tcp"},
+			service.Backend{Name: "web_80", Service: "web", Frontend: "public_http"},
+		},
+	}
+	m.Renewal = fakeRenewal{status: []acme.DomainRenewalStatus{
+		acme.DomainRenewalStatus{Domain: "foo.com", Expiration: &expiration},
+		acme.DomainRenewalStatus{Domain: "bar.com"},
+	}}
+	h := m.SetupRoutes("robin", "test", "test")
+
+	tests := []struct {
+		Path     string
+		Status   int
+		Field    string // Field of every item that is compared
+		Expected string // Comma separated values of the field
+	}{
+		{"/v2/services?public=true", 200, "ServiceName", "web"},
+		{"/v2/services?mode=tcp", 200, "ServiceName", "db"},
+		{"/v2/services?public=maybe", 400, "", ""},
+		{"/v2/instances?health=", 200, "instance.IP", "10.0.0.1,10.0.0.3"},
+		{"/v2/instances?service=web&offset=1", 200, "instance.IP", "10.0.0.2"},
+		{"/v2/instances?limit=x", 400, "", ""},
+		{"/v2/backends?frontend=public_http", 200, "name", "web_80"},
+		{"/v2/backends/db_5432", 200, "name", "db_5432"},
+		{"/v2/backends/missing", 404, "", ""},
+		{"/v2/certificates?limit=1", 200, "domain", "bar.com"},
+		{"/v2/certificates/FOO.com", 200, "domain", "foo.com"},
+		{"/v2/certificates/missing.com", 404, "", ""},
+	}
+	for _, test := range tests {
+		rec := serve(h, "GET", test.Path, "", "")
+		if rec.Code != test.Status {
+			t.Errorf("%s: expected status %d, got %d: %s", test.Path, test.Status, rec.Code, rec.Body.String())
+			continue
+		}
+		result := decodeV2(t, rec.Body.Bytes())
+		if test.Status != http.StatusOK {
+			if result.Error == nil {
+				t.Errorf("%s: expected /v2 error, got %s", test.Path, rec.Body.String())
+			}
+			continue
+		}
+		if got := v2FieldValues(t, result.Data, test.Field); got != test.Expected {
+			t.Errorf("%s: expected %s, got %s", test.Path, test.Expected, got)
+		}
+	}
+}
+
+// v2FieldValues returns the (comma separated) values of the given field of the given /v2 data,
+// which is a single item or a list of items. Nested fields are separated by '.'.
+func v2FieldValues(t *testing.T, data json.RawMessage, field string) string {
+	var items []map[string]interface{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		var item map[string]interface{}
+		if err := json.Unmarshal(data, &item); err != nil {
+			t.Fatalf("Cannot decode data '%s': %#v", string(data), err)
+		}
+		items = append(items, item)
+	}
+	var values []string
+	for _, item := range items {
+		var value interface{} = item
+		for _, key := range strings.Split(field, ".") {
+			if m, ok := value.(map[string]interface{}); ok {
+				value = m[key]
+			}
+		}
+		values = append(values, fmt.Sprintf("%v", value))
+	}
+	return strings.Join(values, ",")
+}
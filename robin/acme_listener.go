@@ -0,0 +1,45 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package robin
+
+import (
+	"sync"
+
+	"github.com/pulcy/robin/service"
+)
+
+// AcmeListener passes changes of ACME certificates to a load-balancer.
+// Since the ACME service must be created before the load-balancer, create it with
+// an AcmeListener and pass that listener in Config.AcmeListener.
+type AcmeListener struct {
+	mutex   sync.Mutex
+	service *service.Service
+}
+
+// attach connects the listener to the given service.
+func (l *AcmeListener) attach(s *service.Service) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.service = s
+}
+
+// CertificatesUpdated is called when there is a change in one of the ACME generated certificates
+func (l *AcmeListener) CertificatesUpdated() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.service != nil {
+		l.service.TriggerUpdate()
+	}
+}
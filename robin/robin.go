@@ -0,0 +1,218 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package robin allows other Go programs to embed the control loop of the load-balancer.
+package robin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/juju/errgo"
+	"github.com/op/go-logging"
+
+	"github.com/pulcy/robin/metrics"
+	"github.com/pulcy/robin/middleware"
+	"github.com/pulcy/robin/service"
+	"github.com/pulcy/robin/service/accounting"
+	"github.com/pulcy/robin/service/acme"
+	"github.com/pulcy/robin/service/backend"
+	"github.com/pulcy/robin/service/prober"
+	"github.com/pulcy/robin/service/sidecar"
+)
+
+// Config contains the configuration and the components of a load-balancer.
+type Config struct {
+	ProjectName    string
+	ProjectVersion string
+	ProjectBuild   string
+
+	Service service.ServiceConfig
+	API     APIConfig
+	Metrics metrics.MetricsConfig // Metrics are served if Metrics.Port is set (project & HAProxy process info is filled in)
+
+	Logger       *logging.Logger
+	Backend      backend.Backend       // Source of the services that are load-balanced (required)
+	AcmeService  acme.AcmeService      // If set, certificates are obtained using ACME
+	AcmeListener *AcmeListener         // If set, it passes certificate changes of AcmeService to the load-balancer
+	Renewal      acme.RenewalMonitor   // If set, the status of certificate renewals is available through the API
+	Prober       prober.Prober         // If set, instances of services with a probe-type are probed by Robin itself
+	Sidecars     []sidecar.Sidecar     // Processes (e.g. SPOE agents) that run next to HAProxy
+	Accountant   accounting.Accountant // If set, the traffic per service is recorded (requires a runtime socket)
+}
+
+// APIConfig contains the configuration of the API.
+type APIConfig struct {
+	Host           string
+	Port           int              // The API is served if this is set
+	RequireIfMatch bool             // If set, PUT & DELETE requests on frontends must contain an If-Match header
+	Token          string           // If set, requests must contain this token (or a tenant token) as bearer token
+	Compress       bool             // If set, responses are gzip compressed for clients that accept it
+	Tenants        []backend.Tenant // Tenants that can manage their own frontend records (requires a TenantBackend)
+
+	// If set, custom middleware & routes (e.g. for authentication or CORS) are added to the API
+	Hooks *middleware.Hooks
+}
+
+// Robin is a load-balancer that keeps HAProxy configured according to its backend.
+type Robin struct {
+	config    Config
+	service   *service.Service
+	startOnce sync.Once
+}
+
+// New creates a load-balancer from the given configuration.
+// It does not start anything, use Start or Run for that.
+func New(config Config) (*Robin, error) {
+	if config.Logger == nil {
+		return nil, maskAny(errgo.WithCausef(nil, InvalidArgumentError, "Logger must be set"))
+	}
+	if config.Backend == nil {
+		return nil, maskAny(errgo.WithCausef(nil, InvalidArgumentError, "Backend must be set"))
+	}
+	if config.Service.HaproxyConfPath == "" {
+		return nil, maskAny(errgo.WithCausef(nil, InvalidArgumentError, "Service.HaproxyConfPath must be set"))
+	}
+	if len(config.API.Tenants) > 0 {
+		if _, ok := config.Backend.(backend.TenantBackend); !ok {
+			return nil, maskAny(errgo.WithCausef(nil, InvalidArgumentError, "Tenants are not supported by the backend"))
+		}
+	}
+	s := service.NewService(config.Service, service.ServiceDependencies{
+		Logger:      config.Logger,
+		Backend:     config.Backend,
+		AcmeService: config.AcmeService,
+		Prober:      config.Prober,
+		Sidecars:    config.Sidecars,
+		Accountant:  config.Accountant,
+	})
+	if config.AcmeListener != nil {
+		config.AcmeListener.attach(s)
+	}
+	return &Robin{
+		config:  config,
+		service: s,
+	}, nil
+}
+
+// Service returns the service that configures HAProxy.
+func (r *Robin) Service() *service.Service {
+	return r.service
+}
+
+// Start runs the load-balancer in the background until the given context is canceled.
+// The metrics listener (if any) keeps running, since it cannot be stopped.
+func (r *Robin) Start(ctx context.Context) error {
+	if err := r.startListeners(ctx); err != nil {
+		return maskAny(err)
+	}
+	r.service.Start(ctx)
+	return nil
+}
+
+// Run runs the load-balancer and waits for OS signals to terminate the process.
+func (r *Robin) Run() error {
+	if err := r.startListeners(context.Background()); err != nil {
+		return maskAny(err)
+	}
+	r.service.Run()
+	return nil
+}
+
+// startListeners starts the API, ACME & metrics listeners.
+// The API is stopped and the backend is closed (if it can be closed) when the given context is canceled.
+func (r *Robin) startListeners(ctx context.Context) error {
+	started := false
+	r.startOnce.Do(func() { started = true })
+	if !started {
+		return maskAny(fmt.Errorf("Already started"))
+	}
+
+	if r.config.API.Port != 0 {
+		r.startAPI(ctx)
+	}
+	if r.config.AcmeService != nil {
+		if err := r.config.AcmeService.Start(); err != nil {
+			return maskAny(fmt.Errorf("Failed to start ACME service: %#v", err))
+		}
+	}
+	if r.config.Metrics.Port != 0 {
+		metricsConfig := r.config.Metrics
+		metricsConfig.ProjectName = r.config.ProjectName
+		metricsConfig.ProjectVersion = r.config.ProjectVersion
+		metricsConfig.ProjectBuild = r.config.ProjectBuild
+		metricsConfig.MinInstances = r.service.MinInstances
+		metricsConfig.OldProcesses = r.service.OldConnections
+		if err := metrics.StartMetricsListener(metricsConfig, r.config.Logger); err != nil {
+			return maskAny(fmt.Errorf("Failed to start metrics: %#v", err))
+		}
+	}
+	if closer, ok := r.config.Backend.(io.Closer); ok {
+		go func() {
+			<-ctx.Done()
+			closer.Close()
+		}()
+	}
+	return nil
+}
+
+// startAPI serves the API until the given context is canceled.
+func (r *Robin) startAPI(ctx context.Context) {
+	apiMiddleware := middleware.Middleware{
+		Logger:           r.config.Logger,
+		Service:          r.config.Backend,
+		Renewal:          r.config.Renewal,
+		Config:           r.service,
+		HistoryInspector: r.service,
+		Backend:          r.config.Backend,
+
+		RequireIfMatch: r.config.API.RequireIfMatch,
+		APIToken:       r.config.API.Token,
+		Compress:       r.config.API.Compress,
+		Tenants:        r.config.API.Tenants,
+		Accounting:     r.config.Accountant,
+		Hooks:          r.config.API.Hooks,
+	}
+	if r.config.Service.RuntimeSocketPath != "" {
+		apiMiddleware.Drainer = r.service
+		apiMiddleware.Quotas = r.service
+		if r.config.Service.IPBan.IsEnabled() {
+			apiMiddleware.BanManager = r.service
+		}
+	}
+	handler := apiMiddleware.SetupRoutes(r.config.ProjectName, r.config.ProjectVersion, r.config.ProjectBuild)
+	server := &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", r.config.API.Host, r.config.API.Port),
+		Handler: handler,
+	}
+	r.config.Logger.Infof("Starting %s API (version %s build %s) on %s\n", r.config.ProjectName, r.config.ProjectVersion, r.config.ProjectBuild, server.Addr)
+	go func() {
+		listener, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			r.config.Logger.Fatalf("API Listen failed: %#v", err)
+		}
+		go func() {
+			// Closing the listener stops Serve
+			<-ctx.Done()
+			listener.Close()
+		}()
+		if err := server.Serve(listener); err != nil && ctx.Err() == nil {
+			r.config.Logger.Fatalf("API Serve failed: %#v", err)
+		}
+	}()
+}
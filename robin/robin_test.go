@@ -0,0 +1,22 @@
+package robin
+
+import (
+	"testing"
+
+	logging "github.com/op/go-logging"
+
+	"github.com/pulcy/robin/service"
+)
+
+func TestNewInvalidConfig(t *testing.T) {
+	log := logging.MustGetLogger("test")
+	tests := map[string]Config{
+		"no logger":  Config{Service: service.ServiceConfig{HaproxyConfPath: "/tmp/haproxy.cfg"}},
+		"no backend": Config{Logger: log, Service: service.ServiceConfig{HaproxyConfPath: "/tmp/haproxy.cfg"}},
+	}
+	for name, config := range tests {
+		if _, err := New(config); !IsInvalidArgument(err) {
+			t.Errorf("Expected invalid argument error for %s, got %v", name, err)
+		}
+	}
+}
@@ -17,29 +17,45 @@ package main
 import (
 	"context"
 	"fmt"
-	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"strings"
 	"time"
 
+	k8shttp "github.com/YakLabs/k8s-client/http"
 	"github.com/coreos/etcd/client"
+	"github.com/coreos/etcd/clientv3"
 	"github.com/op/go-logging"
+	api "github.com/pulcy/robin-api"
 	"github.com/spf13/cobra"
 
+	"github.com/pulcy/robin/haproxy"
 	"github.com/pulcy/robin/metrics"
 	"github.com/pulcy/robin/middleware"
+	"github.com/pulcy/robin/robin"
 	"github.com/pulcy/robin/service"
+	"github.com/pulcy/robin/service/accounting"
 	"github.com/pulcy/robin/service/acme"
 	"github.com/pulcy/robin/service/backend"
 	"github.com/pulcy/robin/service/mutex"
+	"github.com/pulcy/robin/service/prober"
+	"github.com/pulcy/robin/service/sidecar"
 )
 
 const (
 	etcdLocksFolder   = "lb/locks"
 	etcdAcmeFolder    = "lb/acme"
 	etcdLogName       = "etcd"
+	etcd3DialTimeout  = 5 * time.Second
 	kubernetesLogName = "kubernetes"
+	nomadLogName      = "nomad"
+	dnsLogName        = "dns"
+
+	defaultBackendTimeout      = 30 * time.Second
+	defaultBackendWatchTimeout = 5 * time.Minute
+
+	acmeChallengeConfigMapName = "robin-acme-challenges"
 )
 
 var (
@@ -51,26 +67,78 @@ var (
 	}
 
 	runArgs struct {
-		backend            string
-		logLevel           string
-		etcdLogLevel       string
-		kubernetesLogLevel string
-		etcdAddr           string
-		etcdEndpoints      []string
-		etcdPath           string
-		etcdNoSync         bool
-		haproxyConfPath    string
-		statsPort          int
-		statsUser          string
-		statsPassword      string
-		statsSslCert       string
-		sslCertsFolder     string
-		forceSsl           bool
-		privateHost        string
-		publicHost         string
-		privateTcpSslCert  string
-		excludePublic      bool
-		excludePrivate     bool
+		backend                 string
+		logLevel                string
+		etcdLogLevel            string
+		kubernetesLogLevel      string
+		kubernetesNamespaces    []string
+		kubernetesIngressLabels string
+		ingressClass            string
+		ingressWithoutClass     bool
+		kubernetesFrontends     bool
+		legacyEndpoints         bool
+		kubernetesPodWeights    bool
+		nomadLogLevel           string
+		nomadAddr               string
+		nomadToken              string
+		nomadNamespaces         []string
+		nomadTagPrefix          string
+		dnsLogLevel             string
+		dnsFrontends            string
+		dnsServers              []string
+		dnsMinInterval          time.Duration
+		dnsMaxInterval          time.Duration
+		etcdAddr                string
+		etcdEndpoints           []string
+		etcdPath                string
+		etcdNoSync              bool
+		etcdAPIVersion          int
+		etcdSyncInterval        time.Duration
+		etcdSyncMaxBackoff      time.Duration
+		haproxyConfPath         string
+		haproxyVersion          string
+		haproxySocketPath       string
+		prober                  bool
+		probeTimeout            time.Duration
+		reloadGracePeriod       time.Duration
+		tcpPortRange            string
+		externalResolvers       []string
+		externalCAFile          string
+		zone                    string
+		maxBackends             int
+		maxAclsPerFrontend      int
+		maxConfigSize           int
+		mapFilesFolder          string
+		cacheSize               int
+		spoeAgents              []string
+		spoeAgentCommands       []string
+		blocklists              []string
+		blocklistInterval       time.Duration
+		crowdSecURL             string
+		crowdSecAPIKey          string
+		luaScriptsFolder        string
+		quotaErrorFile          string
+		usageFile               string
+		historySize             int
+		deregistrationGrace     time.Duration
+		backendTimeout          time.Duration
+		backendWatchTimeout     time.Duration
+		backendWatchBackoff     service.WatchBackoffConfig
+		statsPort               int
+		statsUser               string
+		statsPassword           string
+		statsSslCert            string
+		sslCertsFolder          string
+		forceSsl                bool
+		forceSslExemptPaths     []string
+		privateDenyByDefault    bool
+		privateGateway          bool
+		privateHost             string
+		publicHost              string
+		privateTcpSslCert       string
+		excludePublic           bool
+		excludePrivate          bool
+		edgeGroup               string
 
 		// acme
 		acmeHttpPort       int
@@ -80,49 +148,137 @@ var (
 		privateKeyPath     string
 		registrationPath   string
 		tmpCertificatePath string
+		privateCADirURLs   []string
+		acmeAccountsPath   string
+		encryptionKey      string
+		encryptionKeyPath  string
+		encryptionKMS      struct {
+			address    string
+			keyName    string
+			token      string
+			ciphertext string
+		}
+		challengeStore     string
+		acmeRepository     string
+		acmeRepositoryPath string
+		challengeNamespace string
+		challengePeers     []string
 
 		// metrics
 		metricsHost      string
 		metricsPort      int
+		tlsStatsAddress  string
+		accessLog        service.AccessLogConfig
+		ipBan            service.IPBanConfig
+		protectSensitive bool
+		realIP           service.RealIPConfig
+		sensitivePaths   []string
+		sensitiveSources []string
+		sensitiveUsers   []string
 		privateStatsPort int
+		statsCompression bool
+		metricsCompress  bool
+		metricsRuntime   bool
+		metricsPprof     bool
 
 		// api
-		apiHost string
-		apiPort int
+		apiHost           string
+		apiPort           int
+		apiRequireIfMatch bool
+		apiToken          string
+		apiCompress       bool
+		apiPlugins        []string
+		tenantsFile       string
 	}
 
 	etcdLog       = logging.MustGetLogger(etcdLogName)
 	kubernetesLog = logging.MustGetLogger(kubernetesLogName)
+	nomadLog      = logging.MustGetLogger(nomadLogName)
+	dnsLog        = logging.MustGetLogger(dnsLogName)
 )
 
-type acmeServiceListener struct {
-	service *service.Service
-}
-
 func init() {
 	defaultAcmeEmail := os.Getenv("ACME_EMAIL")
 	defaultStatsPassword := os.Getenv("STATS_PASSWORD")
 	defaultStatsUser := os.Getenv("STATS_USER")
-	cmdRun.Flags().StringVar(&runArgs.backend, "backend", defaultBackend, "Used backend (etcd|kubernetes)")
+	defaultNomadAddr := os.Getenv("NOMAD_ADDR")
+	if defaultNomadAddr == "" {
+		defaultNomadAddr = "http://127.0.0.1:4646"
+	}
+	cmdRun.Flags().StringVar(&runArgs.backend, "backend", defaultBackend, "Used backend (etcd|kubernetes|nomad|dns)")
 	cmdRun.Flags().StringVar(&runArgs.logLevel, "log-level", defaultLogLevel, "Log level (debug|info|warning|error)")
 	cmdRun.Flags().StringVar(&runArgs.etcdLogLevel, "etcd-log-level", "", "Log level for ETCD backend (debug|info|warning|error)")
 	cmdRun.Flags().StringVar(&runArgs.kubernetesLogLevel, "kubernetes-log-level", "", "Log level for Kubernetes backend (debug|info|warning|error)")
+	cmdRun.Flags().StringSliceVar(&runArgs.kubernetesNamespaces, "kubernetes-namespace", nil, "Namespace watched by the Kubernetes backend (default all)")
+	cmdRun.Flags().StringVar(&runArgs.kubernetesIngressLabels, "kubernetes-ingress-label-selector", "", "Labels (key=value[,key=value]) of the ingresses watched by the Kubernetes backend (default all)")
+	cmdRun.Flags().StringVar(&runArgs.ingressClass, "ingress-class", "", "Class of the ingresses served by the Kubernetes backend, matched against the kubernetes.io/ingress.class annotation and the ingressClassName field (default all)")
+	cmdRun.Flags().BoolVar(&runArgs.ingressWithoutClass, "ingress-without-class", false, "If set, ingresses without a class are also served when --ingress-class is set")
+	cmdRun.Flags().BoolVar(&runArgs.kubernetesFrontends, "kubernetes-frontends", false, "If set, the Kubernetes backend serves RobinFrontend custom resources (requires their CustomResourceDefinition)")
+	cmdRun.Flags().BoolVar(&runArgs.legacyEndpoints, "kubernetes-legacy-endpoints", false, "If set, the Kubernetes backend watches Endpoints instead of EndpointSlices")
+	cmdRun.Flags().BoolVar(&runArgs.kubernetesPodWeights, "kubernetes-pod-weights", false, "If set, the Kubernetes backend watches pods for a "+backend.PodWeightAnnotationKey+" annotation containing the weight (0-256) of their instances")
+	cmdRun.Flags().StringVar(&runArgs.nomadLogLevel, "nomad-log-level", "", "Log level for Nomad backend (debug|info|warning|error)")
+	cmdRun.Flags().StringVar(&runArgs.nomadAddr, "nomad-addr", defaultNomadAddr, "Address of the Nomad HTTP API used by the Nomad backend")
+	cmdRun.Flags().StringVar(&runArgs.nomadToken, "nomad-token", os.Getenv("NOMAD_TOKEN"), "ACL token used by the Nomad backend")
+	cmdRun.Flags().StringSliceVar(&runArgs.nomadNamespaces, "nomad-namespace", nil, "Namespace watched by the Nomad backend (default all)")
+	cmdRun.Flags().StringVar(&runArgs.nomadTagPrefix, "nomad-tag-prefix", backend.DefaultNomadTagPrefix, "Prefix of the service tags that contain the frontends of Nomad services (e.g. urlprefix-foo.com/api)")
+	cmdRun.Flags().StringVar(&runArgs.dnsLogLevel, "dns-log-level", "", "Log level for DNS backend (debug|info|warning|error)")
+	cmdRun.Flags().StringVar(&runArgs.dnsFrontends, "dns-frontends", "", "Path of JSON file containing the frontend records (by ID) of the DNS backend, their service is the SRV name that is resolved")
+	cmdRun.Flags().StringSliceVar(&runArgs.dnsServers, "dns-server", nil, "DNS server (host:port) queried by the DNS backend (default nameservers of /etc/resolv.conf)")
+	cmdRun.Flags().DurationVar(&runArgs.dnsMinInterval, "dns-min-interval", 5*time.Second, "Minimum time between resolves of the DNS backend, used when TTLs are shorter")
+	cmdRun.Flags().DurationVar(&runArgs.dnsMaxInterval, "dns-max-interval", 5*time.Minute, "Maximum time between resolves of the DNS backend, used when TTLs are longer")
 	cmdRun.Flags().StringVar(&runArgs.etcdAddr, "etcd-addr", "", "Address of etcd backend")
 	cmdRun.Flags().StringSliceVar(&runArgs.etcdEndpoints, "etcd-endpoint", nil, "Etcd client endpoints")
 	cmdRun.Flags().StringVar(&runArgs.etcdPath, "etcd-path", "", "Path into etcd namespace")
 	cmdRun.Flags().BoolVar(&runArgs.etcdNoSync, "etcd-no-sync", false, "If set, Robin will not sync the ETCD endpoints")
+	cmdRun.Flags().IntVar(&runArgs.etcdAPIVersion, "etcd-api-version", defaultEtcdAPIVersion, "Version of the ETCD API (2|3)")
+	cmdRun.Flags().DurationVar(&runArgs.etcdSyncInterval, "etcd-sync-interval", backend.DefaultEtcdSyncInterval, "Time between syncs of the ETCD endpoints")
+	cmdRun.Flags().DurationVar(&runArgs.etcdSyncMaxBackoff, "etcd-sync-max-backoff", backend.DefaultEtcdSyncMaxBackoff, "Maximum time between retries of a failed sync of the ETCD endpoints")
 	cmdRun.Flags().StringVar(&runArgs.haproxyConfPath, "haproxy-conf", "/data/config/haproxy.cfg", "Path of haproxy config file")
+	cmdRun.Flags().StringVar(&runArgs.haproxyVersion, "haproxy-version", "", "Version of HAProxy (e.g. 2.4) to generate native directives for. If empty, legacy directives are generated")
+	cmdRun.Flags().StringVar(&runArgs.haproxySocketPath, "haproxy-socket", "", "Path of the HAProxy runtime API socket. If empty, no socket is created")
+	cmdRun.Flags().BoolVar(&runArgs.prober, "prober", false, "If set, Robin probes instances of services with a probe-type itself and pushes their state to HAProxy (requires --haproxy-socket)")
+	cmdRun.Flags().DurationVar(&runArgs.probeTimeout, "probe-timeout", time.Second*2, "Timeout of a single probe")
+	cmdRun.Flags().StringVar(&runArgs.zone, "zone", "", "Availability zone of this load-balancer. Zone-aware services prefer instances in this zone")
+	cmdRun.Flags().DurationVar(&runArgs.reloadGracePeriod, "reload-grace-period", time.Second*10, "Time old HAProxy processes are given to finish their connections after a reload")
+	cmdRun.Flags().StringSliceVar(&runArgs.externalResolvers, "external-resolver", nil, "Nameserver (ip:port) used by HAProxy to resolve hosts of external URLs (default those of resolv.conf, requires HAProxy 1.9)")
+	cmdRun.Flags().StringVar(&runArgs.externalCAFile, "external-ca-file", "", "File containing the CA certificates that certificates of external URLs are verified against (default the system CA's)")
+	cmdRun.Flags().StringVar(&runArgs.tcpPortRange, "tcp-port-range", "", "Range of edge ports (<min>-<max>) that is bound up front for tcp services, so they can be added without changing the listeners")
+	cmdRun.Flags().DurationVar(&runArgs.deregistrationGrace, "deregistration-grace", 0, "If set, instances that leave the backend are kept in drain state for this period, so their connections can finish")
+	cmdRun.Flags().DurationVar(&runArgs.backendTimeout, "backend-timeout", defaultBackendTimeout, "Timeout of fetching services & certificates from the backend (0 means none)")
+	cmdRun.Flags().DurationVar(&runArgs.backendWatchTimeout, "backend-watch-timeout", defaultBackendWatchTimeout, "Maximum duration of a single watch of the backend before it is restarted (0 means none)")
+	cmdRun.Flags().DurationVar(&runArgs.backendWatchBackoff.Initial, "backend-watch-backoff", service.DefaultWatchBackoff, "Delay before retrying a failed watch of the backend, doubled with every consecutive failure")
+	cmdRun.Flags().DurationVar(&runArgs.backendWatchBackoff.Max, "backend-watch-max-backoff", service.DefaultWatchMaxBackoff, "Maximum delay before retrying a failed watch of the backend")
+	cmdRun.Flags().Float64Var(&runArgs.backendWatchBackoff.Jitter, "backend-watch-jitter", 0.2, "Fraction (0-1) of the retry delay of failed watches that is randomized")
+	cmdRun.Flags().IntVar(&runArgs.backendWatchBackoff.CircuitThreshold, "backend-watch-circuit-threshold", service.DefaultWatchCircuitThreshold, "Number of consecutive failed watches after which failures are no longer logged individually")
+	cmdRun.Flags().IntVar(&runArgs.maxBackends, "max-backends", 0, "Maximum number of backends in the haproxy config. If exceeded, the config is refused (0 means unlimited)")
+	cmdRun.Flags().IntVar(&runArgs.maxAclsPerFrontend, "max-acls-per-frontend", 0, "Maximum number of ACLs per frontend. If exceeded, domain-only routes are selected using a map file (0 means unlimited)")
+	cmdRun.Flags().IntVar(&runArgs.maxConfigSize, "max-config-size", 0, "Maximum size (in bytes) of the haproxy config. If exceeded, the config is refused (0 means unlimited)")
+	cmdRun.Flags().IntVar(&runArgs.cacheSize, "cache-size", service.DefaultCacheSize, "Size (in MB) of each response cache used by selectors with cache settings")
+	cmdRun.Flags().StringVar(&runArgs.mapFilesFolder, "map-files", "", "Folder in which map files are written. If empty, the folder of the haproxy config is used")
+	cmdRun.Flags().StringSliceVar(&runArgs.spoeAgents, "spoe-agent", nil, "SPOE agent that frontends can pass requests & responses through using filters (<name>=<host:port>)")
+	cmdRun.Flags().StringSliceVar(&runArgs.spoeAgentCommands, "spoe-agent-command", nil, "Command that runs a SPOE agent as sidecar process, restarted by Robin when it terminates (<name>=<command>)")
+	cmdRun.Flags().StringSliceVar(&runArgs.blocklists, "blocklist", nil, "External list of IP addresses & networks (e.g. Spamhaus DROP) that frontends can refuse (<name>=<url>)")
+	cmdRun.Flags().DurationVar(&runArgs.blocklistInterval, "blocklist-interval", service.DefaultBlocklistInterval, "Interval between fetches of blocklists")
+	cmdRun.Flags().StringVar(&runArgs.crowdSecURL, "crowdsec-url", "", "URL of a CrowdSec local API whose ban decisions form the 'crowdsec' blocklist")
+	cmdRun.Flags().StringVar(&runArgs.crowdSecAPIKey, "crowdsec-api-key", "", "API key of the CrowdSec bouncer")
+	cmdRun.Flags().StringVar(&runArgs.quotaErrorFile, "quota-error-file", service.DefaultQuotaErrorFile, "Error page (HTTP response) served when a traffic quota is exceeded")
+	cmdRun.Flags().StringVar(&runArgs.usageFile, "usage-file", "", "File in which the traffic per service is recorded for usage reports. If empty, usage is not recorded")
+	cmdRun.Flags().StringVar(&runArgs.luaScriptsFolder, "lua-scripts", "", "Folder containing Lua scripts (*.lua) that are loaded by HAProxy, next to the scripts stored in etcd")
 	cmdRun.Flags().IntVar(&runArgs.statsPort, "stats-port", defaultStatsPort, "Port for stats page")
 	cmdRun.Flags().StringVar(&runArgs.statsUser, "stats-user", defaultStatsUser, "User for stats page")
 	cmdRun.Flags().StringVar(&runArgs.statsPassword, "stats-password", defaultStatsPassword, "Password for stats page")
 	cmdRun.Flags().StringVar(&runArgs.statsSslCert, "stats-ssl-cert", defaultStatsSslCert, "Filename of SSL certificate for stats page (located in ssl-certs)")
 	cmdRun.Flags().StringVar(&runArgs.sslCertsFolder, "ssl-certs", defaultSslCertsFolder, "Folder containing SSL certificate")
 	cmdRun.Flags().BoolVar(&runArgs.forceSsl, "force-ssl", defaultForceSsl, "Redirect HTTP to HTTPS")
+	cmdRun.Flags().StringSliceVar(&runArgs.forceSslExemptPaths, "force-ssl-exempt-path", nil, "Path (prefix) that is not redirected to HTTPS by --force-ssl (ACME HTTP challenges never are)")
+	cmdRun.Flags().BoolVar(&runArgs.privateDenyByDefault, "private-deny-by-default", false, "Reject requests on the private HTTP frontend that match no private selector with 403")
+	cmdRun.Flags().BoolVar(&runArgs.privateGateway, "private-gateway", false, "Serve all HTTP services on the private HTTP frontend under /svc/<name>/")
 	cmdRun.Flags().StringVar(&runArgs.privateHost, "private-host", defaultPrivateHost, "IP address of private network")
 	cmdRun.Flags().StringVar(&runArgs.publicHost, "public-host", defaultPublicHost, "IP address of public network")
 	cmdRun.Flags().StringVar(&runArgs.privateTcpSslCert, "private-ssl-cert", defaultPrivateTcpSslCert, "Filename of SSL certificate for private TCP connections (located in ssl-certs)")
 	cmdRun.Flags().BoolVar(&runArgs.excludePrivate, "exclude-private", false, "Exclude private frontends")
 	cmdRun.Flags().BoolVar(&runArgs.excludePublic, "exclude-public", false, "Exclude public frontends")
+	cmdRun.Flags().StringVar(&runArgs.edgeGroup, "edge-group", "", "Name of the edge group served by this instance (empty for default group)")
 
 	// acme
 	cmdRun.Flags().IntVar(&runArgs.acmeHttpPort, "acme-http-port", defaultAcmeHttpPort, "Port to listen for ACME HTTP challenges on (internally)")
@@ -132,15 +288,56 @@ func init() {
 	cmdRun.Flags().StringVar(&runArgs.privateKeyPath, "private-key-path", defaultPrivateKeyPath(), "Path of the private key for the registered account")
 	cmdRun.Flags().StringVar(&runArgs.registrationPath, "registration-path", defaultRegistrationPath(), "Path of the registration resource for the registered account")
 	cmdRun.Flags().StringVar(&runArgs.tmpCertificatePath, "tmp-certificate-path", defaultTmpCertificatePath, "Path of obtained tmp certificates")
+	cmdRun.Flags().StringVar(&runArgs.acmeAccountsPath, "acme-accounts", "", "Path of JSON file containing additional ACME accounts selected by domain suffix")
+	cmdRun.Flags().StringVar(&runArgs.encryptionKey, "acme-encryption-key", "", "Hex encoded AES key used to encrypt certificates stored in ETCD")
+	cmdRun.Flags().StringVar(&runArgs.encryptionKeyPath, "acme-encryption-key-path", "", "Path of file containing hex encoded AES key used to encrypt certificates stored in ETCD")
+	cmdRun.Flags().StringVar(&runArgs.encryptionKMS.ciphertext, "acme-encryption-key-ciphertext", "", "AES key used to encrypt certificates stored in ETCD, encrypted by the KMS (HashiCorp Vault transit)")
+	cmdRun.Flags().StringVar(&runArgs.encryptionKMS.address, "acme-encryption-kms-address", os.Getenv("VAULT_ADDR"), "Address of the HashiCorp Vault server used to decrypt --acme-encryption-key-ciphertext")
+	cmdRun.Flags().StringVar(&runArgs.encryptionKMS.keyName, "acme-encryption-kms-key", "", "Name of the Vault transit key used to decrypt --acme-encryption-key-ciphertext")
+	cmdRun.Flags().StringVar(&runArgs.encryptionKMS.token, "acme-encryption-kms-token", os.Getenv("VAULT_TOKEN"), "Vault token used to decrypt --acme-encryption-key-ciphertext")
+	cmdRun.Flags().StringVar(&runArgs.challengeStore, "acme-challenge-store", defaultAcmeChallengeStore, "Store used for ACME HTTP challenges (etcd|memory|kubernetes)")
+	cmdRun.Flags().StringVar(&runArgs.acmeRepository, "acme-repository", defaultAcmeRepository, "Repository used for ACME certificates (etcd|file)")
+	cmdRun.Flags().StringVar(&runArgs.acmeRepositoryPath, "acme-repository-path", "", "Folder used for ACME certificates by the file repository")
+	cmdRun.Flags().StringVar(&runArgs.challengeNamespace, "acme-challenge-namespace", defaultAcmeChallengeNamespace, "Namespace of the ConfigMap used for ACME HTTP challenges by the kubernetes challenge store")
+	cmdRun.Flags().StringSliceVar(&runArgs.challengePeers, "acme-peer", nil, "Address (host:port) of the ACME HTTP challenge listener of another instance, used when a challenge is not found locally")
+	cmdRun.Flags().StringSliceVar(&runArgs.privateCADirURLs, "acme-private-directory-url", nil, "Directory URL of an internal ACME server for private domains (<domain-suffix>=<url>)")
 
 	// metrics
 	cmdRun.Flags().StringVar(&runArgs.metricsHost, "metrics-host", defaultMetricsHost, "Host address to listen for metrics requests")
 	cmdRun.Flags().IntVar(&runArgs.metricsPort, "metrics-port", defaultMetricsPort, "Port to listen for metrics requests")
+	cmdRun.Flags().StringVar(&runArgs.tlsStatsAddress, "tls-stats-address", "", "UDP address (host:port) used to receive HAProxy TLS logs for per-domain TLS metrics (e.g. 127.0.0.1:5140)")
+	cmdRun.Flags().StringVar(&runArgs.accessLog.Address, "access-log-address", "", "UDP address (host:port) to which HTTP requests are logged (syslog). If empty, requests are not logged")
+	cmdRun.Flags().IntVar(&runArgs.accessLog.SampleRate, "access-log-sample-rate", 0, "Only 1 in this many HTTP requests is logged (0 means all)")
+	cmdRun.Flags().StringSliceVar(&runArgs.accessLog.Headers, "access-log-header", nil, "Request header included in the access log")
+	cmdRun.Flags().StringSliceVar(&runArgs.accessLog.ScrubParams, "access-log-scrub-param", nil, "Query parameter whose value is replaced by *** in the access log (e.g. token)")
+	cmdRun.Flags().StringSliceVar(&runArgs.accessLog.ScrubPatterns, "access-log-scrub-pattern", nil, "Regular expression whose matches are replaced by *** in the access log (e.g. [^/?&=]+@[^/?&=]+ for emails)")
+	cmdRun.Flags().IntVar(&runArgs.ipBan.Failures, "ban-failures", 0, "Number of failed requests (within --ban-window) after which a source IP is banned (0 disables banning)")
+	cmdRun.Flags().DurationVar(&runArgs.ipBan.Window, "ban-window", service.DefaultBanWindow, "Period in which failed requests of a source IP are counted")
+	cmdRun.Flags().DurationVar(&runArgs.ipBan.Duration, "ban-duration", service.DefaultBanDuration, "Time a source IP is banned")
+	cmdRun.Flags().IntSliceVar(&runArgs.ipBan.Statuses, "ban-status", []int{401, 403}, "Response status of failed requests")
+	cmdRun.Flags().StringVar(&runArgs.realIP.Header, "real-ip-header", "", "Request header containing the client IP when behind a CDN or proxy (e.g. CF-Connecting-IP or X-Real-IP), requires --real-ip-trusted-proxy")
+	cmdRun.Flags().BoolVar(&runArgs.realIP.ProxyProtocol, "real-ip-proxy-protocol", false, "Accept the PROXY protocol (containing the client IP) on public frontends")
+	cmdRun.Flags().StringSliceVar(&runArgs.realIP.TrustedProxies, "real-ip-trusted-proxy", nil, "IP address or CIDR range of a proxy that is trusted to report the client IP (default all, for the PROXY protocol only)")
+	cmdRun.Flags().IntSliceVar(&runArgs.realIP.Ports, "real-ip-port", nil, "Edge port of a public frontend on which the client IP is extracted (default all)")
+	cmdRun.Flags().BoolVar(&runArgs.protectSensitive, "protect-sensitive-paths", false, "Protect sensitive paths of all services on public frontends, unless a frontend record exposes them")
+	cmdRun.Flags().StringSliceVar(&runArgs.sensitivePaths, "sensitive-path", service.DefaultSensitivePaths, "Path (prefix) that is protected by --protect-sensitive-paths")
+	cmdRun.Flags().StringSliceVar(&runArgs.sensitiveSources, "sensitive-path-source", nil, "IP address or CIDR range from which sensitive paths can be requested")
+	cmdRun.Flags().StringSliceVar(&runArgs.sensitiveUsers, "sensitive-path-user", nil, "User that can request sensitive paths, formatted as <name>:<password-hash>")
+	cmdRun.Flags().IntVar(&runArgs.historySize, "history-size", service.DefaultHistorySize, "Number of routing changes kept in the history (GET /v1/history)")
 	cmdRun.Flags().IntVar(&runArgs.privateStatsPort, "private-stats-port", defaultPrivateStatsPort, "HAProxy port CSV stats")
+	cmdRun.Flags().BoolVar(&runArgs.statsCompression, "stats-compression", false, "If set, the stats page is gzip compressed (requires HAProxy with zlib support)")
+	cmdRun.Flags().BoolVar(&runArgs.metricsRuntime, "metrics-runtime", false, "If set, Go runtime & process metrics are served on the metrics listener")
+	cmdRun.Flags().BoolVar(&runArgs.metricsPprof, "metrics-pprof", false, "If set, Go runtime profiling data is served on the metrics listener under /debug/pprof/")
+	cmdRun.Flags().BoolVar(&runArgs.metricsCompress, "metrics-compression", true, "If set, metrics responses are gzip compressed for clients that accept it")
 
 	// api
 	cmdRun.Flags().StringVar(&runArgs.apiHost, "api-host", defaultApiHost, "Host address to listen for API requests")
 	cmdRun.Flags().IntVar(&runArgs.apiPort, "api-port", defaultApiPort, "Port to listen for API requests")
+	cmdRun.Flags().BoolVar(&runArgs.apiRequireIfMatch, "api-require-if-match", false, "If set, updates & removals of frontends require an If-Match header")
+	cmdRun.Flags().StringVar(&runArgs.apiToken, "api-token", "", "If set, API requests must contain this token (or a tenant token) as bearer token")
+	cmdRun.Flags().BoolVar(&runArgs.apiCompress, "api-compression", true, "If set, API responses are gzip compressed for clients that accept it")
+	cmdRun.Flags().StringSliceVar(&runArgs.apiPlugins, "api-plugin", nil, "Go plugin (.so) that adds custom middleware & routes to the API (exporting "+middleware.PluginSymbol+")")
+	cmdRun.Flags().StringVar(&runArgs.tenantsFile, "tenants", "", "JSON file containing the tenants (name, tokens, max-domains, max-frontends) that manage their own frontends through the API")
 
 	cmdMain.AddCommand(cmdRun)
 }
@@ -155,68 +352,294 @@ func cmdRunRun(cmd *cobra.Command, args []string) {
 		runArgs.etcdEndpoints = []string{fmt.Sprintf("%s://%s", etcdUrl.Scheme, etcdUrl.Host)}
 		runArgs.etcdPath = etcdUrl.Path
 	}
-	etcdCfg := client.Config{
-		Endpoints: runArgs.etcdEndpoints,
-		Transport: client.DefaultTransport,
+	acmeStores := acme.StoresConfig{
+		ChallengeStore: runArgs.challengeStore,
+		Repository:     runArgs.acmeRepository,
+		RepositoryPath: runArgs.acmeRepositoryPath,
 	}
-	etcdClient, err := client.New(etcdCfg)
-	if err != nil {
-		Exitf("Failed to initialize ETCD client: %#v", err)
+	if err := acmeStores.Validate(); err != nil {
+		Exitf("Invalid ACME stores: %#v", err)
 	}
-
-	if !runArgs.etcdNoSync {
-		go etcdClient.AutoSync(context.Background(), time.Second*30)
+	var etcdClient client.Client
+	var etcd3Client *clientv3.Client
+	var err error
+	if runArgs.backend == "etcd" || acmeStores.NeedsEtcd() {
+		switch runArgs.etcdAPIVersion {
+		case 2:
+			etcdCfg := client.Config{
+				Endpoints: runArgs.etcdEndpoints,
+				Transport: client.DefaultTransport,
+			}
+			etcdClient, err = client.New(etcdCfg)
+		case 3:
+			etcd3Cfg := clientv3.Config{
+				Endpoints:   runArgs.etcdEndpoints,
+				DialTimeout: etcd3DialTimeout,
+			}
+			if !runArgs.etcdNoSync {
+				etcd3Cfg.AutoSyncInterval = runArgs.etcdSyncInterval
+			}
+			etcd3Client, err = clientv3.New(etcd3Cfg)
+		default:
+			Exitf("Unknown --etcd-api-version %d, expected 2 or 3", runArgs.etcdAPIVersion)
+		}
+		if err != nil {
+			Exitf("Failed to initialize ETCD client: %#v", err)
+		}
 	}
 
 	// Set log levels
 	setLogLevel(cmdMain.Use, runArgs.logLevel, runArgs.logLevel, "log-level")
 	setLogLevel(etcdLogName, runArgs.etcdLogLevel, runArgs.logLevel, "etcd-log-level")
 	setLogLevel(kubernetesLogName, runArgs.kubernetesLogLevel, runArgs.logLevel, "kubernetes-log-level")
+	setLogLevel(nomadLogName, runArgs.nomadLogLevel, runArgs.logLevel, "nomad-log-level")
+	setLogLevel(dnsLogName, runArgs.dnsLogLevel, runArgs.logLevel, "dns-log-level")
+
+	if etcdClient != nil && !runArgs.etcdNoSync {
+		go backend.AutoSyncEtcd(context.Background(), etcdClient, backend.EtcdSyncConfig{
+			Endpoints:  runArgs.etcdEndpoints,
+			Interval:   runArgs.etcdSyncInterval,
+			MaxBackoff: runArgs.etcdSyncMaxBackoff,
+		}, etcdLog)
+	}
+
+	var haproxyVersion haproxy.Version
+	if runArgs.haproxyVersion != "" {
+		haproxyVersion, err = haproxy.ParseVersion(runArgs.haproxyVersion)
+		if err != nil {
+			Exitf("Invalid --haproxy-version: %#v", err)
+		}
+	}
+
+	var blocklists []service.Blocklist
+	for _, x := range runArgs.blocklists {
+		bl, err := service.ParseBlocklist(x)
+		if err != nil {
+			Exitf("--blocklist '%s' is not valid: %#v", x, err)
+		}
+		blocklists = append(blocklists, bl)
+	}
+	if runArgs.crowdSecURL != "" {
+		blocklists = append(blocklists, service.Blocklist{
+			Name:   "crowdsec",
+			URL:    runArgs.crowdSecURL,
+			Format: service.BlocklistFormatCrowdSec,
+			APIKey: runArgs.crowdSecAPIKey,
+		})
+	}
 
 	// Prepare backend
+	backendConfig := etcdBackendConfig
+	backendConfig.EdgeGroup = runArgs.edgeGroup
+	backendConfig.HaproxyVersion = haproxyVersion
+	for _, bl := range blocklists {
+		backendConfig.Blocklists = append(backendConfig.Blocklists, bl.Name)
+	}
 	var b backend.Backend
 	switch runArgs.backend {
 	case "etcd":
-		b, err = backend.NewEtcdBackend(etcdBackendConfig, etcdLog, etcdClient, runArgs.etcdPath)
+		if etcd3Client != nil {
+			b, err = backend.NewEtcd3Backend(backendConfig, etcdLog, etcd3Client, runArgs.etcdPath)
+		} else {
+			b, err = backend.NewEtcdBackend(backendConfig, etcdLog, etcdClient, runArgs.etcdPath)
+		}
 		if err != nil {
 			Exitf("Failed to create ETCD backend: %#v", err)
 		}
 	case "kubernetes":
-		b, err = backend.NewKubernetesBackend(etcdBackendConfig, kubernetesLog)
+		ingressLabels, err := backend.ParseLabelSelector(runArgs.kubernetesIngressLabels)
+		if err != nil {
+			Exitf("Invalid kubernetes-ingress-label-selector: %#v", err)
+		}
+		k8sConfig := backend.KubernetesConfig{
+			Namespaces:           runArgs.kubernetesNamespaces,
+			IngressLabelSelector: ingressLabels,
+			IngressClass:         runArgs.ingressClass,
+			IngressWithoutClass:  runArgs.ingressWithoutClass,
+			SslCertsFolder:       runArgs.sslCertsFolder,
+			RobinFrontends:       runArgs.kubernetesFrontends,
+			LegacyEndpoints:      runArgs.legacyEndpoints,
+			PodWeights:           runArgs.kubernetesPodWeights,
+			WatchBackoff:         runArgs.backendWatchBackoff.Initial,
+			WatchMaxBackoff:      runArgs.backendWatchBackoff.Max,
+			WatchJitter:          runArgs.backendWatchBackoff.Jitter,
+		}
+		b, err = backend.NewKubernetesBackend(backendConfig, k8sConfig, kubernetesLog)
 		if err != nil {
 			Exitf("Failed to create Kubernetes backend: %#v", err)
 		}
+	case "nomad":
+		nomadConfig := backend.NomadConfig{
+			Address:    runArgs.nomadAddr,
+			Token:      runArgs.nomadToken,
+			Namespaces: runArgs.nomadNamespaces,
+			TagPrefix:  runArgs.nomadTagPrefix,
+		}
+		b, err = backend.NewNomadBackend(backendConfig, nomadConfig, nomadLog)
+		if err != nil {
+			Exitf("Failed to create Nomad backend: %#v", err)
+		}
+	case "dns":
+		if runArgs.dnsFrontends == "" {
+			Exitf("Please specify --dns-frontends")
+		}
+		dnsConfig := backend.DNSConfig{
+			FrontendsPath: runArgs.dnsFrontends,
+			Servers:       runArgs.dnsServers,
+			MinInterval:   runArgs.dnsMinInterval,
+			MaxInterval:   runArgs.dnsMaxInterval,
+		}
+		b, err = backend.NewDNSBackend(backendConfig, dnsConfig, dnsLog)
+		if err != nil {
+			Exitf("Failed to create DNS backend: %#v", err)
+		}
 	default:
 		Exitf("Unknown backend: '%s'", runArgs.backend)
 	}
+	var tenants []backend.Tenant
+	if runArgs.tenantsFile != "" {
+		if _, ok := b.(backend.TenantBackend); !ok {
+			Exitf("Tenants are not supported by the %s backend", runArgs.backend)
+		}
+		tenants, err = backend.LoadTenants(runArgs.tenantsFile)
+		if err != nil {
+			Exitf("Failed to load tenants: %#v", err)
+		}
+	}
+	var apiHooks *middleware.Hooks
+	if len(runArgs.apiPlugins) > 0 {
+		apiHooks = &middleware.Hooks{}
+		for _, path := range runArgs.apiPlugins {
+			if err := apiHooks.LoadPlugin(path); err != nil {
+				Exitf("Failed to load API plugin '%s': %#v", path, err)
+			}
+		}
+	}
+	tenantQuotas := make(map[string]backend.Quota)
+	for _, t := range tenants {
+		if t.Quota.IsEnabled() {
+			tenantQuotas[t.Name] = t.Quota
+		}
+	}
+	var accountant accounting.Accountant
+	if runArgs.usageFile != "" {
+		if runArgs.haproxySocketPath == "" {
+			Exitf("Usage accounting requires a HAProxy runtime socket")
+		}
+		accountant, err = accounting.NewAccountant(accounting.AccountantConfig{
+			Path: runArgs.usageFile,
+		}, accounting.AccountantDependencies{
+			Logger: log,
+		})
+		if err != nil {
+			Exitf("Failed to create accountant: %#v", err)
+		}
+	}
 
 	// Prepare global mutext service
-	gmService := mutex.NewEtcdGlobalMutexService(etcdClient, path.Join(runArgs.etcdPath, etcdLocksFolder))
+	var gmService mutex.GlobalMutexService
+	switch {
+	case acmeStores.Repository != acme.StoreEtcd:
+		gmService = mutex.NewLocalGlobalMutexService()
+	case etcd3Client != nil:
+		gmService = mutex.NewEtcd3GlobalMutexService(etcd3Client, path.Join(runArgs.etcdPath, etcdLocksFolder))
+	default:
+		gmService = mutex.NewEtcdGlobalMutexService(etcdClient, path.Join(runArgs.etcdPath, etcdLocksFolder))
+	}
 
 	// Prepare acme service
+	privateCADirURLs := make(map[string]string)
+	for _, x := range runArgs.privateCADirURLs {
+		parts := strings.SplitN(x, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			Exitf("--acme-private-directory-url '%s' is not valid, expected <domain-suffix>=<url>", x)
+		}
+		privateCADirURLs[parts[0]] = parts[1]
+	}
+	var acmeAccounts []acme.AcmeAccount
+	if runArgs.acmeAccountsPath != "" {
+		acmeAccounts, err = acme.LoadAcmeAccounts(runArgs.acmeAccountsPath)
+		if err != nil {
+			Exitf("Failed to load --acme-accounts: %#v", err)
+		}
+	}
 	acmeEtcdPrefix := path.Join(runArgs.etcdPath, etcdAcmeFolder)
-	certsRepository := acme.NewEtcdCertificatesRepository(acmeEtcdPrefix, etcdClient)
+	var certsRepository acme.CertificatesRepository
+	switch {
+	case acmeStores.Repository == acme.StoreFile:
+		certsRepository, err = acme.NewFileCertificatesRepository(acmeStores.RepositoryPath)
+		if err != nil {
+			Exitf("Failed to create file certificates repository: %#v", err)
+		}
+	case etcd3Client != nil:
+		certsRepository = acme.NewEtcd3CertificatesRepository(acmeEtcdPrefix, etcd3Client)
+	default:
+		certsRepository = acme.NewEtcdCertificatesRepository(acmeEtcdPrefix, etcdClient)
+	}
+	if runArgs.encryptionKey != "" || runArgs.encryptionKeyPath != "" || runArgs.encryptionKMS.ciphertext != "" {
+		var key []byte
+		switch {
+		case runArgs.encryptionKey != "":
+			key, err = acme.ParseEncryptionKey(runArgs.encryptionKey)
+		case runArgs.encryptionKeyPath != "":
+			key, err = acme.ReadEncryptionKeyFile(runArgs.encryptionKeyPath)
+		default:
+			var kms acme.KeyDecrypter
+			kms, err = acme.NewVaultTransitKeyDecrypter(runArgs.encryptionKMS.address, runArgs.encryptionKMS.keyName, runArgs.encryptionKMS.token)
+			if err == nil {
+				key, err = acme.DecryptEncryptionKey(context.Background(), kms, runArgs.encryptionKMS.ciphertext)
+			}
+		}
+		if err != nil {
+			Exitf("Invalid ACME encryption key: %#v", err)
+		}
+		certsRepository, err = acme.NewEncryptedCertificatesRepository(certsRepository, key, log)
+		if err != nil {
+			Exitf("Failed to create encrypted certificates repository: %#v", err)
+		}
+	}
 	certsCache := acme.NewCertificatesFileCache(runArgs.tmpCertificatePath, certsRepository, log)
-	certsRequester := acme.NewCertificateRequester(log, certsRepository, gmService)
-	renewal := acme.NewRenewalMonitor(log, certsRepository, certsRequester)
-	acmeServiceListener := &acmeServiceListener{}
+	certsRequester := acme.NewCertificateRequester(log, certsRepository, gmService, runArgs.backendTimeout)
+	renewal := acme.NewRenewalMonitor(log, certsRepository, certsRequester, runArgs.backendTimeout)
+	var challengeStore acme.ChallengeStore
+	switch acmeStores.ChallengeStore {
+	case acme.StoreEtcd:
+		if etcd3Client != nil {
+			challengeStore = acme.NewEtcd3ChallengeStore(acmeEtcdPrefix, etcd3Client)
+		} else {
+			challengeStore = acme.NewEtcdChallengeStore(acmeEtcdPrefix, etcdClient)
+		}
+	case acme.StoreMemory:
+		// Tokens are only kept in memory of the HTTP provider
+	case acme.StoreKubernetes:
+		k8sClient, err := k8shttp.NewInCluster()
+		if err != nil {
+			Exitf("Failed to create Kubernetes client: %#v", err)
+		}
+		challengeStore = acme.NewKubernetesChallengeStore(k8sClient, runArgs.challengeNamespace, acmeChallengeConfigMapName)
+	default:
+		Exitf("Unknown ACME challenge store: '%s'", runArgs.challengeStore)
+	}
+	acmeListener := &robin.AcmeListener{}
 	acmeService := acme.NewAcmeService(acme.AcmeServiceConfig{
 		HttpProviderConfig: acme.HttpProviderConfig{
-			EtcdPrefix: acmeEtcdPrefix,
-			Port:       runArgs.acmeHttpPort,
+			Port:  runArgs.acmeHttpPort,
+			Peers: runArgs.challengePeers,
 		},
-		EtcdPrefix:       acmeEtcdPrefix,
-		CADirectoryURL:   runArgs.caDirURL,
-		KeyBits:          runArgs.keyBits,
-		Email:            runArgs.acmeEmail,
-		PrivateKeyPath:   runArgs.privateKeyPath,
-		RegistrationPath: runArgs.registrationPath,
+		EtcdPrefix:             acmeEtcdPrefix,
+		CADirectoryURL:         runArgs.caDirURL,
+		KeyBits:                runArgs.keyBits,
+		Email:                  runArgs.acmeEmail,
+		PrivateKeyPath:         runArgs.privateKeyPath,
+		RegistrationPath:       runArgs.registrationPath,
+		PrivateCADirectoryURLs: privateCADirURLs,
+		Accounts:               acmeAccounts,
 	}, acme.AcmeServiceDependencies{
 		HttpProviderDependencies: acme.HttpProviderDependencies{
-			Logger:     log,
-			EtcdClient: etcdClient,
+			Logger: log,
+			Store:  challengeStore,
 		},
-		Listener:   acmeServiceListener,
+		Listener:   acmeListener,
 		Repository: certsRepository,
 		Cache:      certsCache,
 		Renewal:    renewal,
@@ -230,65 +653,166 @@ func cmdRunRun(cmd *cobra.Command, args []string) {
 	if runArgs.privateHost == "" {
 		Exitf("Please specify --private-host")
 	}
-	service := service.NewService(service.ServiceConfig{
-		HaproxyConfPath:   runArgs.haproxyConfPath,
-		StatsPort:         runArgs.statsPort,
-		StatsUser:         runArgs.statsUser,
-		StatsPassword:     runArgs.statsPassword,
-		StatsSslCert:      runArgs.statsSslCert,
-		SslCertsFolder:    runArgs.sslCertsFolder,
-		ForceSsl:          runArgs.forceSsl,
-		PrivateHost:       runArgs.privateHost,
-		PrivateTcpSslCert: runArgs.privateTcpSslCert,
-		PrivateStatsPort:  runArgs.privateStatsPort,
-		ExcludePrivate:    runArgs.excludePrivate,
-		ExcludePublic:     runArgs.excludePublic,
-	}, service.ServiceDependencies{
-		Logger:      log,
-		Backend:     b,
-		AcmeService: acmeService,
-	})
-	acmeServiceListener.service = service
-
-	// Prepare and run middleware
-	apiMiddleware := middleware.Middleware{
-		Logger:  log,
-		Service: b,
-	}
-	apiAddr := fmt.Sprintf("%s:%d", runArgs.apiHost, runArgs.apiPort)
-	apiHandler := apiMiddleware.SetupRoutes(projectName, projectVersion, projectBuild)
-	log.Infof("Starting %s API (version %s build %s) on %s\n", projectName, projectVersion, projectBuild, apiAddr)
-	go func() {
-		if err := http.ListenAndServe(apiAddr, apiHandler); err != nil {
-			log.Fatalf("API ListenAndServe failed: %#v", err)
-		}
-	}()
-
-	// Start all services
-	if err := acmeService.Start(); err != nil {
-		Exitf("Failed to start ACME service: %#v", err)
+	var serviceProber prober.Prober
+	if runArgs.prober {
+		if runArgs.haproxySocketPath == "" {
+			Exitf("Please specify --haproxy-socket when using --prober")
+		}
+		serviceProber = prober.NewProber(prober.ProberConfig{
+			Timeout: runArgs.probeTimeout,
+		}, prober.ProberDependencies{
+			Logger: log,
+			Setter: haproxy.RuntimeClient{SocketPath: runArgs.haproxySocketPath},
+		})
+	}
+	for _, path := range runArgs.forceSslExemptPaths {
+		if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, " \t") {
+			Exitf("Invalid --force-ssl-exempt-path '%s'", path)
+		}
+	}
+	if err := runArgs.accessLog.Validate(); err != nil {
+		Exitf("Invalid access log options: %#v", err)
+	}
+	if runArgs.accessLog.IsEnabled() && runArgs.tlsStatsAddress != "" && !haproxyVersion.AtLeast(3, 1) {
+		log.Warningf("TLS statistics of HTTPS frontends require --haproxy-version 3.1 or later when the access log is enabled")
+	}
+	var sensitivePaths service.SensitivePathsConfig
+	if runArgs.protectSensitive {
+		sensitivePaths.Paths = runArgs.sensitivePaths
+		sensitivePaths.AllowedSources = runArgs.sensitiveSources
+		for _, x := range runArgs.sensitiveUsers {
+			parts := strings.SplitN(x, ":", 2)
+			if len(parts) != 2 {
+				Exitf("--sensitive-path-user '%s' is not valid, expected <name>:<password-hash>", x)
+			}
+			sensitivePaths.Users = append(sensitivePaths.Users, backend.User{Name: parts[0], PasswordHash: parts[1]})
+		}
+		if err := sensitivePaths.Validate(); err != nil {
+			Exitf("Invalid sensitive path options: %#v", err)
+		}
+	}
+	if err := runArgs.realIP.Validate(); err != nil {
+		Exitf("Invalid real IP options: %#v", err)
+	}
+	var tcpPortRange service.PortRange
+	if runArgs.tcpPortRange != "" {
+		var err error
+		tcpPortRange, err = service.ParsePortRange(runArgs.tcpPortRange)
+		if err != nil {
+			Exitf("Invalid --tcp-port-range: %#v", err)
+		}
+	}
+	if runArgs.ipBan.IsEnabled() && runArgs.haproxySocketPath == "" {
+		Exitf("Please specify --haproxy-socket when using --ban-failures")
+	}
+	var spoeAgents []service.SpoeAgent
+	for _, x := range runArgs.spoeAgents {
+		parts := strings.SplitN(x, "=", 2)
+		if len(parts) != 2 || api.ValidateName(parts[0]) != nil || parts[1] == "" {
+			Exitf("--spoe-agent '%s' is not valid, expected <name>=<host:port>", x)
+		}
+		spoeAgents = append(spoeAgents, service.SpoeAgent{Name: parts[0], Address: parts[1]})
+	}
+	var sidecars []sidecar.Sidecar
+	for _, x := range runArgs.spoeAgentCommands {
+		parts := strings.SplitN(x, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || len(strings.Fields(parts[1])) == 0 {
+			Exitf("--spoe-agent-command '%s' is not valid, expected <name>=<command>", x)
+		}
+		sidecars = append(sidecars, sidecar.NewSidecar(sidecar.SidecarConfig{
+			Name:    parts[0],
+			Command: strings.Fields(parts[1]),
+		}, sidecar.SidecarDependencies{
+			Logger: log,
+		}))
 	}
 	metricsConfig := metrics.MetricsConfig{
-		ProjectName:    projectName,
-		ProjectVersion: projectVersion,
-		ProjectBuild:   projectBuild,
 		Host:           runArgs.metricsHost,
 		Port:           runArgs.metricsPort,
 		HaproxyCSVURI:  fmt.Sprintf("http://127.0.0.1:%d/;csv", runArgs.privateStatsPort),
+		TlsLogAddress:  runArgs.tlsStatsAddress,
+		Compress:       runArgs.metricsCompress,
+		RuntimeMetrics: runArgs.metricsRuntime,
+		Pprof:          runArgs.metricsPprof,
 	}
 	if runArgs.privateStatsPort == 0 {
 		metricsConfig.HaproxyCSVURI = ""
 	}
-	if err := metrics.StartMetricsListener(metricsConfig, log); err != nil {
-		Exitf("Failed to start metrics: %#v", err)
-	}
-	service.Run()
-}
+	r, err := robin.New(robin.Config{
+		ProjectName:    projectName,
+		ProjectVersion: projectVersion,
+		ProjectBuild:   projectBuild,
+		Service: service.ServiceConfig{
+			HaproxyConfPath:      runArgs.haproxyConfPath,
+			StatsPort:            runArgs.statsPort,
+			StatsUser:            runArgs.statsUser,
+			StatsPassword:        runArgs.statsPassword,
+			StatsSslCert:         runArgs.statsSslCert,
+			SslCertsFolder:       runArgs.sslCertsFolder,
+			ForceSsl:             runArgs.forceSsl,
+			ForceSslExemptPaths:  runArgs.forceSslExemptPaths,
+			PrivateDenyByDefault: runArgs.privateDenyByDefault,
+			PrivateGateway:       runArgs.privateGateway,
+			PrivateHost:          runArgs.privateHost,
+			PrivateTcpSslCert:    runArgs.privateTcpSslCert,
+			PrivateStatsPort:     runArgs.privateStatsPort,
+			StatsCompression:     runArgs.statsCompression,
+			ExcludePrivate:       runArgs.excludePrivate,
+			ExcludePublic:        runArgs.excludePublic,
+			TlsLogAddress:        runArgs.tlsStatsAddress,
+			HaproxyVersion:       haproxyVersion,
+			RuntimeSocketPath:    runArgs.haproxySocketPath,
+			ReloadGracePeriod:    runArgs.reloadGracePeriod,
+			Zone:                 runArgs.zone,
+			MaxBackends:          runArgs.maxBackends,
+			MaxAclsPerFrontend:   runArgs.maxAclsPerFrontend,
+			MaxConfigSize:        runArgs.maxConfigSize,
+			MapFilesFolder:       runArgs.mapFilesFolder,
+			CacheSize:            runArgs.cacheSize,
+			SpoeAgents:           spoeAgents,
+			LuaScriptsFolder:     runArgs.luaScriptsFolder,
+			TenantQuotas:         tenantQuotas,
+			QuotaErrorFile:       runArgs.quotaErrorFile,
+			AccessLog:            runArgs.accessLog,
+			IPBan:                runArgs.ipBan,
+			SensitivePaths:       sensitivePaths,
+			RealIP:               runArgs.realIP,
+			Blocklists:           blocklists,
+			BlocklistInterval:    runArgs.blocklistInterval,
+			HistorySize:          runArgs.historySize,
+			DeregistrationGrace:  runArgs.deregistrationGrace,
+			BackendTimeout:       runArgs.backendTimeout,
+			BackendWatchTimeout:  runArgs.backendWatchTimeout,
+			BackendWatchBackoff:  runArgs.backendWatchBackoff,
+			TcpPortRange:         tcpPortRange,
+			ExternalResolvers:    runArgs.externalResolvers,
+			ExternalCAFile:       runArgs.externalCAFile,
+		},
+		API: robin.APIConfig{
+			Host:           runArgs.apiHost,
+			Port:           runArgs.apiPort,
+			RequireIfMatch: runArgs.apiRequireIfMatch,
+			Token:          runArgs.apiToken,
+			Compress:       runArgs.apiCompress,
+			Tenants:        tenants,
+			Hooks:          apiHooks,
+		},
+		Metrics: metricsConfig,
 
-// CertificatesUpdated is called when there is a change in one of the ACME generated certificates
-func (l *acmeServiceListener) CertificatesUpdated() {
-	if l.service != nil {
-		l.service.TriggerUpdate()
+		Logger:       log,
+		Backend:      b,
+		AcmeService:  acmeService,
+		AcmeListener: acmeListener,
+		Renewal:      renewal,
+		Prober:       serviceProber,
+		Sidecars:     sidecars,
+		Accountant:   accountant,
+	})
+	if err != nil {
+		Exitf("Failed to create load-balancer: %#v", err)
+	}
+	if err := r.Run(); err != nil {
+		Exitf("Failed to run load-balancer: %#v", err)
 	}
 }
 
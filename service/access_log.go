@@ -0,0 +1,123 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/juju/errgo"
+
+	api "github.com/pulcy/robin-api"
+	"github.com/pulcy/robin/haproxy"
+)
+
+const (
+	// accessLogScrubbed replaces scrubbed values in the access log.
+	accessLogScrubbed = "***"
+	// accessLogFormat is the HAProxy log-format used for HTTP requests. It equals the standard HTTP
+	// log format, except that the (scrubbed) URI and request headers are taken from variables.
+	accessLogFormat = "%ci:%cp\\ [%tr]\\ %ft\\ %b/%s\\ %TR/%Tw/%Tc/%Tr/%Ta\\ %ST\\ %B\\ %tsc\\ %ac/%fc/%bc/%sc/%rc\\ %sq/%bq"
+)
+
+var (
+	accessLogNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// AccessLogConfig specifies if and how HTTP requests are logged.
+type AccessLogConfig struct {
+	Address       string   // If set, HTTP requests are logged (syslog over UDP) to this address
+	SampleRate    int      // Only 1 in this many requests is logged (0 means all)
+	Headers       []string // Request headers included in the log
+	ScrubParams   []string // Query parameters whose values are replaced by *** (in the URI & headers)
+	ScrubPatterns []string // Regular expressions whose matches are replaced by *** (in the URI & headers)
+}
+
+// IsEnabled returns true if HTTP requests must be logged.
+func (c AccessLogConfig) IsEnabled() bool {
+	return c.Address != ""
+}
+
+// Validate checks the configuration for errors.
+// Patterns are passed to HAProxy as-is, so they cannot contain commas, parentheses or whitespace.
+func (c AccessLogConfig) Validate() error {
+	if c.SampleRate < 0 {
+		return maskAny(errgo.WithCausef(nil, api.ValidationError, "sample rate must be positive, got %d", c.SampleRate))
+	}
+	for _, name := range c.Headers {
+		if !accessLogNameRegexp.MatchString(name) {
+			return maskAny(errgo.WithCausef(nil, api.ValidationError, "invalid header name '%s'", name))
+		}
+	}
+	for _, name := range c.ScrubParams {
+		if !accessLogNameRegexp.MatchString(name) {
+			return maskAny(errgo.WithCausef(nil, api.ValidationError, "invalid query parameter name '%s'", name))
+		}
+	}
+	for _, pattern := range c.ScrubPatterns {
+		if pattern == "" || strings.ContainsAny(pattern, ",()\"' \t") {
+			return maskAny(errgo.WithCausef(nil, api.ValidationError, "pattern '%s' cannot be empty or contain commas, parentheses, quotes or whitespace", pattern))
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return maskAny(errgo.WithCausef(nil, api.ValidationError, "invalid pattern '%s': %v", pattern, err))
+		}
+	}
+	return nil
+}
+
+// scrubConverters returns the HAProxy converters that scrub a logged value.
+func (c AccessLogConfig) scrubConverters() string {
+	result := ""
+	for _, name := range c.ScrubParams {
+		// Keep the separator, since regsub does not support back references in all versions
+		for _, sep := range []string{"?", "&"} {
+			result += fmt.Sprintf(",regsub([%s]%s=[^&]*,%s%s=%s,gi)", sep, name, sep, name, accessLogScrubbed)
+		}
+	}
+	for _, pattern := range c.ScrubPatterns {
+		result += fmt.Sprintf(",regsub(%s,%s,g)", pattern, accessLogScrubbed)
+	}
+	return result
+}
+
+// addAccessLogOptions adds options to the given HTTP frontend section that log all (sampled) requests
+// with scrubbed URIs & headers.
+func (s *Service) addAccessLogOptions(section *haproxy.Section) {
+	c := s.AccessLog
+	if !c.IsEnabled() {
+		return
+	}
+	scrub := c.scrubConverters()
+	if c.SampleRate > 1 && s.HaproxyVersion.AtLeast(2, 0) {
+		section.Add(fmt.Sprintf("log %s sample 1:%d local1 info", c.Address, c.SampleRate))
+	} else {
+		section.Add(fmt.Sprintf("log %s local1 info", c.Address))
+		if c.SampleRate > 1 {
+			section.Add(fmt.Sprintf("http-request set-log-level silent unless { rand(%d) eq 0 }", c.SampleRate))
+		}
+	}
+	section.Add(fmt.Sprintf("http-request set-var(txn.log_uri) url%s", scrub))
+	format := accessLogFormat + "\\ \\\"%HM\\ %[var(txn.log_uri)]\\ %HV\\\""
+	if len(c.Headers) > 0 {
+		values := []string{}
+		for i, name := range c.Headers {
+			section.Add(fmt.Sprintf("http-request set-var(txn.log_hdr%d) req.hdr(%s)%s", i, name, scrub))
+			values = append(values, fmt.Sprintf("%%[var(txn.log_hdr%d)]", i))
+		}
+		format += "\\ {" + strings.Join(values, "|") + "}"
+	}
+	section.Add("log-format " + format)
+}
@@ -0,0 +1,283 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accounting
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/juju/errgo"
+	"github.com/op/go-logging"
+)
+
+const (
+	defaultHourlyRetention = time.Hour * 24 * 7
+	defaultDailyRetention  = time.Hour * 24 * 400
+	filePerm               = os.FileMode(0644)
+)
+
+// Period is the length of the intervals in which usage is reported.
+type Period string
+
+const (
+	PeriodHour  Period = "hour"
+	PeriodDay   Period = "day"
+	PeriodMonth Period = "month"
+)
+
+// ParsePeriod parses the given period (hour|day|month), defaulting to day.
+func ParsePeriod(s string) (Period, error) {
+	switch Period(s) {
+	case "":
+		return PeriodDay, nil
+	case PeriodHour, PeriodDay, PeriodMonth:
+		return Period(s), nil
+	default:
+		return "", maskAny(errgo.WithCausef(nil, InvalidPeriodError, "period must be hour|day|month, got '%s'", s))
+	}
+}
+
+// Counters holds the traffic of a service.
+type Counters struct {
+	Requests int64 `json:"requests"`  // HTTP requests (sessions for tcp services)
+	BytesIn  int64 `json:"bytes-in"`  // Request bytes
+	BytesOut int64 `json:"bytes-out"` // Response bytes
+}
+
+func (c *Counters) add(o Counters) {
+	c.Requests += o.Requests
+	c.BytesIn += o.BytesIn
+	c.BytesOut += o.BytesOut
+}
+
+// Sample holds the cumulative counters (since HAProxy started) of a backend of a service.
+type Sample struct {
+	Backend string
+	Service string
+	Counters
+}
+
+// Usage is the traffic of a service in a single period.
+type Usage struct {
+	Service string    `json:"service"`
+	Start   time.Time `json:"start"` // Start of the period (UTC)
+	Counters
+}
+
+// Accountant aggregates the traffic per service over time.
+type Accountant interface {
+	// Record adds the traffic since the previous samples of the same backends.
+	Record(now time.Time, samples []Sample) error
+	// Usage returns the traffic of the service with given name (all services if empty) per period.
+	Usage(service string, period Period) ([]Usage, error)
+}
+
+type AccountantConfig struct {
+	Path            string        // If set, the usage is persisted in this file
+	HourlyRetention time.Duration // Time hourly usage is kept (0 means 7 days)
+	DailyRetention  time.Duration // Time daily usage is kept (0 means 400 days)
+}
+
+type AccountantDependencies struct {
+	Logger *logging.Logger
+}
+
+// usageTree holds counters per service per start of period (unix time).
+type usageTree map[string]map[int64]Counters
+
+func (t usageTree) add(service string, start time.Time, c Counters) {
+	periods, ok := t[service]
+	if !ok {
+		periods = make(map[int64]Counters)
+		t[service] = periods
+	}
+	x := periods[start.Unix()]
+	x.add(c)
+	periods[start.Unix()] = x
+}
+
+// prune removes all counters of periods that started before the given time.
+func (t usageTree) prune(before time.Time) {
+	for service, periods := range t {
+		for start := range periods {
+			if start < before.Unix() {
+				delete(periods, start)
+			}
+		}
+		if len(periods) == 0 {
+			delete(t, service)
+		}
+	}
+}
+
+type usageFile struct {
+	Hourly usageTree `json:"hourly"`
+	Daily  usageTree `json:"daily"`
+}
+
+type accountant struct {
+	AccountantConfig
+	AccountantDependencies
+
+	mutex sync.Mutex
+	usage usageFile
+	last  map[string]Counters // backend -> counters of previous sample
+}
+
+// NewAccountant creates a new Accountant, loading previously persisted usage (if any).
+func NewAccountant(config AccountantConfig, deps AccountantDependencies) (Accountant, error) {
+	if config.HourlyRetention == 0 {
+		config.HourlyRetention = defaultHourlyRetention
+	}
+	if config.DailyRetention == 0 {
+		config.DailyRetention = defaultDailyRetention
+	}
+	a := &accountant{
+		AccountantConfig:       config,
+		AccountantDependencies: deps,
+		usage: usageFile{
+			Hourly: make(usageTree),
+			Daily:  make(usageTree),
+		},
+		last: make(map[string]Counters),
+	}
+	if config.Path != "" {
+		raw, err := ioutil.ReadFile(config.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, maskAny(err)
+		} else if err == nil {
+			if err := json.Unmarshal(raw, &a.usage); err != nil {
+				return nil, maskAny(err)
+			}
+			if a.usage.Hourly == nil {
+				a.usage.Hourly = make(usageTree)
+			}
+			if a.usage.Daily == nil {
+				a.usage.Daily = make(usageTree)
+			}
+		}
+	}
+	return a, nil
+}
+
+// Record adds the traffic since the previous samples of the same backends.
+// The first sample of a backend only serves as a baseline.
+// Counters that decrease indicate that HAProxy was restarted.
+func (a *accountant) Record(now time.Time, samples []Sample) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now = now.UTC()
+	hour := now.Truncate(time.Hour)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	last := make(map[string]Counters)
+	for _, s := range samples {
+		last[s.Backend] = s.Counters
+		prev, ok := a.last[s.Backend]
+		if !ok {
+			continue
+		}
+		delta := s.Counters
+		if s.Requests >= prev.Requests && s.BytesIn >= prev.BytesIn && s.BytesOut >= prev.BytesOut {
+			delta = Counters{
+				Requests: s.Requests - prev.Requests,
+				BytesIn:  s.BytesIn - prev.BytesIn,
+				BytesOut: s.BytesOut - prev.BytesOut,
+			}
+		}
+		if delta == (Counters{}) {
+			continue
+		}
+		a.usage.Hourly.add(s.Service, hour, delta)
+		a.usage.Daily.add(s.Service, day, delta)
+	}
+	a.last = last
+	a.usage.Hourly.prune(now.Add(-a.HourlyRetention))
+	a.usage.Daily.prune(now.Add(-a.DailyRetention))
+
+	if err := a.save(); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// save writes the usage to the configured file (if any).
+func (a *accountant) save() error {
+	if a.Path == "" {
+		return nil
+	}
+	raw, err := json.Marshal(a.usage)
+	if err != nil {
+		return maskAny(err)
+	}
+	tempPath := filepath.Join(filepath.Dir(a.Path), "."+filepath.Base(a.Path)+".tmp")
+	if err := ioutil.WriteFile(tempPath, raw, filePerm); err != nil {
+		return maskAny(err)
+	}
+	if err := os.Rename(tempPath, a.Path); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// Usage returns the traffic of the service with given name (all services if empty) per period,
+// sorted by service and start of the period.
+func (a *accountant) Usage(service string, period Period) ([]Usage, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	tree := a.usage.Daily
+	if period == PeriodHour {
+		tree = a.usage.Hourly
+	}
+	result := []Usage{}
+	for name, periods := range tree {
+		if service != "" && name != service {
+			continue
+		}
+		byStart := make(map[time.Time]Counters)
+		for start, c := range periods {
+			t := time.Unix(start, 0).UTC()
+			if period == PeriodMonth {
+				t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+			}
+			x := byStart[t]
+			x.add(c)
+			byStart[t] = x
+		}
+		for start, c := range byStart {
+			result = append(result, Usage{Service: name, Start: start, Counters: c})
+		}
+	}
+	sort.Sort(usages(result))
+	return result, nil
+}
+
+// usages sorts a list of usages by service & start time.
+type usages []Usage
+
+func (l usages) Len() int      { return len(l) }
+func (l usages) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l usages) Less(i, j int) bool {
+	if l[i].Service != l[j].Service {
+		return l[i].Service < l[j].Service
+	}
+	return l[i].Start.Before(l[j].Start)
+}
@@ -0,0 +1,58 @@
+package accounting
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccountantRecord(t *testing.T) {
+	a, err := NewAccountant(AccountantConfig{}, AccountantDependencies{})
+	if err != nil {
+		t.Fatalf("NewAccountant failed: %#v", err)
+	}
+	sample := func(requests int64) []Sample {
+		return []Sample{
+			{Backend: "backend_web_80", Service: "web", Counters: Counters{Requests: requests, BytesIn: requests * 10, BytesOut: requests * 100}},
+		}
+	}
+	steps := []struct {
+		At       time.Time
+		Requests int64
+	}{
+		{time.Date(2016, 1, 31, 23, 0, 0, 0, time.UTC), 100}, // Baseline
+		{time.Date(2016, 1, 31, 23, 1, 0, 0, time.UTC), 150}, // +50
+		{time.Date(2016, 2, 1, 0, 1, 0, 0, time.UTC), 160},   // +10
+		{time.Date(2016, 2, 1, 0, 2, 0, 0, time.UTC), 5},     // Restarted: +5
+	}
+	for _, s := range steps {
+		if err := a.Record(s.At, sample(s.Requests)); err != nil {
+			t.Fatalf("Record failed: %#v", err)
+		}
+	}
+
+	tests := []struct {
+		Period   Period
+		Expected []int64
+	}{
+		{PeriodHour, []int64{50, 15}},
+		{PeriodDay, []int64{50, 15}},
+		{PeriodMonth, []int64{50, 15}},
+	}
+	for _, test := range tests {
+		usage, err := a.Usage("web", test.Period)
+		if err != nil {
+			t.Fatalf("Usage(%s) failed: %#v", test.Period, err)
+		}
+		if len(usage) != len(test.Expected) {
+			t.Fatalf("Usage(%s): expected %d periods, got %d", test.Period, len(test.Expected), len(usage))
+		}
+		for i, u := range usage {
+			if u.Requests != test.Expected[i] || u.BytesOut != test.Expected[i]*100 {
+				t.Errorf("Usage(%s)[%d]: expected %d requests, got %#v", test.Period, i, test.Expected[i], u)
+			}
+		}
+	}
+	if usage, _ := a.Usage("other", PeriodDay); len(usage) != 0 {
+		t.Errorf("Expected no usage of other service, got %#v", usage)
+	}
+}
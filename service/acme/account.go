@@ -0,0 +1,99 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acme
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// AcmeAccount describes an additional ACME account that is used for all domains
+// matching its domain suffix.
+// The account must be registered before use (see `robin register acme`).
+type AcmeAccount struct {
+	DomainSuffix     string `json:"domain-suffix"`     // Domains equal to, or ending with, this suffix use this account
+	Email            string `json:"email"`             // Registration email address
+	CADirectoryURL   string `json:"directory-url"`     // URL of ACME directory
+	PrivateKeyPath   string `json:"private-key-path"`  // Path of file containing private key
+	RegistrationPath string `json:"registration-path"` // Path of file containing acme.RegistrationResource
+}
+
+// Validate checks the given account for missing values.
+func (a AcmeAccount) Validate() error {
+	if a.DomainSuffix == "" {
+		return maskAny(fmt.Errorf("domain-suffix must be set"))
+	}
+	if a.Email == "" {
+		return maskAny(fmt.Errorf("email must be set for account of '%s'", a.DomainSuffix))
+	}
+	if a.CADirectoryURL == "" {
+		return maskAny(fmt.Errorf("directory-url must be set for account of '%s'", a.DomainSuffix))
+	}
+	if a.PrivateKeyPath == "" {
+		return maskAny(fmt.Errorf("private-key-path must be set for account of '%s'", a.DomainSuffix))
+	}
+	if a.RegistrationPath == "" {
+		return maskAny(fmt.Errorf("registration-path must be set for account of '%s'", a.DomainSuffix))
+	}
+	return nil
+}
+
+// LoadAcmeAccounts reads a JSON file containing a list of ACME accounts.
+func LoadAcmeAccounts(path string) ([]AcmeAccount, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	var accounts []AcmeAccount
+	if err := json.Unmarshal(raw, &accounts); err != nil {
+		return nil, maskAny(err)
+	}
+	for _, a := range accounts {
+		if err := a.Validate(); err != nil {
+			return nil, maskAny(err)
+		}
+	}
+	return accounts, nil
+}
+
+// createAccountClient creates an ACME client for the given (registered) account.
+func (s *acmeService) createAccountClient(account AcmeAccount) (*acme.Client, error) {
+	key, err := loadRSAPrivateKey(account.PrivateKeyPath)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	registration, err := loadRegistration(account.RegistrationPath)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if registration == nil {
+		return nil, maskAny(fmt.Errorf("No registration found at %s", account.RegistrationPath))
+	}
+	user := acmeUser{
+		Email:        account.Email,
+		Registration: registration,
+		PrivateKey:   key,
+	}
+	client, err := acme.NewClient(account.CADirectoryURL, user, s.KeyBits)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	client.ExcludeChallenges([]acme.Challenge{acme.TLSSNI01, acme.DNS01})
+	client.SetChallengeProvider(acme.HTTP01, s.httpProvider)
+	return client, nil
+}
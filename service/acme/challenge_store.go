@@ -0,0 +1,30 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acme
+
+// ChallengeStore holds the keyAuth of presented HTTP-01 challenge tokens,
+// so all instances can serve them.
+// Without a store, tokens are only kept in memory of the instance that presented them.
+type ChallengeStore interface {
+	// Put stores the keyAuth for the given token.
+	Put(token, keyAuth string) error
+
+	// Get returns the keyAuth for the given token.
+	// Returns an empty string if the token is not found.
+	Get(token string) (string, error)
+
+	// Delete removes the given token.
+	Delete(token string) error
+}
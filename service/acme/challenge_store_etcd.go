@@ -0,0 +1,81 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acme
+
+import (
+	"path"
+
+	"github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+)
+
+// NewEtcdChallengeStore creates a ChallengeStore that holds tokens in ETCD under the given prefix.
+func NewEtcdChallengeStore(etcdPrefix string, etcdClient client.Client) ChallengeStore {
+	return &etcdChallengeStore{
+		EtcdPrefix: etcdPrefix,
+		EtcdClient: etcdClient,
+	}
+}
+
+type etcdChallengeStore struct {
+	EtcdPrefix string
+	EtcdClient client.Client
+}
+
+// Put stores the keyAuth for the given token.
+func (s *etcdChallengeStore) Put(token, keyAuth string) error {
+	kAPI := client.NewKeysAPI(s.EtcdClient)
+	options := &client.SetOptions{
+		TTL: 0,
+	}
+	if _, err := kAPI.Set(context.Background(), s.tokenKey(token), keyAuth, options); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// Get returns the keyAuth for the given token.
+func (s *etcdChallengeStore) Get(token string) (string, error) {
+	kAPI := client.NewKeysAPI(s.EtcdClient)
+	options := &client.GetOptions{
+		Recursive: false,
+		Sort:      false,
+		Quorum:    true, // Token may just have been written on another instance
+	}
+	r, err := kAPI.Get(context.Background(), s.tokenKey(token), options)
+	if err != nil {
+		if isEtcdWithCode(err, client.ErrorCodeKeyNotFound) {
+			return "", nil
+		}
+		return "", maskAny(err)
+	}
+	return r.Node.Value, nil
+}
+
+// Delete removes the given token.
+func (s *etcdChallengeStore) Delete(token string) error {
+	kAPI := client.NewKeysAPI(s.EtcdClient)
+	options := &client.DeleteOptions{
+		Recursive: false,
+	}
+	if _, err := kAPI.Delete(context.Background(), s.tokenKey(token), options); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+func (s *etcdChallengeStore) tokenKey(token string) string {
+	return path.Join(s.EtcdPrefix, token)
+}
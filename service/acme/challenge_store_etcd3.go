@@ -0,0 +1,80 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acme
+
+import (
+	"path"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"golang.org/x/net/context"
+)
+
+const (
+	// etcd3ChallengeTTL is the time after which tokens that are not deleted (e.g. after a crash) expire.
+	etcd3ChallengeTTL = time.Hour
+)
+
+// NewEtcd3ChallengeStore creates a ChallengeStore that holds tokens in ETCD (v3 API) under the given prefix.
+// Tokens are attached to a lease, so they expire when they are not deleted.
+func NewEtcd3ChallengeStore(etcdPrefix string, etcdClient *clientv3.Client) ChallengeStore {
+	return &etcd3ChallengeStore{
+		EtcdPrefix: etcdPrefix,
+		EtcdClient: etcdClient,
+	}
+}
+
+type etcd3ChallengeStore struct {
+	EtcdPrefix string
+	EtcdClient *clientv3.Client
+}
+
+// Put stores the keyAuth for the given token.
+func (s *etcd3ChallengeStore) Put(token, keyAuth string) error {
+	ctx := context.Background()
+	lease, err := s.EtcdClient.Grant(ctx, int64(etcd3ChallengeTTL/time.Second))
+	if err != nil {
+		return maskAny(err)
+	}
+	if _, err := s.EtcdClient.Put(ctx, s.tokenKey(token), keyAuth, clientv3.WithLease(lease.ID)); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// Get returns the keyAuth for the given token.
+// Reads are linearizable, so a token that has just been written on another instance is found.
+func (s *etcd3ChallengeStore) Get(token string) (string, error) {
+	resp, err := s.EtcdClient.Get(context.Background(), s.tokenKey(token))
+	if err != nil {
+		return "", maskAny(err)
+	}
+	if len(resp.Kvs) == 0 {
+		return "", nil
+	}
+	return string(resp.Kvs[0].Value), nil
+}
+
+// Delete removes the given token.
+func (s *etcd3ChallengeStore) Delete(token string) error {
+	if _, err := s.EtcdClient.Delete(context.Background(), s.tokenKey(token)); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+func (s *etcd3ChallengeStore) tokenKey(token string) string {
+	return path.Join(s.EtcdPrefix, token)
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acme
+
+import (
+	"sync"
+
+	k8s "github.com/YakLabs/k8s-client"
+)
+
+// NewKubernetesChallengeStore creates a ChallengeStore that holds tokens in a ConfigMap
+// with given name in given namespace.
+func NewKubernetesChallengeStore(c k8s.Client, namespace, configMapName string) ChallengeStore {
+	return &k8sChallengeStore{
+		client:        c,
+		namespace:     namespace,
+		configMapName: configMapName,
+	}
+}
+
+type k8sChallengeStore struct {
+	client        k8s.Client
+	namespace     string
+	configMapName string
+	mutex         sync.Mutex
+}
+
+// Put stores the keyAuth for the given token.
+func (s *k8sChallengeStore) Put(token, keyAuth string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	cm, err := s.client.GetConfigMap(s.namespace, s.configMapName)
+	if k8s.IsNotFoundError(err) {
+		cm = k8s.NewConfigMap(s.namespace, s.configMapName)
+		cm.Data[token] = []byte(keyAuth)
+		if _, err := s.client.CreateConfigMap(s.namespace, cm); err != nil {
+			return maskAny(err)
+		}
+		return nil
+	} else if err != nil {
+		return maskAny(err)
+	}
+	if cm.Data == nil {
+		cm.Data = make(map[string][]byte)
+	}
+	cm.Data[token] = []byte(keyAuth)
+	if _, err := s.client.UpdateConfigMap(s.namespace, cm); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// Get returns the keyAuth for the given token.
+func (s *k8sChallengeStore) Get(token string) (string, error) {
+	cm, err := s.client.GetConfigMap(s.namespace, s.configMapName)
+	if k8s.IsNotFoundError(err) {
+		return "", nil
+	} else if err != nil {
+		return "", maskAny(err)
+	}
+	return string(cm.Data[token]), nil
+}
+
+// Delete removes the given token.
+func (s *k8sChallengeStore) Delete(token string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	cm, err := s.client.GetConfigMap(s.namespace, s.configMapName)
+	if k8s.IsNotFoundError(err) {
+		return nil
+	} else if err != nil {
+		return maskAny(err)
+	}
+	if _, found := cm.Data[token]; !found {
+		return nil
+	}
+	delete(cm.Data, token)
+	if _, err := s.client.UpdateConfigMap(s.namespace, cm); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
@@ -15,6 +15,7 @@
 package acme
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -27,7 +28,7 @@ type CertificatesFileCache interface {
 	Clear()
 
 	// GetDomainCertificatePath returns the path of a certificate file for the given domain.
-	GetDomainCertificatePath(domain string) (string, error)
+	GetDomainCertificatePath(ctx context.Context, domain string) (string, error)
 }
 
 type certificatesFileCache struct {
@@ -57,7 +58,7 @@ func (s *certificatesFileCache) Clear() {
 }
 
 // getDomainCertificatePath returns the path of a certificate file for the given domain.
-func (s *certificatesFileCache) GetDomainCertificatePath(domain string) (string, error) {
+func (s *certificatesFileCache) GetDomainCertificatePath(ctx context.Context, domain string) (string, error) {
 	s.domainFileCacheMutex.Lock()
 	defer s.domainFileCacheMutex.Unlock()
 
@@ -67,7 +68,7 @@ func (s *certificatesFileCache) GetDomainCertificatePath(domain string) (string,
 	}
 
 	// Not found in cache, try repository
-	certificate, err := s.Repository.LoadDomainCertificate(domain)
+	certificate, err := s.Repository.LoadDomainCertificate(ctx, domain)
 	if err != nil {
 		return "", maskAny(err)
 	}
@@ -16,61 +16,79 @@ package acme
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
-	"path"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/coreos/etcd/client"
 	"github.com/op/go-logging"
 	"github.com/xenolf/lego/acme"
-	"golang.org/x/net/context"
+)
+
+const (
+	// peerRequestHeader is set on challenge requests forwarded to other instances
+	// to prevent forwarding loops.
+	peerRequestHeader  = "X-Robin-Acme-Peer"
+	peerRequestTimeout = time.Second * 2
 )
 
 type HttpProviderConfig struct {
-	EtcdPrefix string // Folder in ETCD to use for Http challenges
-	Port       int    // Port to listen on
+	Port  int      // Port to listen on
+	Peers []string // Addresses (host:port) of the challenge listeners of other instances
 }
 
 type HttpProviderDependencies struct {
-	Logger     *logging.Logger
-	EtcdClient client.Client
+	Logger *logging.Logger
+	Store  ChallengeStore // Store shared by all instances (nil means tokens are kept in memory only)
 }
 
 type httpChallengeProvider struct {
 	HttpProviderConfig
 	HttpProviderDependencies
+
+	tokens      map[string]string // token -> keyAuth of tokens presented by this instance
+	tokensMutex sync.RWMutex
 }
 
 func newHttpChallengeProvider(config HttpProviderConfig, deps HttpProviderDependencies) *httpChallengeProvider {
 	return &httpChallengeProvider{
 		HttpProviderConfig:       config,
 		HttpProviderDependencies: deps,
+		tokens:                   make(map[string]string),
 	}
 }
 
 // Present makes the token available at `HTTP01ChallengePath(token)`
 func (s *httpChallengeProvider) Present(domain, token, keyAuth string) error {
-	// Write token & keyAuth in ETCD
-	kAPI := client.NewKeysAPI(s.EtcdClient)
-	options := &client.SetOptions{
-		TTL: 0,
-	}
-	if _, err := kAPI.Set(context.Background(), s.etcdTokenKey(token), keyAuth, options); err != nil {
-		return maskAny(err)
+	// Keep token in memory, so we can serve it without ETCD round-trip
+	s.tokensMutex.Lock()
+	s.tokens[token] = keyAuth
+	s.tokensMutex.Unlock()
+
+	// Write token & keyAuth in store (for other instances)
+	if s.Store != nil {
+		if err := s.Store.Put(token, keyAuth); err != nil {
+			return maskAny(err)
+		}
 	}
 	return nil
 }
 
 func (s *httpChallengeProvider) CleanUp(domain, token, keyAuth string) error {
-	// Remove token from etcdTokenKey
-	kAPI := client.NewKeysAPI(s.EtcdClient)
-	options := &client.DeleteOptions{
-		Recursive: false,
-	}
-	if _, err := kAPI.Delete(context.Background(), s.etcdTokenKey(token), options); err != nil {
-		return maskAny(err)
+	// Remove token from memory
+	s.tokensMutex.Lock()
+	delete(s.tokens, token)
+	s.tokensMutex.Unlock()
+
+	// Remove token from store
+	if s.Store != nil {
+		if err := s.Store.Delete(token); err != nil {
+			return maskAny(err)
+		}
 	}
 	return nil
 }
@@ -82,28 +100,31 @@ func (s *httpChallengeProvider) Start() error {
 	var handler http.HandlerFunc
 	handler = func(w http.ResponseWriter, req *http.Request) {
 		path := req.URL.Path
-		if strings.HasPrefix(path, pathPrefix) && req.Method == "GET" {
-			token := path[len(pathPrefix):]
-			kAPI := client.NewKeysAPI(s.EtcdClient)
-			options := &client.GetOptions{
-				Recursive: false,
-				Sort:      false,
-			}
-			r, err := kAPI.Get(context.Background(), s.etcdTokenKey(token), options)
-			if err != nil {
-				s.Logger.Errorf("Failed to get keyAuth for token '%s': %#v", token, err)
-				w.WriteHeader(http.StatusNotFound)
-				w.Write([]byte("NOT FOUND"))
-				return
-			}
-			keyAuth := r.Node.Value
-			s.Logger.Infof("Found keyAuth for token '%s'", token)
-			w.Header().Add("Content-Type", "text/plain")
-			w.Write([]byte(keyAuth))
-		} else {
-			s.Logger.Warning("Unknown token request: %s", path)
-			w.Write([]byte("HELLO"))
+		if !strings.HasPrefix(path, pathPrefix) || req.Method != "GET" {
+			s.Logger.Warningf("Unknown token request: %s %s", req.Method, path)
+			challengeRequestsTotal.WithLabelValues(challengeResultInvalid).Inc()
+			http.NotFound(w, req)
+			return
+		}
+		token := path[len(pathPrefix):]
+		keyAuth, source, err := s.getKeyAuth(token)
+		if err != nil {
+			s.Logger.Errorf("Failed to get keyAuth for token '%s': %#v", token, err)
+		}
+		if keyAuth == "" && req.Header.Get(peerRequestHeader) == "" {
+			// Not found locally, ask the other instances
+			keyAuth = s.getKeyAuthFromPeers(req.URL.Path)
+			source = challengeResultPeer
 		}
+		if keyAuth == "" {
+			challengeRequestsTotal.WithLabelValues(challengeResultMiss).Inc()
+			http.NotFound(w, req)
+			return
+		}
+		s.Logger.Infof("Found keyAuth for token '%s' in %s", token, source)
+		challengeRequestsTotal.WithLabelValues(source).Inc()
+		w.Header().Add("Content-Type", "text/plain")
+		w.Write([]byte(keyAuth))
 	}
 
 	listener, err := net.Listen("tcp", net.JoinHostPort("0.0.0.0", strconv.Itoa(s.Port)))
@@ -115,6 +136,64 @@ func (s *httpChallengeProvider) Start() error {
 	return nil
 }
 
-func (s *httpChallengeProvider) etcdTokenKey(token string) string {
-	return path.Join(s.EtcdPrefix, token)
+// getKeyAuth returns the keyAuth for the given token and the source it was found in.
+// The in-memory tokens are tried first, the store is used as fallback (for tokens presented by other instances).
+// If the token is not found, an empty keyAuth is returned.
+func (s *httpChallengeProvider) getKeyAuth(token string) (string, string, error) {
+	s.tokensMutex.RLock()
+	keyAuth, found := s.tokens[token]
+	s.tokensMutex.RUnlock()
+	if found {
+		return keyAuth, challengeResultMemory, nil
+	}
+
+	if s.Store == nil {
+		return "", "", nil
+	}
+	keyAuth, err := s.Store.Get(token)
+	if err != nil {
+		return "", "", maskAny(err)
+	}
+	return keyAuth, challengeResultStore, nil
+}
+
+// getKeyAuthFromPeers requests the given challenge path from all peers
+// and returns the first keyAuth found (or an empty string if not found).
+func (s *httpChallengeProvider) getKeyAuthFromPeers(challengePath string) string {
+	if len(s.Peers) == 0 {
+		return ""
+	}
+	results := make(chan string, len(s.Peers))
+	client := &http.Client{Timeout: peerRequestTimeout}
+	for _, peer := range s.Peers {
+		go func(peer string) {
+			keyAuth := ""
+			defer func() { results <- keyAuth }()
+			req, err := http.NewRequest("GET", "http://"+peer+challengePath, nil)
+			if err != nil {
+				return
+			}
+			req.Header.Set(peerRequestHeader, "1")
+			resp, err := client.Do(req)
+			if err != nil {
+				s.Logger.Debugf("Failed to request challenge from peer %s: %#v", peer, err)
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+			raw, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+			if err != nil {
+				return
+			}
+			keyAuth = string(raw)
+		}(peer)
+	}
+	for range s.Peers {
+		if keyAuth := <-results; keyAuth != "" {
+			return keyAuth
+		}
+	}
+	return ""
 }
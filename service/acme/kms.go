@@ -0,0 +1,113 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acme
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	kmsRequestTimeout = time.Second * 30
+)
+
+var (
+	// vaultKeyNameRegexp matches key names that can be used in a Vault API path as is.
+	vaultKeyNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+)
+
+// KeyDecrypter decrypts data keys that are encrypted by a key management service (KMS).
+// This allows the key that encrypts certificates to be stored encrypted itself (envelope encryption).
+type KeyDecrypter interface {
+	// DecryptKey decrypts the given encrypted data key.
+	DecryptKey(ctx context.Context, ciphertext string) ([]byte, error)
+}
+
+// NewVaultTransitKeyDecrypter creates a KeyDecrypter that uses the transit secrets engine of
+// HashiCorp Vault (at given address) with the key with given name.
+// Encrypted data keys are created with `vault write -f transit/datakey/wrapped/<key-name> bits=256`.
+func NewVaultTransitKeyDecrypter(address, keyName, token string) (KeyDecrypter, error) {
+	if address == "" {
+		return nil, maskAny(fmt.Errorf("Vault address is empty"))
+	}
+	if _, err := url.Parse(address); err != nil {
+		return nil, maskAny(err)
+	}
+	if keyName == "" {
+		return nil, maskAny(fmt.Errorf("Vault transit key name is empty"))
+	}
+	if !vaultKeyNameRegexp.MatchString(keyName) {
+		return nil, maskAny(fmt.Errorf("Vault transit key name '%s' contains invalid characters", keyName))
+	}
+	return &vaultTransitKeyDecrypter{
+		address: strings.TrimSuffix(address, "/"),
+		keyName: keyName,
+		token:   token,
+		client:  &http.Client{Timeout: kmsRequestTimeout},
+	}, nil
+}
+
+type vaultTransitKeyDecrypter struct {
+	address string
+	keyName string
+	token   string
+	client  *http.Client
+}
+
+// DecryptKey decrypts the given encrypted data key (vault:v<n>:...) using Vault.
+func (d *vaultTransitKeyDecrypter) DecryptKey(ctx context.Context, ciphertext string) ([]byte, error) {
+	body, err := json.Marshal(map[string]string{"ciphertext": strings.TrimSpace(ciphertext)})
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/v1/transit/decrypt/%s", d.address, d.keyName), bytes.NewReader(body))
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if d.token != "" {
+		req.Header.Set("X-Vault-Token", d.token)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, maskAny(fmt.Errorf("Vault transit decrypt returned status %d", resp.StatusCode))
+	}
+	var result struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, maskAny(err)
+	}
+	key, err := base64.StdEncoding.DecodeString(result.Data.Plaintext)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return key, nil
+}
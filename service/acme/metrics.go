@@ -0,0 +1,43 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acme
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	challengeResultMemory  = "memory"
+	challengeResultStore   = "store"
+	challengeResultPeer    = "peer"
+	challengeResultMiss    = "miss"
+	challengeResultInvalid = "invalid"
+)
+
+var (
+	challengeRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "robin",
+			Subsystem: "acme",
+			Name:      "http_challenge_requests_total",
+			Help:      "Total number of ACME HTTP challenge requests by result (memory|store|peer|miss|invalid).",
+		},
+		[]string{"result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(challengeRequestsTotal)
+}
@@ -15,6 +15,7 @@
 package acme
 
 import (
+	"sort"
 	"sync"
 	"time"
 
@@ -31,22 +32,38 @@ const (
 type RenewalMonitor interface {
 	SetUsedDomains(domains []string)
 	Start()
+	// Status returns the renewal status of all tracked domains.
+	Status() []DomainRenewalStatus
+}
+
+// DomainRenewalStatus contains the renewal status of a single domain.
+type DomainRenewalStatus struct {
+	Domain             string     `json:"domain"`
+	Expiration         *time.Time `json:"expiration,omitempty"`           // Expiration time of the current certificate
+	LastRenewalAttempt *time.Time `json:"last-renewal-attempt,omitempty"` // Time of the last renewal request
+	LastError          string     `json:"last-error,omitempty"`           // Error of the last check (if any)
+	NextCheck          *time.Time `json:"next-check,omitempty"`           // Time of the next scheduled check
 }
 
 type renewalMonitor struct {
 	Logger     *logging.Logger
 	Repository CertificatesRepository
 	Requester  CertificateRequester
+	Timeout    time.Duration // Timeout of repository operations (0 means none)
 
 	usedDomains      []string
 	usedDomainsMutex sync.Mutex
+
+	status      map[string]DomainRenewalStatus
+	statusMutex sync.Mutex
 }
 
-func NewRenewalMonitor(logger *logging.Logger, repository CertificatesRepository, requester CertificateRequester) RenewalMonitor {
+func NewRenewalMonitor(logger *logging.Logger, repository CertificatesRepository, requester CertificateRequester, timeout time.Duration) RenewalMonitor {
 	return &renewalMonitor{
 		Logger:     logger,
 		Repository: repository,
 		Requester:  requester,
+		Timeout:    timeout,
 	}
 }
 
@@ -56,6 +73,44 @@ func (rm *renewalMonitor) SetUsedDomains(domains []string) {
 	rm.usedDomains = domains
 }
 
+// Status returns the renewal status of all tracked domains.
+func (rm *renewalMonitor) Status() []DomainRenewalStatus {
+	domains := rm.getUsedDomains()
+	sort.Strings(domains)
+
+	rm.statusMutex.Lock()
+	defer rm.statusMutex.Unlock()
+	result := []DomainRenewalStatus{}
+	seen := make(map[string]struct{})
+	for _, domain := range domains {
+		if _, ok := seen[domain]; ok {
+			continue
+		}
+		seen[domain] = struct{}{}
+		status, ok := rm.status[domain]
+		if !ok {
+			status.Domain = domain
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+// updateStatus applies the given update function to the status of the given domain.
+func (rm *renewalMonitor) updateStatus(domain string, update func(*DomainRenewalStatus)) {
+	rm.statusMutex.Lock()
+	defer rm.statusMutex.Unlock()
+	if rm.status == nil {
+		rm.status = make(map[string]DomainRenewalStatus)
+	}
+	status, ok := rm.status[domain]
+	if !ok {
+		status.Domain = domain
+	}
+	update(&status)
+	rm.status[domain] = status
+}
+
 func (rm *renewalMonitor) getUsedDomains() []string {
 	rm.usedDomainsMutex.Lock()
 	defer rm.usedDomainsMutex.Unlock()
@@ -70,24 +125,39 @@ func (rm *renewalMonitor) Start() {
 			// Get all used domains
 			domains := rm.getUsedDomains()
 			for _, domain := range domains {
-				if err := rm.renewCertificateIfNeeded(domain); err != nil {
+				err := rm.renewCertificateIfNeeded(domain)
+				if err != nil {
 					rm.Logger.Errorf("Failed to renew certificate for '%s': %#v", domain, err)
 				}
+				rm.updateStatus(domain, func(status *DomainRenewalStatus) {
+					status.LastError = ""
+					if err != nil {
+						status.LastError = err.Error()
+					}
+				})
 			}
 
 			// Wait a bit before checking for renewals again
+			sleep := renewalSleep
 			if len(domains) == 0 {
-				time.Sleep(time.Second * 10)
-			} else {
-				time.Sleep(renewalSleep)
+				sleep = time.Second * 10
 			}
+			nextCheck := time.Now().Add(sleep)
+			for _, domain := range domains {
+				rm.updateStatus(domain, func(status *DomainRenewalStatus) {
+					status.NextCheck = &nextCheck
+				})
+			}
+			time.Sleep(sleep)
 		}
 	}()
 }
 
 func (rm *renewalMonitor) renewCertificateIfNeeded(domain string) error {
 	// Load current certificate
-	cert, err := rm.Repository.LoadDomainCertificate(domain)
+	ctx, cancel := withTimeout(rm.Timeout)
+	defer cancel()
+	cert, err := rm.Repository.LoadDomainCertificate(ctx, domain)
 	if err != nil {
 		return maskAny(err)
 	}
@@ -102,6 +172,9 @@ func (rm *renewalMonitor) renewCertificateIfNeeded(domain string) error {
 	if err != nil {
 		return maskAny(err)
 	}
+	rm.updateStatus(domain, func(status *DomainRenewalStatus) {
+		status.Expiration = &expTime
+	})
 
 	// The time returned from the certificate is always in UTC.
 	// So calculate the time left with local time as UTC.
@@ -117,6 +190,11 @@ func (rm *renewalMonitor) renewCertificateIfNeeded(domain string) error {
 	// We need to renew the certificate
 	rm.Logger.Debugf("Certificate for '%s' is due for renewal, it has %d days left", daysLeft)
 
+	now := time.Now()
+	rm.updateStatus(domain, func(status *DomainRenewalStatus) {
+		status.LastRenewalAttempt = &now
+	})
+
 	op := func() error {
 		return maskAny(rm.Requester.RequestCertificates([]string{domain}))
 	}
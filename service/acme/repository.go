@@ -14,13 +14,26 @@
 
 package acme
 
+import (
+	"context"
+	"time"
+)
+
 type CertificatesRepository interface {
-	WatchDomainCertificates() error
+	WatchDomainCertificates(ctx context.Context) error
 
 	// loadDomainCertificate tries to load the certificate for the given domain from the ETCD repository
 	// Returns nil,nil if domain is not found.
-	LoadDomainCertificate(domain string) ([]byte, error)
+	LoadDomainCertificate(ctx context.Context, domain string) ([]byte, error)
 
 	// storeDomainCertificate stores the certificate for the given domain in the ETCD repository
-	StoreDomainCertificate(domain string, certificate []byte) error
+	StoreDomainCertificate(ctx context.Context, domain string, certificate []byte) error
+}
+
+// withTimeout returns a context for repository operations that expires after the given timeout (0 means never).
+func withTimeout(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
 }
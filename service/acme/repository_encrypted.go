@@ -0,0 +1,157 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/op/go-logging"
+)
+
+var (
+	// encryptedCertificateMagic is put in front of all encrypted certificates,
+	// so they can be distinguished from (old) plain text certificates.
+	encryptedCertificateMagic = []byte("robin-aesgcm-v1:")
+)
+
+// NewEncryptedCertificatesRepository wraps the given repository such that all certificates
+// are stored encrypted using AES-GCM with the given key (16, 24 or 32 bytes).
+// Plain text certificates found in the given repository are encrypted when they are loaded.
+func NewEncryptedCertificatesRepository(repository CertificatesRepository, key []byte, logger *logging.Logger) (CertificatesRepository, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return &encryptedCertificatesRepository{
+		Repository: repository,
+		Logger:     logger,
+		gcm:        gcm,
+	}, nil
+}
+
+// ParseEncryptionKey parses a hex encoded AES key.
+func ParseEncryptionKey(hexKey string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(hexKey))
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if err := validateEncryptionKey(key); err != nil {
+		return nil, maskAny(err)
+	}
+	return key, nil
+}
+
+// validateEncryptionKey checks that the given key is a valid AES key.
+func validateEncryptionKey(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	default:
+		return maskAny(fmt.Errorf("Encryption key must be 16, 24 or 32 bytes, got %d", len(key)))
+	}
+}
+
+// DecryptEncryptionKey decrypts the given encrypted AES key using the given key management service.
+func DecryptEncryptionKey(ctx context.Context, kms KeyDecrypter, ciphertext string) ([]byte, error) {
+	key, err := kms.DecryptKey(ctx, ciphertext)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if err := validateEncryptionKey(key); err != nil {
+		return nil, maskAny(err)
+	}
+	return key, nil
+}
+
+// ReadEncryptionKeyFile reads a hex encoded AES key from the given file.
+func ReadEncryptionKeyFile(path string) ([]byte, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	key, err := ParseEncryptionKey(string(raw))
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return key, nil
+}
+
+type encryptedCertificatesRepository struct {
+	Repository CertificatesRepository
+	Logger     *logging.Logger
+
+	gcm cipher.AEAD
+}
+
+func (s *encryptedCertificatesRepository) WatchDomainCertificates(ctx context.Context) error {
+	return maskAny(s.Repository.WatchDomainCertificates(ctx))
+}
+
+// LoadDomainCertificate loads and decrypts the certificate for the given domain.
+// Returns nil,nil if domain is not found.
+func (s *encryptedCertificatesRepository) LoadDomainCertificate(ctx context.Context, domain string) ([]byte, error) {
+	raw, err := s.Repository.LoadDomainCertificate(ctx, domain)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if raw == nil {
+		return nil, nil
+	}
+	if !bytes.HasPrefix(raw, encryptedCertificateMagic) {
+		// Plain text certificate, migrate it
+		s.Logger.Infof("Encrypting plain text certificate of '%s'", domain)
+		if err := s.StoreDomainCertificate(ctx, domain, raw); err != nil {
+			s.Logger.Errorf("Failed to encrypt plain text certificate of '%s': %#v", domain, err)
+		}
+		return raw, nil
+	}
+	sealed := raw[len(encryptedCertificateMagic):]
+	nonceSize := s.gcm.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, maskAny(fmt.Errorf("Encrypted certificate of '%s' is too short", domain))
+	}
+	certificate, err := s.gcm.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(domain))
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return certificate, nil
+}
+
+// StoreDomainCertificate encrypts and stores the certificate for the given domain.
+func (s *encryptedCertificatesRepository) StoreDomainCertificate(ctx context.Context, domain string, certificate []byte) error {
+	nonce := make([]byte, s.gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return maskAny(err)
+	}
+	sealed := s.gcm.Seal(nonce, nonce, certificate, []byte(domain))
+	value := append(append([]byte{}, encryptedCertificateMagic...), sealed...)
+	if err := s.Repository.StoreDomainCertificate(ctx, domain, value); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/op/go-logging"
+)
+
+// memoryCertificatesRepository stores certificates in memory.
+type memoryCertificatesRepository map[string][]byte
+
+func (r memoryCertificatesRepository) WatchDomainCertificates(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (r memoryCertificatesRepository) LoadDomainCertificate(ctx context.Context, domain string) ([]byte, error) {
+	return r[domain], nil
+}
+
+func (r memoryCertificatesRepository) StoreDomainCertificate(ctx context.Context, domain string, certificate []byte) error {
+	r[domain] = certificate
+	return nil
+}
+
+var (
+	testEncryptionKey = bytes.Repeat([]byte{0x42}, 32)
+	testCertificate   = []byte("-----BEGIN CERTIFICATE-----\nfoo\n-----END CERTIFICATE-----\n")
+)
+
+func TestEncryptedCertificatesRepositoryRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	inner := memoryCertificatesRepository{}
+	repo, err := NewEncryptedCertificatesRepository(inner, testEncryptionKey, logging.MustGetLogger("test"))
+	if err != nil {
+		t.Fatalf("NewEncryptedCertificatesRepository failed: %#v", err)
+	}
+	if err := repo.StoreDomainCertificate(ctx, "foo.com", testCertificate); err != nil {
+		t.Fatalf("StoreDomainCertificate failed: %#v", err)
+	}
+	stored := inner["foo.com"]
+	if !bytes.HasPrefix(stored, encryptedCertificateMagic) || bytes.Contains(stored, testCertificate) {
+		t.Errorf("Expected certificate to be stored encrypted, got %q", stored)
+	}
+	result, err := repo.LoadDomainCertificate(ctx, "foo.com")
+	if err != nil {
+		t.Fatalf("LoadDomainCertificate failed: %#v", err)
+	}
+	if !bytes.Equal(result, testCertificate) {
+		t.Errorf("Expected %q, got %q", testCertificate, result)
+	}
+	if result, err := repo.LoadDomainCertificate(ctx, "bar.com"); err != nil || result != nil {
+		t.Errorf("Expected nil,nil for unknown domain, got %q, %#v", result, err)
+	}
+
+	// The domain is authenticated, so a certificate cannot be moved to another domain
+	inner["bar.com"] = stored
+	if _, err := repo.LoadDomainCertificate(ctx, "bar.com"); err == nil {
+		t.Errorf("Expected error when loading certificate stored for another domain")
+	}
+	// A different key cannot decrypt the certificate
+	other, _ := NewEncryptedCertificatesRepository(inner, bytes.Repeat([]byte{0x24}, 32), logging.MustGetLogger("test"))
+	if _, err := other.LoadDomainCertificate(ctx, "foo.com"); err == nil {
+		t.Errorf("Expected error when loading certificate with another key")
+	}
+}
+
+func TestEncryptedCertificatesRepositoryMigration(t *testing.T) {
+	ctx := context.Background()
+	inner := memoryCertificatesRepository{"foo.com": testCertificate}
+	repo, err := NewEncryptedCertificatesRepository(inner, testEncryptionKey, logging.MustGetLogger("test"))
+	if err != nil {
+		t.Fatalf("NewEncryptedCertificatesRepository failed: %#v", err)
+	}
+	result, err := repo.LoadDomainCertificate(ctx, "foo.com")
+	if err != nil {
+		t.Fatalf("LoadDomainCertificate failed: %#v", err)
+	}
+	if !bytes.Equal(result, testCertificate) {
+		t.Errorf("Expected %q, got %q", testCertificate, result)
+	}
+	if !bytes.HasPrefix(inner["foo.com"], encryptedCertificateMagic) {
+		t.Errorf("Expected plain text certificate to be encrypted, got %q", inner["foo.com"])
+	}
+	if result, err := repo.LoadDomainCertificate(ctx, "foo.com"); err != nil || !bytes.Equal(result, testCertificate) {
+		t.Errorf("Expected migrated certificate, got %q, %#v", result, err)
+	}
+}
+
+func TestParseEncryptionKey(t *testing.T) {
+	for _, test := range []struct {
+		Key   string
+		Valid bool
+	}{
+		{"000102030405060708090a0b0c0d0e0f", true},
+		{"000102030405060708090a0b0c0d0e0f1011121314151617\n", true},
+		{"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f", true},
+		{"0001020304", false},
+		{"not-hex", false},
+	} {
+		if _, err := ParseEncryptionKey(test.Key); (err == nil) != test.Valid {
+			t.Errorf("Expected '%s' to be valid=%v, got %v", test.Key, test.Valid, err)
+		}
+	}
+}
+
+func TestVaultTransitKeyDecrypter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/transit/decrypt/robin" || r.Header.Get("X-Vault-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var req struct {
+			Ciphertext string `json:"ciphertext"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		plaintext := testEncryptionKey
+		if req.Ciphertext == "vault:v1:short" {
+			plaintext = []byte("short")
+		} else if req.Ciphertext != "vault:v1:wrapped" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]string{"plaintext": base64.StdEncoding.EncodeToString(plaintext)},
+		})
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	kms, err := NewVaultTransitKeyDecrypter(server.URL, "robin", "secret")
+	if err != nil {
+		t.Fatalf("NewVaultTransitKeyDecrypter failed: %#v", err)
+	}
+	key, err := DecryptEncryptionKey(ctx, kms, "vault:v1:wrapped\n")
+	if err != nil {
+		t.Fatalf("DecryptEncryptionKey failed: %#v", err)
+	}
+	if !bytes.Equal(key, testEncryptionKey) {
+		t.Errorf("Expected %x, got %x", testEncryptionKey, key)
+	}
+	if _, err := DecryptEncryptionKey(ctx, kms, "vault:v1:short"); err == nil {
+		t.Errorf("Expected error for invalid key length")
+	}
+	if _, err := DecryptEncryptionKey(ctx, kms, "vault:v1:other"); err == nil {
+		t.Errorf("Expected error for unknown ciphertext")
+	}
+	denied, _ := NewVaultTransitKeyDecrypter(server.URL, "robin", "wrong")
+	if _, err := DecryptEncryptionKey(ctx, denied, "vault:v1:wrapped"); err == nil {
+		t.Errorf("Expected error for invalid token")
+	}
+	if _, err := NewVaultTransitKeyDecrypter("", "robin", "secret"); err == nil {
+		t.Errorf("Expected error for empty address")
+	}
+	if _, err := NewVaultTransitKeyDecrypter(server.URL, "../sys/seal", "secret"); err == nil {
+		t.Errorf("Expected error for invalid key name")
+	}
+}
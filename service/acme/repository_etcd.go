@@ -15,11 +15,11 @@
 package acme
 
 import (
+	"context"
 	"encoding/base64"
 	"path"
 
 	"github.com/coreos/etcd/client"
-	"golang.org/x/net/context"
 )
 
 const (
@@ -58,8 +58,8 @@ func isEtcdWithCode(err error, errCode int) bool {
 
 // watchDomainCertificates waits for changes on one of the domain certificates
 // in the repository and returns where there is a change.
-func (s *etcdCertificatesRepository) WatchDomainCertificates() error {
-	_, err := s.domainCertificatesWatcher.Next(context.Background())
+func (s *etcdCertificatesRepository) WatchDomainCertificates(ctx context.Context) error {
+	_, err := s.domainCertificatesWatcher.Next(ctx)
 	if err != nil {
 		return maskAny(err)
 	}
@@ -68,14 +68,14 @@ func (s *etcdCertificatesRepository) WatchDomainCertificates() error {
 
 // loadDomainCertificate tries to load the certificate for the given domain from the ETCD repository
 // Returns nil,nil if domain is not found.
-func (s *etcdCertificatesRepository) LoadDomainCertificate(domain string) ([]byte, error) {
+func (s *etcdCertificatesRepository) LoadDomainCertificate(ctx context.Context, domain string) ([]byte, error) {
 	kAPI := client.NewKeysAPI(s.EtcdClient)
 	options := &client.GetOptions{
 		Recursive: false,
 		Sort:      false,
 	}
 	key := s.domainCertificateKey(domain)
-	resp, err := kAPI.Get(context.Background(), key, options)
+	resp, err := kAPI.Get(ctx, key, options)
 	if err != nil {
 		if isEtcdWithCode(err, client.ErrorCodeKeyNotFound) {
 			return nil, nil
@@ -90,14 +90,14 @@ func (s *etcdCertificatesRepository) LoadDomainCertificate(domain string) ([]byt
 }
 
 // storeDomainCertificate stores the certificate for the given domain in the ETCD repository
-func (s *etcdCertificatesRepository) StoreDomainCertificate(domain string, certificate []byte) error {
+func (s *etcdCertificatesRepository) StoreDomainCertificate(ctx context.Context, domain string, certificate []byte) error {
 	kAPI := client.NewKeysAPI(s.EtcdClient)
 	options := &client.SetOptions{
 		TTL: 0,
 	}
 	key := s.domainCertificateKey(domain)
 	value := base64.StdEncoding.EncodeToString(certificate)
-	if _, err := kAPI.Set(context.Background(), key, value, options); err != nil {
+	if _, err := kAPI.Set(ctx, key, value, options); err != nil {
 		return maskAny(err)
 	}
 	return nil
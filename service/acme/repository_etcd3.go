@@ -0,0 +1,108 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acme
+
+import (
+	"context"
+	"encoding/base64"
+	"path"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/juju/errgo"
+)
+
+var (
+	watchClosedError = errgo.New("watch closed")
+)
+
+func NewEtcd3CertificatesRepository(etcdPrefix string, etcdClient *clientv3.Client) CertificatesRepository {
+	return &etcd3CertificatesRepository{
+		EtcdPrefix: etcdPrefix,
+		EtcdClient: etcdClient,
+	}
+}
+
+type etcd3CertificatesRepository struct {
+	EtcdPrefix string
+	EtcdClient *clientv3.Client
+
+	domainCertificatesWatch  clientv3.WatchChan
+	domainCertificatesCancel context.CancelFunc
+}
+
+// watchDomainCertificates waits for changes on one of the domain certificates
+// in the repository and returns where there is a change.
+func (s *etcd3CertificatesRepository) WatchDomainCertificates(ctx context.Context) error {
+	if s.domainCertificatesWatch == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		prefix := path.Join(s.EtcdPrefix, etcdCertificatesFolder) + "/"
+		s.domainCertificatesWatch = s.EtcdClient.Watch(ctx, prefix, clientv3.WithPrefix())
+		s.domainCertificatesCancel = cancel
+	}
+	var resp clientv3.WatchResponse
+	var ok bool
+	select {
+	case resp, ok = <-s.domainCertificatesWatch:
+	case <-ctx.Done():
+		return maskAny(ctx.Err())
+	}
+	if !ok {
+		s.resetWatch()
+		return maskAny(watchClosedError)
+	}
+	if err := resp.Err(); err != nil {
+		s.resetWatch()
+		return maskAny(err)
+	}
+	return nil
+}
+
+// resetWatch cancels the current watch, so the next call to WatchDomainCertificates starts a new one.
+func (s *etcd3CertificatesRepository) resetWatch() {
+	s.domainCertificatesCancel()
+	s.domainCertificatesWatch = nil
+	s.domainCertificatesCancel = nil
+}
+
+// loadDomainCertificate tries to load the certificate for the given domain from the ETCD repository
+// Returns nil,nil if domain is not found.
+func (s *etcd3CertificatesRepository) LoadDomainCertificate(ctx context.Context, domain string) ([]byte, error) {
+	resp, err := s.EtcdClient.Get(ctx, s.domainCertificateKey(domain))
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(string(resp.Kvs[0].Value))
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return raw, nil
+}
+
+// storeDomainCertificate stores the certificate for the given domain in the ETCD repository
+func (s *etcd3CertificatesRepository) StoreDomainCertificate(ctx context.Context, domain string, certificate []byte) error {
+	value := base64.StdEncoding.EncodeToString(certificate)
+	if _, err := s.EtcdClient.Put(ctx, s.domainCertificateKey(domain), value); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// domainKey creates an ETCD key for the certificate of the given domain
+func (s *etcd3CertificatesRepository) domainCertificateKey(domain string) string {
+	return path.Join(s.EtcdPrefix, etcdCertificatesFolder, domain)
+}
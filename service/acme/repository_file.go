@@ -0,0 +1,92 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acme
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// NewFileCertificatesRepository creates a repository that stores certificates in the given folder.
+// It can only be used when a single instance is running.
+func NewFileCertificatesRepository(folder string) (CertificatesRepository, error) {
+	if err := os.MkdirAll(folder, 0700); err != nil {
+		return nil, maskAny(err)
+	}
+	return &fileCertificatesRepository{
+		Folder:  folder,
+		changed: make(chan struct{}),
+	}, nil
+}
+
+type fileCertificatesRepository struct {
+	Folder string
+
+	mutex   sync.Mutex
+	changed chan struct{} // Closed when a certificate is stored
+}
+
+// WatchDomainCertificates waits until a certificate is stored in the repository.
+func (s *fileCertificatesRepository) WatchDomainCertificates(ctx context.Context) error {
+	s.mutex.Lock()
+	changed := s.changed
+	s.mutex.Unlock()
+	select {
+	case <-changed:
+		return nil
+	case <-ctx.Done():
+		return maskAny(ctx.Err())
+	}
+}
+
+// LoadDomainCertificate tries to load the certificate for the given domain from the folder.
+// Returns nil,nil if domain is not found.
+func (s *fileCertificatesRepository) LoadDomainCertificate(ctx context.Context, domain string) ([]byte, error) {
+	raw, err := ioutil.ReadFile(s.domainCertificatePath(domain))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, maskAny(err)
+	}
+	return raw, nil
+}
+
+// StoreDomainCertificate stores the certificate for the given domain in the folder.
+func (s *fileCertificatesRepository) StoreDomainCertificate(ctx context.Context, domain string, certificate []byte) error {
+	path := s.domainCertificatePath(domain)
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, certificate, 0600); err != nil {
+		return maskAny(err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return maskAny(err)
+	}
+
+	s.mutex.Lock()
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.mutex.Unlock()
+	return nil
+}
+
+// domainCertificatePath returns the path of the file containing the certificate of the given domain.
+func (s *fileCertificatesRepository) domainCertificatePath(domain string) string {
+	return filepath.Join(s.Folder, strings.Replace(domain, "/", "_", -1)+".pem")
+}
@@ -16,6 +16,7 @@ package acme
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/op/go-logging"
@@ -35,21 +36,27 @@ var (
 
 type CertificateRequester interface {
 	Initialize(acmeClient *acme.Client)
+	// AddDomainSuffixClient registers a client used to obtain certificates for
+	// domains ending with the given suffix.
+	AddDomainSuffixClient(suffix string, acmeClient *acme.Client)
 	RequestCertificates(domains []string) error
 }
 
 type certificateRequester struct {
 	Logger       *logging.Logger
 	Repository   CertificatesRepository
+	Timeout      time.Duration // Timeout of repository operations (0 means none)
 	mutexService mutex.GlobalMutexService
 
-	acmeClient *acme.Client
+	acmeClient         *acme.Client
+	domainSuffixClient map[string]*acme.Client
 }
 
-func NewCertificateRequester(logger *logging.Logger, repository CertificatesRepository, mutexService mutex.GlobalMutexService) CertificateRequester {
+func NewCertificateRequester(logger *logging.Logger, repository CertificatesRepository, mutexService mutex.GlobalMutexService, timeout time.Duration) CertificateRequester {
 	return &certificateRequester{
 		Logger:       logger,
 		Repository:   repository,
+		Timeout:      timeout,
 		mutexService: mutexService,
 	}
 }
@@ -58,6 +65,27 @@ func (cr *certificateRequester) Initialize(acmeClient *acme.Client) {
 	cr.acmeClient = acmeClient
 }
 
+func (cr *certificateRequester) AddDomainSuffixClient(suffix string, acmeClient *acme.Client) {
+	if cr.domainSuffixClient == nil {
+		cr.domainSuffixClient = make(map[string]*acme.Client)
+	}
+	cr.domainSuffixClient[suffix] = acmeClient
+}
+
+// clientFor returns the ACME client used to obtain a certificate for the given domain.
+// The client registered for the longest matching domain suffix is preferred over the default client.
+func (cr *certificateRequester) clientFor(domain string) *acme.Client {
+	result := cr.acmeClient
+	longest := -1
+	for suffix, c := range cr.domainSuffixClient {
+		if hasDomainSuffix(domain, suffix) && len(suffix) > longest {
+			result = c
+			longest = len(suffix)
+		}
+	}
+	return result
+}
+
 // requestCertificates tries to request certificates for all given domains.
 // It first tries to claims to be the master. If that does not succeed,
 // it returns a NotMasterError
@@ -81,7 +109,7 @@ func (s *certificateRequester) RequestCertificates(domains []string) error {
 	for _, domain := range domains {
 		s.Logger.Debugf("Obtaining certificate for '%s'", domain)
 		bundle := true
-		certificates, failures := s.acmeClient.ObtainCertificate([]string{domain}, bundle, nil)
+		certificates, failures := s.clientFor(domain).ObtainCertificate([]string{domain}, bundle, nil)
 		if len(failures) > 0 {
 			failedDomains = append(failedDomains, domain)
 			s.Logger.Errorf("ObtainCertificate for '%s' failed: %#v", domain, failures)
@@ -108,13 +136,22 @@ func (s *certificateRequester) saveCertificate(domain string, cert acme.Certific
 	combined := append(cert.Certificate, cert.PrivateKey...)
 
 	// Store combined certificate in ETCD
-	if err := s.Repository.StoreDomainCertificate(domain, combined); err != nil {
+	ctx, cancel := withTimeout(s.Timeout)
+	defer cancel()
+	if err := s.Repository.StoreDomainCertificate(ctx, domain, combined); err != nil {
 		return maskAny(err)
 	}
 
 	return nil
 }
 
+// hasDomainSuffix returns true if the given domain is equal to, or a sub-domain of, the given suffix.
+func hasDomainSuffix(domain, suffix string) bool {
+	suffix = strings.TrimPrefix(strings.ToLower(suffix), ".")
+	domain = strings.ToLower(domain)
+	return domain == suffix || strings.HasSuffix(domain, "."+suffix)
+}
+
 // claimRequestCertificatesMutex tries to claim the distributed mutex for
 // requesting certificates.
 // On success it returns true with a mutex.
@@ -15,6 +15,9 @@
 package acme
 
 import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha1"
 	"fmt"
 	"time"
 
@@ -42,6 +45,9 @@ type AcmeServiceConfig struct {
 	Email            string // Registration email address
 	PrivateKeyPath   string // Path of file containing private key
 	RegistrationPath string // Path of file containing acme.RegistrationResource
+
+	PrivateCADirectoryURLs map[string]string // Domain suffix -> URL of ACME directory of internal CA used for private domains
+	Accounts               []AcmeAccount     // Additional accounts, selected by domain suffix
 }
 
 type AcmeServiceDependencies struct {
@@ -57,7 +63,7 @@ type AcmeServiceDependencies struct {
 type AcmeService interface {
 	Register() error
 	Start() error
-	Extend(services backend.ServiceRegistrations) (backend.ServiceRegistrations, error)
+	Extend(ctx context.Context, services backend.ServiceRegistrations) (backend.ServiceRegistrations, error)
 }
 
 type acmeService struct {
@@ -126,11 +132,29 @@ func (s *acmeService) Start() error {
 		return maskAny(err)
 	}
 	client.ExcludeChallenges([]acme.Challenge{acme.TLSSNI01, acme.DNS01})
-	client.SetChallengeProvider(acme.HTTP01, newHttpChallengeProvider(s.HttpProviderConfig, s.HttpProviderDependencies))
+	client.SetChallengeProvider(acme.HTTP01, s.httpProvider)
 
 	// Save objects
 	s.Requester.Initialize(client)
 
+	// Create ACME clients for internal CA's
+	for suffix, dirURL := range s.PrivateCADirectoryURLs {
+		privateClient, err := s.createPrivateCAClient(dirURL, key)
+		if err != nil {
+			return maskAny(err)
+		}
+		s.Requester.AddDomainSuffixClient(suffix, privateClient)
+	}
+
+	// Create ACME clients for additional accounts
+	for _, account := range s.Accounts {
+		accountClient, err := s.createAccountClient(account)
+		if err != nil {
+			return maskAny(err)
+		}
+		s.Requester.AddDomainSuffixClient(account.DomainSuffix, accountClient)
+	}
+
 	// Start HTTP challenge listener
 	if err := s.httpProvider.Start(); err != nil {
 		return maskAny(err)
@@ -156,7 +180,7 @@ func (s *acmeService) repositoryMonitorLoop() {
 			start := time.Now()
 			s.Cache.Clear()
 			s.Listener.CertificatesUpdated()
-			s.Repository.WatchDomainCertificates()
+			s.Repository.WatchDomainCertificates(context.Background())
 
 			// Prevent hammering the system
 			if time.Since(start) < time.Second*5 {
@@ -168,7 +192,7 @@ func (s *acmeService) repositoryMonitorLoop() {
 
 // Extend fills is missing data provided by ACME into the list of services.
 // It also adds a service to handle ACME HTTP challenges
-func (s *acmeService) Extend(services backend.ServiceRegistrations) (backend.ServiceRegistrations, error) {
+func (s *acmeService) Extend(ctx context.Context, services backend.ServiceRegistrations) (backend.ServiceRegistrations, error) {
 	if !s.active {
 		// Not active, so nothing to extend
 		return services, nil
@@ -181,13 +205,20 @@ func (s *acmeService) Extend(services backend.ServiceRegistrations) (backend.Ser
 	updatedServices := backend.ServiceRegistrations{}
 	for _, sr := range services {
 		for selIndex, sel := range sr.Selectors {
-			if !sr.Public || sel.SslCertName != "" || sel.Domain == "" {
+			if sel.SslCertName != "" || sel.Domain == "" {
+				continue
+			}
+			if sel.IsWildcard() {
+				// Wildcard certificates cannot be obtained with an HTTP challenge
+				continue
+			}
+			if !sr.Public && !s.hasPrivateCA(sel.Domain) {
 				continue
 			}
 			// Domain needs a certificate, try cache first
 			domain := sel.Domain
 			allDomains = append(allDomains, domain)
-			path, err := s.Cache.GetDomainCertificatePath(domain)
+			path, err := s.Cache.GetDomainCertificatePath(ctx, domain)
 			if err != nil {
 				s.Logger.Errorf("Failed to get domain certificate path for '%s': %#v", domain, err)
 			} else if path != "" {
@@ -227,6 +258,69 @@ func (s *acmeService) Extend(services backend.ServiceRegistrations) (backend.Ser
 	return updatedServices, nil
 }
 
+// createPrivateCAClient creates an ACME client for the internal CA with given directory URL.
+// Internal CA's do not require confirmation of terms, so the account is registered automatically.
+// The registration is saved, so the account is only registered once.
+func (s *acmeService) createPrivateCAClient(dirURL string, key *rsa.PrivateKey) (*acme.Client, error) {
+	registrationPath := s.privateCARegistrationPath(dirURL)
+	registration, err := loadRegistration(registrationPath)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	user := acmeUser{
+		Email:        s.Email,
+		Registration: registration,
+		PrivateKey:   key,
+	}
+	if registration == nil {
+		client, err := acme.NewClient(dirURL, user, s.KeyBits)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		registration, err = client.Register()
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		user.Registration = registration
+		client, err = acme.NewClient(dirURL, user, s.KeyBits)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		if registration.TosURL != "" {
+			if err := client.AgreeToTOS(); err != nil {
+				return nil, maskAny(err)
+			}
+		}
+		if err := saveRegistrationFile(registrationPath, registration); err != nil {
+			return nil, maskAny(err)
+		}
+		s.Logger.Infof("Registered at internal CA %s", dirURL)
+	}
+	client, err := acme.NewClient(dirURL, user, s.KeyBits)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	client.ExcludeChallenges([]acme.Challenge{acme.TLSSNI01, acme.DNS01})
+	client.SetChallengeProvider(acme.HTTP01, s.httpProvider)
+	return client, nil
+}
+
+// privateCARegistrationPath returns the path of the file containing the registration at the
+// internal CA with given directory URL. It is stored next to the main registration.
+func (s *acmeService) privateCARegistrationPath(dirURL string) string {
+	return fmt.Sprintf("%s.%x", s.RegistrationPath, sha1.Sum([]byte(dirURL)))
+}
+
+// hasPrivateCA returns true if there is an internal CA configured for the given domain.
+func (s *acmeService) hasPrivateCA(domain string) bool {
+	for suffix := range s.PrivateCADirectoryURLs {
+		if hasDomainSuffix(domain, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
 // createAcmeServiceRegistration creates a ServiceRegistration item for the ACME HTTP challenge
 func (s *acmeService) createAcmeServiceRegistration() backend.ServiceRegistration {
 	pathPrefix := acme.HTTP01ChallengePath("")
@@ -0,0 +1,84 @@
+package acme
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	logging "github.com/op/go-logging"
+)
+
+// newTestCA starts a minimal ACME server that accepts registrations.
+// It returns the directory URL and a counter of registrations.
+func newTestCA() (*httptest.Server, *int32) {
+	var registrations int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", atomic.LoadInt32(&registrations)))
+		switch {
+		case req.URL.Path == "/directory":
+			json.NewEncoder(w).Encode(map[string]string{
+				"new-authz":   server.URL + "/new-authz",
+				"new-cert":    server.URL + "/new-cert",
+				"new-reg":     server.URL + "/new-reg",
+				"revoke-cert": server.URL + "/revoke-cert",
+			})
+		case req.URL.Path == "/new-reg" && req.Method == "POST":
+			atomic.AddInt32(&registrations, 1)
+			w.Header().Set("Location", server.URL+"/reg/1")
+			w.Header().Add("Link", fmt.Sprintf("<%s/new-authz>;rel=\"next\"", server.URL))
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("{}"))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	return server, &registrations
+}
+
+func TestCreatePrivateCAClientRegistersOnce(t *testing.T) {
+	folder, err := ioutil.TempDir("", "robin-acme")
+	if err != nil {
+		t.Fatalf("TempDir failed: %#v", err)
+	}
+	defer os.RemoveAll(folder)
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %#v", err)
+	}
+	ca, registrations := newTestCA()
+	defer ca.Close()
+	dirURL := ca.URL + "/directory"
+
+	s := NewAcmeService(AcmeServiceConfig{
+		Email:            "admin@foo.com",
+		KeyBits:          1024,
+		RegistrationPath: filepath.Join(folder, "registration.json"),
+	}, AcmeServiceDependencies{
+		HttpProviderDependencies: HttpProviderDependencies{
+			Logger: logging.MustGetLogger("test"),
+		},
+	}).(*acmeService)
+	for i := 0; i < 3; i++ {
+		if _, err := s.createPrivateCAClient(dirURL, key); err != nil {
+			t.Fatalf("createPrivateCAClient failed: %#v", err)
+		}
+	}
+	if n := atomic.LoadInt32(registrations); n != 1 {
+		t.Errorf("Expected 1 registration, got %d", n)
+	}
+	if _, err := os.Stat(s.privateCARegistrationPath(dirURL)); err != nil {
+		t.Errorf("Expected registration to be saved: %v", err)
+	}
+	if s.privateCARegistrationPath(dirURL) == s.privateCARegistrationPath(ca.URL+"/other") {
+		t.Errorf("Expected registration paths to differ per directory URL")
+	}
+}
@@ -54,7 +54,17 @@ func (s *acmeService) getPrivateKey() (*rsa.PrivateKey, error) {
 // getRegistration reads the registration resource for the registration path.
 // If no such file exists, nil is returned.
 func (s *acmeService) getRegistration() (*acme.RegistrationResource, error) {
-	raw, err := ioutil.ReadFile(s.RegistrationPath)
+	res, err := loadRegistration(s.RegistrationPath)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return res, nil
+}
+
+// loadRegistration reads the registration resource from the given path.
+// If no such file exists, nil is returned.
+func loadRegistration(path string) (*acme.RegistrationResource, error) {
+	raw, err := ioutil.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(errgo.Cause(err)) {
 			return nil, nil
@@ -72,7 +82,12 @@ func (s *acmeService) getRegistration() (*acme.RegistrationResource, error) {
 
 // saveRegistration saves the given registration at the configured path
 func (s *acmeService) saveRegistration(res *acme.RegistrationResource) error {
-	if err := ensureDirectoryOf(s.RegistrationPath, 0755); err != nil {
+	return maskAny(saveRegistrationFile(s.RegistrationPath, res))
+}
+
+// saveRegistrationFile saves the given registration at the given path
+func saveRegistrationFile(path string, res *acme.RegistrationResource) error {
+	if err := ensureDirectoryOf(path, 0755); err != nil {
 		return maskAny(err)
 	}
 
@@ -81,7 +96,7 @@ func (s *acmeService) saveRegistration(res *acme.RegistrationResource) error {
 		return maskAny(err)
 	}
 
-	if err := ioutil.WriteFile(s.RegistrationPath, raw, 0600); err != nil {
+	if err := ioutil.WriteFile(path, raw, 0600); err != nil {
 		return maskAny(err)
 	}
 
@@ -0,0 +1,58 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acme
+
+import (
+	"fmt"
+)
+
+const (
+	StoreEtcd       = "etcd"       // Challenges / certificates are stored in ETCD (shared by all instances)
+	StoreMemory     = "memory"     // Challenges are kept in memory (single instance or with peers)
+	StoreKubernetes = "kubernetes" // Challenges are stored in a Kubernetes ConfigMap
+	StoreFile       = "file"       // Certificates are stored in a local folder (single instance)
+)
+
+// StoresConfig selects where ACME challenges and certificates are stored.
+type StoresConfig struct {
+	ChallengeStore string // etcd|memory|kubernetes
+	Repository     string // etcd|file
+	RepositoryPath string // Folder of the file repository
+}
+
+// Validate checks the selected stores.
+func (c StoresConfig) Validate() error {
+	switch c.ChallengeStore {
+	case StoreEtcd, StoreMemory, StoreKubernetes:
+	default:
+		return maskAny(fmt.Errorf("unknown challenge store '%s'", c.ChallengeStore))
+	}
+	switch c.Repository {
+	case StoreEtcd:
+	case StoreFile:
+		if c.RepositoryPath == "" {
+			return maskAny(fmt.Errorf("file repository requires a path"))
+		}
+	default:
+		return maskAny(fmt.Errorf("unknown certificates repository '%s'", c.Repository))
+	}
+	return nil
+}
+
+// NeedsEtcd returns true if any of the selected stores is ETCD.
+// When the certificates are stored in ETCD, certificate requests are also coordinated through ETCD.
+func (c StoresConfig) NeedsEtcd() bool {
+	return c.ChallengeStore == StoreEtcd || c.Repository == StoreEtcd
+}
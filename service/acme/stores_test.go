@@ -0,0 +1,98 @@
+package acme
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStoresConfig(t *testing.T) {
+	tests := []struct {
+		Config    StoresConfig
+		Valid     bool
+		NeedsEtcd bool
+	}{
+		{StoresConfig{ChallengeStore: StoreEtcd, Repository: StoreEtcd}, true, true},
+		{StoresConfig{ChallengeStore: StoreMemory, Repository: StoreEtcd}, true, true},
+		{StoresConfig{ChallengeStore: StoreEtcd, Repository: StoreFile, RepositoryPath: "/certs"}, true, true},
+		{StoresConfig{ChallengeStore: StoreMemory, Repository: StoreFile, RepositoryPath: "/certs"}, true, false},
+		{StoresConfig{ChallengeStore: StoreKubernetes, Repository: StoreFile, RepositoryPath: "/certs"}, true, false},
+		{StoresConfig{ChallengeStore: StoreMemory, Repository: StoreFile}, false, false},
+		{StoresConfig{ChallengeStore: "redis", Repository: StoreEtcd}, false, true},
+		{StoresConfig{ChallengeStore: StoreMemory, Repository: StoreMemory}, false, false},
+	}
+	for _, test := range tests {
+		if err := test.Config.Validate(); (err == nil) != test.Valid {
+			t.Errorf("Expected Validate of %#v to be valid=%v, got %v", test.Config, test.Valid, err)
+		}
+		if actual := test.Config.NeedsEtcd(); actual != test.NeedsEtcd {
+			t.Errorf("Expected NeedsEtcd of %#v to be %v, got %v", test.Config, test.NeedsEtcd, actual)
+		}
+	}
+}
+
+func TestFileCertificatesRepository(t *testing.T) {
+	folder, err := ioutil.TempDir("", "robin-acme")
+	if err != nil {
+		t.Fatalf("TempDir failed: %#v", err)
+	}
+	defer os.RemoveAll(folder)
+	repo, err := NewFileCertificatesRepository(folder)
+	if err != nil {
+		t.Fatalf("NewFileCertificatesRepository failed: %#v", err)
+	}
+
+	ctx := context.Background()
+	if raw, err := repo.LoadDomainCertificate(ctx, "foo.com"); err != nil || raw != nil {
+		t.Errorf("Expected no certificate, got %q, %v", raw, err)
+	}
+
+	watched := make(chan error)
+	go func() {
+		watched <- repo.WatchDomainCertificates(ctx)
+	}()
+	// Store until the watch (started concurrently) notices
+	timeout := time.After(time.Second)
+	for done := false; !done; {
+		if err := repo.StoreDomainCertificate(ctx, "foo.com", []byte("cert")); err != nil {
+			t.Fatalf("StoreDomainCertificate failed: %#v", err)
+		}
+		select {
+		case err := <-watched:
+			if err != nil {
+				t.Errorf("WatchDomainCertificates failed: %#v", err)
+			}
+			done = true
+		case <-time.After(time.Millisecond * 10):
+		case <-timeout:
+			t.Fatalf("Expected WatchDomainCertificates to return after a store")
+		}
+	}
+	if raw, err := repo.LoadDomainCertificate(ctx, "foo.com"); err != nil || string(raw) != "cert" {
+		t.Errorf("Expected stored certificate, got %q, %v", raw, err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := repo.WatchDomainCertificates(cancelled); err == nil {
+		t.Errorf("Expected WatchDomainCertificates to fail on a cancelled context")
+	}
+}
+
+func TestHttpChallengeProviderWithoutStore(t *testing.T) {
+	p := newHttpChallengeProvider(HttpProviderConfig{}, HttpProviderDependencies{})
+	if err := p.Present("foo.com", "token", "keyAuth"); err != nil {
+		t.Fatalf("Present failed: %#v", err)
+	}
+	if keyAuth, source, err := p.getKeyAuth("token"); err != nil || keyAuth != "keyAuth" || source != challengeResultMemory {
+		t.Errorf("Expected keyAuth from memory, got %s, %s, %v", keyAuth, source, err)
+	}
+	if err := p.CleanUp("foo.com", "token", "keyAuth"); err != nil {
+		t.Fatalf("CleanUp failed: %#v", err)
+	}
+	if keyAuth, _, err := p.getKeyAuth("token"); err != nil || keyAuth != "" {
+		t.Errorf("Expected no keyAuth after cleanup, got %s, %v", keyAuth, err)
+	}
+}
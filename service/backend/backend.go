@@ -15,35 +15,89 @@
 package backend
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	api "github.com/pulcy/robin-api"
 )
 
 type Backend interface {
 	api.API
+	StatusReporter
 
 	// Watch for changes in the backend and return where there is a change.
-	Watch() error
+	// It returns an error when the given context is canceled or expires before there is a change.
+	Watch(ctx context.Context) error
 
 	// Load all registered services
-	Services() (ServiceRegistrations, error)
+	Services(ctx context.Context) (ServiceRegistrations, error)
+
+	// Load all Lua scripts (name -> source) stored in the backend
+	LuaScripts(ctx context.Context) (map[string]string, error)
+}
+
+// Scheduler is implemented by backends that support scheduled changes of frontend records.
+type Scheduler interface {
+	api.ScheduleAPI
+
+	// ApplyDueChanges applies all scheduled changes that are due at the given time.
+	ApplyDueChanges(now time.Time) error
 }
 
 type ServiceRegistration struct {
-	ServiceName     string           // Name of the service
-	ServicePort     int              // Port the service is listening on (inside its container)
-	EdgePort        int              // Port that Robin listening on for the service.
-	Public          bool             // If true, this service is exposed to the public network, otherwise it is only exposed to the private network.
-	Instances       ServiceInstances // List instances of the service (can not be empty)
-	Selectors       ServiceSelectors // List of selectors to match traffic to this service
-	HttpCheckPath   string           // Path (on the service) used for health checks (can be empty)
-	HttpCheckMethod string           // Method (on the service) used for health checks (can be empty)
-	Mode            string           // http|tcp
-	Sticky          bool             // Switched blancing mode to source
-	Backup          bool             // If set all instances are backup only servers for their selectors
+	ServiceName        string            // Name of the service
+	ServicePort        int               // Port the service is listening on (inside its container)
+	EdgePort           int               // Port that Robin listening on for the service.
+	Public             bool              // If true, this service is exposed to the public network, otherwise it is only exposed to the private network.
+	Instances          ServiceInstances  // List instances of the service (can not be empty)
+	Selectors          ServiceSelectors  // List of selectors to match traffic to this service
+	HttpCheckPath      string            // Path (on the service) used for health checks (can be empty)
+	HttpCheckMethod    string            // Method (on the service) used for health checks (can be empty)
+	HttpCheckHost      string            // Host header used for health checks (can be empty)
+	HttpCheckPort      int               // Port used for health checks (0 means ServicePort)
+	HttpCheckInterval  string            // Interval between health checks (can be empty)
+	TcpCheck           string            // Protocol level health check of tcp services (mysql|redis|pgsql, can be empty)
+	TcpCheckUser       string            // User used by mysql & pgsql health checks (can be empty)
+	AgentCheckPort     int               // If set, an agent check is performed on this port
+	AgentCheckInterval string            // Interval between agent checks (can be empty)
+	ProbeType          string            // If set, instances are probed by Robin itself (http|tcp)
+	ProbePath          string            // Path used for HTTP probes (can be empty)
+	ProbePort          int               // Port used for probes (0 means instance port)
+	ProbeInterval      string            // Interval between probes (can be empty)
+	Mode               string            // http|tcp|mail
+	Role               string            // If set, only instances with this role are primary servers (primary|replica)
+	InstanceMetadata   map[string]string // If set, only instances with all of this metadata are used
+	MetadataHeaders    map[string]string // Response headers (value) set to the metadata (key) of the serving instance
+	ZoneAware          bool              // If set, instances in the zone of the load-balancer are preferred
+	Sticky             bool              // Switched blancing mode to source
+	HashOn             string            // If set, requests are balanced on a consistent hash of this key (path|header:<name>|url-param:<name>|cookie:<name>|jwt-claim:<name>)
+	HashBalanceFactor  int               // If set, no server gets more than this percentage of the average load
+	SendProxy          bool              // If set, connections to the servers start with a PROXY protocol header
+	Grpc               bool              // If set, the service speaks gRPC (HTTP/2 to the servers)
+	Backup             bool              // If set all instances are backup only servers for their selectors
+	AllBackups         bool              // If set, all backup servers are used at once
+	MinActive          int               // If set, backups are promoted when fewer than this number of primary servers are up
+	MinInstances       int               // If set, the maintenance page is served when fewer than this number of healthy instances are available
+	MaxConn            int               // If set, the maximum number of concurrent connections per server
+	FullConn           int               // If set, the number of concurrent connections at which the backend is considered full
+	QueueTimeout       string            // If set, the maximum time a request is queued waiting for a server
+	ExternalHost       string            // If set, the instance is this external host, resolved by HAProxy (sent as Host header & SNI)
+	ExternalSsl        bool              // If set, connections to the (external) instances use SSL
+	Filters            []string          // Names of SPOE agents that requests & responses are passed through (in order)
+	LuaActions         []string          // Names of Lua actions that are applied to requests (in order)
+	Blocklists         []string          // Names of IP blocklists whose source IPs are refused
+	TrapPaths          []string          // Paths (prefixes) that are never requested legitimately
+	TrapAction         string            // tarpit|ban
+	BodyScan           BodyScan          // Scanning of request bodies
+	ConnectionMode     string            // How HTTP connections are handled: keep-alive|server-close|close|tunnel (empty means server-close)
+	ExposeSensitive    bool              // If set, sensitive paths are not protected
+	Tenant             string            // Tenant that owns the frontend records of this registration (empty for the default tenant)
+	TenantQuota        Quota             // If enabled, the traffic of all registrations of the tenant is limited
 }
 
 func (sr ServiceRegistration) Normalize() ServiceRegistration {
@@ -54,16 +108,61 @@ func (sr ServiceRegistration) Normalize() ServiceRegistration {
 }
 
 func (sr ServiceRegistration) FullString() string {
-	return fmt.Sprintf("%s-%d-%s-%s-%s-%s-%s-%v-%v",
+	return fmt.Sprintf("%s-%d-%s-%s-%s-%s-%s-%d-%s-%s-%s-%d-%s-%s-%s-%d-%s-%s-%v-%v-%v-%d-%d-%s-%s-%s-%v-%v-%v-%s-%d-%d-%d-%s-%d-%v-%s-%v-%s-%s-%s-%d-%d-%s-%s-%s-%s-%s-%v",
 		sr.ServiceName,
 		sr.ServicePort,
 		sr.Instances.FullString(),
 		sr.Selectors.FullString(),
 		sr.HttpCheckPath,
 		sr.HttpCheckMethod,
+		sr.HttpCheckHost,
+		sr.HttpCheckPort,
+		sr.HttpCheckInterval,
+		sr.TcpCheck,
+		sr.TcpCheckUser,
+		sr.AgentCheckPort,
+		sr.AgentCheckInterval,
+		sr.ProbeType,
+		sr.ProbePath,
+		sr.ProbePort,
+		sr.ProbeInterval,
 		sr.Mode,
 		sr.Sticky,
-		sr.Backup)
+		sr.Backup,
+		sr.AllBackups,
+		sr.MinActive,
+		sr.MinInstances,
+		sr.Role,
+		FormatMetadata(sr.InstanceMetadata),
+		FormatMetadata(sr.MetadataHeaders),
+		sr.SendProxy,
+		sr.Grpc,
+		sr.ZoneAware,
+		sr.HashOn,
+		sr.HashBalanceFactor,
+		sr.MaxConn,
+		sr.FullConn,
+		sr.QueueTimeout,
+		sr.EdgePort,
+		sr.Public,
+		sr.ExternalHost,
+		sr.ExternalSsl,
+		strings.Join(sr.Filters, ","),
+		strings.Join(sr.LuaActions, ","),
+		sr.Tenant,
+		sr.TenantQuota.RequestsPerDay,
+		sr.TenantQuota.BandwidthPerDay,
+		strings.Join(sr.Blocklists, ","),
+		strings.Join(sr.TrapPaths, ","),
+		sr.TrapAction,
+		sr.BodyScan,
+		sr.ConnectionMode,
+		sr.ExposeSensitive)
+}
+
+// HasHttpCheck returns true if any of the HTTP health check settings is set.
+func (sr ServiceRegistration) HasHttpCheck() bool {
+	return sr.HttpCheckPath != "" || sr.HttpCheckMethod != "" || sr.HttpCheckHost != "" || sr.HttpCheckPort != 0 || sr.HttpCheckInterval != ""
 }
 
 func (sr ServiceRegistration) IsHttp() bool {
@@ -74,6 +173,11 @@ func (sr ServiceRegistration) IsTcp() bool {
 	return sr.Mode == "tcp"
 }
 
+// IsMail returns true if the service is a mail (SMTP/IMAP/POP3) service.
+func (sr ServiceRegistration) IsMail() bool {
+	return sr.Mode == "mail"
+}
+
 type ServiceRegistrations []ServiceRegistration
 
 func (list ServiceRegistrations) Sort() {
@@ -84,6 +188,21 @@ func (list ServiceRegistrations) Sort() {
 	}
 }
 
+// Clone returns a copy of the list that can be sorted without changing the original.
+// The instances, selectors and users that Sort reorders are copied as well.
+func (list ServiceRegistrations) Clone() ServiceRegistrations {
+	result := make(ServiceRegistrations, 0, len(list))
+	for _, sr := range list {
+		sr.Instances = append(ServiceInstances{}, sr.Instances...)
+		sr.Selectors = append(ServiceSelectors{}, sr.Selectors...)
+		for i, sel := range sr.Selectors {
+			sr.Selectors[i].Users = append(Users{}, sel.Users...)
+		}
+		result = append(result, sr)
+	}
+	return result
+}
+
 func (list ServiceRegistrations) Contains(sr ServiceRegistration) bool {
 	key := sr.FullString()
 	for _, x := range list {
@@ -104,16 +223,113 @@ func (list ServiceRegistrations) FullString() string {
 }
 
 type ServiceInstance struct {
-	IP   string // IP address to connect to to reach the service instance
-	Port int    // Port to connect to to reach the service instance
+	IP       string            // IP address to connect to to reach the service instance
+	Port     int               // Port to connect to to reach the service instance
+	Backup   bool              // If set, this instance is a backup only server
+	Role     string            // Role of the instance (primary|replica), taken from its registration metadata
+	Metadata map[string]string // Metadata of the instance (e.g. node, zone, version)
+	Weight   int               // If set, the relative weight of the instance (1-256)
+	Draining bool              // If set, the instance has left the backend and only finishes its current connections
+	Health   string            // Health of the instance as reported by the backend (empty means healthy)
 }
 
 func (si ServiceInstance) FullString() string {
-	return fmt.Sprintf("%s-%d", si.IP, si.Port)
+	result := fmt.Sprintf("%s-%d", si.IP, si.Port)
+	if si.Backup {
+		result = result + "-backup"
+	}
+	if si.Role != "" {
+		result = result + "-" + si.Role
+	}
+	if len(si.Metadata) > 0 {
+		result = result + "-" + FormatMetadata(si.Metadata)
+	}
+	if si.Weight != 0 {
+		result = fmt.Sprintf("%s-w%d", result, si.Weight)
+	}
+	if si.Draining {
+		result = result + "-draining"
+	}
+	if si.Health != "" {
+		result = result + "-" + si.Health
+	}
+	return result
+}
+
+const (
+	HealthHealthy   = ""          // The instance receives traffic
+	HealthDraining  = "draining"  // The instance only finishes its current connections
+	HealthUnhealthy = "unhealthy" // The instance receives no traffic at all
+)
+
+// ParseInstanceHealth returns the health state for the given (reported) value.
+// Unknown values are considered healthy.
+func ParseInstanceHealth(value string) string {
+	switch strings.ToLower(value) {
+	case HealthDraining:
+		return HealthDraining
+	case HealthUnhealthy, "disabled", "maintenance":
+		return HealthUnhealthy
+	default:
+		return HealthHealthy
+	}
+}
+
+// IsDraining returns true if the instance must not receive new connections.
+func (si ServiceInstance) IsDraining() bool {
+	return si.Draining || si.Health == HealthDraining
+}
+
+// HasMetadata returns true if the instance has all of the given metadata.
+func (si ServiceInstance) HasMetadata(metadata map[string]string) bool {
+	for key, value := range metadata {
+		if si.Metadata[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatMetadata returns a normalized string representation of the given metadata.
+func FormatMetadata(metadata map[string]string) string {
+	list := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		list = append(list, key+"="+value)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+// Matches returns true if the given address (ip or ip:port) refers to this instance.
+func (si ServiceInstance) Matches(address string) bool {
+	if host, port, err := net.SplitHostPort(address); err == nil {
+		return host == si.IP && port == strconv.Itoa(si.Port)
+	}
+	return address == si.IP
 }
 
 type ServiceInstances []ServiceInstance
 
+// HasMetadata returns true if at least one of the instances has the given metadata value.
+func (list ServiceInstances) HasMetadata(key, value string) bool {
+	for _, si := range list {
+		if si.Metadata[key] == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains returns true if the given instance (ip:port) is part of the given list.
+func (list ServiceInstances) Contains(instance ServiceInstance) bool {
+	for _, si := range list {
+		if si.IP == instance.IP && si.Port == instance.Port {
+			return true
+		}
+	}
+	return false
+}
+
 func (list ServiceInstances) FullString() string {
 	slist := []string{}
 	for _, si := range list {
@@ -129,7 +345,7 @@ func (list ServiceInstances) Sort() {
 
 type ServiceSelector struct {
 	Weight            int    // How important is this selector. (0-100), 100 being most important
-	Domain            string // Domain to match on
+	Domain            string // Domain to match on (can be a wildcard domain: *.example.com)
 	SslCertName       string // SSL certificate filename
 	TmpSslCertPath    string // Path of generated certificate file
 	PathPrefix        string // Prefix of HTTP path to match on
@@ -137,6 +353,14 @@ type ServiceSelector struct {
 	AllowUnauthorized bool   // If set, allow all for this path
 	AllowInsecure     bool   // If set, allow insecure access to this path
 	RewriteRules      []RewriteRule
+	AnyOf             []Condition // If set, at least one of these conditions must match
+	NoneOf            []Condition // If set, none of these conditions may match
+	CanonicalHost     string      // If set, requests for another host are redirected to this host
+	RequestTimeout    string      // If set, overrides the server timeout of matching requests
+	Cache             Cache       // If enabled, responses to matching requests are cached
+	AuthAgent         string      // If set, matching requests must be allowed by this SPOE agent
+	Quota             Quota       // If enabled, the traffic per domain is limited
+	AllowedSources    []string    // If set, only requests from these IP addresses or CIDR ranges match
 }
 
 func (fs ServiceSelector) FullString() string {
@@ -149,7 +373,56 @@ func (fs ServiceSelector) FullString() string {
 	if fs.Domain == "" {
 		selectorRelevance += 100
 	}
-	return fmt.Sprintf("%03d-%03d-%s-%s-%s-%#v-%v-%v", (100 - fs.Weight), (1000 - selectorRelevance), fs.Domain, fs.SslCertName, fs.PathPrefix, users, fs.AllowUnauthorized, fs.AllowInsecure)
+	// Exact domains are more relevant than wildcard domains
+	wildcard := 0
+	if fs.IsWildcard() {
+		wildcard = 1
+	}
+	result := fmt.Sprintf("%03d-%03d-%d-%s-%s-%s-%#v-%v-%v", (100 - fs.Weight), (1000 - selectorRelevance), wildcard, fs.Domain, fs.SslCertName, fs.PathPrefix, users, fs.AllowUnauthorized, fs.AllowInsecure)
+	if len(fs.AnyOf) > 0 || len(fs.NoneOf) > 0 {
+		result = fmt.Sprintf("%s-%v-%v", result, fs.AnyOf, fs.NoneOf)
+	}
+	if fs.CanonicalHost != "" {
+		result = fmt.Sprintf("%s-canonical-%s", result, fs.CanonicalHost)
+	}
+	if fs.RequestTimeout != "" {
+		result = fmt.Sprintf("%s-timeout-%s", result, fs.RequestTimeout)
+	}
+	if fs.Cache.IsEnabled() {
+		result = fmt.Sprintf("%s-cache-%s", result, fs.Cache.Name())
+	}
+	if fs.AuthAgent != "" {
+		result = fmt.Sprintf("%s-auth-agent-%s", result, fs.AuthAgent)
+	}
+	if fs.Quota.IsEnabled() {
+		result = fmt.Sprintf("%s-quota-%d-%d", result, fs.Quota.RequestsPerDay, fs.Quota.BandwidthPerDay)
+	}
+	if len(fs.AllowedSources) > 0 {
+		result = fmt.Sprintf("%s-sources-%s", result, strings.Join(fs.AllowedSources, ","))
+	}
+	if fs.TmpSslCertPath != "" {
+		result = fmt.Sprintf("%s-tmpcert-%s", result, fs.TmpSslCertPath)
+	}
+	if len(fs.RewriteRules) > 0 {
+		result = fmt.Sprintf("%s-rewrite-%v", result, fs.RewriteRules)
+	}
+	return result
+}
+
+// HasConditions returns true if the selector has additional (any-of, none-of) conditions.
+func (fs ServiceSelector) HasConditions() bool {
+	return len(fs.AnyOf) > 0 || len(fs.NoneOf) > 0
+}
+
+// IsWildcard returns true if the domain of the selector is a wildcard domain.
+func (ss ServiceSelector) IsWildcard() bool {
+	return strings.HasPrefix(ss.Domain, api.WildcardDomainPrefix)
+}
+
+// DomainSuffix returns the suffix (including the leading '.') that hosts must have to
+// match a wildcard domain.
+func (ss ServiceSelector) DomainSuffix() string {
+	return strings.TrimPrefix(ss.Domain, "*")
 }
 
 func (ss ServiceSelector) IsSecure() bool {
@@ -188,6 +461,67 @@ type RewriteRule struct {
 	PathPrefix       string // Add this to the start of the request path.
 	RemovePathPrefix string // Remove this from the start of the request path.
 	Domain           string // Redirect to this domain
+	DropQuery        bool   // Remove the query string when redirecting to Domain
+	DropPath         bool   // Redirect to the root of Domain (instead of keeping the path & query string)
+}
+
+// Cache holds the settings of the cache used for responses of a selector.
+type Cache struct {
+	MaxAge        int  // Time (in seconds) responses are cached (0 means caching is disabled)
+	MaxObjectSize int  // If set, larger responses (in bytes) are not cached
+	Vary          bool // If set, a variant is cached per value of the headers in the Vary response header
+}
+
+// IsEnabled returns true if responses must be cached.
+func (c Cache) IsEnabled() bool {
+	return c.MaxAge > 0
+}
+
+// Name returns the name of the cache section that holds responses cached with these settings.
+func (c Cache) Name() string {
+	name := fmt.Sprintf("cache_%d_%d", c.MaxAge, c.MaxObjectSize)
+	if c.Vary {
+		name = name + "_vary"
+	}
+	return name
+}
+
+// Quota limits the traffic (in 24 hours) of a domain or tenant.
+type Quota struct {
+	RequestsPerDay  int   `json:"requests-per-day,omitempty"`  // If set, the maximum number of requests
+	BandwidthPerDay int64 `json:"bandwidth-per-day,omitempty"` // If set, the maximum number of response bytes
+}
+
+// IsEnabled returns true if the quota limits any traffic.
+func (q Quota) IsEnabled() bool {
+	return q.RequestsPerDay > 0 || q.BandwidthPerDay > 0
+}
+
+// BodyScan specifies how request bodies are scanned by a SPOE agent before they are forwarded.
+type BodyScan struct {
+	Agent       string // Name of the SPOE agent that scans bodies
+	MaxBodySize int    // If set, larger bodies (in bytes) are not scanned
+	FailOpen    bool   // If set, bodies that cannot be scanned are forwarded, otherwise refused
+}
+
+// IsEnabled returns true if bodies must be scanned.
+func (b BodyScan) IsEnabled() bool {
+	return b.Agent != ""
+}
+
+// String returns a unique (for the settings) string.
+func (b BodyScan) String() string {
+	if !b.IsEnabled() {
+		return ""
+	}
+	return fmt.Sprintf("%s/%d/%v", b.Agent, b.MaxBodySize, b.FailOpen)
+}
+
+// Condition is an additional condition of a selector.
+// If both domain and path-prefix are set, both must match.
+type Condition struct {
+	Domain     string // Domain (or wildcard domain) to match on
+	PathPrefix string // Prefix of HTTP path to match on
 }
 
 type User struct {
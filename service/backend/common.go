@@ -16,56 +16,95 @@ package backend
 
 import (
 	"fmt"
+	"net"
+	"strconv"
 
 	logging "github.com/op/go-logging"
 	regapi "github.com/pulcy/registrator-api"
 	"github.com/pulcy/robin-api"
 )
 
+const (
+	// instanceRoleTag is the tag of a registered instance that contains its role (primary|replica).
+	instanceRoleTag = regapi.TagRole
+	// instanceHealthTag is the tag of a registered instance that contains its health (healthy|draining|unhealthy).
+	instanceHealthTag = regapi.TagHealth
+	// instanceWeightTag is the tag of a registered instance that contains its relative weight (0-256).
+	instanceWeightTag = regapi.TagWeight
+
+	// Well known instance metadata keys
+	MetadataNode    = regapi.TagNode
+	MetadataZone    = regapi.TagZone
+	MetadataVersion = "version"
+)
+
 // mergeTrees merges the 2 trees into a single list of registrations.
 func mergeTrees(log *logging.Logger, config BackendConfig, services []regapi.Service, frontends []api.FrontendRecord) (ServiceRegistrations, error) {
+	// Drop records with unsafe values (they could have been stored without validation)
+	validFrontends := make([]api.FrontendRecord, 0, len(frontends))
+	for _, fr := range frontends {
+		if err := fr.Validate(); err != nil {
+			log.Warningf("Ignoring invalid frontend record for service '%s': %v", fr.Service, err)
+			continue
+		}
+		validFrontends = append(validFrontends, fr)
+	}
+	frontends = expandPrivateFrontends(validFrontends)
+	services = addStaticServices(log, services, frontends)
+	discovered := len(services)
+	services = addExternalServices(log, services, frontends)
+
 	result := ServiceRegistrations{}
-	for _, s := range services {
+	for i, s := range services {
+		external := i >= discovered
 		serviceName := s.ServiceName
 		servicePort := s.ServicePort
 
-		createServiceRegistration := func(edgePort int, public bool, mode string) *ServiceRegistration {
+		createServiceRegistration := func(edgePort int, public bool, mode, role string, metadata map[string]string, tenant string) *ServiceRegistration {
 			service := &ServiceRegistration{
-				ServiceName: serviceName,
-				ServicePort: servicePort,
-				EdgePort:    edgePort,
-				Public:      public,
-				Mode:        mode,
+				ServiceName:      serviceName,
+				ServicePort:      servicePort,
+				EdgePort:         edgePort,
+				Public:           public,
+				Mode:             mode,
+				Role:             role,
+				InstanceMetadata: metadata,
+				Tenant:           tenant,
 			}
 			for _, si := range s.Instances {
-				service.Instances = append(service.Instances, ServiceInstance{
-					IP:   si.IP,
-					Port: si.Port,
-				})
+				instance := newServiceInstance(si)
+				if !instance.HasMetadata(metadata) {
+					continue
+				}
+				switch role {
+				case api.RolePrimary:
+					if instance.Role != api.RolePrimary {
+						continue
+					}
+				case api.RoleReplica:
+					// Reads go to the primary only when no replica is available
+					if instance.Role == api.RolePrimary {
+						instance.Backup = true
+					} else if instance.Role != api.RoleReplica {
+						continue
+					}
+				}
+				service.Instances = append(service.Instances, instance)
 			}
-			log.Debugf("Created service '%s' edge-port=%d, public=%v, mode=%s", serviceName, edgePort, public, mode)
+			log.Debugf("Created service '%s' edge-port=%d, public=%v, mode=%s, role=%s", serviceName, edgePort, public, mode, role)
 			return service
 		}
 		servicesByEdge := make(map[string]*ServiceRegistration)
-		getServiceRegistration := func(edgePort int, private bool, mode string) *ServiceRegistration {
+		splitDone := make(map[*ServiceRegistration]bool)
+		getServiceRegistration := func(edgePort int, private bool, mode, role string, metadata map[string]string, tenant string) *ServiceRegistration {
 			if mode == "" {
 				mode = "http"
 			}
-			if edgePort == 0 {
-				if private {
-					if mode == "http" {
-						edgePort = config.PrivateHttpEdgePort
-					} else {
-						edgePort = config.PrivateTcpEdgePort
-					}
-				} else {
-					edgePort = config.PublicEdgePort
-				}
-			}
-			key := fmt.Sprintf("%d-%v", edgePort, private)
+			edgePort = config.edgePort(edgePort, private, mode)
+			key := fmt.Sprintf("%d-%v-%s-%s-%s", edgePort, private, role, FormatMetadata(metadata), tenant)
 			sr, ok := servicesByEdge[key]
 			if !ok {
-				sr = createServiceRegistration(edgePort, !private, mode)
+				sr = createServiceRegistration(edgePort, !private, mode, role, metadata, tenant)
 				servicesByEdge[key] = sr
 			} else {
 				if sr.Mode != mode {
@@ -76,6 +115,12 @@ func mergeTrees(log *logging.Logger, config BackendConfig, services []regapi.Ser
 		}
 
 		for _, fr := range frontends {
+			if fr.EdgeGroup != config.EdgeGroup {
+				continue
+			}
+			if (fr.ExternalURL != "") != external {
+				continue
+			}
 			frExtService := fmt.Sprintf("%s-%d", fr.Service, servicePort)
 			if serviceName != fr.Service && serviceName != frExtService {
 				continue
@@ -84,32 +129,182 @@ func mergeTrees(log *logging.Logger, config BackendConfig, services []regapi.Ser
 				if sel.ServicePort != 0 && sel.ServicePort != servicePort {
 					continue
 				}
-				service := getServiceRegistration(sel.FrontendPort, sel.Private, fr.Mode)
+				service := getServiceRegistration(sel.FrontendPort, sel.Private, fr.Mode, sel.Role, sel.InstanceMetadata, fr.Tenant)
+				if external {
+					service.ExternalHost, _, service.ExternalSsl, _ = api.ParseExternalURL(fr.ExternalURL)
+				}
 				if fr.HttpCheckPath != "" && service.HttpCheckPath == "" {
 					service.HttpCheckPath = fr.HttpCheckPath
 				}
 				if fr.HttpCheckMethod != "" && service.HttpCheckMethod == "" {
 					service.HttpCheckMethod = fr.HttpCheckMethod
 				}
+				if fr.HttpCheckHost != "" && service.HttpCheckHost == "" {
+					service.HttpCheckHost = fr.HttpCheckHost
+				}
+				if fr.HttpCheckPort != 0 && service.HttpCheckPort == 0 {
+					service.HttpCheckPort = fr.HttpCheckPort
+				}
+				if fr.HttpCheckInterval != "" && service.HttpCheckInterval == "" {
+					service.HttpCheckInterval = fr.HttpCheckInterval
+				}
+				if fr.TcpCheck != "" && service.TcpCheck == "" {
+					service.TcpCheck = fr.TcpCheck
+					service.TcpCheckUser = fr.TcpCheckUser
+				}
+				if fr.AgentCheckPort != 0 && service.AgentCheckPort == 0 {
+					service.AgentCheckPort = fr.AgentCheckPort
+					service.AgentCheckInterval = fr.AgentCheckInterval
+				}
+				if fr.ProbeType != "" && service.ProbeType == "" {
+					service.ProbeType = fr.ProbeType
+					service.ProbePath = fr.ProbePath
+					service.ProbePort = fr.ProbePort
+					service.ProbeInterval = fr.ProbeInterval
+				}
+				for key, header := range fr.MetadataHeaders {
+					if service.MetadataHeaders == nil {
+						service.MetadataHeaders = make(map[string]string)
+					}
+					if _, found := service.MetadataHeaders[key]; !found {
+						service.MetadataHeaders[key] = header
+					}
+				}
+				for _, name := range fr.Filters {
+					if !containsString(service.Filters, name) {
+						service.Filters = append(service.Filters, name)
+					}
+				}
+				for _, name := range fr.LuaActions {
+					if !containsString(service.LuaActions, name) {
+						service.LuaActions = append(service.LuaActions, name)
+					}
+				}
+				for _, name := range fr.Blocklists {
+					if !containsString(service.Blocklists, name) {
+						service.Blocklists = append(service.Blocklists, name)
+					}
+				}
+				for _, path := range fr.TrapPaths {
+					if !containsString(service.TrapPaths, path) {
+						service.TrapPaths = append(service.TrapPaths, path)
+					}
+				}
+				if fr.TrapAction == api.TrapActionBan || service.TrapAction == "" {
+					service.TrapAction = fr.TrapAction
+				}
+				if fr.BodyScan != nil && !service.BodyScan.IsEnabled() {
+					service.BodyScan = BodyScan{
+						Agent:       fr.BodyScan.Agent,
+						MaxBodySize: fr.BodyScan.MaxBodySize,
+						FailOpen:    fr.BodyScan.FailOpen,
+					}
+				}
+				if fr.ConnectionMode != "" && service.ConnectionMode == "" {
+					service.ConnectionMode = fr.ConnectionMode
+				}
+				if fr.ExposeSensitive {
+					service.ExposeSensitive = true
+				}
 				if fr.Sticky {
 					service.Sticky = true
 				}
+				if fr.HashOn != "" && service.HashOn == "" {
+					service.HashOn = fr.HashOn
+					service.HashBalanceFactor = fr.HashBalanceFactor
+				}
+				if fr.SendProxy {
+					service.SendProxy = true
+				}
+				if fr.Grpc {
+					service.Grpc = true
+				}
+				if fr.ZoneAware {
+					service.ZoneAware = true
+				}
 				if fr.Backup {
 					service.Backup = true
 				}
+				for _, address := range fr.StaticInstances {
+					instance, err := staticInstance(address, servicePort)
+					if err != nil {
+						log.Warningf("Ignoring static instance '%s' of service '%s': %v", address, fr.Service, err)
+						continue
+					}
+					if !service.Instances.Contains(instance) {
+						service.Instances = append(service.Instances, instance)
+					}
+				}
+				for i, si := range service.Instances {
+					for _, address := range fr.BackupInstances {
+						if si.Matches(address) {
+							service.Instances[i].Backup = true
+						}
+					}
+					if weight, ok := fr.InstanceWeight(si.IP, si.Port); ok {
+						service.Instances[i].setWeight(weight)
+					}
+				}
+				if len(fr.Split) > 0 && !splitDone[service] {
+					splitDone[service] = true
+					applySplit(log, service, fr.Split, services)
+				}
+				if fr.AllBackups {
+					service.AllBackups = true
+				}
+				if fr.MinActive > service.MinActive {
+					service.MinActive = fr.MinActive
+				}
+				if fr.MinInstances > service.MinInstances {
+					service.MinInstances = fr.MinInstances
+				}
+				if fr.MaxConn != 0 && (service.MaxConn == 0 || fr.MaxConn < service.MaxConn) {
+					service.MaxConn = fr.MaxConn
+				}
+				if fr.FullConn > service.FullConn {
+					service.FullConn = fr.FullConn
+				}
+				if fr.QueueTimeout != "" && service.QueueTimeout == "" {
+					service.QueueTimeout = fr.QueueTimeout
+				}
 				srSel := ServiceSelector{
-					Weight:      sel.Weight,
-					Domain:      sel.Domain,
-					SslCertName: sel.SslCert,
-					PathPrefix:  sel.PathPrefix,
+					Weight:         sel.Weight,
+					Domain:         sel.Domain,
+					SslCertName:    sel.SslCert,
+					PathPrefix:     sel.PathPrefix,
+					CanonicalHost:  sel.CanonicalHost,
+					RequestTimeout: sel.RequestTimeout,
+					AuthAgent:      sel.AuthAgent,
+					AllowedSources: sel.AllowedSources,
+				}
+				if sel.Cache != nil {
+					srSel.Cache = Cache{
+						MaxAge:        sel.Cache.MaxAge,
+						MaxObjectSize: sel.Cache.MaxObjectSize,
+						Vary:          sel.Cache.Vary,
+					}
+				}
+				if sel.Quota != nil {
+					srSel.Quota = Quota{
+						RequestsPerDay:  sel.Quota.RequestsPerDay,
+						BandwidthPerDay: sel.Quota.BandwidthPerDay,
+					}
 				}
 				for _, rwRule := range sel.RewriteRules {
 					srSel.RewriteRules = append(srSel.RewriteRules, RewriteRule{
 						PathPrefix:       rwRule.PathPrefix,
 						RemovePathPrefix: rwRule.RemovePathPrefix,
 						Domain:           rwRule.Domain,
+						DropQuery:        rwRule.DropQuery,
+						DropPath:         rwRule.DropPath,
 					})
 				}
+				for _, c := range sel.AnyOf {
+					srSel.AnyOf = append(srSel.AnyOf, Condition{Domain: c.Domain, PathPrefix: c.PathPrefix})
+				}
+				for _, c := range sel.NoneOf {
+					srSel.NoneOf = append(srSel.NoneOf, Condition{Domain: c.Domain, PathPrefix: c.PathPrefix})
+				}
 				for _, user := range sel.Users {
 					srSel.Users = append(srSel.Users, User{
 						Name:         user.Name,
@@ -130,3 +325,240 @@ func mergeTrees(log *logging.Logger, config BackendConfig, services []regapi.Ser
 	}
 	return result, nil
 }
+
+// addStaticServices adds a service (without discovered instances) for every frontend
+// that has static instances, but no discovered service.
+func addStaticServices(log *logging.Logger, services []regapi.Service, frontends []api.FrontendRecord) []regapi.Service {
+	for _, fr := range frontends {
+		if len(fr.StaticInstances) == 0 {
+			continue
+		}
+		found := false
+		for _, s := range services {
+			if s.ServiceName == fr.Service || s.ServiceName == fmt.Sprintf("%s-%d", fr.Service, s.ServicePort) {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		port := 0
+		for _, sel := range fr.Selectors {
+			if sel.ServicePort != 0 {
+				port = sel.ServicePort
+				break
+			}
+		}
+		if port == 0 {
+			for _, address := range fr.StaticInstances {
+				if instance, err := staticInstance(address, 0); err == nil && instance.Port != 0 {
+					port = instance.Port
+					break
+				}
+			}
+		}
+		if port == 0 {
+			log.Warningf("Cannot determine port of static only service '%s'", fr.Service)
+			continue
+		}
+		services = append(services, regapi.Service{ServiceName: fr.Service, ServicePort: port})
+	}
+	return services
+}
+
+// staticInstance parses the given static instance address (ip or ip:port).
+// If the address has no port, the given default port is used.
+func staticInstance(address string, defaultPort int) (ServiceInstance, error) {
+	host, port := address, defaultPort
+	if h, p, err := net.SplitHostPort(address); err == nil {
+		port, err = strconv.Atoi(p)
+		if err != nil {
+			return ServiceInstance{}, maskAny(err)
+		}
+		host = h
+	}
+	return ServiceInstance{IP: host, Port: port}, nil
+}
+
+// addExternalServices adds a service for every frontend with an external URL.
+// The only instance of such a service is the host of that URL, which is resolved by HAProxy.
+func addExternalServices(log *logging.Logger, services []regapi.Service, frontends []api.FrontendRecord) []regapi.Service {
+	for _, fr := range frontends {
+		if fr.ExternalURL == "" {
+			continue
+		}
+		host, port, _, err := api.ParseExternalURL(fr.ExternalURL)
+		if err != nil {
+			log.Warningf("Ignoring external-url of service '%s': %v", fr.Service, err)
+			continue
+		}
+		services = append(services, regapi.Service{
+			ServiceName: fr.Service,
+			ServicePort: port,
+			Instances:   []regapi.ServiceInstance{regapi.ServiceInstance{IP: host, Port: port}},
+		})
+	}
+	return services
+}
+
+// containsString returns true if the given list contains the given value.
+func containsString(list []string, value string) bool {
+	for _, x := range list {
+		if x == value {
+			return true
+		}
+	}
+	return false
+}
+
+// expandPrivateFrontends replaces the records with a private section by a record for that section,
+// followed by the record itself (without the section).
+// The private record goes first, so its health checks are used by the private registration
+// even when the record itself contains private selectors as well.
+func expandPrivateFrontends(frontends []api.FrontendRecord) []api.FrontendRecord {
+	result := make([]api.FrontendRecord, 0, len(frontends))
+	for _, fr := range frontends {
+		if private, ok := fr.PrivateFrontend(); ok {
+			result = append(result, private)
+			fr.Private = nil
+		}
+		result = append(result, fr)
+	}
+	return result
+}
+
+// newServiceInstance creates an instance from the given registered instance.
+func newServiceInstance(si regapi.ServiceInstance) ServiceInstance {
+	instance := ServiceInstance{
+		IP:     si.IP,
+		Port:   si.Port,
+		Role:   si.Tags[instanceRoleTag],
+		Health: ParseInstanceHealth(si.Tags[instanceHealthTag]),
+	}
+	if weight, err := strconv.Atoi(si.Tags[instanceWeightTag]); err == nil && weight >= 0 && weight <= api.MaxInstanceWeight {
+		instance.setWeight(weight)
+	}
+	for key, value := range si.Tags {
+		if key == instanceRoleTag || key == instanceHealthTag || key == instanceWeightTag {
+			continue
+		}
+		if instance.Metadata == nil {
+			instance.Metadata = make(map[string]string)
+		}
+		instance.Metadata[key] = value
+	}
+	return instance
+}
+
+// setWeight sets the relative weight (0-256) of the instance.
+// Weight 0 puts the instance in drain state.
+func (si *ServiceInstance) setWeight(weight int) {
+	if weight == 0 {
+		si.Weight = 0
+		si.Health = HealthDraining
+	} else {
+		si.Weight = weight
+	}
+}
+
+// applySplit adds the instances of the services in the given split to the given registration.
+// The instances are weighted such that every service receives its percentage of the traffic,
+// the instances of the registration itself receive the remainder.
+// Within a service, the traffic is divided according to the weights of its instances.
+func applySplit(log *logging.Logger, service *ServiceRegistration, split []api.SplitRecord, services []regapi.Service) {
+	remainder := 100
+	for _, sr := range split {
+		remainder -= sr.Weight
+	}
+	var instances ServiceInstances
+	var shares []float64
+	if remainder > 0 {
+		instances = append(instances, service.Instances...)
+		shares = append(shares, splitShares(remainder, service.Instances)...)
+	}
+	splitInstances := 0
+	for _, sr := range split {
+		var target *regapi.Service
+		for i, s := range services {
+			if (sr.Port == 0 || sr.Port == s.ServicePort) && (s.ServiceName == sr.Service || s.ServiceName == fmt.Sprintf("%s-%d", sr.Service, s.ServicePort)) {
+				target = &services[i]
+				break
+			}
+		}
+		if target == nil || len(target.Instances) == 0 {
+			log.Warningf("Split target '%s' of service '%s' has no instances", sr.Service, service.ServiceName)
+			continue
+		}
+		var targetInstances ServiceInstances
+		for _, si := range target.Instances {
+			instance := newServiceInstance(si)
+			if instances.Contains(instance) || targetInstances.Contains(instance) {
+				continue
+			}
+			targetInstances = append(targetInstances, instance)
+		}
+		instances = append(instances, targetInstances...)
+		shares = append(shares, splitShares(sr.Weight, targetInstances)...)
+		splitInstances += len(targetInstances)
+	}
+	if splitInstances == 0 {
+		return
+	}
+	for i, weight := range splitWeights(shares) {
+		if !instances[i].IsDraining() {
+			instances[i].Weight = weight
+		}
+	}
+	service.Instances = instances
+}
+
+// splitShares divides the given percentage of the traffic over the given instances,
+// according to their own weights. Instances without a weight count as weight 1,
+// draining instances get no share.
+func splitShares(percentage int, instances ServiceInstances) []float64 {
+	weights := make([]float64, len(instances))
+	total := 0.0
+	for i, instance := range instances {
+		if instance.IsDraining() {
+			continue
+		} else if instance.Weight == 0 {
+			weights[i] = 1
+		} else {
+			weights[i] = float64(instance.Weight)
+		}
+		total += weights[i]
+	}
+	shares := make([]float64, len(instances))
+	if total > 0 {
+		for i, weight := range weights {
+			shares[i] = float64(percentage) * weight / total
+		}
+	}
+	return shares
+}
+
+// splitWeights returns the weights (1-256) of instances that each receive the given share of the traffic.
+// The weights are computed over all instances of the backend, such that the instance with the largest
+// share gets the maximum weight. This keeps the rounding error of small shares as small as possible.
+// Shares smaller than 1/256 of the largest share cannot be expressed and get weight 1.
+func splitWeights(shares []float64) []int {
+	max := 0.0
+	for _, share := range shares {
+		if share > max {
+			max = share
+		}
+	}
+	weights := make([]int, len(shares))
+	for i, share := range shares {
+		weight := 1
+		if max > 0 {
+			weight = int(share*float64(api.MaxInstanceWeight)/max + 0.5)
+		}
+		if weight < 1 {
+			weight = 1
+		}
+		weights[i] = weight
+	}
+	return weights
+}
@@ -0,0 +1,500 @@
+package backend
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"testing"
+
+	logging "github.com/op/go-logging"
+	regapi "github.com/pulcy/registrator-api"
+	api "github.com/pulcy/robin-api"
+)
+
+func TestMergeTreesRoles(t *testing.T) {
+	services := []regapi.Service{
+		regapi.Service{
+			ServiceName: "db",
+			ServicePort: 5432,
+			Instances: []regapi.ServiceInstance{
+				regapi.ServiceInstance{IP: "10.0.0.1", Port: 5432, Tags: map[string]string{"role": "primary"}},
+				regapi.ServiceInstance{IP: "10.0.0.2", Port: 5432, Tags: map[string]string{"role": "replica"}},
+				regapi.ServiceInstance{IP: "10.0.0.3", Port: 5432, Tags: map[string]string{"role": "replica"}},
+			},
+		},
+	}
+	frontends := []api.FrontendRecord{
+		api.FrontendRecord{
+			Service: "db",
+			Mode:    "tcp",
+			Selectors: []api.FrontendSelectorRecord{
+				api.FrontendSelectorRecord{FrontendPort: 5432, Private: true, Role: api.RolePrimary},
+				api.FrontendSelectorRecord{FrontendPort: 5433, Private: true, Role: api.RoleReplica},
+			},
+		},
+	}
+	result, err := mergeTrees(logging.MustGetLogger("test"), k8sTestConfig, services, frontends)
+	if err != nil {
+		t.Fatalf("mergeTrees failed: %#v", err)
+	}
+	result.Sort()
+	expected := map[int]string{
+		5432: "[10.0.0.1-5432-primary]",
+		5433: "[10.0.0.1-5432-backup-primary,10.0.0.2-5432-replica,10.0.0.3-5432-replica]",
+	}
+	if len(result) != len(expected) {
+		t.Fatalf("Expected %d registrations, got %d", len(expected), len(result))
+	}
+	for _, sr := range result {
+		if got := sr.Instances.FullString(); got != expected[sr.EdgePort] {
+			t.Errorf("Edge port %d: expected instances %s, got %s", sr.EdgePort, expected[sr.EdgePort], got)
+		}
+	}
+}
+
+func TestMergeTreesHealth(t *testing.T) {
+	services := []regapi.Service{
+		regapi.Service{
+			ServiceName: "web",
+			ServicePort: 80,
+			Instances: []regapi.ServiceInstance{
+				regapi.ServiceInstance{IP: "10.0.0.1", Port: 80, Tags: map[string]string{"weight": "20"}},
+				regapi.ServiceInstance{IP: "10.0.0.2", Port: 80, Tags: map[string]string{"health": "draining"}},
+				regapi.ServiceInstance{IP: "10.0.0.3", Port: 80, Tags: map[string]string{"health": "unhealthy"}},
+				regapi.ServiceInstance{IP: "10.0.0.4", Port: 80, Tags: map[string]string{"weight": "0"}},
+			},
+		},
+	}
+	frontends := []api.FrontendRecord{
+		api.FrontendRecord{
+			Service: "web",
+			Selectors: []api.FrontendSelectorRecord{
+				api.FrontendSelectorRecord{Domain: "foo.com"},
+			},
+		},
+	}
+	result, err := mergeTrees(logging.MustGetLogger("test"), k8sTestConfig, services, frontends)
+	if err != nil {
+		t.Fatalf("mergeTrees failed: %#v", err)
+	}
+	if len(result) != 1 {
+		t.Fatalf("Expected 1 registration, got %d", len(result))
+	}
+	result.Sort()
+	expected := "[10.0.0.1-80-w20,10.0.0.2-80-draining,10.0.0.3-80-unhealthy,10.0.0.4-80-draining]"
+	if got := result[0].Instances.FullString(); got != expected {
+		t.Errorf("Expected instances %s, got %s", expected, got)
+	}
+}
+
+func TestMergeTreesPrivateSection(t *testing.T) {
+	services := []regapi.Service{
+		regapi.Service{
+			ServiceName: "web",
+			ServicePort: 80,
+			Instances: []regapi.ServiceInstance{
+				regapi.ServiceInstance{IP: "10.0.0.1", Port: 80},
+			},
+		},
+	}
+	frontends := []api.FrontendRecord{
+		api.FrontendRecord{
+			Service:       "web",
+			HttpCheckPath: "/health",
+			Selectors: []api.FrontendSelectorRecord{
+				api.FrontendSelectorRecord{Domain: "foo.com"},
+				api.FrontendSelectorRecord{Domain: "legacy.private", Private: true},
+			},
+			Private: &api.PrivateFrontendRecord{
+				HttpCheckPath: "/internal",
+				Selectors: []api.FrontendSelectorRecord{
+					api.FrontendSelectorRecord{Domain: "web.private"},
+					api.FrontendSelectorRecord{Domain: "admin.private", FrontendPort: 8443, SslCert: "admin.pem"},
+				},
+			},
+		},
+	}
+	result, err := mergeTrees(logging.MustGetLogger("test"), k8sTestConfig, services, frontends)
+	if err != nil {
+		t.Fatalf("mergeTrees failed: %#v", err)
+	}
+	if len(result) != 3 {
+		t.Fatalf("Expected 3 registrations, got %d", len(result))
+	}
+	result.Sort()
+	for _, sr := range result {
+		var expectedCheck, expectedSelectors string
+		switch {
+		case sr.Public:
+			expectedCheck, expectedSelectors = "/health", "foo.com"
+		case sr.EdgePort == 8443:
+			expectedCheck, expectedSelectors = "/internal", "admin.private"
+		default:
+			expectedCheck, expectedSelectors = "/internal", "legacy.private,web.private"
+		}
+		var domains []string
+		for _, sel := range sr.Selectors {
+			domains = append(domains, sel.Domain)
+		}
+		sort.Strings(domains)
+		if sr.HttpCheckPath != expectedCheck {
+			t.Errorf("Expected http-check-path %s on edge-port %d, got %s", expectedCheck, sr.EdgePort, sr.HttpCheckPath)
+		}
+		if got := strings.Join(domains, ","); got != expectedSelectors {
+			t.Errorf("Expected selectors %s on edge-port %d, got %s", expectedSelectors, sr.EdgePort, got)
+		}
+	}
+}
+
+func TestMergeTreesStaticInstances(t *testing.T) {
+	services := []regapi.Service{
+		regapi.Service{
+			ServiceName: "web",
+			ServicePort: 80,
+			Instances: []regapi.ServiceInstance{
+				regapi.ServiceInstance{IP: "10.0.0.1", Port: 8080},
+			},
+		},
+	}
+	frontends := []api.FrontendRecord{
+		api.FrontendRecord{
+			Service:         "web",
+			StaticInstances: []string{"192.168.1.1", "192.168.1.2:8000", "10.0.0.1:8080"},
+			BackupInstances: []string{"192.168.1.2:8000"},
+			Selectors: []api.FrontendSelectorRecord{
+				api.FrontendSelectorRecord{Domain: "web.com"},
+			},
+		},
+		api.FrontendRecord{
+			Service:         "legacy",
+			StaticInstances: []string{"192.168.2.1:9000"},
+			Selectors: []api.FrontendSelectorRecord{
+				api.FrontendSelectorRecord{Domain: "legacy.com"},
+			},
+		},
+	}
+	result, err := mergeTrees(logging.MustGetLogger("test"), k8sTestConfig, services, frontends)
+	if err != nil {
+		t.Fatalf("mergeTrees failed: %#v", err)
+	}
+	result.Sort()
+	expected := map[string]string{
+		"legacy": "[192.168.2.1-9000]",
+		"web":    "[10.0.0.1-8080,192.168.1.1-80,192.168.1.2-8000-backup]",
+	}
+	if len(result) != len(expected) {
+		t.Fatalf("Expected %d registrations, got %d", len(expected), len(result))
+	}
+	for _, sr := range result {
+		sr.Instances.Sort()
+		if got := sr.Instances.FullString(); got != expected[sr.ServiceName] {
+			t.Errorf("Service %s: expected instances %s, got %s", sr.ServiceName, expected[sr.ServiceName], got)
+		}
+	}
+}
+
+func TestMergeTreesInstanceWeights(t *testing.T) {
+	services := []regapi.Service{
+		regapi.Service{
+			ServiceName: "web",
+			ServicePort: 80,
+			Instances: []regapi.ServiceInstance{
+				regapi.ServiceInstance{IP: "10.0.0.1", Port: 8080},
+				regapi.ServiceInstance{IP: "10.0.0.2", Port: 8080},
+				regapi.ServiceInstance{IP: "10.0.0.2", Port: 8081},
+				regapi.ServiceInstance{IP: "10.0.0.3", Port: 8080, Tags: map[string]string{instanceWeightTag: "50"}},
+			},
+		},
+	}
+	frontends := []api.FrontendRecord{
+		api.FrontendRecord{
+			Service:         "web",
+			InstanceWeights: map[string]int{"10.0.0.1": 0, "10.0.0.2": 10, "10.0.0.2:8081": 200},
+			Selectors: []api.FrontendSelectorRecord{
+				api.FrontendSelectorRecord{Domain: "web.com"},
+			},
+		},
+	}
+	result, err := mergeTrees(logging.MustGetLogger("test"), k8sTestConfig, services, frontends)
+	if err != nil {
+		t.Fatalf("mergeTrees failed: %#v", err)
+	}
+	if len(result) != 1 {
+		t.Fatalf("Expected 1 registration, got %d", len(result))
+	}
+	result[0].Instances.Sort()
+	expected := "[10.0.0.1-8080-draining,10.0.0.2-8080-w10,10.0.0.2-8081-w200,10.0.0.3-8080-w50]"
+	if got := result[0].Instances.FullString(); got != expected {
+		t.Errorf("Expected instances %s, got %s", expected, got)
+	}
+}
+
+func TestMergeTreesExternalURL(t *testing.T) {
+	frontends := []api.FrontendRecord{
+		api.FrontendRecord{
+			Service:     "docs",
+			ExternalURL: "https://docs.example-saas.com",
+			Selectors: []api.FrontendSelectorRecord{
+				api.FrontendSelectorRecord{Domain: "docs.foo.com"},
+			},
+		},
+	}
+	result, err := mergeTrees(logging.MustGetLogger("test"), k8sTestConfig, nil, frontends)
+	if err != nil {
+		t.Fatalf("mergeTrees failed: %#v", err)
+	}
+	if len(result) != 1 {
+		t.Fatalf("Expected 1 registration, got %d", len(result))
+	}
+	sr := result[0]
+	if sr.ExternalHost != "docs.example-saas.com" || !sr.ExternalSsl || sr.ServicePort != 443 {
+		t.Errorf("Unexpected external settings: host=%s, ssl=%v, port=%d", sr.ExternalHost, sr.ExternalSsl, sr.ServicePort)
+	}
+	if got, expected := sr.Instances.FullString(), "[docs.example-saas.com-443]"; got != expected {
+		t.Errorf("Expected instances %s, got %s", expected, got)
+	}
+}
+
+func TestMergeTreesEdgeGroup(t *testing.T) {
+	services := []regapi.Service{
+		regapi.Service{
+			ServiceName: "web",
+			ServicePort: 8080,
+			Instances: []regapi.ServiceInstance{
+				regapi.ServiceInstance{IP: "10.0.0.1", Port: 8080},
+			},
+		},
+	}
+	frontends := []api.FrontendRecord{
+		api.FrontendRecord{
+			Service: "web",
+			Selectors: []api.FrontendSelectorRecord{
+				api.FrontendSelectorRecord{Domain: "foo.com"},
+			},
+		},
+		api.FrontendRecord{
+			Service:   "web",
+			EdgeGroup: "internal",
+			Selectors: []api.FrontendSelectorRecord{
+				api.FrontendSelectorRecord{Domain: "internal.foo.com"},
+			},
+		},
+	}
+	tests := map[string]string{
+		"":         "foo.com",
+		"internal": "internal.foo.com",
+		"other":    "",
+	}
+	for edgeGroup, expected := range tests {
+		config := k8sTestConfig
+		config.EdgeGroup = edgeGroup
+		result, err := mergeTrees(logging.MustGetLogger("test"), config, services, frontends)
+		if err != nil {
+			t.Fatalf("mergeTrees failed: %#v", err)
+		}
+		var domains []string
+		for _, sr := range result {
+			for _, sel := range sr.Selectors {
+				domains = append(domains, sel.Domain)
+			}
+		}
+		if got := strings.Join(domains, ","); got != expected {
+			t.Errorf("Edge group '%s': expected domains '%s', got '%s'", edgeGroup, expected, got)
+		}
+	}
+}
+
+func TestMergeTreesSplit(t *testing.T) {
+	services := []regapi.Service{
+		regapi.Service{
+			ServiceName: "web",
+			ServicePort: 80,
+			Instances: []regapi.ServiceInstance{
+				regapi.ServiceInstance{IP: "10.0.0.1", Port: 8080},
+				regapi.ServiceInstance{IP: "10.0.0.2", Port: 8080},
+				regapi.ServiceInstance{IP: "10.0.0.3", Port: 8080},
+			},
+		},
+		regapi.Service{
+			ServiceName: "web-canary",
+			ServicePort: 80,
+			Instances: []regapi.ServiceInstance{
+				regapi.ServiceInstance{IP: "10.0.1.1", Port: 8080},
+			},
+		},
+	}
+	frontends := []api.FrontendRecord{
+		api.FrontendRecord{
+			Service: "web",
+			Split: []api.SplitRecord{
+				api.SplitRecord{Service: "web-canary", Weight: 10},
+			},
+			Selectors: []api.FrontendSelectorRecord{
+				api.FrontendSelectorRecord{Domain: "web.com"},
+			},
+		},
+	}
+	result, err := mergeTrees(logging.MustGetLogger("test"), k8sTestConfig, services, frontends)
+	if err != nil {
+		t.Fatalf("mergeTrees failed: %#v", err)
+	}
+	if len(result) != 1 {
+		t.Fatalf("Expected 1 registration, got %d", len(result))
+	}
+	expected := "[10.0.0.1-8080-w256,10.0.0.2-8080-w256,10.0.0.3-8080-w256,10.0.1.1-8080-w85]"
+	if got := result[0].Instances.FullString(); got != expected {
+		t.Errorf("Expected instances %s, got %s", expected, got)
+	}
+}
+
+func TestMergeTreesSplitInstanceWeights(t *testing.T) {
+	services := []regapi.Service{
+		regapi.Service{
+			ServiceName: "web",
+			ServicePort: 80,
+			Instances: []regapi.ServiceInstance{
+				regapi.ServiceInstance{IP: "10.0.0.1", Port: 8080},
+				regapi.ServiceInstance{IP: "10.0.0.2", Port: 8080},
+				regapi.ServiceInstance{IP: "10.0.0.3", Port: 8080},
+			},
+		},
+		regapi.Service{
+			ServiceName: "web-canary",
+			ServicePort: 80,
+			Instances: []regapi.ServiceInstance{
+				regapi.ServiceInstance{IP: "10.0.1.1", Port: 8080},
+				regapi.ServiceInstance{IP: "10.0.1.2", Port: 8080, Tags: map[string]string{regapi.TagWeight: "3"}},
+			},
+		},
+	}
+	frontends := []api.FrontendRecord{
+		api.FrontendRecord{
+			Service: "web",
+			Split: []api.SplitRecord{
+				api.SplitRecord{Service: "web-canary", Weight: 20},
+			},
+			InstanceWeights: map[string]int{
+				"10.0.0.1": 3,
+				"10.0.0.3": 0,
+			},
+			Selectors: []api.FrontendSelectorRecord{
+				api.FrontendSelectorRecord{Domain: "web.com"},
+			},
+		},
+	}
+	result, err := mergeTrees(logging.MustGetLogger("test"), k8sTestConfig, services, frontends)
+	if err != nil {
+		t.Fatalf("mergeTrees failed: %#v", err)
+	}
+	if len(result) != 1 {
+		t.Fatalf("Expected 1 registration, got %d", len(result))
+	}
+	// web: 80% divided 3:1 (10.0.0.3 is draining), web-canary: 20% divided 1:3
+	expected := "[10.0.0.1-8080-w256,10.0.0.2-8080-w85,10.0.0.3-8080-draining,10.0.1.1-8080-w21,10.0.1.2-8080-w64]"
+	if got := result[0].Instances.FullString(); got != expected {
+		t.Errorf("Expected instances %s, got %s", expected, got)
+	}
+}
+
+func TestSplitWeights(t *testing.T) {
+	tests := []struct {
+		Percentages []int // Percentage of every group
+		Instances   []int // Number of instances of every group
+	}{
+		{[]int{99, 1}, []int{1, 1}},
+		{[]int{99, 1}, []int{10, 1}},
+		{[]int{95, 5}, []int{3, 1}},
+		{[]int{90, 10}, []int{3, 2}},
+		{[]int{50, 49, 1}, []int{2, 2, 1}},
+	}
+	for _, test := range tests {
+		var shares []float64
+		for i, percentage := range test.Percentages {
+			for j := 0; j < test.Instances[i]; j++ {
+				shares = append(shares, float64(percentage)/float64(test.Instances[i]))
+			}
+		}
+		weights := splitWeights(shares)
+		total := 0
+		for _, w := range weights {
+			if w < 1 || w > api.MaxInstanceWeight {
+				t.Errorf("Weight %d of %v out of range", w, test)
+			}
+			total += w
+		}
+		offset := 0
+		for i, percentage := range test.Percentages {
+			groupWeight := 0
+			for _, w := range weights[offset : offset+test.Instances[i]] {
+				groupWeight += w
+			}
+			offset += test.Instances[i]
+			if actual := float64(groupWeight) * 100 / float64(total); math.Abs(actual-float64(percentage)) > 0.5 {
+				t.Errorf("Expected %d%% of traffic in group %d of %v, got %.2f%% (weights %v)", percentage, i, test, actual, weights)
+			}
+		}
+	}
+}
+
+func TestMergeTreesTenants(t *testing.T) {
+	services := []regapi.Service{
+		regapi.Service{
+			ServiceName: "web",
+			ServicePort: 80,
+			Instances: []regapi.ServiceInstance{
+				regapi.ServiceInstance{IP: "10.0.0.1", Port: 8080},
+			},
+		},
+	}
+	frontends := []api.FrontendRecord{
+		api.FrontendRecord{
+			Service: "web",
+			Selectors: []api.FrontendSelectorRecord{
+				api.FrontendSelectorRecord{Domain: "web.com"},
+			},
+		},
+		api.FrontendRecord{
+			Service: "web",
+			Tenant:  "acme",
+			Selectors: []api.FrontendSelectorRecord{
+				api.FrontendSelectorRecord{Domain: "acme.com"},
+			},
+		},
+	}
+	result, err := mergeTrees(logging.MustGetLogger("test"), k8sTestConfig, services, frontends)
+	if err != nil {
+		t.Fatalf("mergeTrees failed: %#v", err)
+	}
+	expected := map[string]string{
+		"":     "web.com",
+		"acme": "acme.com",
+	}
+	if len(result) != len(expected) {
+		t.Fatalf("Expected %d registrations, got %d", len(expected), len(result))
+	}
+	for _, sr := range result {
+		if len(sr.Selectors) != 1 || sr.Selectors[0].Domain != expected[sr.Tenant] {
+			t.Errorf("Tenant '%s': expected domain %s, got %#v", sr.Tenant, expected[sr.Tenant], sr.Selectors)
+		}
+	}
+}
+
+func TestTenantCheckLimits(t *testing.T) {
+	tenant := Tenant{Name: "acme", Tokens: []string{"secret"}, MaxDomains: 2, MaxFrontends: 2}
+	record := func(domains ...string) api.FrontendRecord {
+		r := api.FrontendRecord{Service: "web"}
+		for _, d := range domains {
+			r.Selectors = append(r.Selectors, api.FrontendSelectorRecord{Domain: d})
+		}
+		return r
+	}
+	if err := tenant.CheckLimits(map[string]api.FrontendRecord{"a": record("a.com"), "b": record("a.com", "b.com")}); err != nil {
+		t.Errorf("Expected records within limits, got %v", err)
+	}
+	if err := tenant.CheckLimits(map[string]api.FrontendRecord{"a": record("a.com"), "b": record("b.com", "c.com")}); !api.IsValidation(err) {
+		t.Errorf("Expected validation error for too many domains, got %v", err)
+	}
+	if err := tenant.CheckLimits(map[string]api.FrontendRecord{"a": record(), "b": record(), "c": record()}); !api.IsValidation(err) {
+		t.Errorf("Expected validation error for too many frontends, got %v", err)
+	}
+}
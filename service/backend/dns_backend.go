@@ -0,0 +1,336 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/op/go-logging"
+	regapi "github.com/pulcy/registrator-api"
+	api "github.com/pulcy/robin-api"
+)
+
+const (
+	defaultDNSMinInterval = 5 * time.Second
+	defaultDNSMaxInterval = 5 * time.Minute
+	resolvConfPath        = "/etc/resolv.conf"
+	dnsUDPSize            = 4096 // EDNS0 buffer size advertised for UDP answers
+)
+
+// DNSConfig configures the DNS backend.
+type DNSConfig struct {
+	FrontendsPath string        // JSON file containing the frontend records (ID -> record), their service is the SRV name that is resolved
+	Servers       []string      // DNS servers (host:port) that are queried (empty means the nameservers of /etc/resolv.conf)
+	MinInterval   time.Duration // Minimum time between resolves, also when TTLs are shorter (0 means 5s)
+	MaxInterval   time.Duration // Maximum time between resolves, also when TTLs are longer (0 means 5m)
+}
+
+type dnsBackend struct {
+	*statusTracker
+
+	config      BackendConfig
+	dnsConfig   DNSConfig
+	frontends   map[string]api.FrontendRecord
+	client      *dns.Client
+	tcpClient   *dns.Client
+	Logger      *logging.Logger
+	mutex       sync.Mutex
+	resolved    bool
+	services    []regapi.Service // Services found by the last resolve
+	nextResolve time.Time        // Time of the next resolve, based on the TTLs of the last answers
+}
+
+// NewDNSBackend creates a backend that serves the frontend records in the configured file,
+// with the instances of their services found by resolving DNS SRV records.
+func NewDNSBackend(config BackendConfig, dnsConfig DNSConfig, logger *logging.Logger) (Backend, error) {
+	raw, err := ioutil.ReadFile(dnsConfig.FrontendsPath)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	var frontends map[string]api.FrontendRecord
+	if err := json.Unmarshal(raw, &frontends); err != nil {
+		return nil, maskAny(fmt.Errorf("Cannot parse frontend records in %s: %v", dnsConfig.FrontendsPath, err))
+	}
+	if len(dnsConfig.Servers) == 0 {
+		conf, err := dns.ClientConfigFromFile(resolvConfPath)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		for _, server := range conf.Servers {
+			dnsConfig.Servers = append(dnsConfig.Servers, net.JoinHostPort(server, conf.Port))
+		}
+		if len(dnsConfig.Servers) == 0 {
+			return nil, maskAny(fmt.Errorf("No nameservers found in %s", resolvConfPath))
+		}
+	}
+	if dnsConfig.MinInterval <= 0 {
+		dnsConfig.MinInterval = defaultDNSMinInterval
+	}
+	if dnsConfig.MaxInterval <= 0 {
+		dnsConfig.MaxInterval = defaultDNSMaxInterval
+	}
+	return &dnsBackend{
+		statusTracker: &statusTracker{},
+		config:        config,
+		dnsConfig:     dnsConfig,
+		frontends:     frontends,
+		client:        &dns.Client{},
+		tcpClient:     &dns.Client{Net: "tcp"},
+		Logger:        logger,
+	}, nil
+}
+
+// Watch resolves the SRV records when their TTL has expired and returns when the instances have changed.
+func (db *dnsBackend) Watch(ctx context.Context) (err error) {
+	defer func() { db.recordWatch(ctx, err) }()
+	for {
+		db.mutex.Lock()
+		wait := db.nextResolve.Sub(time.Now())
+		db.mutex.Unlock()
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return maskAny(ctx.Err())
+			}
+		}
+		changed, err := db.resolve()
+		if err != nil {
+			return maskAny(err)
+		}
+		if changed {
+			return nil
+		}
+	}
+}
+
+// Load all registered services
+func (db *dnsBackend) Services(ctx context.Context) (registrations ServiceRegistrations, err error) {
+	defer func() { db.recordServices(err) }()
+	db.mutex.Lock()
+	resolved := db.resolved
+	db.mutex.Unlock()
+	if !resolved {
+		if _, err := db.resolve(); err != nil {
+			return nil, maskAny(err)
+		}
+	}
+
+	db.mutex.Lock()
+	services := db.services
+	db.mutex.Unlock()
+	var records []api.FrontendRecord
+	for _, id := range db.frontendIDs() {
+		records = append(records, db.frontends[id])
+	}
+	result, err := mergeTrees(db.Logger, db.config, services, records)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return result, nil
+}
+
+// LuaScripts returns no scripts, since they cannot be stored in DNS.
+func (db *dnsBackend) LuaScripts(ctx context.Context) (map[string]string, error) {
+	return nil, nil
+}
+
+// resolve looks up the instances of all services and returns true if they have changed since the last resolve.
+func (db *dnsBackend) resolve() (bool, error) {
+	names := make(map[string]struct{})
+	for _, r := range db.frontends {
+		names[r.Service] = struct{}{}
+	}
+	var sortedNames []string
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	var services []regapi.Service
+	ttl := uint32(db.dnsConfig.MaxInterval / time.Second)
+	for _, name := range sortedNames {
+		service, serviceTTL, err := db.lookupService(name)
+		if err != nil {
+			db.mutex.Lock()
+			db.nextResolve = time.Now().Add(db.dnsConfig.MinInterval)
+			db.mutex.Unlock()
+			return false, maskAny(err)
+		}
+		if serviceTTL < ttl {
+			ttl = serviceTTL
+		}
+		services = append(services, service)
+	}
+
+	interval := time.Duration(ttl) * time.Second
+	if interval < db.dnsConfig.MinInterval {
+		interval = db.dnsConfig.MinInterval
+	}
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	changed := !db.resolved || !reflect.DeepEqual(services, db.services)
+	db.resolved = true
+	db.services = services
+	db.nextResolve = time.Now().Add(interval)
+	if changed {
+		db.Logger.Debugf("Instances changed, next resolve in %s", interval)
+	}
+	return changed, nil
+}
+
+// lookupService resolves the SRV records with given name into a service.
+// It returns the service and the lowest TTL of the answers.
+// A name that does not exist results in a service without instances.
+func (db *dnsBackend) lookupService(name string) (regapi.Service, uint32, error) {
+	service := regapi.Service{
+		ServiceName: name,
+	}
+	resp, err := db.query(name, dns.TypeSRV)
+	if err != nil {
+		return service, 0, maskAny(err)
+	}
+	if resp.Rcode == dns.RcodeNameError {
+		db.Logger.Warningf("SRV name '%s' does not exist", name)
+		return service, 0, nil
+	}
+
+	ttl := ^uint32(0)
+	addresses := make(map[string][]string) // Target -> IP addresses found in the additional section
+	for _, rr := range resp.Extra {
+		switch rr := rr.(type) {
+		case *dns.A:
+			addresses[rr.Hdr.Name] = append(addresses[rr.Hdr.Name], rr.A.String())
+		case *dns.AAAA:
+			addresses[rr.Hdr.Name] = append(addresses[rr.Hdr.Name], rr.AAAA.String())
+		}
+	}
+	for _, rr := range resp.Answer {
+		srv, ok := rr.(*dns.SRV)
+		if !ok {
+			continue
+		}
+		if srv.Hdr.Ttl < ttl {
+			ttl = srv.Hdr.Ttl
+		}
+		ips, found := addresses[srv.Target]
+		if !found {
+			var addrTTL uint32
+			ips, addrTTL, err = db.lookupAddresses(srv.Target)
+			if err != nil {
+				return service, 0, maskAny(err)
+			}
+			if addrTTL < ttl {
+				ttl = addrTTL
+			}
+		}
+		for _, ip := range ips {
+			service.Instances = append(service.Instances, regapi.ServiceInstance{
+				IP:   ip,
+				Port: int(srv.Port),
+				Tags: map[string]string{MetadataNode: strings.TrimSuffix(srv.Target, ".")},
+			})
+		}
+	}
+	// Answers are often rotated, so order the instances to detect actual changes
+	sort.Sort(dnsInstances(service.Instances))
+	for i, instance := range service.Instances {
+		if i == 0 {
+			service.ServicePort = instance.Port
+		} else if instance.Port != service.ServicePort {
+			// Instances listen on different ports, so selectors cannot specify a port
+			service.ServicePort = 0
+			break
+		}
+	}
+	return service, ttl, nil
+}
+
+// lookupAddresses resolves the IPv4 addresses of the given host.
+// It returns the addresses and the lowest TTL of the answers.
+func (db *dnsBackend) lookupAddresses(host string) ([]string, uint32, error) {
+	resp, err := db.query(host, dns.TypeA)
+	if err != nil {
+		return nil, 0, maskAny(err)
+	}
+	var result []string
+	ttl := ^uint32(0)
+	for _, rr := range resp.Answer {
+		if a, ok := rr.(*dns.A); ok {
+			result = append(result, a.A.String())
+			if a.Hdr.Ttl < ttl {
+				ttl = a.Hdr.Ttl
+			}
+		}
+	}
+	return result, ttl, nil
+}
+
+// query sends a query for the given name & type to the configured servers, until one of them answers.
+func (db *dnsBackend) query(name string, qtype uint16) (*dns.Msg, error) {
+	msg := &dns.Msg{}
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.SetEdns0(dnsUDPSize, false)
+	var lastErr error
+	for _, server := range db.dnsConfig.Servers {
+		resp, _, err := db.client.Exchange(msg, server)
+		if err == dns.ErrTruncated || (err == nil && resp.Truncated) {
+			// The answer does not fit in a UDP message, so records are missing. Ask again over TCP.
+			resp, _, err = db.tcpClient.Exchange(msg, server)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+			lastErr = fmt.Errorf("Query for %s at %s failed: %s", name, server, dns.RcodeToString[resp.Rcode])
+			continue
+		}
+		return resp, nil
+	}
+	return nil, maskAny(lastErr)
+}
+
+// frontendIDs returns the IDs of all frontend records, sorted.
+func (db *dnsBackend) frontendIDs() []string {
+	var ids []string
+	for id := range db.frontends {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// dnsInstances sorts a list of resolved instances by IP address and port.
+type dnsInstances []regapi.ServiceInstance
+
+func (l dnsInstances) Len() int      { return len(l) }
+func (l dnsInstances) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l dnsInstances) Less(i, j int) bool {
+	if l[i].IP != l[j].IP {
+		return l[i].IP < l[j].IP
+	}
+	return l[i].Port < l[j].Port
+}
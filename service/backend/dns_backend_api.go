@@ -0,0 +1,52 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+
+	api "github.com/pulcy/robin-api"
+)
+
+// Add adds a given frontend record with given ID to the list of frontends.
+// The frontend records are read from a file, so they cannot be added.
+func (db *dnsBackend) Add(id string, record api.FrontendRecord) error {
+	return maskAny(fmt.Errorf("Add not implemented"))
+}
+
+// Remove a frontend with given ID.
+// The frontend records are read from a file, so they cannot be removed.
+func (db *dnsBackend) Remove(id string) error {
+	return maskAny(fmt.Errorf("Remove not implemented"))
+}
+
+// All returns a map of all known frontend records mapped by their ID.
+func (db *dnsBackend) All() (map[string]api.FrontendRecord, error) {
+	result := make(map[string]api.FrontendRecord)
+	for id, record := range db.frontends {
+		result[id] = record
+	}
+	return result, nil
+}
+
+// Get returns the frontend record for the given id.
+// If the ID is not found, an IDNotFoundError is returned.
+func (db *dnsBackend) Get(id string) (api.FrontendRecord, error) {
+	record, found := db.frontends[id]
+	if !found {
+		return api.FrontendRecord{}, maskAny(api.IDNotFoundError)
+	}
+	return record, nil
+}
@@ -0,0 +1,183 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	logging "github.com/op/go-logging"
+)
+
+// fakeDNS serves the given SRV and A records on a local UDP port.
+// Only the addresses of targets listed in glue are added to the additional section of SRV answers.
+func fakeDNS(t *testing.T, srv map[string][]dns.RR, a map[string][]dns.RR, glue map[string]bool) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %#v", err)
+	}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		q := req.Question[0]
+		var found bool
+		switch q.Qtype {
+		case dns.TypeSRV:
+			resp.Answer, found = srv[q.Name]
+			for _, rr := range resp.Answer {
+				if target := rr.(*dns.SRV).Target; glue[target] {
+					resp.Extra = append(resp.Extra, a[target]...)
+				}
+			}
+		case dns.TypeA:
+			resp.Answer, found = a[q.Name]
+		}
+		if !found {
+			resp.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(resp)
+	})
+	server := &dns.Server{PacketConn: conn, Handler: handler}
+	go server.ActivateAndServe()
+	return conn.LocalAddr().String(), func() { server.Shutdown() }
+}
+
+func TestDNSServices(t *testing.T) {
+	srv := map[string][]dns.RR{
+		"_http._tcp.web.example.com.": []dns.RR{
+			&dns.SRV{Hdr: dns.RR_Header{Name: "_http._tcp.web.example.com.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 30}, Port: 8080, Target: "node2.example.com."},
+			&dns.SRV{Hdr: dns.RR_Header{Name: "_http._tcp.web.example.com.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 30}, Port: 8080, Target: "node1.example.com."},
+		},
+		"_pg._tcp.db.example.com.": []dns.RR{
+			&dns.SRV{Hdr: dns.RR_Header{Name: "_pg._tcp.db.example.com.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 60}, Port: 5432, Target: "node1.example.com."},
+			&dns.SRV{Hdr: dns.RR_Header{Name: "_pg._tcp.db.example.com.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 60}, Port: 5433, Target: "node2.example.com."},
+		},
+	}
+	a := map[string][]dns.RR{
+		"node1.example.com.": []dns.RR{
+			&dns.A{Hdr: dns.RR_Header{Name: "node1.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 10}, A: net.ParseIP("10.0.0.1")},
+		},
+		"node2.example.com.": []dns.RR{
+			&dns.A{Hdr: dns.RR_Header{Name: "node2.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 10}, A: net.ParseIP("10.0.0.2")},
+			&dns.A{Hdr: dns.RR_Header{Name: "node2.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 10}, A: net.ParseIP("10.0.0.3")},
+		},
+	}
+	addr, shutdown := fakeDNS(t, srv, a, map[string]bool{"node1.example.com.": true})
+	defer shutdown()
+
+	dir, err := ioutil.TempDir("", "robin-dns")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %#v", err)
+	}
+	defer os.RemoveAll(dir)
+	frontendsPath := filepath.Join(dir, "frontends.json")
+	frontends := `{
+		"web": {"service": "_http._tcp.web.example.com", "selectors": [{"domain": "foo.com"}]},
+		"db": {"service": "_pg._tcp.db.example.com", "mode": "tcp", "selectors": [{"frontend-port": 5432, "private": true}]},
+		"missing": {"service": "_http._tcp.missing.example.com", "selectors": [{"domain": "missing.com"}]}
+	}`
+	if err := ioutil.WriteFile(frontendsPath, []byte(frontends), 0644); err != nil {
+		t.Fatalf("Cannot write frontends: %#v", err)
+	}
+
+	b, err := NewDNSBackend(k8sTestConfig, DNSConfig{
+		FrontendsPath: frontendsPath,
+		Servers:       []string{addr},
+	}, logging.MustGetLogger("test"))
+	if err != nil {
+		t.Fatalf("NewDNSBackend failed: %#v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := b.Watch(ctx); err != nil {
+		t.Fatalf("Watch failed: %#v", err)
+	}
+	services, err := b.Services(context.Background())
+	if err != nil {
+		t.Fatalf("Services failed: %#v", err)
+	}
+	for i, s := range services {
+		services[i] = s.Normalize()
+	}
+	services.Sort()
+	result, err := json.MarshalIndent(services, "", "  ")
+	if err != nil {
+		t.Fatalf("Cannot marshal services: %#v", err)
+	}
+	resultPath := "./fixtures/dns_services.json"
+	if os.Getenv("UPDATE-FIXTURES") == "1" {
+		if err := ioutil.WriteFile(resultPath, append(result, '\n'), 0644); err != nil {
+			t.Errorf("Cannot update fixture %s: %#v", resultPath, err)
+		}
+		return
+	}
+	expected, err := ioutil.ReadFile(resultPath)
+	if err != nil {
+		t.Errorf("Cannot read fixture %s: %#v", resultPath, err)
+	} else if string(expected) != string(result)+"\n" {
+		t.Errorf("Unexpected services for %s: got\n%s", resultPath, string(result))
+	}
+
+	// Answers have not changed, so the next watch must wait for the TTL and then the context expires
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if err := b.Watch(ctx); err == nil {
+		t.Errorf("Expected Watch to time out")
+	}
+}
+
+func TestDNSQueryTruncated(t *testing.T) {
+	var answer []dns.RR
+	for i := 0; i < 100; i++ {
+		answer = append(answer, &dns.SRV{Hdr: dns.RR_Header{Name: "_http._tcp.web.example.com.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 30}, Port: uint16(8000 + i), Target: "node1.example.com."})
+	}
+	var edns0 bool
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		resp.Answer = answer
+		if w.RemoteAddr().Network() == "udp" {
+			// Only part of the answer fits in the UDP message
+			edns0 = req.IsEdns0() != nil
+			resp.Answer = answer[:1]
+			resp.Truncated = true
+		}
+		w.WriteMsg(resp)
+	})
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %#v", err)
+	}
+	listener, err := net.Listen("tcp", conn.LocalAddr().String())
+	if err != nil {
+		conn.Close()
+		t.Fatalf("Cannot listen: %#v", err)
+	}
+	udpServer := &dns.Server{PacketConn: conn, Handler: handler}
+	tcpServer := &dns.Server{Listener: listener, Handler: handler}
+	go udpServer.ActivateAndServe()
+	go tcpServer.ActivateAndServe()
+	defer udpServer.Shutdown()
+	defer tcpServer.Shutdown()
+
+	db := &dnsBackend{
+		dnsConfig: DNSConfig{Servers: []string{conn.LocalAddr().String()}},
+		client:    &dns.Client{},
+		tcpClient: &dns.Client{Net: "tcp"},
+	}
+	resp, err := db.query("_http._tcp.web.example.com", dns.TypeSRV)
+	if err != nil {
+		t.Fatalf("query failed: %#v", err)
+	}
+	if !edns0 {
+		t.Errorf("Expected query to use EDNS0")
+	}
+	if len(resp.Answer) != len(answer) {
+		t.Errorf("Expected %d answers, got %d", len(answer), len(resp.Answer))
+	}
+}
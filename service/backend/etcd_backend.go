@@ -15,15 +15,17 @@
 package backend
 
 import (
+	"context"
 	"encoding/json"
 	"path"
 
 	"github.com/coreos/etcd/client"
-	"github.com/juju/errgo"
+	"github.com/coreos/etcd/clientv3"
 	"github.com/op/go-logging"
 	regapi "github.com/pulcy/registrator-api"
 	"github.com/pulcy/robin-api"
-	"golang.org/x/net/context"
+
+	"github.com/pulcy/robin/haproxy"
 )
 
 const (
@@ -36,64 +38,85 @@ type BackendConfig struct {
 	PublicEdgePort      int
 	PrivateHttpEdgePort int
 	PrivateTcpEdgePort  int
+	EdgeGroup           string          // Only frontend records with this edge group are served (empty means default group)
+	HaproxyVersion      haproxy.Version // Version of HAProxy the records are served by (zero means legacy), records using unsupported features are rejected
+	Blocklists          []string        // Names of the configured IP blocklists, records using other blocklists are rejected
+}
+
+// edgePort returns the edge port of a selector with given frontend port (0 means default), visibility & mode.
+func (config BackendConfig) edgePort(frontendPort int, private bool, mode string) int {
+	if frontendPort != 0 {
+		return frontendPort
+	}
+	if !private {
+		return config.PublicEdgePort
+	}
+	if mode == "" || mode == "http" {
+		return config.PrivateHttpEdgePort
+	}
+	return config.PrivateTcpEdgePort
 }
 
 type etcdBackend struct {
-	config            BackendConfig
-	client            client.Client
-	watcher           client.Watcher
-	registratorAPI    regapi.API
-	Logger            *logging.Logger
-	prefix            string
-	recentWatchErrors int
+	*statusTracker
+
+	config         BackendConfig
+	store          etcdStore
+	registratorAPI regapi.API
+	Logger         *logging.Logger
+	prefix         string
+	tenant         *Tenant // If set, the frontend API is limited to the records of this tenant
 }
 
+// NewEtcdBackend creates a backend that uses the ETCD v2 keys API.
 func NewEtcdBackend(config BackendConfig, logger *logging.Logger, c client.Client, etcdPath string) (Backend, error) {
-	kAPI := client.NewKeysAPI(c)
-	options := &client.WatcherOptions{
-		Recursive: true,
-	}
 	registratorAPI, err := regapi.NewRegistratorClient(c, path.Join(etcdPath, servicePrefix), logger)
 	if err != nil {
 		return nil, maskAny(err)
 	}
-	watcher := kAPI.Watcher(etcdPath, options)
 	return &etcdBackend{
+		statusTracker:  &statusTracker{},
 		config:         config,
-		client:         c,
-		watcher:        watcher,
+		store:          newEtcdV2Store(c, etcdPath, logger),
 		registratorAPI: registratorAPI,
 		prefix:         etcdPath,
 		Logger:         logger,
 	}, nil
 }
 
-// Watch for changes on a path and return where there is a change.
-func (eb *etcdBackend) Watch() error {
-	if eb.watcher == nil || eb.recentWatchErrors > recentWatchErrorsMax {
-		eb.recentWatchErrors = 0
-		kAPI := client.NewKeysAPI(eb.client)
-		options := &client.WatcherOptions{
-			Recursive: true,
-		}
-		eb.watcher = kAPI.Watcher(eb.prefix, options)
-	}
-	_, err := eb.watcher.Next(context.Background())
+// NewEtcd3Backend creates a backend that uses the ETCD v3 API.
+func NewEtcd3Backend(config BackendConfig, logger *logging.Logger, c *clientv3.Client, etcdPath string) (Backend, error) {
+	registratorAPI, err := regapi.NewRegistratorClientV3(c, path.Join(etcdPath, servicePrefix), logger)
 	if err != nil {
-		eb.recentWatchErrors++
+		return nil, maskAny(err)
+	}
+	return &etcdBackend{
+		statusTracker:  &statusTracker{},
+		config:         config,
+		store:          newEtcdV3Store(c, etcdPath, logger),
+		registratorAPI: registratorAPI,
+		prefix:         etcdPath,
+		Logger:         logger,
+	}, nil
+}
+
+// Watch for changes on a path and return where there is a change.
+func (eb *etcdBackend) Watch(ctx context.Context) (err error) {
+	defer func() { eb.recordWatch(ctx, err) }()
+	if err := eb.store.Watch(ctx); err != nil {
 		return maskAny(err)
 	}
-	eb.recentWatchErrors = 0
 	return nil
 }
 
 // Load all registered services
-func (eb *etcdBackend) Services() (ServiceRegistrations, error) {
-	servicesTree, err := eb.registratorAPI.Services()
+func (eb *etcdBackend) Services(ctx context.Context) (registrations ServiceRegistrations, err error) {
+	defer func() { eb.recordServices(err) }()
+	servicesTree, err := eb.registratorAPI.Services(ctx)
 	if err != nil {
 		return nil, maskAny(err)
 	}
-	frontEndTree, err := eb.readFrontEndsTree()
+	frontEndTree, err := eb.readFrontEndsTree(ctx)
 	if err != nil {
 		return nil, maskAny(err)
 	}
@@ -105,30 +128,26 @@ func (eb *etcdBackend) Services() (ServiceRegistrations, error) {
 }
 
 // Load all registered front-ends
-func (eb *etcdBackend) readFrontEndsTree() ([]api.FrontendRecord, error) {
-	etcdPath := path.Join(eb.prefix, frontEndPrefix)
-	kAPI := client.NewKeysAPI(eb.client)
-	options := &client.GetOptions{
-		Recursive: false,
-		Sort:      false,
-	}
-	resp, err := kAPI.Get(context.Background(), etcdPath, options)
+func (eb *etcdBackend) readFrontEndsTree(ctx context.Context) ([]api.FrontendRecord, error) {
+	nodes, err := eb.store.List(ctx, path.Join(eb.prefix, frontEndPrefix))
 	if err != nil {
 		return nil, maskAny(err)
 	}
 	list := []api.FrontendRecord{}
-	if resp.Node == nil {
-		return list, nil
-	}
-	for _, frontEndNode := range resp.Node.Nodes {
-		rawJson := frontEndNode.Value
+	for _, frontEndNode := range nodes {
 		record := api.FrontendRecord{}
-		if err := json.Unmarshal([]byte(rawJson), &record); err != nil {
+		if err := json.Unmarshal([]byte(frontEndNode.Value), &record); err != nil {
 			eb.Logger.Errorf("Cannot unmarshal registration of %s", frontEndNode.Key)
 			continue
 		}
+		record.Tenant = ""
 		list = append(list, record)
 	}
+	tenantList, err := eb.readTenantFrontEndsTree(ctx)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	list = append(list, tenantList...)
 
 	return list, nil
 }
@@ -141,8 +160,3 @@ func (eb *etcdBackend) mergeTrees(services []regapi.Service, frontends []api.Fro
 	}
 	return result, nil
 }
-
-func isEtcdError(err error, code int) bool {
-	cerr, ok := errgo.Cause(err).(client.Error)
-	return ok && cerr.Code == code
-}
@@ -20,8 +20,8 @@ import (
 	"fmt"
 	"path"
 	"regexp"
+	"strconv"
 
-	"github.com/coreos/etcd/client"
 	"github.com/juju/errgo"
 	api "github.com/pulcy/robin-api"
 )
@@ -36,19 +36,24 @@ func (eb *etcdBackend) Add(id string, record api.FrontendRecord) error {
 	if err := validateID(id); err != nil {
 		return maskAny(err)
 	}
+	if err := eb.prepareTenantRecord(id, &record); err != nil {
+		return maskAny(err)
+	}
 	if err := record.Validate(); err != nil {
 		return maskAny(err)
 	}
-	etcdPath := path.Join(eb.prefix, frontEndPrefix, id)
-	kAPI := client.NewKeysAPI(eb.client)
-	options := &client.SetOptions{
-		PrevExist: client.PrevNoExist,
+	if err := eb.config.checkFeatures(record); err != nil {
+		return maskAny(err)
+	}
+	if err := eb.checkEdgePorts(context.Background(), id, record); err != nil {
+		return maskAny(err)
 	}
+	etcdPath := path.Join(eb.frontendRoot(), id)
 	rawJSON, err := json.Marshal(record)
 	if err != nil {
 		return maskAny(err)
 	}
-	if _, err := kAPI.Set(context.Background(), etcdPath, string(rawJSON), options); isEtcdError(err, client.ErrorCodeNodeExist) {
+	if err := eb.store.Create(context.Background(), etcdPath, string(rawJSON)); isKeyExists(err) {
 		return maskAny(errgo.WithCausef(nil, api.DuplicateIDError, "Duplicate ID '%s'", id))
 	} else if err != nil {
 		eb.Logger.Warningf("ETCD error in Add: %#v", err)
@@ -64,13 +69,9 @@ func (eb *etcdBackend) Remove(id string) error {
 	if err := validateID(id); err != nil {
 		return maskAny(err)
 	}
-	etcdPath := path.Join(eb.prefix, frontEndPrefix, id)
-	kAPI := client.NewKeysAPI(eb.client)
-	options := &client.DeleteOptions{
-		Recursive: false,
-	}
-	_, err := kAPI.Delete(context.Background(), etcdPath, options)
-	if isEtcdError(err, client.ErrorCodeKeyNotFound) {
+	etcdPath := path.Join(eb.frontendRoot(), id)
+	err := eb.store.Delete(context.Background(), etcdPath, 0)
+	if isKeyNotFound(err) {
 		return maskAny(errgo.WithCausef(nil, api.IDNotFoundError, "ID '%s' not found", id))
 	}
 	if err != nil {
@@ -82,25 +83,13 @@ func (eb *etcdBackend) Remove(id string) error {
 
 // All returns a map of all known frontend records mapped by their ID.
 func (eb *etcdBackend) All() (map[string]api.FrontendRecord, error) {
-	etcdPath := path.Join(eb.prefix, frontEndPrefix)
-	kAPI := client.NewKeysAPI(eb.client)
-	options := &client.GetOptions{
-		Recursive: false,
-		Sort:      false,
-	}
 	result := make(map[string]api.FrontendRecord)
-	resp, err := kAPI.Get(context.Background(), etcdPath, options)
-	if isEtcdError(err, client.ErrorCodeKeyNotFound) {
-		return result, nil
-	}
+	nodes, err := eb.store.List(context.Background(), eb.frontendRoot())
 	if err != nil {
 		eb.Logger.Warningf("ETCD error in All: %#v", err)
 		return nil, maskAny(err)
 	}
-	if resp.Node == nil {
-		return result, nil
-	}
-	for _, frontEndNode := range resp.Node.Nodes {
+	for _, frontEndNode := range nodes {
 		id := path.Base(frontEndNode.Key)
 		rawJSON := frontEndNode.Value
 		record := api.FrontendRecord{}
@@ -117,33 +106,112 @@ func (eb *etcdBackend) All() (map[string]api.FrontendRecord, error) {
 // Get returns the frontend record for the given id.
 // If the ID is not found, an IDNotFoundError is returned.
 func (eb *etcdBackend) Get(id string) (api.FrontendRecord, error) {
-	if err := validateID(id); err != nil {
+	record, _, err := eb.GetVersioned(id)
+	if err != nil {
 		return api.FrontendRecord{}, maskAny(err)
 	}
-	etcdPath := path.Join(eb.prefix, frontEndPrefix, id)
-	kAPI := client.NewKeysAPI(eb.client)
-	options := &client.GetOptions{
-		Recursive: false,
-		Sort:      false,
+	return record, nil
+}
+
+// GetVersioned returns the frontend record for the given id and its current version.
+// The version is the ETCD modified index (v2) or modification revision (v3) of the record.
+// If the ID is not found, an IDNotFoundError is returned.
+func (eb *etcdBackend) GetVersioned(id string) (api.FrontendRecord, string, error) {
+	if err := validateID(id); err != nil {
+		return api.FrontendRecord{}, "", maskAny(err)
 	}
-	resp, err := kAPI.Get(context.Background(), etcdPath, options)
-	if isEtcdError(err, client.ErrorCodeKeyNotFound) {
-		return api.FrontendRecord{}, maskAny(errgo.WithCausef(nil, api.IDNotFoundError, "ID '%s' not found", id))
+	etcdPath := path.Join(eb.frontendRoot(), id)
+	node, err := eb.store.Get(context.Background(), etcdPath)
+	if isKeyNotFound(err) {
+		return api.FrontendRecord{}, "", maskAny(errgo.WithCausef(nil, api.IDNotFoundError, "ID '%s' not found", id))
 	}
 	if err != nil {
 		eb.Logger.Warningf("ETCD error in Get: %#v", err)
-		return api.FrontendRecord{}, maskAny(err)
-	}
-	if resp.Node == nil {
-		return api.FrontendRecord{}, maskAny(errgo.WithCausef(nil, api.IDNotFoundError, "ID '%s' not found", id))
+		return api.FrontendRecord{}, "", maskAny(err)
 	}
-	rawJSON := resp.Node.Value
 	record := api.FrontendRecord{}
-	if err := json.Unmarshal([]byte(rawJSON), &record); err != nil {
-		return api.FrontendRecord{}, maskAny(fmt.Errorf("Cannot unmarshal registration of %s", id))
+	if err := json.Unmarshal([]byte(node.Value), &record); err != nil {
+		return api.FrontendRecord{}, "", maskAny(fmt.Errorf("Cannot unmarshal registration of %s", id))
 	}
 
-	return record, nil
+	return record, strconv.FormatUint(node.Index, 10), nil
+}
+
+// Update replaces the frontend record with given ID.
+// If the ID is not found, an IDNotFoundError is returned.
+// If version is not empty and does not match the current version, a VersionMismatchError is returned.
+func (eb *etcdBackend) Update(id string, record api.FrontendRecord, version string) error {
+	if err := validateID(id); err != nil {
+		return maskAny(err)
+	}
+	if err := eb.prepareTenantRecord(id, &record); err != nil {
+		return maskAny(err)
+	}
+	if err := record.Validate(); err != nil {
+		return maskAny(err)
+	}
+	if err := eb.config.checkFeatures(record); err != nil {
+		return maskAny(err)
+	}
+	if err := eb.checkEdgePorts(context.Background(), id, record); err != nil {
+		return maskAny(err)
+	}
+	prevIndex, err := parseVersion(version)
+	if err != nil {
+		return maskAny(err)
+	}
+	etcdPath := path.Join(eb.frontendRoot(), id)
+	rawJSON, err := json.Marshal(record)
+	if err != nil {
+		return maskAny(err)
+	}
+	if err := eb.store.Update(context.Background(), etcdPath, string(rawJSON), prevIndex); err != nil {
+		return maskAny(eb.mapVersionedError(id, "Update", err))
+	}
+	return nil
+}
+
+// RemoveVersioned removes the frontend with given ID.
+// If the ID is not found, an IDNotFoundError is returned.
+// If version is not empty and does not match the current version, a VersionMismatchError is returned.
+func (eb *etcdBackend) RemoveVersioned(id string, version string) error {
+	if err := validateID(id); err != nil {
+		return maskAny(err)
+	}
+	prevIndex, err := parseVersion(version)
+	if err != nil {
+		return maskAny(err)
+	}
+	etcdPath := path.Join(eb.frontendRoot(), id)
+	if err := eb.store.Delete(context.Background(), etcdPath, prevIndex); err != nil {
+		return maskAny(eb.mapVersionedError(id, "RemoveVersioned", err))
+	}
+	return nil
+}
+
+// mapVersionedError converts ETCD errors of a versioned operation into API errors.
+func (eb *etcdBackend) mapVersionedError(id, operation string, err error) error {
+	if isKeyNotFound(err) {
+		return errgo.WithCausef(nil, api.IDNotFoundError, "ID '%s' not found", id)
+	}
+	if isTestFailed(err) {
+		return errgo.WithCausef(nil, api.VersionMismatchError, "ID '%s' has been modified", id)
+	}
+	eb.Logger.Warningf("ETCD error in %s: %#v", operation, err)
+	return err
+}
+
+// parseVersion converts a version (ETag) into an ETCD index (v2) or revision (v3).
+// An empty version results in 0 (no comparison).
+func parseVersion(version string) (uint64, error) {
+	if version == "" {
+		return 0, nil
+	}
+	index, err := strconv.ParseUint(version, 10, 64)
+	if err != nil || index == 0 {
+		return 0, maskAny(errgo.WithCausef(nil, api.VersionMismatchError, "invalid version '%s'", version))
+	}
+	return index, nil
 }
 
 func validateID(id string) error {
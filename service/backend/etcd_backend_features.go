@@ -0,0 +1,44 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/juju/errgo"
+	"github.com/pulcy/robin-api"
+)
+
+// checkFeatures returns a ValidationError when the given record uses a feature
+// that is not supported by the configured HAProxy version or a blocklist that is not configured.
+func (config BackendConfig) checkFeatures(record api.FrontendRecord) error {
+	for _, name := range record.Blocklists {
+		if !containsString(config.Blocklists, name) {
+			return maskAny(errgo.WithCausef(nil, api.ValidationError, "unknown blocklist '%s'", name))
+		}
+	}
+	if len(record.TrapPaths) > 0 && !config.HaproxyVersion.AtLeast(1, 8) {
+		return maskAny(errgo.WithCausef(nil, api.ValidationError, "trap-paths requires HAProxy 1.8 or later, got %s", config.HaproxyVersion))
+	}
+	if record.Grpc && !config.HaproxyVersion.AtLeast(2, 0) {
+		return maskAny(errgo.WithCausef(nil, api.ValidationError, "grpc requires HAProxy 2.0 or later, got %s", config.HaproxyVersion))
+	}
+	if !config.HaproxyVersion.AtLeast(1, 8) {
+		for _, sel := range record.AllSelectors() {
+			if sel.Cache != nil {
+				return maskAny(errgo.WithCausef(nil, api.ValidationError, "cache requires HAProxy 1.8 or later, got %s", config.HaproxyVersion))
+			}
+		}
+	}
+	return nil
+}
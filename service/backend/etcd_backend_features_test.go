@@ -0,0 +1,58 @@
+package backend
+
+import (
+	"testing"
+
+	api "github.com/pulcy/robin-api"
+
+	"github.com/pulcy/robin/haproxy"
+)
+
+func TestCheckFeatures(t *testing.T) {
+	traps := newTestRecord("web", "foo.com")
+	traps.TrapPaths = []string{"/admin.php"}
+	cache := newTestRecord("web", "foo.com")
+	cache.Selectors[0].Cache = &api.CacheRecord{MaxAge: 60}
+	grpc := newTestRecord("web", "foo.com")
+	grpc.Grpc = true
+	tests := []struct {
+		Version haproxy.Version
+		Record  api.FrontendRecord
+		Valid   bool
+	}{
+		{haproxy.Version{}, newTestRecord("web", "foo.com"), true},
+		{haproxy.Version{}, traps, false},
+		{haproxy.Version{Major: 1, Minor: 6}, traps, false},
+		{haproxy.Version{Major: 1, Minor: 8}, traps, true},
+		{haproxy.Version{Major: 2, Minor: 4}, traps, true},
+		{haproxy.Version{}, cache, false},
+		{haproxy.Version{Major: 1, Minor: 6}, cache, false},
+		{haproxy.Version{Major: 1, Minor: 8}, cache, true},
+		{haproxy.Version{}, grpc, false},
+		{haproxy.Version{Major: 1, Minor: 8}, grpc, false},
+		{haproxy.Version{Major: 2, Minor: 0}, grpc, true},
+	}
+	for i, test := range tests {
+		eb := newTestEtcdBackend()
+		eb.config.HaproxyVersion = test.Version
+		err := eb.Add("web", test.Record)
+		if test.Valid && err != nil {
+			t.Errorf("Test %d: expected success, got %#v", i, err)
+		} else if !test.Valid && !api.IsValidation(err) {
+			t.Errorf("Test %d: expected validation error, got %#v", i, err)
+		}
+	}
+}
+
+func TestCheckBlocklists(t *testing.T) {
+	record := newTestRecord("web", "foo.com")
+	record.Blocklists = []string{"spamhaus"}
+	eb := newTestEtcdBackend()
+	if err := eb.Add("web", record); !api.IsValidation(err) {
+		t.Errorf("Expected validation error for unknown blocklist, got %#v", err)
+	}
+	eb.config.Blocklists = []string{"crowdsec", "spamhaus"}
+	if err := eb.Add("web", record); err != nil {
+		t.Errorf("Expected success, got %#v", err)
+	}
+}
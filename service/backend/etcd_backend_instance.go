@@ -0,0 +1,99 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errgo"
+	api "github.com/pulcy/robin-api"
+)
+
+const (
+	// instanceHost is used as host part of the keys of instances registered through the API.
+	// Registrator uses the name of the docker host there.
+	instanceHost = "robin"
+)
+
+// RegisterInstance registers (or refreshes the TTL of) an instance of the service with given name.
+// The instance is written in the same format as registrator uses.
+func (eb *etcdBackend) RegisterInstance(serviceName string, record api.InstanceRecord) error {
+	if err := api.ValidateName(serviceName); err != nil {
+		return maskAny(err)
+	}
+	if err := record.Validate(); err != nil {
+		return maskAny(err)
+	}
+	ttl := record.TTL
+	if ttl == 0 {
+		ttl = api.DefaultInstanceTTL
+	}
+	value := fmt.Sprintf("%s:%d", record.IP, record.Port)
+	tags := url.Values{}
+	for key, v := range record.Tags {
+		tags.Set(key, v)
+	}
+	if record.Weight != nil {
+		tags.Set(instanceWeightTag, strconv.Itoa(*record.Weight))
+	}
+	if len(tags) > 0 {
+		value = value + "?" + tags.Encode()
+	}
+	etcdPath := path.Join(eb.prefix, servicePrefix, serviceName, fmt.Sprintf("%s:%s:%d", instanceHost, record.InstanceID(), record.Port))
+	if err := eb.store.Set(context.Background(), etcdPath, value, time.Duration(ttl)*time.Second); err != nil {
+		eb.Logger.Warningf("ETCD error in RegisterInstance: %#v", err)
+		return maskAny(err)
+	}
+	return nil
+}
+
+// DeregisterInstance removes the instance with given ID of the service with given name.
+// If the ID is not found, an IDNotFoundError is returned.
+func (eb *etcdBackend) DeregisterInstance(serviceName, id string) error {
+	if err := api.ValidateName(serviceName); err != nil {
+		return maskAny(err)
+	}
+	if err := api.ValidateName(id); err != nil {
+		return maskAny(err)
+	}
+	etcdPath := path.Join(eb.prefix, servicePrefix, serviceName)
+	nodes, err := eb.store.List(context.Background(), etcdPath)
+	if err != nil {
+		return maskAny(err)
+	}
+	// Keys are formatted as <host>:<id>:<port>, the port is not known here
+	prefix := fmt.Sprintf("%s:%s:", instanceHost, id)
+	found := false
+	for _, node := range nodes {
+		if !strings.HasPrefix(path.Base(node.Key), prefix) {
+			continue
+		}
+		if err := eb.store.Delete(context.Background(), node.Key, 0); err != nil && !isKeyNotFound(err) {
+			eb.Logger.Warningf("ETCD error in DeregisterInstance: %#v", err)
+			return maskAny(err)
+		}
+		found = true
+	}
+	if !found {
+		return maskAny(errgo.WithCausef(nil, api.IDNotFoundError, "instance '%s' not found", id))
+	}
+	return nil
+}
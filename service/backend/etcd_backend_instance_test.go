@@ -0,0 +1,88 @@
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	api "github.com/pulcy/robin-api"
+)
+
+func TestRegisterInstance(t *testing.T) {
+	eb := newTestEtcdBackend()
+	store := eb.store.(*fakeStore)
+	weight := 0
+	tests := []struct {
+		Record api.InstanceRecord
+		Key    string
+		Value  string
+		TTL    time.Duration
+	}{
+		{
+			api.InstanceRecord{IP: "10.0.0.1", Port: 8080},
+			"/pulcy/service/web/robin:10_0_0_1-8080:8080", "10.0.0.1:8080", 30 * time.Second,
+		},
+		{
+			api.InstanceRecord{ID: "web-2", IP: "10.0.0.2", Port: 8080, Tags: map[string]string{"role": "primary"}, Weight: &weight, TTL: 60},
+			"/pulcy/service/web/robin:web-2:8080", "10.0.0.2:8080?role=primary&weight=0", 60 * time.Second,
+		},
+	}
+	for _, test := range tests {
+		if err := eb.RegisterInstance("web", test.Record); err != nil {
+			t.Fatalf("RegisterInstance failed: %#v", err)
+		}
+		node, err := store.Get(context.Background(), test.Key)
+		if err != nil {
+			t.Errorf("Expected key %s: %#v", test.Key, err)
+			continue
+		}
+		if node.Value != test.Value {
+			t.Errorf("Key %s: expected value %s, got %s", test.Key, test.Value, node.Value)
+		}
+		if ttl := store.ttls[test.Key]; ttl != test.TTL {
+			t.Errorf("Key %s: expected TTL %s, got %s", test.Key, test.TTL, ttl)
+		}
+	}
+
+	// A heartbeat refreshes the same key
+	if err := eb.RegisterInstance("web", tests[0].Record); err != nil {
+		t.Fatalf("RegisterInstance failed: %#v", err)
+	}
+	if nodes, _ := store.List(context.Background(), "/pulcy/service/web"); len(nodes) != 2 {
+		t.Errorf("Expected 2 instances after heartbeat, got %d", len(nodes))
+	}
+
+	invalid := []api.InstanceRecord{
+		api.InstanceRecord{IP: "not-an-ip", Port: 8080},
+		api.InstanceRecord{IP: "10.0.0.1", Port: 0},
+		api.InstanceRecord{IP: "10.0.0.1", Port: 8080, TTL: -1},
+	}
+	for i, record := range invalid {
+		if err := eb.RegisterInstance("web", record); !api.IsValidation(err) {
+			t.Errorf("Test %d: expected validation error, got %#v", i, err)
+		}
+	}
+	if err := eb.RegisterInstance("Web App", tests[0].Record); !api.IsValidation(err) {
+		t.Errorf("Expected validation error for invalid service name, got %#v", err)
+	}
+}
+
+func TestDeregisterInstance(t *testing.T) {
+	eb := newTestEtcdBackend()
+	store := eb.store.(*fakeStore)
+	// An instance registered by registrator must not be removed
+	store.Set(context.Background(), "/pulcy/service/web/host1:web-1:8080", "10.0.0.9:8080", 0)
+	if err := eb.RegisterInstance("web", api.InstanceRecord{ID: "web-1", IP: "10.0.0.1", Port: 8080}); err != nil {
+		t.Fatalf("RegisterInstance failed: %#v", err)
+	}
+	if err := eb.DeregisterInstance("web", "web-1"); err != nil {
+		t.Fatalf("DeregisterInstance failed: %#v", err)
+	}
+	nodes, _ := store.List(context.Background(), "/pulcy/service/web")
+	if len(nodes) != 1 || nodes[0].Key != "/pulcy/service/web/host1:web-1:8080" {
+		t.Errorf("Expected only the registrator instance to remain, got %#v", nodes)
+	}
+	if err := eb.DeregisterInstance("web", "web-1"); !api.IsIDNotFound(err) {
+		t.Errorf("Expected not found error for second removal, got %#v", err)
+	}
+}
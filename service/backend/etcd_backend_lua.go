@@ -0,0 +1,45 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"path"
+
+	api "github.com/pulcy/robin-api"
+)
+
+const (
+	luaPrefix = "lua"
+)
+
+// LuaScripts loads all Lua scripts stored under <prefix>/lua/<name>.
+// Scripts with an invalid name are ignored.
+func (eb *etcdBackend) LuaScripts(ctx context.Context) (map[string]string, error) {
+	nodes, err := eb.store.List(ctx, path.Join(eb.prefix, luaPrefix))
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	result := make(map[string]string)
+	for _, node := range nodes {
+		name := path.Base(node.Key)
+		if api.ValidateName(name) != nil {
+			eb.Logger.Warningf("Ignoring Lua script %s", node.Key)
+			continue
+		}
+		result[name] = node.Value
+	}
+	return result, nil
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+
+	api "github.com/pulcy/robin-api"
+)
+
+// checkEdgePorts returns a PortConflictError when the given record, about to be stored under the given ID,
+// uses an edge port that another record (of any tenant) uses with another mode or visibility.
+func (eb *etcdBackend) checkEdgePorts(ctx context.Context, id string, record api.FrontendRecord) error {
+	records, err := eb.readFrontEndsByOwner(ctx)
+	if err != nil {
+		return maskAny(err)
+	}
+	owner := eb.ownerOf(id)
+	var owners []string
+	for o := range records {
+		if o != owner {
+			owners = append(owners, o)
+		}
+	}
+	sort.Strings(owners)
+	registry := NewPortRegistry(eb.config)
+	for _, o := range owners {
+		// Existing conflicts cannot be solved here, they only must not get worse
+		if err := registry.Reserve(o, records[o]); err != nil {
+			eb.Logger.Debugf("Existing frontend '%s' has an edge port conflict: %v", o, err)
+		}
+	}
+	if err := registry.Reserve(owner, record); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// ownerOf returns the name of the record with given ID, as used in edge port reservations.
+// Records of tenants are named <tenant>/<id>.
+func (eb *etcdBackend) ownerOf(id string) string {
+	if eb.tenant == nil {
+		return id
+	}
+	return path.Join(eb.tenant.Name, id)
+}
+
+// readFrontEndsByOwner loads the frontend records of the backend and of all tenants, by owner name.
+func (eb *etcdBackend) readFrontEndsByOwner(ctx context.Context) (map[string]api.FrontendRecord, error) {
+	result := make(map[string]api.FrontendRecord)
+	nodes, err := eb.store.List(ctx, path.Join(eb.prefix, frontEndPrefix))
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	for _, node := range nodes {
+		record := api.FrontendRecord{}
+		if err := json.Unmarshal([]byte(node.Value), &record); err != nil {
+			continue
+		}
+		result[path.Base(node.Key)] = record
+	}
+	tenantPath := path.Join(eb.prefix, tenantPrefix)
+	nodes, err = eb.store.ListRecursive(ctx, tenantPath)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	for _, node := range nodes {
+		// Keys are formatted as <tenant>/frontend/<id>
+		parts := strings.Split(relativeKey(tenantPath, node.Key), "/")
+		if len(parts) != 3 || parts[1] != frontEndPrefix {
+			continue
+		}
+		record := api.FrontendRecord{}
+		if err := json.Unmarshal([]byte(node.Value), &record); err != nil {
+			continue
+		}
+		result[path.Join(parts[0], parts[2])] = record
+	}
+	return result, nil
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/juju/errgo"
+	api "github.com/pulcy/robin-api"
+)
+
+const (
+	schedulePrefix = "schedule"
+)
+
+// ScheduleChange registers the given change and returns it (with its ID).
+func (eb *etcdBackend) ScheduleChange(change api.ScheduledChange) (api.ScheduledChange, error) {
+	if change.ID == "" {
+		id, err := newScheduleID(change.At)
+		if err != nil {
+			return api.ScheduledChange{}, maskAny(err)
+		}
+		change.ID = id
+	}
+	if err := change.Validate(); err != nil {
+		return api.ScheduledChange{}, maskAny(err)
+	}
+	if err := validateID(change.ID); err != nil {
+		return api.ScheduledChange{}, maskAny(err)
+	}
+	etcdPath := path.Join(eb.prefix, schedulePrefix, change.ID)
+	rawJSON, err := json.Marshal(change)
+	if err != nil {
+		return api.ScheduledChange{}, maskAny(err)
+	}
+	if err := eb.store.Create(context.Background(), etcdPath, string(rawJSON)); isKeyExists(err) {
+		return api.ScheduledChange{}, maskAny(errgo.WithCausef(nil, api.DuplicateIDError, "scheduled change '%s' already exists", change.ID))
+	} else if err != nil {
+		eb.Logger.Warningf("ETCD error in ScheduleChange: %#v", err)
+		return api.ScheduledChange{}, maskAny(err)
+	}
+	return change, nil
+}
+
+// ScheduledChanges returns all changes that have not been applied yet, ordered by time.
+func (eb *etcdBackend) ScheduledChanges() ([]api.ScheduledChange, error) {
+	nodes, err := eb.scheduleNodes()
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	result := make([]api.ScheduledChange, 0, len(nodes))
+	for _, n := range nodes {
+		result = append(result, n.change)
+	}
+	return result, nil
+}
+
+// CancelScheduledChange removes the change with given ID.
+// If the ID is not found, an IDNotFoundError is returned.
+func (eb *etcdBackend) CancelScheduledChange(id string) error {
+	if err := validateID(id); err != nil {
+		return maskAny(err)
+	}
+	etcdPath := path.Join(eb.prefix, schedulePrefix, id)
+	err := eb.store.Delete(context.Background(), etcdPath, 0)
+	if isKeyNotFound(err) {
+		return maskAny(errgo.WithCausef(nil, api.IDNotFoundError, "scheduled change '%s' not found", id))
+	}
+	if err != nil {
+		eb.Logger.Warningf("ETCD error in CancelScheduledChange: %#v", err)
+		return maskAny(err)
+	}
+	return nil
+}
+
+// ApplyDueChanges applies all scheduled changes that are due at the given time.
+// A change is claimed by removing it from ETCD (compare-and-delete) first, so
+// only one robin instance applies it. A change that fails to apply is scheduled again,
+// so it is retried later (until it is canceled).
+func (eb *etcdBackend) ApplyDueChanges(now time.Time) error {
+	nodes, err := eb.scheduleNodes()
+	if err != nil {
+		return maskAny(err)
+	}
+	for _, n := range nodes {
+		if n.change.At.After(now) {
+			break
+		}
+		if err := eb.store.Delete(context.Background(), n.key, n.index); isKeyNotFound(err) || isTestFailed(err) {
+			// Claimed by someone else
+			continue
+		} else if err != nil {
+			eb.Logger.Warningf("ETCD error in ApplyDueChanges: %#v", err)
+			return maskAny(err)
+		}
+		if err := eb.applyScheduledChange(n.change); err != nil {
+			eb.Logger.Errorf("Failed to apply scheduled change %s of frontend %s: %#v", n.change.ID, n.change.FrontendID, err)
+			if err := eb.requeueScheduledChange(n); err != nil {
+				return maskAny(err)
+			}
+			continue
+		}
+		eb.Logger.Infof("Applied scheduled change %s (%s) of frontend %s", n.change.ID, n.change.Action, n.change.FrontendID)
+	}
+	return nil
+}
+
+// applyScheduledChange performs the action of the given change.
+func (eb *etcdBackend) applyScheduledChange(change api.ScheduledChange) error {
+	switch change.Action {
+	case api.ScheduleActionSet:
+		err := eb.Update(change.FrontendID, *change.Record, "")
+		if api.IsIDNotFound(err) {
+			err = eb.Add(change.FrontendID, *change.Record)
+		}
+		if err != nil {
+			return maskAny(err)
+		}
+	case api.ScheduleActionRemove:
+		if err := eb.Remove(change.FrontendID); err != nil && !api.IsIDNotFound(err) {
+			return maskAny(err)
+		}
+	default:
+		return maskAny(fmt.Errorf("Unknown action '%s'", change.Action))
+	}
+	return nil
+}
+
+// requeueScheduledChange stores the (claimed) change of the given node again.
+func (eb *etcdBackend) requeueScheduledChange(n scheduleNode) error {
+	rawJSON, err := json.Marshal(n.change)
+	if err != nil {
+		return maskAny(err)
+	}
+	if err := eb.store.Create(context.Background(), n.key, string(rawJSON)); err != nil {
+		eb.Logger.Errorf("Failed to reschedule change %s, it is lost: %#v", n.change.ID, err)
+		return maskAny(err)
+	}
+	return nil
+}
+
+type scheduleNode struct {
+	key    string
+	index  uint64
+	change api.ScheduledChange
+}
+
+// scheduleNodes loads all scheduled changes, ordered by time.
+func (eb *etcdBackend) scheduleNodes() ([]scheduleNode, error) {
+	nodes, err := eb.store.List(context.Background(), path.Join(eb.prefix, schedulePrefix))
+	if err != nil {
+		eb.Logger.Warningf("ETCD error in scheduleNodes: %#v", err)
+		return nil, maskAny(err)
+	}
+	var result []scheduleNode
+	for _, node := range nodes {
+		change := api.ScheduledChange{}
+		if err := json.Unmarshal([]byte(node.Value), &change); err != nil {
+			eb.Logger.Errorf("Cannot unmarshal scheduled change %s", node.Key)
+			continue
+		}
+		change.ID = path.Base(node.Key)
+		result = append(result, scheduleNode{key: node.Key, index: node.Index, change: change})
+	}
+	sort.Sort(scheduleNodes(result))
+	return result, nil
+}
+
+// scheduleNodes sorts a list of scheduled changes by time & ID.
+type scheduleNodes []scheduleNode
+
+func (l scheduleNodes) Len() int      { return len(l) }
+func (l scheduleNodes) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l scheduleNodes) Less(i, j int) bool {
+	a, b := l[i].change, l[j].change
+	if !a.At.Equal(b.At) {
+		return a.At.Before(b.At)
+	}
+	return a.ID < b.ID
+}
+
+// newScheduleID creates a unique ID for a change scheduled at the given time.
+func newScheduleID(at time.Time) (string, error) {
+	raw := make([]byte, 4)
+	if _, err := rand.Read(raw); err != nil {
+		return "", maskAny(err)
+	}
+	return fmt.Sprintf("%d-%s", at.Unix(), hex.EncodeToString(raw)), nil
+}
@@ -0,0 +1,61 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	api "github.com/pulcy/robin-api"
+)
+
+func TestApplyDueChanges(t *testing.T) {
+	eb := newTestEtcdBackend()
+	if err := eb.Add("web", newTestRecord("web", "foo.com")); err != nil {
+		t.Fatalf("Add failed: %#v", err)
+	}
+	now := time.Now()
+	updated := newTestRecord("web", "www.foo.com")
+	// Traps are not supported by the (legacy) HAProxy version of the backend, so this change fails
+	unsupported := newTestRecord("docs", "docs.foo.com")
+	unsupported.TrapPaths = []string{"/admin.php"}
+	changes := []api.ScheduledChange{
+		api.ScheduledChange{ID: "update", FrontendID: "web", At: now.Add(-time.Minute), Action: api.ScheduleActionSet, Record: &updated},
+		api.ScheduledChange{ID: "failing", FrontendID: "docs", At: now.Add(-time.Minute), Action: api.ScheduleActionSet, Record: &unsupported},
+		api.ScheduledChange{ID: "later", FrontendID: "web", At: now.Add(time.Hour), Action: api.ScheduleActionRemove},
+	}
+	for _, c := range changes {
+		if _, err := eb.ScheduleChange(c); err != nil {
+			t.Fatalf("ScheduleChange failed: %#v", err)
+		}
+	}
+
+	if err := eb.ApplyDueChanges(now); err != nil {
+		t.Fatalf("ApplyDueChanges failed: %#v", err)
+	}
+	record, err := eb.Get("web")
+	if err != nil {
+		t.Fatalf("Get failed: %#v", err)
+	}
+	if record.Selectors[0].Domain != "www.foo.com" {
+		t.Errorf("Expected due change to be applied, got domain '%s'", record.Selectors[0].Domain)
+	}
+	pending, err := eb.ScheduledChanges()
+	if err != nil {
+		t.Fatalf("ScheduledChanges failed: %#v", err)
+	}
+	ids := []string{}
+	for _, c := range pending {
+		ids = append(ids, c.ID)
+	}
+	// The failed change is kept for a retry, the applied change is removed
+	if len(ids) != 2 || ids[0] != "failing" || ids[1] != "later" {
+		t.Errorf("Expected changes [failing later] to be pending, got %v", ids)
+	}
+
+	// Once due, the removal is applied as well
+	if err := eb.ApplyDueChanges(now.Add(time.Hour * 2)); err != nil {
+		t.Fatalf("ApplyDueChanges failed: %#v", err)
+	}
+	if _, err := eb.Get("web"); !api.IsIDNotFound(err) {
+		t.Errorf("Expected frontend to be removed, got %#v", err)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/juju/errgo"
+	api "github.com/pulcy/robin-api"
+)
+
+const (
+	templatePrefix = "template"
+)
+
+// AddTemplate adds or replaces the template with given name.
+func (eb *etcdBackend) AddTemplate(name string, tmpl api.FrontendRecord) error {
+	if err := validateID(name); err != nil {
+		return maskAny(err)
+	}
+	if err := api.ValidateTemplate(tmpl); err != nil {
+		return maskAny(err)
+	}
+	etcdPath := path.Join(eb.prefix, templatePrefix, name)
+	rawJSON, err := json.Marshal(tmpl)
+	if err != nil {
+		return maskAny(err)
+	}
+	if err := eb.store.Set(context.Background(), etcdPath, string(rawJSON), 0); err != nil {
+		eb.Logger.Warningf("ETCD error in AddTemplate: %#v", err)
+		return maskAny(err)
+	}
+	return nil
+}
+
+// RemoveTemplate removes the template with given name.
+// If the name is not found, an IDNotFoundError is returned.
+func (eb *etcdBackend) RemoveTemplate(name string) error {
+	if err := validateID(name); err != nil {
+		return maskAny(err)
+	}
+	etcdPath := path.Join(eb.prefix, templatePrefix, name)
+	err := eb.store.Delete(context.Background(), etcdPath, 0)
+	if isKeyNotFound(err) {
+		return maskAny(errgo.WithCausef(nil, api.IDNotFoundError, "template '%s' not found", name))
+	}
+	if err != nil {
+		eb.Logger.Warningf("ETCD error in RemoveTemplate: %#v", err)
+		return maskAny(err)
+	}
+	return nil
+}
+
+// AllTemplates returns a map of all known templates mapped by their name.
+func (eb *etcdBackend) AllTemplates() (map[string]api.FrontendRecord, error) {
+	result := make(map[string]api.FrontendRecord)
+	nodes, err := eb.store.List(context.Background(), path.Join(eb.prefix, templatePrefix))
+	if err != nil {
+		eb.Logger.Warningf("ETCD error in AllTemplates: %#v", err)
+		return nil, maskAny(err)
+	}
+	for _, node := range nodes {
+		tmpl := api.FrontendRecord{}
+		if err := json.Unmarshal([]byte(node.Value), &tmpl); err != nil {
+			eb.Logger.Errorf("Cannot unmarshal template %s", node.Key)
+			continue
+		}
+		result[path.Base(node.Key)] = tmpl
+	}
+	return result, nil
+}
+
+// GetTemplate returns the template with given name.
+// If the name is not found, an IDNotFoundError is returned.
+func (eb *etcdBackend) GetTemplate(name string) (api.FrontendRecord, error) {
+	if err := validateID(name); err != nil {
+		return api.FrontendRecord{}, maskAny(err)
+	}
+	etcdPath := path.Join(eb.prefix, templatePrefix, name)
+	node, err := eb.store.Get(context.Background(), etcdPath)
+	if isKeyNotFound(err) {
+		return api.FrontendRecord{}, maskAny(errgo.WithCausef(nil, api.IDNotFoundError, "template '%s' not found", name))
+	}
+	if err != nil {
+		eb.Logger.Warningf("ETCD error in GetTemplate: %#v", err)
+		return api.FrontendRecord{}, maskAny(err)
+	}
+	tmpl := api.FrontendRecord{}
+	if err := json.Unmarshal([]byte(node.Value), &tmpl); err != nil {
+		return api.FrontendRecord{}, maskAny(fmt.Errorf("Cannot unmarshal template %s", name))
+	}
+	return tmpl, nil
+}
+
+// AddFromTemplate instantiates the requested template and adds the resulting frontend record.
+// If the given ID already exists, a DuplicateIDError is returned.
+func (eb *etcdBackend) AddFromTemplate(req api.FromTemplateRequest) (api.FrontendRecord, error) {
+	tmpl, err := eb.GetTemplate(req.Template)
+	if err != nil {
+		return api.FrontendRecord{}, maskAny(err)
+	}
+	record, err := api.InstantiateTemplate(tmpl, req.Variables)
+	if err != nil {
+		return api.FrontendRecord{}, maskAny(err)
+	}
+	if err := eb.Add(req.ID, record); err != nil {
+		return api.FrontendRecord{}, maskAny(err)
+	}
+	return record, nil
+}
@@ -0,0 +1,80 @@
+package backend
+
+import (
+	"reflect"
+	"testing"
+
+	api "github.com/pulcy/robin-api"
+)
+
+func TestTemplates(t *testing.T) {
+	eb := newTestEtcdBackend()
+	tmpl := api.FrontendRecord{
+		Service: "{{.app}}-web",
+		Selectors: []api.FrontendSelectorRecord{
+			api.FrontendSelectorRecord{Domain: "{{.app}}.example.com", PathPrefix: "/{{.app}}"},
+		},
+	}
+	if err := eb.AddTemplate("app", tmpl); err != nil {
+		t.Fatalf("AddTemplate failed: %#v", err)
+	}
+	if all, err := eb.AllTemplates(); err != nil {
+		t.Fatalf("AllTemplates failed: %#v", err)
+	} else if !reflect.DeepEqual(all, map[string]api.FrontendRecord{"app": tmpl}) {
+		t.Errorf("Unexpected templates %#v", all)
+	}
+
+	record, err := eb.AddFromTemplate(api.FromTemplateRequest{ID: "shop", Template: "app", Variables: map[string]string{"app": "shop"}})
+	if err != nil {
+		t.Fatalf("AddFromTemplate failed: %#v", err)
+	}
+	expected := api.FrontendRecord{
+		Service: "shop-web",
+		Selectors: []api.FrontendSelectorRecord{
+			api.FrontendSelectorRecord{Domain: "shop.example.com", PathPrefix: "/shop"},
+		},
+	}
+	if !reflect.DeepEqual(record, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, record)
+	}
+	if stored, err := eb.Get("shop"); err != nil {
+		t.Errorf("Get failed: %#v", err)
+	} else if !reflect.DeepEqual(stored, expected) {
+		t.Errorf("Expected stored %#v, got %#v", expected, stored)
+	}
+	// The ID of an instantiated template cannot be reused
+	if _, err := eb.AddFromTemplate(api.FromTemplateRequest{ID: "shop", Template: "app", Variables: map[string]string{"app": "blog"}}); !api.IsDuplicateID(err) {
+		t.Errorf("Expected duplicate ID error, got %#v", err)
+	}
+	// All variables must be given
+	if _, err := eb.AddFromTemplate(api.FromTemplateRequest{ID: "blog", Template: "app"}); !api.IsValidation(err) {
+		t.Errorf("Expected validation error for missing variable, got %#v", err)
+	}
+	if _, err := eb.AddFromTemplate(api.FromTemplateRequest{ID: "blog", Template: "missing"}); !api.IsIDNotFound(err) {
+		t.Errorf("Expected not found error for unknown template, got %#v", err)
+	}
+
+	if err := eb.RemoveTemplate("app"); err != nil {
+		t.Errorf("RemoveTemplate failed: %#v", err)
+	}
+	if _, err := eb.GetTemplate("app"); !api.IsIDNotFound(err) {
+		t.Errorf("Expected not found error after removal, got %#v", err)
+	}
+	if err := eb.RemoveTemplate("app"); !api.IsIDNotFound(err) {
+		t.Errorf("Expected not found error for second removal, got %#v", err)
+	}
+}
+
+func TestAddTemplateValidation(t *testing.T) {
+	eb := newTestEtcdBackend()
+	tests := []api.FrontendRecord{
+		api.FrontendRecord{Selectors: []api.FrontendSelectorRecord{api.FrontendSelectorRecord{Domain: "foo.com"}}},
+		api.FrontendRecord{Service: "web"},
+		api.FrontendRecord{Service: "{{.app", Selectors: []api.FrontendSelectorRecord{api.FrontendSelectorRecord{Domain: "foo.com"}}},
+	}
+	for i, tmpl := range tests {
+		if err := eb.AddTemplate("app", tmpl); !api.IsValidation(err) {
+			t.Errorf("Test %d: expected validation error, got %#v", i, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+
+	"github.com/juju/errgo"
+	api "github.com/pulcy/robin-api"
+)
+
+const (
+	tenantPrefix = "tenant"
+)
+
+// ForTenant returns an API that manages the frontend records of the given tenant only.
+// These records are stored under <prefix>/tenant/<name>/frontend.
+func (eb *etcdBackend) ForTenant(t Tenant) api.API {
+	tb := *eb
+	tb.tenant = &t
+	return &tb
+}
+
+// frontendRoot returns the ETCD path under which the frontend records
+// (of the tenant) of this backend are stored.
+func (eb *etcdBackend) frontendRoot() string {
+	if eb.tenant == nil {
+		return path.Join(eb.prefix, frontEndPrefix)
+	}
+	return path.Join(eb.prefix, tenantPrefix, eb.tenant.Name, frontEndPrefix)
+}
+
+// prepareTenantRecord sets the tenant of the given record that is about to be stored
+// under the given ID and checks that the limits of the tenant are not exceeded.
+func (eb *etcdBackend) prepareTenantRecord(id string, record *api.FrontendRecord) error {
+	if eb.tenant == nil {
+		record.Tenant = ""
+		return nil
+	}
+	record.Tenant = eb.tenant.Name
+	all, err := eb.All()
+	if err != nil {
+		return maskAny(err)
+	}
+	all[id] = *record
+	if err := eb.tenant.CheckLimits(all); err != nil {
+		return maskAny(err)
+	}
+	if err := eb.checkTenantOwnership(context.Background(), *record); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// checkTenantOwnership returns an error when the given record of the tenant uses a domain or service
+// that is used by the records of another owner (the backend itself or another tenant).
+func (eb *etcdBackend) checkTenantOwnership(ctx context.Context, record api.FrontendRecord) error {
+	records, err := eb.readFrontEndsByOwner(ctx)
+	if err != nil {
+		return maskAny(err)
+	}
+	ownPrefix := eb.tenant.Name + "/"
+	for owner, other := range records {
+		if strings.HasPrefix(owner, ownPrefix) {
+			continue
+		}
+		if other.Service == record.Service {
+			return maskAny(errgo.WithCausef(nil, api.ValidationError, "service '%s' is owned by another tenant", record.Service))
+		}
+		for _, sel := range record.AllSelectors() {
+			if sel.Domain == "" {
+				continue
+			}
+			for _, otherSel := range other.AllSelectors() {
+				if strings.EqualFold(otherSel.Domain, sel.Domain) {
+					return maskAny(errgo.WithCausef(nil, api.ValidationError, "domain '%s' is owned by another tenant", sel.Domain))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// readTenantFrontEndsTree loads the frontend records of all tenants.
+func (eb *etcdBackend) readTenantFrontEndsTree(ctx context.Context) ([]api.FrontendRecord, error) {
+	etcdPath := path.Join(eb.prefix, tenantPrefix)
+	nodes, err := eb.store.ListRecursive(ctx, etcdPath)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	var list []api.FrontendRecord
+	for _, frontEndNode := range nodes {
+		// Keys are formatted as <tenant>/frontend/<id>
+		parts := strings.Split(relativeKey(etcdPath, frontEndNode.Key), "/")
+		if len(parts) != 3 || parts[1] != frontEndPrefix {
+			continue
+		}
+		record := api.FrontendRecord{}
+		if err := json.Unmarshal([]byte(frontEndNode.Value), &record); err != nil {
+			eb.Logger.Errorf("Cannot unmarshal registration of %s", frontEndNode.Key)
+			continue
+		}
+		record.Tenant = parts[0]
+		list = append(list, record)
+	}
+	return list, nil
+}
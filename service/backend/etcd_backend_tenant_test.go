@@ -0,0 +1,65 @@
+package backend
+
+import (
+	"testing"
+
+	logging "github.com/op/go-logging"
+	api "github.com/pulcy/robin-api"
+)
+
+// newTestEtcdBackend creates an etcd backend that stores its data in memory.
+func newTestEtcdBackend() *etcdBackend {
+	return &etcdBackend{
+		statusTracker: &statusTracker{},
+		config:        k8sTestConfig,
+		store:         newFakeStore(),
+		Logger:        logging.MustGetLogger("test"),
+		prefix:        "/pulcy",
+	}
+}
+
+func newTestRecord(service, domain string) api.FrontendRecord {
+	return api.FrontendRecord{
+		Service: service,
+		Selectors: []api.FrontendSelectorRecord{
+			api.FrontendSelectorRecord{Domain: domain},
+		},
+	}
+}
+
+func TestTenantOwnership(t *testing.T) {
+	eb := newTestEtcdBackend()
+	if err := eb.Add("admin", newTestRecord("admin", "admin.com")); err != nil {
+		t.Fatalf("Add failed: %#v", err)
+	}
+	a := eb.ForTenant(Tenant{Name: "a", Tokens: []string{"ta"}})
+	b := eb.ForTenant(Tenant{Name: "b", Tokens: []string{"tb"}})
+	if err := a.Add("web", newTestRecord("web-a", "a.com")); err != nil {
+		t.Fatalf("Add failed: %#v", err)
+	}
+	// A tenant can update its own records
+	if err := a.(api.VersionedAPI).Update("web", newTestRecord("web-a", "www.a.com"), ""); err != nil {
+		t.Errorf("Update of own record failed: %#v", err)
+	}
+	tests := []struct {
+		Record api.FrontendRecord
+		Valid  bool
+	}{
+		{newTestRecord("web-b", "b.com"), true},
+		{newTestRecord("web-b", "WWW.A.com"), false}, // Domain of tenant a
+		{newTestRecord("web-a", "b.com"), false},     // Service of tenant a
+		{newTestRecord("web-b", "admin.com"), false}, // Domain of the backend itself
+		{newTestRecord("admin", "b.com"), false},     // Service of the backend itself
+	}
+	for i, test := range tests {
+		err := b.Add("web", test.Record)
+		if test.Valid && err != nil {
+			t.Errorf("Test %d: expected success, got %#v", i, err)
+		} else if !test.Valid && !api.IsValidation(err) {
+			t.Errorf("Test %d: expected validation error, got %#v", i, err)
+		}
+		if err == nil {
+			b.Remove("web")
+		}
+	}
+}
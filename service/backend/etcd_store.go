@@ -0,0 +1,96 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/juju/errgo"
+)
+
+var (
+	keyNotFoundError = errgo.New("key not found")
+	keyExistsError   = errgo.New("key already exists")
+	testFailedError  = errgo.New("key has been modified")
+)
+
+// etcdNode is a key and its value, as stored in ETCD.
+type etcdNode struct {
+	Key   string
+	Value string
+	Index uint64 // Modified index (v2) or modification revision (v3) of the key
+}
+
+// etcdNodesByKey sorts a list of nodes by key.
+type etcdNodesByKey []etcdNode
+
+func (l etcdNodesByKey) Len() int           { return len(l) }
+func (l etcdNodesByKey) Less(i, j int) bool { return l[i].Key < l[j].Key }
+func (l etcdNodesByKey) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// etcdStore provides the ETCD operations used by the etcd backend,
+// independent of the version of the ETCD API.
+type etcdStore interface {
+	// Watch waits for a change below the root of the store and returns when there is one.
+	// It returns the error of the given context when that is done before there is a change.
+	Watch(ctx context.Context) error
+
+	// Get returns the node with given key.
+	// If the key is not found, a keyNotFoundError is returned.
+	Get(ctx context.Context, key string) (etcdNode, error)
+	// List returns the nodes directly below the given directory, ordered by key.
+	// If the directory is not found, an empty list is returned.
+	List(ctx context.Context, dir string) ([]etcdNode, error)
+	// ListRecursive returns the nodes at any depth below the given directory, ordered by key.
+	// If the directory is not found, an empty list is returned.
+	ListRecursive(ctx context.Context, dir string) ([]etcdNode, error)
+
+	// Set stores the given value under the given key.
+	// If ttl is not 0, the key expires after that time.
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	// Create stores the given value under the given key, if that key does not exist yet.
+	// If the key already exists, a keyExistsError is returned.
+	Create(ctx context.Context, key, value string) error
+	// Update replaces the value of the given key.
+	// If the key is not found, a keyNotFoundError is returned.
+	// If index is not 0 and the key has been modified since that index, a testFailedError is returned.
+	Update(ctx context.Context, key, value string, index uint64) error
+	// Delete removes the given key.
+	// If the key is not found, a keyNotFoundError is returned.
+	// If index is not 0 and the key has been modified since that index, a testFailedError is returned.
+	Delete(ctx context.Context, key string, index uint64) error
+}
+
+func isKeyNotFound(err error) bool {
+	return errgo.Cause(err) == keyNotFoundError
+}
+
+func isKeyExists(err error) bool {
+	return errgo.Cause(err) == keyExistsError
+}
+
+func isTestFailed(err error) bool {
+	return errgo.Cause(err) == testFailedError
+}
+
+// relativeKey returns the part of the given key below the given directory.
+// A leading '/' is optional in both.
+func relativeKey(dir, key string) string {
+	dir = strings.TrimPrefix(dir, "/")
+	key = strings.TrimPrefix(key, "/")
+	return strings.TrimPrefix(strings.TrimPrefix(key, dir), "/")
+}
@@ -0,0 +1,39 @@
+package backend
+
+import (
+	"testing"
+)
+
+func TestRelativeKey(t *testing.T) {
+	tests := []struct {
+		Dir      string
+		Key      string
+		Expected string
+	}{
+		{"/pulcy/tenant", "/pulcy/tenant/foo/frontend/x", "foo/frontend/x"},
+		{"pulcy/tenant", "/pulcy/tenant/foo/frontend/x", "foo/frontend/x"},
+		{"/pulcy/lua", "/pulcy/lua/script", "script"},
+		{"", "/lua/script", "lua/script"},
+	}
+	for _, test := range tests {
+		if result := relativeKey(test.Dir, test.Key); result != test.Expected {
+			t.Errorf("Relative key of '%s' in '%s': expected '%s', got '%s'", test.Key, test.Dir, test.Expected, result)
+		}
+	}
+}
+
+func TestEtcdV3Dir(t *testing.T) {
+	tests := []struct {
+		Dir      string
+		Expected string
+	}{
+		{"/pulcy/frontend", "/pulcy/frontend/"},
+		{"/pulcy/frontend/", "/pulcy/frontend/"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if result := etcdV3Dir(test.Dir); result != test.Expected {
+			t.Errorf("Prefix of '%s': expected '%s', got '%s'", test.Dir, test.Expected, result)
+		}
+	}
+}
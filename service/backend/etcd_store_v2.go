@@ -0,0 +1,218 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/juju/errgo"
+	"github.com/op/go-logging"
+)
+
+// etcdV2Store implements etcdStore using the ETCD v2 keys API.
+type etcdV2Store struct {
+	client            client.Client
+	watcher           client.Watcher
+	root              string
+	logger            *logging.Logger
+	recentWatchErrors int
+}
+
+// newEtcdV2Store creates an etcdStore using the ETCD v2 keys API that watches the given root.
+func newEtcdV2Store(c client.Client, root string, logger *logging.Logger) *etcdV2Store {
+	return &etcdV2Store{
+		client: c,
+		root:   root,
+		logger: logger,
+	}
+}
+
+// Watch waits for a change below the root and returns when there is one.
+// If the watcher has fallen so far behind that etcd no longer has the events it missed
+// (index cleared), the watcher is reset to the current index and a change is reported,
+// so all services are reloaded.
+func (s *etcdV2Store) Watch(ctx context.Context) error {
+	if s.watcher == nil || s.recentWatchErrors > recentWatchErrorsMax {
+		s.recentWatchErrors = 0
+		s.resetWatcher(0)
+	}
+	_, err := s.watcher.Next(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			// Not a failure of the watcher, so it can be used again
+			return maskAny(ctx.Err())
+		}
+		if cerr, ok := errgo.Cause(err).(client.Error); ok && cerr.Code == client.ErrorCodeEventIndexCleared {
+			s.logger.Warningf("Watcher missed events (%s), resetting it to index %d", cerr.Message, cerr.Index)
+			watchResets.Inc()
+			s.recentWatchErrors = 0
+			s.resetWatcher(cerr.Index)
+			return nil
+		}
+		s.recentWatchErrors++
+		return maskAny(err)
+	}
+	s.recentWatchErrors = 0
+	return nil
+}
+
+// resetWatcher creates a new watcher that reports changes after the given index (0 means from now).
+func (s *etcdV2Store) resetWatcher(afterIndex uint64) {
+	kAPI := client.NewKeysAPI(s.client)
+	options := &client.WatcherOptions{
+		AfterIndex: afterIndex,
+		Recursive:  true,
+	}
+	s.watcher = kAPI.Watcher(s.root, options)
+}
+
+// Get returns the node with given key.
+func (s *etcdV2Store) Get(ctx context.Context, key string) (etcdNode, error) {
+	kAPI := client.NewKeysAPI(s.client)
+	resp, err := kAPI.Get(ctx, key, nil)
+	if err != nil {
+		return etcdNode{}, maskAny(mapEtcdV2Error(err))
+	}
+	if resp.Node == nil {
+		return etcdNode{}, maskAny(keyNotFoundError)
+	}
+	return newEtcdV2Node(resp.Node), nil
+}
+
+// List returns the nodes directly below the given directory, ordered by key.
+func (s *etcdV2Store) List(ctx context.Context, dir string) ([]etcdNode, error) {
+	kAPI := client.NewKeysAPI(s.client)
+	resp, err := kAPI.Get(ctx, dir, &client.GetOptions{Sort: true})
+	if isEtcdError(err, client.ErrorCodeKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	var result []etcdNode
+	if resp.Node == nil {
+		return result, nil
+	}
+	for _, node := range resp.Node.Nodes {
+		if !node.Dir {
+			result = append(result, newEtcdV2Node(node))
+		}
+	}
+	return result, nil
+}
+
+// ListRecursive returns the nodes at any depth below the given directory, ordered by key.
+func (s *etcdV2Store) ListRecursive(ctx context.Context, dir string) ([]etcdNode, error) {
+	kAPI := client.NewKeysAPI(s.client)
+	resp, err := kAPI.Get(ctx, dir, &client.GetOptions{Recursive: true, Sort: true})
+	if isEtcdError(err, client.ErrorCodeKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	var result []etcdNode
+	var collect func(nodes client.Nodes)
+	collect = func(nodes client.Nodes) {
+		for _, node := range nodes {
+			if node.Dir {
+				collect(node.Nodes)
+			} else {
+				result = append(result, newEtcdV2Node(node))
+			}
+		}
+	}
+	if resp.Node != nil {
+		collect(resp.Node.Nodes)
+	}
+	sort.Sort(etcdNodesByKey(result))
+	return result, nil
+}
+
+// Set stores the given value under the given key.
+func (s *etcdV2Store) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	kAPI := client.NewKeysAPI(s.client)
+	if _, err := kAPI.Set(ctx, key, value, &client.SetOptions{TTL: ttl}); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// Create stores the given value under the given key, if that key does not exist yet.
+func (s *etcdV2Store) Create(ctx context.Context, key, value string) error {
+	kAPI := client.NewKeysAPI(s.client)
+	options := &client.SetOptions{
+		PrevExist: client.PrevNoExist,
+	}
+	if _, err := kAPI.Set(ctx, key, value, options); err != nil {
+		return maskAny(mapEtcdV2Error(err))
+	}
+	return nil
+}
+
+// Update replaces the value of the given key.
+func (s *etcdV2Store) Update(ctx context.Context, key, value string, index uint64) error {
+	kAPI := client.NewKeysAPI(s.client)
+	options := &client.SetOptions{
+		PrevExist: client.PrevExist,
+		PrevIndex: index,
+	}
+	if _, err := kAPI.Set(ctx, key, value, options); err != nil {
+		return maskAny(mapEtcdV2Error(err))
+	}
+	return nil
+}
+
+// Delete removes the given key.
+func (s *etcdV2Store) Delete(ctx context.Context, key string, index uint64) error {
+	kAPI := client.NewKeysAPI(s.client)
+	options := &client.DeleteOptions{
+		PrevIndex: index,
+	}
+	if _, err := kAPI.Delete(ctx, key, options); err != nil {
+		return maskAny(mapEtcdV2Error(err))
+	}
+	return nil
+}
+
+func newEtcdV2Node(node *client.Node) etcdNode {
+	return etcdNode{
+		Key:   node.Key,
+		Value: node.Value,
+		Index: node.ModifiedIndex,
+	}
+}
+
+// mapEtcdV2Error converts ETCD v2 errors into the errors of etcdStore.
+func mapEtcdV2Error(err error) error {
+	switch {
+	case isEtcdError(err, client.ErrorCodeKeyNotFound):
+		return keyNotFoundError
+	case isEtcdError(err, client.ErrorCodeNodeExist):
+		return keyExistsError
+	case isEtcdError(err, client.ErrorCodeTestFailed):
+		return testFailedError
+	default:
+		return err
+	}
+}
+
+func isEtcdError(err error, code int) bool {
+	cerr, ok := errgo.Cause(err).(client.Error)
+	return ok && cerr.Code == code
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/juju/errgo"
+	"github.com/op/go-logging"
+)
+
+var (
+	watchClosedError = errgo.New("watch closed")
+)
+
+// etcdV3Store implements etcdStore using the ETCD v3 API.
+// Directories do not exist in v3, a directory is the prefix '<dir>/' of the keys below it.
+type etcdV3Store struct {
+	client        *clientv3.Client
+	root          string
+	logger        *logging.Logger
+	watchChan     clientv3.WatchChan
+	watchCancel   context.CancelFunc
+	watchRevision int64 // Revision from which the next watch starts (0 means from now)
+}
+
+// newEtcdV3Store creates an etcdStore using the ETCD v3 API that watches the given root.
+func newEtcdV3Store(c *clientv3.Client, root string, logger *logging.Logger) *etcdV3Store {
+	return &etcdV3Store{
+		client: c,
+		root:   root,
+		logger: logger,
+	}
+}
+
+// Watch waits for a change below the root and returns when there is one.
+// Consecutive calls continue at the revision after the last reported change, so no change is missed.
+// If that revision has been compacted, the watch is restarted at the oldest available revision
+// and a change is reported, so all services are reloaded.
+func (s *etcdV3Store) Watch(ctx context.Context) error {
+	if s.watchChan == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		options := []clientv3.OpOption{clientv3.WithPrefix()}
+		if s.watchRevision > 0 {
+			options = append(options, clientv3.WithRev(s.watchRevision))
+		}
+		s.watchChan = s.client.Watch(ctx, etcdV3Dir(s.root), options...)
+		s.watchCancel = cancel
+	}
+	var resp clientv3.WatchResponse
+	var ok bool
+	select {
+	case resp, ok = <-s.watchChan:
+	case <-ctx.Done():
+		// The watch continues, so the next call will not miss a change
+		return maskAny(ctx.Err())
+	}
+	if !ok {
+		s.resetWatch()
+		return maskAny(watchClosedError)
+	}
+	if resp.CompactRevision != 0 {
+		s.logger.Warningf("Watcher missed events (compacted), resetting it to revision %d", resp.CompactRevision)
+		watchResets.Inc()
+		s.resetWatch()
+		s.watchRevision = resp.CompactRevision
+		return nil
+	}
+	if err := resp.Err(); err != nil {
+		s.resetWatch()
+		return maskAny(err)
+	}
+	s.watchRevision = resp.Header.Revision + 1
+	return nil
+}
+
+// resetWatch cancels the current watch (if any), so the next call to Watch starts a new one.
+func (s *etcdV3Store) resetWatch() {
+	if s.watchCancel != nil {
+		s.watchCancel()
+	}
+	s.watchChan = nil
+	s.watchCancel = nil
+}
+
+// Get returns the node with given key.
+func (s *etcdV3Store) Get(ctx context.Context, key string) (etcdNode, error) {
+	resp, err := s.client.Get(ctx, key)
+	if err != nil {
+		return etcdNode{}, maskAny(err)
+	}
+	if len(resp.Kvs) == 0 {
+		return etcdNode{}, maskAny(keyNotFoundError)
+	}
+	return newEtcdV3Node(resp.Kvs[0]), nil
+}
+
+// List returns the nodes directly below the given directory, ordered by key.
+func (s *etcdV3Store) List(ctx context.Context, dir string) ([]etcdNode, error) {
+	all, err := s.ListRecursive(ctx, dir)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	var result []etcdNode
+	for _, node := range all {
+		if !strings.Contains(relativeKey(dir, node.Key), "/") {
+			result = append(result, node)
+		}
+	}
+	return result, nil
+}
+
+// ListRecursive returns the nodes at any depth below the given directory, ordered by key.
+func (s *etcdV3Store) ListRecursive(ctx context.Context, dir string) ([]etcdNode, error) {
+	resp, err := s.client.Get(ctx, etcdV3Dir(dir), clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	result := make([]etcdNode, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		result = append(result, newEtcdV3Node(kv))
+	}
+	return result, nil
+}
+
+// Set stores the given value under the given key.
+// If ttl is not 0, the key is attached to a new lease with that TTL.
+func (s *etcdV3Store) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	var options []clientv3.OpOption
+	if ttl != 0 {
+		lease, err := s.client.Grant(ctx, etcdV3LeaseTTL(ttl))
+		if err != nil {
+			return maskAny(err)
+		}
+		options = append(options, clientv3.WithLease(lease.ID))
+	}
+	if _, err := s.client.Put(ctx, key, value, options...); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// Create stores the given value under the given key, if that key does not exist yet.
+func (s *etcdV3Store) Create(ctx context.Context, key, value string) error {
+	resp, err := s.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, value)).
+		Commit()
+	if err != nil {
+		return maskAny(err)
+	}
+	if !resp.Succeeded {
+		return maskAny(keyExistsError)
+	}
+	return nil
+}
+
+// Update replaces the value of the given key.
+func (s *etcdV3Store) Update(ctx context.Context, key, value string, index uint64) error {
+	if err := s.commitIfUnmodified(ctx, key, index, clientv3.OpPut(key, value)); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// Delete removes the given key.
+func (s *etcdV3Store) Delete(ctx context.Context, key string, index uint64) error {
+	if err := s.commitIfUnmodified(ctx, key, index, clientv3.OpDelete(key)); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// commitIfUnmodified performs the given operation in a transaction that only succeeds
+// when the given key exists and (if index is not 0) has not been modified since that index.
+func (s *etcdV3Store) commitIfUnmodified(ctx context.Context, key string, index uint64, op clientv3.Op) error {
+	cmp := clientv3.Compare(clientv3.CreateRevision(key), ">", 0)
+	if index != 0 {
+		cmp = clientv3.Compare(clientv3.ModRevision(key), "=", int64(index))
+	}
+	resp, err := s.client.Txn(ctx).
+		If(cmp).
+		Then(op).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return maskAny(err)
+	}
+	if !resp.Succeeded {
+		if len(resp.Responses) == 0 || len(resp.Responses[0].GetResponseRange().Kvs) == 0 {
+			return maskAny(keyNotFoundError)
+		}
+		return maskAny(testFailedError)
+	}
+	return nil
+}
+
+func newEtcdV3Node(kv *mvccpb.KeyValue) etcdNode {
+	return etcdNode{
+		Key:   string(kv.Key),
+		Value: string(kv.Value),
+		Index: uint64(kv.ModRevision),
+	}
+}
+
+// etcdV3Dir returns the prefix of all keys below the given directory.
+func etcdV3Dir(dir string) string {
+	if dir == "" || strings.HasSuffix(dir, "/") {
+		return dir
+	}
+	return dir + "/"
+}
+
+// etcdV3LeaseTTL converts the given TTL into the TTL of a lease (in seconds, at least 1).
+func etcdV3LeaseTTL(ttl time.Duration) int64 {
+	seconds := int64(ttl / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+)
+
+const (
+	DefaultEtcdSyncInterval   = time.Second * 30
+	DefaultEtcdSyncMaxBackoff = time.Minute * 2
+	etcdSyncMinBackoff        = time.Second
+	etcdSyncTimeout           = time.Second * 10
+	etcdHealthTimeout         = time.Second * 5
+	etcdSyncResetAfter        = 3 // Number of consecutive failures after which the client is reset
+)
+
+// EtcdSyncConfig specifies how the endpoints of an etcd client are kept up to date.
+type EtcdSyncConfig struct {
+	Endpoints  []string      // Configured endpoints, used to reset the client
+	Interval   time.Duration // Time between syncs
+	MaxBackoff time.Duration // Maximum time between retries of a failed sync
+}
+
+// AutoSyncEtcd periodically syncs the endpoints of the given client with the members of the etcd cluster,
+// until the given context is canceled.
+// Failed syncs are retried with exponential backoff. When syncing keeps failing, or none of the endpoints
+// is healthy, the client is reset to the configured endpoints, so DNS names are resolved again and
+// a changed cluster topology is picked up.
+func AutoSyncEtcd(ctx context.Context, c client.Client, config EtcdSyncConfig, logger *logging.Logger) {
+	if config.Interval <= 0 {
+		config.Interval = DefaultEtcdSyncInterval
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultEtcdSyncMaxBackoff
+	}
+	health := make(map[string]bool)
+	failures := 0
+	for {
+		err := syncEtcd(ctx, c)
+		if err == nil && !checkEtcdHealth(c.Endpoints(), health, logger) {
+			err = maskAny(client.ErrClusterUnavailable)
+		}
+		wait := config.Interval
+		if err != nil {
+			failures++
+			etcdSyncFailures.Inc()
+			wait = etcdSyncBackoff(failures, config.MaxBackoff)
+			logger.Warningf("Failed to sync etcd endpoints (%d times), retrying in %s: %v", failures, wait, err)
+			if failures%etcdSyncResetAfter == 0 {
+				resetEtcdClient(c, config.Endpoints, logger)
+			}
+		} else {
+			if failures > 0 {
+				logger.Infof("Synced etcd endpoints after %d failures: %s", failures, strings.Join(c.Endpoints(), ", "))
+			}
+			failures = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// syncEtcd syncs the endpoints of the given client once.
+func syncEtcd(ctx context.Context, c client.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, etcdSyncTimeout)
+	defer cancel()
+	if err := c.Sync(ctx); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// checkEtcdHealth checks the health of all given endpoints, logging the ones that changed.
+// It returns true if at least one endpoint is healthy.
+func checkEtcdHealth(endpoints []string, health map[string]bool, logger *logging.Logger) bool {
+	httpClient := &http.Client{Timeout: etcdHealthTimeout}
+	seen := make(map[string]struct{})
+	result := false
+	etcdEndpointHealthy.Reset()
+	for _, ep := range endpoints {
+		seen[ep] = struct{}{}
+		healthy := false
+		if resp, err := httpClient.Get(strings.TrimSuffix(ep, "/") + "/health"); err == nil {
+			healthy = resp.StatusCode == http.StatusOK
+			resp.Body.Close()
+		}
+		if last, ok := health[ep]; ok && last != healthy {
+			if healthy {
+				logger.Infof("Etcd endpoint %s is healthy again", ep)
+			} else {
+				logger.Warningf("Etcd endpoint %s is unhealthy", ep)
+			}
+		}
+		health[ep] = healthy
+		etcdEndpointHealthy.WithLabelValues(ep).Set(boolToFloat(healthy))
+		result = result || healthy
+	}
+	// Forget endpoints that are no longer used
+	for ep := range health {
+		if _, ok := seen[ep]; !ok {
+			delete(health, ep)
+		}
+	}
+	return result
+}
+
+// resetEtcdClient replaces the endpoints of the given client with the configured endpoints
+// and drops idle connections, so the next request dials (and resolves) them again.
+func resetEtcdClient(c client.Client, endpoints []string, logger *logging.Logger) {
+	logger.Warningf("Resetting etcd client to endpoints %s", strings.Join(endpoints, ", "))
+	if err := c.SetEndpoints(endpoints); err != nil {
+		logger.Errorf("Failed to reset etcd endpoints: %#v", err)
+		return
+	}
+	if t, ok := client.DefaultTransport.(interface {
+		CloseIdleConnections()
+	}); ok {
+		t.CloseIdleConnections()
+	}
+	etcdClientResets.Inc()
+}
+
+// etcdSyncBackoff returns the time to wait after the given number of consecutive failures.
+func etcdSyncBackoff(failures int, max time.Duration) time.Duration {
+	result := etcdSyncMinBackoff
+	for i := 1; i < failures && result < max; i++ {
+		result *= 2
+	}
+	if result > max {
+		return max
+	}
+	return result
+}
+
+func boolToFloat(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,24 @@
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEtcdSyncBackoff(t *testing.T) {
+	tests := []struct {
+		Failures int
+		Expected time.Duration
+	}{
+		{1, time.Second},
+		{2, time.Second * 2},
+		{5, time.Second * 16},
+		{8, time.Minute},
+		{100, time.Minute},
+	}
+	for _, test := range tests {
+		if d := etcdSyncBackoff(test.Failures, time.Minute); d != test.Expected {
+			t.Errorf("Backoff after %d failures: expected %s, got %s", test.Failures, test.Expected, d)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package backend
+
+import (
+	"encoding/json"
+	"sync/atomic"
+
+	k8s "github.com/YakLabs/k8s-client"
+)
+
+// fakeClient is a k8s.Client that serves a fixed set of resources through its watch functions.
+// Methods that are not used by the resource registry are not implemented and will panic.
+type fakeClient struct {
+	k8s.Client
+
+	ingresses      []k8s.Ingress
+	endpoints      []k8s.Endpoints
+	endpointSlices []k8s.EndpointSlice // If nil, the client behaves like an apiserver without endpoint slices
+	secrets        []k8s.Secret
+	frontends      []RobinFrontend
+	pods           []k8s.Pod
+
+	runningWatches int32 // Number of watches waiting for changes (accessed atomically)
+}
+
+type fakeIngressEvent struct {
+	object  k8s.Ingress
+	deleted bool
+}
+
+func (e fakeIngressEvent) Type() k8s.WatchEventType {
+	if e.deleted {
+		return k8s.WatchEventTypeDeleted
+	}
+	return k8s.WatchEventTypeAdded
+}
+func (e fakeIngressEvent) Object() (*k8s.Ingress, error) {
+	object := e.object
+	return &object, nil
+}
+
+type fakeEndpointsEvent struct {
+	object k8s.Endpoints
+}
+
+func (e fakeEndpointsEvent) Type() k8s.WatchEventType { return k8s.WatchEventTypeAdded }
+func (e fakeEndpointsEvent) Object() (*k8s.Endpoints, error) {
+	object := e.object
+	return &object, nil
+}
+
+type fakeEndpointSliceEvent struct {
+	object k8s.EndpointSlice
+}
+
+func (e fakeEndpointSliceEvent) Type() k8s.WatchEventType { return k8s.WatchEventTypeAdded }
+func (e fakeEndpointSliceEvent) Object() (*k8s.EndpointSlice, error) {
+	object := e.object
+	return &object, nil
+}
+
+type fakeSecretEvent struct {
+	object  k8s.Secret
+	deleted bool
+}
+
+func (e fakeSecretEvent) Type() k8s.WatchEventType {
+	if e.deleted {
+		return k8s.WatchEventTypeDeleted
+	}
+	return k8s.WatchEventTypeAdded
+}
+func (e fakeSecretEvent) Object() (*k8s.Secret, error) {
+	object := e.object
+	return &object, nil
+}
+
+type fakePodEvent struct {
+	object k8s.Pod
+}
+
+func (e fakePodEvent) Type() k8s.WatchEventType { return k8s.WatchEventTypeAdded }
+func (e fakePodEvent) Object() (*k8s.Pod, error) {
+	object := e.object
+	return &object, nil
+}
+
+// eventCount returns the number of change events the registry with given config will trigger for all resources.
+func (c *fakeClient) eventCount(config KubernetesConfig) int {
+	result := 0
+	for _, namespace := range config.watchNamespaces() {
+		for _, x := range c.ingresses {
+			if watchMatches(namespace, config.ingressWatchOptions(), x.ObjectMeta) && config.matchesIngressClass(x) {
+				result++
+			}
+		}
+		if c.endpointSlices == nil || config.LegacyEndpoints {
+			for _, x := range c.endpoints {
+				if watchMatches(namespace, nil, x.ObjectMeta) {
+					result++
+				}
+			}
+		} else {
+			for _, x := range c.endpointSlices {
+				if watchMatches(namespace, nil, x.ObjectMeta) {
+					result++
+				}
+			}
+		}
+		if config.SslCertsFolder != "" {
+			for _, x := range c.secrets {
+				if watchMatches(namespace, nil, x.ObjectMeta) {
+					result++
+				}
+			}
+		}
+		if config.RobinFrontends {
+			for _, x := range c.frontends {
+				if watchMatches(namespace, nil, x.ObjectMeta) {
+					result++
+				}
+			}
+		}
+		if config.PodWeights {
+			for _, x := range c.pods {
+				// Only pods with a weight annotation trigger a change
+				if _, found := x.Annotations[PodWeightAnnotationKey]; found && watchMatches(namespace, nil, x.ObjectMeta) {
+					result++
+				}
+			}
+		}
+	}
+	return result
+}
+
+// watchMatches returns true if a watch in the given namespace with given options reports the resource with given metadata.
+func watchMatches(namespace string, opts *k8s.WatchOptions, meta k8s.ObjectMeta) bool {
+	if namespace != "" && meta.Namespace != namespace {
+		return false
+	}
+	if opts != nil {
+		for key, value := range opts.LabelSelector.MatchLabels {
+			if meta.Labels[key] != value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (c *fakeClient) WatchNodes(opts *k8s.WatchOptions, events chan k8s.NodeWatchEvent) error {
+	defer close(events)
+	return c.waitWatch(opts)
+}
+
+func (c *fakeClient) WatchServices(namespace string, opts *k8s.WatchOptions, events chan k8s.ServiceWatchEvent) error {
+	defer close(events)
+	return c.waitWatch(opts)
+}
+
+func (c *fakeClient) WatchIngresses(namespace string, opts *k8s.WatchOptions, events chan k8s.IngressWatchEvent) error {
+	defer close(events)
+	for _, x := range c.ingresses {
+		if watchMatches(namespace, opts, x.ObjectMeta) {
+			events <- fakeIngressEvent{object: x}
+		}
+	}
+	return c.waitWatch(opts)
+}
+
+func (c *fakeClient) WatchEndpoints(namespace string, opts *k8s.WatchOptions, events chan k8s.EndpointsWatchEvent) error {
+	defer close(events)
+	for _, x := range c.endpoints {
+		if watchMatches(namespace, opts, x.ObjectMeta) {
+			events <- fakeEndpointsEvent{object: x}
+		}
+	}
+	return c.waitWatch(opts)
+}
+
+func (c *fakeClient) WatchEndpointSlices(namespace string, opts *k8s.WatchOptions, events chan k8s.EndpointSliceWatchEvent) error {
+	defer close(events)
+	if c.endpointSlices == nil {
+		return &k8s.Status{Code: 404}
+	}
+	for _, x := range c.endpointSlices {
+		if watchMatches(namespace, opts, x.ObjectMeta) {
+			events <- fakeEndpointSliceEvent{object: x}
+		}
+	}
+	return c.waitWatch(opts)
+}
+
+func (c *fakeClient) WatchSecrets(namespace string, opts *k8s.WatchOptions, events chan k8s.SecretWatchEvent) error {
+	defer close(events)
+	for _, x := range c.secrets {
+		if watchMatches(namespace, nil, x.ObjectMeta) {
+			events <- fakeSecretEvent{object: x}
+		}
+	}
+	return c.waitWatch(opts)
+}
+
+func (c *fakeClient) WatchPods(namespace string, opts *k8s.WatchOptions, events chan k8s.PodWatchEvent) error {
+	defer close(events)
+	for _, x := range c.pods {
+		if watchMatches(namespace, opts, x.ObjectMeta) {
+			events <- fakePodEvent{object: x}
+		}
+	}
+	return c.waitWatch(opts)
+}
+
+func (c *fakeClient) WatchCustomResources(group, version, plural, namespace string, opts *k8s.WatchOptions, events chan k8s.WatchEvent) error {
+	defer close(events)
+	for _, x := range c.frontends {
+		if watchMatches(namespace, opts, x.ObjectMeta) {
+			raw, err := json.Marshal(x)
+			if err != nil {
+				return err
+			}
+			events <- k8s.WatchEvent{Type: k8s.WatchEventTypeAdded, Object: raw}
+		}
+	}
+	return c.waitWatch(opts)
+}
+
+// waitWatch blocks like a watch without further changes, until the context of the given options is canceled.
+func (c *fakeClient) waitWatch(opts *k8s.WatchOptions) error {
+	atomic.AddInt32(&c.runningWatches, 1)
+	defer atomic.AddInt32(&c.runningWatches, -1)
+	<-opts.Context.Done()
+	return opts.Context.Err()
+}
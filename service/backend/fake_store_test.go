@@ -0,0 +1,117 @@
+package backend
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeStore is an etcdStore that keeps all keys in memory.
+// Keys do not expire (their TTL is only recorded) and Watch never reports a change.
+type fakeStore struct {
+	mutex sync.Mutex
+	nodes map[string]etcdNode
+	ttls  map[string]time.Duration
+	index uint64
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{nodes: make(map[string]etcdNode), ttls: make(map[string]time.Duration)}
+}
+
+func (s *fakeStore) Watch(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (s *fakeStore) Get(ctx context.Context, key string) (etcdNode, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	node, ok := s.nodes[path.Clean(key)]
+	if !ok {
+		return etcdNode{}, maskAny(keyNotFoundError)
+	}
+	return node, nil
+}
+
+func (s *fakeStore) List(ctx context.Context, dir string) ([]etcdNode, error) {
+	return s.list(dir, false), nil
+}
+
+func (s *fakeStore) ListRecursive(ctx context.Context, dir string) ([]etcdNode, error) {
+	return s.list(dir, true), nil
+}
+
+func (s *fakeStore) list(dir string, recursive bool) []etcdNode {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	prefix := path.Clean(dir) + "/"
+	result := []etcdNode{}
+	for key, node := range s.nodes {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if !recursive && strings.Contains(key[len(prefix):], "/") {
+			continue
+		}
+		result = append(result, node)
+	}
+	sort.Sort(etcdNodesByKey(result))
+	return result
+}
+
+func (s *fakeStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.put(key, value)
+	s.ttls[path.Clean(key)] = ttl
+	return nil
+}
+
+func (s *fakeStore) Create(ctx context.Context, key, value string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.nodes[path.Clean(key)]; ok {
+		return maskAny(keyExistsError)
+	}
+	s.put(key, value)
+	return nil
+}
+
+func (s *fakeStore) Update(ctx context.Context, key, value string, index uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	node, ok := s.nodes[path.Clean(key)]
+	if !ok {
+		return maskAny(keyNotFoundError)
+	}
+	if index != 0 && node.Index != index {
+		return maskAny(testFailedError)
+	}
+	s.put(key, value)
+	return nil
+}
+
+func (s *fakeStore) Delete(ctx context.Context, key string, index uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	node, ok := s.nodes[path.Clean(key)]
+	if !ok {
+		return maskAny(keyNotFoundError)
+	}
+	if index != 0 && node.Index != index {
+		return maskAny(testFailedError)
+	}
+	delete(s.nodes, path.Clean(key))
+	delete(s.ttls, path.Clean(key))
+	return nil
+}
+
+func (s *fakeStore) put(key, value string) {
+	s.index++
+	key = path.Clean(key)
+	s.nodes[key] = etcdNode{Key: key, Value: value, Index: s.index}
+}
@@ -15,11 +15,13 @@
 package backend
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/json"
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	k8s "github.com/YakLabs/k8s-client"
 	"github.com/op/go-logging"
@@ -29,54 +31,176 @@ import (
 
 const (
 	RobinFrontendRecordsAnnotationKey = "pulcy.com.robin.frontend.records"
+	IngressClassAnnotationKey         = "kubernetes.io/ingress.class"
+	PodWeightAnnotationKey            = "pulcy.com.robin.weight" // Relative weight (0-256, 0 means draining) of the instance of a pod
+
+	defaultWatchBackoff    = time.Second
+	defaultWatchMaxBackoff = time.Minute
+)
+
+var (
+	// Node labels containing the zone of a node, in order of preference
+	zoneLabels = []string{"topology.kubernetes.io/zone", "failure-domain.beta.kubernetes.io/zone"}
 )
 
+// KubernetesConfig limits the resources that are watched by the Kubernetes backend.
+type KubernetesConfig struct {
+	Namespaces           []string          // Namespaces to watch (empty means all namespaces)
+	IngressLabelSelector map[string]string // Labels that watched ingresses must have (empty means all ingresses)
+	IngressClass         string            // Class of the ingresses that are served (empty means all ingresses)
+	IngressWithoutClass  bool              // If set, ingresses without a class are also served when IngressClass is set
+	SslCertsFolder       string            // Folder to which the certificates of ingress TLS sections are written (empty means TLS sections are ignored)
+	RobinFrontends       bool              // If set, RobinFrontend custom resources are watched (requires their CustomResourceDefinition)
+	LegacyEndpoints      bool              // If set, Endpoints are watched instead of EndpointSlices (which are used when the apiserver serves them)
+	PodWeights           bool              // If set, pods are watched for their weight annotation
+	WatchBackoff         time.Duration     // Delay before retrying a failed watch, doubled with every consecutive failure (0 means defaultWatchBackoff)
+	WatchMaxBackoff      time.Duration     // Maximum delay before retrying a failed watch (0 means defaultWatchMaxBackoff)
+	WatchJitter          float64           // Fraction (0-1) of the retry delay that is randomized
+}
+
+// watchRetryDelay returns the delay before retrying a watch that failed the given number of consecutive times.
+// The given random number (in [0,1)) determines the jitter.
+func (c KubernetesConfig) watchRetryDelay(failures int, random float64) time.Duration {
+	delay, max := c.WatchBackoff, c.WatchMaxBackoff
+	if delay <= 0 {
+		delay = defaultWatchBackoff
+	}
+	if max <= 0 {
+		max = defaultWatchMaxBackoff
+	}
+	for i := 1; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	if jitter := c.WatchJitter; jitter > 0 {
+		if jitter > 1 {
+			jitter = 1
+		}
+		delay -= time.Duration(float64(delay) * jitter * random)
+	}
+	return delay
+}
+
+// matchesIngressClass returns true if the given ingress must be served according to its class.
+// The class annotation takes precedence over the IngressClassName field.
+func (c KubernetesConfig) matchesIngressClass(i k8s.Ingress) bool {
+	if c.IngressClass == "" {
+		return true
+	}
+	class, found := i.GetAnnotations()[IngressClassAnnotationKey]
+	if !found && i.Spec != nil && i.Spec.IngressClassName != nil {
+		class, found = *i.Spec.IngressClassName, true
+	}
+	if !found || class == "" {
+		return c.IngressWithoutClass
+	}
+	return class == c.IngressClass
+}
+
+// watchNamespaces returns the namespaces to watch, where an empty namespace means all namespaces.
+func (c KubernetesConfig) watchNamespaces() []string {
+	if len(c.Namespaces) == 0 {
+		return []string{""}
+	}
+	return c.Namespaces
+}
+
+// ingressWatchOptions returns the options used to watch ingresses.
+func (c KubernetesConfig) ingressWatchOptions() *k8s.WatchOptions {
+	if len(c.IngressLabelSelector) == 0 {
+		return nil
+	}
+	opts := &k8s.WatchOptions{}
+	opts.LabelSelector.MatchLabels = c.IngressLabelSelector
+	return opts
+}
+
+// ParseLabelSelector parses a label selector formatted as `key=value[,key=value]`.
+func ParseLabelSelector(selector string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, part := range strings.Split(selector, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || key == "" {
+			return nil, maskAny(fmt.Errorf("Invalid label selector '%s', expected key=value", part))
+		}
+		result[key] = strings.TrimSpace(kv[1])
+	}
+	return result, nil
+}
+
 type k8sBackend struct {
+	*statusTracker
+
 	config        BackendConfig
 	registry      *resourceRegistry
 	Logger        *logging.Logger
 	startRegistry sync.Once
-	watchCond     *sync.Cond
+	changes       chan struct{} // Changes of the registry, coalesced into a single pending change
+	ctx           context.Context
+	cancel        context.CancelFunc
 }
 
-func NewKubernetesBackend(config BackendConfig, logger *logging.Logger) (Backend, error) {
-	registry, err := newResourceRegistry(logger)
+func NewKubernetesBackend(config BackendConfig, k8sConfig KubernetesConfig, logger *logging.Logger) (Backend, error) {
+	registry, err := newResourceRegistry(k8sConfig, logger)
 	if err != nil {
 		return nil, maskAny(err)
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	tracker := &statusTracker{}
+	registry.reportWatch = tracker.recordResourceWatch
 	return &k8sBackend{
-		config:    config,
-		registry:  registry,
-		Logger:    logger,
-		watchCond: sync.NewCond(new(sync.Mutex)),
+		statusTracker: tracker,
+		config:        config,
+		registry:      registry,
+		Logger:        logger,
+		changes:       make(chan struct{}, 1),
+		ctx:           ctx,
+		cancel:        cancel,
 	}, nil
 }
 
 // Watch for changes on a path and return where there is a change.
-func (eb *k8sBackend) Watch() error {
-	eb.startRegistry.Do(func() { eb.start() })
+// Changes that happen while nobody is watching are returned by the next call.
+func (eb *k8sBackend) Watch(ctx context.Context) (err error) {
+	defer func() { eb.recordWatch(ctx, err) }()
+	eb.startRegistry.Do(func() { eb.registry.Start(eb.ctx, eb.notifyChange) })
 
 	// Wait for events from the registry
-	eb.watchCond.L.Lock()
-	eb.watchCond.Wait()
-	eb.watchCond.L.Unlock()
+	select {
+	case <-eb.changes:
+		return nil
+	case <-eb.ctx.Done():
+		return maskAny(eb.ctx.Err())
+	case <-ctx.Done():
+		return maskAny(ctx.Err())
+	}
+}
+
+// Close stops watching the apiserver.
+func (eb *k8sBackend) Close() error {
+	eb.cancel()
 	return nil
 }
 
-// start starts the resource registry and listens for its changes.
-func (eb *k8sBackend) start() {
-	onChange := make(chan struct{})
-	go func() {
-		for _ = range onChange {
-			// trigger Watch return
-			eb.watchCond.Broadcast()
-		}
-	}()
-	eb.registry.Start(onChange)
+// notifyChange records a change of the registry, unless a change is already pending.
+func (eb *k8sBackend) notifyChange() {
+	select {
+	case eb.changes <- struct{}{}:
+	default:
+	}
 }
 
 // Load all registered services
-func (eb *k8sBackend) Services() (ServiceRegistrations, error) {
+// The resources are served from the registry, so the context is not used.
+func (eb *k8sBackend) Services(ctx context.Context) (registrations ServiceRegistrations, err error) {
+	defer func() { eb.recordServices(err) }()
 	ingresses := eb.registry.GetIngresses()
 	result := ServiceRegistrations{}
 	for _, i := range ingresses {
@@ -86,10 +210,22 @@ func (eb *k8sBackend) Services() (ServiceRegistrations, error) {
 		}
 		result = append(result, srs...)
 	}
+	for _, f := range eb.registry.GetRobinFrontends() {
+		srs, err := eb.createServiceRegistrationsFromFrontendRecords(f.GetNamespace(), nil, []api.FrontendRecord{f.Spec})
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		result = append(result, srs...)
+	}
 
 	return result, nil
 }
 
+// LuaScripts returns no scripts, since they cannot be stored in Kubernetes.
+func (eb *k8sBackend) LuaScripts(ctx context.Context) (map[string]string, error) {
+	return nil, nil
+}
+
 // createServiceRegistrationsFromIngress creates all ServiceRegistrations needed for the given ingress.
 func (eb *k8sBackend) createServiceRegistrationsFromIngress(i k8s.Ingress) (ServiceRegistrations, error) {
 	// Look for FrontendRecord annotation
@@ -99,21 +235,38 @@ func (eb *k8sBackend) createServiceRegistrationsFromIngress(i k8s.Ingress) (Serv
 		if err := json.Unmarshal([]byte(raw), &frontendRecords); err != nil {
 			return nil, maskAny(err)
 		}
-		result, err := eb.createServiceRegistrationsFromFrontendRecords(i, frontendRecords)
+		result, err := eb.createServiceRegistrationsFromFrontendRecords(i.GetNamespace(), ingressBackends(i), frontendRecords)
 		if err != nil {
 			return nil, maskAny(err)
 		}
+		eb.addIngressTLSCertificates(i, result)
 		return result, nil
 	}
 
-	// Create ServiceRegistrations from raw ingresses
+	// Create ServiceRegistrations from raw ingresses.
+	// Raw ingresses are always part of the default edge group.
 	var result ServiceRegistrations
+	if eb.config.EdgeGroup != "" {
+		return result, nil
+	}
 	for _, rule := range i.Spec.Rules {
 		if rule.HTTP == nil {
 			continue
 		}
 		host := rule.Host
+		if host != "" {
+			if err := api.ValidateSelectorDomain(host); err != nil {
+				eb.Logger.Warningf("Ignoring rule of ingress %s.%s: %v", i.Name, i.GetNamespace(), err)
+				continue
+			}
+		}
 		for _, httpPath := range rule.HTTP.Paths {
+			if httpPath.Path != "" {
+				if err := api.ValidatePath(httpPath.Path); err != nil {
+					eb.Logger.Warningf("Ignoring path of ingress %s.%s: %v", i.Name, i.GetNamespace(), err)
+					continue
+				}
+			}
 			selector := ServiceSelector{
 				Domain: host,
 			}
@@ -132,42 +285,75 @@ func (eb *k8sBackend) createServiceRegistrationsFromIngress(i k8s.Ingress) (Serv
 				Sticky:          false,
 				Backup:          false,
 			}
-			ips, _, err := eb.listServicePodIPsByIngress(httpPath.Backend, i)
+			addrs, notReadyAddrs, err := eb.listServicePodAddressesByIngress(httpPath.Backend, i)
 			if err != nil {
 				return nil, maskAny(err)
 			}
-			for _, ip := range ips {
-				sr.Instances = append(sr.Instances, ServiceInstance{
-					IP:   ip,
+			for _, addr := range addrs {
+				sr.Instances = append(sr.Instances, newServiceInstance(regapi.ServiceInstance{
+					IP:   addr.IP,
 					Port: httpPath.Backend.ServicePort.IntValue(),
+					Tags: eb.instanceMetadata(addr),
+				}))
+			}
+			for _, addr := range notReadyAddrs {
+				instance := newServiceInstance(regapi.ServiceInstance{
+					IP:   addr.IP,
+					Port: httpPath.Backend.ServicePort.IntValue(),
+					Tags: eb.instanceMetadata(addr),
 				})
+				instance.Health = HealthUnhealthy
+				sr.Instances = append(sr.Instances, instance)
 			}
 
 			result = append(result, sr)
 		}
 	}
+	eb.addIngressTLSCertificates(i, result)
 	return result, nil
 }
 
+// ingressBackends returns the backends of the default backend and all rules of the given ingress.
+func ingressBackends(i k8s.Ingress) []k8s.IngressBackend {
+	var result []k8s.IngressBackend
+	if i.Spec == nil {
+		return result
+	}
+	if i.Spec.Backend != nil {
+		result = append(result, *i.Spec.Backend)
+	}
+	for _, rule := range i.Spec.Rules {
+		if rule.HTTP != nil {
+			for _, httpPath := range rule.HTTP.Paths {
+				result = append(result, httpPath.Backend)
+			}
+		}
+	}
+	return result
+}
+
 // createServiceRegistrationsFromFrontendRecord creates ServiceRegistrations from the given FrontendRecord.
-func (eb *k8sBackend) createServiceRegistrationsFromFrontendRecords(i k8s.Ingress, records []api.FrontendRecord) (ServiceRegistrations, error) {
+// Services of the given backends and services in the records without a namespace are
+// in the given namespace.
+func (eb *k8sBackend) createServiceRegistrationsFromFrontendRecords(namespace string, backends []k8s.IngressBackend, records []api.FrontendRecord) (ServiceRegistrations, error) {
 	serviceMap := make(map[string]struct{})
 	var services []regapi.Service
 	createServiceFromBackend := func(backend k8s.IngressBackend) error {
-		key := fmt.Sprintf("%s-%s-%s", i.GetNamespace(), backend.ServiceName, backend.ServicePort.String())
+		key := fmt.Sprintf("%s-%s-%s", namespace, backend.ServiceName, backend.ServicePort.String())
 		if _, found := serviceMap[key]; !found {
-			ips, _, err := eb.listServicePodIPsByIngress(backend, i)
+			addrs, _, err := eb.listServicePodAddressesByName(namespace, backend.ServiceName)
 			if err != nil {
 				return maskAny(err)
 			}
 			service := regapi.Service{
-				ServiceName: fmt.Sprintf("%s_%s", i.Namespace, backend.ServiceName),
+				ServiceName: fmt.Sprintf("%s_%s", namespace, backend.ServiceName),
 				ServicePort: backend.ServicePort.IntValue(),
 			}
-			for _, ip := range ips {
+			for _, addr := range addrs {
 				service.Instances = append(service.Instances, regapi.ServiceInstance{
-					IP:   ip,
+					IP:   addr.IP,
 					Port: backend.ServicePort.IntValue(),
+					Tags: eb.instanceMetadata(addr),
 				})
 			}
 			serviceMap[key] = struct{}{}
@@ -176,57 +362,54 @@ func (eb *k8sBackend) createServiceRegistrationsFromFrontendRecords(i k8s.Ingres
 		return nil
 	}
 
-	if i.Spec.Backend != nil {
-		if err := createServiceFromBackend(*i.Spec.Backend); err != nil {
+	for _, backend := range backends {
+		if err := createServiceFromBackend(backend); err != nil {
 			return nil, maskAny(err)
 		}
 	}
-	for _, rule := range i.Spec.Rules {
-		if rule.HTTP != nil {
-			for _, httpPath := range rule.HTTP.Paths {
-				if err := createServiceFromBackend(httpPath.Backend); err != nil {
-					return nil, maskAny(err)
-				}
-			}
-		}
-	}
 
 	createServiceFromRecord := func(record api.FrontendRecord) error {
 		serviceName := record.Service
-		namespace := i.Namespace
+		serviceNamespace := namespace
 		parts := strings.SplitN(serviceName, ".", 2)
 		if len(parts) == 2 {
 			serviceName = parts[0]
-			namespace = parts[1]
+			serviceNamespace = parts[1]
 		}
 
-		for _, sel := range record.Selectors {
+		for _, sel := range record.AllSelectors() {
 			if sel.ServicePort == 0 {
 				continue
 			}
-			key := fmt.Sprintf("%s-%s-%d", namespace, serviceName, sel.ServicePort)
+			key := fmt.Sprintf("%s-%s-%d", serviceNamespace, serviceName, sel.ServicePort)
 			if _, found := serviceMap[key]; !found {
-				activeIPs, notActiveIPs, err := eb.listServicePodIPsByName(namespace, serviceName)
+				activeAddrs, notActiveAddrs, err := eb.listServicePodAddressesByName(serviceNamespace, serviceName)
 				if err != nil {
 					return maskAny(err)
 				}
 				service := regapi.Service{
-					ServiceName: fmt.Sprintf("%s_%s", namespace, serviceName),
+					ServiceName: fmt.Sprintf("%s_%s", serviceNamespace, serviceName),
 					ServicePort: sel.ServicePort,
 				}
-				for _, ip := range activeIPs {
+				for _, addr := range activeAddrs {
 					service.Instances = append(service.Instances, regapi.ServiceInstance{
-						IP:   ip,
+						IP:   addr.IP,
 						Port: sel.ServicePort,
+						Tags: eb.instanceMetadata(addr),
 					})
 				}
-				if record.HttpCheckMethod != "" || record.HttpCheckPath != "" {
-					for _, ip := range notActiveIPs {
-						service.Instances = append(service.Instances, regapi.ServiceInstance{
-							IP:   ip,
-							Port: sel.ServicePort,
-						})
+				for _, addr := range notActiveAddrs {
+					// Pods that are not ready are added as unhealthy (disabled) servers
+					tags := eb.instanceMetadata(addr)
+					if tags == nil {
+						tags = make(map[string]string)
 					}
+					tags[instanceHealthTag] = HealthUnhealthy
+					service.Instances = append(service.Instances, regapi.ServiceInstance{
+						IP:   addr.IP,
+						Port: sel.ServicePort,
+						Tags: tags,
+					})
 				}
 				serviceMap[key] = struct{}{}
 				services = append(services, service)
@@ -249,7 +432,7 @@ func (eb *k8sBackend) createServiceRegistrationsFromFrontendRecords(i k8s.Ingres
 		serviceName := r.Service
 		parts := strings.SplitN(serviceName, ".", 2)
 		if len(parts) == 1 {
-			serviceName = i.Namespace + "_" + serviceName
+			serviceName = namespace + "_" + serviceName
 		} else {
 			serviceName = parts[1] + "_" + parts[0]
 		}
@@ -263,14 +446,14 @@ func (eb *k8sBackend) createServiceRegistrationsFromFrontendRecords(i k8s.Ingres
 	return result, nil
 }
 
-// listServicePodIPs returns the IP addresses of all pods that match the service name in the given ingress backend.
-func (eb *k8sBackend) listServicePodIPsByIngress(backend k8s.IngressBackend, i k8s.Ingress) ([]string, []string, error) {
-	return eb.listServicePodIPsByName(i.GetNamespace(), backend.ServiceName)
+// listServicePodAddressesByIngress returns the addresses of all pods that match the service name in the given ingress backend.
+func (eb *k8sBackend) listServicePodAddressesByIngress(backend k8s.IngressBackend, i k8s.Ingress) ([]k8s.EndpointAddress, []k8s.EndpointAddress, error) {
+	return eb.listServicePodAddressesByName(i.GetNamespace(), backend.ServiceName)
 }
 
-// listServicePodIPs returns the IP addresses of all endpoints that match the service name in the given ingress backend.
-// The first set of IP addresses is from all active pods, the second set is from all not-yet-active pods.
-func (eb *k8sBackend) listServicePodIPsByName(namespace, serviceName string) ([]string, []string, error) {
+// listServicePodAddressesByName returns the addresses of all endpoints that match the service name in the given ingress backend.
+// The first set of addresses is from all active pods, the second set is from all not-yet-active pods.
+func (eb *k8sBackend) listServicePodAddressesByName(namespace, serviceName string) ([]k8s.EndpointAddress, []k8s.EndpointAddress, error) {
 	eb.Logger.Debugf("searching for endpoints for service '%s' in '%s'", serviceName, namespace)
 	// Find matching endpoints
 	endpoints, found := eb.registry.GetEndpoint(namespace, serviceName)
@@ -278,21 +461,46 @@ func (eb *k8sBackend) listServicePodIPsByName(namespace, serviceName string) ([]
 		eb.Logger.Debugf("cannot find endpoints for service '%s' in '%s'", serviceName, namespace)
 		return nil, nil, nil
 	}
-	// Get IP's
-	var active []string
-	var notYetActive []string
+	// Get addresses
+	var active []k8s.EndpointAddress
+	var notYetActive []k8s.EndpointAddress
 	for _, subset := range endpoints.Subsets {
-		for _, addr := range subset.Addresses {
-			active = append(active, addr.IP)
-		}
-		for _, addr := range subset.NotReadyAddresses {
-			notYetActive = append(notYetActive, addr.IP)
-		}
+		active = append(active, subset.Addresses...)
+		notYetActive = append(notYetActive, subset.NotReadyAddresses...)
 	}
 	eb.Logger.Debugf("found %d/%d IP's for service '%s' in '%s'", len(active), len(notYetActive), serviceName, namespace)
 	return active, notYetActive, nil
 }
 
+// instanceMetadata returns the metadata of the instance at the given endpoint address.
+// It contains the name of the node hosting the pod and the zone of that node,
+// and the weight of the pod (if annotated).
+func (eb *k8sBackend) instanceMetadata(addr k8s.EndpointAddress) map[string]string {
+	var result map[string]string
+	if addr.NodeName != "" {
+		result = map[string]string{
+			MetadataNode: addr.NodeName,
+		}
+		if node, found := eb.registry.GetNode(addr.NodeName); found {
+			for _, label := range zoneLabels {
+				if zone := node.Labels[label]; zone != "" {
+					result[MetadataZone] = zone
+					break
+				}
+			}
+		}
+	}
+	if ref := addr.TargetRef; ref != nil && ref.Kind == "Pod" {
+		if weight, found := eb.registry.GetPodWeight(ref.Namespace, ref.Name); found {
+			if result == nil {
+				result = make(map[string]string)
+			}
+			result[instanceWeightTag] = weight
+		}
+	}
+	return result
+}
+
 func hashOf(s ...string) string {
 	all := strings.Join(s, ",")
 	return fmt.Sprintf("%x", sha1.Sum([]byte(all)))[:8]
@@ -0,0 +1,54 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"reflect"
+
+	k8s "github.com/YakLabs/k8s-client"
+	api "github.com/pulcy/robin-api"
+)
+
+const (
+	// Identification of the RobinFrontend CustomResourceDefinition
+	RobinFrontendGroup   = "robin.pulcy.com"
+	RobinFrontendVersion = "v1"
+	RobinFrontendKind    = "RobinFrontend"
+	RobinFrontendPlural  = "robinfrontends"
+)
+
+// RobinFrontend is a custom resource containing a frontend record.
+// Services in the record without a namespace (`name` instead of `name.namespace`)
+// are in the namespace of the resource.
+type RobinFrontend struct {
+	k8s.TypeMeta   `json:",inline"`
+	k8s.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec api.FrontendRecord `json:"spec"`
+}
+
+// decodeRobinFrontend returns the RobinFrontend of the given watch event.
+func decodeRobinFrontend(evt k8s.WatchEvent) (*RobinFrontend, error) {
+	var result RobinFrontend
+	if err := evt.UnmarshalObject(&result); err != nil {
+		return nil, maskAny(err)
+	}
+	return &result, nil
+}
+
+// robinFrontendChanged returns true if the records of the given frontends differ.
+func robinFrontendChanged(a, b RobinFrontend) bool {
+	return !reflect.DeepEqual(a.Spec, b.Spec)
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"sort"
+
+	k8s "github.com/YakLabs/k8s-client"
+)
+
+// endpointsFromSlices merges the given endpoint slices of a service into an Endpoints resource.
+// Endpoints without a ready condition are considered ready. Addresses that are ready in
+// one slice and not ready in another (possible while endpoints move between slices) are ready.
+func endpointsFromSlices(namespace, serviceName string, slices []k8s.EndpointSlice) k8s.Endpoints {
+	sort.Sort(endpointSlicesByName(slices))
+	ready := make(map[string]bool)
+	var addrs []k8s.EndpointAddress
+	for _, slice := range slices {
+		if slice.AddressType == "FQDN" {
+			continue
+		}
+		for _, ep := range slice.Endpoints {
+			isReady := ep.Conditions.Ready == nil || *ep.Conditions.Ready
+			for _, ip := range ep.Addresses {
+				if wasReady, found := ready[ip]; found {
+					ready[ip] = wasReady || isReady
+					continue
+				}
+				ready[ip] = isReady
+				addr := k8s.EndpointAddress{
+					IP:        ip,
+					TargetRef: ep.TargetRef,
+				}
+				if ep.NodeName != nil {
+					addr.NodeName = *ep.NodeName
+				}
+				if ep.Hostname != nil {
+					addr.Hostname = *ep.Hostname
+				}
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	subset := k8s.EndpointSubset{}
+	for _, addr := range addrs {
+		if ready[addr.IP] {
+			subset.Addresses = append(subset.Addresses, addr)
+		} else {
+			subset.NotReadyAddresses = append(subset.NotReadyAddresses, addr)
+		}
+	}
+	return k8s.Endpoints{
+		ObjectMeta: k8s.ObjectMeta{
+			Namespace: namespace,
+			Name:      serviceName,
+		},
+		Subsets: []k8s.EndpointSubset{subset},
+	}
+}
+
+// endpointSlicesByName sorts a list of endpoint slices by name.
+type endpointSlicesByName []k8s.EndpointSlice
+
+func (l endpointSlicesByName) Len() int           { return len(l) }
+func (l endpointSlicesByName) Less(i, j int) bool { return l[i].Name < l[j].Name }
+func (l endpointSlicesByName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// endpointSliceChanged returns true if the addresses of the given slices differ.
+func endpointSliceChanged(a, b k8s.EndpointSlice) bool {
+	return endpointChanged(endpointsFromSlices(a.Namespace, a.Name, []k8s.EndpointSlice{a}),
+		endpointsFromSlices(b.Namespace, b.Name, []k8s.EndpointSlice{b}))
+}
@@ -0,0 +1,679 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	k8s "github.com/YakLabs/k8s-client"
+	"github.com/YakLabs/k8s-client/intstr"
+	logging "github.com/op/go-logging"
+	api "github.com/pulcy/robin-api"
+)
+
+type k8sTest struct {
+	Config     BackendConfig
+	Kubernetes KubernetesConfig
+	Client     fakeClient
+	ResultPath string
+}
+
+var (
+	k8sTestConfig = BackendConfig{
+		PublicEdgePort:      80,
+		PrivateHttpEdgePort: 81,
+		PrivateTcpEdgePort:  82,
+	}
+	k8sEdgeGroupTestConfig = BackendConfig{
+		PublicEdgePort:      80,
+		PrivateHttpEdgePort: 81,
+		PrivateTcpEdgePort:  82,
+		EdgeGroup:           "internal",
+	}
+
+	webEndpoints = newEndpoints("default", "web", []string{"10.1.0.1", "10.1.0.2"}, []string{"10.1.0.3"})
+	apiEndpoints = newEndpoints("apps", "api", []string{"10.2.0.1"}, []string{"10.2.0.2"})
+
+	k8sTests = []k8sTest{
+		k8sTest{
+			Config: k8sTestConfig,
+			Client: fakeClient{
+				ingresses: []k8s.Ingress{
+					newIngress("default", "web", nil,
+						k8s.IngressRule{
+							Host: "foo.com",
+							HTTP: &k8s.HTTPIngressRuleValue{
+								Paths: []k8s.HTTPIngressPath{
+									newIngressPath("/", "web", 8080),
+									newIngressPath("/static", "web", 8081),
+								},
+							},
+						},
+					),
+				},
+				endpoints: []k8s.Endpoints{webEndpoints},
+			},
+			ResultPath: "./fixtures/k8s_raw_ingress.json",
+		},
+		k8sTest{
+			Config: k8sTestConfig,
+			Client: fakeClient{
+				ingresses: []k8s.Ingress{
+					newIngress("default", "web", []api.FrontendRecord{
+						api.FrontendRecord{
+							Service: "web",
+							Selectors: []api.FrontendSelectorRecord{
+								api.FrontendSelectorRecord{Domain: "foo.com", SslCert: "foo-com.pem", ServicePort: 8080},
+								api.FrontendSelectorRecord{Domain: "web.private", Private: true, ServicePort: 8080},
+							},
+							HttpCheckPath: "/health",
+						},
+						api.FrontendRecord{
+							Service: "api.apps",
+							Mode:    "tcp",
+							Selectors: []api.FrontendSelectorRecord{
+								api.FrontendSelectorRecord{Domain: "api.private", Private: true, ServicePort: 5000},
+							},
+						},
+					}),
+				},
+				endpoints: []k8s.Endpoints{webEndpoints, apiEndpoints},
+			},
+			ResultPath: "./fixtures/k8s_frontend_records.json",
+		},
+		k8sTest{
+			Config: k8sEdgeGroupTestConfig,
+			Client: fakeClient{
+				ingresses: []k8s.Ingress{
+					newIngress("default", "raw", nil,
+						k8s.IngressRule{
+							Host: "raw.com",
+							HTTP: &k8s.HTTPIngressRuleValue{
+								Paths: []k8s.HTTPIngressPath{newIngressPath("/", "web", 8080)},
+							},
+						},
+					),
+					newIngress("default", "web", []api.FrontendRecord{
+						api.FrontendRecord{
+							Service: "web",
+							Selectors: []api.FrontendSelectorRecord{
+								api.FrontendSelectorRecord{Domain: "foo.com", ServicePort: 8080},
+							},
+						},
+						api.FrontendRecord{
+							Service:   "web",
+							EdgeGroup: "internal",
+							Selectors: []api.FrontendSelectorRecord{
+								api.FrontendSelectorRecord{Domain: "internal.foo.com", ServicePort: 8080},
+							},
+						},
+					}),
+				},
+				endpoints: []k8s.Endpoints{webEndpoints},
+			},
+			ResultPath: "./fixtures/k8s_edge_group.json",
+		},
+		k8sTest{
+			Config: k8sTestConfig,
+			Kubernetes: KubernetesConfig{
+				Namespaces:           []string{"apps"},
+				IngressLabelSelector: map[string]string{"robin": "public"},
+			},
+			Client: fakeClient{
+				ingresses: []k8s.Ingress{
+					withLabels(newIngress("default", "web", nil,
+						k8s.IngressRule{
+							Host: "foo.com",
+							HTTP: &k8s.HTTPIngressRuleValue{
+								Paths: []k8s.HTTPIngressPath{newIngressPath("/", "web", 8080)},
+							},
+						},
+					), map[string]string{"robin": "public"}),
+					withLabels(newIngress("apps", "api", nil,
+						k8s.IngressRule{
+							Host: "api.foo.com",
+							HTTP: &k8s.HTTPIngressRuleValue{
+								Paths: []k8s.HTTPIngressPath{newIngressPath("/", "api", 5000)},
+							},
+						},
+					), map[string]string{"robin": "public"}),
+					newIngress("apps", "internal", nil,
+						k8s.IngressRule{
+							Host: "internal.foo.com",
+							HTTP: &k8s.HTTPIngressRuleValue{
+								Paths: []k8s.HTTPIngressPath{newIngressPath("/", "api", 5000)},
+							},
+						},
+					),
+				},
+				endpoints: []k8s.Endpoints{webEndpoints, apiEndpoints},
+			},
+			ResultPath: "./fixtures/k8s_namespace_filter.json",
+		},
+		k8sTest{
+			Config:     k8sTestConfig,
+			Kubernetes: KubernetesConfig{IngressClass: "robin"},
+			Client: fakeClient{
+				ingresses: []k8s.Ingress{
+					withIngressClassAnnotation(newIngress("default", "annotated", nil,
+						k8s.IngressRule{
+							Host: "annotated.foo.com",
+							HTTP: &k8s.HTTPIngressRuleValue{
+								Paths: []k8s.HTTPIngressPath{newIngressPath("/", "web", 8080)},
+							},
+						},
+					), "robin"),
+					withIngressClassName(newIngress("default", "named", nil,
+						k8s.IngressRule{
+							Host: "named.foo.com",
+							HTTP: &k8s.HTTPIngressRuleValue{
+								Paths: []k8s.HTTPIngressPath{newIngressPath("/", "web", 8080)},
+							},
+						},
+					), "robin"),
+					withIngressClassAnnotation(withIngressClassName(newIngress("default", "other", nil,
+						k8s.IngressRule{
+							Host: "other.foo.com",
+							HTTP: &k8s.HTTPIngressRuleValue{
+								Paths: []k8s.HTTPIngressPath{newIngressPath("/", "web", 8080)},
+							},
+						},
+					), "robin"), "nginx"),
+					newIngress("default", "unclassed", nil,
+						k8s.IngressRule{
+							Host: "unclassed.foo.com",
+							HTTP: &k8s.HTTPIngressRuleValue{
+								Paths: []k8s.HTTPIngressPath{newIngressPath("/", "web", 8080)},
+							},
+						},
+					),
+				},
+				endpoints: []k8s.Endpoints{webEndpoints},
+			},
+			ResultPath: "./fixtures/k8s_ingress_class.json",
+		},
+		k8sTest{
+			Config: k8sTestConfig,
+			// The certificates folder is replaced by a temporary folder
+			Kubernetes: KubernetesConfig{SslCertsFolder: "tmp"},
+			Client: fakeClient{
+				ingresses: []k8s.Ingress{
+					withIngressTLS(newIngress("default", "web", nil,
+						k8s.IngressRule{
+							Host: "foo.com",
+							HTTP: &k8s.HTTPIngressRuleValue{
+								Paths: []k8s.HTTPIngressPath{newIngressPath("/", "web", 8080)},
+							},
+						},
+						k8s.IngressRule{
+							Host: "bar.com",
+							HTTP: &k8s.HTTPIngressRuleValue{
+								Paths: []k8s.HTTPIngressPath{newIngressPath("/", "web", 8080)},
+							},
+						},
+					), k8s.IngressTLS{Hosts: []string{"foo.com"}, SecretName: "foo-tls"}),
+				},
+				endpoints: []k8s.Endpoints{webEndpoints},
+				secrets: []k8s.Secret{
+					newTLSSecret("default", "foo-tls", "CERT", "KEY"),
+				},
+			},
+			ResultPath: "./fixtures/k8s_ingress_tls.json",
+		},
+		k8sTest{
+			Config:     k8sTestConfig,
+			Kubernetes: KubernetesConfig{RobinFrontends: true},
+			Client: fakeClient{
+				frontends: []RobinFrontend{
+					newRobinFrontend("default", "web", api.FrontendRecord{
+						Service: "web",
+						Selectors: []api.FrontendSelectorRecord{
+							api.FrontendSelectorRecord{Domain: "foo.com", ServicePort: 8080},
+						},
+						HttpCheckPath: "/health",
+					}),
+					newRobinFrontend("default", "api", api.FrontendRecord{
+						Service: "api.apps",
+						Mode:    "tcp",
+						Selectors: []api.FrontendSelectorRecord{
+							api.FrontendSelectorRecord{Domain: "api.private", Private: true, ServicePort: 5000},
+						},
+					}),
+				},
+				endpoints: []k8s.Endpoints{webEndpoints, apiEndpoints},
+			},
+			ResultPath: "./fixtures/k8s_robin_frontends.json",
+		},
+		k8sTest{
+			Config: k8sTestConfig,
+			Client: fakeClient{
+				ingresses: []k8s.Ingress{
+					newIngress("default", "web", nil,
+						k8s.IngressRule{
+							Host: "foo.com",
+							HTTP: &k8s.HTTPIngressRuleValue{
+								Paths: []k8s.HTTPIngressPath{newIngressPath("/", "web", 8080)},
+							},
+						},
+					),
+				},
+				// Endpoints are ignored when endpoint slices are served
+				endpoints: []k8s.Endpoints{newEndpoints("default", "web", []string{"10.9.0.1"}, nil)},
+				endpointSlices: []k8s.EndpointSlice{
+					newEndpointSlice("default", "web-abc", "web", []string{"10.1.0.1", "10.1.0.2"}, []string{"10.1.0.3"}),
+					// 10.1.0.2 is moving between slices and ready in the other slice
+					newEndpointSlice("default", "web-def", "web", []string{"10.1.0.4"}, []string{"10.1.0.2"}),
+				},
+			},
+			ResultPath: "./fixtures/k8s_endpoint_slices.json",
+		},
+		k8sTest{
+			Config:     k8sTestConfig,
+			Kubernetes: KubernetesConfig{PodWeights: true},
+			Client: fakeClient{
+				ingresses: []k8s.Ingress{
+					newIngress("default", "web", nil,
+						k8s.IngressRule{
+							Host: "foo.com",
+							HTTP: &k8s.HTTPIngressRuleValue{
+								Paths: []k8s.HTTPIngressPath{newIngressPath("/", "web", 8080)},
+							},
+						},
+					),
+				},
+				endpoints: []k8s.Endpoints{withPodTargets(webEndpoints)},
+				pods: []k8s.Pod{
+					newPod("default", "10.1.0.1", map[string]string{PodWeightAnnotationKey: "20"}),
+					newPod("default", "10.1.0.2", map[string]string{PodWeightAnnotationKey: "0"}),
+					newPod("default", "10.1.0.3", nil),
+				},
+			},
+			ResultPath: "./fixtures/k8s_pod_weights.json",
+		},
+	}
+)
+
+func newIngress(namespace, name string, records []api.FrontendRecord, rules ...k8s.IngressRule) k8s.Ingress {
+	i := k8s.Ingress{
+		ObjectMeta: k8s.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Spec: &k8s.IngressSpec{
+			Rules: rules,
+		},
+	}
+	if len(records) > 0 {
+		raw, err := json.Marshal(records)
+		if err != nil {
+			panic(err)
+		}
+		i.ObjectMeta.Annotations = map[string]string{
+			RobinFrontendRecordsAnnotationKey: string(raw),
+		}
+	}
+	return i
+}
+
+func withLabels(i k8s.Ingress, labels map[string]string) k8s.Ingress {
+	i.ObjectMeta.Labels = labels
+	return i
+}
+
+func withIngressClassAnnotation(i k8s.Ingress, class string) k8s.Ingress {
+	annotations := map[string]string{IngressClassAnnotationKey: class}
+	for k, v := range i.ObjectMeta.Annotations {
+		annotations[k] = v
+	}
+	i.ObjectMeta.Annotations = annotations
+	return i
+}
+
+func withIngressClassName(i k8s.Ingress, class string) k8s.Ingress {
+	i.Spec.IngressClassName = &class
+	return i
+}
+
+func withIngressTLS(i k8s.Ingress, tls ...k8s.IngressTLS) k8s.Ingress {
+	i.Spec.TLS = tls
+	return i
+}
+
+func newTLSSecret(namespace, name, cert, key string) k8s.Secret {
+	return k8s.Secret{
+		ObjectMeta: k8s.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Type: tlsSecretType,
+		Data: map[string][]byte{
+			tlsSecretCertKey: []byte(cert),
+			tlsSecretKeyKey:  []byte(key),
+		},
+	}
+}
+
+func newRobinFrontend(namespace, name string, record api.FrontendRecord) RobinFrontend {
+	return RobinFrontend{
+		TypeMeta: k8s.TypeMeta{
+			Kind:       RobinFrontendKind,
+			APIVersion: RobinFrontendGroup + "/" + RobinFrontendVersion,
+		},
+		ObjectMeta: k8s.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Spec: record,
+	}
+}
+
+func newIngressPath(path, serviceName string, servicePort int) k8s.HTTPIngressPath {
+	return k8s.HTTPIngressPath{
+		Path: path,
+		Backend: k8s.IngressBackend{
+			ServiceName: serviceName,
+			ServicePort: intstr.FromInt(servicePort),
+		},
+	}
+}
+
+func newEndpoints(namespace, name string, ready, notReady []string) k8s.Endpoints {
+	subset := k8s.EndpointSubset{}
+	for _, ip := range ready {
+		subset.Addresses = append(subset.Addresses, k8s.EndpointAddress{IP: ip})
+	}
+	for _, ip := range notReady {
+		subset.NotReadyAddresses = append(subset.NotReadyAddresses, k8s.EndpointAddress{IP: ip})
+	}
+	return k8s.Endpoints{
+		ObjectMeta: k8s.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Subsets: []k8s.EndpointSubset{subset},
+	}
+}
+
+// withPodTargets returns the given endpoints with all addresses referring to their pod (see newPod).
+func withPodTargets(e k8s.Endpoints) k8s.Endpoints {
+	target := func(addrs []k8s.EndpointAddress) []k8s.EndpointAddress {
+		var result []k8s.EndpointAddress
+		for _, addr := range addrs {
+			addr.TargetRef = &k8s.ObjectReference{Kind: "Pod", Namespace: e.Namespace, Name: podName(addr.IP)}
+			result = append(result, addr)
+		}
+		return result
+	}
+	var subsets []k8s.EndpointSubset
+	for _, subset := range e.Subsets {
+		subset.Addresses = target(subset.Addresses)
+		subset.NotReadyAddresses = target(subset.NotReadyAddresses)
+		subsets = append(subsets, subset)
+	}
+	e.Subsets = subsets
+	return e
+}
+
+// newPod creates a pod with given IP address and annotations.
+func newPod(namespace, ip string, annotations map[string]string) k8s.Pod {
+	return k8s.Pod{
+		ObjectMeta: k8s.ObjectMeta{
+			Namespace:   namespace,
+			Name:        podName(ip),
+			Annotations: annotations,
+		},
+	}
+}
+
+func podName(ip string) string {
+	return "pod-" + strings.Replace(ip, ".", "-", -1)
+}
+
+func newEndpointSlice(namespace, name, serviceName string, ready, notReady []string) k8s.EndpointSlice {
+	slice := k8s.EndpointSlice{
+		ObjectMeta: k8s.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    map[string]string{k8s.LabelServiceName: serviceName},
+		},
+		AddressType: "IPv4",
+	}
+	isReady, isNotReady := true, false
+	for _, ip := range ready {
+		slice.Endpoints = append(slice.Endpoints, k8s.Endpoint{
+			Addresses:  []string{ip},
+			Conditions: k8s.EndpointConditions{Ready: &isReady},
+		})
+	}
+	for _, ip := range notReady {
+		slice.Endpoints = append(slice.Endpoints, k8s.Endpoint{
+			Addresses:  []string{ip},
+			Conditions: k8s.EndpointConditions{Ready: &isNotReady},
+		})
+	}
+	return slice
+}
+
+// newTestKubernetesBackend creates a backend using the given fake client and waits
+// until the registry has received all its resources.
+func newTestKubernetesBackend(t *testing.T, config BackendConfig, k8sConfig KubernetesConfig, client *fakeClient) *k8sBackend {
+	log := logging.MustGetLogger("test")
+	registry := newResourceRegistryWithClient(client, k8sConfig, log)
+	onChange := make(chan struct{})
+	registry.Start(context.Background(), func() { onChange <- struct{}{} })
+	for i := 0; i < client.eventCount(k8sConfig); i++ {
+		select {
+		case <-onChange:
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Timeout waiting for registry changes")
+		}
+	}
+	return &k8sBackend{
+		statusTracker: &statusTracker{},
+		config:        config,
+		registry:      registry,
+		Logger:        log,
+	}
+}
+
+func TestKubernetesServices(t *testing.T) {
+	updateFixtures := os.Getenv("UPDATE-FIXTURES") == "1"
+	for _, test := range k8sTests {
+		client := test.Client
+		k8sConfig := test.Kubernetes
+		if k8sConfig.SslCertsFolder != "" {
+			dir, err := ioutil.TempDir("", "robin-k8s")
+			if err != nil {
+				t.Fatalf("Cannot create temp dir: %#v", err)
+			}
+			defer os.RemoveAll(dir)
+			k8sConfig.SslCertsFolder = dir
+		}
+		eb := newTestKubernetesBackend(t, test.Config, k8sConfig, &client)
+		services, err := eb.Services(context.Background())
+		if err != nil {
+			t.Errorf("Services failed for %s: %#v", test.ResultPath, err)
+			continue
+		}
+		for i, s := range services {
+			services[i] = s.Normalize()
+		}
+		services.Sort()
+		result, err := json.MarshalIndent(services, "", "  ")
+		if err != nil {
+			t.Fatalf("Cannot marshal services: %#v", err)
+		}
+		if updateFixtures {
+			if err := ioutil.WriteFile(test.ResultPath, append(result, '\n'), 0644); err != nil {
+				t.Errorf("Cannot update fixture %s: %#v", test.ResultPath, err)
+			}
+			continue
+		}
+		expected, err := ioutil.ReadFile(test.ResultPath)
+		if err != nil {
+			t.Errorf("Cannot read fixture %s: %#v", test.ResultPath, err)
+		} else if string(expected) != string(result)+"\n" {
+			t.Errorf("Unexpected services for %s: got\n%s", test.ResultPath, string(result))
+		}
+	}
+}
+
+func TestKubernetesWatchRetryDelay(t *testing.T) {
+	config := KubernetesConfig{WatchBackoff: time.Second, WatchMaxBackoff: time.Second * 10, WatchJitter: 0.5}
+	tests := []struct {
+		Failures int
+		Random   float64
+		Expected time.Duration
+	}{
+		{1, 0, time.Second},
+		{3, 0, time.Second * 4},
+		{10, 0, time.Second * 10},
+		{2, 0.5, time.Millisecond * 1500},
+	}
+	for _, test := range tests {
+		if d := config.watchRetryDelay(test.Failures, test.Random); d != test.Expected {
+			t.Errorf("Delay after %d failures: expected %s, got %s", test.Failures, test.Expected, d)
+		}
+	}
+	if d := (KubernetesConfig{}).watchRetryDelay(1, 0); d != defaultWatchBackoff {
+		t.Errorf("Expected default delay, got %s", d)
+	}
+}
+
+func TestResourceRegistryWatchBackoff(t *testing.T) {
+	registry := newResourceRegistryWithClient(&fakeClient{}, KubernetesConfig{WatchBackoff: time.Millisecond * 50}, logging.MustGetLogger("test"))
+	tracker := &statusTracker{}
+	tracker.recordServices(nil)
+	registry.reportWatch = tracker.recordResourceWatch
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*120)
+	defer cancel()
+	calls := 0
+	registry.watch(ctx, "service", "default", func() error {
+		calls++
+		return maskAny(fmt.Errorf("apiserver down"))
+	})
+	// Attempts at 0ms, 50ms (after 50ms delay) & 150ms (after 100ms delay, canceled)
+	if calls != 2 {
+		t.Errorf("Expected 2 watch attempts, got %d", calls)
+	}
+	// The failures are reported in the status of the backend
+	status := tracker.Status()
+	if status.Ready || len(status.FailingResources) != 1 || status.FailingResources[0] != "default/service" {
+		t.Errorf("Expected failing service watch in status, got %#v", status)
+	}
+	// A watch that ends without an error recovers
+	ctx, cancel = context.WithCancel(context.Background())
+	registry.watch(ctx, "service", "default", func() error {
+		cancel()
+		return nil
+	})
+	if status := tracker.Status(); !status.Ready || len(status.FailingResources) != 0 {
+		t.Errorf("Expected ready after recovered watch, got %#v", status)
+	}
+}
+
+func TestResourceRegistryStop(t *testing.T) {
+	client := &fakeClient{
+		ingresses: []k8s.Ingress{newIngress("default", "web", nil), newIngress("default", "api", nil)},
+		endpoints: []k8s.Endpoints{newEndpoints("default", "web", []string{"10.0.0.1"}, nil)},
+	}
+	registry := newResourceRegistryWithClient(client, KubernetesConfig{}, logging.MustGetLogger("test"))
+	ctx, cancel := context.WithCancel(context.Background())
+	var notifying, notifications int32
+	registry.Start(ctx, func() {
+		if atomic.AddInt32(&notifying, 1) > 1 {
+			t.Errorf("Expected notify to be called from a single goroutine")
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&notifications, 1)
+		atomic.AddInt32(&notifying, -1)
+	})
+	waitFor := func(what string, condition func() bool) {
+		deadline := time.Now().Add(time.Second * 5)
+		for !condition() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timeout waiting for %s", what)
+			}
+			time.Sleep(time.Millisecond * 5)
+		}
+	}
+	// Node, ingress, endpoints (no endpoint slices) & service watches
+	waitFor("running watches", func() bool {
+		return atomic.LoadInt32(&client.runningWatches) == 4 && atomic.LoadInt32(&notifications) == 3
+	})
+	// Canceling the context ends the running watches
+	cancel()
+	waitFor("stopped watches", func() bool { return atomic.LoadInt32(&client.runningWatches) == 0 })
+}
+
+func TestKubernetesTLSFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "robin-k8s")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %#v", err)
+	}
+	defer os.RemoveAll(dir)
+	r := newResourceRegistryWithClient(&fakeClient{}, KubernetesConfig{SslCertsFolder: dir}, logging.MustGetLogger("test"))
+	ingress := withIngressTLS(newIngress("default", "web", nil), k8s.IngressTLS{SecretName: "foo-tls"})
+	secret := newTLSSecret("default", "foo-tls", "CERT", "KEY")
+	files := func() []string {
+		paths, err := filepath.Glob(filepath.Join(dir, "*"))
+		if err != nil {
+			t.Fatalf("Cannot list files: %#v", err)
+		}
+		for i, p := range paths {
+			paths[i] = filepath.Base(p)
+		}
+		return paths
+	}
+
+	// Secrets that are not referenced by an ingress are not written
+	r.updateSecret(fakeSecretEvent{object: secret})
+	if f := files(); len(f) != 0 {
+		t.Errorf("Expected no files, got %#v", f)
+	}
+	r.updateIngress(fakeIngressEvent{object: ingress})
+	certName, found := r.GetTLSCertName("default", "foo-tls")
+	if !found {
+		t.Fatalf("Expected certificate of foo-tls")
+	}
+	if f := files(); !reflect.DeepEqual(f, []string{certName}) {
+		t.Errorf("Expected %s, got %#v", certName, f)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(dir, certName)); err != nil || string(content) != "CERT\nKEY" {
+		t.Errorf("Unexpected bundle %q (%v)", string(content), err)
+	}
+
+	// A changed secret replaces the bundle
+	r.updateSecret(fakeSecretEvent{object: newTLSSecret("default", "foo-tls", "CERT2", "KEY2")})
+	newCertName, _ := r.GetTLSCertName("default", "foo-tls")
+	if f := files(); newCertName == certName || !reflect.DeepEqual(f, []string{newCertName}) {
+		t.Errorf("Expected %s, got %#v", newCertName, f)
+	}
+
+	// Deleting the secret removes the bundle
+	r.updateSecret(fakeSecretEvent{object: secret, deleted: true})
+	if _, found := r.GetTLSCertName("default", "foo-tls"); found {
+		t.Errorf("Expected no certificate after deleting secret")
+	}
+	if f := files(); len(f) != 0 {
+		t.Errorf("Expected no files after deleting secret, got %#v", f)
+	}
+
+	// Deleting the ingress removes the bundle
+	r.updateSecret(fakeSecretEvent{object: secret})
+	if f := files(); len(f) != 1 {
+		t.Errorf("Expected 1 file, got %#v", f)
+	}
+	r.updateIngress(fakeIngressEvent{object: ingress, deleted: true})
+	if f := files(); len(f) != 0 {
+		t.Errorf("Expected no files after deleting ingress, got %#v", f)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	k8s "github.com/YakLabs/k8s-client"
+)
+
+const (
+	tlsSecretType    = "kubernetes.io/tls"
+	tlsSecretCertKey = "tls.crt"
+	tlsSecretKeyKey  = "tls.key"
+	tlsCertPrefix    = "k8s_" // Prefix of the bundles written for TLS secrets
+)
+
+// tlsSecretWatchOptions returns the options used to watch TLS secrets.
+func tlsSecretWatchOptions() *k8s.WatchOptions {
+	return &k8s.WatchOptions{
+		ListOptions: k8s.ListOptions{
+			FieldSelector: k8s.FieldSelector{"type": tlsSecretType},
+		},
+	}
+}
+
+// tlsSecretChanged returns true if the certificate or key of the given secrets differ.
+func tlsSecretChanged(a, b k8s.Secret) bool {
+	return !bytes.Equal(a.Data[tlsSecretCertKey], b.Data[tlsSecretCertKey]) ||
+		!bytes.Equal(a.Data[tlsSecretKeyKey], b.Data[tlsSecretKeyKey])
+}
+
+// addIngressTLSCertificates sets the certificate of all selectors (without a certificate) whose domain
+// is covered by a TLS section of the given ingress.
+// The certificates are taken from the bundles the registry has written for the secrets referenced by the TLS sections.
+func (eb *k8sBackend) addIngressTLSCertificates(i k8s.Ingress, srs ServiceRegistrations) {
+	if eb.registry.config.SslCertsFolder == "" || i.Spec == nil {
+		return
+	}
+	for _, tls := range i.Spec.TLS {
+		if tls.SecretName == "" {
+			continue
+		}
+		certName, found := eb.registry.GetTLSCertName(i.GetNamespace(), tls.SecretName)
+		if !found {
+			eb.Logger.Warningf("Ignoring TLS section of ingress %s.%s: no certificate for secret %s", i.Name, i.GetNamespace(), tls.SecretName)
+			continue
+		}
+		for srIndex := range srs {
+			for selIndex, sel := range srs[srIndex].Selectors {
+				if sel.SslCertName != "" || sel.Domain == "" || !tlsCoversHost(tls, sel.Domain) {
+					continue
+				}
+				srs[srIndex].Selectors[selIndex].SslCertName = certName
+			}
+		}
+	}
+}
+
+// tlsCoversHost returns true if the given TLS section applies to the given host.
+// A section without hosts applies to all hosts.
+func tlsCoversHost(tls k8s.IngressTLS, host string) bool {
+	if len(tls.Hosts) == 0 {
+		return true
+	}
+	for _, h := range tls.Hosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTLSCertName returns the name of the certificate+key bundle of the TLS secret with given namespace+name.
+// It returns false if the secret is unknown, invalid or not referenced by an ingress.
+func (r *resourceRegistry) GetTLSCertName(namespace, secretName string) (string, bool) {
+	r.accessMutex.RLock()
+	defer r.accessMutex.RUnlock()
+
+	result, ok := r.tlsCertNames[r.createKey(namespace, secretName)]
+	return result, ok
+}
+
+// syncTLSFiles writes the certificate+key bundles of all TLS secrets referenced by an ingress
+// to the ssl-certs folder and removes all other bundles, including those of deleted secrets and ingresses.
+// It must be called with the access mutex locked.
+func (r *resourceRegistry) syncTLSFiles() {
+	folder := r.config.SslCertsFolder
+	if folder == "" {
+		return
+	}
+	certNames := make(map[string]string)
+	used := make(map[string]bool)
+	for _, i := range r.ingresses {
+		if i.Spec == nil {
+			continue
+		}
+		for _, tls := range i.Spec.TLS {
+			key := r.createKey(i.GetNamespace(), tls.SecretName)
+			if _, found := certNames[key]; found || tls.SecretName == "" {
+				continue
+			}
+			secret, found := r.secrets[key]
+			if !found {
+				continue
+			}
+			certName, err := writeTLSSecret(folder, secret)
+			if err != nil {
+				r.log.Warningf("Cannot write TLS secret %s.%s: %v", tls.SecretName, i.GetNamespace(), err)
+				continue
+			}
+			certNames[key] = certName
+			used[certName] = true
+		}
+	}
+	if paths, err := filepath.Glob(filepath.Join(folder, tlsCertPrefix+"*.pem")); err == nil {
+		for _, p := range paths {
+			if !used[filepath.Base(p)] {
+				os.Remove(p)
+			}
+		}
+	}
+	r.tlsCertNames = certNames
+}
+
+// writeTLSSecret writes the certificate+key bundle of the given TLS secret to the given folder
+// and returns its filename.
+// The filename contains a hash of the bundle, so a changed secret results in a changed configuration.
+func writeTLSSecret(folder string, secret k8s.Secret) (string, error) {
+	cert, key := secret.Data[tlsSecretCertKey], secret.Data[tlsSecretKeyKey]
+	if len(cert) == 0 || len(key) == 0 {
+		return "", maskAny(fmt.Errorf("TLS secret %s.%s has no certificate or key", secret.Name, secret.Namespace))
+	}
+	bundle := append([]byte{}, cert...)
+	if !bytes.HasSuffix(bundle, []byte("\n")) {
+		bundle = append(bundle, '\n')
+	}
+	bundle = append(bundle, key...)
+
+	// Kubernetes names cannot contain '_', so the prefix identifies the secret
+	certName := fmt.Sprintf("%s%s_%s_%s.pem", tlsCertPrefix, secret.Namespace, secret.Name, hashOf(string(bundle)))
+	certPath := filepath.Join(folder, certName)
+	if _, err := os.Stat(certPath); os.IsNotExist(err) {
+		if err := ioutil.WriteFile(certPath, bundle, 0600); err != nil {
+			return "", maskAny(err)
+		}
+	}
+	return certName, nil
+}
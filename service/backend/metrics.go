@@ -0,0 +1,98 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	watchResets = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "robin",
+			Subsystem: "backend",
+			Name:      "watch_resets_total",
+			Help:      "Number of times the watcher was reset because it missed events.",
+		},
+	)
+	etcdSyncFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "robin",
+			Subsystem: "backend",
+			Name:      "etcd_sync_failures_total",
+			Help:      "Number of failed syncs of the etcd endpoints.",
+		},
+	)
+	etcdClientResets = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "robin",
+			Subsystem: "backend",
+			Name:      "etcd_client_resets_total",
+			Help:      "Number of times the etcd client was reset to the configured endpoints.",
+		},
+	)
+	etcdEndpointHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "robin",
+			Subsystem: "backend",
+			Name:      "etcd_endpoint_healthy",
+			Help:      "1 if the etcd endpoint is healthy, 0 otherwise.",
+		},
+		[]string{"endpoint"},
+	)
+	backendReady = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "robin",
+			Subsystem: "backend",
+			Name:      "ready",
+			Help:      "1 if services have been loaded and neither the last load nor the last watch failed, 0 otherwise.",
+		},
+	)
+	backendLastServices = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "robin",
+			Subsystem: "backend",
+			Name:      "last_services_timestamp_seconds",
+			Help:      "Time of the last successful load of services from the backend.",
+		},
+	)
+	backendServicesFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "robin",
+			Subsystem: "backend",
+			Name:      "services_failures_total",
+			Help:      "Number of failed loads of services from the backend.",
+		},
+	)
+	backendWatchFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "robin",
+			Subsystem: "backend",
+			Name:      "watch_failures_total",
+			Help:      "Number of failed watches of the backend.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(watchResets)
+	prometheus.MustRegister(etcdSyncFailures)
+	prometheus.MustRegister(etcdClientResets)
+	prometheus.MustRegister(etcdEndpointHealthy)
+	prometheus.MustRegister(backendReady)
+	prometheus.MustRegister(backendLastServices)
+	prometheus.MustRegister(backendServicesFailures)
+	prometheus.MustRegister(backendWatchFailures)
+}
@@ -0,0 +1,321 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	regapi "github.com/pulcy/registrator-api"
+	api "github.com/pulcy/robin-api"
+)
+
+const (
+	// DefaultNomadTagPrefix is the prefix of service tags that contain selectors (compatible with Fabio)
+	DefaultNomadTagPrefix = "urlprefix-"
+
+	nomadIndexHeader = "X-Nomad-Index"
+	nomadTokenHeader = "X-Nomad-Token"
+	nomadWaitTime    = time.Minute // Maximum duration of a single blocking query
+)
+
+// NomadConfig configures the Nomad backend.
+type NomadConfig struct {
+	Address    string   // Address of the Nomad HTTP API (e.g. http://127.0.0.1:4646)
+	Token      string   // If set, ACL token used for all requests
+	Namespaces []string // Namespaces to watch (empty means all namespaces)
+	TagPrefix  string   // Prefix of service tags that contain selectors (empty means DefaultNomadTagPrefix)
+}
+
+// queryNamespace returns the namespace used to list services.
+func (c NomadConfig) queryNamespace() string {
+	if len(c.Namespaces) == 1 {
+		return c.Namespaces[0]
+	}
+	return "*"
+}
+
+// watchesNamespace returns true if services in the given namespace are served.
+func (c NomadConfig) watchesNamespace(namespace string) bool {
+	return len(c.Namespaces) == 0 || containsString(c.Namespaces, namespace)
+}
+
+// nomadServiceList is an element of the response of /v1/services.
+type nomadServiceList struct {
+	Namespace string
+	Services  []struct {
+		ServiceName string
+		Tags        []string
+	}
+}
+
+// nomadServiceRegistration is an element of the response of /v1/service/:name.
+type nomadServiceRegistration struct {
+	ID          string
+	ServiceName string
+	Namespace   string
+	NodeID      string
+	Datacenter  string
+	JobID       string
+	AllocID     string
+	Tags        []string
+	Address     string
+	Port        int
+}
+
+type nomadBackend struct {
+	*statusTracker
+
+	config    BackendConfig
+	nomad     NomadConfig
+	client    *http.Client
+	Logger    *logging.Logger
+	mutex     sync.Mutex
+	lastIndex uint64 // Index of the service catalog at the last change returned by Watch
+}
+
+// NewNomadBackend creates a backend that serves the services registered in Nomad (native service discovery).
+// The frontends of these services are specified in their tags, e.g. `urlprefix-foo.com/api`.
+func NewNomadBackend(config BackendConfig, nomadConfig NomadConfig, logger *logging.Logger) (Backend, error) {
+	if nomadConfig.Address == "" {
+		return nil, maskAny(fmt.Errorf("Nomad address must be set"))
+	}
+	if _, err := url.Parse(nomadConfig.Address); err != nil {
+		return nil, maskAny(err)
+	}
+	if nomadConfig.TagPrefix == "" {
+		nomadConfig.TagPrefix = DefaultNomadTagPrefix
+	}
+	return &nomadBackend{
+		statusTracker: &statusTracker{},
+		config:        config,
+		nomad:         nomadConfig,
+		client:        &http.Client{},
+		Logger:        logger,
+	}, nil
+}
+
+// Watch for changes in the service catalog and return where there is a change.
+// Changes that happen while nobody is watching are returned by the next call.
+func (nb *nomadBackend) Watch(ctx context.Context) (err error) {
+	defer func() { nb.recordWatch(ctx, err) }()
+	nb.mutex.Lock()
+	lastIndex := nb.lastIndex
+	nb.mutex.Unlock()
+
+	for {
+		query := url.Values{}
+		query.Set("namespace", nb.nomad.queryNamespace())
+		query.Set("index", strconv.FormatUint(lastIndex, 10))
+		query.Set("wait", nomadWaitTime.String())
+		index, err := nb.get(ctx, "/v1/services", query, nil)
+		if err != nil {
+			return maskAny(err)
+		}
+		if index != lastIndex {
+			// The index changed (or was reset by a restored cluster state)
+			nb.mutex.Lock()
+			nb.lastIndex = index
+			nb.mutex.Unlock()
+			return nil
+		}
+	}
+}
+
+// Load all registered services
+func (nb *nomadBackend) Services(ctx context.Context) (registrations ServiceRegistrations, err error) {
+	defer func() { nb.recordServices(err) }()
+	query := url.Values{}
+	query.Set("namespace", nb.nomad.queryNamespace())
+	var lists []nomadServiceList
+	if _, err := nb.get(ctx, "/v1/services", query, &lists); err != nil {
+		return nil, maskAny(err)
+	}
+
+	var services []regapi.Service
+	var records []api.FrontendRecord
+	for _, list := range lists {
+		if !nb.nomad.watchesNamespace(list.Namespace) {
+			continue
+		}
+		for _, stub := range list.Services {
+			query := url.Values{}
+			query.Set("namespace", list.Namespace)
+			var registrations []nomadServiceRegistration
+			if _, err := nb.get(ctx, "/v1/service/"+(&url.URL{Path: stub.ServiceName}).EscapedPath(), query, &registrations); err != nil {
+				return nil, maskAny(err)
+			}
+			serviceName := fmt.Sprintf("%s_%s", list.Namespace, stub.ServiceName)
+			service := regapi.Service{
+				ServiceName: serviceName,
+			}
+			tags := make(map[string]struct{})
+			for _, r := range registrations {
+				service.Instances = append(service.Instances, regapi.ServiceInstance{
+					IP:   r.Address,
+					Port: r.Port,
+					Tags: map[string]string{MetadataNode: r.NodeID},
+				})
+				for _, tag := range r.Tags {
+					tags[tag] = struct{}{}
+				}
+			}
+			services = append(services, service)
+			records = append(records, nb.createFrontendRecords(serviceName, tags)...)
+		}
+	}
+
+	result, err := mergeTrees(nb.Logger, nb.config, services, records)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return result, nil
+}
+
+// LuaScripts returns no scripts, since they cannot be stored in Nomad.
+func (nb *nomadBackend) LuaScripts(ctx context.Context) (map[string]string, error) {
+	return nil, nil
+}
+
+// createFrontendRecords creates the frontend records (one per mode) specified in the given tags of a service.
+// Tags are formatted as `<prefix>[host]/path [option ...]` or `<prefix>:port proto=tcp`.
+// Supported options are `proto=http|tcp`, `strip=<path>` and `private=true`.
+func (nb *nomadBackend) createFrontendRecords(serviceName string, tags map[string]struct{}) []api.FrontendRecord {
+	var sortedTags []string
+	for tag := range tags {
+		if strings.HasPrefix(tag, nb.nomad.TagPrefix) {
+			sortedTags = append(sortedTags, tag)
+		}
+	}
+	sort.Strings(sortedTags)
+
+	var result []api.FrontendRecord
+	recordIndex := make(map[string]int) // mode -> index in result
+	for _, tag := range sortedTags {
+		mode, sel, err := parseNomadTag(strings.TrimPrefix(tag, nb.nomad.TagPrefix))
+		if err == nil {
+			err = sel.Validate()
+		}
+		if err != nil {
+			nb.Logger.Warningf("Ignoring tag '%s' of service '%s': %v", tag, serviceName, err)
+			continue
+		}
+		i, found := recordIndex[mode]
+		if !found {
+			i = len(result)
+			recordIndex[mode] = i
+			result = append(result, api.FrontendRecord{
+				Service: serviceName,
+				Mode:    mode,
+			})
+		}
+		result[i].Selectors = append(result[i].Selectors, sel)
+	}
+	return result
+}
+
+// parseNomadTag parses a tag (without prefix) into a mode and a selector.
+func parseNomadTag(tag string) (string, api.FrontendSelectorRecord, error) {
+	var sel api.FrontendSelectorRecord
+	fields := strings.Fields(tag)
+	if len(fields) == 0 {
+		return "", sel, maskAny(fmt.Errorf("route missing"))
+	}
+	mode := "http"
+	for _, opt := range fields[1:] {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return "", sel, maskAny(fmt.Errorf("invalid option '%s', expected key=value", opt))
+		}
+		switch kv[0] {
+		case "proto":
+			if kv[1] != "http" && kv[1] != "tcp" {
+				return "", sel, maskAny(fmt.Errorf("unsupported proto '%s'", kv[1]))
+			}
+			mode = kv[1]
+		case "strip":
+			sel.RewriteRules = append(sel.RewriteRules, api.RewriteRule{RemovePathPrefix: kv[1]})
+		case "private":
+			private, err := strconv.ParseBool(kv[1])
+			if err != nil {
+				return "", sel, maskAny(err)
+			}
+			sel.Private = private
+		default:
+			return "", sel, maskAny(fmt.Errorf("unsupported option '%s'", kv[0]))
+		}
+	}
+
+	route := fields[0]
+	if mode == "tcp" {
+		port, err := strconv.Atoi(strings.TrimPrefix(route, ":"))
+		if err != nil || !strings.HasPrefix(route, ":") {
+			return "", sel, maskAny(fmt.Errorf("invalid tcp route '%s', expected :port", route))
+		}
+		sel.FrontendPort = port
+		return mode, sel, nil
+	}
+	slash := strings.Index(route, "/")
+	if slash < 0 {
+		return "", sel, maskAny(fmt.Errorf("invalid route '%s', expected [host]/path", route))
+	}
+	sel.Domain = route[:slash]
+	if path := route[slash:]; path != "/" {
+		sel.PathPrefix = path
+	}
+	return mode, sel, nil
+}
+
+// get performs a GET request on the Nomad HTTP API and decodes the response into the given result (if not nil).
+// It returns the index of the response.
+func (nb *nomadBackend) get(ctx context.Context, path string, query url.Values, result interface{}) (uint64, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(nb.nomad.Address, "/")+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return 0, maskAny(err)
+	}
+	req = req.WithContext(ctx)
+	if nb.nomad.Token != "" {
+		req.Header.Set(nomadTokenHeader, nb.nomad.Token)
+	}
+	resp, err := nb.client.Do(req)
+	if err != nil {
+		return 0, maskAny(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, maskAny(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, maskAny(fmt.Errorf("GET %s failed with status %d: %s", path, resp.StatusCode, strings.TrimSpace(string(body))))
+	}
+	index, _ := strconv.ParseUint(resp.Header.Get(nomadIndexHeader), 10, 64)
+	if result != nil {
+		if err := json.Unmarshal(body, result); err != nil {
+			return 0, maskAny(err)
+		}
+	}
+	return index, nil
+}
@@ -0,0 +1,115 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	logging "github.com/op/go-logging"
+)
+
+// fakeNomad serves the native service discovery API of Nomad from the given registrations.
+func fakeNomad(t *testing.T, index string, registrations []nomadServiceRegistration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespace := r.URL.Query().Get("namespace")
+		var result interface{}
+		switch {
+		case r.URL.Path == "/v1/services":
+			lists := []nomadServiceList{}
+			listIndex := make(map[string]int)
+			seen := make(map[string]bool)
+			for _, reg := range registrations {
+				if namespace != "*" && namespace != reg.Namespace {
+					continue
+				}
+				i, found := listIndex[reg.Namespace]
+				if !found {
+					i = len(lists)
+					listIndex[reg.Namespace] = i
+					lists = append(lists, nomadServiceList{Namespace: reg.Namespace})
+				}
+				if key := reg.Namespace + "/" + reg.ServiceName; !seen[key] {
+					seen[key] = true
+					lists[i].Services = append(lists[i].Services, struct {
+						ServiceName string
+						Tags        []string
+					}{reg.ServiceName, reg.Tags})
+				}
+			}
+			result = lists
+		case strings.HasPrefix(r.URL.Path, "/v1/service/"):
+			name := strings.TrimPrefix(r.URL.Path, "/v1/service/")
+			list := []nomadServiceRegistration{}
+			for _, reg := range registrations {
+				if reg.ServiceName == name && reg.Namespace == namespace {
+					list = append(list, reg)
+				}
+			}
+			result = list
+		default:
+			t.Errorf("Unexpected request %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set(nomadIndexHeader, index)
+		json.NewEncoder(w).Encode(result)
+	}))
+}
+
+func TestNomadServices(t *testing.T) {
+	registrations := []nomadServiceRegistration{
+		nomadServiceRegistration{ServiceName: "web", Namespace: "default", NodeID: "n1", Address: "10.0.0.1", Port: 23456,
+			Tags: []string{"urlprefix-foo.com/", "urlprefix-/static strip=/static", "other"}},
+		nomadServiceRegistration{ServiceName: "web", Namespace: "default", NodeID: "n2", Address: "10.0.0.2", Port: 23457,
+			Tags: []string{"urlprefix-foo.com/", "urlprefix-web.private/ private=true"}},
+		nomadServiceRegistration{ServiceName: "db", Namespace: "default", NodeID: "n2", Address: "10.0.0.2", Port: 5432,
+			Tags: []string{"urlprefix-:5432 proto=tcp private=true", "urlprefix-bad tag"}},
+		nomadServiceRegistration{ServiceName: "api", Namespace: "apps", NodeID: "n1", Address: "10.0.1.1", Port: 8080,
+			Tags: []string{"urlprefix-api.foo.com/v1"}},
+		nomadServiceRegistration{ServiceName: "hidden", Namespace: "other", NodeID: "n1", Address: "10.0.2.1", Port: 8080,
+			Tags: []string{"urlprefix-hidden.foo.com/"}},
+	}
+	server := fakeNomad(t, "7", registrations)
+	defer server.Close()
+
+	b, err := NewNomadBackend(k8sTestConfig, NomadConfig{
+		Address:    server.URL,
+		Namespaces: []string{"default", "apps"},
+	}, logging.MustGetLogger("test"))
+	if err != nil {
+		t.Fatalf("NewNomadBackend failed: %#v", err)
+	}
+	if err := b.Watch(context.Background()); err != nil {
+		t.Fatalf("Watch failed: %#v", err)
+	}
+	services, err := b.Services(context.Background())
+	if err != nil {
+		t.Fatalf("Services failed: %#v", err)
+	}
+	for i, s := range services {
+		services[i] = s.Normalize()
+	}
+	services.Sort()
+	result, err := json.MarshalIndent(services, "", "  ")
+	if err != nil {
+		t.Fatalf("Cannot marshal services: %#v", err)
+	}
+	resultPath := "./fixtures/nomad_services.json"
+	if os.Getenv("UPDATE-FIXTURES") == "1" {
+		if err := ioutil.WriteFile(resultPath, append(result, '\n'), 0644); err != nil {
+			t.Errorf("Cannot update fixture %s: %#v", resultPath, err)
+		}
+		return
+	}
+	expected, err := ioutil.ReadFile(resultPath)
+	if err != nil {
+		t.Errorf("Cannot read fixture %s: %#v", resultPath, err)
+	} else if string(expected) != string(result)+"\n" {
+		t.Errorf("Unexpected services for %s: got\n%s", resultPath, string(result))
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"sort"
+
+	"github.com/juju/errgo"
+	api "github.com/pulcy/robin-api"
+)
+
+// PortReservation is the claim of a frontend record on an edge port.
+type PortReservation struct {
+	EdgeGroup string `json:"edge-group,omitempty"`
+	Port      int    `json:"port"`
+	Public    bool   `json:"public"`
+	Mode      string `json:"mode"`
+	Owner     string `json:"owner,omitempty"` // ID of the frontend record that claimed the port first (empty for the built-in HTTP frontends)
+}
+
+// PortRegistry keeps the reservations of edge ports by frontend records, per edge group.
+// Every edge port is served by a single frontend, so all records that use it must agree
+// on its mode & visibility.
+type PortRegistry struct {
+	config       BackendConfig
+	reservations map[string]map[int]PortReservation // edge-group -> port -> reservation
+}
+
+// NewPortRegistry creates an empty registry for load-balancers with the given config.
+func NewPortRegistry(config BackendConfig) *PortRegistry {
+	return &PortRegistry{
+		config:       config,
+		reservations: make(map[string]map[int]PortReservation),
+	}
+}
+
+// Reserve claims the edge ports used by the selectors of the given record for the owner with given ID.
+// It returns a PortConflictError (and reserves nothing) when one of these ports is reserved
+// with another mode or visibility.
+func (r *PortRegistry) Reserve(owner string, record api.FrontendRecord) error {
+	group := r.group(record.EdgeGroup)
+	mode := record.Mode
+	if mode == "" {
+		mode = "http"
+	}
+	var claims []PortReservation
+	for _, sel := range record.AllSelectors() {
+		claim := PortReservation{
+			EdgeGroup: record.EdgeGroup,
+			Port:      r.config.edgePort(sel.FrontendPort, sel.Private, mode),
+			Public:    !sel.Private,
+			Mode:      mode,
+			Owner:     owner,
+		}
+		if current, found := group[claim.Port]; found && current.conflictsWith(claim) {
+			return maskAny(errgo.WithCausef(nil, api.PortConflictError, "edge port %d is used as %s by %s, cannot use it as %s",
+				claim.Port, current.describe(), current.ownerName(), claim.describe()))
+		}
+		for _, prev := range claims {
+			if prev.Port == claim.Port && prev.conflictsWith(claim) {
+				return maskAny(errgo.WithCausef(nil, api.PortConflictError, "edge port %d is used both as %s and as %s",
+					claim.Port, prev.describe(), claim.describe()))
+			}
+		}
+		claims = append(claims, claim)
+	}
+	for _, claim := range claims {
+		if _, found := group[claim.Port]; !found {
+			group[claim.Port] = claim
+		}
+	}
+	return nil
+}
+
+// Reservations returns all reservations, sorted by edge group & port.
+func (r *PortRegistry) Reservations() []PortReservation {
+	var result []PortReservation
+	for _, group := range r.reservations {
+		for _, res := range group {
+			result = append(result, res)
+		}
+	}
+	sort.Sort(portReservations(result))
+	return result
+}
+
+// portReservations sorts a list of reservations by edge group & port.
+type portReservations []PortReservation
+
+func (l portReservations) Len() int      { return len(l) }
+func (l portReservations) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l portReservations) Less(i, j int) bool {
+	if l[i].EdgeGroup != l[j].EdgeGroup {
+		return l[i].EdgeGroup < l[j].EdgeGroup
+	}
+	return l[i].Port < l[j].Port
+}
+
+// group returns the reservations of the given edge group, starting with those of the built-in HTTP frontends.
+func (r *PortRegistry) group(edgeGroup string) map[int]PortReservation {
+	group, found := r.reservations[edgeGroup]
+	if !found {
+		group = map[int]PortReservation{
+			r.config.PublicEdgePort:      PortReservation{EdgeGroup: edgeGroup, Port: r.config.PublicEdgePort, Public: true, Mode: "http"},
+			r.config.PrivateHttpEdgePort: PortReservation{EdgeGroup: edgeGroup, Port: r.config.PrivateHttpEdgePort, Public: false, Mode: "http"},
+		}
+		r.reservations[edgeGroup] = group
+	}
+	return group
+}
+
+// conflictsWith returns true if the given reservation cannot share a frontend with this one.
+func (r PortReservation) conflictsWith(other PortReservation) bool {
+	return r.Public != other.Public || r.Mode != other.Mode
+}
+
+// describe returns the visibility & mode of the reservation, e.g. "public http".
+func (r PortReservation) describe() string {
+	if r.Public {
+		return "public " + r.Mode
+	}
+	return "private " + r.Mode
+}
+
+// ownerName returns a description of the owner of the reservation for use in errors.
+func (r PortReservation) ownerName() string {
+	if r.Owner == "" {
+		return "the built-in frontend"
+	}
+	return "frontend '" + r.Owner + "'"
+}
@@ -0,0 +1,46 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+
+	api "github.com/pulcy/robin-api"
+)
+
+func TestPortRegistry(t *testing.T) {
+	registry := NewPortRegistry(k8sTestConfig)
+	tests := []struct {
+		Owner    string
+		Record   api.FrontendRecord
+		Conflict string // Expected part of the error (empty means no conflict)
+	}{
+		{"web", api.FrontendRecord{Service: "web", Selectors: []api.FrontendSelectorRecord{{Domain: "foo.com"}, {Domain: "web.private", Private: true}}}, ""},
+		{"db", api.FrontendRecord{Service: "db", Mode: "tcp", Selectors: []api.FrontendSelectorRecord{{FrontendPort: 5432, Private: true}}}, ""},
+		{"db2", api.FrontendRecord{Service: "db2", Mode: "tcp", Selectors: []api.FrontendSelectorRecord{{FrontendPort: 5432, Private: true, ServicePort: 5433}}}, ""},
+		{"db-public", api.FrontendRecord{Service: "db", Mode: "tcp", Selectors: []api.FrontendSelectorRecord{{FrontendPort: 5432}}}, "used as private tcp by frontend 'db'"},
+		{"tenant/api", api.FrontendRecord{Service: "api", Selectors: []api.FrontendSelectorRecord{{FrontendPort: 5432, Private: true}}}, "used as private tcp by frontend 'db'"},
+		{"public-tcp", api.FrontendRecord{Service: "x", Mode: "tcp", Selectors: []api.FrontendSelectorRecord{{Domain: "x.com"}}}, "by the built-in frontend"},
+		{"mixed", api.FrontendRecord{Service: "x", Mode: "tcp", Selectors: []api.FrontendSelectorRecord{{FrontendPort: 6000}, {FrontendPort: 6000, Private: true}}}, "used both as public tcp and as private tcp"},
+		{"other-group", api.FrontendRecord{Service: "db", Mode: "tcp", EdgeGroup: "other", Selectors: []api.FrontendSelectorRecord{{FrontendPort: 5432}}}, ""},
+	}
+	for _, test := range tests {
+		err := registry.Reserve(test.Owner, test.Record)
+		if test.Conflict == "" {
+			if err != nil {
+				t.Errorf("Expected no conflict for %s, got %v", test.Owner, err)
+			}
+			continue
+		}
+		if !api.IsPortConflict(err) {
+			t.Errorf("Expected port conflict for %s, got %v", test.Owner, err)
+		} else if !strings.Contains(err.Error(), test.Conflict) {
+			t.Errorf("Expected error containing '%s' for %s, got '%s'", test.Conflict, test.Owner, err.Error())
+		}
+	}
+	// Conflicting records reserve nothing
+	for _, res := range registry.Reservations() {
+		if res.Port == 6000 {
+			t.Errorf("Expected no reservation of port 6000, got %#v", res)
+		}
+	}
+}
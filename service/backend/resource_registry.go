@@ -15,119 +15,287 @@
 package backend
 
 import (
+	"context"
+	"math/rand"
 	"sync"
+	"time"
 
 	k8s "github.com/YakLabs/k8s-client"
 	"github.com/YakLabs/k8s-client/http"
+	"github.com/juju/errgo"
 	logging "github.com/op/go-logging"
 )
 
 const (
 	defaultWatchBufferSize = 32
+	watchEstablishedAfter  = time.Second * 5 // Time after which a running watch is considered established
 )
 
-func newResourceRegistry(log *logging.Logger) (*resourceRegistry, error) {
+var (
+	// watchUnsupportedError is returned by a watch function when the apiserver does not serve the watched resource.
+	watchUnsupportedError = errgo.New("watch unsupported")
+)
+
+func newResourceRegistry(config KubernetesConfig, log *logging.Logger) (*resourceRegistry, error) {
 	client, err := http.NewInCluster()
 	if err != nil {
 		return nil, maskAny(err)
 	}
+	return newResourceRegistryWithClient(client, config, log), nil
+}
+
+// newResourceRegistryWithClient creates a registry that uses the given client.
+func newResourceRegistryWithClient(client k8s.Client, config KubernetesConfig, log *logging.Logger) *resourceRegistry {
 	return &resourceRegistry{
 		client:          client,
+		config:          config,
 		log:             log,
 		watchBufferSize: defaultWatchBufferSize,
 		nodes:           make(map[string]k8s.Node),
 		services:        make(map[string]k8s.Service),
 		endpoints:       make(map[string]k8s.Endpoints),
+		endpointSlices:  make(map[string]map[string]k8s.EndpointSlice),
 		ingresses:       make(map[string]k8s.Ingress),
-	}, nil
+		secrets:         make(map[string]k8s.Secret),
+		tlsCertNames:    make(map[string]string),
+		frontends:       make(map[string]RobinFrontend),
+		podWeights:      make(map[string]string),
+	}
 }
 
 type resourceRegistry struct {
 	client          k8s.Client
+	config          KubernetesConfig
 	log             *logging.Logger
+	reportWatch     func(resource string, err error) // If set, called with the outcome of every watch
 	accessMutex     sync.RWMutex
 	watchBufferSize int
 
-	nodes     map[string]k8s.Node
-	services  map[string]k8s.Service
-	endpoints map[string]k8s.Endpoints
-	ingresses map[string]k8s.Ingress
+	nodes          map[string]k8s.Node
+	services       map[string]k8s.Service
+	endpoints      map[string]k8s.Endpoints
+	endpointSlices map[string]map[string]k8s.EndpointSlice // service key -> slice name -> slice
+	ingresses      map[string]k8s.Ingress
+	secrets        map[string]k8s.Secret
+	tlsCertNames   map[string]string // secret key -> name of its bundle in the ssl-certs folder
+	frontends      map[string]RobinFrontend
+	podWeights     map[string]string // pod key -> value of its weight annotation
 }
 
 // Start runs watches on the apiserver and maintains the current state of the resources in it.
-// It sends an event in the given channel when a change is detected.
-func (r *resourceRegistry) Start(onChange chan struct{}) {
-	// Watch nodes
+// The events of all watches are applied by a single goroutine, which calls notify when a change is detected.
+// The watches (including running ones) are stopped when the given context is canceled.
+func (r *resourceRegistry) Start(ctx context.Context, notify func()) {
+	updates := make(chan func() bool, r.watchBufferSize)
 	go func() {
 		for {
-			events := make(chan k8s.NodeWatchEvent, r.watchBufferSize)
-			go func() {
-				for evt := range events {
-					if r.updateNode(evt) {
-						onChange <- struct{}{}
-					}
+			select {
+			case update := <-updates:
+				if update() && ctx.Err() == nil {
+					notify()
 				}
-			}()
-			r.log.Debugf("watching node events")
-			if err := r.client.WatchNodes(nil, events); err != nil {
-				r.log.Errorf("WatchNodes failed: %v", err)
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
 
+	// Watch nodes
+	go r.watch(ctx, "node", "", func() error {
+		events := make(chan k8s.NodeWatchEvent, r.watchBufferSize)
+		go func() {
+			for evt := range events {
+				evt := evt
+				forwardUpdate(ctx, updates, func() bool { return r.updateNode(evt) })
+			}
+		}()
+		return r.client.WatchNodes(withWatchContext(ctx, nil), events)
+	})
+
+	for _, namespace := range r.config.watchNamespaces() {
+		r.startNamespace(ctx, namespace, updates)
+	}
+}
+
+// startNamespace runs the watches on the namespaced resources in the given namespace
+// (empty means all namespaces). Their events are sent to the given updates channel.
+func (r *resourceRegistry) startNamespace(ctx context.Context, namespace string, updates chan<- func() bool) {
 	// Watch ingresses
-	go func() {
-		for {
-			events := make(chan k8s.IngressWatchEvent, r.watchBufferSize)
+	go r.watch(ctx, "ingress", namespace, func() error {
+		events := make(chan k8s.IngressWatchEvent, r.watchBufferSize)
+		go func() {
+			for evt := range events {
+				evt := evt
+				forwardUpdate(ctx, updates, func() bool { return r.updateIngress(evt) })
+			}
+		}()
+		return r.client.WatchIngresses(namespace, withWatchContext(ctx, r.config.ingressWatchOptions()), events)
+	})
+
+	// Watch endpoint slices (or endpoints)
+	go r.watchEndpoints(ctx, namespace, updates)
+
+	// Watch services
+	go r.watch(ctx, "service", namespace, func() error {
+		events := make(chan k8s.ServiceWatchEvent, r.watchBufferSize)
+		go func() {
+			for evt := range events {
+				evt := evt
+				forwardUpdate(ctx, updates, func() bool { return r.updateService(evt) })
+			}
+		}()
+		return r.client.WatchServices(namespace, withWatchContext(ctx, nil), events)
+	})
+
+	// Watch TLS secrets (only needed for ingress TLS sections)
+	if r.config.SslCertsFolder != "" {
+		go r.watch(ctx, "secret", namespace, func() error {
+			events := make(chan k8s.SecretWatchEvent, r.watchBufferSize)
 			go func() {
 				for evt := range events {
-					if r.updateIngress(evt) {
-						onChange <- struct{}{}
-					}
+					evt := evt
+					forwardUpdate(ctx, updates, func() bool { return r.updateSecret(evt) })
 				}
 			}()
-			r.log.Debugf("watching ingress events")
-			if err := r.client.WatchIngresses("", nil, events); err != nil {
-				r.log.Errorf("WatchIngresses failed: %v", err)
-			}
-		}
-	}()
+			return r.client.WatchSecrets(namespace, withWatchContext(ctx, tlsSecretWatchOptions()), events)
+		})
+	}
 
-	// Watch endpoints
-	go func() {
-		for {
-			events := make(chan k8s.EndpointsWatchEvent, r.watchBufferSize)
+	// Watch pods (only their weight annotation is used)
+	if r.config.PodWeights {
+		go r.watch(ctx, "pod", namespace, func() error {
+			events := make(chan k8s.PodWatchEvent, r.watchBufferSize)
 			go func() {
 				for evt := range events {
-					if r.updateEndpoints(evt) {
-						onChange <- struct{}{}
-					}
+					evt := evt
+					forwardUpdate(ctx, updates, func() bool { return r.updatePod(evt) })
 				}
 			}()
-			r.log.Debugf("watching endpoints events")
-			if err := r.client.WatchEndpoints("", nil, events); err != nil {
-				r.log.Errorf("WatchEndpoints failed: %v", err)
-			}
-		}
-	}()
+			return r.client.WatchPods(namespace, withWatchContext(ctx, nil), events)
+		})
+	}
 
-	// Watch services
-	go func() {
-		for {
-			events := make(chan k8s.ServiceWatchEvent, r.watchBufferSize)
+	// Watch RobinFrontend custom resources
+	if r.config.RobinFrontends {
+		go r.watch(ctx, "robinfrontend", namespace, func() error {
+			events := make(chan k8s.WatchEvent, r.watchBufferSize)
+			go func() {
+				for evt := range events {
+					evt := evt
+					forwardUpdate(ctx, updates, func() bool { return r.updateRobinFrontend(evt) })
+				}
+			}()
+			return r.client.WatchCustomResources(RobinFrontendGroup, RobinFrontendVersion, RobinFrontendPlural, namespace, withWatchContext(ctx, nil), events)
+		})
+	}
+}
+
+// watchEndpoints watches the endpoint slices in the given namespace.
+// If the apiserver does not serve endpoint slices (or LegacyEndpoints is set),
+// the endpoints in the given namespace are watched instead.
+func (r *resourceRegistry) watchEndpoints(ctx context.Context, namespace string, updates chan<- func() bool) {
+	if !r.config.LegacyEndpoints {
+		err := r.watch(ctx, "endpointslice", namespace, func() error {
+			events := make(chan k8s.EndpointSliceWatchEvent, r.watchBufferSize)
 			go func() {
 				for evt := range events {
-					if r.updateService(evt) {
-						onChange <- struct{}{}
-					}
+					evt := evt
+					forwardUpdate(ctx, updates, func() bool { return r.updateEndpointSlice(evt) })
 				}
 			}()
-			r.log.Debugf("watching service events")
-			if err := r.client.WatchServices("", nil, events); err != nil {
-				r.log.Errorf("WatchServices failed: %v", err)
+			if err := r.client.WatchEndpointSlices(namespace, withWatchContext(ctx, nil), events); err != nil {
+				if k8s.IsNotFoundError(err) {
+					return maskAny(watchUnsupportedError)
+				}
+				return maskAny(err)
 			}
+			return nil
+		})
+		if err == nil {
+			return
 		}
-	}()
+		r.log.Infof("EndpointSlices are not served by the apiserver, watching Endpoints in namespace '%s' instead", namespace)
+	}
+	r.watch(ctx, "endpoints", namespace, func() error {
+		events := make(chan k8s.EndpointsWatchEvent, r.watchBufferSize)
+		go func() {
+			for evt := range events {
+				evt := evt
+				forwardUpdate(ctx, updates, func() bool { return r.updateEndpoints(evt) })
+			}
+		}()
+		return r.client.WatchEndpoints(namespace, withWatchContext(ctx, nil), events)
+	})
+}
+
+// forwardUpdate sends the given update to the goroutine that applies all updates.
+// Once the given context is canceled, the update is dropped, so the events of a
+// watch that is being stopped can still be drained.
+func forwardUpdate(ctx context.Context, updates chan<- func() bool, update func() bool) {
+	select {
+	case updates <- update:
+	case <-ctx.Done():
+	}
+}
+
+// withWatchContext returns a copy of the given watch options (nil means none) that
+// ends the watch when the given context is canceled.
+func withWatchContext(ctx context.Context, opts *k8s.WatchOptions) *k8s.WatchOptions {
+	result := &k8s.WatchOptions{}
+	if opts != nil {
+		*result = *opts
+	}
+	result.Context = ctx
+	return result
+}
+
+// watch runs the given watch function until the given context is canceled.
+// The watch function starts a goroutine that forwards the events of a single watch.
+// That goroutine ends when the client closes the events channel, which happens when the watch ends
+// (at the latest when the given context is canceled).
+// If the watch function returns a watchUnsupportedError, watching stops and that error is returned.
+// The outcome of every other watch is reported. Failed watches are retried with an exponential backoff (with jitter).
+func (r *resourceRegistry) watch(ctx context.Context, kind, namespace string, watchFunc func() error) error {
+	resource := kind
+	if namespace != "" {
+		resource = namespace + "/" + kind
+	}
+	failures := 0
+	for ctx.Err() == nil {
+		r.log.Debugf("watching %s events in namespace '%s'", kind, namespace)
+		var established *time.Timer
+		if r.reportWatch != nil && failures > 0 {
+			// A watch that has not failed for a while has recovered
+			established = time.AfterFunc(watchEstablishedAfter, func() { r.reportWatch(resource, nil) })
+		}
+		err := watchFunc()
+		if established != nil {
+			established.Stop()
+		}
+		if err != nil && ctx.Err() != nil {
+			// The watch failed because it was canceled
+			break
+		}
+		if err != nil && errgo.Cause(err) == watchUnsupportedError {
+			return maskAny(err)
+		}
+		if r.reportWatch != nil {
+			r.reportWatch(resource, err)
+		}
+		if err != nil {
+			failures++
+			delay := r.config.watchRetryDelay(failures, rand.Float64())
+			r.log.Errorf("Watching %s events failed %d times (retry in %s): %v", kind, failures, delay, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+			continue
+		}
+		failures = 0
+	}
+	r.log.Debugf("stopped watching %s events in namespace '%s'", kind, namespace)
+	return nil
 }
 
 // GetNode returns a node by name.
@@ -175,11 +343,19 @@ func (r *resourceRegistry) GetIngresses() []k8s.Ingress {
 }
 
 // GetEndpoint returns an endpoint by namespace+name.
+// When endpoint slices are watched, the endpoint is merged from the slices of the service with that name.
 func (r *resourceRegistry) GetEndpoint(namespace, endpointsName string) (k8s.Endpoints, bool) {
 	r.accessMutex.RLock()
 	defer r.accessMutex.RUnlock()
 
 	key := r.createKey(namespace, endpointsName)
+	if slices, ok := r.endpointSlices[key]; ok {
+		list := make([]k8s.EndpointSlice, 0, len(slices))
+		for _, s := range slices {
+			list = append(list, s)
+		}
+		return endpointsFromSlices(namespace, endpointsName, list), true
+	}
 	result, ok := r.endpoints[key]
 	return result, ok
 }
@@ -189,10 +365,17 @@ func (r *resourceRegistry) GetEndpoints() []k8s.Endpoints {
 	r.accessMutex.RLock()
 	defer r.accessMutex.RUnlock()
 
-	result := make([]k8s.Endpoints, 0, len(r.endpoints))
+	result := make([]k8s.Endpoints, 0, len(r.endpoints)+len(r.endpointSlices))
 	for _, s := range r.endpoints {
 		result = append(result, s)
 	}
+	for _, slices := range r.endpointSlices {
+		list := make([]k8s.EndpointSlice, 0, len(slices))
+		for _, s := range slices {
+			list = append(list, s)
+		}
+		result = append(result, endpointsFromSlices(list[0].Namespace, list[0].Labels[k8s.LabelServiceName], list))
+	}
 	return result
 }
 
@@ -218,6 +401,38 @@ func (r *resourceRegistry) GetServices() []k8s.Service {
 	return result
 }
 
+// GetSecret returns a (TLS) secret by namespace+name.
+func (r *resourceRegistry) GetSecret(namespace, secretName string) (k8s.Secret, bool) {
+	r.accessMutex.RLock()
+	defer r.accessMutex.RUnlock()
+
+	key := r.createKey(namespace, secretName)
+	result, ok := r.secrets[key]
+	return result, ok
+}
+
+// GetPodWeight returns the value of the weight annotation of a pod by namespace+name.
+func (r *resourceRegistry) GetPodWeight(namespace, podName string) (string, bool) {
+	r.accessMutex.RLock()
+	defer r.accessMutex.RUnlock()
+
+	key := r.createKey(namespace, podName)
+	result, ok := r.podWeights[key]
+	return result, ok
+}
+
+// GetRobinFrontends returns a list of all known RobinFrontend resources
+func (r *resourceRegistry) GetRobinFrontends() []RobinFrontend {
+	r.accessMutex.RLock()
+	defer r.accessMutex.RUnlock()
+
+	result := make([]RobinFrontend, 0, len(r.frontends))
+	for _, s := range r.frontends {
+		result = append(result, s)
+	}
+	return result
+}
+
 func (r *resourceRegistry) updateNode(evt k8s.NodeWatchEvent) bool {
 	switch evt.Type() {
 	case k8s.WatchEventTypeModified:
@@ -298,6 +513,48 @@ func (r *resourceRegistry) updateEndpoints(evt k8s.EndpointsWatchEvent) bool {
 	}
 }
 
+func (r *resourceRegistry) updateEndpointSlice(evt k8s.EndpointSliceWatchEvent) bool {
+	switch evt.Type() {
+	case k8s.WatchEventTypeAdded, k8s.WatchEventTypeModified, k8s.WatchEventTypeDeleted:
+		resource, err := evt.Object()
+		if err != nil {
+			r.log.Errorf("Failed to process resource event: %#v", err)
+			return false
+		}
+		r.log.Debugf("EndpointSlice %s.%s %s", resource.Name, resource.Namespace, evt.Type())
+		serviceName := resource.Labels[k8s.LabelServiceName]
+		if serviceName == "" {
+			// Not a slice of a service
+			return false
+		}
+		key := r.createKey(resource.Namespace, serviceName)
+		r.accessMutex.Lock()
+		defer r.accessMutex.Unlock()
+		slices := r.endpointSlices[key]
+		existing, found := slices[resource.Name]
+		if evt.Type() == k8s.WatchEventTypeDeleted {
+			if !found {
+				return false
+			}
+			delete(slices, resource.Name)
+			if len(slices) == 0 {
+				delete(r.endpointSlices, key)
+			}
+			return true
+		}
+		if slices == nil {
+			slices = make(map[string]k8s.EndpointSlice)
+			r.endpointSlices[key] = slices
+		}
+		// Check for different addresses
+		slices[resource.Name] = *resource
+		return !found || endpointSliceChanged(existing, *resource)
+	default:
+		r.log.Warningf("unknown endpointslice watch event of type '%s'", evt.Type())
+		return false
+	}
+}
+
 func endpointChanged(a, b k8s.Endpoints) bool {
 	describe := func(x k8s.Endpoints) string {
 		result := ""
@@ -329,11 +586,17 @@ func (r *resourceRegistry) updateIngress(evt k8s.IngressWatchEvent) bool {
 		key := r.createKey(resource.Namespace, resource.Name)
 		r.accessMutex.Lock()
 		defer r.accessMutex.Unlock()
-		if evt.Type() == k8s.WatchEventTypeDeleted {
+		if evt.Type() == k8s.WatchEventTypeDeleted || !r.config.matchesIngressClass(*resource) {
+			// Ingresses of another class are treated as deleted, since their class may have changed
+			_, found := r.ingresses[key]
 			delete(r.ingresses, key)
-		} else {
-			r.ingresses[key] = *resource
+			if found {
+				r.syncTLSFiles()
+			}
+			return found
 		}
+		r.ingresses[key] = *resource
+		r.syncTLSFiles()
 		return true
 	default:
 		r.log.Warningf("unknown ingress watch event of type '%s'", evt.Type())
@@ -341,6 +604,92 @@ func (r *resourceRegistry) updateIngress(evt k8s.IngressWatchEvent) bool {
 	}
 }
 
+func (r *resourceRegistry) updateSecret(evt k8s.SecretWatchEvent) bool {
+	switch evt.Type() {
+	case k8s.WatchEventTypeAdded, k8s.WatchEventTypeModified, k8s.WatchEventTypeDeleted:
+		resource, err := evt.Object()
+		if err != nil {
+			r.log.Errorf("Failed to process resource event: %#v", err)
+			return false
+		}
+		r.log.Debugf("Secret %s.%s %s", resource.Name, resource.Namespace, evt.Type())
+		key := r.createKey(resource.Namespace, resource.Name)
+		r.accessMutex.Lock()
+		defer r.accessMutex.Unlock()
+		existing, found := r.secrets[key]
+		if evt.Type() == k8s.WatchEventTypeDeleted || resource.Type != tlsSecretType {
+			delete(r.secrets, key)
+			if found {
+				r.syncTLSFiles()
+			}
+			return found
+		}
+		// Only changes of the certificate or key are relevant
+		r.secrets[key] = *resource
+		if found && !tlsSecretChanged(existing, *resource) {
+			return false
+		}
+		r.syncTLSFiles()
+		return true
+	default:
+		r.log.Warningf("unknown secret watch event of type '%s'", evt.Type())
+		return false
+	}
+}
+
+func (r *resourceRegistry) updatePod(evt k8s.PodWatchEvent) bool {
+	switch evt.Type() {
+	case k8s.WatchEventTypeAdded, k8s.WatchEventTypeModified, k8s.WatchEventTypeDeleted:
+		resource, err := evt.Object()
+		if err != nil {
+			r.log.Errorf("Failed to process resource event: %#v", err)
+			return false
+		}
+		key := r.createKey(resource.Namespace, resource.Name)
+		weight, annotated := resource.Annotations[PodWeightAnnotationKey]
+		r.accessMutex.Lock()
+		defer r.accessMutex.Unlock()
+		existing, found := r.podWeights[key]
+		if evt.Type() == k8s.WatchEventTypeDeleted || !annotated {
+			delete(r.podWeights, key)
+			return found
+		}
+		// Only changes of the weight annotation are relevant
+		r.log.Debugf("Pod %s.%s has weight %s", resource.Name, resource.Namespace, weight)
+		r.podWeights[key] = weight
+		return !found || existing != weight
+	default:
+		r.log.Warningf("unknown pod watch event of type '%s'", evt.Type())
+		return false
+	}
+}
+
+func (r *resourceRegistry) updateRobinFrontend(evt k8s.WatchEvent) bool {
+	switch evt.Type {
+	case k8s.WatchEventTypeAdded, k8s.WatchEventTypeModified, k8s.WatchEventTypeDeleted:
+		resource, err := decodeRobinFrontend(evt)
+		if err != nil {
+			r.log.Errorf("Failed to process resource event: %#v", err)
+			return false
+		}
+		r.log.Debugf("RobinFrontend %s.%s %s", resource.Name, resource.Namespace, evt.Type)
+		key := r.createKey(resource.Namespace, resource.Name)
+		r.accessMutex.Lock()
+		defer r.accessMutex.Unlock()
+		existing, found := r.frontends[key]
+		if evt.Type == k8s.WatchEventTypeDeleted {
+			delete(r.frontends, key)
+			return found
+		}
+		// Only changes of the record are relevant
+		r.frontends[key] = *resource
+		return !found || robinFrontendChanged(existing, *resource)
+	default:
+		r.log.Warningf("unknown robinfrontend watch event of type '%s'", evt.Type)
+		return false
+	}
+}
+
 func (r *resourceRegistry) createKey(namespace, resourceName string) string {
 	return resourceName + "." + namespace
 }
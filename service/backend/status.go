@@ -0,0 +1,131 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BackendStatus describes how well a backend keeps up with its source.
+type BackendStatus struct {
+	Ready                  bool       `json:"ready"`                           // Set when services have been loaded and neither the last load nor the last watch failed
+	LastServices           *time.Time `json:"last-services,omitempty"`         // Time of the last successful load of services
+	LastServicesError      string     `json:"last-services-error,omitempty"`   // Error of the last load of services (if it failed)
+	LastWatch              *time.Time `json:"last-watch,omitempty"`            // Time of the last watch that ended without an error
+	LastWatchError         string     `json:"last-watch-error,omitempty"`      // Error of the last failed watch
+	LastWatchErrorTime     *time.Time `json:"last-watch-error-time,omitempty"` // Time of the last failed watch
+	ConsecutiveWatchErrors int        `json:"consecutive-watch-errors"`        // Number of watches that failed since the last one that did not
+	FailingResources       []string   `json:"failing-resources,omitempty"`     // Resources of which the last watch failed (backends that watch multiple resources only)
+	Staleness              string     `json:"staleness,omitempty"`             // Time since the last successful load of services
+}
+
+// StatusReporter is implemented by backends to report their status.
+type StatusReporter interface {
+	// Status returns the current status of the backend.
+	Status() BackendStatus
+}
+
+// statusTracker records the outcome of watches & loads of a backend.
+// It is embedded (by reference, so copies for tenants share it) in backends to implement StatusReporter.
+type statusTracker struct {
+	mutex            sync.Mutex
+	status           BackendStatus
+	failingResources map[string]struct{}
+}
+
+// recordWatch records the outcome of a watch with the given context.
+// A watch that ends because its context expired did not fail, there simply was no change.
+func (t *statusTracker) recordWatch(ctx context.Context, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := time.Now()
+	if err != nil && ctx.Err() == nil {
+		t.status.LastWatchError = err.Error()
+		t.status.LastWatchErrorTime = &now
+		t.status.ConsecutiveWatchErrors++
+		backendWatchFailures.Inc()
+	} else {
+		t.status.LastWatch = &now
+		t.status.ConsecutiveWatchErrors = 0
+	}
+	t.updateReady()
+}
+
+// recordResourceWatch records the outcome of a watch of a single resource, for backends
+// that watch multiple resources independently. The backend is not ready while the last watch
+// of any resource failed.
+func (t *statusTracker) recordResourceWatch(resource string, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err != nil {
+		now := time.Now()
+		t.status.LastWatchError = err.Error()
+		t.status.LastWatchErrorTime = &now
+		if t.failingResources == nil {
+			t.failingResources = make(map[string]struct{})
+		}
+		t.failingResources[resource] = struct{}{}
+		backendWatchFailures.Inc()
+	} else {
+		delete(t.failingResources, resource)
+	}
+	t.updateReady()
+}
+
+// recordServices records the outcome of a load of services.
+func (t *statusTracker) recordServices(err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err != nil {
+		t.status.LastServicesError = err.Error()
+		backendServicesFailures.Inc()
+	} else {
+		now := time.Now()
+		t.status.LastServices = &now
+		t.status.LastServicesError = ""
+		backendLastServices.Set(float64(now.Unix()))
+	}
+	t.updateReady()
+}
+
+// updateReady updates the ready flag & metric.
+// The mutex must be held by the caller.
+func (t *statusTracker) updateReady() {
+	t.status.Ready = t.status.LastServices != nil && t.status.LastServicesError == "" && t.status.ConsecutiveWatchErrors == 0 && len(t.failingResources) == 0
+	if t.status.Ready {
+		backendReady.Set(1)
+	} else {
+		backendReady.Set(0)
+	}
+}
+
+// Status returns the current status of the backend.
+func (t *statusTracker) Status() BackendStatus {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	result := t.status
+	for resource := range t.failingResources {
+		result.FailingResources = append(result.FailingResources, resource)
+	}
+	sort.Strings(result.FailingResources)
+	if result.LastServices != nil {
+		staleness := time.Since(*result.LastServices)
+		result.Staleness = (time.Duration(int64(staleness)/int64(time.Second)) * time.Second).String()
+	}
+	return result
+}
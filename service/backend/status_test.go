@@ -0,0 +1,45 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestStatusTracker(t *testing.T) {
+	tracker := &statusTracker{}
+	if status := tracker.Status(); status.Ready {
+		t.Errorf("Expected not ready before services are loaded")
+	}
+
+	tracker.recordServices(nil)
+	if status := tracker.Status(); !status.Ready || status.LastServices == nil || status.Staleness == "" {
+		t.Errorf("Expected ready after services are loaded, got %#v", status)
+	}
+
+	// A watch that expires without a change did not fail
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tracker.recordWatch(ctx, ctx.Err())
+	if status := tracker.Status(); !status.Ready || status.ConsecutiveWatchErrors != 0 {
+		t.Errorf("Expected ready after expired watch, got %#v", status)
+	}
+
+	tracker.recordWatch(context.Background(), errors.New("watch failed"))
+	tracker.recordWatch(context.Background(), errors.New("watch failed again"))
+	status := tracker.Status()
+	if status.Ready || status.ConsecutiveWatchErrors != 2 || status.LastWatchError != "watch failed again" {
+		t.Errorf("Expected not ready after failed watches, got %#v", status)
+	}
+
+	tracker.recordWatch(context.Background(), nil)
+	tracker.recordServices(errors.New("load failed"))
+	if status := tracker.Status(); status.Ready || status.LastServicesError != "load failed" || status.LastServices == nil {
+		t.Errorf("Expected not ready after failed load, got %#v", status)
+	}
+
+	tracker.recordServices(nil)
+	if status := tracker.Status(); !status.Ready || status.LastWatchError == "" {
+		t.Errorf("Expected ready (keeping the last watch error) after successful load, got %#v", status)
+	}
+}
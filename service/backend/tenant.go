@@ -0,0 +1,109 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/juju/errgo"
+	api "github.com/pulcy/robin-api"
+)
+
+// Tenant is a user of the API that manages its own set of frontend records.
+type Tenant struct {
+	Name         string   `json:"name"`
+	Tokens       []string `json:"tokens"`                  // API tokens that give access to the frontend records of this tenant
+	MaxDomains   int      `json:"max-domains,omitempty"`   // If set, the maximum number of distinct domains in the frontend records of this tenant
+	MaxFrontends int      `json:"max-frontends,omitempty"` // If set, the maximum number of frontend records of this tenant
+	Quota        Quota    `json:"quota"`                   // If enabled, the traffic to all frontends of this tenant is limited
+}
+
+// TenantBackend is implemented by backends that store frontend records per tenant.
+type TenantBackend interface {
+	// ForTenant returns an API that manages the frontend records of the given tenant only.
+	ForTenant(t Tenant) api.API
+}
+
+// Validate checks the given tenant for invalid values.
+func (t Tenant) Validate() error {
+	if err := validateID(t.Name); err != nil {
+		return maskAny(err)
+	}
+	if len(t.Tokens) == 0 {
+		return maskAny(errgo.WithCausef(nil, api.ValidationError, "tenant '%s' has no tokens", t.Name))
+	}
+	for _, token := range t.Tokens {
+		if token == "" {
+			return maskAny(errgo.WithCausef(nil, api.ValidationError, "tenant '%s' has an empty token", t.Name))
+		}
+	}
+	if t.MaxDomains < 0 || t.MaxFrontends < 0 || t.Quota.RequestsPerDay < 0 || t.Quota.BandwidthPerDay < 0 {
+		return maskAny(errgo.WithCausef(nil, api.ValidationError, "limits of tenant '%s' cannot be negative", t.Name))
+	}
+	return nil
+}
+
+// CheckLimits returns an error when the given frontend records exceed the limits of the tenant.
+func (t Tenant) CheckLimits(records map[string]api.FrontendRecord) error {
+	if t.MaxFrontends > 0 && len(records) > t.MaxFrontends {
+		return maskAny(errgo.WithCausef(nil, api.ValidationError, "tenant '%s' cannot have more than %d frontends", t.Name, t.MaxFrontends))
+	}
+	if t.MaxDomains > 0 {
+		domains := make(map[string]struct{})
+		for _, r := range records {
+			for _, sel := range r.AllSelectors() {
+				if sel.Domain != "" {
+					domains[sel.Domain] = struct{}{}
+				}
+			}
+		}
+		if len(domains) > t.MaxDomains {
+			return maskAny(errgo.WithCausef(nil, api.ValidationError, "tenant '%s' cannot have more than %d domains", t.Name, t.MaxDomains))
+		}
+	}
+	return nil
+}
+
+// LoadTenants reads a JSON file containing a list of tenants.
+func LoadTenants(path string) ([]Tenant, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	var tenants []Tenant
+	if err := json.Unmarshal(raw, &tenants); err != nil {
+		return nil, maskAny(fmt.Errorf("Cannot parse tenants in %s: %v", path, err))
+	}
+	names := make(map[string]struct{})
+	tokens := make(map[string]struct{})
+	for _, t := range tenants {
+		if err := t.Validate(); err != nil {
+			return nil, maskAny(err)
+		}
+		if _, found := names[t.Name]; found {
+			return nil, maskAny(fmt.Errorf("Duplicate tenant '%s' in %s", t.Name, path))
+		}
+		names[t.Name] = struct{}{}
+		for _, token := range t.Tokens {
+			if _, found := tokens[token]; found {
+				return nil, maskAny(fmt.Errorf("Token of tenant '%s' is used more than once in %s", t.Name, path))
+			}
+			tokens[token] = struct{}{}
+		}
+	}
+	return tenants, nil
+}
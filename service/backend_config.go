@@ -16,10 +16,18 @@ package service
 
 import (
 	"fmt"
+	"regexp"
+	"sort"
+	"time"
 
+	"github.com/pulcy/robin/haproxy"
 	"github.com/pulcy/robin/service/backend"
 )
 
+var (
+	metadataValueRegexp = regexp.MustCompile(`^[A-Za-z0-9._:/-]+$`)
+)
+
 type backendConfig struct {
 	Name     string
 	Services backend.ServiceRegistrations
@@ -38,6 +46,45 @@ func (b backendConfig) IsSticky() (bool, error) {
 	return result, nil
 }
 
+// HashOn returns the key on which requests to the backend are balanced (if any).
+func (b backendConfig) HashOn() (string, error) {
+	if len(b.Services) == 0 {
+		return "", nil
+	}
+	result := b.Services[0].HashOn
+	for _, sr := range b.Services {
+		if sr.HashOn != result {
+			return result, maskAny(fmt.Errorf("Conflicting hash-on settings in backend %s", b.Name))
+		}
+	}
+	return result, nil
+}
+
+// HashBalanceFactor returns the lowest bounded load factor of the services in the backend (0 if none).
+func (b backendConfig) HashBalanceFactor() int {
+	result := 0
+	for _, sr := range b.Services {
+		if sr.HashBalanceFactor != 0 && (result == 0 || sr.HashBalanceFactor < result) {
+			result = sr.HashBalanceFactor
+		}
+	}
+	return result
+}
+
+// IsGrpc returns true if the servers of the backend speak gRPC.
+func (b backendConfig) IsGrpc() (bool, error) {
+	if len(b.Services) == 0 {
+		return false, nil
+	}
+	result := b.Services[0].Grpc
+	for _, sr := range b.Services {
+		if sr.Grpc != result {
+			return result, maskAny(fmt.Errorf("Conflicting grpc settings in backend %s", b.Name))
+		}
+	}
+	return result, nil
+}
+
 func (b backendConfig) Mode() (string, error) {
 	normalize := func(s string) string {
 		if s == "" {
@@ -100,16 +147,285 @@ func (b backendConfig) HttpCheckMethod() (string, bool, error) {
 	return result, true, nil
 }
 
+// HttpCheckHost returns the Host header used for health checks.
+func (b backendConfig) HttpCheckHost() (string, error) {
+	services := b.httpCheckServices()
+	if len(services) == 0 {
+		return "", nil
+	}
+	result := services[0].HttpCheckHost
+	for _, sr := range services {
+		if sr.HttpCheckHost != result {
+			return result, maskAny(fmt.Errorf("Conflicting HttpCheckHost settings in backend %s", b.Name))
+		}
+	}
+	return result, nil
+}
+
+// TcpCheckOption returns the option line of the protocol level health check (if any).
+func (b backendConfig) TcpCheckOption() (string, error) {
+	result := ""
+	for _, sr := range b.Services {
+		if sr.TcpCheck == "" {
+			continue
+		}
+		option := fmt.Sprintf("option %s-check", sr.TcpCheck)
+		if sr.TcpCheckUser != "" {
+			option = fmt.Sprintf("%s user %s", option, sr.TcpCheckUser)
+		}
+		if result != "" && result != option {
+			return result, maskAny(fmt.Errorf("Conflicting TcpCheck settings in backend %s", b.Name))
+		}
+		result = option
+	}
+	return result, nil
+}
+
+// ExternalHost returns the external host that the servers of the backend belong to (if any).
+func (b backendConfig) ExternalHost() (string, error) {
+	if len(b.Services) == 0 {
+		return "", nil
+	}
+	result := b.Services[0].ExternalHost
+	for _, sr := range b.Services {
+		if sr.ExternalHost != result {
+			return result, maskAny(fmt.Errorf("Conflicting external host settings in backend %s", b.Name))
+		}
+	}
+	return result, nil
+}
+
+// Filters returns the names of the SPOE agents of all services in the backend (in order).
+func (b backendConfig) Filters() []string {
+	result := []string{}
+	seen := make(map[string]struct{})
+	for _, sr := range b.Services {
+		for _, name := range sr.Filters {
+			if _, ok := seen[name]; !ok {
+				seen[name] = struct{}{}
+				result = append(result, name)
+			}
+		}
+	}
+	return result
+}
+
+// LuaActions returns the names of the Lua actions of all services in the backend (in order).
+func (b backendConfig) LuaActions() []string {
+	result := []string{}
+	seen := make(map[string]struct{})
+	for _, sr := range b.Services {
+		for _, name := range sr.LuaActions {
+			if _, ok := seen[name]; !ok {
+				seen[name] = struct{}{}
+				result = append(result, name)
+			}
+		}
+	}
+	return result
+}
+
+// BodyScan returns the scanning of request bodies of the backend (if any).
+func (b backendConfig) BodyScan() (backend.BodyScan, error) {
+	var result backend.BodyScan
+	for _, sr := range b.Services {
+		if !sr.BodyScan.IsEnabled() {
+			continue
+		}
+		if result.IsEnabled() && result != sr.BodyScan {
+			return result, maskAny(fmt.Errorf("Conflicting body-scan settings in backend %s", b.Name))
+		}
+		result = sr.BodyScan
+	}
+	return result, nil
+}
+
+// ConnectionMode returns how HTTP connections of the backend are handled (empty means the default).
+func (b backendConfig) ConnectionMode() (string, error) {
+	result := ""
+	for _, sr := range b.Services {
+		if sr.ConnectionMode == "" {
+			continue
+		}
+		if result != "" && result != sr.ConnectionMode {
+			return result, maskAny(fmt.Errorf("Conflicting connection-mode settings in backend %s", b.Name))
+		}
+		result = sr.ConnectionMode
+	}
+	return result, nil
+}
+
+// Blocklists returns the names of the IP blocklists of all services in the backend (sorted).
+func (b backendConfig) Blocklists() []string {
+	result := []string{}
+	seen := make(map[string]struct{})
+	for _, sr := range b.Services {
+		for _, name := range sr.Blocklists {
+			if _, ok := seen[name]; !ok {
+				seen[name] = struct{}{}
+				result = append(result, name)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// HasHttpCheck returns true if any of the services of the backend has an HTTP health check setting.
+func (b backendConfig) HasHttpCheck() bool {
+	return len(b.httpCheckServices()) > 0
+}
+
 func (b backendConfig) httpCheckServices() backend.ServiceRegistrations {
 	var result backend.ServiceRegistrations
 	for _, sr := range b.Services {
-		if sr.HttpCheckPath != "" || sr.HttpCheckMethod != "" {
+		if sr.HasHttpCheck() {
 			result = append(result, sr)
 		}
 	}
 	return result
 }
 
+// AllBackups returns true if all backup servers must be used at once.
+func (b backendConfig) AllBackups() bool {
+	for _, sr := range b.Services {
+		if sr.AllBackups {
+			return true
+		}
+	}
+	return false
+}
+
+// MinActive returns the number of primary servers below which the backup servers are promoted.
+// It returns 0 if the backend has no backup servers.
+func (b backendConfig) MinActive() int {
+	if !b.hasBackupServers() {
+		return 0
+	}
+	result := 0
+	for _, sr := range b.Services {
+		if sr.MinActive > result {
+			result = sr.MinActive
+		}
+	}
+	return result
+}
+
+// MinInstances returns the number of healthy servers below which the maintenance page is served.
+func (b backendConfig) MinInstances() int {
+	result := 0
+	for _, sr := range b.Services {
+		if sr.MinInstances > result {
+			result = sr.MinInstances
+		}
+	}
+	return result
+}
+
+func (b backendConfig) hasBackupServers() bool {
+	for _, sr := range b.Services {
+		for _, si := range sr.Instances {
+			if sr.Backup || si.Backup {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RequestTimeout returns the largest request timeout of all selectors of the backend (if any).
+func (b backendConfig) RequestTimeout() string {
+	result := ""
+	var max time.Duration
+	for _, sr := range b.Services {
+		for _, sel := range sr.Selectors {
+			if sel.RequestTimeout == "" {
+				continue
+			}
+			d, err := haproxy.ParseInterval(sel.RequestTimeout)
+			if err == nil && d > max {
+				max = d
+				result = sel.RequestTimeout
+			}
+		}
+	}
+	return result
+}
+
+// MetadataHeaders returns the rules that set response headers to the metadata of the server
+// that served the request. Servers are identified by their (implicit) id, which follows
+// the order in which createServers adds them.
+func (b backendConfig) MetadataHeaders() []string {
+	result := []string{}
+	id := 0
+	for _, sr := range b.Services {
+		keys := make([]string, 0, len(sr.MetadataHeaders))
+		for key := range sr.MetadataHeaders {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, instance := range sr.Instances {
+			id++
+			for _, key := range keys {
+				value := instance.Metadata[key]
+				if !metadataValueRegexp.MatchString(value) {
+					continue
+				}
+				result = append(result, fmt.Sprintf("http-response set-header %s %s if { srv_id %d }", sr.MetadataHeaders[key], value, id))
+			}
+		}
+	}
+	return result
+}
+
+// FullConn returns the largest full-conn setting of the services in the backend (0 if none).
+func (b backendConfig) FullConn() int {
+	result := 0
+	for _, sr := range b.Services {
+		if sr.FullConn > result {
+			result = sr.FullConn
+		}
+	}
+	return result
+}
+
+// QueueTimeout returns the largest queue timeout of the services in the backend (if any).
+func (b backendConfig) QueueTimeout() string {
+	result := ""
+	var max time.Duration
+	for _, sr := range b.Services {
+		if sr.QueueTimeout == "" {
+			continue
+		}
+		d, err := haproxy.ParseInterval(sr.QueueTimeout)
+		if err == nil && d > max {
+			max = d
+			result = sr.QueueTimeout
+		}
+	}
+	return result
+}
+
+// IsPublic returns true if the backend serves a public frontend.
+func (b backendConfig) IsPublic() bool {
+	for _, sr := range b.Services {
+		if sr.Public {
+			return true
+		}
+	}
+	return false
+}
+
+// ExposesSensitive returns true if any of the services of the backend exposes its sensitive paths.
+func (b backendConfig) ExposesSensitive() bool {
+	for _, sr := range b.Services {
+		if sr.ExposeSensitive {
+			return true
+		}
+	}
+	return false
+}
+
 func (b backendConfig) HasAllowUnauthorized() bool {
 	for _, sr := range b.Services {
 		if sr.HasAllowUnauthorized() {
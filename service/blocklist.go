@@ -0,0 +1,281 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juju/errgo"
+
+	api "github.com/pulcy/robin-api"
+	"github.com/pulcy/robin/service/backend"
+)
+
+const (
+	// DefaultBlocklistInterval is the default interval between fetches of blocklists.
+	DefaultBlocklistInterval = time.Minute * 15
+
+	BlocklistFormatPlain    = "plain"    // One IP address or network (CIDR) per line, `#` and `;` start a comment
+	BlocklistFormatCrowdSec = "crowdsec" // Ban decisions of a CrowdSec local API
+
+	blocklistFetchTimeout = time.Second * 30
+)
+
+// Blocklist is an external list of source IPs & networks that are refused
+// by the backends of frontend records that use it.
+type Blocklist struct {
+	Name   string // Name of the blocklist, as used in frontend records
+	URL    string // URL the list is fetched from (for crowdsec the URL of the local API)
+	Format string // plain|crowdsec (empty means plain)
+	APIKey string // API key of the bouncer (crowdsec only)
+}
+
+// ParseBlocklist parses a blocklist in `<name>=<url>` format.
+func ParseBlocklist(s string) (Blocklist, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Blocklist{}, maskAny(errgo.WithCausef(nil, api.ValidationError, "expected <name>=<url>, got '%s'", s))
+	}
+	if err := api.ValidateName(parts[0]); err != nil {
+		return Blocklist{}, maskAny(err)
+	}
+	return Blocklist{Name: parts[0], URL: parts[1], Format: BlocklistFormatPlain}, nil
+}
+
+// blocklistPath returns the path of the ACL file of the blocklist with given name.
+func (s *Service) blocklistPath(name string) string {
+	return filepath.Join(s.MapFilesFolder, fmt.Sprintf("blocklist-%s.acl", cleanName(name)))
+}
+
+// blocklistsByName returns the configured blocklists by their name.
+func (s *Service) blocklistsByName() map[string]Blocklist {
+	result := make(map[string]Blocklist)
+	for _, bl := range s.Blocklists {
+		result[bl.Name] = bl
+	}
+	return result
+}
+
+// createBlocklistRules creates the rules of a backend (in given mode) that refuse
+// the source IPs of the given blocklists. Blocklists that are not configured are ignored.
+func (s *Service) createBlocklistRules(names []string, mode string) []string {
+	blocklists := s.blocklistsByName()
+	lines := []string{}
+	for _, name := range names {
+		if _, ok := blocklists[name]; !ok {
+			continue
+		}
+		if mode == "http" {
+			lines = append(lines, fmt.Sprintf("http-request deny if { src -f %s }", s.blocklistPath(name)))
+		} else {
+			lines = append(lines, fmt.Sprintf("tcp-request content reject if { src -f %s }", s.blocklistPath(name)))
+		}
+	}
+	return lines
+}
+
+// createUsedBlocklists returns the names of the configured blocklists that are used by the given services.
+func (s *Service) createUsedBlocklists(services backend.ServiceRegistrations) map[string]struct{} {
+	blocklists := s.blocklistsByName()
+	result := make(map[string]struct{})
+	for _, sr := range services {
+		for _, name := range sr.Blocklists {
+			if _, ok := blocklists[name]; ok {
+				result[name] = struct{}{}
+			}
+		}
+	}
+	return result
+}
+
+// ensureBlocklistFiles creates an empty ACL file for every blocklist that has not been fetched yet,
+// since HAProxy refuses configs that refer to missing files.
+func (s *Service) ensureBlocklistFiles() error {
+	for _, bl := range s.Blocklists {
+		path := s.blocklistPath(bl.Name)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if err := ioutil.WriteFile(path, nil, confPerm); err != nil {
+				s.Logger.Errorf("Cannot write blocklist to %s: %#v", path, err)
+				return maskAny(err)
+			}
+		}
+	}
+	return nil
+}
+
+// blocklistLoop periodically fetches all blocklists.
+func (s *Service) blocklistLoop(ctx context.Context) {
+	interval := s.BlocklistInterval
+	if interval == 0 {
+		interval = DefaultBlocklistInterval
+	}
+	for {
+		for _, bl := range s.Blocklists {
+			if err := s.updateBlocklist(bl); err != nil {
+				s.Logger.Warningf("Failed to update blocklist %s: %#v", bl.Name, err)
+			}
+		}
+		if !sleep(ctx, interval) {
+			return
+		}
+	}
+}
+
+// updateBlocklist fetches the given blocklist and writes it to its ACL file.
+// If the list has changed and is used by HAProxy, the ACL is updated through the runtime API,
+// otherwise the change is picked up by the next restart of HAProxy.
+func (s *Service) updateBlocklist(bl Blocklist) error {
+	entries, err := fetchBlocklist(bl)
+	if err != nil {
+		return maskAny(err)
+	}
+	blocklistEntries.WithLabelValues(bl.Name).Set(float64(len(entries)))
+	path := s.blocklistPath(bl.Name)
+	content := strings.Join(entries, "\n") + "\n"
+	if current, err := ioutil.ReadFile(path); err == nil && string(current) == content {
+		return nil
+	}
+	if err := ioutil.WriteFile(path, []byte(content), confPerm); err != nil {
+		return maskAny(err)
+	}
+	s.Logger.Infof("Blocklist %s changed, it now contains %d entries", bl.Name, len(entries))
+	used, _ := s.lastBlocklists.Load().(map[string]struct{})
+	if _, ok := used[bl.Name]; !ok || s.RuntimeSocketPath == "" {
+		return nil
+	}
+	client, err := s.runtimeClient()
+	if err != nil {
+		return maskAny(err)
+	}
+	if s.HaproxyVersion.AtLeast(2, 4) {
+		err = client.ReplaceACL(path, entries)
+	} else {
+		err = client.SyncACL(path, entries)
+	}
+	if err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// fetchBlocklist fetches the given blocklist and returns its (valid) IP addresses & networks, sorted.
+func fetchBlocklist(bl Blocklist) ([]string, error) {
+	url := bl.URL
+	if bl.Format == BlocklistFormatCrowdSec {
+		url = strings.TrimSuffix(url, "/") + "/v1/decisions?type=ban"
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if bl.APIKey != "" {
+		req.Header.Set("X-Api-Key", bl.APIKey)
+	}
+	client := &http.Client{Timeout: blocklistFetchTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, maskAny(fmt.Errorf("GET %s returned status %d", url, resp.StatusCode))
+	}
+	var entries []string
+	if bl.Format == BlocklistFormatCrowdSec {
+		entries, err = parseCrowdSecDecisions(resp.Body)
+	} else {
+		entries, err = parsePlainBlocklist(resp.Body)
+	}
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return normalizeBlocklist(entries), nil
+}
+
+// parsePlainBlocklist parses a list with one IP address or network per line.
+// Everything after `#` or `;` is a comment (e.g. `192.0.2.0/24 ; SBL123` in Spamhaus DROP).
+func parsePlainBlocklist(r io.Reader) ([]string, error) {
+	var result []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		if fields := strings.Fields(line); len(fields) > 0 {
+			result = append(result, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, maskAny(err)
+	}
+	return result, nil
+}
+
+// crowdSecDecision is a decision returned by the CrowdSec local API.
+type crowdSecDecision struct {
+	Scope string `json:"scope"` // Ip|Range
+	Value string `json:"value"`
+	Type  string `json:"type"` // ban|captcha|...
+}
+
+// parseCrowdSecDecisions parses the response of a GET /v1/decisions request of the CrowdSec local API.
+func parseCrowdSecDecisions(r io.Reader) ([]string, error) {
+	var decisions []crowdSecDecision
+	if err := json.NewDecoder(r).Decode(&decisions); err != nil {
+		return nil, maskAny(err)
+	}
+	var result []string
+	for _, d := range decisions {
+		scope := strings.ToLower(d.Scope)
+		if strings.ToLower(d.Type) == "ban" && (scope == "ip" || scope == "range") {
+			result = append(result, d.Value)
+		}
+	}
+	return result, nil
+}
+
+// normalizeBlocklist returns the valid IP addresses & networks of the given list, sorted and without duplicates.
+func normalizeBlocklist(entries []string) []string {
+	seen := make(map[string]struct{})
+	result := []string{}
+	for _, entry := range entries {
+		if ip := net.ParseIP(entry); ip != nil {
+			entry = ip.String()
+		} else if _, network, err := net.ParseCIDR(entry); err == nil {
+			entry = network.String()
+		} else {
+			continue
+		}
+		if _, ok := seen[entry]; !ok {
+			seen[entry] = struct{}{}
+			result = append(result, entry)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
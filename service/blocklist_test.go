@@ -0,0 +1,27 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBlocklists(t *testing.T) {
+	plain := "; Spamhaus DROP List\n192.0.2.0/24 ; SBL123\n198.51.100.7\n# comment\ninvalid\n192.0.2.0/24 ; SBL456\n"
+	entries, err := parsePlainBlocklist(strings.NewReader(plain))
+	if err != nil {
+		t.Fatalf("parsePlainBlocklist failed: %#v", err)
+	}
+	if result, expected := normalizeBlocklist(entries), []string{"192.0.2.0/24", "198.51.100.7"}; !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+
+	decisions := `[{"scope":"Ip","value":"203.0.113.5","type":"ban"},{"scope":"Range","value":"203.0.113.128/25","type":"ban"},{"scope":"Ip","value":"203.0.113.6","type":"captcha"},{"scope":"Country","value":"XX","type":"ban"}]`
+	entries, err = parseCrowdSecDecisions(strings.NewReader(decisions))
+	if err != nil {
+		t.Fatalf("parseCrowdSecDecisions failed: %#v", err)
+	}
+	if result, expected := normalizeBlocklist(entries), []string{"203.0.113.128/25", "203.0.113.5"}; !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+
+	"github.com/pulcy/robin/service/backend"
+)
+
+const (
+	// bodyScanProcessingTimeout is the maximum time an agent takes to scan a body.
+	bodyScanProcessingTimeout = "10s"
+)
+
+// scanEngineName returns the name of the SPOE engine that sends request bodies to the scan agent with given name.
+func scanEngineName(name string) string {
+	return name + "-scan"
+}
+
+// createBodyScanRules creates the rules of an HTTP backend that pass request bodies to the scan agent
+// and refuse requests with infected bodies (403). Unless the scan fails open, requests with bodies that
+// cannot be scanned are refused as well (413 when too large, 503 when the agent fails).
+// The agent only receives the part of the body that fits in the request buffer (tune.bufsize),
+// so a fail-closed scan also refuses bodies that are not completely buffered (413),
+// while a fail-open scan passes only their buffered part to the agent.
+// If the agent is not configured (or SPOE is not supported), only a fail-closed scan refuses all requests with a body.
+// The names of the used agents are added to the given set.
+func (s *Service) createBodyScanRules(scan backend.BodyScan, used map[string]struct{}) []string {
+	if !scan.IsEnabled() {
+		return nil
+	}
+	hasBody := "{ req.body_size gt 0 }"
+	if _, ok := s.spoeAgentsByName()[scan.Agent]; !ok {
+		if scan.FailOpen {
+			return nil
+		}
+		return []string{fmt.Sprintf("http-request deny deny_status 503 if %s", hasBody)}
+	}
+	used[scan.Agent] = struct{}{}
+	engine := scanEngineName(scan.Agent)
+	prefix := spoeVarPrefix(engine)
+	lines := []string{
+		"option http-buffer-request",
+		fmt.Sprintf("filter spoe engine %s config %s", engine, s.spoeConfigPath(engine)),
+	}
+	if !scan.FailOpen {
+		lines = append(lines, "http-request deny deny_status 413 if { req.body_len lt req.body_size }")
+	}
+	scanned := hasBody
+	if scan.MaxBodySize > 0 {
+		if !scan.FailOpen {
+			lines = append(lines, fmt.Sprintf("http-request deny deny_status 413 if { req.body_size gt %d }", scan.MaxBodySize))
+		}
+		scanned = fmt.Sprintf("%s { req.body_size le %d }", hasBody, scan.MaxBodySize)
+	}
+	lines = append(lines,
+		fmt.Sprintf("http-request send-spoe-group %s scan if %s", engine, scanned),
+		fmt.Sprintf("http-request deny deny_status 403 if { var(txn.%s.infected) -m bool }", prefix),
+	)
+	if !scan.FailOpen {
+		lines = append(lines, fmt.Sprintf("http-request deny deny_status 503 if { var(txn.%s.error) -m found }", prefix))
+	}
+	return lines
+}
+
+// createScanSpoeConfig creates the lines of the SPOE config file used to send request bodies
+// to the scan agent with given name.
+// The agent must set the `infected` variable when a body must be refused. It typically passes
+// the body on to an ICAP server.
+func createScanSpoeConfig(name string) []string {
+	engine := scanEngineName(name)
+	return []string{
+		fmt.Sprintf("[%s]", engine),
+		fmt.Sprintf("spoe-agent %s", engine),
+		"    groups scan",
+		fmt.Sprintf("    option var-prefix %s", spoeVarPrefix(engine)),
+		"    option set-on-error error",
+		"    timeout hello 2s",
+		"    timeout idle 2m",
+		fmt.Sprintf("    timeout processing %s", bodyScanProcessingTimeout),
+		fmt.Sprintf("    use-backend %s", spoeBackendName(name)),
+		"",
+		"spoe-message scan",
+		"    args method=method host=var(txn.host) path=path src=src content-type=req.hdr(content-type) body=req.body",
+		"",
+		"spoe-group scan",
+		"    messages scan",
+	}
+}
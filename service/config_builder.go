@@ -17,8 +17,12 @@ package service
 import (
 	"fmt"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
+	"time"
+
+	api "github.com/pulcy/robin-api"
 
 	"github.com/pulcy/robin/haproxy"
 	"github.com/pulcy/robin/service/backend"
@@ -29,9 +33,76 @@ const (
 	PublicHttpsPort   = 443
 	PrivateHttpPort   = 81
 	PrivateTcpSslPort = 82
+	PrivateHttpsPort  = 83
+
+	// deniedBackendName is the name of the backend rejecting unmatched private requests.
+	deniedBackendName = "denied"
+
+	// maintenanceBackendName is the name of the backend serving the maintenance page.
+	maintenanceBackendName = "maintenance"
+
+	// hostDomainAclPrefix is the prefix of `acl` rules matching a (non-wildcard) domain.
+	hostDomainAclPrefix = "var(txn.host) -m dom -i "
+
+	// acmeChallengePathPrefix is the path prefix of ACME HTTP challenges, which are never redirected to HTTPS.
+	acmeChallengePathPrefix = "/.well-known/acme-challenge/"
+	acmeChallengeAclName    = "acme_challenge"
+	// sslExemptAclName is the name of the `acl` matching paths that are not redirected to HTTPS.
+	sslExemptAclName = "ssl_exempt"
+
+	// mapFileExt is the extension of the map files used to select backends in frontends with many ACLs.
+	mapFileExt = ".map"
+	// mapFileGlob matches the map files of all (secure) frontend sections, which are named after the section.
+	mapFileGlob = "*_in_*" + mapFileExt
+
+	// hashKeyHeader is the request header containing the value hashed by jwt-claim hash-on backends.
+	hashKeyHeader = "X-Robin-Hash-Key"
+
+	// DefaultCacheSize is the size (in MB) of a response cache.
+	DefaultCacheSize = 64
+
+	// tlsLogProfileName is the name of the log profile used to log TLS details next to an access log.
+	tlsLogProfileName = "tls_stats"
+	// tlsErrorLogFormat is the format of connection errors (such as handshake failures) in the TLS log profile.
+	tlsErrorLogFormat = "%ci:%cp\\ [%tr]\\ %f/%b:\\ %[fc_err_str]"
+
+	// TlsLogFormat is the HAProxy log-format used for TLS frontends.
+	// Handshake failures are logged by HAProxy in its own format.
+	// The repeat field is set for all but the first request of an HTTP connection, so
+	// handshakes are counted once per connection.
+	TlsLogFormat = "tls\\ sni=%[ssl_fc_sni]\\ protocol=%sslv\\ cipher=%sslc\\ resumed=%[ssl_fc_is_resumed]\\ repeat=%[var(txn.tls_repeat)]"
 )
 
 var (
+	// mailImplicitTlsPorts contains the mail ports on which TLS is terminated by HAProxy (SMTPS, IMAPS, POP3S)
+	mailImplicitTlsPorts = map[int]bool{465: true, 993: true, 995: true}
+	// tlsRepeatRules mark all but the first request of a TLS connection (see TlsLogFormat)
+	tlsRepeatRules = []string{
+		"http-request set-var(txn.tls_repeat) bool(true) if { var(sess.tls_seen) -m found }",
+		"http-request set-var(sess.tls_seen) bool(true)",
+	}
+	// mailFrontendOptions & mailBackendOptions allow mail sessions to be idle for a long time
+	mailFrontendOptions = []string{
+		"timeout client 5m",
+	}
+	mailBackendOptions = []string{
+		"timeout server 5m",
+	}
+	// grpcRetryOptions retry requests to gRPC servers that failed before a response was received
+	grpcRetryOptions = []string{
+		"retry-on conn-failure empty-response response-timeout",
+	}
+	// grpcCheckOptions check gRPC servers using the standard gRPC health checking protocol.
+	// gRPC always responds with HTTP status 200, so the response message must be checked.
+	// The request is an empty HealthCheckRequest (overall server health), the response
+	// must be a HealthCheckResponse with status SERVING (field 1 = 1).
+	grpcCheckOptions = []string{
+		"option httpchk",
+		"http-check connect proto h2",
+		"http-check send meth POST uri /grpc.health.v1.Health/Check ver HTTP/2 hdr content-type application/grpc hdr te trailers body-lf %[bin(0000000000)]",
+		"http-check expect status 200",
+		"http-check expect binary 00000000020801",
+	}
 	globalOptions = []string{
 		//"log global",
 		"quiet",
@@ -43,7 +114,7 @@ var (
 		"timeout connect 5000ms",
 		"timeout client 50000ms",
 		"timeout server 50000ms",
-		"option http-server-close",
+		defaultConnectionModeOption,
 		//"log global",
 		//"option dontlognull",
 		"errorfile 400 /app/errors/400.http",
@@ -60,6 +131,13 @@ var (
 		"http-response set-header X-XSS-Protection 1;mode=block",
 		"http-response set-header X-Content-Type-Options nosniff",
 	}
+	// Store the host of a request (without port, lowercase) in `txn.host`.
+	// For absolute URIs, the host is taken from the URI.
+	hostNormalizationOptions = []string{
+		"http-request set-var(txn.host) req.hdr(host),field(1,:),lower",
+		"http-request set-var(txn.host) url,regsub(^[^:]*://,),field(1,/),field(1,:),lower if { url_beg -i http:// https:// }",
+	}
+	invalidNameCharRegexp = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
 )
 
 type useBlock struct {
@@ -69,6 +147,16 @@ type useBlock struct {
 	AllowUnauthorized bool
 	AllowInsecure     bool
 	RewriteRules      []backend.RewriteRule
+	CanonicalHost     string
+	RequestTimeout    string
+	Cache             backend.Cache
+	AuthAgent         string
+	Quota             backend.Quota // Quota per domain
+	Tenant            string
+	TenantQuota       backend.Quota
+	TrapPaths         []string
+	TrapAction        string
+	MapDomain         string // If set, the block is served through the map file of its frontend section
 }
 
 type frontend struct {
@@ -95,6 +183,25 @@ func (f frontend) IsTCP() bool {
 	return f.Mode == "tcp"
 }
 
+// IsMail returns true if Mode == "mail"
+func (f frontend) IsMail() bool {
+	return f.Mode == "mail"
+}
+
+// IsImplicitTLS returns true if this is a mail frontend on a port that is TLS from the start
+// (as opposed to ports that use STARTTLS, which is passed through to the mail servers).
+func (f frontend) IsImplicitTLS() bool {
+	return f.IsMail() && mailImplicitTlsPorts[f.Port]
+}
+
+// HaproxyMode returns the mode of the frontend in the HAProxy configuration.
+func (f frontend) HaproxyMode() string {
+	if f.IsMail() {
+		return "tcp"
+	}
+	return f.Mode
+}
+
 type frontendList []frontend
 
 func (l frontendList) Len() int { return len(l) }
@@ -116,76 +223,110 @@ func (l frontendList) Swap(i, j int) {
 	l[i], l[j] = l[j], l[i]
 }
 
+// addTlsLogOptions adds options to the given TLS terminating frontend section that log
+// the SNI domain, protocol & cipher of every connection (used for TLS statistics).
+// HTTP sections only log the first request of every connection.
+// HTTP sections with an access log use the log-format for the access log, so their TLS details
+// are logged using a log profile, which requires HAProxy 3.1. Without it, they are not logged.
+func (s *Service) addTlsLogOptions(section *haproxy.Section, isHTTP bool) {
+	if s.TlsLogAddress == "" {
+		return
+	}
+	if isHTTP && s.AccessLog.IsEnabled() {
+		if s.useTlsLogProfile() {
+			section.Add(fmt.Sprintf("log %s profile %s local0 info", s.TlsLogAddress, tlsLogProfileName))
+			section.Add(tlsRepeatRules...)
+		}
+		return
+	}
+	section.Add(
+		fmt.Sprintf("log %s local0 info", s.TlsLogAddress),
+		"log-format "+TlsLogFormat,
+	)
+	if isHTTP {
+		section.Add(tlsRepeatRules...)
+		section.Add("http-request set-log-level silent if { var(txn.tls_repeat) -m found }")
+	}
+}
+
+// useTlsLogProfile returns true if the TLS details of HTTP sections with an access log
+// are logged using a log profile.
+func (s *Service) useTlsLogProfile() bool {
+	return s.TlsLogAddress != "" && s.AccessLog.IsEnabled() && s.HaproxyVersion.AtLeast(3, 1)
+}
+
 // renderConfig creates a new haproxy configuration content.
+// The content only depends on the given services, not on their order, so identical
+// inputs always result in identical content.
 func (s *Service) renderConfig(services backend.ServiceRegistrations) (string, error) {
+	services = services.Clone()
+	services.Sort()
+	services = s.addGatewaySelectors(services)
+	services = s.preferLocalZone(services)
+	services = s.applyTenantQuotas(services)
 	c := haproxy.NewConfig()
 	c.Section("global").Add(globalOptions...)
-	c.Section("defaults").Add(defaultsOptions...)
+	if s.RuntimeSocketPath != "" {
+		c.Section("global").Add(fmt.Sprintf("stats socket %s level admin", s.RuntimeSocketPath))
+	}
+	luaLoads, luaScripts := s.createLuaLoads()
+	c.Section("global").Add(luaLoads...)
+	connectionModes := usesConnectionModes(services)
+	c.Section("defaults").Add(createDefaultsOptions(connectionModes)...)
+	if s.useTlsLogProfile() {
+		c.Section("log-profile "+tlsLogProfileName).Add(
+			"on error format "+tlsErrorLogFormat,
+			"on any format "+TlsLogFormat,
+		)
+	}
 
 	// Create user lists for each frontend (that needs it)
+	usersAdded := make(map[string]struct{})
 	for _, sr := range services {
 		for selIndex, sel := range sr.Selectors {
 			if len(sel.Users) == 0 {
 				continue
 			}
-			userListSection := c.Section("userlist " + userListName(sr, selIndex))
+			listName := userListName(sr, selIndex)
+			userListSection := c.Section("userlist " + listName)
 			for _, user := range sel.Users {
+				// Registrations of the same service (e.g. for the private gateway) can share a user list
+				key := listName + "/" + user.Name
+				if _, found := usersAdded[key]; found {
+					continue
+				}
+				usersAdded[key] = struct{}{}
 				userListSection.Add(fmt.Sprintf("user %s password %s", user.Name, user.PasswordHash))
 			}
 		}
 	}
 
+	s.createSensitivePathsUserList(c)
+
+	// Create caches used by selectors
+	s.createCaches(c, services)
+	s.createQuotaTables(c, services)
+	s.createIPBanTables(c)
+	s.createExternalResolvers(c, services)
+
 	// Collect certificates
-	certs := []string{}
-	certsSet := make(map[string]struct{})
-	for _, sr := range services {
-		if sr.Public {
-			for _, sel := range sr.Selectors {
-				if sel.IsSecure() {
-					certPath := sel.TmpSslCertPath
-					if certPath == "" {
-						certPath = filepath.Join(s.SslCertsFolder, sel.SslCertName)
-					}
-					certFolder := filepath.Dir(certPath)
-					if _, ok := certsSet[certFolder]; !ok {
-						crt := fmt.Sprintf("crt %s", certFolder)
-						certs = append(certs, crt)
-						certsSet[certFolder] = struct{}{}
-					}
-				}
-			}
-		}
-	}
+	certs := s.collectCertificates(services, true)
+	privateCerts := s.collectCertificates(services, false)
+
+	// Collect per-service certificates of the private TCP SSL frontend
+	privateTcpCrtList := s.createPrivateTcpCrtList(services)
 
 	// Collect frontends
-	var frontends frontendList
-	frontendMap := make(map[string]frontend)
-	collectFrontend := func(index, edgePort int, public bool, mode string) {
-		if (public && s.ExcludePublic) || (!public && s.ExcludePrivate) {
-			return // Exclude
-		}
-		f := frontend{
-			index:  index,
-			Port:   edgePort,
-			Public: public,
-			Mode:   mode,
-		}
-		if _, ok := frontendMap[f.Name()]; !ok {
-			frontendMap[f.Name()] = f
-			frontends = append(frontends, f)
-		}
-	}
-	collectFrontend(0, PublicHttpPort, true, "http")   // Always create a public HTTP frontend
-	collectFrontend(1, PrivateHttpPort, false, "http") // Always create a private HTTP frontend
-	for _, sr := range services {
-		collectFrontend(2, sr.EdgePort, sr.Public, sr.Mode)
-	}
-	sort.Sort(frontends)
+	frontends := s.collectFrontends(services)
 
 	// Create all frontends
-	aclNameGen := NewNameGenerator("acl")
 	backends := make(map[string]backendConfig)
+	mapFiles := make(map[string][]string)
+	usedAuthAgents := make(map[string]struct{})
 	for _, frontend := range frontends {
+		if s.inTcpPortRange(frontend) {
+			continue // Served by a TCP port range frontend
+		}
 		frontendSection := c.Section(fmt.Sprintf("frontend %s", frontend.Name()))
 		host := "*"
 		if frontend.Public {
@@ -198,47 +339,116 @@ func (s *Service) renderConfig(services backend.ServiceRegistrations) (string, e
 			}
 		}
 		bind := fmt.Sprintf("bind %s:%d", host, frontend.Port)
-		if !frontend.Public && frontend.IsTCP() && frontend.Port == PrivateTcpSslPort && s.PrivateTcpSslCert != "" {
-			bind = fmt.Sprintf("%s ssl generate-certificates ca-sign-file %s crt %s no-sslv3",
-				bind,
-				filepath.Join(s.SslCertsFolder, s.PrivateTcpSslCert),
-				filepath.Join(s.SslCertsFolder, s.PrivateTcpSslCert),
-			)
+		isTLS := false
+		if !frontend.Public && frontend.IsTCP() && frontend.Port == PrivateTcpSslPort {
+			crtList := ""
+			if len(privateTcpCrtList) > 0 {
+				crtList = fmt.Sprintf(" crt-list %s", s.PrivateTcpCrtListPath)
+			}
+			if s.PrivateTcpSslCert != "" {
+				isTLS = true
+				bind = fmt.Sprintf("%s ssl generate-certificates ca-sign-file %s crt %s%s no-sslv3",
+					bind,
+					filepath.Join(s.SslCertsFolder, s.PrivateTcpSslCert),
+					filepath.Join(s.SslCertsFolder, s.PrivateTcpSslCert),
+					crtList,
+				)
+			} else if crtList != "" {
+				isTLS = true
+				bind = fmt.Sprintf("%s ssl%s no-sslv3", bind, crtList)
+			}
+		}
+		if frontend.IsImplicitTLS() {
+			if mailCerts := createMailCerts(services, frontend, s.SslCertsFolder); len(mailCerts) > 0 {
+				isTLS = true
+				bind = fmt.Sprintf("%s ssl %s no-sslv3", bind, strings.Join(mailCerts, " "))
+			}
+		}
+		frontendSection.Add(bind + s.realIPBindOption(frontend.Port, frontend.Public))
+		if isTLS {
+			s.addTlsLogOptions(frontendSection, frontend.IsHTTP())
 		}
-		frontendSection.Add(bind)
 		var secureFrontendSection *haproxy.Section
 		frontendSections := []*haproxy.Section{frontendSection}
-		haveCertificates := len(certs) > 0
-		if frontend.Public && frontend.Port == PublicHttpPort && frontend.IsHTTP() && haveCertificates {
+		// The default HTTP frontends get a secure companion that serves their certificates
+		httpPort, securePort, frontendCerts := PublicHttpPort, PublicHttpsPort, certs
+		if !frontend.Public {
+			httpPort, securePort, frontendCerts = PrivateHttpPort, PrivateHttpsPort, privateCerts
+		}
+		haveCertificates := len(frontendCerts) > 0
+		if frontend.Port == httpPort && frontend.IsHTTP() && haveCertificates {
 			secureFrontendSection = c.Section(fmt.Sprintf("frontend secure-%s", frontend.Name()))
 			frontendSections = append(frontendSections, secureFrontendSection)
-			secureFrontendSection.Add(fmt.Sprintf("bind %s:%d ssl %s no-sslv3", host, PublicHttpsPort, strings.Join(certs, " ")))
+			alpn := ""
+			if s.HaproxyVersion.AtLeast(2, 0) && hasGrpcServices(services, frontend) {
+				// Let gRPC clients negotiate HTTP/2
+				alpn = " alpn h2,http/1.1"
+			}
+			secureFrontendSection.Add(fmt.Sprintf("bind %s:%d ssl %s no-sslv3%s%s", host, securePort, strings.Join(frontendCerts, " "), alpn, s.realIPBindOption(securePort, frontend.Public)))
+			s.addTlsLogOptions(secureFrontendSection, true)
 		}
 		for _, section := range frontendSections {
-			section.Add(fmt.Sprintf("mode %s", frontend.Mode))
+			section.Add(fmt.Sprintf("mode %s", frontend.HaproxyMode()))
+			port := frontend.Port
+			if section == secureFrontendSection {
+				port = securePort
+			}
+			section.Add(s.createRealIPRules(port, frontend.Public, frontend.IsHTTP())...)
+			if frontend.IsMail() {
+				section.Add(mailFrontendOptions...)
+			}
 			if frontend.IsHTTP() {
 				section.Add(
 					"option forwardfor",
 					//"option httplog",
-					"reqadd X-Forwarded-Port:\\ %[dst_port]",
-					"reqadd X-Forwarded-Proto:\\ https if { ssl_fc }",
 				)
+				if s.HaproxyVersion.AtLeast(2, 0) {
+					section.Add(
+						"http-request add-header X-Forwarded-Port %[dst_port]",
+						"http-request add-header X-Forwarded-Proto https if { ssl_fc }",
+					)
+				} else {
+					section.Add(
+						"reqadd X-Forwarded-Port:\\ %[dst_port]",
+						"reqadd X-Forwarded-Proto:\\ https if { ssl_fc }",
+					)
+				}
+				section.Add(hostNormalizationOptions...)
+				s.addAccessLogOptions(section)
+				if frontend.Public {
+					s.addIPBanOptions(section)
+				}
+			}
+			if s.PrivateDenyByDefault && !frontend.Public && frontend.IsHTTP() {
+				section.Add("default_backend " + deniedBackendName)
+			} else {
+				section.Add("default_backend fallback")
 			}
-			section.Add("default_backend fallback")
 		}
 		// Create acls
 		var useBlocks []useBlock
 		isHTTPS := false
-		useBlocks, backends = createAcls(frontendSection, services, frontend, isHTTPS, aclNameGen, backends)
+		mapPath := s.mapFilePath(services, frontend, frontend.Name(), isHTTPS)
+		// ACL names are generated per section, so changes in one frontend do not rename the ACLs of others
+		useBlocks, backends = createAcls(frontendSection, services, frontend, isHTTPS, NewNameGenerator("acl"), backends, mapPath)
+		addMapFile(mapFiles, mapPath, useBlocks)
 		// Create link to backends
-		createUseBackends(frontendSection, useBlocks, frontend, (secureFrontendSection != nil), frontend.Public && frontend.IsHTTP() && s.ForceSsl, haveCertificates)
+		s.addAuthFilters(frontendSection, useBlocks, usedAuthAgents)
+		s.addTraps(frontendSection, useBlocks, frontend)
+		createUseBackends(frontendSection, useBlocks, backends, frontend, s.HaproxyVersion, frontend.Public && (secureFrontendSection != nil), frontend.Public && frontend.IsHTTP() && s.ForceSsl, haveCertificates, s.ForceSslExemptPaths, mapPath, s.spoeAgentsByName())
 		if secureFrontendSection != nil {
 			isHTTPS = true
-			useBlocks, backends = createAcls(secureFrontendSection, services, frontend, isHTTPS, aclNameGen, backends)
-			createUseBackends(secureFrontendSection, useBlocks, frontend, false, false, haveCertificates)
+			mapPath := s.mapFilePath(services, frontend, "secure-"+frontend.Name(), isHTTPS)
+			useBlocks, backends = createAcls(secureFrontendSection, services, frontend, isHTTPS, NewNameGenerator("acl"), backends, mapPath)
+			addMapFile(mapFiles, mapPath, useBlocks)
+			s.addAuthFilters(secureFrontendSection, useBlocks, usedAuthAgents)
+			s.addTraps(secureFrontendSection, useBlocks, frontend)
+			createUseBackends(secureFrontendSection, useBlocks, backends, frontend, s.HaproxyVersion, false, false, haveCertificates, nil, mapPath, s.spoeAgentsByName())
 		}
 	}
 
+	backends = s.createTcpPortRangeFrontends(c, services, frontends, backends)
+
 	// Create stats section
 	if s.StatsPort != 0 && s.StatsUser != "" && s.StatsPassword != "" {
 		statsSection := c.Section("frontend stats")
@@ -261,6 +471,12 @@ func (s *Service) renderConfig(services backend.ServiceRegistrations) (string, e
 		if statsCerts != "" {
 			statsSection.Add(securityOptions...)
 		}
+		if s.StatsCompression {
+			statsSection.Add(
+				"compression algo gzip",
+				"compression type text/html text/plain text/csv",
+			)
+		}
 	}
 
 	// Private stats
@@ -280,60 +496,165 @@ func (s *Service) renderConfig(services backend.ServiceRegistrations) (string, e
 		backendNames = append(backendNames, name)
 	}
 	sort.Strings(backendNames)
+	usedSpoeAgents := make(map[string]struct{})
+	usedScanAgents := make(map[string]struct{})
 	for _, name := range backendNames {
 		// Create backend
 		b := backends[name]
-		backendSection := c.Section(fmt.Sprintf("backend %s", b.Name))
+		options := []string{}
 		sticky, err := b.IsSticky()
 		if err != nil {
 			return "", maskAny(err)
 		}
+		hashOn, err := b.HashOn()
+		if err != nil {
+			return "", maskAny(err)
+		}
+		hashRules := []string{}
 		if sticky {
-			backendSection.Add("balance source")
+			options = append(options, "balance source")
+		} else if hashOn != "" {
+			var balance []string
+			balance, hashRules = createHashOnOptions(hashOn, s.HaproxyVersion)
+			options = append(options, balance...)
+			// Bounded load requires HAProxy 2.0
+			if factor := b.HashBalanceFactor(); factor != 0 && s.HaproxyVersion.AtLeast(2, 0) {
+				options = append(options, fmt.Sprintf("hash-balance-factor %d", factor))
+			}
 		} else {
-			backendSection.Add("balance roundrobin")
+			options = append(options, "balance roundrobin")
 		}
 		mode, err := b.Mode()
 		if err != nil {
 			return "", maskAny(err)
 		}
 		if mode == "http" {
-			backendSection.Add("mode http")
+			options = append(options, "mode http")
+			connectionMode, err := b.ConnectionMode()
+			if err != nil {
+				return "", maskAny(err)
+			}
+			if option := s.createConnectionModeOption(connectionMode, connectionModes); option != "" {
+				options = append(options, option)
+			}
+			options = append(options, s.createBlocklistRules(b.Blocklists(), mode)...)
+			options = append(options, s.createSensitivePathRules(b)...)
 			if !b.HasAllowUnauthorized() {
-				backendSection.Add(securityOptions...)
+				options = append(options, securityOptions...)
+			}
+			options = append(options, b.MetadataHeaders()...)
+			options = append(options, hashRules...)
+			externalHost, err := b.ExternalHost()
+			if err != nil {
+				return "", maskAny(err)
+			}
+			if externalHost != "" {
+				options = append(options, fmt.Sprintf("http-request set-header Host %s", externalHost))
+			}
+			options = append(options, s.createSpoeFilters(b.Filters(), usedSpoeAgents)...)
+			bodyScan, err := b.BodyScan()
+			if err != nil {
+				return "", maskAny(err)
+			}
+			options = append(options, s.createBodyScanRules(bodyScan, usedScanAgents)...)
+			for _, name := range b.LuaActions() {
+				options = append(options, "http-request lua."+name)
 			}
 		} else if mode == "tcp" {
-			backendSection.Add("mode tcp")
+			options = append(options, "mode tcp")
+			options = append(options, s.createBlocklistRules(b.Blocklists(), mode)...)
+		} else if mode == "mail" {
+			options = append(options, "mode tcp")
+			options = append(options, mailBackendOptions...)
+			options = append(options, s.createBlocklistRules(b.Blocklists(), mode)...)
 		} else {
 			return "", maskAny(fmt.Errorf("Unknown service mode '%s'", mode))
 		}
-		method, hasCheckMethod, err := b.HttpCheckMethod()
+		method, _, err := b.HttpCheckMethod()
 		if err != nil {
 			return "", maskAny(err)
 		}
-		path, hasCheckPath, err := b.HttpCheckPath()
+		path, _, err := b.HttpCheckPath()
 		if err != nil {
 			return "", maskAny(err)
 		}
-		if hasCheckMethod || hasCheckPath {
-			backendSection.Add(fmt.Sprintf("option httpchk %s %s", method, path))
-		}
-		for _, sr := range b.Services {
-			for i, instance := range sr.Instances {
-				id := fmt.Sprintf("s%d-%s-%d", i, instance.IP, instance.Port)
-				id = strings.Replace(id, ".", "_", -1)
-				id = strings.Replace(id, ":", "_", -1)
-				id = strings.Replace(id, "[", "", -1)
-				id = strings.Replace(id, "]", "", -1)
-				id = strings.Replace(id, "%", "", -1)
-				check := ""
-				if sr.HttpCheckPath != "" || sr.HttpCheckMethod != "" || sr.Backup {
-					check = "check"
-					if sr.Backup {
-						check = check + " backup"
-					}
-				}
-				backendSection.Add(fmt.Sprintf("server %s %s:%d %s", id, instance.IP, instance.Port, check))
+		host, err := b.HttpCheckHost()
+		if err != nil {
+			return "", maskAny(err)
+		}
+		grpc, err := b.IsGrpc()
+		if err != nil {
+			return "", maskAny(err)
+		}
+		// HTTP/2 to the servers requires HAProxy 2.0 or later
+		grpc = grpc && s.HaproxyVersion.AtLeast(2, 0)
+		if grpc {
+			options = append(options, grpcRetryOptions...)
+			if s.HaproxyVersion.AtLeast(2, 2) {
+				options = append(options, grpcCheckOptions...)
+			}
+		} else if b.HasHttpCheck() {
+			// Any of the http-check settings (also host, port or interval alone) enables an HTTP check
+			switch {
+			case host == "":
+				options = append(options, fmt.Sprintf("option httpchk %s %s", method, path))
+			case s.HaproxyVersion.AtLeast(2, 2):
+				options = append(options,
+					"option httpchk",
+					fmt.Sprintf("http-check send meth %s uri %s ver HTTP/1.1 hdr Host %s", method, path, host),
+				)
+			default:
+				options = append(options, fmt.Sprintf("option httpchk %s %s HTTP/1.1\\r\\nHost:\\ %s", method, path, host))
+			}
+		}
+		if mode == "tcp" {
+			tcpCheck, err := b.TcpCheckOption()
+			if err != nil {
+				return "", maskAny(err)
+			}
+			if tcpCheck != "" {
+				options = append(options, tcpCheck)
+			}
+		}
+		if !s.HaproxyVersion.AtLeast(2, 4) {
+			if timeout := b.RequestTimeout(); timeout != "" {
+				options = append(options, fmt.Sprintf("timeout server %s", timeout))
+			}
+		}
+		if timeout := b.QueueTimeout(); timeout != "" {
+			options = append(options, fmt.Sprintf("timeout queue %s", timeout))
+		}
+		if fullConn := b.FullConn(); fullConn > 0 {
+			options = append(options, fmt.Sprintf("fullconn %d", fullConn))
+		}
+		backendSection := c.Section(fmt.Sprintf("backend %s", b.Name))
+		backendSection.Add(options...)
+		if b.AllBackups() {
+			backendSection.Add("option allbackups")
+		}
+		backendSection.Add(s.createServers(b, grpc, false)...)
+
+		// Create a backend in which the backup servers are promoted to primary servers.
+		// It is used when there are not enough primary servers left.
+		if b.MinActive() > 0 {
+			promotedSection := c.Section(fmt.Sprintf("backend %s", promotedBackendName(b.Name)))
+			promotedSection.Add(options...)
+			promotedSection.Add(s.createServers(b, grpc, true)...)
+		}
+	}
+
+	// Create backends of the SPOE agents used by filters
+	spoeConfigs := s.createSpoeBackends(c, usedSpoeAgents, usedAuthAgents, usedScanAgents)
+
+	// Create maintenance backend (used when there are not enough healthy instances)
+	for _, b := range backends {
+		if b.MinInstances() > 0 {
+			if mode, _ := b.Mode(); mode == "http" {
+				c.Section("backend "+maintenanceBackendName).Add(
+					"mode http",
+					"errorfile 503 /app/errors/503.http",
+				)
+				break
 			}
 		}
 	}
@@ -345,20 +666,264 @@ func (s *Service) renderConfig(services backend.ServiceRegistrations) (string, e
 		"balance roundrobin",
 		"errorfile 503 /app/errors/404.http", // Force not found
 	)
+	if s.PrivateDenyByDefault {
+		// Create denied backend, used for private requests that match no selector
+		deniedSection := c.Section("backend " + deniedBackendName)
+		deniedSection.Add(
+			"mode http",
+			"http-request deny deny_status 403",
+		)
+	}
+
+	s.lastMapFiles = mapFiles
+	s.lastSpoeConfigs = spoeConfigs
+	s.lastLuaScripts = luaScripts
+
+	// Refuse configurations that would allow registration data to inject directives
+	if err := c.Validate(); err != nil {
+		return "", maskAny(err)
+	}
 
 	// Render config
 	return c.Render(), nil
 }
 
+// mapFilePath returns the path of the map file used to select backends in the frontend section
+// with given name, or an empty string if that frontend section does not exceed the ACL limit.
+func (s *Service) mapFilePath(services backend.ServiceRegistrations, selection frontend, sectionName string, isHttps bool) string {
+	if s.MaxAclsPerFrontend <= 0 {
+		return ""
+	}
+	c := haproxy.NewConfig()
+	createAcls(c.Section("frontend "+sectionName), services, selection, isHttps, NewNameGenerator("acl"), make(map[string]backendConfig), "")
+	if countAcls(c.Render()) <= s.MaxAclsPerFrontend {
+		return ""
+	}
+	return filepath.Join(s.MapFilesFolder, sectionName+mapFileExt)
+}
+
+// createServers creates the server lines of the given backend.
+// If grpc is set, servers are connected to using HTTP/2 and are always checked.
+// If promoteBackups is set, backup servers are added as primary servers.
+func (s *Service) createServers(b backendConfig, grpc, promoteBackups bool) []string {
+	lines := []string{}
+	for _, sr := range b.Services {
+		for i, instance := range sr.Instances {
+			id := serverID(i, instance)
+			isBackup := sr.Backup || instance.Backup
+			check := ""
+			if sr.HasHttpCheck() || sr.TcpCheck != "" || grpc || isBackup || sr.ZoneAware {
+				check = "check"
+				if sr.HttpCheckPort != 0 {
+					check = fmt.Sprintf("%s port %d", check, sr.HttpCheckPort)
+				}
+				if sr.HttpCheckInterval != "" {
+					check = fmt.Sprintf("%s inter %s", check, sr.HttpCheckInterval)
+				}
+				if isBackup && !promoteBackups {
+					check = check + " backup"
+				}
+			}
+			if sr.SendProxy {
+				check = strings.TrimSpace(check + " send-proxy")
+			}
+			if sr.ExternalHost != "" {
+				check = strings.TrimSpace(check + " " + s.externalServerOptions(sr))
+			}
+			if grpc {
+				check = check + " proto h2"
+			}
+			if instance.IsDraining() {
+				// Weight 0 puts the server in drain state, it receives no new connections
+				check = strings.TrimSpace(check + " weight 0")
+			} else if instance.Weight != 0 {
+				check = strings.TrimSpace(fmt.Sprintf("%s weight %d", check, instance.Weight))
+			}
+			if instance.Health == backend.HealthUnhealthy {
+				check = strings.TrimSpace(check + " disabled")
+			}
+			if sr.MaxConn != 0 {
+				check = strings.TrimSpace(fmt.Sprintf("%s maxconn %d", check, sr.MaxConn))
+			}
+			if sr.AgentCheckPort != 0 {
+				check = strings.TrimSpace(fmt.Sprintf("%s agent-check agent-port %d", check, sr.AgentCheckPort))
+				if sr.AgentCheckInterval != "" {
+					check = fmt.Sprintf("%s agent-inter %s", check, sr.AgentCheckInterval)
+				}
+			}
+			lines = append(lines, fmt.Sprintf("server %s %s:%d %s", id, instance.IP, instance.Port, check))
+		}
+	}
+	return lines
+}
+
+// createHashOnOptions creates the balance options for the given hash key (path|header:<name>|url-param:<name>|cookie:<name>|jwt-claim:<name>),
+// together with the http-request rules that prepare the hashed value.
+func createHashOnOptions(hashOn string, version haproxy.Version) ([]string, []string) {
+	parts := strings.SplitN(hashOn, ":", 2)
+	kind, name := parts[0], parts[len(parts)-1]
+	switch kind {
+	case "path":
+		return []string{"balance uri", "hash-type consistent"}, nil
+	case "cookie":
+		if !version.AtLeast(2, 6) {
+			// Hashing a sample requires HAProxy 2.6, fall back to all cookies
+			return []string{"balance hdr(Cookie)", "hash-type consistent"}, nil
+		}
+		return []string{fmt.Sprintf("balance hash req.cook(%s)", name), "hash-type consistent"}, nil
+	case "url-param":
+		return []string{fmt.Sprintf("balance url_param %s", name), "hash-type consistent"}, nil
+	case "jwt-claim":
+		if !version.AtLeast(2, 6) {
+			// Decoding JWT's requires HAProxy 2.6, fall back to the entire token (which is still per user)
+			return []string{"balance hdr(Authorization)", "hash-type consistent"}, nil
+		}
+		return []string{fmt.Sprintf("balance hdr(%s)", hashKeyHeader), "hash-type consistent"},
+			[]string{fmt.Sprintf("http-request set-header %s %%[http_auth_bearer,jwt_payload_query('$.%s')]", hashKeyHeader, name)}
+	default:
+		return []string{fmt.Sprintf("balance hdr(%s)", name), "hash-type consistent"}, nil
+	}
+}
+
+// serverID creates the name of the server for the given instance.
+func serverID(index int, instance backend.ServiceInstance) string {
+	id := fmt.Sprintf("s%d-%s-%d", index, instance.IP, instance.Port)
+	id = strings.Replace(id, ".", "_", -1)
+	id = strings.Replace(id, ":", "_", -1)
+	id = strings.Replace(id, "[", "", -1)
+	id = strings.Replace(id, "]", "", -1)
+	id = strings.Replace(id, "%", "", -1)
+	return id
+}
+
+// collectFrontends returns a sorted list of all frontends needed for the given services.
+func (s *Service) collectFrontends(services backend.ServiceRegistrations) frontendList {
+	var frontends frontendList
+	frontendMap := make(map[string]frontend)
+	collectFrontend := func(index, edgePort int, public bool, mode string) {
+		if (public && s.ExcludePublic) || (!public && s.ExcludePrivate) {
+			return // Exclude
+		}
+		f := frontend{
+			index:  index,
+			Port:   edgePort,
+			Public: public,
+			Mode:   mode,
+		}
+		if _, ok := frontendMap[f.Name()]; !ok {
+			frontendMap[f.Name()] = f
+			frontends = append(frontends, f)
+		}
+	}
+	collectFrontend(0, PublicHttpPort, true, "http")   // Always create a public HTTP frontend
+	collectFrontend(1, PrivateHttpPort, false, "http") // Always create a private HTTP frontend
+	for _, sr := range services {
+		collectFrontend(2, sr.EdgePort, sr.Public, sr.Mode)
+	}
+	sort.Sort(frontends)
+	return frontends
+}
+
+// hasGrpcServices returns true if any of the services served by the given frontend speaks gRPC.
+func hasGrpcServices(services backend.ServiceRegistrations, selection frontend) bool {
+	for _, pair := range createSelectorServicePairs(services, selection) {
+		if pair.Service.Grpc {
+			return true
+		}
+	}
+	return false
+}
+
+// createMailCerts creates the `crt` options of the given (implicit TLS) mail frontend.
+// HAProxy selects the certificate based on SNI.
+func createMailCerts(services backend.ServiceRegistrations, selection frontend, sslCertsFolder string) []string {
+	certs := []string{}
+	certsSet := make(map[string]struct{})
+	for _, sr := range services {
+		if !sr.IsMail() || sr.EdgePort != selection.Port || sr.Public != selection.Public {
+			continue
+		}
+		for _, sel := range sr.Selectors {
+			if !sel.IsSecure() {
+				continue
+			}
+			certPath := sel.TmpSslCertPath
+			if certPath == "" {
+				certPath = filepath.Join(sslCertsFolder, sel.SslCertName)
+			}
+			if _, ok := certsSet[certPath]; !ok {
+				certs = append(certs, "crt "+certPath)
+				certsSet[certPath] = struct{}{}
+			}
+		}
+	}
+	return certs
+}
+
+// collectCertificates returns the `crt` options (one per folder) with the certificates of all
+// secure selectors of public (or private) services.
+// Certificates of private TCP services are served by the private TCP SSL frontend, mail certificates
+// by the mail frontends, so they are not included.
+func (s *Service) collectCertificates(services backend.ServiceRegistrations, public bool) []string {
+	certs := []string{}
+	certsSet := make(map[string]struct{})
+	for _, sr := range services {
+		if sr.Public != public || sr.IsMail() || (!public && !sr.IsHttp()) {
+			continue
+		}
+		for _, sel := range sr.Selectors {
+			if sel.IsSecure() {
+				certPath := sel.TmpSslCertPath
+				if certPath == "" {
+					certPath = filepath.Join(s.SslCertsFolder, sel.SslCertName)
+				}
+				certFolder := filepath.Dir(certPath)
+				if _, ok := certsSet[certFolder]; !ok {
+					crt := fmt.Sprintf("crt %s", certFolder)
+					certs = append(certs, crt)
+					certsSet[certFolder] = struct{}{}
+				}
+			}
+		}
+	}
+	return certs
+}
+
+// createPrivateTcpCrtList creates the lines of a crt-list file containing
+// the certificates (with their SNI filter) of all secure selectors
+// of services on the private TCP SSL frontend.
+func (s *Service) createPrivateTcpCrtList(services backend.ServiceRegistrations) []string {
+	lines := []string{}
+	linesSet := make(map[string]struct{})
+	for _, sr := range services {
+		if sr.Public || !sr.IsTcp() || sr.EdgePort != PrivateTcpSslPort {
+			continue
+		}
+		for _, sel := range sr.Selectors {
+			if !sel.IsSecure() || sel.Domain == "" {
+				continue
+			}
+			certPath := sel.TmpSslCertPath
+			if certPath == "" {
+				certPath = filepath.Join(s.SslCertsFolder, sel.SslCertName)
+			}
+			line := fmt.Sprintf("%s %s", certPath, sel.Domain)
+			if _, ok := linesSet[line]; !ok {
+				lines = append(lines, line)
+				linesSet[line] = struct{}{}
+			}
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
 // createAclRules create `acl` rules for the given selector
 func createAclRules(sel backend.ServiceSelector, isHttps, isTcp bool) []string {
 	result := []string{}
+	useSni := (sel.IsSecure() && isHttps) || isTcp
 	if sel.Domain != "" {
-		if (sel.IsSecure() && isHttps) || isTcp {
-			result = append(result, fmt.Sprintf("ssl_fc_sni -i %s", sel.Domain))
-		} else {
-			result = append(result, fmt.Sprintf("hdr_dom(host) -i %s", sel.Domain))
-		}
+		result = append(result, createDomainAclRule(sel.Domain, useSni))
 	}
 	if sel.PathPrefix != "" {
 		result = append(result, fmt.Sprintf("path_beg %s", sel.PathPrefix))
@@ -366,30 +931,122 @@ func createAclRules(sel backend.ServiceSelector, isHttps, isTcp bool) []string {
 	if len(result) == 0 && isTcp {
 		result = append(result, "always_true")
 	}
+	if len(sel.AllowedSources) > 0 {
+		result = append(result, "src "+strings.Join(sel.AllowedSources, " "))
+	}
+	return result
+}
+
+// createAclRuleSets creates sets of `acl` rules for the given selector, including
+// its any-of & none-of conditions. The selector matches when all rules of one of the sets match.
+// Rules prefixed with '!' must not match.
+func createAclRuleSets(sel backend.ServiceSelector, isHttps, isTcp bool) [][]string {
+	useSni := (sel.IsSecure() && isHttps) || isTcp
+	sets := [][]string{createAclRules(sel, isHttps, isTcp)}
+	expand := func(alternatives [][]string) {
+		var expanded [][]string
+		for _, set := range sets {
+			for _, alt := range alternatives {
+				expanded = append(expanded, append(append([]string{}, set...), alt...))
+			}
+		}
+		sets = expanded
+	}
+	if len(sel.AnyOf) > 0 {
+		var alternatives [][]string
+		for _, c := range sel.AnyOf {
+			alternatives = append(alternatives, createConditionAclRules(c, useSni))
+		}
+		expand(alternatives)
+	}
+	for _, c := range sel.NoneOf {
+		// !(a && b) == !a || !b
+		var alternatives [][]string
+		for _, rule := range createConditionAclRules(c, useSni) {
+			alternatives = append(alternatives, []string{"!" + rule})
+		}
+		expand(alternatives)
+	}
+	return sets
+}
+
+// createConditionAclRules creates `acl` rules for the given condition.
+func createConditionAclRules(c backend.Condition, useSni bool) []string {
+	result := []string{}
+	if c.Domain != "" {
+		result = append(result, createDomainAclRule(c.Domain, useSni))
+	}
+	if c.PathPrefix != "" {
+		result = append(result, fmt.Sprintf("path_beg %s", c.PathPrefix))
+	}
 	return result
 }
 
+// createDomainAclRule creates an `acl` rule matching the given (wildcard) domain.
+// Without SNI, the normalized host (see hostNormalizationOptions) is matched.
+func createDomainAclRule(domain string, useSni bool) string {
+	if strings.HasPrefix(domain, api.WildcardDomainPrefix) {
+		suffix := strings.TrimPrefix(domain, "*")
+		if useSni {
+			return fmt.Sprintf("ssl_fc_sni -m end -i %s", suffix)
+		}
+		return fmt.Sprintf("var(txn.host) -m end -i %s", suffix)
+	}
+	if useSni {
+		return fmt.Sprintf("ssl_fc_sni -i %s", domain)
+	}
+	return hostDomainAclPrefix + domain
+}
+
 // creteAcls create `acl` rules for the given services and adds them
 // to the given section
-func createAcls(section *haproxy.Section, services backend.ServiceRegistrations, selection frontend, isHttps bool, ng *nameGenerator, backends map[string]backendConfig) ([]useBlock, map[string]backendConfig) {
-	pairs := selectorServicePairs{}
-	for _, sr := range services {
-		if sr.IsHttp() == selection.IsHTTP() && sr.Public == selection.Public {
-			for selIndex, sel := range sr.Selectors {
-				pairs = append(pairs, selectorServicePair{
-					Selector:      sel,
-					SelectorIndex: selIndex,
-					Service:       sr,
-				})
+// If a map path is given, the trailing selectors that only match a domain are served
+// through that map file instead of `acl` rules.
+func createAcls(section *haproxy.Section, services backend.ServiceRegistrations, selection frontend, isHttps bool, ng *nameGenerator, backends map[string]backendConfig, mapPath string) ([]useBlock, map[string]backendConfig) {
+	pairs := createSelectorServicePairs(services, selection)
+	mapFrom := len(pairs)
+	if mapPath != "" {
+		// Only a trailing run of selectors can be moved to the end (into the map) without changing precedence
+		for mapFrom > 0 && mapDomain(pairs[mapFrom-1], isHttps) != "" {
+			mapFrom--
+		}
+	}
+	addToBackend := func(backendName string, sr backend.ServiceRegistration) {
+		backendCfg, ok := backends[backendName]
+		if !ok {
+			backendCfg = backendConfig{
+				Name: backendName,
 			}
 		}
+		if !backendCfg.Services.Contains(sr) {
+			backendCfg.Services = append(backendCfg.Services, sr)
+		}
+		backends[backendName] = backendCfg
 	}
-	sort.Sort(pairs)
 
 	useBlocks := []useBlock{}
 	rules2Block := make(map[string]useBlock)
-	for _, pair := range pairs {
-		rules := createAclRules(pair.Selector, isHttps, pair.Service.IsTcp())
+	for i, pair := range pairs {
+		if i >= mapFrom {
+			domain := mapDomain(pair, isHttps)
+			rulesKey := hostDomainAclPrefix + domain
+			block, ok := rules2Block[rulesKey]
+			if !ok {
+				block = useBlock{
+					BackendName: generateBackendName(pair.Service, selection),
+					MapDomain:   domain,
+				}
+				useBlocks = append(useBlocks, block)
+				rules2Block[rulesKey] = block
+			}
+			addToBackend(block.BackendName, pair.Service)
+			continue
+		}
+		ruleSets := createAclRuleSets(pair.Selector, isHttps, pair.Service.IsTcp())
+		if pair.Service.IsMail() {
+			// Mail servers talk first, so there is nothing to select on
+			ruleSets = [][]string{{"always_true"}}
+		}
 
 		authAclName := ""
 		if len(pair.Selector.Users) > 0 {
@@ -397,55 +1054,140 @@ func createAcls(section *haproxy.Section, services backend.ServiceRegistrations,
 			section.Add(fmt.Sprintf("acl %s http_auth(%s)", authAclName, userListName(pair.Service, pair.SelectorIndex)))
 		}
 
-		if len(rules) == 0 && authAclName == "" {
-			continue
-		}
-		rulesKey := strings.Join(rules, ",")
-		block, ok := rules2Block[rulesKey]
-		if !ok {
-			aclNames := []string{}
-			for _, rule := range rules {
-				aclName := ng.Next()
-				section.Add(fmt.Sprintf("acl %s %s", aclName, rule))
-				aclNames = append(aclNames, aclName)
-			}
-			backendName := generateBackendName(pair.Service, selection)
-			block = useBlock{
-				BackendName:       backendName,
-				AclNames:          aclNames,
-				AuthAclName:       authAclName,
-				RewriteRules:      pair.Selector.RewriteRules,
-				AllowUnauthorized: pair.Selector.AllowUnauthorized,
-				AllowInsecure:     pair.Selector.AllowInsecure,
-			}
-			useBlocks = append(useBlocks, block)
-			rules2Block[rulesKey] = block
-		}
-		backendCfg, ok := backends[block.BackendName]
-		if !ok {
-			backendCfg = backendConfig{
-				Name: block.BackendName,
+		for _, rules := range ruleSets {
+			if len(rules) == 0 && authAclName == "" {
+				continue
 			}
+			rulesKey := strings.Join(rules, ",")
+			block, ok := rules2Block[rulesKey]
+			if !ok {
+				aclNames := []string{}
+				for _, rule := range rules {
+					aclName := ng.Next()
+					if strings.HasPrefix(rule, "!") {
+						section.Add(fmt.Sprintf("acl %s %s", aclName, rule[1:]))
+						aclName = "!" + aclName
+					} else {
+						section.Add(fmt.Sprintf("acl %s %s", aclName, rule))
+					}
+					aclNames = append(aclNames, aclName)
+				}
+				backendName := generateBackendName(pair.Service, selection)
+				block = useBlock{
+					BackendName:       backendName,
+					AclNames:          aclNames,
+					AuthAclName:       authAclName,
+					RewriteRules:      pair.Selector.RewriteRules,
+					AllowUnauthorized: pair.Selector.AllowUnauthorized,
+					AllowInsecure:     pair.Selector.AllowInsecure,
+					CanonicalHost:     pair.Selector.CanonicalHost,
+					RequestTimeout:    pair.Selector.RequestTimeout,
+					Cache:             pair.Selector.Cache,
+					AuthAgent:         pair.Selector.AuthAgent,
+					Quota:             pair.Selector.Quota,
+					Tenant:            pair.Service.Tenant,
+					TenantQuota:       pair.Service.TenantQuota,
+					TrapPaths:         pair.Service.TrapPaths,
+					TrapAction:        pair.Service.TrapAction,
+				}
+				useBlocks = append(useBlocks, block)
+				rules2Block[rulesKey] = block
+			}
+			addToBackend(block.BackendName, pair.Service)
 		}
-		if !backendCfg.Services.Contains(pair.Service) {
-			backendCfg.Services = append(backendCfg.Services, pair.Service)
-		}
-		backends[block.BackendName] = backendCfg
 	}
 	return useBlocks, backends
 }
 
+// mapDomain returns the domain of the given selector if it can be served through a map file.
+// That is the case when the selector only matches a (non-wildcard) domain and needs no other rules.
+func mapDomain(pair selectorServicePair, isHttps bool) string {
+	sel, sr := pair.Selector, pair.Service
+	if !sr.IsHttp() || sr.MinInstances > 0 || sr.MinActive > 0 || sr.TenantQuota.IsEnabled() || len(sr.TrapPaths) > 0 {
+		return ""
+	}
+	if len(sel.Users) > 0 || len(sel.RewriteRules) > 0 || sel.AllowUnauthorized || sel.AllowInsecure || sel.CanonicalHost != "" || sel.RequestTimeout != "" || sel.Cache.IsEnabled() || sel.AuthAgent != "" || sel.Quota.IsEnabled() {
+		return ""
+	}
+	ruleSets := createAclRuleSets(sel, isHttps, false)
+	if len(ruleSets) != 1 || len(ruleSets[0]) != 1 || !strings.HasPrefix(ruleSets[0][0], hostDomainAclPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(ruleSets[0][0], hostDomainAclPrefix)
+}
+
+// addMapFile adds the content of the map file with given path (if any) to the given map files.
+// Each line maps a domain onto its backend.
+func addMapFile(mapFiles map[string][]string, mapPath string, useBlocks []useBlock) {
+	if mapPath == "" {
+		return
+	}
+	lines := []string{}
+	for _, block := range useBlocks {
+		if block.MapDomain != "" {
+			lines = append(lines, fmt.Sprintf("%s %s", block.MapDomain, block.BackendName))
+		}
+	}
+	mapFiles[mapPath] = lines
+}
+
 // createUseBackends creates a `use_backend` rules for the given input
-// and adds it to the given section
-func createUseBackends(section *haproxy.Section, useBlocks []useBlock, selection frontend, redirectHttps, forceSecure, haveCertificates bool) {
+// and adds it to the given section.
+// Requests of blocks with an auth agent that is not in authAgents are denied.
+// When insecure requests are redirected to HTTPS (forceSecure), ACME HTTP challenges and requests for
+// the given exempt paths are not redirected. Exempt paths are served by the backend of the matching block.
+func createUseBackends(section *haproxy.Section, useBlocks []useBlock, backends map[string]backendConfig, selection frontend, version haproxy.Version, redirectHttps, forceSecure, haveCertificates bool, exemptPaths []string, mapPath string, authAgents map[string]SpoeAgent) {
+	hasMapBlocks := false
+	httpsRedirect := "redirect scheme https if !{ ssl_fc }"
+	if forceSecure && haveCertificates {
+		section.Add(fmt.Sprintf("acl %s path_beg %s", acmeChallengeAclName, acmeChallengePathPrefix))
+		httpsRedirect = fmt.Sprintf("%s !%s", httpsRedirect, acmeChallengeAclName)
+		if len(exemptPaths) > 0 {
+			section.Add(fmt.Sprintf("acl %s path_beg %s", sslExemptAclName, strings.Join(exemptPaths, " ")))
+			httpsRedirect = fmt.Sprintf("%s !%s", httpsRedirect, sslExemptAclName)
+		}
+	}
 	for _, useBlock := range useBlocks {
+		if useBlock.MapDomain != "" {
+			hasMapBlocks = true
+		}
 		if len(useBlock.AclNames) == 0 {
 			continue
 		}
 		acls := strings.Join(useBlock.AclNames, " ")
 		skipUseBackend := false
+		if useBlock.CanonicalHost != "" && selection.IsHTTP() {
+			// Redirect to the canonical host first, so forced SSL does not cause a second redirect
+			notCanonical := fmt.Sprintf("!{ var(txn.host) -m str -i %s }", useBlock.CanonicalHost)
+			if forceSecure && haveCertificates {
+				// ACME HTTP challenges must be answered for the requested host
+				notCanonical = notCanonical + " !" + acmeChallengeAclName
+			}
+			addHostRedirect(section, useBlock.CanonicalHost, true, "", acls+" "+notCanonical, redirectHttps || (forceSecure && haveCertificates))
+		}
+		if (useBlock.Quota.IsEnabled() || useBlock.TenantQuota.IsEnabled()) && selection.IsHTTP() {
+			conditions := acls
+			if !useBlock.AllowInsecure && forceSecure && haveCertificates {
+				// Insecure requests are redirected, they do not count
+				conditions = conditions + " { ssl_fc }"
+			}
+			addQuotas(section, useBlock, conditions)
+		}
+		if useBlock.AuthAgent != "" && selection.IsHTTP() {
+			conditions := acls
+			if !useBlock.AllowInsecure && forceSecure && haveCertificates {
+				// Insecure requests are redirected (after all http-request rules)
+				conditions = conditions + " { ssl_fc }"
+			}
+			_, known := authAgents[useBlock.AuthAgent]
+			addExternalAuth(section, useBlock.AuthAgent, known, conditions)
+		}
 		if !useBlock.AllowInsecure && forceSecure && haveCertificates {
-			section.Add(fmt.Sprintf("redirect scheme https if !{ ssl_fc } %s", acls))
+			section.Add(fmt.Sprintf("%s %s", httpsRedirect, acls))
+			if len(exemptPaths) > 0 {
+				// Only exempt requests remain for this block
+				section.Add(fmt.Sprintf("use_backend %s if %s %s", useBlock.BackendName, acls, sslExemptAclName))
+			}
 			skipUseBackend = true
 		} else if useBlock.AllowUnauthorized {
 			section.Add(fmt.Sprintf("http-request allow if %s", acls))
@@ -464,39 +1206,184 @@ func createUseBackends(section *haproxy.Section, useBlocks []useBlock, selection
 			}
 			if rwRule.RemovePathPrefix != "" {
 				prefix := strings.TrimPrefix(strings.TrimSuffix(rwRule.RemovePathPrefix, "/"), "/")
-				section.Add(fmt.Sprintf(`reqrep ^([^\ :]*)\ /%s/(.*)     \1\ /\2  if %s`, prefix, acls))
+				if version.AtLeast(2, 2) {
+					section.Add(fmt.Sprintf(`http-request replace-path ^/%s/(.*) /\1 if %s`, prefix, acls))
+				} else if version.AtLeast(2, 0) {
+					section.Add(fmt.Sprintf("http-request set-path %%[path,regsub(^/%s/,/)] if %s", prefix, acls))
+				} else {
+					section.Add(fmt.Sprintf(`reqrep ^([^\ :]*)\ /%s/(.*)     \1\ /\2  if %s`, prefix, acls))
+				}
 			}
 			if rwRule.Domain != "" {
-				if redirectHttps {
-					section.Add(fmt.Sprintf("http-request redirect prefix https://%s code 301 if %s", rwRule.Domain, acls))
-				} else {
-					section.Add(fmt.Sprintf("http-request redirect prefix https://%s code 301 if { ssl_fc } %s", rwRule.Domain, acls))
-					section.Add(fmt.Sprintf("http-request redirect prefix http://%s code 301 if !{ ssl_fc } %s", rwRule.Domain, acls))
+				options := ""
+				if rwRule.DropQuery {
+					options = " drop-query"
 				}
+				addHostRedirect(section, rwRule.Domain, !rwRule.DropPath, options, acls, redirectHttps)
 				skipUseBackend = true
 			}
 		}
 		if !skipUseBackend {
+			if useBlock.RequestTimeout != "" && selection.IsHTTP() {
+				addRequestTimeout(section, useBlock.RequestTimeout, acls, version)
+			}
+			if useBlock.Cache.IsEnabled() && selection.IsHTTP() && version.AtLeast(1, 8) {
+				addCache(section, useBlock.Cache.Name(), acls)
+			}
+			if minInstances := backends[useBlock.BackendName].MinInstances(); minInstances > 0 {
+				notEnoughInstances := fmt.Sprintf("{ nbsrv(%s) lt %d }", useBlock.BackendName, minInstances)
+				if selection.IsHTTP() {
+					section.Add(fmt.Sprintf("use_backend %s if %s %s", maintenanceBackendName, acls, notEnoughInstances))
+				} else {
+					section.Add(fmt.Sprintf("tcp-request content reject if %s %s", acls, notEnoughInstances))
+				}
+			}
+			if minActive := backends[useBlock.BackendName].MinActive(); minActive > 0 {
+				section.Add(fmt.Sprintf("use_backend %s if %s { nbsrv(%s) lt %d }", promotedBackendName(useBlock.BackendName), acls, useBlock.BackendName, minActive))
+			}
 			section.Add(fmt.Sprintf("use_backend %s if %s", useBlock.BackendName, acls))
 		}
 	}
+	if hasMapBlocks {
+		lookup := fmt.Sprintf("var(txn.host),map_dom(%s)", mapPath)
+		if forceSecure && haveCertificates {
+			section.Add(fmt.Sprintf("%s { %s -m found }", httpsRedirect, lookup))
+			if len(exemptPaths) > 0 {
+				section.Add(fmt.Sprintf("use_backend %%[%s] if { %s -m found } %s", lookup, lookup, sslExemptAclName))
+			}
+		} else {
+			section.Add(fmt.Sprintf("use_backend %%[%s] if { %s -m found }", lookup, lookup))
+		}
+	}
+}
+
+// addRequestTimeout adds rules that override the server timeout of requests matching the given conditions
+// and pass the resulting deadline (unix time in seconds) to the server in an X-Deadline header.
+// Before HAProxy 2.4 the timeout cannot be set per request, it is set on the backend instead.
+func addRequestTimeout(section *haproxy.Section, timeout, conditions string, version haproxy.Version) {
+	if version.AtLeast(2, 4) {
+		section.Add(fmt.Sprintf("http-request set-timeout server %s if %s", timeout, conditions))
+	}
+	if d, err := haproxy.ParseInterval(timeout); err == nil {
+		seconds := int64((d + time.Second - 1) / time.Second)
+		section.Add(fmt.Sprintf("http-request set-header X-Deadline %%[date(%d)] if %s", seconds, conditions))
+	}
+}
+
+// addCache adds rules that serve requests matching the given conditions from the cache with given name
+// and store their responses in it.
+// The request conditions are no longer available for the response, so the cache is selected
+// in a transaction variable. Like use_backend, the first matching selector wins.
+func addCache(section *haproxy.Section, name, conditions string) {
+	selected := fmt.Sprintf("{ var(txn.cache) -m str %s }", name)
+	section.Add(
+		fmt.Sprintf("http-request set-var(txn.cache) str(%s) if %s !{ var(txn.cache) -m found }", name, conditions),
+		fmt.Sprintf("http-request cache-use %s if %s", name, selected),
+		fmt.Sprintf("http-response cache-store %s if %s", name, selected),
+	)
+}
+
+// createCaches creates a cache section for every distinct cache setting of the selectors of the given services.
+// Caching requires HAProxy 1.8.
+func (s *Service) createCaches(c *haproxy.Config, services backend.ServiceRegistrations) {
+	if !s.HaproxyVersion.AtLeast(1, 8) {
+		return
+	}
+	caches := make(map[string]backend.Cache)
+	names := []string{}
+	for _, sr := range services {
+		if !sr.IsHttp() {
+			continue
+		}
+		for _, sel := range sr.Selectors {
+			if !sel.Cache.IsEnabled() {
+				continue
+			}
+			name := sel.Cache.Name()
+			if _, ok := caches[name]; !ok {
+				caches[name] = sel.Cache
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	totalSize := s.CacheSize
+	if totalSize <= 0 {
+		totalSize = DefaultCacheSize
+	}
+	for _, name := range names {
+		cache := caches[name]
+		section := c.Section("cache " + name)
+		section.Add(fmt.Sprintf("total-max-size %d", totalSize))
+		if cache.MaxObjectSize > 0 {
+			section.Add(fmt.Sprintf("max-object-size %d", cache.MaxObjectSize))
+		}
+		section.Add(fmt.Sprintf("max-age %d", cache.MaxAge))
+		// Processing the Vary header requires HAProxy 2.4
+		if cache.Vary && s.HaproxyVersion.AtLeast(2, 4) {
+			section.Add("process-vary on")
+		}
+	}
+}
+
+// addHostRedirect adds rules that redirect requests matching the given conditions to the given host.
+// If keepURI is set, the path & query string of the request are kept, otherwise the
+// request is redirected to the root of the host.
+// Unless redirectHttps is set, the scheme of the request is kept.
+func addHostRedirect(section *haproxy.Section, host string, keepURI bool, options, conditions string, redirectHttps bool) {
+	redirect := func(scheme string) string {
+		if keepURI {
+			return fmt.Sprintf("http-request redirect prefix %s://%s code 301%s", scheme, host, options)
+		}
+		return fmt.Sprintf("http-request redirect location %s://%s/ code 301%s", scheme, host, options)
+	}
+	if redirectHttps {
+		section.Add(fmt.Sprintf("%s if %s", redirect("https"), conditions))
+	} else {
+		section.Add(fmt.Sprintf("%s if { ssl_fc } %s", redirect("https"), conditions))
+		section.Add(fmt.Sprintf("%s if !{ ssl_fc } %s", redirect("http"), conditions))
+	}
 }
 
 // generateBackendName creates a valid name for the backend of this registration
 // in haproxy.
 func generateBackendName(sr backend.ServiceRegistration, selection frontend) string {
-	return fmt.Sprintf("backend_%s_%d_%s", cleanName(sr.ServiceName), sr.ServicePort, selection.Name())
+	name := fmt.Sprintf("backend_%s%s_%d_%s", tenantPrefix(sr), cleanName(sr.ServiceName), sr.ServicePort, selection.Name())
+	if sr.Role != "" {
+		// Selectors for instances of a specific role (e.g. replica) need a backend of their own
+		name = name + "_" + cleanName(sr.Role)
+	}
+	if len(sr.InstanceMetadata) > 0 {
+		// Selectors for a subset of the instances need a backend of their own
+		name = name + "_" + cleanName(backend.FormatMetadata(sr.InstanceMetadata))
+	}
+	return name
+}
+
+// promotedBackendName creates the name of the backend in which the backup servers
+// of the given backend are promoted to primary servers.
+func promotedBackendName(backendName string) string {
+	return backendName + "_promoted"
 }
 
 // userListName creates a valid name for the userlist of this registration
 // in haproxy.
 func userListName(sr backend.ServiceRegistration, selectorIndex int) string {
-	return fmt.Sprintf("userlist_%s_%d_%d", cleanName(sr.ServiceName), sr.ServicePort, selectorIndex)
+	return fmt.Sprintf("userlist_%s%s_%d_%d", tenantPrefix(sr), cleanName(sr.ServiceName), sr.ServicePort, selectorIndex)
+}
+
+// tenantPrefix returns the prefix of generated names of the given registration,
+// such that names of different tenants never collide.
+func tenantPrefix(sr backend.ServiceRegistration) string {
+	if sr.Tenant == "" {
+		return ""
+	}
+	return "t_" + cleanName(sr.Tenant) + "_"
 }
 
-// cleanName removes invalid characters (for haproxy conf) from the given name
+// cleanName replaces invalid characters (for haproxy conf) in the given name with '_'.
 func cleanName(s string) string {
-	return s // TODO
+	return invalidNameCharRegexp.ReplaceAllString(s, "_")
 }
 
 type selectorServicePair struct {
@@ -515,9 +1402,7 @@ func (list selectorServicePairs) Len() int {
 // Less reports whether the element with
 // index i should sort before the element with index j.
 func (list selectorServicePairs) Less(i, j int) bool {
-	a := list[i].Selector.FullString() + list[i].Service.FullString()
-	b := list[j].Selector.FullString() + list[j].Service.FullString()
-	return strings.Compare(a, b) < 0
+	return hasPrecedence(list[i], list[j])
 }
 
 // Swap swaps the elements with indexes i and j.
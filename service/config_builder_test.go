@@ -1,11 +1,18 @@
 package service
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
 
+	logging "github.com/op/go-logging"
+
+	"github.com/pulcy/robin/haproxy"
 	"github.com/pulcy/robin/service/backend"
 )
 
@@ -27,6 +34,52 @@ var (
 			ForceSsl:    true,
 		},
 	}
+	sslExemptService = Service{
+		ServiceConfig: ServiceConfig{
+			PrivateHost:         "10.0.0.1",
+			ForceSsl:            true,
+			ForceSslExemptPaths: []string{"/healthz", "/.well-known/security.txt"},
+		},
+	}
+	privateDenyService = Service{
+		ServiceConfig: ServiceConfig{
+			PrivateHost:          "10.0.0.1",
+			PrivateDenyByDefault: true,
+		},
+	}
+	privateGatewayService = Service{
+		ServiceConfig: ServiceConfig{
+			PrivateHost:    "10.0.0.1",
+			PrivateGateway: true,
+		},
+	}
+	sensitivePathsService = Service{
+		ServiceConfig: ServiceConfig{
+			PrivateHost: "10.0.0.1",
+			SensitivePaths: SensitivePathsConfig{
+				Paths:          DefaultSensitivePaths,
+				AllowedSources: []string{"10.0.0.0/8"},
+				Users:          backend.Users{backend.User{Name: "ops", PasswordHash: "$6$hash"}},
+			},
+		},
+	}
+	realIPService = Service{
+		ServiceConfig: ServiceConfig{
+			PrivateHost: "10.0.0.1",
+			RealIP: RealIPConfig{
+				Header:         "CF-Connecting-IP",
+				ProxyProtocol:  true,
+				TrustedProxies: []string{"173.245.48.0/20", "103.21.244.0/22"},
+				Ports:          []int{PublicHttpPort},
+			},
+		},
+	}
+	tcpPortRangeService = Service{
+		ServiceConfig: ServiceConfig{
+			PrivateHost:  "10.0.0.1",
+			TcpPortRange: PortRange{Min: 10000, Max: 10099},
+		},
+	}
 	privateOnlyService = Service{
 		ServiceConfig: ServiceConfig{
 			PrivateHost:   "10.0.0.2",
@@ -46,6 +99,212 @@ var (
 			ExcludePublic:    true,
 		},
 	}
+	privateTcpCertService = Service{
+		ServiceConfig: ServiceConfig{
+			PrivateHost:           "10.0.0.1",
+			SslCertsFolder:        "/certs/",
+			PrivateTcpSslCert:     "private-ca.pem",
+			PrivateTcpCrtListPath: "/data/config/private-tcp-crt-list.txt",
+		},
+	}
+	mapFilesService = Service{
+		ServiceConfig: ServiceConfig{
+			PrivateHost:        "10.0.0.1",
+			MaxAclsPerFrontend: 2,
+			MapFilesFolder:     "/data/config/",
+		},
+	}
+	tlsStatsService = Service{
+		ServiceConfig: ServiceConfig{
+			PrivateHost:    "10.0.0.1",
+			SslCertsFolder: "/certs/",
+			TlsLogAddress:  "127.0.0.1:5140",
+		},
+	}
+	sslCertsService = Service{
+		ServiceConfig: ServiceConfig{
+			PrivateHost:    "10.0.0.1",
+			SslCertsFolder: "/certs/",
+		},
+	}
+	runtimeSocketService = Service{
+		ServiceConfig: ServiceConfig{
+			PrivateHost:       "10.0.0.1",
+			RuntimeSocketPath: "/var/run/haproxy.sock",
+		},
+	}
+	probedService = backend.ServiceRegistration{
+		ServiceName:   "web",
+		ServicePort:   80,
+		EdgePort:      PublicHttpPort,
+		Public:        true,
+		ProbeType:     "http",
+		ProbePath:     "/health",
+		ProbeInterval: "10s",
+		Instances: backend.ServiceInstances{
+			backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+			backend.ServiceInstance{IP: "192.168.35.3", Port: 2345},
+		},
+		Selectors: backend.ServiceSelectors{
+			backend.ServiceSelector{Domain: "foo.com"},
+		},
+		Mode: "http",
+	}
+	requestTimeoutServices = backend.ServiceRegistrations{
+		backend.ServiceRegistration{
+			ServiceName: "web",
+			ServicePort: 80,
+			EdgePort:    PublicHttpPort,
+			Public:      true,
+			Instances: backend.ServiceInstances{
+				backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+			},
+			Selectors: backend.ServiceSelectors{
+				backend.ServiceSelector{Domain: "foo.com"},
+				backend.ServiceSelector{Domain: "foo.com", PathPrefix: "/export", RequestTimeout: "10m"},
+			},
+			Mode: "http",
+		},
+	}
+	haproxy24Service = Service{
+		ServiceConfig: ServiceConfig{
+			PrivateHost:    "10.0.0.1",
+			HaproxyVersion: haproxy.Version{Major: 2, Minor: 4},
+		},
+	}
+	haproxy26Service = Service{
+		ServiceConfig: ServiceConfig{
+			PrivateHost:    "10.0.0.1",
+			HaproxyVersion: haproxy.Version{Major: 2, Minor: 6},
+		},
+	}
+	externalServices = backend.ServiceRegistrations{
+		backend.ServiceRegistration{
+			ServiceName: "docs",
+			ServicePort: 443,
+			EdgePort:    PublicHttpPort,
+			Public:      true,
+			Instances: backend.ServiceInstances{
+				backend.ServiceInstance{IP: "docs.example-saas.com", Port: 443},
+			},
+			Selectors: backend.ServiceSelectors{
+				backend.ServiceSelector{Domain: "docs.foo.com"},
+			},
+			ExternalHost: "docs.example-saas.com",
+			ExternalSsl:  true,
+			Mode:         "http",
+		},
+	}
+	cacheServices = backend.ServiceRegistrations{
+		backend.ServiceRegistration{
+			ServiceName: "web",
+			ServicePort: 80,
+			EdgePort:    PublicHttpPort,
+			Public:      true,
+			Instances: backend.ServiceInstances{
+				backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+			},
+			Selectors: backend.ServiceSelectors{
+				backend.ServiceSelector{Domain: "foo.com"},
+				backend.ServiceSelector{Domain: "foo.com", PathPrefix: "/static/", Cache: backend.Cache{MaxAge: 300, MaxObjectSize: 65536, Vary: true}},
+				backend.ServiceSelector{Domain: "foo.com", PathPrefix: "/health", Cache: backend.Cache{MaxAge: 5}},
+			},
+			Mode: "http",
+		},
+	}
+	spoeService = Service{
+		ServiceConfig: ServiceConfig{
+			HaproxyConfPath: "/data/config/haproxy.cfg",
+			PrivateHost:     "10.0.0.1",
+			HaproxyVersion:  haproxy.Version{Major: 2, Minor: 4},
+			SpoeAgents: []SpoeAgent{
+				SpoeAgent{Name: "compress", Address: "127.0.0.1:12345"},
+				SpoeAgent{Name: "audit", Address: "127.0.0.1:12346"},
+				SpoeAgent{Name: "opa", Address: "127.0.0.1:9191"},
+				SpoeAgent{Name: "icap", Address: "127.0.0.1:12347"},
+			},
+		},
+	}
+	hashOnServices = backend.ServiceRegistrations{
+		backend.ServiceRegistration{
+			ServiceName: "web",
+			ServicePort: 80,
+			EdgePort:    PublicHttpPort,
+			Public:      true,
+			Instances: backend.ServiceInstances{
+				backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+			},
+			Selectors: backend.ServiceSelectors{
+				backend.ServiceSelector{Domain: "foo.com"},
+			},
+			HashOn: "header:X-Tenant",
+			Mode:   "http",
+		},
+		backend.ServiceRegistration{
+			ServiceName: "search",
+			ServicePort: 80,
+			EdgePort:    PublicHttpPort,
+			Public:      true,
+			Instances: backend.ServiceInstances{
+				backend.ServiceInstance{IP: "192.168.35.3", Port: 2345},
+			},
+			Selectors: backend.ServiceSelectors{
+				backend.ServiceSelector{Domain: "search.foo.com"},
+			},
+			HashOn: "url-param:user",
+			Mode:   "http",
+		},
+		backend.ServiceRegistration{
+			ServiceName: "api",
+			ServicePort: 80,
+			EdgePort:    PublicHttpPort,
+			Public:      true,
+			Instances: backend.ServiceInstances{
+				backend.ServiceInstance{IP: "192.168.35.4", Port: 2345},
+			},
+			Selectors: backend.ServiceSelectors{
+				backend.ServiceSelector{Domain: "api.foo.com"},
+			},
+			HashOn: "jwt-claim:sub",
+			Mode:   "http",
+		},
+		backend.ServiceRegistration{
+			ServiceName: "cache",
+			ServicePort: 80,
+			EdgePort:    PublicHttpPort,
+			Public:      true,
+			Instances: backend.ServiceInstances{
+				backend.ServiceInstance{IP: "192.168.35.5", Port: 2345},
+				backend.ServiceInstance{IP: "192.168.35.6", Port: 2345},
+			},
+			Selectors: backend.ServiceSelectors{
+				backend.ServiceSelector{Domain: "cache.foo.com"},
+			},
+			HashOn:            "path",
+			HashBalanceFactor: 150,
+			Mode:              "http",
+		},
+		backend.ServiceRegistration{
+			ServiceName: "shop",
+			ServicePort: 80,
+			EdgePort:    PublicHttpPort,
+			Public:      true,
+			Instances: backend.ServiceInstances{
+				backend.ServiceInstance{IP: "192.168.35.7", Port: 2345},
+			},
+			Selectors: backend.ServiceSelectors{
+				backend.ServiceSelector{Domain: "shop.foo.com"},
+			},
+			HashOn: "cookie:session",
+			Mode:   "http",
+		},
+	}
+	zoneService = Service{
+		ServiceConfig: ServiceConfig{
+			PrivateHost: "10.0.0.1",
+			Zone:        "eu-west-1a",
+		},
+	}
 	configTests = []configTest{
 		configTest{
 			Service:    testService,
@@ -374,39 +633,1603 @@ var (
 			},
 			ResultPath: "./fixtures/ssh_gogs.txt",
 		},
-	}
-)
-
-func TestConfigs(t *testing.T) {
-	updateFixtures := os.Getenv("UPDATE-FIXTURES") == "1"
-	for _, test := range configTests {
-		result, err := test.Service.renderConfig(test.Services)
-		if err != nil {
-			t.Errorf("Test failed: %#v", err)
-		} else {
-			if updateFixtures {
-				err := ioutil.WriteFile(test.ResultPath, []byte(result), 0644)
-				if err != nil {
-					t.Errorf("Cannot update fixture %s: %#v", test.ResultPath, err)
-				}
-			} else {
-				expectedRaw, err := ioutil.ReadFile(test.ResultPath)
-				if err != nil {
-					t.Errorf("Cannot read fixture %s: %#v", test.ResultPath, err)
-				} else {
-					expected := strings.Split(string(expectedRaw), "\n")
-					lines := strings.Split(result, "\n")
-					for i, line := range lines {
-						if i >= len(expected) {
-							t.Errorf("Unexpected addition: `%s`", line)
-							break
-						} else if expected[i] != line {
-							t.Errorf("Diff at %d: expected `%s` got `%s`", i, expected[i], line)
-							break
-						}
-					}
-				}
-			}
+		configTest{
+			Service: privateTcpCertService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "db",
+					ServicePort: 5432,
+					EdgePort:    PrivateTcpSslPort,
+					Public:      false,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 5432},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{
+							Domain:      "db.private",
+							SslCertName: "db-private.pem",
+						},
+					},
+					Mode: "tcp",
+				},
+			},
+			ResultPath: "./fixtures/private_tcp_sni_certs.txt",
+		},
+		configTest{
+			Service: privateTcpCertService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "db",
+					ServicePort: 5432,
+					EdgePort:    PrivateTcpSslPort,
+					Public:      false,
+					Role:        "primary",
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 5432, Role: "primary"},
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 5432, Role: "replica", Backup: true},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{
+							Domain:      "db.private",
+							SslCertName: "db-private.pem",
+						},
+					},
+					Mode: "tcp",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "db",
+					ServicePort: 5432,
+					EdgePort:    PrivateTcpSslPort,
+					Public:      false,
+					Role:        "replica",
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 5432, Role: "primary", Backup: true},
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 5432, Role: "replica"},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{
+							Domain:      "db-ro.private",
+							SslCertName: "db-private.pem",
+						},
+					},
+					Mode: "tcp",
+				},
+			},
+			ResultPath: "./fixtures/private_tcp_roles.txt",
+		},
+		configTest{
+			Service: testService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "admin",
+					ServicePort: 80,
+					EdgePort:    PrivateHttpPort,
+					Public:      false,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{
+							Domain:         "admin.internal",
+							TmpSslCertPath: "/tmp/certificates/admin.internal.pem",
+						},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/private_https.txt",
+		},
+		configTest{
+			Service: tlsStatsService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{
+							Domain:      "foo.com",
+							SslCertName: "foo-com.crt",
+						},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/tls_stats.txt",
+		},
+		configTest{
+			Service: haproxy24Service,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "api",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{
+							Domain:     "foo.com",
+							PathPrefix: "/api",
+							RewriteRules: []backend.RewriteRule{
+								backend.RewriteRule{
+									PathPrefix:       "/v1",
+									RemovePathPrefix: "/api",
+								},
+							},
+						},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/haproxy_2_4_service.txt",
+		},
+		configTest{
+			Service: sslCertsService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "customers",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{
+							Domain:      "*.foo.com",
+							SslCertName: "wildcard-foo-com.crt",
+						},
+					},
+					Mode: "http",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "www",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{
+							Domain:      "www.foo.com",
+							SslCertName: "wildcard-foo-com.crt",
+						},
+						backend.ServiceSelector{
+							Domain: "www.foo.com",
+						},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/wildcard_domains.txt",
+		},
+		configTest{
+			Service: testService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com", PathPrefix: "/"},
+						backend.ServiceSelector{Domain: "foo.com", PathPrefix: "/legacy/api", Weight: 10},
+					},
+					Mode: "http",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "api",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com", PathPrefix: "/api"},
+						backend.ServiceSelector{Domain: "foo.com", PathPrefix: "/legacy"},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/route_precedence.txt",
+		},
+		configTest{
+			Service: testService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{
+							Domain: "foo.com",
+							AnyOf: []backend.Condition{
+								backend.Condition{PathPrefix: "/app"},
+								backend.Condition{PathPrefix: "/static"},
+							},
+							NoneOf: []backend.Condition{
+								backend.Condition{PathPrefix: "/app/internal"},
+							},
+						},
+						backend.ServiceSelector{
+							Domain: "bar.com",
+							NoneOf: []backend.Condition{
+								backend.Condition{Domain: "bar.com", PathPrefix: "/admin"},
+							},
+						},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/selector_conditions.txt",
+		},
+		configTest{
+			Service: forceSecureService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{
+							Domain:        "example.com",
+							SslCertName:   "example-com.pem",
+							CanonicalHost: "www.example.com",
+						},
+						backend.ServiceSelector{
+							Domain:        "www.example.com",
+							SslCertName:   "example-com.pem",
+							CanonicalHost: "www.example.com",
+						},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/canonical_host.txt",
+		},
+		configTest{
+			Service: testService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "old",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{
+							Domain:       "old.com",
+							RewriteRules: []backend.RewriteRule{backend.RewriteRule{Domain: "new.com"}},
+						},
+						backend.ServiceSelector{
+							Domain:       "old.org",
+							RewriteRules: []backend.RewriteRule{backend.RewriteRule{Domain: "new.org", DropQuery: true}},
+						},
+						backend.ServiceSelector{
+							Domain:       "old.net",
+							RewriteRules: []backend.RewriteRule{backend.RewriteRule{Domain: "new.net", DropPath: true}},
+						},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/domain_redirects.txt",
+		},
+		configTest{
+			Service: testService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName:       "web",
+					ServicePort:       80,
+					EdgePort:          PublicHttpPort,
+					Public:            true,
+					HttpCheckPath:     "/health",
+					HttpCheckHost:     "web.internal",
+					HttpCheckPort:     8081,
+					HttpCheckInterval: "5s",
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/http_check_host_port.txt",
+		},
+		configTest{
+			Service: testService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName:       "web",
+					ServicePort:       80,
+					EdgePort:          PublicHttpPort,
+					Public:            true,
+					HttpCheckPort:     8081,
+					HttpCheckInterval: "5s",
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/http_check_port_only.txt",
+		},
+		configTest{
+			Service: haproxy24Service,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName:   "web",
+					ServicePort:   80,
+					EdgePort:      PublicHttpPort,
+					Public:        true,
+					HttpCheckPath: "/health",
+					HttpCheckHost: "web.internal",
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/http_check_host_2_4.txt",
+		},
+		configTest{
+			Service: testService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName:        "web",
+					ServicePort:        80,
+					EdgePort:           PublicHttpPort,
+					Public:             true,
+					AgentCheckPort:     9999,
+					AgentCheckInterval: "2s",
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/agent_check.txt",
+		},
+		configTest{
+			Service: runtimeSocketService,
+			Services: backend.ServiceRegistrations{
+				probedService,
+			},
+			ResultPath: "./fixtures/runtime_socket.txt",
+		},
+		configTest{
+			Service: testService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName:   "web",
+					ServicePort:   80,
+					EdgePort:      PublicHttpPort,
+					Public:        true,
+					HttpCheckPath: "/health",
+					AllBackups:    true,
+					MinActive:     2,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 2345},
+						backend.ServiceInstance{IP: "192.168.35.4", Port: 2345},
+						backend.ServiceInstance{IP: "192.168.35.5", Port: 2345, Backup: true},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/backup_promotion.txt",
+		},
+		configTest{
+			Service: testService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName:   "web",
+					ServicePort:   80,
+					EdgePort:      PublicHttpPort,
+					Public:        true,
+					HttpCheckPath: "/health",
+					MinInstances:  2,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 2345},
+						backend.ServiceInstance{IP: "192.168.35.4", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					Mode: "http",
+				},
+				backend.ServiceRegistration{
+					ServiceName:  "db",
+					ServicePort:  5432,
+					EdgePort:     PrivateTcpSslPort,
+					MinInstances: 1,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 5432},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "db.internal"},
+					},
+					Mode: "tcp",
+				},
+			},
+			ResultPath: "./fixtures/min_instances.txt",
+		},
+		configTest{
+			Service: testService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName:  "mysql",
+					ServicePort:  3306,
+					EdgePort:     PrivateTcpSslPort,
+					TcpCheck:     "mysql",
+					TcpCheckUser: "haproxy",
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 3306},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "mysql.internal"},
+					},
+					Mode: "tcp",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "redis",
+					ServicePort: 6379,
+					EdgePort:    PrivateTcpSslPort,
+					TcpCheck:    "redis",
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 6379},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "redis.internal"},
+					},
+					Mode: "tcp",
+				},
+			},
+			ResultPath: "./fixtures/tcp_checks.txt",
+		},
+		configTest{
+			Service: sslCertsService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "smtp",
+					ServicePort: 25,
+					EdgePort:    25,
+					Public:      true,
+					SendProxy:   true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2525},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "mail.foo.com"},
+					},
+					Mode: "mail",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "imap",
+					ServicePort: 143,
+					EdgePort:    993,
+					Public:      true,
+					SendProxy:   true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 1143},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "mail.foo.com", SslCertName: "mail-foo-com.pem"},
+					},
+					Mode: "mail",
+				},
+			},
+			ResultPath: "./fixtures/mail.txt",
+		},
+		configTest{
+			Service: haproxy24Service,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "greeter",
+					ServicePort: 50051,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Grpc:        true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 50051},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "greeter.foo.com", SslCertName: "greeter-foo-com.pem"},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/grpc.txt",
+		},
+		configTest{
+			Service:    haproxy24Service,
+			Services:   requestTimeoutServices,
+			ResultPath: "./fixtures/request_timeout_2_4.txt",
+		},
+		configTest{
+			Service:    testService,
+			Services:   requestTimeoutServices,
+			ResultPath: "./fixtures/request_timeout.txt",
+		},
+		configTest{
+			Service: testService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345, Metadata: map[string]string{"zone": "eu-west-1a", "version": "v1"}},
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 2345, Metadata: map[string]string{"zone": "eu-west-1b", "version": "v1"}},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					MetadataHeaders: map[string]string{"zone": "X-Served-Zone"},
+					Mode:            "http",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.4", Port: 2345, Metadata: map[string]string{"zone": "eu-west-1a", "version": "v2"}},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com", PathPrefix: "/v2"},
+					},
+					InstanceMetadata: map[string]string{"version": "v2"},
+					Mode:             "http",
+				},
+			},
+			ResultPath: "./fixtures/instance_metadata.txt",
+		},
+		configTest{
+			Service: zoneService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345, Metadata: map[string]string{"zone": "eu-west-1a"}},
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 2345, Metadata: map[string]string{"zone": "eu-west-1b"}},
+						backend.ServiceInstance{IP: "192.168.35.4", Port: 2345, Metadata: map[string]string{"zone": "eu-west-1c"}},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					ZoneAware: true,
+					Mode:      "http",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "api",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.5", Port: 2345, Metadata: map[string]string{"zone": "eu-west-1b"}},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "api.foo.com"},
+					},
+					ZoneAware: true,
+					Mode:      "http",
+				},
+			},
+			ResultPath: "./fixtures/zone_aware.txt",
+		},
+		configTest{
+			Service:    testService,
+			Services:   hashOnServices,
+			ResultPath: "./fixtures/hash_on.txt",
+		},
+		configTest{
+			Service:    haproxy26Service,
+			Services:   hashOnServices,
+			ResultPath: "./fixtures/hash_on_2_6.txt",
+		},
+		configTest{
+			Service: testService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					MaxConn:      50,
+					FullConn:     80,
+					QueueTimeout: "10s",
+					Mode:         "http",
+				},
+			},
+			ResultPath: "./fixtures/queueing.txt",
+		},
+		configTest{
+			Service: mapFilesService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "api",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com", PathPrefix: "/api/"},
+					},
+					Mode: "http",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+						backend.ServiceSelector{Domain: "www.foo.com"},
+					},
+					Mode: "http",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "shop",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.4", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "shop.com"},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/map_files.txt",
+		},
+		configTest{
+			Service:    testService,
+			Services:   externalServices,
+			ResultPath: "./fixtures/external_url.txt",
+		},
+		configTest{
+			Service:    haproxy26Service,
+			Services:   externalServices,
+			ResultPath: "./fixtures/external_url_2_6.txt",
+		},
+		configTest{
+			Service: Service{
+				ServiceConfig: ServiceConfig{
+					PrivateHost:       "10.0.0.1",
+					ExternalResolvers: []string{"10.0.0.53:53"},
+					ExternalCAFile:    "/etc/robin/ca.pem",
+				},
+			},
+			Services:   externalServices,
+			ResultPath: "./fixtures/external_url_resolvers.txt",
+		},
+		configTest{
+			Service:    haproxy24Service,
+			Services:   cacheServices,
+			ResultPath: "./fixtures/cache.txt",
+		},
+		configTest{
+			Service:    testService,
+			Services:   cacheServices,
+			ResultPath: "./fixtures/cache_legacy.txt",
+		},
+		configTest{
+			Service: spoeService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					Filters: []string{"compress", "unknown", "audit"},
+					Mode:    "http",
+				},
+			},
+			ResultPath: "./fixtures/spoe_filters.txt",
+		},
+		configTest{
+			Service: spoeService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+						backend.ServiceSelector{Domain: "foo.com", PathPrefix: "/api/", AuthAgent: "opa"},
+						backend.ServiceSelector{Domain: "foo.com", PathPrefix: "/admin/", AuthAgent: "unknown"},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/spoe_auth.txt",
+		},
+		configTest{
+			Service: Service{
+				ServiceConfig: ServiceConfig{
+					HaproxyConfPath: "/data/config/haproxy.cfg",
+					PrivateHost:     "10.0.0.1",
+				},
+				luaScripts: map[string]string{
+					"hmac": "core.register_action('validate_hmac', { 'http-req' }, function(txn) end)",
+				},
+			},
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					LuaActions: []string{"validate_hmac"},
+					Mode:       "http",
+				},
+			},
+			ResultPath: "./fixtures/lua_actions.txt",
+		},
+		configTest{
+			Service: testService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345, Weight: 115},
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 2345, Weight: 115},
+						backend.ServiceInstance{IP: "192.168.35.4", Port: 2345, Weight: 26},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/split.txt",
+		},
+		configTest{
+			Service: testService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					Mode: "http",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Tenant:      "acme",
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{
+							Domain: "acme.com",
+							Users: backend.Users{
+								backend.User{Name: "admin", PasswordHash: "$6$abc"},
+							},
+						},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/tenants.txt",
+		},
+		configTest{
+			Service: Service{
+				ServiceConfig: ServiceConfig{
+					PrivateHost: "10.0.0.1",
+					TenantQuotas: map[string]backend.Quota{
+						"acme": backend.Quota{RequestsPerDay: 100000},
+					},
+				},
+			},
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{
+							Domain: "foo.com",
+							Quota:  backend.Quota{RequestsPerDay: 1000, BandwidthPerDay: 1073741824},
+						},
+					},
+					Mode: "http",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Tenant:      "acme",
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "acme.com"},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/quotas.txt",
+		},
+		configTest{
+			Service: Service{
+				ServiceConfig: ServiceConfig{
+					PrivateHost:    "10.0.0.1",
+					SslCertsFolder: "/certs/",
+					TlsLogAddress:  "127.0.0.1:5140",
+					HaproxyVersion: haproxy.Version{Major: 2, Minor: 4},
+					AccessLog: AccessLogConfig{
+						Address:       "127.0.0.1:5141",
+						SampleRate:    10,
+						Headers:       []string{"Referer"},
+						ScrubParams:   []string{"token"},
+						ScrubPatterns: []string{"[^/?&=]+@[^/?&=]+"},
+					},
+				},
+			},
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{
+							Domain:      "foo.com",
+							SslCertName: "foo-com.crt",
+						},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/access_log.txt",
+		},
+		configTest{
+			Service: Service{
+				ServiceConfig: ServiceConfig{
+					PrivateHost:    "10.0.0.1",
+					SslCertsFolder: "/certs/",
+					TlsLogAddress:  "127.0.0.1:5140",
+					HaproxyVersion: haproxy.Version{Major: 3, Minor: 1},
+					AccessLog: AccessLogConfig{
+						Address:    "127.0.0.1:5141",
+						SampleRate: 10,
+					},
+				},
+			},
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{
+							Domain:      "foo.com",
+							SslCertName: "foo-com.crt",
+						},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/access_log_tls_profile.txt",
+		},
+		configTest{
+			Service: Service{
+				ServiceConfig: ServiceConfig{
+					PrivateHost: "10.0.0.1",
+					IPBan: IPBanConfig{
+						Failures: 20,
+						Window:   DefaultBanWindow * 5,
+					},
+				},
+			},
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/ip_bans.txt",
+		},
+		configTest{
+			Service: Service{
+				ServiceConfig: ServiceConfig{
+					PrivateHost:    "10.0.0.1",
+					MapFilesFolder: "/data/config/",
+					Blocklists: []Blocklist{
+						Blocklist{Name: "drop", URL: "https://www.spamhaus.org/drop/drop.txt"},
+						Blocklist{Name: "crowdsec", URL: "http://127.0.0.1:8080", Format: BlocklistFormatCrowdSec},
+					},
+				},
+			},
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Blocklists:  []string{"drop", "crowdsec", "unknown"},
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					Mode: "http",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "db",
+					ServicePort: 5432,
+					EdgePort:    5432,
+					Public:      true,
+					Blocklists:  []string{"drop"},
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 5432},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "db.foo.com"},
+					},
+					Mode: "tcp",
+				},
+			},
+			ResultPath: "./fixtures/blocklists.txt",
+		},
+		configTest{
+			Service: Service{
+				ServiceConfig: ServiceConfig{
+					PrivateHost:    "10.0.0.1",
+					IPBan:          IPBanConfig{Failures: 20},
+					HaproxyVersion: haproxy.Version{Major: 1, Minor: 8},
+				},
+			},
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					TrapPaths:   []string{"/admin.php", "/wp-login.php"},
+					TrapAction:  "ban",
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					Mode: "http",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "shop",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					TrapPaths:   []string{"/.env"},
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "shop.com"},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/traps.txt",
+		},
+		configTest{
+			Service: spoeService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "upload",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					BodyScan:    backend.BodyScan{Agent: "icap", MaxBodySize: 10485760},
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "upload.foo.com"},
+					},
+					Mode: "http",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "forum",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					BodyScan:    backend.BodyScan{Agent: "icap", FailOpen: true},
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "forum.foo.com"},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/body_scan.txt",
+		},
+		configTest{
+			Service: Service{
+				ServiceConfig: ServiceConfig{
+					HaproxyConfPath: "/data/config/haproxy.cfg",
+					PrivateHost:     "10.0.0.1",
+					SpoeAgents: []SpoeAgent{
+						SpoeAgent{Name: "icap", Address: "127.0.0.1:12347"},
+					},
+				},
+			},
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "upload",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					BodyScan:    backend.BodyScan{Agent: "icap"},
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "upload.foo.com"},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/body_scan_legacy.txt",
+		},
+		configTest{
+			Service: testService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName:   "web",
+					ServicePort:   80,
+					EdgePort:      PublicHttpPort,
+					Public:        true,
+					HttpCheckPath: "/health",
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 2345, Weight: 10, Draining: true},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/deregistration_grace.txt",
+		},
+		configTest{
+			Service: testService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName:    "api",
+					ServicePort:    80,
+					EdgePort:       PublicHttpPort,
+					Public:         true,
+					ConnectionMode: "keep-alive",
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "api.foo.com"},
+					},
+					Mode: "http",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/connection_modes.txt",
+		},
+		configTest{
+			Service: sslExemptService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com", SslCertName: "foo-com.pem", PathPrefix: "/"},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/ssl_exempt_paths.txt",
+		},
+		configTest{
+			Service: privateDenyService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "admin",
+					ServicePort: 80,
+					EdgePort:    PrivateHttpPort,
+					Public:      false,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "admin.private", AllowedSources: []string{"10.0.0.0/8", "192.168.1.5"}},
+						backend.ServiceSelector{Domain: "status.private"},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/private_deny_by_default.txt",
+		},
+		configTest{
+			Service: privateGatewayService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					Mode: "http",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "api",
+					ServicePort: 8080,
+					EdgePort:    PrivateHttpPort,
+					Public:      false,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 2346},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "api.private"},
+					},
+					Mode: "http",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "db",
+					ServicePort: 5432,
+					EdgePort:    5432,
+					Public:      false,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.4", Port: 5432},
+					},
+					Mode: "tcp",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "admin",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.5", Port: 2347},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{
+							Domain:         "admin.com",
+							Users:          backend.Users{backend.User{Name: "ops", PasswordHash: "$6$hash"}},
+							AllowedSources: []string{"10.0.0.0/8"},
+						},
+					},
+					Mode: "http",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "mixed",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.6", Port: 2348},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "mixed.com"},
+						backend.ServiceSelector{Domain: "mixed.com", PathPrefix: "/admin/", AuthAgent: "auth"},
+					},
+					Mode: "http",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "__acme",
+					ServicePort: 8011,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "127.0.0.1", Port: 8011},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{PathPrefix: "/.well-known/acme-challenge/", AllowUnauthorized: true, AllowInsecure: true, Weight: 100},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/private_gateway.txt",
+		},
+		configTest{
+			Service: sensitivePathsService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					Mode: "http",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "metrics",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 2346},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "metrics.foo.com"},
+					},
+					Mode:            "http",
+					ExposeSensitive: true,
+				},
+				backend.ServiceRegistration{
+					ServiceName: "admin",
+					ServicePort: 80,
+					EdgePort:    PrivateHttpPort,
+					Public:      false,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.4", Port: 2347},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "admin.private"},
+					},
+					Mode: "http",
+				},
+			},
+			ResultPath: "./fixtures/sensitive_paths.txt",
+		},
+		configTest{
+			Service: realIPService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "web",
+					ServicePort: 80,
+					EdgePort:    PublicHttpPort,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "foo.com"},
+					},
+					Mode: "http",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "db",
+					ServicePort: 5432,
+					EdgePort:    5432,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 2346},
+					},
+					Mode: "tcp",
+				},
+			},
+			ResultPath: "./fixtures/real_ip.txt",
+		},
+		configTest{
+			Service: tcpPortRangeService,
+			Services: backend.ServiceRegistrations{
+				backend.ServiceRegistration{
+					ServiceName: "db",
+					ServicePort: 5432,
+					EdgePort:    10001,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.3", Port: 2346},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "db.example.com"},
+					},
+					Mode: "tcp",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "redis",
+					ServicePort: 6379,
+					EdgePort:    10002,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.4", Port: 6379},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{AllowedSources: []string{"10.0.0.0/8"}},
+					},
+					Mode: "tcp",
+				},
+				backend.ServiceRegistration{
+					ServiceName: "ssh",
+					ServicePort: 22,
+					EdgePort:    2222,
+					Public:      true,
+					Instances: backend.ServiceInstances{
+						backend.ServiceInstance{IP: "192.168.35.5", Port: 22},
+					},
+					Selectors: backend.ServiceSelectors{
+						backend.ServiceSelector{Domain: "ssh.example.com"},
+					},
+					Mode: "tcp",
+				},
+			},
+			ResultPath: "./fixtures/tcp_port_range.txt",
+		},
+	}
+)
+
+func TestConfigs(t *testing.T) {
+	for _, test := range configTests {
+		result, err := test.Service.renderConfig(test.Services)
+		if err != nil {
+			t.Errorf("Test failed: %#v", err)
+		} else {
+			compareFixture(t, test.ResultPath, result)
+		}
+	}
+}
+
+// TestRemoveStaleMapFiles checks that only the map files of the current config are kept.
+func TestRemoveStaleMapFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "robin-maps")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %#v", err)
+	}
+	defer os.RemoveAll(dir)
+	var test configTest
+	for _, x := range configTests {
+		if x.ResultPath == "./fixtures/map_files.txt" {
+			test = x
+		}
+	}
+	s := test.Service
+	s.MapFilesFolder = dir
+	s.Logger = logging.MustGetLogger("test")
+	for _, name := range []string{"private_http_in_81.map", "secure-public_http_in_443.map", "custom.map", "blocklist-tor.acl"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("old\n"), 0644); err != nil {
+			t.Fatalf("Cannot write %s: %#v", name, err)
+		}
+	}
+	if _, err := s.renderConfig(test.Services); err != nil {
+		t.Fatalf("renderConfig failed: %#v", err)
+	}
+	if len(s.lastMapFiles) == 0 {
+		t.Fatalf("Expected config with map files")
+	}
+	if err := s.writeMapFiles(); err != nil {
+		t.Fatalf("writeMapFiles failed: %#v", err)
+	}
+	s.removeStaleMapFiles()
+
+	expected := []string{"blocklist-tor.acl", "custom.map"}
+	for path := range s.lastMapFiles {
+		expected = append(expected, filepath.Base(path))
+	}
+	sort.Strings(expected)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Cannot read dir: %#v", err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected files %v, got %v", expected, names)
+	}
+}
+
+// TestConfigsDeterministic checks that the order of the registrations, instances & selectors
+// does not affect the rendered config.
+func TestConfigsDeterministic(t *testing.T) {
+	for _, test := range configTests {
+		expected, err := test.Service.renderConfig(test.Services)
+		if err != nil {
+			continue
+		}
+		reversed := backend.ServiceRegistrations{}
+		for i := len(test.Services) - 1; i >= 0; i-- {
+			sr := test.Services[i]
+			sr.Instances = backend.ServiceInstances{}
+			for j := len(test.Services[i].Instances) - 1; j >= 0; j-- {
+				sr.Instances = append(sr.Instances, test.Services[i].Instances[j])
+			}
+			sr.Selectors = backend.ServiceSelectors{}
+			for j := len(test.Services[i].Selectors) - 1; j >= 0; j-- {
+				sr.Selectors = append(sr.Selectors, test.Services[i].Selectors[j])
+			}
+			reversed = append(reversed, sr)
+		}
+		var before []string
+		for _, sr := range reversed {
+			before = append(before, fmt.Sprintf("%v %v", sr.Instances, sr.Selectors))
+		}
+		result, err := test.Service.renderConfig(reversed)
+		if err != nil {
+			t.Errorf("Test failed: %#v", err)
+		} else if result != expected {
+			t.Errorf("Config of %s depends on the order of its input", test.ResultPath)
+		}
+		// The input itself is not reordered
+		for i, sr := range reversed {
+			if fmt.Sprintf("%v %v", sr.Instances, sr.Selectors) != before[i] {
+				t.Errorf("Rendering %s changed input registration %d", test.ResultPath, i)
+			}
+		}
+	}
+}
+
+// TestKubernetesConfigs renders the service registrations created by the kubernetes backend tests.
+func TestKubernetesConfigs(t *testing.T) {
+	for _, name := range []string{"k8s_raw_ingress", "k8s_frontend_records", "k8s_edge_group"} {
+		raw, err := ioutil.ReadFile("./backend/fixtures/" + name + ".json")
+		if err != nil {
+			t.Errorf("Cannot read backend fixture %s: %#v", name, err)
+			continue
+		}
+		var services backend.ServiceRegistrations
+		if err := json.Unmarshal(raw, &services); err != nil {
+			t.Errorf("Cannot parse backend fixture %s: %#v", name, err)
+			continue
+		}
+		result, err := testService.renderConfig(services)
+		if err != nil {
+			t.Errorf("Test failed: %#v", err)
+		} else {
+			compareFixture(t, "./fixtures/"+name+".txt", result)
+		}
+	}
+}
+
+// compareFixture compares the given result with the content of the given fixture.
+// If UPDATE-FIXTURES=1, the fixture is updated instead.
+func compareFixture(t *testing.T, path, result string) {
+	if os.Getenv("UPDATE-FIXTURES") == "1" {
+		err := ioutil.WriteFile(path, []byte(result), 0644)
+		if err != nil {
+			t.Errorf("Cannot update fixture %s: %#v", path, err)
+		}
+		return
+	}
+	expectedRaw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Errorf("Cannot read fixture %s: %#v", path, err)
+		return
+	}
+	expected := strings.Split(string(expectedRaw), "\n")
+	lines := strings.Split(result, "\n")
+	for i, line := range lines {
+		if i >= len(expected) {
+			t.Errorf("Unexpected addition: `%s`", line)
+			break
+		} else if expected[i] != line {
+			t.Errorf("Diff at %d: expected `%s` got `%s`", i, expected[i], line)
+			break
 		}
 	}
 }
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+
+	api "github.com/pulcy/robin-api"
+	"github.com/pulcy/robin/service/backend"
+)
+
+const (
+	defaultFuzzSeed       = 1
+	defaultFuzzIterations = 500
+
+	safeAlphabet  = "abcdefghijklmnopqrstuvwxyz0123456789-_"
+	weirdAlphabet = safeAlphabet + "ABCXYZ./ #{}\\\"'%$;:*?[]()!@&=+,~é"
+
+	// Characters that must never appear in validated values
+	unsafeConfigCharacters = " \t\r\n\x00\x7f#{}\\\"'"
+)
+
+var (
+	// Fragments that are occasionally inserted in generated strings
+	injections = []string{
+		"\n",
+		"\r\n",
+		"\t",
+		"\x00",
+		"\x7f",
+		"\n    bind *:9999",
+		"\nbackend injected",
+		" if TRUE\n    use_backend injected",
+	}
+	sectionKeywords = map[string]struct{}{
+		"global":   struct{}{},
+		"defaults": struct{}{},
+		"frontend": struct{}{},
+		"backend":  struct{}{},
+		"userlist": struct{}{},
+	}
+)
+
+// TestRenderConfigProperties renders random service registrations with weird domains, paths & users
+// and checks that the result is either rejected or structurally safe.
+// Use FUZZ-SEED & FUZZ-ITERATIONS to change the generated input.
+// Set HAPROXY-CHECK=1 to also validate every rendered config with `haproxy -c`.
+func TestRenderConfigProperties(t *testing.T) {
+	seed := envInt("FUZZ-SEED", defaultFuzzSeed)
+	iterations := envInt("FUZZ-ITERATIONS", defaultFuzzIterations)
+	haproxyCheck := os.Getenv("HAPROXY-CHECK") == "1"
+	r := rand.New(rand.NewSource(int64(seed)))
+
+	rejected := 0
+	for i := 0; i < iterations; i++ {
+		services := randomServiceRegistrations(r)
+		s := testService
+		s.SslCertsFolder = "/certs/"
+		s.ForceSsl = r.Intn(2) == 0
+		config, err := s.renderConfig(services)
+		if err != nil {
+			rejected++
+			continue
+		}
+		if err := checkConfigStructure(config); err != nil {
+			t.Fatalf("Iteration %d (seed %d): %v\nServices: %#v", i, seed, err, services)
+		}
+		if haproxyCheck {
+			if err := checkConfigWithHaproxy(config); err != nil {
+				t.Fatalf("Iteration %d (seed %d): %v\nServices: %#v", i, seed, err, services)
+			}
+		}
+	}
+	t.Logf("%d of %d random configurations rejected", rejected, iterations)
+}
+
+// TestValidationProperties checks that random values accepted by the validation functions
+// never contain characters that have a special meaning in the haproxy configuration.
+func TestValidationProperties(t *testing.T) {
+	seed := envInt("FUZZ-SEED", defaultFuzzSeed)
+	iterations := envInt("FUZZ-ITERATIONS", defaultFuzzIterations)
+	r := rand.New(rand.NewSource(int64(seed)))
+	validators := map[string]func(string) error{
+		"domain": api.ValidateDomain,
+		"path":   api.ValidatePath,
+		"name":   api.ValidateName,
+	}
+	for i := 0; i < iterations; i++ {
+		for kind, validate := range validators {
+			value := randomString(r, weirdAlphabet, 24)
+			if kind == "path" {
+				value = "/" + value
+			}
+			if validate(value) == nil && strings.ContainsAny(value, unsafeConfigCharacters) {
+				t.Fatalf("Iteration %d (seed %d): unsafe %s %q accepted", i, seed, kind, value)
+			}
+		}
+	}
+}
+
+// checkConfigStructure verifies that every line of the given config is either empty,
+// a known section header or an indented option without control characters.
+func checkConfigStructure(config string) error {
+	for i, line := range strings.Split(config, "\n") {
+		if strings.ContainsAny(line, "\r\x00\x7f") {
+			return fmt.Errorf("line %d contains control characters: %q", i+1, line)
+		}
+		if line == "" || strings.HasPrefix(line, "    ") {
+			continue
+		}
+		fields := strings.Split(line, " ")
+		if _, ok := sectionKeywords[fields[0]]; !ok {
+			return fmt.Errorf("line %d is not a section header or option: %q", i+1, line)
+		}
+		if fields[0] != "global" && fields[0] != "defaults" && len(fields) != 2 {
+			return fmt.Errorf("line %d is a section header with an invalid name: %q", i+1, line)
+		}
+	}
+	return nil
+}
+
+// checkConfigWithHaproxy runs `haproxy -c` on the given config.
+func checkConfigWithHaproxy(config string) error {
+	f, err := ioutil.TempFile("", "haproxy-fuzz")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(config); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	if output, err := exec.Command("haproxy", "-c", "-f", f.Name()).CombinedOutput(); err != nil {
+		return fmt.Errorf("haproxy -c failed: %s\n%s", string(output), config)
+	}
+	return nil
+}
+
+func randomServiceRegistrations(r *rand.Rand) backend.ServiceRegistrations {
+	var result backend.ServiceRegistrations
+	for i := r.Intn(4) + 1; i > 0; i-- {
+		sr := backend.ServiceRegistration{
+			ServiceName: randomString(r, weirdAlphabet, 12) + "x",
+			ServicePort: r.Intn(65535) + 1,
+			Public:      r.Intn(2) == 0,
+			Mode:        "http",
+			Sticky:      r.Intn(4) == 0,
+			Backup:      r.Intn(8) == 0,
+		}
+		switch r.Intn(4) {
+		case 0:
+			sr.EdgePort = PublicHttpPort
+		case 1:
+			sr.EdgePort = PrivateHttpPort
+		case 2:
+			sr.EdgePort = PrivateTcpSslPort
+			sr.Mode = "tcp"
+		default:
+			sr.EdgePort = 1000 + r.Intn(1000)
+		}
+		if r.Intn(4) == 0 {
+			sr.HttpCheckPath = "/" + randomString(r, weirdAlphabet, 16)
+		}
+		for j := r.Intn(3) + 1; j > 0; j-- {
+			sr.Instances = append(sr.Instances, backend.ServiceInstance{
+				IP:   fmt.Sprintf("10.%d.%d.%d", r.Intn(256), r.Intn(256), r.Intn(256)),
+				Port: r.Intn(65535) + 1,
+			})
+		}
+		for j := r.Intn(3) + 1; j > 0; j-- {
+			sel := backend.ServiceSelector{
+				Weight: r.Intn(101),
+				Domain: randomString(r, weirdAlphabet, 24),
+			}
+			if r.Intn(2) == 0 {
+				sel.PathPrefix = "/" + randomString(r, weirdAlphabet, 16)
+			}
+			if r.Intn(4) == 0 {
+				sel.SslCertName = randomString(r, weirdAlphabet, 16)
+			}
+			for k := r.Intn(3); k > 0; k-- {
+				sel.Users = append(sel.Users, backend.User{
+					Name:         randomString(r, weirdAlphabet, 12),
+					PasswordHash: randomString(r, weirdAlphabet, 20),
+				})
+			}
+			if r.Intn(4) == 0 {
+				sel.RewriteRules = append(sel.RewriteRules, backend.RewriteRule{
+					PathPrefix:       "/" + randomString(r, weirdAlphabet, 8),
+					RemovePathPrefix: "/" + randomString(r, weirdAlphabet, 8),
+					Domain:           randomString(r, weirdAlphabet, 16),
+				})
+			}
+			sr.Selectors = append(sr.Selectors, sel)
+		}
+		result = append(result, sr.Normalize())
+	}
+	result.Sort()
+	return result
+}
+
+// randomString creates a random string from the given alphabet.
+// Strings from the weird alphabet sometimes contain a (control character) injection.
+func randomString(r *rand.Rand, alphabet string, maxLen int) string {
+	runes := []rune(alphabet)
+	n := r.Intn(maxLen + 1)
+	result := make([]rune, n)
+	for i := range result {
+		result[i] = runes[r.Intn(len(runes))]
+	}
+	if alphabet == weirdAlphabet && r.Intn(20) == 0 {
+		pos := r.Intn(n + 1)
+		return string(result[:pos]) + injections[r.Intn(len(injections))] + string(result[pos:])
+	}
+	return string(result)
+}
+
+func envInt(key string, defaultValue int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return defaultValue
+}
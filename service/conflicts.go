@@ -0,0 +1,93 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"strings"
+
+	"github.com/pulcy/robin/service/backend"
+)
+
+// RouteConflict describes a route that can never be reached, because
+// another route (to a different backend) with a higher precedence matches all of its requests.
+type RouteConflict struct {
+	Route      Route `json:"route"`
+	ShadowedBy Route `json:"shadowed-by"`
+}
+
+// detectConflicts returns all routes of the given services that are shadowed by another route.
+func (s *Service) detectConflicts(services backend.ServiceRegistrations) []RouteConflict {
+	conflicts := []RouteConflict{}
+	for _, f := range s.collectFrontends(services) {
+		pairs := createSelectorServicePairs(services, f)
+		for i, pair := range pairs {
+			for _, prev := range pairs[:i] {
+				if shadows(prev, pair, f.IsTCP()) {
+					conflicts = append(conflicts, RouteConflict{
+						Route:      newRoute(f, pair),
+						ShadowedBy: newRoute(f, prev),
+					})
+					break
+				}
+			}
+		}
+	}
+	return conflicts
+}
+
+// shadows returns true if all requests matched by selector b are also matched by selector a,
+// where a takes precedence and uses another backend.
+func shadows(a, b selectorServicePair, isTcp bool) bool {
+	if a.Service.ServiceName == b.Service.ServiceName && a.Service.ServicePort == b.Service.ServicePort {
+		return false // Same backend
+	}
+	sa, sb := a.Selector, b.Selector
+	if sa.HasConditions() {
+		// Selector only matches a subset of its domain & path prefix
+		return false
+	}
+	if sa.Domain == "" && sa.PathPrefix == "" && !isTcp {
+		// Selector without rules is never used in HTTP frontends
+		return false
+	}
+	if !strings.HasPrefix(sb.PathPrefix, sa.PathPrefix) {
+		return false
+	}
+	switch {
+	case sa.Domain == "":
+		return true
+	case sa.IsWildcard():
+		return sb.Domain != "" && strings.HasSuffix(strings.ToLower(sb.Domain), strings.ToLower(sa.DomainSuffix()))
+	default:
+		return strings.EqualFold(sa.Domain, sb.Domain)
+	}
+}
+
+// Conflicts returns the routes of the current configuration that can never be reached.
+func (s *Service) Conflicts() []RouteConflict {
+	conflicts, _ := s.lastConflicts.Load().([]RouteConflict)
+	return conflicts
+}
+
+// reportConflicts logs the given conflicts and updates the conflict metrics.
+func (s *Service) reportConflicts(conflicts []RouteConflict) {
+	routeConflicts.Reset()
+	for _, c := range conflicts {
+		s.Logger.Warningf("Route %s%s to %s (%s) is shadowed by route %s%s to %s",
+			c.Route.Domain, c.Route.PathPrefix, c.Route.Backend, c.Route.Frontend,
+			c.ShadowedBy.Domain, c.ShadowedBy.PathPrefix, c.ShadowedBy.Backend)
+		routeConflicts.WithLabelValues(c.Route.Frontend).Inc()
+	}
+}
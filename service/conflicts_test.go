@@ -0,0 +1,79 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/pulcy/robin/service/backend"
+)
+
+func newConflictTestService(name string, selectors ...backend.ServiceSelector) backend.ServiceRegistration {
+	return backend.ServiceRegistration{
+		ServiceName: name,
+		ServicePort: 80,
+		EdgePort:    PublicHttpPort,
+		Public:      true,
+		Instances: backend.ServiceInstances{
+			backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+		},
+		Selectors: selectors,
+		Mode:      "http",
+	}
+}
+
+func TestDetectConflicts(t *testing.T) {
+	tests := []struct {
+		Services  backend.ServiceRegistrations
+		Conflicts []string // Backend of shadowed route
+	}{
+		{
+			// Longest prefix wins, no conflicts
+			Services: backend.ServiceRegistrations{
+				newConflictTestService("web", backend.ServiceSelector{Domain: "foo.com", PathPrefix: "/"}),
+				newConflictTestService("api", backend.ServiceSelector{Domain: "foo.com", PathPrefix: "/api"}),
+			},
+		},
+		{
+			// Weight overrides longest prefix
+			Services: backend.ServiceRegistrations{
+				newConflictTestService("web", backend.ServiceSelector{Domain: "foo.com", PathPrefix: "/", Weight: 10}),
+				newConflictTestService("api", backend.ServiceSelector{Domain: "foo.com", PathPrefix: "/api"}),
+			},
+			Conflicts: []string{"backend_api_80_public_http_in_80"},
+		},
+		{
+			// Same domain & prefix
+			Services: backend.ServiceRegistrations{
+				newConflictTestService("a", backend.ServiceSelector{Domain: "foo.com"}),
+				newConflictTestService("b", backend.ServiceSelector{Domain: "foo.com"}),
+			},
+			Conflicts: []string{"backend_b_80_public_http_in_80"},
+		},
+		{
+			// Wildcard with higher weight
+			Services: backend.ServiceRegistrations{
+				newConflictTestService("a", backend.ServiceSelector{Domain: "*.foo.com", Weight: 10}),
+				newConflictTestService("b", backend.ServiceSelector{Domain: "www.foo.com"}),
+				newConflictTestService("c", backend.ServiceSelector{Domain: "www.bar.com"}),
+			},
+			Conflicts: []string{"backend_b_80_public_http_in_80"},
+		},
+		{
+			// Selectors of the same service never conflict
+			Services: backend.ServiceRegistrations{
+				newConflictTestService("a", backend.ServiceSelector{Domain: "foo.com"}, backend.ServiceSelector{Domain: "foo.com", PathPrefix: "/x"}),
+			},
+		},
+	}
+	for i, test := range tests {
+		conflicts := testService.detectConflicts(test.Services)
+		if len(conflicts) != len(test.Conflicts) {
+			t.Errorf("Test %d: expected %d conflicts, got %#v", i, len(test.Conflicts), conflicts)
+			continue
+		}
+		for j, c := range conflicts {
+			if c.Route.Backend != test.Conflicts[j] {
+				t.Errorf("Test %d: expected conflict for %s, got %s", i, test.Conflicts[j], c.Route.Backend)
+			}
+		}
+	}
+}
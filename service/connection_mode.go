@@ -0,0 +1,73 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	api "github.com/pulcy/robin-api"
+
+	"github.com/pulcy/robin/service/backend"
+)
+
+const (
+	defaultConnectionModeOption = "option http-server-close"
+)
+
+// usesConnectionModes returns true if any of the given services uses a connection mode other than the default.
+// HAProxy uses the most restrictive mode of a frontend & backend, so then the defaults are set to keep-alive
+// and all other backends set the default mode explicitly.
+func usesConnectionModes(services backend.ServiceRegistrations) bool {
+	for _, sr := range services {
+		if sr.ConnectionMode != "" && sr.ConnectionMode != api.ConnectionModeServerClose {
+			return true
+		}
+	}
+	return false
+}
+
+// createDefaultsOptions returns the options of the defaults section.
+func createDefaultsOptions(connectionModes bool) []string {
+	if !connectionModes {
+		return defaultsOptions
+	}
+	result := make([]string, 0, len(defaultsOptions))
+	for _, option := range defaultsOptions {
+		if option == defaultConnectionModeOption {
+			option = "option http-keep-alive"
+		}
+		result = append(result, option)
+	}
+	return result
+}
+
+// createConnectionModeOption returns the option of a backend that sets the given connection mode.
+// It returns an empty string if the defaults apply.
+func (s *Service) createConnectionModeOption(mode string, connectionModes bool) string {
+	switch mode {
+	case api.ConnectionModeKeepAlive:
+		return "option http-keep-alive"
+	case api.ConnectionModeClose:
+		return "option httpclose"
+	case api.ConnectionModeTunnel:
+		if s.HaproxyVersion.AtLeast(2, 1) {
+			// Tunnel mode has been removed in HAProxy 2.1, keep-alive comes closest
+			return "option http-keep-alive"
+		}
+		return "option http-tunnel"
+	}
+	if connectionModes {
+		return defaultConnectionModeOption
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	tcpStateEstablished = "01"
+)
+
+// processConnections returns the remote addresses (ip:port) of all established
+// TCP connections owned by the process with given pid.
+func processConnections(pid int) ([]string, error) {
+	procDir := filepath.Join("/proc", strconv.Itoa(pid))
+	fds, err := ioutil.ReadDir(filepath.Join(procDir, "fd"))
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	inodes := make(map[string]struct{})
+	for _, fd := range fds {
+		link, err := os.Readlink(filepath.Join(procDir, "fd", fd.Name()))
+		if err != nil {
+			// File descriptor closed in the mean time
+			continue
+		}
+		if strings.HasPrefix(link, "socket:[") {
+			inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] = struct{}{}
+		}
+	}
+	result := []string{}
+	for _, name := range []string{"tcp", "tcp6"} {
+		addrs, err := readEstablishedConnections(filepath.Join(procDir, "net", name), inodes)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		result = append(result, addrs...)
+	}
+	return result, nil
+}
+
+// readEstablishedConnections returns the remote addresses of the established connections
+// listed in the given /proc/net/tcp[6] file that belong to one of the given socket inodes.
+func readEstablishedConnections(path string, inodes map[string]struct{}) ([]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, maskAny(err)
+	}
+	defer f.Close()
+
+	result := []string{}
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // Skip header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != tcpStateEstablished {
+			continue
+		}
+		if _, ok := inodes[fields[9]]; !ok {
+			continue
+		}
+		addr, err := parseProcNetAddress(fields[2])
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		result = append(result, addr)
+	}
+	return result, maskAny(scanner.Err())
+}
+
+// parseProcNetAddress converts an address in /proc/net/tcp[6] format (hex ip:port) into ip:port.
+func parseProcNetAddress(s string) (string, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return "", maskAny(fmt.Errorf("Invalid address '%s'", s))
+	}
+	raw, err := hex.DecodeString(parts[0])
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", maskAny(fmt.Errorf("Invalid address '%s'", s))
+	}
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return "", maskAny(err)
+	}
+	// The address is stored as 32-bit words in host (little endian) byte order
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		ip[i], ip[i+1], ip[i+2], ip[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port))), nil
+}
@@ -0,0 +1,25 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestParseProcNetAddress(t *testing.T) {
+	tests := map[string]string{
+		"0100007F:1F90":                         "127.0.0.1:8080",
+		"0223A8C0:0929":                         "192.168.35.2:2345",
+		"0000000000000000FFFF00000223A8C0:0050": "192.168.35.2:80",
+		"00000000000000000000000001000000:01BB": "[::1]:443",
+	}
+	for input, expected := range tests {
+		result, err := parseProcNetAddress(input)
+		if err != nil {
+			t.Errorf("Parse of '%s' failed: %#v", input, err)
+		} else if result != expected {
+			t.Errorf("Parse of '%s' returned '%s', expected '%s'", input, result, expected)
+		}
+	}
+	if _, err := parseProcNetAddress("foo"); err == nil {
+		t.Errorf("Expected error for invalid address")
+	}
+}
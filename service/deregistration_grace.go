@@ -0,0 +1,148 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pulcy/robin/service/backend"
+)
+
+// departedInstance is an instance that has left the backend, but is kept in the configuration
+// (in drain state) until its deregistration grace period has passed.
+type departedInstance struct {
+	Instance backend.ServiceInstance
+	Until    time.Time
+}
+
+// addDepartedInstances adds the instances that have left the backend less than DeregistrationGrace ago
+// to the given services, marked as draining.
+// Services that have been removed entirely are kept (with all their instances draining) as well.
+// An update is scheduled for the moment the first grace period ends.
+func (s *Service) addDepartedInstances(services backend.ServiceRegistrations, now time.Time) backend.ServiceRegistrations {
+	if s.DeregistrationGrace <= 0 {
+		return services
+	}
+	previous, _ := s.lastServices.Load().(backend.ServiceRegistrations)
+	prevMap := historyServiceMap(previous)
+	departed := make(map[string]map[string]departedInstance)
+	seen := make(map[string]struct{})
+	for i, sr := range services {
+		key := registrationKey(sr)
+		seen[key] = struct{}{}
+		entries := s.departedEntries(sr, prevMap[key].Instances, now)
+		if len(entries) == 0 {
+			continue
+		}
+		departed[key] = entries
+		services[i].Instances = appendDeparted(sr.Instances, entries)
+	}
+	// Services of which the last instance has left
+	removed := false
+	for _, prev := range previous {
+		key := registrationKey(prev)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		sr := prev
+		sr.Instances = nil
+		entries := s.departedEntries(sr, prev.Instances, now)
+		if len(entries) == 0 {
+			continue
+		}
+		departed[key] = entries
+		sr.Instances = appendDeparted(nil, entries)
+		services = append(services, sr)
+		removed = true
+	}
+	if removed {
+		services.Sort()
+	}
+	s.departed = departed
+	s.scheduleDepartedUpdate(now)
+	return services
+}
+
+// departedEntries returns the instances of the given registration that are draining, given the
+// instances of the registration at the previous update.
+func (s *Service) departedEntries(sr backend.ServiceRegistration, prevInstances backend.ServiceInstances, now time.Time) map[string]departedInstance {
+	key := registrationKey(sr)
+	current := make(map[string]struct{})
+	for _, si := range sr.Instances {
+		current[instanceAddress(si)] = struct{}{}
+	}
+	// Instances that were still draining at the previous update
+	entries := make(map[string]departedInstance)
+	for addr, d := range s.departed[key] {
+		if _, ok := current[addr]; !ok && now.Before(d.Until) {
+			entries[addr] = d
+		}
+	}
+	// Instances that have left since the previous update
+	for _, si := range prevInstances {
+		addr := instanceAddress(si)
+		if _, ok := current[addr]; ok || si.Draining {
+			continue
+		}
+		s.Logger.Infof("Instance %s of %s has left, draining it for %s", addr, sr.ServiceName, s.DeregistrationGrace)
+		entries[addr] = departedInstance{Instance: si, Until: now.Add(s.DeregistrationGrace)}
+	}
+	return entries
+}
+
+// appendDeparted returns a copy of the given instances with the given departed instances added (draining).
+func appendDeparted(instances backend.ServiceInstances, entries map[string]departedInstance) backend.ServiceInstances {
+	result := append(backend.ServiceInstances{}, instances...)
+	for _, addr := range sortedDepartedAddresses(entries) {
+		si := entries[addr].Instance
+		si.Draining = true
+		result = append(result, si)
+	}
+	return result
+}
+
+// scheduleDepartedUpdate triggers an update when the first grace period of the departed instances ends,
+// so they are removed from the configuration without waiting for another change.
+func (s *Service) scheduleDepartedUpdate(now time.Time) {
+	if s.departedTimer != nil {
+		s.departedTimer.Stop()
+		s.departedTimer = nil
+	}
+	var first time.Time
+	for _, entries := range s.departed {
+		for _, d := range entries {
+			if first.IsZero() || d.Until.Before(first) {
+				first = d.Until
+			}
+		}
+	}
+	if first.IsZero() {
+		return
+	}
+	s.departedTimer = time.AfterFunc(first.Sub(now), s.TriggerUpdate)
+}
+
+func instanceAddress(si backend.ServiceInstance) string {
+	return fmt.Sprintf("%s:%d", si.IP, si.Port)
+}
+
+func sortedDepartedAddresses(entries map[string]departedInstance) []string {
+	set := make(map[string]struct{})
+	for addr := range entries {
+		set[addr] = struct{}{}
+	}
+	return sortedKeys(set)
+}
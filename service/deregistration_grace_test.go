@@ -0,0 +1,72 @@
+package service
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	logging "github.com/op/go-logging"
+
+	"github.com/pulcy/robin/service/backend"
+)
+
+func TestAddDepartedInstances(t *testing.T) {
+	s := &Service{
+		ServiceConfig:       ServiceConfig{DeregistrationGrace: time.Minute},
+		ServiceDependencies: ServiceDependencies{Logger: logging.MustGetLogger("test")},
+	}
+	web := func(ips ...string) backend.ServiceRegistrations {
+		sr := backend.ServiceRegistration{ServiceName: "web", ServicePort: 80, EdgePort: 80, Public: true}
+		for _, ip := range ips {
+			sr.Instances = append(sr.Instances, backend.ServiceInstance{IP: ip, Port: 2345})
+		}
+		return backend.ServiceRegistrations{sr}
+	}
+	update := func(services backend.ServiceRegistrations, now time.Time) []string {
+		services = s.addDepartedInstances(services, now)
+		s.lastServices.Store(services)
+		result := []string{}
+		for _, si := range services[0].Instances {
+			result = append(result, si.FullString())
+		}
+		return result
+	}
+
+	now := time.Now()
+	update(web("10.0.0.1", "10.0.0.2"), now)
+	if result := update(web("10.0.0.1"), now.Add(time.Second)); len(result) != 2 || result[1] != "10.0.0.2-2345-draining" {
+		t.Errorf("Expected departed instance to be draining, got %v", result)
+	}
+	if result := update(web("10.0.0.1"), now.Add(time.Second*30)); len(result) != 2 {
+		t.Errorf("Expected departed instance to be kept during grace period, got %v", result)
+	}
+	if result := update(web("10.0.0.1"), now.Add(time.Minute*2)); len(result) != 1 {
+		t.Errorf("Expected departed instance to be removed after grace period, got %v", result)
+	}
+
+	// Services of which the last instance has left are kept as well
+	if result := update(backend.ServiceRegistrations{}, now.Add(time.Minute*3)); len(result) != 1 || result[0] != "10.0.0.1-2345-draining" {
+		t.Errorf("Expected removed service to be draining, got %v", result)
+	}
+	if services := s.addDepartedInstances(backend.ServiceRegistrations{}, now.Add(time.Minute*5)); len(services) != 0 {
+		t.Errorf("Expected removed service to be dropped after grace period, got %v", services)
+	}
+}
+
+func TestDepartedInstancesTriggerUpdate(t *testing.T) {
+	s := &Service{
+		ServiceConfig:       ServiceConfig{DeregistrationGrace: time.Millisecond * 50},
+		ServiceDependencies: ServiceDependencies{Logger: logging.MustGetLogger("test")},
+	}
+	sr := backend.ServiceRegistration{ServiceName: "web", ServicePort: 80, EdgePort: 80, Public: true}
+	sr.Instances = backend.ServiceInstances{backend.ServiceInstance{IP: "10.0.0.1", Port: 2345}}
+	s.lastServices.Store(backend.ServiceRegistrations{sr})
+	s.addDepartedInstances(backend.ServiceRegistrations{}, time.Now())
+	if counter := atomic.LoadUint32(&s.changeCounter); counter != 0 {
+		t.Fatalf("Expected no update yet, got %d", counter)
+	}
+	time.Sleep(time.Millisecond * 200)
+	if counter := atomic.LoadUint32(&s.changeCounter); counter != 1 {
+		t.Errorf("Expected an update after the grace period, got %d", counter)
+	}
+}
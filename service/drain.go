@@ -0,0 +1,175 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/pulcy/robin/haproxy"
+	"github.com/pulcy/robin/service/backend"
+	"github.com/pulcy/robin/service/prober"
+)
+
+const (
+	drainPollInterval = time.Second
+)
+
+// DrainStatus is the result of a drain request.
+type DrainStatus struct {
+	Draining       bool `json:"draining"`
+	ActiveSessions int  `json:"active-sessions"`
+	Completed      bool `json:"completed"` // Set when all sessions have finished
+}
+
+// Drainer coordinates a soft shutdown of the edge node.
+type Drainer interface {
+	// Drain puts all servers in drain state and waits (up to the given timeout)
+	// for the active sessions to finish.
+	Drain(timeout time.Duration) (DrainStatus, error)
+	// Undrain puts all servers back in ready state.
+	Undrain() error
+	// IsDraining returns true when the node is draining.
+	IsDraining() bool
+}
+
+// serverRef identifies a server in the generated configuration.
+type serverRef struct {
+	Backend string
+	Server  string
+	Address string // ip:port of the instance
+}
+
+// createServerRefs returns all servers of the generated configuration.
+func (s *Service) createServerRefs(services backend.ServiceRegistrations) []serverRef {
+	services = s.addGatewaySelectors(services)
+	result := []serverRef{}
+	seen := make(map[serverRef]struct{})
+	backends := make(map[string]backendConfig)
+	for _, f := range s.collectFrontends(services) {
+		for _, pair := range createSelectorServicePairs(services, f) {
+			backendName := generateBackendName(pair.Service, f)
+			b := backends[backendName]
+			b.Name = backendName
+			if !b.Services.Contains(pair.Service) {
+				b.Services = append(b.Services, pair.Service)
+			}
+			backends[backendName] = b
+			for i, instance := range pair.Service.Instances {
+				ref := serverRef{
+					Backend: backendName,
+					Server:  serverID(i, instance),
+					Address: net.JoinHostPort(instance.IP, strconv.Itoa(instance.Port)),
+				}
+				if _, ok := seen[ref]; ok {
+					continue
+				}
+				seen[ref] = struct{}{}
+				result = append(result, ref)
+			}
+		}
+	}
+	// The servers of backends with backup servers are also part of their promoted backend
+	n := len(result)
+	for _, ref := range result[:n] {
+		if backends[ref.Backend].MinActive() > 0 {
+			ref.Backend = promotedBackendName(ref.Backend)
+			result = append(result, ref)
+		}
+	}
+	return result
+}
+
+// IsDraining returns true when the node is draining.
+func (s *Service) IsDraining() bool {
+	return atomic.LoadUint32(&s.draining) != 0
+}
+
+// Drain puts all servers in drain state and waits (up to the given timeout)
+// for the active sessions to finish.
+func (s *Service) Drain(timeout time.Duration) (DrainStatus, error) {
+	client, err := s.runtimeClient()
+	if err != nil {
+		return DrainStatus{}, maskAny(err)
+	}
+	if atomic.CompareAndSwapUint32(&s.draining, 0, 1) {
+		s.Logger.Infof("Draining all servers")
+	}
+	// Stop the prober, it would otherwise put servers back in ready state
+	if s.Prober != nil {
+		s.Prober.SetTargets(nil)
+	}
+	if err := s.setDrainState(client, true); err != nil {
+		return DrainStatus{Draining: true}, maskAny(err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		sessions, err := client.CurrentSessions()
+		if err != nil {
+			return DrainStatus{Draining: true}, maskAny(err)
+		}
+		status := DrainStatus{
+			Draining:       true,
+			ActiveSessions: sessions,
+			Completed:      sessions == 0,
+		}
+		if status.Completed || !time.Now().Before(deadline) || !s.IsDraining() {
+			return status, nil
+		}
+		time.Sleep(drainPollInterval)
+	}
+}
+
+// Undrain puts all servers back in ready state.
+func (s *Service) Undrain() error {
+	client, err := s.runtimeClient()
+	if err != nil {
+		return maskAny(err)
+	}
+	if !atomic.CompareAndSwapUint32(&s.draining, 1, 0) {
+		return nil
+	}
+	s.Logger.Infof("Undraining all servers")
+	if err := s.setDrainState(client, false); err != nil {
+		return maskAny(err)
+	}
+	if s.Prober != nil {
+		targets, _ := s.lastProbeTargets.Load().([]prober.Target)
+		s.Prober.SetTargets(targets)
+	}
+	return nil
+}
+
+// setDrainState puts all servers of the current configuration in drain or ready state.
+func (s *Service) setDrainState(client haproxy.RuntimeClient, drain bool) error {
+	refs, _ := s.lastServerRefs.Load().([]serverRef)
+	for _, ref := range refs {
+		if err := client.SetServerDrain(ref.Backend, ref.Server, drain); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+func (s *Service) runtimeClient() (haproxy.RuntimeClient, error) {
+	if s.RuntimeSocketPath == "" {
+		return haproxy.RuntimeClient{}, maskAny(fmt.Errorf("HAProxy runtime socket is not configured"))
+	}
+	return haproxy.RuntimeClient{SocketPath: s.RuntimeSocketPath}, nil
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/op/go-logging"
+
+	"github.com/pulcy/robin/service/backend"
+)
+
+var (
+	backupPromotionService = backend.ServiceRegistration{
+		ServiceName:   "web",
+		ServicePort:   80,
+		EdgePort:      PublicHttpPort,
+		Public:        true,
+		HttpCheckPath: "/health",
+		MinActive:     2,
+		Instances: backend.ServiceInstances{
+			backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+			backend.ServiceInstance{IP: "192.168.35.3", Port: 2345, Backup: true},
+		},
+		Selectors: backend.ServiceSelectors{
+			backend.ServiceSelector{Domain: "foo.com"},
+		},
+		Mode: "http",
+	}
+)
+
+func TestServerRefs(t *testing.T) {
+	services := backend.ServiceRegistrations{backupPromotionService}
+	config, err := runtimeSocketService.renderConfig(services)
+	if err != nil {
+		t.Fatalf("Test failed: %#v", err)
+	}
+	refs := runtimeSocketService.createServerRefs(services)
+	if len(refs) != 4 {
+		t.Fatalf("Expected 4 server refs, got %#v", refs)
+	}
+	promoted := 0
+	for _, ref := range refs {
+		if !strings.Contains(config, "backend "+ref.Backend+"\n") {
+			t.Errorf("Backend '%s' not found in config", ref.Backend)
+		}
+		if !strings.Contains(config, "server "+ref.Server+" "+ref.Address+" ") {
+			t.Errorf("Server '%s' not found in config", ref.Server)
+		}
+		if strings.HasSuffix(ref.Backend, "_promoted") {
+			promoted++
+		}
+	}
+	if promoted != 2 {
+		t.Errorf("Expected 2 servers in promoted backend, got %d", promoted)
+	}
+}
+
+// fakeRuntimeSocket serves a minimal HAProxy runtime API that records all `set server` commands
+// and reports the given number of active sessions.
+func fakeRuntimeSocket(t *testing.T, sessions string) (string, func() []string, func()) {
+	dir, err := ioutil.TempDir("", "drain")
+	if err != nil {
+		t.Fatalf("TempDir failed: %#v", err)
+	}
+	socketPath := filepath.Join(dir, "haproxy.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Listen failed: %#v", err)
+	}
+	var mutex sync.Mutex
+	var commands []string
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			command, _ := bufio.NewReader(conn).ReadString('\n')
+			command = strings.TrimSpace(command)
+			if command == "show stat" {
+				row := make([]string, 33)
+				row[0], row[1], row[4], row[32] = "public_http_in_80", "FRONTEND", sessions, "0"
+				conn.Write([]byte("# pxname,svname\n" + strings.Join(row, ",") + "\n"))
+			} else {
+				mutex.Lock()
+				commands = append(commands, command)
+				mutex.Unlock()
+				conn.Write([]byte("\n"))
+			}
+			conn.Close()
+		}
+	}()
+	recorded := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		result := commands
+		commands = nil
+		return result
+	}
+	return socketPath, recorded, func() {
+		listener.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDrainUndrain(t *testing.T) {
+	socketPath, recorded, cleanup := fakeRuntimeSocket(t, "0")
+	defer cleanup()
+	s := &Service{
+		ServiceConfig:       ServiceConfig{RuntimeSocketPath: socketPath},
+		ServiceDependencies: ServiceDependencies{Logger: logging.MustGetLogger("test")},
+	}
+	s.lastServerRefs.Store(runtimeSocketService.createServerRefs(backend.ServiceRegistrations{backupPromotionService}))
+
+	status, err := s.Drain(time.Second)
+	if err != nil {
+		t.Fatalf("Drain failed: %#v", err)
+	}
+	if !status.Draining || !status.Completed || !s.IsDraining() {
+		t.Errorf("Expected completed drain, got %#v", status)
+	}
+	commands := recorded()
+	if len(commands) != 4 {
+		t.Fatalf("Expected 4 commands, got %#v", commands)
+	}
+	for _, command := range commands {
+		if !strings.HasSuffix(command, " state drain") {
+			t.Errorf("Expected drain command, got '%s'", command)
+		}
+	}
+	if !strings.Contains(strings.Join(commands, "\n"), "_promoted/") {
+		t.Errorf("Expected servers of promoted backend to be drained, got %#v", commands)
+	}
+
+	if err := s.Undrain(); err != nil {
+		t.Fatalf("Undrain failed: %#v", err)
+	}
+	if s.IsDraining() {
+		t.Errorf("Expected undrained service")
+	}
+	commands = recorded()
+	if len(commands) != 4 {
+		t.Fatalf("Expected 4 commands, got %#v", commands)
+	}
+	for _, command := range commands {
+		if !strings.HasSuffix(command, " state ready") {
+			t.Errorf("Expected ready command, got '%s'", command)
+		}
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	socketPath, _, cleanup := fakeRuntimeSocket(t, "3")
+	defer cleanup()
+	s := &Service{
+		ServiceConfig:       ServiceConfig{RuntimeSocketPath: socketPath},
+		ServiceDependencies: ServiceDependencies{Logger: logging.MustGetLogger("test")},
+	}
+	status, err := s.Drain(0)
+	if err != nil {
+		t.Fatalf("Drain failed: %#v", err)
+	}
+	if !status.Draining || status.Completed || status.ActiveSessions != 3 {
+		t.Errorf("Expected incomplete drain with 3 sessions, got %#v", status)
+	}
+}
@@ -19,5 +19,10 @@ import (
 )
 
 var (
-	maskAny = errgo.MaskFunc(errgo.Any)
+	ConfigLimitExceededError = errgo.New("config limit exceeded")
+	maskAny                  = errgo.MaskFunc(errgo.Any)
 )
+
+func IsConfigLimitExceeded(err error) bool {
+	return errgo.Cause(err) == ConfigLimitExceededError
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+
+	"github.com/pulcy/robin/haproxy"
+	"github.com/pulcy/robin/service/backend"
+)
+
+const (
+	// DefaultExternalCAFile is the file containing the CA certificates that certificates of external hosts
+	// are verified against on HAProxy versions before 2.6.
+	DefaultExternalCAFile = "/etc/ssl/certs/ca-certificates.crt"
+
+	externalResolversName = "external"
+)
+
+// hasExternalResolvers returns true if HAProxy can resolve external hosts at runtime.
+// Without configured nameservers, that requires HAProxy 1.9 (parse-resolv-conf). Otherwise external hosts
+// are only resolved when HAProxy starts.
+func (s *Service) hasExternalResolvers() bool {
+	return len(s.ExternalResolvers) > 0 || s.HaproxyVersion.AtLeast(1, 9)
+}
+
+// createExternalResolvers creates the resolvers section used to resolve the external hosts of the given services (if any).
+func (s *Service) createExternalResolvers(c *haproxy.Config, services backend.ServiceRegistrations) {
+	if !s.hasExternalResolvers() {
+		return
+	}
+	found := false
+	for _, sr := range services {
+		if sr.ExternalHost != "" {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return
+	}
+	section := c.Section("resolvers " + externalResolversName)
+	if len(s.ExternalResolvers) == 0 {
+		section.Add("parse-resolv-conf")
+	}
+	for i, address := range s.ExternalResolvers {
+		section.Add(fmt.Sprintf("nameserver ns%d %s", i, address))
+	}
+	section.Add("hold valid 10s")
+}
+
+// externalServerOptions creates the server options used to connect to the external host of the given registration.
+// The host is resolved at runtime, so changes of its addresses are picked up without a reload.
+// When connecting using SSL, the certificate of the host is always verified.
+func (s *Service) externalServerOptions(sr backend.ServiceRegistration) string {
+	options := ""
+	if s.hasExternalResolvers() {
+		options = fmt.Sprintf("resolvers %s resolve-prefer ipv4", externalResolversName)
+		if s.HaproxyVersion.AtLeast(1, 7) {
+			options = options + " init-addr last,libc,none"
+		}
+	}
+	if sr.ExternalSsl {
+		caFile := s.ExternalCAFile
+		if caFile == "" {
+			if s.HaproxyVersion.AtLeast(2, 6) {
+				caFile = "@system-ca"
+			} else {
+				caFile = DefaultExternalCAFile
+			}
+		}
+		ssl := fmt.Sprintf("ssl verify required ca-file %s sni str(%s) verifyhost %s", caFile, sr.ExternalHost, sr.ExternalHost)
+		if options == "" {
+			options = ssl
+		} else {
+			options = options + " " + ssl
+		}
+	}
+	return options
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pulcy/robin/service/backend"
+)
+
+const (
+	// GatewayPathPrefix is the path prefix under which the private gateway serves all services.
+	GatewayPathPrefix = "/svc/"
+)
+
+// gatewayPathPrefix returns the path prefix under which the private gateway serves the service with given name.
+func gatewayPathPrefix(serviceName string) string {
+	return GatewayPathPrefix + serviceName + "/"
+}
+
+// addGatewaySelectors returns a sorted copy of the given (sorted) services in which every HTTP service
+// is also served on the private HTTP frontend under `/svc/<name>/`, with that prefix removed.
+// If a service already has a private HTTP registration, the selector is added to it,
+// otherwise a private registration is derived from the first HTTP registration of the service.
+// The gateway selector gets the access restrictions (users, auth agent & allowed sources) of the service.
+// Services whose selectors have different restrictions and the ACME challenge service are not served
+// by the gateway.
+func (s *Service) addGatewaySelectors(services backend.ServiceRegistrations) backend.ServiceRegistrations {
+	if !s.PrivateGateway {
+		return services
+	}
+	// Select a registration for each service
+	selected := make(map[string]int)
+	restrictions := make(map[string]backend.ServiceSelector)
+	skip := make(map[string]struct{})
+	var names []string
+	for i, sr := range services {
+		if !sr.IsHttp() {
+			continue
+		}
+		if isAcmeChallengeRegistration(sr) {
+			skip[sr.ServiceName] = struct{}{}
+		}
+		current, found := selected[sr.ServiceName]
+		if !found {
+			names = append(names, sr.ServiceName)
+			selected[sr.ServiceName] = i
+		} else if isPrivateHttpRegistration(sr) && !isPrivateHttpRegistration(services[current]) {
+			selected[sr.ServiceName] = i
+		}
+		for _, sel := range sr.Selectors {
+			r := accessRestrictions(sel)
+			if first, found := restrictions[sr.ServiceName]; !found {
+				restrictions[sr.ServiceName] = r
+			} else if accessRestrictionsKey(first) != accessRestrictionsKey(r) {
+				// Cannot decide which restrictions apply to the gateway
+				skip[sr.ServiceName] = struct{}{}
+			}
+		}
+	}
+
+	result := append(backend.ServiceRegistrations{}, services...)
+	for _, name := range names {
+		if _, found := skip[name]; found {
+			continue
+		}
+		i := selected[name]
+		sr := result[i]
+		prefix := gatewayPathPrefix(name)
+		sel := restrictions[name]
+		sel.PathPrefix = prefix
+		sel.RewriteRules = []backend.RewriteRule{{RemovePathPrefix: prefix}}
+		if isPrivateHttpRegistration(sr) {
+			sr.Selectors = append(append(backend.ServiceSelectors{}, sr.Selectors...), sel)
+			result[i] = sr
+		} else {
+			sr.EdgePort = PrivateHttpPort
+			sr.Public = false
+			sr.Selectors = backend.ServiceSelectors{sel}
+			result = append(result, sr)
+		}
+	}
+	result.Sort()
+	return result
+}
+
+// accessRestrictions returns a selector containing only the access restrictions of the given selector.
+func accessRestrictions(sel backend.ServiceSelector) backend.ServiceSelector {
+	return backend.ServiceSelector{
+		Users:             sel.Users,
+		AllowUnauthorized: sel.AllowUnauthorized,
+		AuthAgent:         sel.AuthAgent,
+		AllowedSources:    sel.AllowedSources,
+	}
+}
+
+// accessRestrictionsKey returns a string that is equal for selectors with equal access restrictions.
+func accessRestrictionsKey(sel backend.ServiceSelector) string {
+	users := append(backend.Users{}, sel.Users...)
+	sort.Sort(users)
+	sources := append([]string{}, sel.AllowedSources...)
+	sort.Strings(sources)
+	return fmt.Sprintf("%#v-%v-%s-%v", users, sel.AllowUnauthorized && len(sel.Users) > 0, sel.AuthAgent, sources)
+}
+
+// isAcmeChallengeRegistration returns true if the given registration serves ACME HTTP challenges.
+func isAcmeChallengeRegistration(sr backend.ServiceRegistration) bool {
+	for _, sel := range sr.Selectors {
+		if strings.HasPrefix(sel.PathPrefix, acmeChallengePathPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrivateHttpRegistration returns true if the given registration is served on the private HTTP frontend.
+func isPrivateHttpRegistration(sr backend.ServiceRegistration) bool {
+	return !sr.Public && sr.EdgePort == PrivateHttpPort && sr.IsHttp()
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/pulcy/robin/service/backend"
+)
+
+func TestGatewayDerivedBackends(t *testing.T) {
+	services := backend.ServiceRegistrations{
+		backend.ServiceRegistration{
+			ServiceName: "web",
+			ServicePort: 80,
+			EdgePort:    PublicHttpPort,
+			Public:      true,
+			Instances: backend.ServiceInstances{
+				backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+			},
+			Selectors: backend.ServiceSelectors{
+				backend.ServiceSelector{Domain: "foo.com"},
+			},
+			Mode: "http",
+		},
+	}
+	gatewayBackend := "backend_web_80_private_http_in_81"
+
+	found := false
+	for _, route := range privateGatewayService.createRoutes(services) {
+		if route.Backend == gatewayBackend && route.PathPrefix == "/svc/web/" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected a route to '%s'", gatewayBackend)
+	}
+
+	found = false
+	for _, ref := range privateGatewayService.createServerRefs(services) {
+		if ref.Backend == gatewayBackend && ref.Address == "192.168.35.2:2345" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected a server ref in '%s'", gatewayBackend)
+	}
+
+	if name := privateGatewayService.createBackendServices(services)[gatewayBackend]; name != "web" {
+		t.Errorf("Expected '%s' to be accounted to 'web', got '%s'", gatewayBackend, name)
+	}
+}
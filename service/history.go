@@ -0,0 +1,199 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pulcy/robin/service/backend"
+)
+
+const (
+	DefaultHistorySize = 1000 // Number of routing changes kept by default
+
+	HistoryServiceAdded     = "service-added"
+	HistoryServiceRemoved   = "service-removed"
+	HistoryInstanceJoined   = "instance-joined"
+	HistoryInstanceLeft     = "instance-left"
+	HistorySelectorsChanged = "selectors-changed"
+)
+
+// HistoryEvent is a single change of the routing model.
+type HistoryEvent struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	Service     string    `json:"service"`
+	ServicePort int       `json:"service-port"`
+	EdgePort    int       `json:"edge-port"`
+	Public      bool      `json:"public"`
+	Instance    string    `json:"instance,omitempty"`  // IP:port of the instance that joined or left
+	Selectors   []string  `json:"selectors,omitempty"` // Routes (domain & path prefix) of the service after the change
+}
+
+// HistoryInspector provides the recent changes of the routing model.
+type HistoryInspector interface {
+	// History returns the recorded changes since the given time (oldest first).
+	// If service is not empty, only changes of that service are returned.
+	History(since time.Time, service string) []HistoryEvent
+}
+
+// History returns the recorded changes since the given time (oldest first).
+func (s *Service) History(since time.Time, service string) []HistoryEvent {
+	return s.history.Events(since, service)
+}
+
+// historyBuffer holds the most recent routing changes.
+type historyBuffer struct {
+	mutex  sync.Mutex
+	size   int
+	events []HistoryEvent
+}
+
+func newHistoryBuffer(size int) *historyBuffer {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	return &historyBuffer{size: size}
+}
+
+// Record adds the given events, dropping the oldest events when the buffer is full.
+func (h *historyBuffer) Record(events []HistoryEvent) {
+	if len(events) == 0 {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.events = append(h.events, events...)
+	if overflow := len(h.events) - h.size; overflow > 0 {
+		h.events = append([]HistoryEvent(nil), h.events[overflow:]...)
+	}
+}
+
+// Events returns the events since the given time, optionally limited to the given service.
+func (h *historyBuffer) Events(since time.Time, service string) []HistoryEvent {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	result := []HistoryEvent{}
+	for _, e := range h.events {
+		if e.Time.Before(since) || (service != "" && e.Service != service) {
+			continue
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+// createHistoryEvents returns the changes from the previous to the current services.
+func createHistoryEvents(previous, current backend.ServiceRegistrations, now time.Time) []HistoryEvent {
+	prevMap := historyServiceMap(previous)
+	curMap := historyServiceMap(current)
+	keys := []string{}
+	for key := range prevMap {
+		keys = append(keys, key)
+	}
+	for key := range curMap {
+		if _, ok := prevMap[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := []HistoryEvent{}
+	for _, key := range keys {
+		prev, hasPrev := prevMap[key]
+		cur, hasCur := curMap[key]
+		switch {
+		case !hasPrev:
+			result = append(result, newHistoryEvent(HistoryServiceAdded, cur, now))
+		case !hasCur:
+			result = append(result, newHistoryEvent(HistoryServiceRemoved, prev, now))
+		default:
+			if prev.Selectors.FullString() != cur.Selectors.FullString() {
+				result = append(result, newHistoryEvent(HistorySelectorsChanged, cur, now))
+			}
+			prevInstances := historyInstanceSet(prev.Instances)
+			curInstances := historyInstanceSet(cur.Instances)
+			for _, addr := range sortedKeys(curInstances) {
+				if _, ok := prevInstances[addr]; !ok {
+					e := newHistoryEvent(HistoryInstanceJoined, cur, now)
+					e.Instance = addr
+					result = append(result, e)
+				}
+			}
+			for _, addr := range sortedKeys(prevInstances) {
+				if _, ok := curInstances[addr]; !ok {
+					e := newHistoryEvent(HistoryInstanceLeft, cur, now)
+					e.Instance = addr
+					result = append(result, e)
+				}
+			}
+		}
+	}
+	return result
+}
+
+func newHistoryEvent(eventType string, sr backend.ServiceRegistration, now time.Time) HistoryEvent {
+	e := HistoryEvent{
+		Time:        now,
+		Type:        eventType,
+		Service:     sr.ServiceName,
+		ServicePort: sr.ServicePort,
+		EdgePort:    sr.EdgePort,
+		Public:      sr.Public,
+	}
+	if eventType != HistoryServiceRemoved {
+		for _, sel := range sr.Selectors {
+			e.Selectors = append(e.Selectors, sel.Domain+sel.PathPrefix)
+		}
+	}
+	return e
+}
+
+func historyServiceMap(list backend.ServiceRegistrations) map[string]backend.ServiceRegistration {
+	result := make(map[string]backend.ServiceRegistration)
+	for _, sr := range list {
+		result[registrationKey(sr)] = sr
+	}
+	return result
+}
+
+// registrationKey returns a key that identifies the given registration across updates.
+func registrationKey(sr backend.ServiceRegistration) string {
+	return fmt.Sprintf("%s-%d-%d-%v", sr.ServiceName, sr.ServicePort, sr.EdgePort, sr.Public)
+}
+
+func historyInstanceSet(list backend.ServiceInstances) map[string]struct{} {
+	result := make(map[string]struct{})
+	for _, si := range list {
+		if si.Draining {
+			// Draining instances have already left
+			continue
+		}
+		result[instanceAddress(si)] = struct{}{}
+	}
+	return result
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	result := make([]string, 0, len(m))
+	for key := range m {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pulcy/robin/service/backend"
+)
+
+func TestCreateHistoryEvents(t *testing.T) {
+	now := time.Now()
+	web := backend.ServiceRegistration{
+		ServiceName: "web",
+		ServicePort: 80,
+		EdgePort:    80,
+		Public:      true,
+		Instances: backend.ServiceInstances{
+			backend.ServiceInstance{IP: "192.168.35.2", Port: 2345},
+		},
+		Selectors: backend.ServiceSelectors{
+			backend.ServiceSelector{Domain: "foo.com"},
+		},
+	}
+	api := backend.ServiceRegistration{ServiceName: "api", ServicePort: 8080, EdgePort: 81}
+	web2 := web
+	web2.Instances = backend.ServiceInstances{
+		backend.ServiceInstance{IP: "192.168.35.3", Port: 2345},
+	}
+	web2.Selectors = backend.ServiceSelectors{
+		backend.ServiceSelector{Domain: "foo.com", PathPrefix: "/v2"},
+	}
+
+	events := createHistoryEvents(backend.ServiceRegistrations{web, api}, backend.ServiceRegistrations{web2}, now)
+	types := []string{}
+	for _, e := range events {
+		types = append(types, e.Type+" "+e.Service+" "+e.Instance)
+	}
+	expected := []string{
+		"service-removed api ",
+		"selectors-changed web ",
+		"instance-joined web 192.168.35.3:2345",
+		"instance-left web 192.168.35.2:2345",
+	}
+	if !reflect.DeepEqual(types, expected) {
+		t.Errorf("Expected %v, got %v", expected, types)
+	}
+
+	h := newHistoryBuffer(3)
+	h.Record(events)
+	if result := h.Events(time.Time{}, ""); len(result) != 3 || result[0].Type != HistorySelectorsChanged {
+		t.Errorf("Expected oldest event to be dropped, got %v", result)
+	}
+	if result := h.Events(time.Time{}, "api"); len(result) != 0 {
+		t.Errorf("Expected no events of api, got %v", result)
+	}
+}
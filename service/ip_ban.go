@@ -0,0 +1,327 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errgo"
+
+	api "github.com/pulcy/robin-api"
+	"github.com/pulcy/robin/haproxy"
+)
+
+const (
+	// DefaultBanWindow is the default period in which failed requests of a source IP are counted.
+	DefaultBanWindow = time.Minute
+	// DefaultBanDuration is the default time a source IP is banned.
+	DefaultBanDuration = time.Minute * 10
+
+	ipFailuresTable   = "ip_failures"
+	ipBansTable       = "ip_bans"
+	ipBanStickIndex   = 0
+	ipBanInterval     = time.Second * 5
+	banReasonAuto     = "auto"
+	banReasonManual   = "manual"
+	ipBanTableOptions = "type ip size 100k"
+)
+
+var (
+	defaultBanStatuses = []int{401, 403}
+)
+
+// IPBanConfig specifies when source IPs are banned automatically.
+type IPBanConfig struct {
+	Failures int           // Number of failed requests (within Window) after which a source IP is banned (0 disables banning)
+	Window   time.Duration // Period in which failed requests are counted (0 means DefaultBanWindow)
+	Duration time.Duration // Time a source IP is banned (0 means DefaultBanDuration)
+	Statuses []int         // Response statuses of failed requests (empty means 401 & 403)
+}
+
+// IsEnabled returns true if source IPs must be banned.
+func (c IPBanConfig) IsEnabled() bool {
+	return c.Failures > 0
+}
+
+func (c IPBanConfig) window() time.Duration {
+	if c.Window == 0 {
+		return DefaultBanWindow
+	}
+	return c.Window
+}
+
+func (c IPBanConfig) duration() time.Duration {
+	if c.Duration == 0 {
+		return DefaultBanDuration
+	}
+	return c.Duration
+}
+
+func (c IPBanConfig) statuses() []int {
+	if len(c.Statuses) == 0 {
+		return defaultBanStatuses
+	}
+	return c.Statuses
+}
+
+// Ban is a source IP from which all requests are refused (403) until it expires.
+type Ban struct {
+	IP      string    `json:"ip"`
+	Expires time.Time `json:"expires"`
+	Reason  string    `json:"reason"` // auto|manual|trap
+}
+
+// BanManager provides access to the list of banned source IPs.
+type BanManager interface {
+	// Bans returns all banned source IPs.
+	Bans() []Ban
+	// Ban bans the given source IP for the given duration (0 means the configured ban duration).
+	Ban(ip string, duration time.Duration) (Ban, error)
+	// Unban removes the ban of the given source IP.
+	Unban(ip string) error
+}
+
+// banList holds the banned source IPs. It is the source of truth for the ban table in HAProxy,
+// which is lost when HAProxy restarts.
+type banList struct {
+	mutex sync.Mutex
+	bans  map[string]Ban
+}
+
+func newBanList() *banList {
+	return &banList{bans: make(map[string]Ban)}
+}
+
+// formatInterval formats the given duration as HAProxy time value.
+func formatInterval(d time.Duration) string {
+	if d%time.Second == 0 {
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+	return fmt.Sprintf("%dms", d/time.Millisecond)
+}
+
+// createIPBanTables creates the stick tables that count failed requests (gpc0) & trap requests (gpc1)
+// per source IP and hold the banned source IPs.
+// Trap requests are only counted on HAProxy 1.8 or later.
+func (s *Service) createIPBanTables(c *haproxy.Config) {
+	if !s.IPBan.IsEnabled() {
+		return
+	}
+	window := formatInterval(s.IPBan.window())
+	store := fmt.Sprintf("gpc0,gpc0_rate(%s)", window)
+	if s.countsTraps() {
+		store = store + ",gpc1,gpt0"
+	}
+	c.Section("backend " + ipFailuresTable).Add(fmt.Sprintf("stick-table %s expire %s store %s", ipBanTableOptions, window, store))
+	c.Section("backend " + ipBansTable).Add(fmt.Sprintf("stick-table %s expire %s store gpc0", ipBanTableOptions, formatInterval(s.IPBan.duration())))
+}
+
+// addIPBanOptions adds rules to the given (public) HTTP frontend section that refuse requests
+// from banned source IPs and count the failed requests of all others.
+func (s *Service) addIPBanOptions(section *haproxy.Section) {
+	if !s.IPBan.IsEnabled() {
+		return
+	}
+	statuses := []string{}
+	for _, status := range s.IPBan.statuses() {
+		statuses = append(statuses, fmt.Sprintf("%d", status))
+	}
+	section.Add(
+		fmt.Sprintf("http-request deny deny_status 403 if { src,table_gpc0(%s) gt 0 }", ipBansTable),
+		fmt.Sprintf("http-request track-sc%d src table %s", ipBanStickIndex, ipFailuresTable),
+		fmt.Sprintf("http-response sc-inc-gpc0(%d) if { status %s }", ipBanStickIndex, strings.Join(statuses, " ")),
+	)
+}
+
+// Bans returns all banned source IPs, sorted by IP.
+func (s *Service) Bans() []Ban {
+	s.bans.mutex.Lock()
+	defer s.bans.mutex.Unlock()
+
+	now := time.Now()
+	result := []Ban{}
+	for _, b := range s.bans.bans {
+		if b.Expires.After(now) {
+			result = append(result, b)
+		}
+	}
+	sort.Sort(bansByIP(result))
+	return result
+}
+
+// bansByIP sorts a list of bans by IP.
+type bansByIP []Ban
+
+func (l bansByIP) Len() int           { return len(l) }
+func (l bansByIP) Less(i, j int) bool { return l[i].IP < l[j].IP }
+func (l bansByIP) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// Ban bans the given source IP for the given duration (0 means the configured ban duration).
+func (s *Service) Ban(ip string, duration time.Duration) (Ban, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return Ban{}, maskAny(errgo.WithCausef(nil, api.ValidationError, "invalid IP address '%s'", ip))
+	}
+	if duration < 0 {
+		return Ban{}, maskAny(errgo.WithCausef(nil, api.ValidationError, "duration must be positive"))
+	}
+	if duration == 0 {
+		duration = s.IPBan.duration()
+	}
+	b, err := s.addBan(parsed.String(), duration, banReasonManual)
+	if err != nil {
+		return Ban{}, maskAny(err)
+	}
+	return b, nil
+}
+
+// Unban removes the ban of the given source IP.
+func (s *Service) Unban(ip string) error {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return maskAny(errgo.WithCausef(nil, api.ValidationError, "invalid IP address '%s'", ip))
+	}
+	ip = parsed.String()
+	s.bans.mutex.Lock()
+	_, found := s.bans.bans[ip]
+	delete(s.bans.bans, ip)
+	s.bans.mutex.Unlock()
+	if !found {
+		return maskAny(errgo.WithCausef(nil, api.IDNotFoundError, "IP address '%s' is not banned", ip))
+	}
+	ipBansActive.Dec()
+	client, err := s.runtimeClient()
+	if err != nil {
+		return maskAny(err)
+	}
+	if err := client.ClearTableEntry(ipBansTable, ip); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// addBan adds (or extends) the ban of the given source IP and inserts it in the ban table of HAProxy.
+func (s *Service) addBan(ip string, duration time.Duration, reason string) (Ban, error) {
+	b := Ban{
+		IP:      ip,
+		Expires: time.Now().Add(duration),
+		Reason:  reason,
+	}
+	s.bans.mutex.Lock()
+	if _, found := s.bans.bans[ip]; !found {
+		ipBansActive.Inc()
+	}
+	s.bans.bans[ip] = b
+	s.bans.mutex.Unlock()
+	ipBansTotal.WithLabelValues(reason).Inc()
+
+	client, err := s.runtimeClient()
+	if err != nil {
+		return b, maskAny(err)
+	}
+	if err := client.SetTableEntry(ipBansTable, ip, "gpc0", 1); err != nil {
+		return b, maskAny(err)
+	}
+	return b, nil
+}
+
+// ipBanLoop periodically bans source IPs with too many failed requests
+// and synchronizes the ban table of HAProxy with the list of bans.
+func (s *Service) ipBanLoop(ctx context.Context) {
+	for sleep(ctx, ipBanInterval) {
+		if err := s.updateBans(time.Now()); err != nil {
+			s.Logger.Debugf("Failed to update bans: %#v", err)
+		}
+	}
+}
+
+func (s *Service) updateBans(now time.Time) error {
+	client, err := s.runtimeClient()
+	if err != nil {
+		return maskAny(err)
+	}
+
+	// Ban source IPs with too many failed requests or that requested a trap path with ban action
+	failures, err := client.ShowTable(ipFailuresTable)
+	if err != nil {
+		return maskAny(err)
+	}
+	for _, e := range failures {
+		reason := ""
+		if hits := e.Values["gpc1"]; hits > 0 {
+			s.Logger.Warningf("Security event: %s requested a trap path (%d times)", e.Key, hits)
+			trapHits.Add(float64(hits))
+			if e.Values["gpt0"] > 0 {
+				reason = banReasonTrap
+			} else if err := client.SetTableEntry(ipFailuresTable, e.Key, "gpc1", 0); err != nil {
+				return maskAny(err)
+			}
+		}
+		if reason == "" && e.Values["gpc0_rate"] >= int64(s.IPBan.Failures) {
+			reason = banReasonAuto
+		}
+		if reason == "" {
+			continue
+		}
+		s.Logger.Infof("Banning %s (%s) after %d failed requests", e.Key, reason, e.Values["gpc0_rate"])
+		if _, err := s.addBan(e.Key, s.IPBan.duration(), reason); err != nil {
+			return maskAny(err)
+		}
+		// Start counting from zero when the ban expires
+		if err := client.ClearTableEntry(ipFailuresTable, e.Key); err != nil {
+			return maskAny(err)
+		}
+	}
+
+	// Remove expired bans & restore bans lost by a restart of HAProxy
+	current, err := client.ShowTable(ipBansTable)
+	if err != nil {
+		return maskAny(err)
+	}
+	inTable := make(map[string]struct{})
+	for _, e := range current {
+		inTable[e.Key] = struct{}{}
+	}
+	s.bans.mutex.Lock()
+	var expired, missing []string
+	for ip, b := range s.bans.bans {
+		if !b.Expires.After(now) {
+			expired = append(expired, ip)
+			delete(s.bans.bans, ip)
+			ipBansActive.Dec()
+		} else if _, found := inTable[ip]; !found {
+			missing = append(missing, ip)
+		}
+	}
+	s.bans.mutex.Unlock()
+	for _, ip := range expired {
+		s.Logger.Infof("Ban of %s expired", ip)
+		if err := client.ClearTableEntry(ipBansTable, ip); err != nil {
+			return maskAny(err)
+		}
+	}
+	for _, ip := range missing {
+		if err := client.SetTableEntry(ipBansTable, ip, "gpc0", 1); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
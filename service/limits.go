@@ -0,0 +1,93 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"strings"
+
+	"github.com/juju/errgo"
+)
+
+// configStats holds the numbers of a rendered configuration that are subject to limits.
+type configStats struct {
+	Backends int
+	Acls     map[string]int // frontend name -> number of acl lines
+	Size     int
+}
+
+// analyzeConfig counts the backends & ACLs of the given (rendered) configuration.
+func analyzeConfig(config string) configStats {
+	stats := configStats{
+		Acls: make(map[string]int),
+		Size: len(config),
+	}
+	frontend := ""
+	for _, line := range strings.Split(config, "\n") {
+		if !strings.HasPrefix(line, " ") {
+			frontend = ""
+			if strings.HasPrefix(line, "backend ") {
+				stats.Backends++
+			} else if strings.HasPrefix(line, "frontend ") {
+				frontend = strings.TrimPrefix(line, "frontend ")
+				stats.Acls[frontend] = 0
+			}
+			continue
+		}
+		if frontend != "" && strings.HasPrefix(strings.TrimSpace(line), "acl ") {
+			stats.Acls[frontend]++
+		}
+	}
+	return stats
+}
+
+// countAcls returns the total number of ACLs in all frontends of the given configuration.
+func countAcls(config string) int {
+	total := 0
+	for _, count := range analyzeConfig(config).Acls {
+		total += count
+	}
+	return total
+}
+
+// checkConfigLimits updates the configuration metrics and returns an error
+// when the given configuration exceeds one of the configured limits.
+func (s *Service) checkConfigLimits(config string) error {
+	stats := analyzeConfig(config)
+	configBackends.Set(float64(stats.Backends))
+	configSize.Set(float64(stats.Size))
+	configMapFiles.Set(float64(len(s.lastMapFiles)))
+	configAcls.Reset()
+	for frontend, count := range stats.Acls {
+		configAcls.WithLabelValues(frontend).Set(float64(count))
+	}
+
+	if s.MaxBackends > 0 && stats.Backends > s.MaxBackends {
+		configLimitsExceeded.WithLabelValues("backends").Inc()
+		return maskAny(errgo.WithCausef(nil, ConfigLimitExceededError, "config has %d backends, at most %d allowed", stats.Backends, s.MaxBackends))
+	}
+	if s.MaxAclsPerFrontend > 0 {
+		for frontend, count := range stats.Acls {
+			if count > s.MaxAclsPerFrontend {
+				configLimitsExceeded.WithLabelValues("acls").Inc()
+				return maskAny(errgo.WithCausef(nil, ConfigLimitExceededError, "frontend %s has %d ACLs, at most %d allowed (even when using a map file)", frontend, count, s.MaxAclsPerFrontend))
+			}
+		}
+	}
+	if s.MaxConfigSize > 0 && stats.Size > s.MaxConfigSize {
+		configLimitsExceeded.WithLabelValues("size").Inc()
+		return maskAny(errgo.WithCausef(nil, ConfigLimitExceededError, "config is %d bytes, at most %d bytes allowed", stats.Size, s.MaxConfigSize))
+	}
+	return nil
+}
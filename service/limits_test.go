@@ -0,0 +1,26 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestCheckConfigLimits(t *testing.T) {
+	config := "frontend http_in\n    bind *:80\n    acl acl1 var(txn.host) -m dom -i foo.com\n    acl acl2 path_beg /api/\n\nbackend a\n    mode http\n\nbackend b\n    mode http\n"
+	tests := []struct {
+		Config   ServiceConfig
+		Exceeded bool
+	}{
+		{ServiceConfig{}, false},
+		{ServiceConfig{MaxBackends: 2, MaxAclsPerFrontend: 2, MaxConfigSize: len(config)}, false},
+		{ServiceConfig{MaxBackends: 1}, true},
+		{ServiceConfig{MaxAclsPerFrontend: 1}, true},
+		{ServiceConfig{MaxConfigSize: 10}, true},
+	}
+	for _, test := range tests {
+		s := Service{ServiceConfig: test.Config}
+		err := s.checkConfigLimits(config)
+		if IsConfigLimitExceeded(err) != test.Exceeded {
+			t.Errorf("Unexpected result for %#v: %#v", test.Config, err)
+		}
+	}
+}
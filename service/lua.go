@@ -0,0 +1,88 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	luaScriptExt = ".lua"
+)
+
+// luaScriptsPath returns the folder in which Lua scripts stored in the backend are written.
+func (s *Service) luaScriptsPath() string {
+	return filepath.Join(filepath.Dir(s.HaproxyConfPath), "lua")
+}
+
+// createLuaLoads creates the lua-load directives for all scripts in the Lua scripts folder
+// and all scripts stored in the backend.
+// It returns the directives and the content of the scripts that must be written (by path).
+// Scripts from the backend are written to a path containing a hash of their content,
+// so a changed script results in a changed config (and a reload).
+func (s *Service) createLuaLoads() ([]string, map[string]string) {
+	paths := []string{}
+	if s.LuaScriptsFolder != "" {
+		files, _ := filepath.Glob(filepath.Join(s.LuaScriptsFolder, "*"+luaScriptExt))
+		sort.Strings(files)
+		paths = append(paths, files...)
+	}
+	names := []string{}
+	for name := range s.luaScripts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	scripts := make(map[string]string)
+	for _, name := range names {
+		source := s.luaScripts[name]
+		path := filepath.Join(s.luaScriptsPath(), fmt.Sprintf("%s-%x%s", name, sha1.Sum([]byte(source)), luaScriptExt))
+		scripts[path] = source
+		paths = append(paths, path)
+	}
+	loads := []string{}
+	for _, path := range paths {
+		loads = append(loads, "lua-load "+path)
+	}
+	return loads, scripts
+}
+
+// writeLuaScripts writes the Lua scripts stored in the backend and removes
+// scripts that are no longer used.
+func (s *Service) writeLuaScripts() error {
+	folder := s.luaScriptsPath()
+	if len(s.lastLuaScripts) > 0 {
+		if err := os.MkdirAll(folder, 0755); err != nil {
+			return maskAny(err)
+		}
+	}
+	for path, source := range s.lastLuaScripts {
+		if err := ioutil.WriteFile(path, []byte(source), confPerm); err != nil {
+			s.Logger.Errorf("Cannot write Lua script to %s: %#v", path, err)
+			return maskAny(err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(folder, "*"+luaScriptExt))
+	for _, path := range files {
+		if _, ok := s.lastLuaScripts[path]; !ok {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
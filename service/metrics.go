@@ -0,0 +1,158 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	routeConflicts = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "robin",
+			Subsystem: "config",
+			Name:      "route_conflicts",
+			Help:      "Number of routes in the current configuration that are shadowed by another route.",
+		},
+		[]string{"frontend"},
+	)
+	configBackends = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "robin",
+			Subsystem: "config",
+			Name:      "backends",
+			Help:      "Number of backends in the current configuration.",
+		},
+	)
+	configAcls = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "robin",
+			Subsystem: "config",
+			Name:      "acls",
+			Help:      "Number of ACLs per frontend in the current configuration.",
+		},
+		[]string{"frontend"},
+	)
+	configSize = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "robin",
+			Subsystem: "config",
+			Name:      "size_bytes",
+			Help:      "Size of the current configuration in bytes.",
+		},
+	)
+	configMapFiles = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "robin",
+			Subsystem: "config",
+			Name:      "map_files",
+			Help:      "Number of frontends that select their backends using a map file.",
+		},
+	)
+	configLimitsExceeded = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "robin",
+			Subsystem: "config",
+			Name:      "limit_exceeded_total",
+			Help:      "Number of configurations that were refused because they exceed a limit.",
+		},
+		[]string{"limit"},
+	)
+	quotaRequests = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "robin",
+			Subsystem: "quota",
+			Name:      "requests",
+			Help:      "Number of requests in the last 24 hours per domain or tenant with a quota.",
+		},
+		[]string{"kind", "key"},
+	)
+	quotaBandwidth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "robin",
+			Subsystem: "quota",
+			Name:      "bandwidth_bytes",
+			Help:      "Number of response bytes in the last 24 hours per domain or tenant with a quota.",
+		},
+		[]string{"kind", "key"},
+	)
+	ipBansActive = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "robin",
+			Subsystem: "ban",
+			Name:      "active",
+			Help:      "Number of banned source IPs.",
+		},
+	)
+	ipBansTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "robin",
+			Subsystem: "ban",
+			Name:      "total",
+			Help:      "Number of bans of source IPs.",
+		},
+		[]string{"reason"},
+	)
+	trapHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "robin",
+			Subsystem: "trap",
+			Name:      "hits_total",
+			Help:      "Number of requests for a trap path (counted on public frontends when banning is enabled).",
+		},
+	)
+	blocklistEntries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "robin",
+			Subsystem: "blocklist",
+			Name:      "entries",
+			Help:      "Number of IP addresses & networks per blocklist.",
+		},
+		[]string{"name"},
+	)
+	watchCircuitOpen = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "robin",
+			Subsystem: "watch",
+			Name:      "circuit_open",
+			Help:      "1 if watching the backend failed too many times in a row (retries are backed off at the maximum delay), 0 otherwise.",
+		},
+	)
+	watchRetryDelay = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "robin",
+			Subsystem: "watch",
+			Name:      "retry_delay_seconds",
+			Help:      "Delay before the next attempt to watch the backend after a failure (0 when the last watch succeeded).",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(routeConflicts)
+	prometheus.MustRegister(configBackends)
+	prometheus.MustRegister(configAcls)
+	prometheus.MustRegister(configSize)
+	prometheus.MustRegister(configMapFiles)
+	prometheus.MustRegister(configLimitsExceeded)
+	prometheus.MustRegister(quotaRequests)
+	prometheus.MustRegister(quotaBandwidth)
+	prometheus.MustRegister(ipBansActive)
+	prometheus.MustRegister(ipBansTotal)
+	prometheus.MustRegister(trapHits)
+	prometheus.MustRegister(blocklistEntries)
+	prometheus.MustRegister(watchCircuitOpen)
+	prometheus.MustRegister(watchRetryDelay)
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"github.com/pulcy/robin/service/backend"
+)
+
+// createMinInstances returns the minimum number of healthy instances per backend,
+// for all backends that have such a minimum.
+func (s *Service) createMinInstances(services backend.ServiceRegistrations) map[string]int {
+	services = s.addGatewaySelectors(services)
+	result := make(map[string]int)
+	for _, f := range s.collectFrontends(services) {
+		for _, pair := range createSelectorServicePairs(services, f) {
+			if pair.Service.MinInstances == 0 {
+				continue
+			}
+			name := generateBackendName(pair.Service, f)
+			if pair.Service.MinInstances > result[name] {
+				result[name] = pair.Service.MinInstances
+			}
+		}
+	}
+	return result
+}
+
+// MinInstances returns the minimum number of healthy instances per backend of the current configuration.
+func (s *Service) MinInstances() map[string]int {
+	result, _ := s.lastMinInstances.Load().(map[string]int)
+	return result
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"sort"
+
+	"github.com/pulcy/robin/service/backend"
+)
+
+// Backend describes a HAProxy backend of the current configuration.
+type Backend struct {
+	Name        string                   `json:"name"`
+	Service     string                   `json:"service"`
+	ServicePort int                      `json:"service-port"`
+	Mode        string                   `json:"mode"`
+	Frontend    string                   `json:"frontend"` // Frontend that routes requests to this backend
+	Servers     backend.ServiceInstances `json:"servers"`
+}
+
+// Services returns the services of the current configuration.
+func (s *Service) Services() backend.ServiceRegistrations {
+	services, _ := s.lastServices.Load().(backend.ServiceRegistrations)
+	return services
+}
+
+// Backends returns the backends of the current configuration, sorted by name.
+func (s *Service) Backends() []Backend {
+	services := s.Services()
+	byName := make(map[string]Backend)
+	for _, f := range s.collectFrontends(services) {
+		for _, pair := range createSelectorServicePairs(services, f) {
+			name := generateBackendName(pair.Service, f)
+			if _, found := byName[name]; !found {
+				byName[name] = Backend{
+					Name:        name,
+					Service:     pair.Service.ServiceName,
+					ServicePort: pair.Service.ServicePort,
+					Mode:        pair.Service.Mode,
+					Frontend:    f.Name(),
+					Servers:     pair.Service.Instances,
+				}
+			}
+		}
+	}
+	result := make([]Backend, 0, len(byName))
+	for _, b := range byName {
+		result = append(result, b)
+	}
+	sort.Sort(backendsByName(result))
+	return result
+}
+
+// backendsByName sorts a list of backends by name.
+type backendsByName []Backend
+
+func (l backendsByName) Len() int           { return len(l) }
+func (l backendsByName) Less(i, j int) bool { return l[i].Name < l[j].Name }
+func (l backendsByName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
@@ -0,0 +1,136 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mutex
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/dchest/uniuri"
+	"github.com/juju/errgo"
+	"golang.org/x/net/context"
+)
+
+// NewEtcd3GlobalMutexService returns a global mutex service implementation
+// based on the ETCD v3 API. Locks are attached to a lease, so they expire
+// when their TTL is not refreshed.
+func NewEtcd3GlobalMutexService(etcdClient *clientv3.Client, prefix string) GlobalMutexService {
+	return &etcd3GlobalMutexService{
+		etcdClient: etcdClient,
+		prefix:     prefix,
+		ownerID:    uniuri.New(),
+	}
+}
+
+type etcd3GlobalMutexService struct {
+	etcdClient *clientv3.Client
+	prefix     string
+	ownerID    string
+}
+
+// New creates a new global mutex with a given name.
+// The mutex is initialized but not yet claimed.
+// name is the name of the new mutex. This name is accessible globally in the cluster
+// ttl is the amount of time before the lock will automatically be released
+func (gms *etcd3GlobalMutexService) New(name string, ttl time.Duration) (*GlobalMutex, error) {
+	m, err := newMutex(name, ttl, gms)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return m, nil
+}
+
+// Claim tries to claim a lock with given name and assign it to the given owner.
+// If successful, it returns nil, otherwise it returns an error.
+func (gms *etcd3GlobalMutexService) Claim(name string, ttl time.Duration) error {
+	ctx := context.Background()
+	lease, err := gms.etcdClient.Grant(ctx, leaseTTL(ttl))
+	if err != nil {
+		return maskAny(err)
+	}
+	key := gms.key(name)
+	resp, err := gms.etcdClient.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, gms.ownerID, clientv3.WithLease(lease.ID))).
+		Commit()
+	if err != nil {
+		// The key may not have been put, don't leave the lease behind
+		gms.etcdClient.Revoke(ctx, lease.ID)
+		return maskAny(err)
+	}
+	if !resp.Succeeded {
+		gms.etcdClient.Revoke(ctx, lease.ID)
+		return maskAny(errgo.WithCausef(nil, AlreadyLockedError, "%s", name))
+	}
+	return nil
+}
+
+// Update tries to update a lock with given name to the given ownerID.
+// This must be called often enough to avoid TTL expiration.
+func (gms *etcd3GlobalMutexService) Update(name string, ttl time.Duration) error {
+	ctx := context.Background()
+	resp, err := gms.etcdClient.Get(ctx, gms.key(name))
+	if err != nil {
+		return maskAny(err)
+	}
+	if len(resp.Kvs) == 0 {
+		// Lock did not exists
+		return maskAny(errgo.WithCausef(nil, NotLockedError, "%s", name))
+	}
+	if string(resp.Kvs[0].Value) != gms.ownerID {
+		// Lock did not have ownerID as value
+		return maskAny(errgo.WithCausef(nil, NotOwnerError, "%s", name))
+	}
+	if _, err := gms.etcdClient.KeepAliveOnce(ctx, clientv3.LeaseID(resp.Kvs[0].Lease)); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// Release releases the lock with given name from the given ownerID.
+func (gms *etcd3GlobalMutexService) Release(name string) error {
+	key := gms.key(name)
+	resp, err := gms.etcdClient.Txn(context.Background()).
+		If(clientv3.Compare(clientv3.Value(key), "=", gms.ownerID)).
+		Then(clientv3.OpDelete(key)).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return maskAny(err)
+	}
+	if !resp.Succeeded {
+		if len(resp.Responses) == 0 || len(resp.Responses[0].GetResponseRange().Kvs) == 0 {
+			// Lock did not exists
+			return errgo.WithCausef(nil, NotLockedError, "%s", name)
+		}
+		// Lock did not have ownerID as value
+		return errgo.WithCausef(nil, NotOwnerError, "%s", name)
+	}
+	return nil
+}
+
+func (gms *etcd3GlobalMutexService) key(name string) string {
+	return fmt.Sprintf("%s/%s/%s", gms.prefix, locksPrefix, name)
+}
+
+// leaseTTL converts the given TTL into the TTL of a lease (in seconds, at least 1).
+func leaseTTL(ttl time.Duration) int64 {
+	seconds := int64(ttl / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
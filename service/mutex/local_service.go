@@ -0,0 +1,87 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mutex
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/errgo"
+)
+
+// NewLocalGlobalMutexService returns a global mutex service implementation
+// that only excludes users within this process.
+// It can only be used when a single instance is running.
+func NewLocalGlobalMutexService() GlobalMutexService {
+	return &localGlobalMutexService{
+		locks: make(map[string]time.Time),
+	}
+}
+
+type localGlobalMutexService struct {
+	mutex sync.Mutex
+	locks map[string]time.Time // name -> expiration time
+}
+
+// New creates a new global mutex with a given name.
+// The mutex is initialized but not yet claimed.
+func (gms *localGlobalMutexService) New(name string, ttl time.Duration) (*GlobalMutex, error) {
+	m, err := newMutex(name, ttl, gms)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return m, nil
+}
+
+// Claim tries to claim a lock with given name.
+// If successful, it returns nil, otherwise it returns an error.
+func (gms *localGlobalMutexService) Claim(name string, ttl time.Duration) error {
+	gms.mutex.Lock()
+	defer gms.mutex.Unlock()
+	if gms.isLocked(name) {
+		return maskAny(errgo.WithCausef(nil, AlreadyLockedError, "%s", name))
+	}
+	gms.locks[name] = time.Now().Add(ttl)
+	return nil
+}
+
+// Update tries to update a lock with given name.
+// This must be called often enough to avoid TTL expiration.
+func (gms *localGlobalMutexService) Update(name string, ttl time.Duration) error {
+	gms.mutex.Lock()
+	defer gms.mutex.Unlock()
+	if !gms.isLocked(name) {
+		return maskAny(errgo.WithCausef(nil, NotLockedError, "%s", name))
+	}
+	gms.locks[name] = time.Now().Add(ttl)
+	return nil
+}
+
+// Release releases the lock with given name.
+func (gms *localGlobalMutexService) Release(name string) error {
+	gms.mutex.Lock()
+	defer gms.mutex.Unlock()
+	if !gms.isLocked(name) {
+		return errgo.WithCausef(nil, NotLockedError, "%s", name)
+	}
+	delete(gms.locks, name)
+	return nil
+}
+
+// isLocked returns true if the lock with given name is claimed and not expired.
+func (gms *localGlobalMutexService) isLocked(name string) bool {
+	expiration, found := gms.locks[name]
+	return found && time.Now().Before(expiration)
+}
@@ -0,0 +1,41 @@
+package mutex
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocalGlobalMutexService(t *testing.T) {
+	gms := NewLocalGlobalMutexService()
+	m1, err := gms.New("certs", time.Minute)
+	if err != nil {
+		t.Fatalf("New failed: %#v", err)
+	}
+	m2, err := gms.New("certs", time.Minute)
+	if err != nil {
+		t.Fatalf("New failed: %#v", err)
+	}
+	if err := m1.Lock(); err != nil {
+		t.Fatalf("Lock failed: %#v", err)
+	}
+	if err := m2.Lock(); !IsAlreadyLocked(err) {
+		t.Errorf("Expected AlreadyLockedError, got %v", err)
+	}
+	if err := m1.Unlock(); err != nil {
+		t.Fatalf("Unlock failed: %#v", err)
+	}
+	if err := m2.Lock(); err != nil {
+		t.Errorf("Expected lock to be free after unlock, got %v", err)
+	}
+	m2.Unlock()
+
+	// Expired locks can be claimed again
+	local := gms.(*localGlobalMutexService)
+	if err := local.Claim("expired", time.Millisecond); err != nil {
+		t.Fatalf("Claim failed: %#v", err)
+	}
+	time.Sleep(time.Millisecond * 5)
+	if err := local.Claim("expired", time.Minute); err != nil {
+		t.Errorf("Expected expired lock to be claimable, got %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// OldProcess describes a HAProxy process that has been replaced by a reload,
+// but still serves long-lived connections.
+type OldProcess struct {
+	Pid              int            `json:"pid"`
+	ReloadedAt       time.Time      `json:"reloaded-at"`       // Time the process was replaced
+	Connections      map[string]int `json:"connections"`       // Number of server connections per backend
+	TotalConnections int            `json:"total-connections"` // Number of connections (including client connections)
+}
+
+// processTracker keeps track of running HAProxy processes and the servers of their configuration.
+type processTracker struct {
+	mutex     sync.Mutex
+	processes map[int]*trackedProcess
+}
+
+type trackedProcess struct {
+	pid        int
+	servers    map[string]string // ip:port -> backend name
+	reloadedAt time.Time         // Zero for the current process
+}
+
+func newProcessTracker() *processTracker {
+	return &processTracker{processes: make(map[int]*trackedProcess)}
+}
+
+// started records a new HAProxy process serving the given servers.
+func (t *processTracker) started(pid int, refs []serverRef) {
+	servers := make(map[string]string)
+	for _, ref := range refs {
+		servers[ref.Address] = ref.Backend
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.processes[pid] = &trackedProcess{pid: pid, servers: servers}
+}
+
+// reloaded records that the given HAProxy process has been replaced.
+func (t *processTracker) reloaded(pid int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if p, ok := t.processes[pid]; ok {
+		p.reloadedAt = time.Now()
+	}
+}
+
+// terminated removes the given HAProxy process.
+func (t *processTracker) terminated(pid int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.processes, pid)
+}
+
+// old returns all processes that have been replaced, sorted by pid.
+func (t *processTracker) old() []trackedProcess {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	result := []trackedProcess{}
+	for _, p := range t.processes {
+		if !p.reloadedAt.IsZero() {
+			result = append(result, *p)
+		}
+	}
+	sort.Sort(processesByPid(result))
+	return result
+}
+
+// processesByPid sorts a list of tracked processes by pid.
+type processesByPid []trackedProcess
+
+func (l processesByPid) Len() int           { return len(l) }
+func (l processesByPid) Less(i, j int) bool { return l[i].pid < l[j].pid }
+func (l processesByPid) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// OldProcesses returns the HAProxy processes that have been replaced by a reload,
+// together with the connections they still serve.
+func (s *Service) OldProcesses() []OldProcess {
+	result := []OldProcess{}
+	if s.processes == nil {
+		return result
+	}
+	for _, p := range s.processes.old() {
+		addrs, err := processConnections(p.pid)
+		if err != nil {
+			s.Logger.Debugf("Cannot read connections of haproxy pid %d: %#v", p.pid, err)
+			continue
+		}
+		op := OldProcess{
+			Pid:              p.pid,
+			ReloadedAt:       p.reloadedAt,
+			Connections:      make(map[string]int),
+			TotalConnections: len(addrs),
+		}
+		for _, addr := range addrs {
+			if backend, ok := p.servers[addr]; ok {
+				op.Connections[backend]++
+			}
+		}
+		result = append(result, op)
+	}
+	return result
+}
+
+// OldConnections returns the number of HAProxy processes that have been replaced by a reload,
+// and the number of server connections they still serve per backend.
+func (s *Service) OldConnections() (int, map[string]int) {
+	processes := s.OldProcesses()
+	connections := make(map[string]int)
+	for _, p := range processes {
+		for backend, count := range p.Connections {
+			connections[backend] += count
+		}
+	}
+	return len(processes), connections
+}
@@ -0,0 +1,206 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+const (
+	defaultInterval = time.Second * 5
+	defaultTimeout  = time.Second * 2
+	tickInterval    = time.Second
+)
+
+// Target is a single server that must be probed.
+type Target struct {
+	Backend  string        // Name of the HAProxy backend containing the server
+	Server   string        // Name of the server in the backend
+	Type     string        // http|tcp
+	Address  string        // host:port to probe
+	Path     string        // Path of HTTP probes
+	Interval time.Duration // Time between probes
+}
+
+func (t Target) key() string {
+	return t.Backend + "/" + t.Server
+}
+
+// ServerStateSetter pushes the state of a server to HAProxy.
+type ServerStateSetter interface {
+	SetServerState(backend, server string, up bool) error
+}
+
+// Prober probes servers and pushes their up/down state to HAProxy.
+type Prober interface {
+	// SetTargets replaces the set of probed servers.
+	// It must be called after every HAProxy (re)start, since that resets all server states.
+	SetTargets(targets []Target)
+	// Start runs the probe loop in the background.
+	Start()
+}
+
+type ProberConfig struct {
+	Timeout time.Duration // Timeout of a single probe
+}
+
+type ProberDependencies struct {
+	Logger *logging.Logger
+	Setter ServerStateSetter
+}
+
+type targetState struct {
+	Target
+	nextProbe time.Time
+	probing   bool
+	up        bool
+	pushed    bool // Set when the current state has been pushed to HAProxy
+}
+
+type prober struct {
+	ProberConfig
+	ProberDependencies
+
+	mutex   sync.Mutex
+	targets map[string]*targetState
+}
+
+// NewProber creates a new prober.
+func NewProber(config ProberConfig, deps ProberDependencies) Prober {
+	if config.Timeout == 0 {
+		config.Timeout = defaultTimeout
+	}
+	return &prober{
+		ProberConfig:       config,
+		ProberDependencies: deps,
+		targets:            make(map[string]*targetState),
+	}
+}
+
+// SetTargets replaces the set of probed servers.
+func (p *prober) SetTargets(targets []Target) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	newTargets := make(map[string]*targetState)
+	for _, t := range targets {
+		if t.Interval == 0 {
+			t.Interval = defaultInterval
+		}
+		ts, ok := p.targets[t.key()]
+		if !ok {
+			ts = &targetState{up: true}
+		}
+		ts.Target = t
+		// HAProxy has been restarted, so its server state must be pushed again
+		ts.pushed = false
+		newTargets[t.key()] = ts
+	}
+	p.targets = newTargets
+}
+
+// Start runs the probe loop in the background.
+func (p *prober) Start() {
+	go p.run()
+}
+
+func (p *prober) run() {
+	for {
+		now := time.Now()
+		p.mutex.Lock()
+		for _, ts := range p.targets {
+			if ts.probing || now.Before(ts.nextProbe) {
+				continue
+			}
+			ts.probing = true
+			ts.nextProbe = now.Add(ts.Interval)
+			go p.probe(ts, ts.Target)
+		}
+		p.mutex.Unlock()
+		time.Sleep(tickInterval)
+	}
+}
+
+// probe checks the given target and pushes its state to HAProxy if needed.
+func (p *prober) probe(ts *targetState, t Target) {
+	err := p.check(t)
+	up := err == nil
+
+	p.mutex.Lock()
+	ts.probing = false
+	changed := ts.up != up
+	if changed {
+		ts.up = up
+		ts.pushed = false
+	}
+	push := !ts.pushed
+	p.mutex.Unlock()
+
+	if changed {
+		if up {
+			p.Logger.Infof("Probe of %s (%s) succeeded, server is up", t.key(), t.Address)
+		} else {
+			p.Logger.Warningf("Probe of %s (%s) failed, server is down: %v", t.key(), t.Address, err)
+		}
+	}
+	if !push {
+		return
+	}
+	if err := p.Setter.SetServerState(t.Backend, t.Server, up); err != nil {
+		p.Logger.Errorf("Failed to set state of %s: %#v", t.key(), err)
+		return
+	}
+	p.mutex.Lock()
+	if ts.up == up {
+		ts.pushed = true
+	}
+	p.mutex.Unlock()
+}
+
+// check performs a single probe of the given target.
+func (p *prober) check(t Target) error {
+	switch t.Type {
+	case "tcp":
+		conn, err := net.DialTimeout("tcp", t.Address, p.Timeout)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+		return nil
+	case "http":
+		path := t.Path
+		if path == "" {
+			path = "/"
+		}
+		client := http.Client{Timeout: p.Timeout}
+		resp, err := client.Get(fmt.Sprintf("http://%s%s", t.Address, path))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("status %d", resp.StatusCode)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown probe type '%s'", t.Type)
+	}
+}
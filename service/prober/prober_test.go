@@ -0,0 +1,113 @@
+package prober
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+type testSetter struct {
+	mutex  sync.Mutex
+	states []string
+}
+
+func (s *testSetter) SetServerState(backend, server string, up bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state := "down"
+	if up {
+		state = "up"
+	}
+	s.states = append(s.states, backend+"/"+server+" "+state)
+	return nil
+}
+
+func newTestProber() (*prober, *testSetter) {
+	setter := &testSetter{}
+	p := NewProber(ProberConfig{Timeout: time.Second}, ProberDependencies{Logger: logging.MustGetLogger("test"), Setter: setter}).(*prober)
+	return p, setter
+}
+
+func TestCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	// Find a port on which nothing is listening
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %#v", err)
+	}
+	closedAddress := l.Addr().String()
+	l.Close()
+
+	p, _ := newTestProber()
+	tests := []struct {
+		Target Target
+		Up     bool
+	}{
+		{Target{Type: "http", Address: address, Path: "/health"}, true},
+		{Target{Type: "http", Address: address}, false},
+		{Target{Type: "http", Address: closedAddress, Path: "/health"}, false},
+		{Target{Type: "tcp", Address: address}, true},
+		{Target{Type: "tcp", Address: closedAddress}, false},
+		{Target{Type: "udp", Address: address}, false},
+	}
+	for i, test := range tests {
+		if err := p.check(test.Target); (err == nil) != test.Up {
+			t.Errorf("Test %d: expected up=%v, got %v", i, test.Up, err)
+		}
+	}
+}
+
+func TestProbePushesChanges(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %#v", err)
+	}
+	defer l.Close()
+	target := Target{Backend: "backend_web", Server: "s0", Type: "tcp", Address: l.Addr().String()}
+
+	p, setter := newTestProber()
+	p.SetTargets([]Target{target})
+	ts := p.targets[target.key()]
+	if ts.Interval != defaultInterval {
+		t.Errorf("Expected default interval, got %s", ts.Interval)
+	}
+
+	// The initial state is pushed once
+	p.probe(ts, ts.Target)
+	p.probe(ts, ts.Target)
+	// A change of state is pushed
+	l.Close()
+	p.probe(ts, ts.Target)
+	// After a restart of HAProxy, the state is pushed again
+	p.SetTargets([]Target{target})
+	if p.targets[target.key()] != ts {
+		t.Errorf("Expected state of target to be kept")
+	}
+	p.probe(ts, ts.Target)
+
+	expected := "backend_web/s0 up,backend_web/s0 down,backend_web/s0 down"
+	if result := strings.Join(setter.states, ","); result != expected {
+		t.Errorf("Expected pushes '%s', got '%s'", expected, result)
+	}
+
+	// Removed targets are no longer probed
+	p.SetTargets(nil)
+	if len(p.targets) != 0 {
+		t.Errorf("Expected no targets, got %d", len(p.targets))
+	}
+}
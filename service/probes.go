@@ -0,0 +1,70 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pulcy/robin/haproxy"
+	"github.com/pulcy/robin/service/backend"
+	"github.com/pulcy/robin/service/prober"
+)
+
+// createProbeTargets returns the servers of all services that have a probe configured.
+// The backend & server names match those of the generated configuration.
+func (s *Service) createProbeTargets(services backend.ServiceRegistrations) []prober.Target {
+	services = s.addGatewaySelectors(services)
+	targets := []prober.Target{}
+	seen := make(map[string]struct{})
+	for _, f := range s.collectFrontends(services) {
+		for _, pair := range createSelectorServicePairs(services, f) {
+			sr := pair.Service
+			if sr.ProbeType == "" {
+				continue
+			}
+			var interval time.Duration
+			if sr.ProbeInterval != "" {
+				var err error
+				interval, err = haproxy.ParseInterval(sr.ProbeInterval)
+				if err != nil {
+					s.Logger.Warningf("Ignoring probe-interval of service '%s': %#v", sr.ServiceName, err)
+				}
+			}
+			backendName := generateBackendName(sr, f)
+			for i, instance := range sr.Instances {
+				t := prober.Target{
+					Backend:  backendName,
+					Server:   serverID(i, instance),
+					Type:     sr.ProbeType,
+					Address:  net.JoinHostPort(instance.IP, strconv.Itoa(instance.Port)),
+					Path:     sr.ProbePath,
+					Interval: interval,
+				}
+				if sr.ProbePort != 0 {
+					t.Address = net.JoinHostPort(instance.IP, strconv.Itoa(sr.ProbePort))
+				}
+				key := t.Backend + "/" + t.Server
+				if _, ok := seen[key]; ok {
+					continue
+				}
+				seen[key] = struct{}{}
+				targets = append(targets, t)
+			}
+		}
+	}
+	return targets
+}
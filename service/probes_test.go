@@ -0,0 +1,32 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pulcy/robin/service/backend"
+)
+
+func TestProbeTargets(t *testing.T) {
+	services := backend.ServiceRegistrations{probedService}
+	config, err := runtimeSocketService.renderConfig(services)
+	if err != nil {
+		t.Fatalf("Test failed: %#v", err)
+	}
+	targets := runtimeSocketService.createProbeTargets(services)
+	if len(targets) != 2 {
+		t.Fatalf("Expected 2 targets, got %d", len(targets))
+	}
+	for _, target := range targets {
+		if !strings.Contains(config, "backend "+target.Backend+"\n") {
+			t.Errorf("Backend '%s' not found in config", target.Backend)
+		}
+		if !strings.Contains(config, "server "+target.Server+" ") {
+			t.Errorf("Server '%s' not found in config", target.Server)
+		}
+		if target.Type != "http" || target.Path != "/health" || target.Interval != time.Second*10 {
+			t.Errorf("Unexpected target %#v", target)
+		}
+	}
+}
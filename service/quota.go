@@ -0,0 +1,164 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pulcy/robin/haproxy"
+	"github.com/pulcy/robin/service/backend"
+)
+
+const (
+	// DefaultQuotaErrorFile is the error page served when a quota is exceeded.
+	DefaultQuotaErrorFile = "/app/errors/429.http"
+
+	quotaDomainsTable     = "quota_domains"
+	quotaTenantsTable     = "quota_tenants"
+	quotaTableOptions     = "type string len 128 size 100k expire 1d store http_req_rate(1d),bytes_out_rate(1d)"
+	quotaMetricsInterval  = time.Second * 30
+	quotaKindDomain       = "domain"
+	quotaKindTenant       = "tenant"
+	quotaDomainStickIndex = 1
+	quotaTenantStickIndex = 2
+)
+
+// QuotaUsage is the traffic of a domain or tenant in the last 24 hours.
+type QuotaUsage struct {
+	Kind      string `json:"kind"` // domain|tenant
+	Key       string `json:"key"`  // Domain or tenant name
+	Requests  int64  `json:"requests"`
+	Bandwidth int64  `json:"bandwidth"` // Response bytes
+}
+
+// QuotaInspector provides the traffic that is counted for quotas.
+type QuotaInspector interface {
+	// QuotaUsage returns the traffic of all domains and tenants that have a quota.
+	QuotaUsage() ([]QuotaUsage, error)
+}
+
+// applyTenantQuotas returns a copy of the given services in which the quota
+// of the tenant of each service is set.
+func (s *Service) applyTenantQuotas(services backend.ServiceRegistrations) backend.ServiceRegistrations {
+	if len(s.TenantQuotas) == 0 {
+		return services
+	}
+	result := make(backend.ServiceRegistrations, 0, len(services))
+	for _, sr := range services {
+		if sr.Tenant != "" {
+			sr.TenantQuota = s.TenantQuotas[sr.Tenant]
+		}
+		result = append(result, sr)
+	}
+	return result
+}
+
+// createQuotaTables creates the stick tables that count the traffic per domain & tenant
+// and sets the error page of exceeded quotas, if any of the given services has a quota.
+func (s *Service) createQuotaTables(c *haproxy.Config, services backend.ServiceRegistrations) {
+	domains, tenants := false, false
+	for _, sr := range services {
+		if !sr.IsHttp() {
+			continue
+		}
+		tenants = tenants || sr.TenantQuota.IsEnabled()
+		for _, sel := range sr.Selectors {
+			domains = domains || sel.Quota.IsEnabled()
+		}
+	}
+	if !domains && !tenants {
+		return
+	}
+	errorFile := s.QuotaErrorFile
+	if errorFile == "" {
+		errorFile = DefaultQuotaErrorFile
+	}
+	c.Section("defaults").Add(fmt.Sprintf("errorfile 429 %s", errorFile))
+	if domains {
+		c.Section("backend " + quotaDomainsTable).Add("stick-table " + quotaTableOptions)
+	}
+	if tenants {
+		c.Section("backend " + quotaTenantsTable).Add("stick-table " + quotaTableOptions)
+	}
+}
+
+// addQuotas adds rules that count the requests matching the given conditions
+// and refuse them (429) when the quota of their domain or tenant is exceeded.
+// Like use_backend, the first matching selector determines the counter of a request.
+func addQuotas(section *haproxy.Section, block useBlock, conditions string) {
+	if block.Quota.IsEnabled() {
+		section.Add(fmt.Sprintf("http-request track-sc%d var(txn.host) table %s if %s", quotaDomainStickIndex, quotaDomainsTable, conditions))
+		addQuotaDenies(section, quotaDomainStickIndex, block.Quota, conditions)
+	}
+	if block.TenantQuota.IsEnabled() {
+		section.Add(fmt.Sprintf("http-request track-sc%d str(%s) table %s if %s", quotaTenantStickIndex, block.Tenant, quotaTenantsTable, conditions))
+		addQuotaDenies(section, quotaTenantStickIndex, block.TenantQuota, conditions)
+	}
+}
+
+func addQuotaDenies(section *haproxy.Section, index int, q backend.Quota, conditions string) {
+	if q.RequestsPerDay > 0 {
+		section.Add(fmt.Sprintf("http-request deny deny_status 429 if %s { sc%d_http_req_rate gt %d }", conditions, index, q.RequestsPerDay))
+	}
+	if q.BandwidthPerDay > 0 {
+		section.Add(fmt.Sprintf("http-request deny deny_status 429 if %s { sc%d_bytes_out_rate gt %d }", conditions, index, q.BandwidthPerDay))
+	}
+}
+
+// QuotaUsage returns the traffic of all domains and tenants that have a quota.
+func (s *Service) QuotaUsage() ([]QuotaUsage, error) {
+	client, err := s.runtimeClient()
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	result := []QuotaUsage{}
+	for _, t := range []struct{ kind, table string }{{quotaKindDomain, quotaDomainsTable}, {quotaKindTenant, quotaTenantsTable}} {
+		entries, err := client.ShowTable(t.table)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		for _, e := range entries {
+			result = append(result, QuotaUsage{
+				Kind:      t.kind,
+				Key:       e.Key,
+				Requests:  e.Values["http_req_rate"],
+				Bandwidth: e.Values["bytes_out_rate"],
+			})
+		}
+	}
+	return result, nil
+}
+
+// quotaMetricsLoop periodically exposes the traffic counted for quotas as metrics.
+func (s *Service) quotaMetricsLoop(ctx context.Context) {
+	for {
+		usage, err := s.QuotaUsage()
+		if err != nil {
+			s.Logger.Debugf("Failed to fetch quota usage: %#v", err)
+		} else {
+			quotaRequests.Reset()
+			quotaBandwidth.Reset()
+			for _, u := range usage {
+				quotaRequests.WithLabelValues(u.Kind, u.Key).Set(float64(u.Requests))
+				quotaBandwidth.WithLabelValues(u.Kind, u.Key).Set(float64(u.Bandwidth))
+			}
+		}
+		if !sleep(ctx, quotaMetricsInterval) {
+			return
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// RealIPConfig configures how the IP address of clients is determined when the load-balancer sits
+// behind a CDN (e.g. CloudFlare) or another load-balancer.
+// The extracted address replaces the source address, so it is used by ACLs, rate limiting & banning,
+// X-Forwarded-For and logs.
+type RealIPConfig struct {
+	Header         string   // If set, the client IP is taken from this request header (e.g. CF-Connecting-IP or X-Real-IP)
+	ProxyProtocol  bool     // If set, connections start with a PROXY protocol header containing the client IP
+	TrustedProxies []string // IP addresses or CIDR ranges of the proxies (required with Header, empty means all sources are trusted)
+	Ports          []int    // Edge ports of the public frontends the client IP is extracted on (empty means all)
+}
+
+// IsEnabled returns true if the client IP must be extracted.
+func (c RealIPConfig) IsEnabled() bool {
+	return c.Header != "" || c.ProxyProtocol
+}
+
+// Validate checks the configuration for errors.
+func (c RealIPConfig) Validate() error {
+	if strings.ContainsAny(c.Header, " \t:") {
+		return maskAny(fmt.Errorf("Invalid real IP header '%s'", c.Header))
+	}
+	if c.Header != "" && len(c.TrustedProxies) == 0 {
+		// Otherwise any client can forge its source address
+		return maskAny(fmt.Errorf("A real IP header requires at least one trusted proxy"))
+	}
+	for _, source := range c.TrustedProxies {
+		if net.ParseIP(source) == nil {
+			if _, _, err := net.ParseCIDR(source); err != nil {
+				return maskAny(fmt.Errorf("Invalid trusted proxy '%s', expected an IP address or CIDR range", source))
+			}
+		}
+	}
+	return nil
+}
+
+// appliesTo returns true if the client IP must be extracted on the frontend with given (edge) port.
+func (c RealIPConfig) appliesTo(port int, public bool) bool {
+	if !c.IsEnabled() || !public {
+		return false
+	}
+	if len(c.Ports) == 0 {
+		return true
+	}
+	for _, p := range c.Ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// realIPBindOption returns the option of the bind line of a frontend with given (edge) port
+// that accepts the PROXY protocol from all sources (if needed).
+func (s *Service) realIPBindOption(port int, public bool) string {
+	if !s.RealIP.ProxyProtocol || !s.RealIP.appliesTo(port, public) || len(s.RealIP.TrustedProxies) > 0 {
+		return ""
+	}
+	return " accept-proxy"
+}
+
+// createRealIPRules creates the rules of a frontend with given (edge) port that replace the source address
+// by the address of the client, as reported by trusted proxies.
+func (s *Service) createRealIPRules(port int, public, isHTTP bool) []string {
+	config := s.RealIP
+	if !config.appliesTo(port, public) {
+		return nil
+	}
+	var result []string
+	trusted := ""
+	if len(config.TrustedProxies) > 0 {
+		trusted = fmt.Sprintf(" { src %s }", strings.Join(config.TrustedProxies, " "))
+		if config.ProxyProtocol {
+			// Only trusted proxies send a PROXY protocol header
+			result = append(result, "tcp-request connection expect-proxy layer4 if"+trusted)
+		}
+	}
+	if config.Header != "" && isHTTP && trusted != "" {
+		// Without trusted proxies, any client could forge its source address
+		result = append(result, fmt.Sprintf("http-request set-src hdr(%s) if%s { req.hdr(%s) -m found }", config.Header, trusted, config.Header))
+	}
+	return result
+}
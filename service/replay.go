@@ -0,0 +1,392 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/pulcy/robin/service/backend"
+)
+
+const (
+	replayServiceHeader = "X-Robin-Replay-Service"
+	replayPathHeader    = "X-Robin-Replay-Path"
+	replayStartTimeout  = time.Second * 10
+	replayErrorsFolder  = "/app/errors/"
+)
+
+var (
+	bindRegexp      = regexp.MustCompile(`(?m)^(\s+bind )[^\s]*:([0-9]+)`)
+	errorFileRegexp = regexp.MustCompile(regexp.QuoteMeta(replayErrorsFolder) + `([0-9]{3})\.http`)
+)
+
+// ReplaySpec declares the services of a routing test and the synthetic requests fired at them.
+type ReplaySpec struct {
+	Services backend.ServiceRegistrations `json:"services"`
+	Requests []ReplayRequest              `json:"requests"`
+}
+
+// ParseReplaySpec parses a spec in YAML or JSON format.
+func ParseReplaySpec(raw []byte) (ReplaySpec, error) {
+	var spec ReplaySpec
+	if err := yaml.Unmarshal(raw, &spec); err != nil {
+		return ReplaySpec{}, maskAny(err)
+	}
+	return spec, nil
+}
+
+// ReplayRequest is a synthetic request with the expected outcome.
+type ReplayRequest struct {
+	Name     string            `json:"name,omitempty"`
+	Method   string            `json:"method,omitempty"` // Defaults to GET
+	Host     string            `json:"host"`
+	Path     string            `json:"path,omitempty"`   // Defaults to /
+	Scheme   string            `json:"scheme,omitempty"` // http|https (defaults to http)
+	Private  bool              `json:"private,omitempty"`
+	User     string            `json:"user,omitempty"` // If set, basic authentication is used
+	Password string            `json:"password,omitempty"`
+	Expect   ReplayExpectation `json:"expect"`
+}
+
+// ReplayExpectation describes the expected outcome of a request. Empty fields are not checked.
+type ReplayExpectation struct {
+	Status   int    `json:"status,omitempty"`
+	Service  string `json:"service,omitempty"`  // Name of the service that receives the request
+	Path     string `json:"path,omitempty"`     // Path as received by the service
+	Location string `json:"location,omitempty"` // Location header of a redirect
+}
+
+// ReplayResult is the actual outcome of a request.
+type ReplayResult struct {
+	Request  ReplayRequest `json:"request"`
+	Status   int           `json:"status"`
+	Service  string        `json:"service,omitempty"`
+	Path     string        `json:"path,omitempty"`
+	Location string        `json:"location,omitempty"`
+	Failures []string      `json:"failures,omitempty"`
+}
+
+// Passed returns true if the outcome of the request met all expectations.
+func (r ReplayResult) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+// Replay renders the config for the services of the given spec, with all instances replaced by stub
+// backends, runs HAProxy with it on ephemeral ports and fires the requests of the spec at it.
+func (s *Service) Replay(spec ReplaySpec) ([]ReplayResult, error) {
+	tempDir, err := ioutil.TempDir("", "robin-replay")
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Start a stub backend for every service
+	services := make(backend.ServiceRegistrations, 0, len(spec.Services))
+	for _, sr := range spec.Services {
+		stub, err := startReplayStub(sr.ServiceName)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		defer stub.Close()
+		sr.Instances = backend.ServiceInstances{
+			backend.ServiceInstance{IP: "127.0.0.1", Port: stub.Addr().(*net.TCPAddr).Port},
+		}
+		services = append(services, sr.Normalize())
+	}
+
+	// Render the config
+	config, err := s.renderConfig(services)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if err := writeReplayErrorFiles(tempDir, config); err != nil {
+		return nil, maskAny(err)
+	}
+	config = strings.Replace(config, replayErrorsFolder, tempDir+"/", -1)
+	filesReplacer, err := s.writeReplayFiles(tempDir)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	config = filesReplacer.Replace(config)
+	config, ports, err := bindEphemeralPorts(config)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	confPath := filepath.Join(tempDir, "haproxy.cfg")
+	if err := ioutil.WriteFile(confPath, []byte(config), confPerm); err != nil {
+		return nil, maskAny(err)
+	}
+
+	// Run haproxy in the foreground
+	var output bytes.Buffer
+	cmd := exec.Command(s.HaproxyPath, "-db", "-f", confPath)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		return nil, maskAny(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	if err := waitForPorts(ports, replayStartTimeout); err != nil {
+		return nil, maskAny(fmt.Errorf("haproxy did not start: %v\n%s", err, output.String()))
+	}
+
+	results := []ReplayResult{}
+	for _, req := range spec.Requests {
+		results = append(results, replayRequest(req, ports))
+	}
+	return results, nil
+}
+
+// startReplayStub starts an HTTP server that reports the name of the given service
+// and the path of each request in its response headers.
+func startReplayStub(serviceName string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	go http.Serve(listener, http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set(replayServiceHeader, serviceName)
+		res.Header().Set(replayPathHeader, req.URL.Path)
+		res.WriteHeader(http.StatusOK)
+	}))
+	return listener, nil
+}
+
+// writeReplayErrorFiles writes the error files referenced by the given config into the given folder.
+func writeReplayErrorFiles(folder, config string) error {
+	for _, code := range replayErrorCodes(config) {
+		content := fmt.Sprintf("HTTP/1.0 %d %s\r\nCache-Control: no-cache\r\nConnection: close\r\nContent-Type: text/plain\r\n\r\n%s\n", code, http.StatusText(code), http.StatusText(code))
+		if err := ioutil.WriteFile(filepath.Join(folder, fmt.Sprintf("%d.http", code)), []byte(content), confPerm); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// writeReplayFiles writes the map files, SPOE configs & Lua scripts of the last rendered config
+// and (empty) files for the configured blocklists into the given folder.
+// It returns a replacer that rewrites their paths in the config to those in the given folder.
+func (s *Service) writeReplayFiles(folder string) (*strings.Replacer, error) {
+	files := make(map[string]string) // original path -> content
+	for path, lines := range s.lastMapFiles {
+		files[path] = strings.Join(lines, "\n") + "\n"
+	}
+	for path, lines := range s.lastSpoeConfigs {
+		files[path] = strings.Join(lines, "\n") + "\n"
+	}
+	for path, source := range s.lastLuaScripts {
+		files[path] = source
+	}
+	for _, bl := range s.Blocklists {
+		files[s.blocklistPath(bl.Name)] = ""
+	}
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	// Replace longer paths first, so a path that ends with another one is rewritten as a whole
+	sort.Sort(pathsByLength(paths))
+	var oldNew []string
+	for i, path := range paths {
+		// Files of different folders can have the same name
+		replayPath := filepath.Join(folder, fmt.Sprintf("%d-%s", i, filepath.Base(path)))
+		if err := ioutil.WriteFile(replayPath, []byte(files[path]), confPerm); err != nil {
+			return nil, maskAny(err)
+		}
+		oldNew = append(oldNew, path, replayPath)
+	}
+	return strings.NewReplacer(oldNew...), nil
+}
+
+// pathsByLength sorts a list of paths by descending length & name.
+type pathsByLength []string
+
+func (l pathsByLength) Len() int      { return len(l) }
+func (l pathsByLength) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l pathsByLength) Less(i, j int) bool {
+	if len(l[i]) != len(l[j]) {
+		return len(l[i]) > len(l[j])
+	}
+	return l[i] < l[j]
+}
+
+// replayErrorCodes returns the status codes of all error files in the errors folder that are
+// referenced by the given config.
+func replayErrorCodes(config string) []int {
+	seen := make(map[int]struct{})
+	var codes []int
+	for _, m := range errorFileRegexp.FindAllStringSubmatch(config, -1) {
+		code, _ := strconv.Atoi(m[1])
+		if _, ok := seen[code]; !ok {
+			seen[code] = struct{}{}
+			codes = append(codes, code)
+		}
+	}
+	sort.Ints(codes)
+	return codes
+}
+
+// bindEphemeralPorts rewrites all `bind` lines of the given config to listen on a free port of
+// the loopback interface. It returns the rewritten config and a map from original port to new port.
+func bindEphemeralPorts(config string) (string, map[int]int, error) {
+	ports := make(map[int]int)
+	var bindErr error
+	config = bindRegexp.ReplaceAllStringFunc(config, func(line string) string {
+		m := bindRegexp.FindStringSubmatch(line)
+		port, _ := strconv.Atoi(m[2])
+		local, ok := ports[port]
+		if !ok {
+			var err error
+			local, err = freePort()
+			if err != nil {
+				bindErr = err
+				return line
+			}
+			ports[port] = local
+		}
+		return fmt.Sprintf("%s127.0.0.1:%d", m[1], local)
+	})
+	if bindErr != nil {
+		return "", nil, maskAny(bindErr)
+	}
+	return config, ports, nil
+}
+
+// freePort returns a port on the loopback interface that is currently not in use.
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, maskAny(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// waitForPorts waits until all given (local) ports accept connections.
+func waitForPorts(ports map[int]int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for _, port := range ports {
+		addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+		for {
+			conn, err := net.DialTimeout("tcp", addr, time.Second)
+			if err == nil {
+				conn.Close()
+				break
+			}
+			if time.Now().After(deadline) {
+				return maskAny(err)
+			}
+			time.Sleep(time.Millisecond * 100)
+		}
+	}
+	return nil
+}
+
+// replayRequest fires the given request at the frontend it targets and checks the outcome.
+func replayRequest(req ReplayRequest, ports map[int]int) ReplayResult {
+	result := ReplayResult{Request: req}
+	scheme := req.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	port := PublicHttpPort
+	if req.Private {
+		port = PrivateHttpPort
+	} else if scheme == "https" {
+		port = PublicHttpsPort
+	}
+	local, ok := ports[port]
+	if !ok {
+		result.Failures = append(result.Failures, fmt.Sprintf("config has no frontend on port %d", port))
+		return result
+	}
+	method := req.Method
+	if method == "" {
+		method = "GET"
+	}
+	path := req.Path
+	if path == "" {
+		path = "/"
+	}
+	httpReq, err := http.NewRequest(method, fmt.Sprintf("%s://127.0.0.1:%d%s", scheme, local, path), nil)
+	if err != nil {
+		result.Failures = append(result.Failures, err.Error())
+		return result
+	}
+	httpReq.Host = req.Host
+	if req.User != "" {
+		httpReq.SetBasicAuth(req.User, req.Password)
+	}
+	client := &http.Client{
+		Timeout: time.Second * 10,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				ServerName:         req.Host,
+				InsecureSkipVerify: true, // Certificates are not under test
+			},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Do(httpReq)
+	if err != nil {
+		result.Failures = append(result.Failures, err.Error())
+		return result
+	}
+	res.Body.Close()
+	result.Status = res.StatusCode
+	result.Service = res.Header.Get(replayServiceHeader)
+	result.Path = res.Header.Get(replayPathHeader)
+	result.Location = res.Header.Get("Location")
+	result.Failures = checkReplayExpectation(req.Expect, result)
+	return result
+}
+
+// checkReplayExpectation returns a description of every expectation that is not met by the given result.
+func checkReplayExpectation(expect ReplayExpectation, result ReplayResult) []string {
+	var failures []string
+	if expect.Status != 0 && expect.Status != result.Status {
+		failures = append(failures, fmt.Sprintf("expected status %d, got %d", expect.Status, result.Status))
+	}
+	if expect.Service != "" && expect.Service != result.Service {
+		failures = append(failures, fmt.Sprintf("expected service '%s', got '%s'", expect.Service, result.Service))
+	}
+	if expect.Path != "" && expect.Path != result.Path {
+		failures = append(failures, fmt.Sprintf("expected path '%s', got '%s'", expect.Path, result.Path))
+	}
+	if expect.Location != "" && expect.Location != result.Location {
+		failures = append(failures, fmt.Sprintf("expected location '%s', got '%s'", expect.Location, result.Location))
+	}
+	return failures
+}
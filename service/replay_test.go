@@ -0,0 +1,103 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBindEphemeralPorts(t *testing.T) {
+	config := "frontend public_http_in_80\n    bind *:80\n\nfrontend secure-public_http_in_80\n    bind *:443 ssl crt /certs/ no-sslv3\n\nfrontend private_http_in_81\n    bind 10.0.0.1:81\n"
+	result, ports, err := bindEphemeralPorts(config)
+	if err != nil {
+		t.Fatalf("bindEphemeralPorts failed: %#v", err)
+	}
+	if len(ports) != 3 {
+		t.Fatalf("Expected 3 ports, got %#v", ports)
+	}
+	for _, expected := range []string{
+		fmt.Sprintf("    bind 127.0.0.1:%d\n", ports[80]),
+		fmt.Sprintf("    bind 127.0.0.1:%d ssl crt /certs/ no-sslv3\n", ports[443]),
+		fmt.Sprintf("    bind 127.0.0.1:%d\n", ports[81]),
+	} {
+		if !strings.Contains(result, expected) {
+			t.Errorf("Expected `%s` in %s", expected, result)
+		}
+	}
+}
+
+func TestCheckReplayExpectation(t *testing.T) {
+	result := ReplayResult{Status: 200, Service: "web", Path: "/v1"}
+	if failures := checkReplayExpectation(ReplayExpectation{Status: 200, Service: "web", Path: "/v1"}, result); len(failures) != 0 {
+		t.Errorf("Expected no failures, got %#v", failures)
+	}
+	if failures := checkReplayExpectation(ReplayExpectation{Status: 302, Service: "api"}, result); len(failures) != 2 {
+		t.Errorf("Expected 2 failures, got %#v", failures)
+	}
+}
+
+func TestParseReplaySpec(t *testing.T) {
+	for _, raw := range []string{
+		"services:\n- ServiceName: web\n  ServicePort: 80\n  EdgePort: 80\n  Public: true\n  Selectors:\n  - Domain: foo.com\nrequests:\n- host: foo.com\n  path: /v1\n  expect:\n    status: 200\n    service: web\n",
+		`{"services":[{"ServiceName":"web","ServicePort":80,"EdgePort":80,"Public":true,"Selectors":[{"Domain":"foo.com"}]}],"requests":[{"host":"foo.com","path":"/v1","expect":{"status":200,"service":"web"}}]}`,
+	} {
+		spec, err := ParseReplaySpec([]byte(raw))
+		if err != nil {
+			t.Fatalf("ParseReplaySpec failed: %#v", err)
+		}
+		if len(spec.Services) != 1 || spec.Services[0].ServiceName != "web" || spec.Services[0].Selectors[0].Domain != "foo.com" {
+			t.Errorf("Unexpected services %#v", spec.Services)
+		}
+		if len(spec.Requests) != 1 || spec.Requests[0].Path != "/v1" || spec.Requests[0].Expect.Service != "web" {
+			t.Errorf("Unexpected requests %#v", spec.Requests)
+		}
+	}
+}
+
+func TestReplayErrorCodes(t *testing.T) {
+	config := "defaults\n    errorfile 400 /app/errors/400.http\n    errorfile 503 /app/errors/503.http\n    errorfile 429 /app/errors/429.http\n\nbackend fallback\n    errorfile 503 /app/errors/404.http\n    errorfile 503 /app/errors/503.http\n"
+	codes := replayErrorCodes(config)
+	if fmt.Sprintf("%v", codes) != "[400 404 429 503]" {
+		t.Errorf("Unexpected codes %v", codes)
+	}
+}
+
+func TestWriteReplayFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "robin-replay-test")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %#v", err)
+	}
+	defer os.RemoveAll(dir)
+	s := &Service{ServiceConfig: ServiceConfig{MapFilesFolder: "/maps", Blocklists: []Blocklist{{Name: "tor"}}}}
+	s.lastMapFiles = map[string][]string{"/maps/http_in_80.map": {"foo.com web"}}
+	s.lastSpoeConfigs = map[string][]string{"/etc/haproxy/spoe-waf.conf": {"[waf]"}}
+	s.lastLuaScripts = map[string]string{"/etc/haproxy/lua/hello-1234.lua": "core.Info('hello')"}
+	config := "global\n    lua-load /etc/haproxy/lua/hello-1234.lua\n\nfrontend http_in_80\n    filter spoe engine waf config /etc/haproxy/spoe-waf.conf\n    http-request deny if { src -f /maps/blocklist-tor.acl }\n    use_backend %[req.hdr(host),lower,map(/maps/http_in_80.map)]\n"
+	replacer, err := s.writeReplayFiles(dir)
+	if err != nil {
+		t.Fatalf("writeReplayFiles failed: %#v", err)
+	}
+	result := replacer.Replace(config)
+	expected := map[string]string{
+		"/maps/http_in_80.map":            "foo.com web\n",
+		"/etc/haproxy/spoe-waf.conf":      "[waf]\n",
+		"/etc/haproxy/lua/hello-1234.lua": "core.Info('hello')",
+		"/maps/blocklist-tor.acl":         "",
+	}
+	for path, content := range expected {
+		if strings.Contains(result, path) {
+			t.Errorf("Expected %s to be rewritten, got\n%s", path, result)
+		}
+		files, _ := filepath.Glob(filepath.Join(dir, "*-"+filepath.Base(path)))
+		if len(files) != 1 || !strings.Contains(result, files[0]) {
+			t.Errorf("Expected config to refer to a single copy of %s, got %v in\n%s", path, files, result)
+			continue
+		}
+		if raw, err := ioutil.ReadFile(files[0]); err != nil || string(raw) != content {
+			t.Errorf("Unexpected content of %s: '%s' (%v)", files[0], string(raw), err)
+		}
+	}
+}
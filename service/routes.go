@@ -0,0 +1,136 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pulcy/robin/service/backend"
+)
+
+// Route describes a single selector of a service as it is used in a frontend.
+type Route struct {
+	Frontend    string `json:"frontend"`
+	Domain      string `json:"domain,omitempty"`
+	PathPrefix  string `json:"path-prefix,omitempty"`
+	Weight      int    `json:"weight,omitempty"`
+	Service     string `json:"service"`
+	ServicePort int    `json:"service-port"`
+	Backend     string `json:"backend"`
+}
+
+// ConfigInspector provides insight in the generated configuration.
+type ConfigInspector interface {
+	// Routes returns the routes of the current configuration, per frontend in order of precedence.
+	Routes() []Route
+	// Conflicts returns the routes of the current configuration that can never be reached.
+	Conflicts() []RouteConflict
+	// SimulateRoute returns how the given request is handled by the current configuration.
+	SimulateRoute(req RouteRequest) RouteMatch
+	// OldProcesses returns the HAProxy processes that have been replaced by a reload,
+	// together with the connections they still serve.
+	OldProcesses() []OldProcess
+	// Services returns the services of the current configuration.
+	Services() backend.ServiceRegistrations
+	// Backends returns the backends of the current configuration, sorted by name.
+	Backends() []Backend
+	// Config returns the content of the HAProxy configuration that is currently in use.
+	Config() string
+}
+
+// createSelectorServicePairs returns all selectors of the services served by the given frontend,
+// sorted by precedence.
+func createSelectorServicePairs(services backend.ServiceRegistrations, selection frontend) selectorServicePairs {
+	pairs := selectorServicePairs{}
+	for _, sr := range services {
+		if sr.IsMail() != selection.IsMail() || (sr.IsMail() && sr.EdgePort != selection.Port) {
+			// Mail services are only served on their own port
+			continue
+		}
+		if sr.IsHttp() == selection.IsHTTP() && sr.Public == selection.Public {
+			for selIndex, sel := range sr.Selectors {
+				pairs = append(pairs, selectorServicePair{
+					Selector:      sel,
+					SelectorIndex: selIndex,
+					Service:       sr,
+				})
+			}
+		}
+	}
+	sort.Sort(pairs)
+	return pairs
+}
+
+// hasPrecedence returns true if selector a must be evaluated before selector b.
+// The rules (in order) are:
+// - A selector with a higher weight wins (use the weight to override the rules below).
+// - A selector without a domain wins over a selector with a domain.
+// - A selector with a longer path prefix wins.
+// - A selector with an exact domain wins over a selector with a wildcard domain.
+// Remaining ties are ordered by the full string representation, so the order is deterministic.
+func hasPrecedence(a, b selectorServicePair) bool {
+	sa, sb := a.Selector, b.Selector
+	if sa.Weight != sb.Weight {
+		return sa.Weight > sb.Weight
+	}
+	if (sa.Domain == "") != (sb.Domain == "") {
+		return sa.Domain == ""
+	}
+	if len(sa.PathPrefix) != len(sb.PathPrefix) {
+		return len(sa.PathPrefix) > len(sb.PathPrefix)
+	}
+	if sa.IsWildcard() != sb.IsWildcard() {
+		return !sa.IsWildcard()
+	}
+	return strings.Compare(sa.FullString()+a.Service.FullString(), sb.FullString()+b.Service.FullString()) < 0
+}
+
+// createRoutes returns the routes of all frontends needed for the given services.
+func (s *Service) createRoutes(services backend.ServiceRegistrations) []Route {
+	services = s.addGatewaySelectors(services)
+	routes := []Route{}
+	for _, f := range s.collectFrontends(services) {
+		for _, pair := range createSelectorServicePairs(services, f) {
+			routes = append(routes, newRoute(f, pair))
+		}
+	}
+	return routes
+}
+
+// newRoute creates a route for the given selector in the given frontend.
+func newRoute(f frontend, pair selectorServicePair) Route {
+	return Route{
+		Frontend:    f.Name(),
+		Domain:      pair.Selector.Domain,
+		PathPrefix:  pair.Selector.PathPrefix,
+		Weight:      pair.Selector.Weight,
+		Service:     pair.Service.ServiceName,
+		ServicePort: pair.Service.ServicePort,
+		Backend:     generateBackendName(pair.Service, f),
+	}
+}
+
+// Routes returns the routes of the current configuration, per frontend in order of precedence.
+func (s *Service) Routes() []Route {
+	routes, _ := s.lastRoutes.Load().([]Route)
+	return routes
+}
+
+// Config returns the content of the HAProxy configuration that is currently in use.
+func (s *Service) Config() string {
+	config, _ := s.lastActiveConfig.Load().(string)
+	return config
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pulcy/robin/haproxy"
+	"github.com/pulcy/robin/service/backend"
+)
+
+const (
+	// sensitiveUserListName is the name of the userlist of users that can access sensitive paths.
+	sensitiveUserListName = "sensitive_paths"
+	// sensitivePathVarName is the name of the variable containing the normalized path that is matched.
+	sensitivePathVarName = "sensitive_path"
+)
+
+var (
+	// DefaultSensitivePaths are the well-known paths of metrics & debug endpoints.
+	DefaultSensitivePaths = []string{"/metrics", "/debug/pprof", "/actuator"}
+)
+
+// SensitivePathsConfig protects sensitive paths (e.g. metrics & debug endpoints) of all services on
+// public frontends, unless a service explicitly exposes them.
+// Requests for these paths are only allowed from the allowed sources or for the users (if any),
+// all other requests are refused.
+type SensitivePathsConfig struct {
+	Paths          []string       // Path prefixes that are protected (empty disables the protection)
+	AllowedSources []string       // IP addresses or CIDR ranges from which sensitive paths can be requested
+	Users          []backend.User // Users that can request sensitive paths (using basic authentication)
+}
+
+// IsEnabled returns true if sensitive paths must be protected.
+func (c SensitivePathsConfig) IsEnabled() bool {
+	return len(c.Paths) > 0
+}
+
+// Validate checks the configuration for errors.
+func (c SensitivePathsConfig) Validate() error {
+	for _, path := range c.Paths {
+		if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, " \t") {
+			return maskAny(fmt.Errorf("Invalid sensitive path '%s'", path))
+		}
+	}
+	for _, source := range c.AllowedSources {
+		if net.ParseIP(source) == nil {
+			if _, _, err := net.ParseCIDR(source); err != nil {
+				return maskAny(fmt.Errorf("Invalid source '%s', expected an IP address or CIDR range", source))
+			}
+		}
+	}
+	for _, user := range c.Users {
+		if user.Name == "" || user.PasswordHash == "" || strings.ContainsAny(user.Name+user.PasswordHash, " \t") {
+			return maskAny(fmt.Errorf("Invalid user '%s'", user.Name))
+		}
+	}
+	return nil
+}
+
+// createSensitivePathsUserList creates the userlist of users that can request sensitive paths (if needed).
+func (s *Service) createSensitivePathsUserList(c *haproxy.Config) {
+	if !s.SensitivePaths.IsEnabled() || len(s.SensitivePaths.Users) == 0 {
+		return
+	}
+	section := c.Section("userlist " + sensitiveUserListName)
+	for _, user := range s.SensitivePaths.Users {
+		section.Add(fmt.Sprintf("user %s password %s", user.Name, user.PasswordHash))
+	}
+}
+
+// createSensitivePathRules creates the rules of a (http mode) backend that protect its sensitive paths.
+// Only backends of public frontends are protected, unless one of their services exposes sensitive paths.
+func (s *Service) createSensitivePathRules(b backendConfig) []string {
+	config := s.SensitivePaths
+	if !config.IsEnabled() || !b.IsPublic() || b.ExposesSensitive() {
+		return nil
+	}
+	// Match on the decoded, lowercase path with collapsed slashes, so encoded or
+	// duplicate characters (e.g. /%6Detrics or //Metrics) cannot bypass the protection
+	rules := []string{fmt.Sprintf("http-request set-var(txn.%s) path,url_dec,lower,regsub(/+,/,g)", sensitivePathVarName)}
+	conditions := fmt.Sprintf("{ var(txn.%s) -m beg %s }", sensitivePathVarName, strings.ToLower(strings.Join(config.Paths, " ")))
+	if len(config.AllowedSources) > 0 {
+		conditions = fmt.Sprintf("%s !{ src %s }", conditions, strings.Join(config.AllowedSources, " "))
+	}
+	if len(config.Users) > 0 {
+		return append(rules, fmt.Sprintf("http-request auth realm %s if %s !{ http_auth(%s) }", sensitiveUserListName, conditions, sensitiveUserListName))
+	}
+	return append(rules, fmt.Sprintf("http-request deny deny_status 403 if %s", conditions))
+}
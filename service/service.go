@@ -16,60 +16,128 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/op/go-logging"
 
+	"github.com/pulcy/robin/haproxy"
+	"github.com/pulcy/robin/service/accounting"
 	"github.com/pulcy/robin/service/acme"
 	"github.com/pulcy/robin/service/backend"
+	"github.com/pulcy/robin/service/prober"
+	"github.com/pulcy/robin/service/sidecar"
 )
 
 const (
 	osExitDelay  = time.Second * 3
 	confPerm     = os.FileMode(0664) // rw-rw-r
 	refreshDelay = time.Second * 5
+
+	scheduleInterval = time.Second * 5
+
+	defaultReloadGracePeriod = time.Second * 10
 )
 
 type ServiceConfig struct {
-	HaproxyConfPath   string
-	HaproxyPath       string
-	HaproxyPidPath    string
-	StatsPort         int
-	StatsUser         string
-	StatsPassword     string
-	StatsSslCert      string
-	PrivateStatsPort  int
-	SslCertsFolder    string
-	ForceSsl          bool
-	PrivateHost       string
-	PublicHost        string
-	PrivateTcpSslCert string // Name of SSL certificate used for private tcp connections
-	ExcludePublic     bool   // If set, all public frontends are excluded
-	ExcludePrivate    bool   // If set, all private frontends are excluded
+	HaproxyConfPath       string
+	HaproxyPath           string
+	HaproxyPidPath        string
+	StatsPort             int
+	StatsUser             string
+	StatsPassword         string
+	StatsSslCert          string
+	PrivateStatsPort      int
+	StatsCompression      bool // If set, the stats page is gzip compressed for clients that accept it
+	SslCertsFolder        string
+	ForceSsl              bool
+	ForceSslExemptPaths   []string // Paths (prefixes) that are not redirected to HTTPS when ForceSsl is set (ACME HTTP challenges never are)
+	PrivateDenyByDefault  bool     // If set, requests on the private HTTP frontend that match no selector are rejected with 403
+	PrivateGateway        bool     // If set, all HTTP services are served on the private HTTP frontend under /svc/<name>/
+	PrivateHost           string
+	PublicHost            string
+	PrivateTcpSslCert     string                   // Name of SSL certificate used for private tcp connections
+	PrivateTcpCrtListPath string                   // Path of crt-list file with per-service certificates for private tcp connections
+	ExcludePublic         bool                     // If set, all public frontends are excluded
+	ExcludePrivate        bool                     // If set, all private frontends are excluded
+	TlsLogAddress         string                   // If set, TLS connection details are logged (syslog over UDP) to this address
+	AccessLog             AccessLogConfig          // Logging of HTTP requests
+	IPBan                 IPBanConfig              // Automatic banning of source IPs with too many failed requests (requires a runtime socket)
+	Blocklists            []Blocklist              // External lists of source IPs that frontend records can refuse
+	SensitivePaths        SensitivePathsConfig     // Protection of sensitive paths (e.g. /metrics) on public frontends
+	RealIP                RealIPConfig             // Extraction of the client IP when behind a CDN or other load-balancer
+	BlocklistInterval     time.Duration            // Interval between fetches of blocklists (0 means DefaultBlocklistInterval)
+	HaproxyVersion        haproxy.Version          // Version of HAProxy to generate directives for (zero means detect & lint only)
+	RuntimeSocketPath     string                   // If set, HAProxy exposes its runtime API on this unix socket
+	ReloadGracePeriod     time.Duration            // Time old HAProxy processes are given to finish their connections after a reload
+	Zone                  string                   // Availability zone of this load-balancer (used by zone-aware services)
+	MaxBackends           int                      // If set, configurations with more backends are refused
+	MaxAclsPerFrontend    int                      // If set, frontends with more ACLs select their backends using a map file
+	MaxConfigSize         int                      // If set, configurations larger than this (in bytes) are refused
+	MapFilesFolder        string                   // Folder in which map files are written
+	CacheSize             int                      // Size (in MB) of each response cache (0 means DefaultCacheSize)
+	SpoeAgents            []SpoeAgent              // Agents that requests & responses can be passed through using filters
+	LuaScriptsFolder      string                   // If set, all Lua scripts in this folder are loaded
+	TenantQuotas          map[string]backend.Quota // Traffic quota per tenant name
+	QuotaErrorFile        string                   // Error page (HTTP response) served when a quota is exceeded (empty means DefaultQuotaErrorFile)
+	HistorySize           int                      // Number of routing changes kept in the history (0 means DefaultHistorySize)
+	DeregistrationGrace   time.Duration            // If set, instances that leave the backend are drained for this period before they are removed
+	BackendTimeout        time.Duration            // Timeout of fetching services from the backend (0 means none)
+	BackendWatchTimeout   time.Duration            // Maximum time a single watch of the backend may take before it is restarted (0 means none)
+	BackendWatchBackoff   WatchBackoffConfig       // Delays between retries of failed watches of the backend
+	TcpPortRange          PortRange                // If set, TCP frontends on ports in this range are served by a single pre-bound frontend
+	ExternalResolvers     []string                 // Nameservers (ip:port) used to resolve external hosts (empty means those of resolv.conf, requires HAProxy 1.9)
+	ExternalCAFile        string                   // File containing the CA certificates that certificates of external hosts are verified against (empty means the system CA's)
 }
 
 type ServiceDependencies struct {
 	Logger      *logging.Logger
 	Backend     backend.Backend
-	AcmeService acme.AcmeService
+	AcmeService acme.AcmeService      // If set, services are extended with ACME certificates
+	Prober      prober.Prober         // If set, instances of services with a probe-type are probed by Robin itself
+	Sidecars    []sidecar.Sidecar     // Processes (e.g. SPOE agents) that run next to HAProxy
+	Accountant  accounting.Accountant // If set, the traffic per service is recorded (requires a runtime socket)
 }
 
 type Service struct {
 	ServiceConfig
 	ServiceDependencies
 
-	signalCounter uint32
-	lastConfig    string
-	lastPid       int
-	changeCounter uint32
+	signalCounter         uint32
+	lastConfig            string
+	lastPrivateTcpCrtList []string
+	lastMapFiles          map[string][]string // map file path -> lines
+	lastSpoeConfigs       map[string][]string // SPOE config file path -> lines
+	luaScripts            map[string]string   // Lua script name -> source (loaded from the backend)
+	lastLuaScripts        map[string]string   // Lua script path -> source
+	lastProbeTargets      atomic.Value        // []prober.Target
+	lastServerRefs        atomic.Value        // []serverRef
+	lastPid               int
+	lastRoutes            atomic.Value // []Route
+	lastActiveConfig      atomic.Value // string (config HAProxy is running with)
+	lastServices          atomic.Value // backend.ServiceRegistrations
+	lastConflicts         atomic.Value // []RouteConflict
+	lastMinInstances      atomic.Value // map[string]int
+	lastBlocklists        atomic.Value // map[string]struct{} (names of used blocklists)
+	lintVersion           haproxy.Version
+	haproxyVersionChecked bool
+	changeCounter         uint32
+	draining              uint32
+	processes             *processTracker
+	bans                  *banList
+	history               *historyBuffer
+	departed              map[string]map[string]departedInstance // registration key -> instance address -> departed instance
+	departedTimer         *time.Timer                            // Triggers an update when the first grace period of departed instances ends
 }
 
 // NewService creates a new service instance.
@@ -80,26 +148,72 @@ func NewService(config ServiceConfig, deps ServiceDependencies) *Service {
 	if config.HaproxyPidPath == "" {
 		config.HaproxyPidPath = "/var/run/haproxy.pid"
 	}
+	if config.PrivateTcpCrtListPath == "" {
+		config.PrivateTcpCrtListPath = filepath.Join(filepath.Dir(config.HaproxyConfPath), "private-tcp-crt-list.txt")
+	}
+	if config.MapFilesFolder == "" {
+		config.MapFilesFolder = filepath.Dir(config.HaproxyConfPath)
+	}
+	if config.ReloadGracePeriod == 0 {
+		config.ReloadGracePeriod = defaultReloadGracePeriod
+	}
 	return &Service{
 		ServiceConfig:       config,
 		ServiceDependencies: deps,
+		processes:           newProcessTracker(),
+		bans:                newBanList(),
+		history:             newHistoryBuffer(config.HistorySize),
 	}
 }
 
 // Run starts the service and waits for OS signals to terminate it.
 func (s *Service) Run() {
-	go s.backendMonitorLoop()
-	go s.configLoop()
+	s.Start(context.Background())
+	s.listenSignals()
+}
+
+// Start runs the service in the background until the given context is canceled.
+// Unlike Run, it does not handle OS signals, so the service can be embedded in another program.
+func (s *Service) Start(ctx context.Context) {
+	if s.Prober != nil {
+		s.Prober.Start()
+	}
+	for _, sc := range s.Sidecars {
+		sc.Start()
+	}
+	go s.backendMonitorLoop(ctx)
+	if len(s.Blocklists) > 0 {
+		go s.blocklistLoop(ctx)
+	}
+	go s.configLoop(ctx)
+	if sch, ok := s.Backend.(backend.Scheduler); ok {
+		go s.scheduleLoop(ctx, sch)
+	}
+	if s.RuntimeSocketPath != "" {
+		go s.quotaMetricsLoop(ctx)
+		if s.Accountant != nil {
+			go s.accountingLoop(ctx)
+		}
+		if s.IPBan.IsEnabled() {
+			go s.ipBanLoop(ctx)
+		}
+	}
 	go func() {
-		time.Sleep(time.Second)
-		s.TriggerUpdate()
+		if sleep(ctx, time.Second) {
+			s.TriggerUpdate()
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		for _, sc := range s.Sidecars {
+			sc.Stop()
+		}
 	}()
-	s.listenSignals()
 }
 
 // configLoop updates the haproxy config, and then waits
 // for changes in the backend.
-func (s *Service) configLoop() {
+func (s *Service) configLoop(ctx context.Context) {
 	var lastChangeCounter uint32
 	for {
 		currentChangeCounter := atomic.LoadUint32(&s.changeCounter)
@@ -111,20 +225,75 @@ func (s *Service) configLoop() {
 				lastChangeCounter = currentChangeCounter
 			}
 		}
-		select {
-		case <-time.After(refreshDelay):
+		if !sleep(ctx, refreshDelay) {
+			return
 		}
 	}
 }
 
 // backendMonitorLoop monitors the configuration backend for changes.
 // When it detects a change, it set a dirty flag.
-func (s *Service) backendMonitorLoop() {
+// Failed watches are retried with an exponential backoff.
+func (s *Service) backendMonitorLoop(ctx context.Context) {
+	backoff := newWatchBackoff(s.BackendWatchBackoff)
+	for ctx.Err() == nil {
+		watchCtx, cancel := withTimeout(ctx, s.BackendWatchTimeout)
+		err := s.Backend.Watch(watchCtx)
+		failed := err != nil && watchCtx.Err() == nil
+		cancel()
+		s.TriggerUpdate()
+		if !failed {
+			if backoff.isOpen() {
+				s.Logger.Infof("Watching backend changes recovered after %d failures", backoff.failures)
+			}
+			backoff.succeeded()
+			continue
+		}
+		wasOpen := backoff.isOpen()
+		delay := backoff.failed()
+		switch {
+		case wasOpen:
+			s.Logger.Debugf("Failed to watch for backend changes (retry in %s): %#v", delay, err)
+		case backoff.isOpen():
+			s.Logger.Errorf("Failed to watch for backend changes %d times in a row, logging no more failures until it recovers (retry in %s): %#v", backoff.failures, delay, err)
+		default:
+			s.Logger.Errorf("Failed to watch for backend changes (retry in %s): %#v", delay, err)
+		}
+		if !sleep(ctx, delay) {
+			return
+		}
+	}
+}
+
+// withTimeout returns a child of the given context that expires after the given timeout (0 means never).
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// sleep waits for the given duration.
+// It returns false when the given context is canceled before that.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// scheduleLoop periodically applies scheduled changes that are due.
+// The resulting backend changes are picked up by backendMonitorLoop.
+func (s *Service) scheduleLoop(ctx context.Context, sch backend.Scheduler) {
 	for {
-		if err := s.Backend.Watch(); err != nil {
-			s.Logger.Errorf("Failed to watch for backend changes: %#v", err)
+		if err := sch.ApplyDueChanges(time.Now()); err != nil {
+			s.Logger.Errorf("Failed to apply scheduled changes: %#v", err)
+		}
+		if !sleep(ctx, scheduleInterval) {
+			return
 		}
-		s.TriggerUpdate()
 	}
 }
 
@@ -149,6 +318,31 @@ func (s *Service) updateHaproxy() error {
 	// Cleanup afterwards
 	defer os.Remove(tempConf)
 
+	// Write crt-list of the private TCP SSL frontend (used by the config)
+	if err := s.writePrivateTcpCrtList(); err != nil {
+		return maskAny(err)
+	}
+
+	// Write map files (used by the config)
+	if err := s.writeMapFiles(); err != nil {
+		return maskAny(err)
+	}
+
+	// Write SPOE config files (used by the config)
+	if err := s.writeSpoeConfigs(); err != nil {
+		return maskAny(err)
+	}
+
+	// Create blocklist files that have not been fetched yet (used by the config)
+	if err := s.ensureBlocklistFiles(); err != nil {
+		return maskAny(err)
+	}
+
+	// Write Lua scripts (loaded by the config)
+	if err := s.writeLuaScripts(); err != nil {
+		return maskAny(err)
+	}
+
 	// Validate the config
 	if err := s.validateConfig(tempConf, config); err != nil {
 		s.Logger.Errorf("haproxy config validation failed: %#v", err)
@@ -169,6 +363,21 @@ func (s *Service) updateHaproxy() error {
 
 	// Rember the current config
 	s.lastConfig = config
+	s.lastActiveConfig.Store(config)
+
+	// Map files of frontends that no longer exceed the ACL limit (or no longer exist) are not used anymore
+	s.removeStaleMapFiles()
+
+	// The restart resets all server states, drain them again or let the prober push them again
+	if s.IsDraining() {
+		client, _ := s.runtimeClient()
+		if err := s.setDrainState(client, true); err != nil {
+			s.Logger.Errorf("Failed to drain servers after restart: %#v", err)
+		}
+	} else if s.Prober != nil {
+		targets, _ := s.lastProbeTargets.Load().([]prober.Target)
+		s.Prober.SetTargets(targets)
+	}
 
 	s.Logger.Infof("Restarted haproxy")
 
@@ -179,17 +388,25 @@ func (s *Service) updateHaproxy() error {
 // It returns the path of the new config file.
 func (s *Service) createConfigFile() (string, string, error) {
 	// Fetch data from backend
-	services, err := s.Backend.Services()
+	ctx, cancel := withTimeout(context.Background(), s.BackendTimeout)
+	defer cancel()
+	services, err := s.Backend.Services(ctx)
 	if err != nil {
 		return "", "", maskAny(err)
 	}
-
-	// Extend with ACME info
-	services, err = s.AcmeService.Extend(services)
+	s.luaScripts, err = s.Backend.LuaScripts(ctx)
 	if err != nil {
 		return "", "", maskAny(err)
 	}
 
+	// Extend with ACME info
+	if s.AcmeService != nil {
+		services, err = s.AcmeService.Extend(ctx, services)
+		if err != nil {
+			return "", "", maskAny(err)
+		}
+	}
+
 	// Normalize services
 	for i, s := range services {
 		services[i] = s.Normalize()
@@ -198,11 +415,33 @@ func (s *Service) createConfigFile() (string, string, error) {
 	// Sort the services
 	services.Sort()
 
+	// Keep instances that left the backend (drained) during the deregistration grace period
+	services = s.addDepartedInstances(services, time.Now())
+
 	// Render the content of the haproxy.cfg file
 	config, err := s.renderConfig(services)
 	if err != nil {
 		return "", "", maskAny(err)
 	}
+	config, err = s.lintConfig(config)
+	if err != nil {
+		return "", "", maskAny(err)
+	}
+	if err := s.checkConfigLimits(config); err != nil {
+		s.Logger.Errorf("haproxy config exceeds limits: %#v", err)
+		return "", "", maskAny(err)
+	}
+	s.lastPrivateTcpCrtList = s.createPrivateTcpCrtList(services)
+	s.lastProbeTargets.Store(s.createProbeTargets(services))
+	s.lastServerRefs.Store(s.createServerRefs(services))
+	s.lastMinInstances.Store(s.createMinInstances(services))
+	s.lastRoutes.Store(s.createRoutes(services))
+	previousServices, _ := s.lastServices.Load().(backend.ServiceRegistrations)
+	s.history.Record(createHistoryEvents(previousServices, services, time.Now()))
+	s.lastServices.Store(services)
+	s.lastBlocklists.Store(s.createUsedBlocklists(services))
+	conflicts := s.detectConflicts(services)
+	s.lastConflicts.Store(conflicts)
 
 	// If nothing has changed, don't do anything
 	if s.lastConfig == config {
@@ -210,7 +449,8 @@ func (s *Service) createConfigFile() (string, string, error) {
 		return config, "", nil
 	}
 
-	// Log services
+	// Log services & conflicting routes
+	s.reportConflicts(conflicts)
 	s.Logger.Infof("Found %d services", len(services))
 	for srvIndex, srv := range services {
 		s.Logger.Debugf("Service %d: %#v", srvIndex, srv)
@@ -228,6 +468,91 @@ func (s *Service) createConfigFile() (string, string, error) {
 	return config, tempFile.Name(), nil
 }
 
+// lintConfig checks the given config against the version of HAProxy,
+// rewriting incompatible directives where possible.
+func (s *Service) lintConfig(config string) (string, error) {
+	if !s.haproxyVersionChecked {
+		s.haproxyVersionChecked = true
+		version, err := haproxy.DetectVersion(s.HaproxyPath)
+		if err != nil {
+			s.Logger.Warningf("Cannot detect HAProxy version: %#v", err)
+		} else {
+			s.Logger.Infof("Detected HAProxy version %s", version)
+			if s.HaproxyVersion.IsUnknown() {
+				s.lintVersion = version
+			} else if s.HaproxyVersion.Major != version.Major || s.HaproxyVersion.Minor != version.Minor {
+				s.Logger.Warningf("Configured HAProxy version %s differs from detected version %s", s.HaproxyVersion, version)
+			}
+		}
+		if !s.HaproxyVersion.IsUnknown() {
+			s.lintVersion = s.HaproxyVersion
+		}
+	}
+	result, rewrites, err := haproxy.Lint(config, s.lintVersion)
+	if err != nil {
+		s.Logger.Errorf("haproxy config is incompatible with HAProxy %s: %#v", s.lintVersion, err)
+		return "", maskAny(err)
+	}
+	for _, r := range rewrites {
+		s.Logger.Debugf("Rewrote haproxy config %s", r)
+	}
+	return result, nil
+}
+
+// writePrivateTcpCrtList writes the crt-list file containing the per-service
+// certificates of the private TCP SSL frontend.
+func (s *Service) writePrivateTcpCrtList() error {
+	if len(s.lastPrivateTcpCrtList) == 0 {
+		return nil
+	}
+	content := strings.Join(s.lastPrivateTcpCrtList, "\n") + "\n"
+	if err := ioutil.WriteFile(s.PrivateTcpCrtListPath, []byte(content), confPerm); err != nil {
+		s.Logger.Errorf("Cannot write crt-list to %s: %#v", s.PrivateTcpCrtListPath, err)
+		return maskAny(err)
+	}
+	return nil
+}
+
+// writeMapFiles writes the map files used to select backends in frontends with many ACLs.
+func (s *Service) writeMapFiles() error {
+	for path, lines := range s.lastMapFiles {
+		content := strings.Join(lines, "\n") + "\n"
+		if err := ioutil.WriteFile(path, []byte(content), confPerm); err != nil {
+			s.Logger.Errorf("Cannot write map file to %s: %#v", path, err)
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// removeStaleMapFiles removes the map files in the map files folder that are not used by the current config.
+func (s *Service) removeStaleMapFiles() {
+	paths, err := filepath.Glob(filepath.Join(s.MapFilesFolder, mapFileGlob))
+	if err != nil {
+		return
+	}
+	for _, path := range paths {
+		if _, used := s.lastMapFiles[path]; used {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			s.Logger.Warningf("Cannot remove stale map file %s: %#v", path, err)
+		}
+	}
+}
+
+// writeSpoeConfigs writes the config files of the SPOE agents used by filters.
+func (s *Service) writeSpoeConfigs() error {
+	for path, lines := range s.lastSpoeConfigs {
+		content := strings.Join(lines, "\n") + "\n"
+		if err := ioutil.WriteFile(path, []byte(content), confPerm); err != nil {
+			s.Logger.Errorf("Cannot write SPOE config to %s: %#v", path, err)
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
 // validateConfig calls haproxy to validate the given config file.
 func (s *Service) validateConfig(confPath, confContent string) error {
 	cmd := exec.Command(s.HaproxyPath, "-c", "-f", confPath)
@@ -271,6 +596,8 @@ func (s *Service) restartHaproxy() error {
 	}
 	s.lastPid = pid
 	s.Logger.Debugf("haxproxy pid %d started", pid)
+	refs, _ := s.lastServerRefs.Load().([]serverRef)
+	s.processes.started(pid, refs)
 
 	go func() {
 		// Wait for haproxy to terminate so we avoid defunct processes
@@ -279,12 +606,14 @@ func (s *Service) restartHaproxy() error {
 		} else {
 			s.Logger.Debugf("haproxy pid %d terminated", pid)
 		}
+		s.processes.terminated(pid)
 	}()
 
 	if lastPid != 0 {
+		s.processes.reloaded(lastPid)
 		// Make sure the old haproxy terminates
 		go func() {
-			time.Sleep(time.Second * 10)
+			time.Sleep(s.ReloadGracePeriod)
 			p, _ := os.FindProcess(lastPid)
 			if p != nil {
 				p.Kill()
@@ -303,6 +632,9 @@ func (s *Service) close() {
 	}
 
 	s.Logger.Infof("shutting down server in %s", osExitDelay.String())
+	for _, sc := range s.Sidecars {
+		sc.Stop()
+	}
 	time.Sleep(osExitDelay)
 
 	s.exitProcess()
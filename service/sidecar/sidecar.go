@@ -0,0 +1,162 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sidecar
+
+import (
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+const (
+	minRestartDelay = time.Second
+	maxRestartDelay = time.Minute
+	stopTimeout     = time.Second * 5
+)
+
+// Sidecar runs an external process (e.g. a SPOE agent) next to HAProxy,
+// restarting it when it terminates.
+type Sidecar interface {
+	// Start runs the process (and restarts it when needed) in the background.
+	Start()
+	// Stop terminates the process and prevents further restarts.
+	Stop()
+}
+
+type SidecarConfig struct {
+	Name    string   // Name of the sidecar (used in logs)
+	Command []string // Command (and arguments) of the process
+}
+
+type SidecarDependencies struct {
+	Logger *logging.Logger
+}
+
+type sidecar struct {
+	SidecarConfig
+	SidecarDependencies
+
+	mutex   sync.Mutex
+	cmd     *exec.Cmd
+	running bool
+	stopped bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewSidecar creates a new sidecar.
+func NewSidecar(config SidecarConfig, deps SidecarDependencies) Sidecar {
+	return &sidecar{
+		SidecarConfig:       config,
+		SidecarDependencies: deps,
+		stop:                make(chan struct{}),
+		done:                make(chan struct{}),
+	}
+}
+
+// Start runs the process (and restarts it when needed) in the background.
+func (s *sidecar) Start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.running || s.stopped {
+		return
+	}
+	s.running = true
+	go s.run()
+}
+
+// Stop terminates the process and prevents further restarts.
+func (s *sidecar) Stop() {
+	s.mutex.Lock()
+	if s.stopped {
+		s.mutex.Unlock()
+		return
+	}
+	s.stopped = true
+	close(s.stop)
+	cmd, running := s.cmd, s.running
+	s.mutex.Unlock()
+
+	if !running {
+		return
+	}
+	if cmd != nil {
+		s.Logger.Infof("Stopping sidecar %s", s.Name)
+		cmd.Process.Signal(syscall.SIGTERM)
+	}
+	select {
+	case <-s.done:
+	case <-time.After(stopTimeout):
+		if cmd != nil {
+			cmd.Process.Kill()
+		}
+	}
+}
+
+func (s *sidecar) run() {
+	defer close(s.done)
+	delay := minRestartDelay
+	for {
+		s.mutex.Lock()
+		if s.stopped {
+			s.mutex.Unlock()
+			return
+		}
+		cmd := exec.Command(s.Command[0], s.Command[1:]...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		started := time.Now()
+		err := cmd.Start()
+		if err == nil {
+			s.cmd = cmd
+		}
+		s.mutex.Unlock()
+
+		if err != nil {
+			s.Logger.Errorf("Failed to start sidecar %s: %#v", s.Name, err)
+		} else {
+			s.Logger.Infof("Sidecar %s started with pid %d", s.Name, cmd.Process.Pid)
+			err = cmd.Wait()
+		}
+
+		s.mutex.Lock()
+		s.cmd = nil
+		stopped := s.stopped
+		s.mutex.Unlock()
+		if stopped {
+			s.Logger.Infof("Sidecar %s terminated", s.Name)
+			return
+		}
+
+		// Back off when the process keeps terminating quickly
+		if time.Since(started) > maxRestartDelay {
+			delay = minRestartDelay
+		}
+		s.Logger.Warningf("Sidecar %s terminated unexpectedly (%v), restarting in %s", s.Name, err, delay)
+		select {
+		case <-time.After(delay):
+		case <-s.stop:
+			return
+		}
+		delay *= 2
+		if delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package sidecar
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+func newTestSidecar(command ...string) *sidecar {
+	return NewSidecar(SidecarConfig{Name: "test", Command: command}, SidecarDependencies{Logger: logging.MustGetLogger("test")}).(*sidecar)
+}
+
+// waitFor polls the given condition until it is true or the timeout has passed.
+func waitFor(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	return condition()
+}
+
+func TestSidecarRestarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sidecar")
+	if err != nil {
+		t.Fatalf("TempDir failed: %#v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "runs")
+
+	// The process terminates immediately, so it must be restarted
+	s := newTestSidecar("sh", "-c", "echo run >> "+path)
+	s.Start()
+	defer s.Stop()
+	runs := func() int {
+		data, _ := ioutil.ReadFile(path)
+		return strings.Count(string(data), "run")
+	}
+	if !waitFor(minRestartDelay*3, func() bool { return runs() >= 2 }) {
+		t.Fatalf("Expected sidecar to be restarted, got %d runs", runs())
+	}
+}
+
+func TestSidecarStop(t *testing.T) {
+	s := newTestSidecar("sleep", "60")
+	s.Start()
+	if !waitFor(time.Second*5, func() bool {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return s.cmd != nil
+	}) {
+		t.Fatalf("Expected sidecar to be started")
+	}
+	started := time.Now()
+	s.Stop()
+	if d := time.Since(started); d >= stopTimeout {
+		t.Errorf("Expected process to terminate on SIGTERM, took %s", d)
+	}
+	select {
+	case <-s.done:
+	default:
+		t.Errorf("Expected run loop to be finished")
+	}
+	// Stopped sidecars cannot be started again
+	s.Start()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.cmd != nil {
+		t.Errorf("Expected stopped sidecar not to restart")
+	}
+}
+
+func TestSidecarStopBeforeStart(t *testing.T) {
+	s := newTestSidecar("sleep", "60")
+	s.Stop()
+	s.Stop()
+	s.Start()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.running {
+		t.Errorf("Expected stopped sidecar not to start")
+	}
+}
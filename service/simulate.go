@@ -0,0 +1,195 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	api "github.com/pulcy/robin-api"
+
+	"github.com/pulcy/robin/service/backend"
+)
+
+// RouteRequest describes an HTTP request for which the route is simulated.
+type RouteRequest struct {
+	Host    string // Host header of the request
+	Path    string // Path of the request
+	Scheme  string // http|https
+	Private bool   // If set, the request arrives on the private network
+}
+
+// RouteMatch describes how a request is handled by the current configuration.
+type RouteMatch struct {
+	Frontend string   `json:"frontend"`
+	Matched  bool     `json:"matched"`
+	Route    *Route   `json:"route,omitempty"`
+	Reason   string   `json:"reason,omitempty"`   // Why no backend was selected
+	Redirect string   `json:"redirect,omitempty"` // If set, the request is redirected to this URL
+	Path     string   `json:"path,omitempty"`     // Path of the request as it is forwarded to the backend
+	Rewrites []string `json:"rewrites,omitempty"` // Description of the applied rewrite rules
+	Auth     string   `json:"auth"`               // none|basic|allow-unauthorized
+	Users    []string `json:"users,omitempty"`    // Users that are allowed (if auth is basic)
+}
+
+const (
+	authNone              = "none"
+	authBasic             = "basic"
+	authAllowUnauthorized = "allow-unauthorized"
+)
+
+// SimulateRoute evaluates the routing model of the current configuration for the given request.
+func (s *Service) SimulateRoute(req RouteRequest) RouteMatch {
+	services, _ := s.lastServices.Load().(backend.ServiceRegistrations)
+	return s.simulateRoute(services, req)
+}
+
+// simulateRoute evaluates the routing model of the given services for the given request.
+func (s *Service) simulateRoute(services backend.ServiceRegistrations, req RouteRequest) RouteMatch {
+	host := strings.ToLower(req.Host)
+	if i := strings.Index(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+	path := req.Path
+	if path == "" {
+		path = "/"
+	}
+	isHttps := req.Scheme == "https"
+
+	f := frontend{Port: PublicHttpPort, Public: true, Mode: "http"}
+	if req.Private {
+		f = frontend{index: 1, Port: PrivateHttpPort, Public: false, Mode: "http"}
+	}
+	result := RouteMatch{
+		Frontend: f.Name(),
+		Auth:     authNone,
+	}
+	if (f.Public && s.ExcludePublic) || (!f.Public && s.ExcludePrivate) {
+		result.Reason = "frontend is excluded"
+		return result
+	}
+
+	for _, pair := range createSelectorServicePairs(services, f) {
+		sel := pair.Selector
+		if sel.Domain == "" && sel.PathPrefix == "" {
+			continue // Selector without rules is never used in HTTP frontends
+		}
+		if !selectorMatches(sel, host, path) {
+			continue
+		}
+		route := newRoute(f, pair)
+		result.Matched = true
+		result.Route = &route
+		result.Path = path
+		if len(sel.Users) > 0 {
+			result.Auth = authBasic
+			for _, u := range sel.Users {
+				result.Users = append(result.Users, u.Name)
+			}
+		}
+		if sel.AllowUnauthorized {
+			result.Auth = authAllowUnauthorized
+		}
+		if sel.CanonicalHost != "" && !strings.EqualFold(sel.CanonicalHost, host) {
+			result.Redirect = fmt.Sprintf("%s://%s%s", req.Scheme, sel.CanonicalHost, path)
+			return result
+		}
+		if f.Public && s.ForceSsl && !isHttps && !sel.AllowInsecure && s.haveCertificates(services) {
+			result.Redirect = fmt.Sprintf("https://%s%s", host, path)
+			return result
+		}
+		for _, rw := range sel.RewriteRules {
+			if rw.PathPrefix != "" {
+				result.Path = strings.TrimSuffix(rw.PathPrefix, "/") + result.Path
+				result.Rewrites = append(result.Rewrites, fmt.Sprintf("add path prefix %s", rw.PathPrefix))
+			}
+			if rw.RemovePathPrefix != "" {
+				prefix := "/" + strings.Trim(rw.RemovePathPrefix, "/") + "/"
+				if strings.HasPrefix(result.Path, prefix) {
+					result.Path = "/" + strings.TrimPrefix(result.Path, prefix)
+				}
+				result.Rewrites = append(result.Rewrites, fmt.Sprintf("remove path prefix %s", rw.RemovePathPrefix))
+			}
+			if rw.Domain != "" {
+				redirectPath := result.Path
+				if rw.DropPath {
+					redirectPath = "/"
+				}
+				result.Redirect = fmt.Sprintf("%s://%s%s", req.Scheme, rw.Domain, redirectPath)
+				result.Rewrites = append(result.Rewrites, fmt.Sprintf("redirect to domain %s", rw.Domain))
+			}
+		}
+		return result
+	}
+	result.Reason = "no route matches, the fallback backend responds with 404"
+	return result
+}
+
+// haveCertificates returns true if any public selector of the given services has a certificate.
+func (s *Service) haveCertificates(services backend.ServiceRegistrations) bool {
+	for _, sr := range services {
+		if sr.Public && !sr.IsMail() {
+			for _, sel := range sr.Selectors {
+				if sel.IsSecure() {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// selectorMatches returns true if the given selector (including its conditions) matches the given host & path.
+func selectorMatches(sel backend.ServiceSelector, host, path string) bool {
+	if !conditionMatches(sel.Domain, sel.PathPrefix, host, path) {
+		return false
+	}
+	if len(sel.AnyOf) > 0 {
+		found := false
+		for _, c := range sel.AnyOf {
+			if conditionMatches(c.Domain, c.PathPrefix, host, path) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, c := range sel.NoneOf {
+		if conditionMatches(c.Domain, c.PathPrefix, host, path) {
+			return false
+		}
+	}
+	return true
+}
+
+// conditionMatches returns true if the given (wildcard) domain & path prefix match the given host & path,
+// using the same semantics as the `acl` rules created by createDomainAclRule.
+func conditionMatches(domain, pathPrefix, host, path string) bool {
+	if pathPrefix != "" && !strings.HasPrefix(path, pathPrefix) {
+		return false
+	}
+	domain = strings.ToLower(domain)
+	switch {
+	case domain == "":
+		return true
+	case strings.HasPrefix(domain, api.WildcardDomainPrefix):
+		return strings.HasSuffix(host, strings.TrimPrefix(domain, "*"))
+	default:
+		// `-m dom` matches a dot-delimited portion of the host
+		return strings.Contains("."+host+".", "."+domain+".")
+	}
+}
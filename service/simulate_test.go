@@ -0,0 +1,45 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/pulcy/robin/service/backend"
+)
+
+func TestSimulateRoute(t *testing.T) {
+	services := backend.ServiceRegistrations{
+		newConflictTestService("web", backend.ServiceSelector{Domain: "foo.com"}),
+		newConflictTestService("api", backend.ServiceSelector{
+			Domain:       "foo.com",
+			PathPrefix:   "/api/",
+			RewriteRules: []backend.RewriteRule{backend.RewriteRule{RemovePathPrefix: "/api"}},
+			Users:        backend.Users{backend.User{Name: "admin"}},
+		}),
+		newConflictTestService("wild", backend.ServiceSelector{Domain: "*.bar.com", SslCertName: "bar.pem"}),
+	}
+	tests := []struct {
+		Request  RouteRequest
+		Backend  string
+		Path     string
+		Auth     string
+		Redirect string
+	}{
+		{RouteRequest{Host: "foo.com", Path: "/index.html", Scheme: "https"}, "backend_web_80_public_http_in_80", "/index.html", authNone, ""},
+		{RouteRequest{Host: "www.foo.com:8080", Path: "/api/v1", Scheme: "https"}, "backend_api_80_public_http_in_80", "/v1", authBasic, ""},
+		{RouteRequest{Host: "x.bar.com", Scheme: "https"}, "backend_wild_80_public_http_in_80", "/", authNone, ""},
+		{RouteRequest{Host: "x.bar.com", Scheme: "http"}, "backend_wild_80_public_http_in_80", "/", authNone, "https://x.bar.com/"},
+		{RouteRequest{Host: "bar.com", Path: "/"}, "", "", authNone, ""},
+		{RouteRequest{Host: "foo.com", Private: true}, "", "", authNone, ""},
+	}
+	s := Service{ServiceConfig: ServiceConfig{ForceSsl: true}}
+	for _, test := range tests {
+		result := s.simulateRoute(services, test.Request)
+		backendName := ""
+		if result.Route != nil {
+			backendName = result.Route.Backend
+		}
+		if backendName != test.Backend || result.Path != test.Path || result.Auth != test.Auth || result.Redirect != test.Redirect {
+			t.Errorf("Unexpected result for %#v: %#v", test.Request, result)
+		}
+	}
+}
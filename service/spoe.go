@@ -0,0 +1,210 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pulcy/robin/haproxy"
+)
+
+// SpoeAgent is an external agent (speaking the Stream Processing Offload Protocol) that
+// requests & responses of services with a matching filter are passed through.
+// The agent can set variables (prefixed with its name) that are available to later rules.
+// Agents only receive the method, path, query, status & headers of a message. They cannot
+// modify message bodies, so content transformations such as response compression
+// (e.g. Brotli or zstd) cannot be offloaded to an agent.
+type SpoeAgent struct {
+	Name    string // Name of the agent, as used in the filters of frontend records
+	Address string // Address (host:port) of the agent
+}
+
+// spoeBackendName returns the name of the backend containing the agent with given name.
+func spoeBackendName(name string) string {
+	return "spoe_" + cleanName(name)
+}
+
+// spoeVarPrefix returns the prefix of the variables set by the agent with given name.
+func spoeVarPrefix(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// spoeConfigPath returns the path of the SPOE config file of the agent with given name.
+func (s *Service) spoeConfigPath(name string) string {
+	return filepath.Join(filepath.Dir(s.HaproxyConfPath), fmt.Sprintf("spoe-%s.conf", cleanName(name)))
+}
+
+// spoeAgentsByName returns the configured agents by their name.
+// SPOE requires HAProxy 1.7, so on older versions no agents are available.
+func (s *Service) spoeAgentsByName() map[string]SpoeAgent {
+	result := make(map[string]SpoeAgent)
+	if !s.HaproxyVersion.AtLeast(1, 7) {
+		return result
+	}
+	for _, agent := range s.SpoeAgents {
+		result[agent.Name] = agent
+	}
+	return result
+}
+
+// createSpoeFilters creates the filter lines of a backend with given filters.
+// Filters without a configured agent are ignored.
+// The names of the used agents are added to the given set.
+func (s *Service) createSpoeFilters(filters []string, used map[string]struct{}) []string {
+	agents := s.spoeAgentsByName()
+	lines := []string{}
+	for _, name := range filters {
+		if _, ok := agents[name]; !ok {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("filter spoe engine %s config %s", name, s.spoeConfigPath(name)))
+		used[name] = struct{}{}
+	}
+	return lines
+}
+
+// createSpoeBackends creates a backend for each of the given (used) agents and
+// returns the content of their SPOE config files (by path).
+// Filter agents are used by filters, auth agents are used by selectors with an auth agent,
+// scan agents are used by services with a body scan.
+func (s *Service) createSpoeBackends(c *haproxy.Config, filterAgents, authAgents, scanAgents map[string]struct{}) map[string][]string {
+	used := make(map[string]struct{})
+	configs := make(map[string][]string)
+	for name := range filterAgents {
+		used[name] = struct{}{}
+		configs[s.spoeConfigPath(name)] = createSpoeConfig(name)
+	}
+	for name := range authAgents {
+		used[name] = struct{}{}
+		configs[s.spoeConfigPath(authEngineName(name))] = createAuthSpoeConfig(name)
+	}
+	for name := range scanAgents {
+		used[name] = struct{}{}
+		configs[s.spoeConfigPath(scanEngineName(name))] = createScanSpoeConfig(name)
+	}
+	names := []string{}
+	for name := range used {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	agents := s.spoeAgentsByName()
+	for _, name := range names {
+		agent := agents[name]
+		c.Section("backend "+spoeBackendName(name)).Add(
+			"mode tcp",
+			"balance roundrobin",
+			fmt.Sprintf("server agent %s", agent.Address),
+		)
+	}
+	return configs
+}
+
+// createSpoeConfig creates the lines of the SPOE config file of the agent with given name.
+// The agent receives a message with the request before it is sent to a server
+// and a message with the response before it is sent to the client.
+func createSpoeConfig(name string) []string {
+	return []string{
+		fmt.Sprintf("[%s]", name),
+		fmt.Sprintf("spoe-agent %s", name),
+		"    messages request response",
+		fmt.Sprintf("    option var-prefix %s", spoeVarPrefix(name)),
+		"    option set-on-error error",
+		"    timeout hello 2s",
+		"    timeout idle 2m",
+		"    timeout processing 500ms",
+		fmt.Sprintf("    use-backend %s", spoeBackendName(name)),
+		"",
+		"spoe-message request",
+		"    args method=method path=path query=query headers=req.hdrs_bin",
+		"    event on-backend-http-request",
+		"",
+		"spoe-message response",
+		"    args status=status headers=res.hdrs_bin",
+		"    event on-http-response",
+	}
+}
+
+// authEngineName returns the name of the SPOE engine that sends requests to the auth agent with given name.
+func authEngineName(name string) string {
+	return name + "-auth"
+}
+
+// addAuthFilters adds a filter for every (configured) auth agent used by the given blocks.
+// The names of the used agents are added to the given set.
+func (s *Service) addAuthFilters(section *haproxy.Section, useBlocks []useBlock, used map[string]struct{}) {
+	agents := s.spoeAgentsByName()
+	added := make(map[string]struct{})
+	for _, block := range useBlocks {
+		name := block.AuthAgent
+		if _, ok := agents[name]; !ok {
+			continue
+		}
+		if _, ok := added[name]; ok {
+			continue
+		}
+		added[name] = struct{}{}
+		used[name] = struct{}{}
+		engine := authEngineName(name)
+		section.Add(fmt.Sprintf("filter spoe engine %s config %s", engine, s.spoeConfigPath(engine)))
+	}
+}
+
+// addExternalAuth adds rules that send requests matching the given conditions to the auth agent with given name
+// and deny them unless the agent sets the `allowed` variable.
+// If the agent is not known, all matching requests are denied.
+func addExternalAuth(section *haproxy.Section, name string, known bool, conditions string) {
+	if !known {
+		section.Add(fmt.Sprintf("http-request deny if %s", conditions))
+		return
+	}
+	engine := authEngineName(name)
+	section.Add(
+		fmt.Sprintf("http-request send-spoe-group %s check if %s", engine, conditions),
+		fmt.Sprintf("http-request deny if %s !{ var(txn.%s.allowed) -m bool }", conditions, spoeVarPrefix(engine)),
+	)
+}
+
+// createAuthSpoeConfig creates the lines of the SPOE config file used to send requests
+// to the auth agent with given name.
+// The agent must set the `allowed` variable to allow a request. If the agent
+// cannot be reached, the variable is not set, so the request is denied.
+func createAuthSpoeConfig(name string) []string {
+	engine := authEngineName(name)
+	return []string{
+		fmt.Sprintf("[%s]", engine),
+		fmt.Sprintf("spoe-agent %s", engine),
+		"    groups check",
+		fmt.Sprintf("    option var-prefix %s", spoeVarPrefix(engine)),
+		"    option set-on-error error",
+		"    timeout hello 2s",
+		"    timeout idle 2m",
+		"    timeout processing 500ms",
+		fmt.Sprintf("    use-backend %s", spoeBackendName(name)),
+		"",
+		"spoe-message authorize",
+		"    args method=method host=var(txn.host) path=path query=query src=src headers=req.hdrs_bin",
+		"",
+		"spoe-group check",
+		"    messages authorize",
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pulcy/robin/haproxy"
+	"github.com/pulcy/robin/service/backend"
+)
+
+// PortRange is an (inclusive) range of edge ports.
+// TCP frontends on ports in the range are served by a single frontend (per visibility) that
+// binds the entire range up front, so adding or removing TCP services never changes the listeners.
+type PortRange struct {
+	Min int
+	Max int
+}
+
+// ParsePortRange parses a port range formatted as `<min>-<max>`.
+func ParsePortRange(s string) (PortRange, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return PortRange{}, maskAny(fmt.Errorf("Invalid port range '%s', expected <min>-<max>", s))
+	}
+	min, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return PortRange{}, maskAny(fmt.Errorf("Invalid port range '%s': %v", s, err))
+	}
+	max, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return PortRange{}, maskAny(fmt.Errorf("Invalid port range '%s': %v", s, err))
+	}
+	r := PortRange{Min: min, Max: max}
+	if err := r.Validate(); err != nil {
+		return PortRange{}, maskAny(err)
+	}
+	return r, nil
+}
+
+// IsEmpty returns true if the range contains no ports.
+func (r PortRange) IsEmpty() bool {
+	return r.Min == 0 && r.Max == 0
+}
+
+// Contains returns true if the given port is in the range.
+func (r PortRange) Contains(port int) bool {
+	return !r.IsEmpty() && port >= r.Min && port <= r.Max
+}
+
+// Validate checks the range for errors.
+func (r PortRange) Validate() error {
+	if r.IsEmpty() {
+		return nil
+	}
+	if r.Min < 1 || r.Max > 65535 || r.Min > r.Max {
+		return maskAny(fmt.Errorf("Invalid port range %s", r))
+	}
+	for _, port := range []int{PublicHttpPort, PublicHttpsPort, PrivateHttpPort, PrivateTcpSslPort, PrivateHttpsPort} {
+		if r.Contains(port) {
+			return maskAny(fmt.Errorf("Port range %s contains reserved port %d", r, port))
+		}
+	}
+	return nil
+}
+
+func (r PortRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// inTcpPortRange returns true if the given frontend is served by a pre-bound TCP port range frontend.
+func (s *Service) inTcpPortRange(f frontend) bool {
+	return f.IsTCP() && s.TcpPortRange.Contains(f.Port)
+}
+
+// createTcpPortRangeFrontends creates a frontend per visibility that binds the entire TCP port range.
+// The frontends are created even when they serve no services, so the listeners remain the same
+// when TCP services come & go. Connections are passed to the backend of their destination port.
+func (s *Service) createTcpPortRangeFrontends(c *haproxy.Config, services backend.ServiceRegistrations, frontends frontendList, backends map[string]backendConfig) map[string]backendConfig {
+	if s.TcpPortRange.IsEmpty() {
+		return backends
+	}
+	for _, public := range []bool{true, false} {
+		if (public && s.ExcludePublic) || (!public && s.ExcludePrivate) {
+			continue
+		}
+		name := fmt.Sprintf("private_tcp_in_%d_%d", s.TcpPortRange.Min, s.TcpPortRange.Max)
+		host := "*"
+		if public {
+			name = fmt.Sprintf("public_tcp_in_%d_%d", s.TcpPortRange.Min, s.TcpPortRange.Max)
+			if s.PublicHost != "" {
+				host = s.PublicHost
+			}
+		} else if s.PrivateHost != "" {
+			host = s.PrivateHost
+		}
+		section := c.Section("frontend " + name)
+		section.Add(
+			fmt.Sprintf("bind %s:%s", host, s.TcpPortRange),
+			"mode tcp",
+			"default_backend fallback",
+		)
+		ng := NewNameGenerator("acl")
+		for _, f := range frontends {
+			if f.Public != public || !s.inTcpPortRange(f) {
+				continue
+			}
+			portAclName := fmt.Sprintf("port_%d", f.Port)
+			section.Add(fmt.Sprintf("acl %s dst_port %d", portAclName, f.Port))
+			var useBlocks []useBlock
+			useBlocks, backends = createAcls(section, services, f, false, ng, backends, "")
+			for i, block := range useBlocks {
+				useBlocks[i].AclNames = append([]string{portAclName}, block.AclNames...)
+			}
+			createUseBackends(section, useBlocks, backends, f, s.HaproxyVersion, false, false, false, nil, "", nil)
+		}
+	}
+	return backends
+}
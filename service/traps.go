@@ -0,0 +1,61 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	api "github.com/pulcy/robin-api"
+	"github.com/pulcy/robin/haproxy"
+)
+
+const (
+	banReasonTrap = "trap"
+)
+
+// countsTraps returns true if trap requests are counted in the ip_failures table.
+// The gpc1 & gpt0 counters require HAProxy 1.8.
+func (s *Service) countsTraps() bool {
+	return s.IPBan.IsEnabled() && s.HaproxyVersion.AtLeast(1, 8)
+}
+
+// addTraps adds rules to the given frontend section that tarpit or refuse requests for the trap paths of the given blocks.
+// When source IPs are banned automatically (public frontends only, HAProxy 1.8 or later), trap requests are counted,
+// so they are reported as security event and their source IP is banned if the trap action is ban.
+// Otherwise all trap requests are tarpitted.
+func (s *Service) addTraps(section *haproxy.Section, useBlocks []useBlock, selection frontend) {
+	if !selection.IsHTTP() {
+		return
+	}
+	counted := s.countsTraps() && selection.Public
+	for _, block := range useBlocks {
+		if len(block.TrapPaths) == 0 || len(block.AclNames) == 0 {
+			continue
+		}
+		conditions := fmt.Sprintf("%s { path_beg %s }", strings.Join(block.AclNames, " "), strings.Join(block.TrapPaths, " "))
+		if counted {
+			section.Add(fmt.Sprintf("http-request sc-inc-gpc1(%d) if %s", ipBanStickIndex, conditions))
+			if block.TrapAction == api.TrapActionBan {
+				section.Add(
+					fmt.Sprintf("http-request sc-set-gpt0(%d) 1 if %s", ipBanStickIndex, conditions),
+					fmt.Sprintf("http-request deny deny_status 403 if %s", conditions),
+				)
+				continue
+			}
+		}
+		section.Add(fmt.Sprintf("http-request tarpit if %s", conditions))
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/pulcy/robin/service/accounting"
+	"github.com/pulcy/robin/service/backend"
+)
+
+const (
+	accountingInterval = time.Minute
+)
+
+// accountingLoop periodically records the traffic of all backends (per service).
+func (s *Service) accountingLoop(ctx context.Context) {
+	for sleep(ctx, accountingInterval) {
+		if err := s.recordUsage(time.Now()); err != nil {
+			s.Logger.Warningf("Failed to record usage: %#v", err)
+		}
+	}
+}
+
+// recordUsage passes the current traffic counters of all backends of services to the accountant.
+func (s *Service) recordUsage(now time.Time) error {
+	client, err := s.runtimeClient()
+	if err != nil {
+		return maskAny(err)
+	}
+	stats, err := client.BackendStats()
+	if err != nil {
+		return maskAny(err)
+	}
+	services, _ := s.lastServices.Load().(backend.ServiceRegistrations)
+	backendServices := s.createBackendServices(services)
+	var samples []accounting.Sample
+	for _, stat := range stats {
+		serviceName, ok := backendServices[stat.Name]
+		if !ok {
+			continue
+		}
+		samples = append(samples, accounting.Sample{
+			Backend: stat.Name,
+			Service: serviceName,
+			Counters: accounting.Counters{
+				Requests: stat.Requests,
+				BytesIn:  stat.BytesIn,
+				BytesOut: stat.BytesOut,
+			},
+		})
+	}
+	if err := s.Accountant.Record(now, samples); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// createBackendServices returns the name of the service served by each backend of the generated configuration.
+func (s *Service) createBackendServices(services backend.ServiceRegistrations) map[string]string {
+	services = s.addGatewaySelectors(services)
+	result := make(map[string]string)
+	for _, f := range s.collectFrontends(services) {
+		for _, pair := range createSelectorServicePairs(services, f) {
+			backendName := generateBackendName(pair.Service, f)
+			result[backendName] = pair.Service.ServiceName
+			result[promotedBackendName(backendName)] = pair.Service.ServiceName
+		}
+	}
+	return result
+}
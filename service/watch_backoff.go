@@ -0,0 +1,117 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	// DefaultWatchBackoff is the default delay after the first failed watch of the backend.
+	DefaultWatchBackoff = time.Second
+	// DefaultWatchMaxBackoff is the default maximum delay between failed watches of the backend.
+	DefaultWatchMaxBackoff = time.Minute
+	// DefaultWatchCircuitThreshold is the default number of consecutive failed watches after which the circuit opens.
+	DefaultWatchCircuitThreshold = 5
+)
+
+// WatchBackoffConfig specifies how long to wait before retrying a failed watch of the backend.
+// The delay doubles with every consecutive failure. Once the circuit is open (too many consecutive failures),
+// failures are no longer logged individually until a watch succeeds again.
+type WatchBackoffConfig struct {
+	Initial          time.Duration // Delay after the first failure (0 means DefaultWatchBackoff)
+	Max              time.Duration // Maximum delay (0 means DefaultWatchMaxBackoff)
+	Jitter           float64       // Fraction (0-1) of the delay that is randomized, so load-balancers do not retry in sync (0 means none)
+	CircuitThreshold int           // Number of consecutive failures after which the circuit opens (0 means DefaultWatchCircuitThreshold)
+}
+
+func (c WatchBackoffConfig) initial() time.Duration {
+	if c.Initial == 0 {
+		return DefaultWatchBackoff
+	}
+	return c.Initial
+}
+
+func (c WatchBackoffConfig) max() time.Duration {
+	if c.Max == 0 {
+		return DefaultWatchMaxBackoff
+	}
+	return c.Max
+}
+
+func (c WatchBackoffConfig) circuitThreshold() int {
+	if c.CircuitThreshold == 0 {
+		return DefaultWatchCircuitThreshold
+	}
+	return c.CircuitThreshold
+}
+
+// watchBackoff tracks consecutive failed watches and derives the delay before the next attempt.
+type watchBackoff struct {
+	config   WatchBackoffConfig
+	failures int
+	random   func() float64 // Returns a number in [0,1)
+}
+
+func newWatchBackoff(config WatchBackoffConfig) *watchBackoff {
+	b := &watchBackoff{
+		config: config,
+		random: rand.Float64,
+	}
+	b.updateMetrics(0)
+	return b
+}
+
+// isOpen returns true when the circuit is open, i.e. the number of consecutive failures reached the threshold.
+func (b *watchBackoff) isOpen() bool {
+	return b.failures >= b.config.circuitThreshold()
+}
+
+// failed records a failed watch and returns the delay before the next attempt.
+func (b *watchBackoff) failed() time.Duration {
+	b.failures++
+	delay := b.config.initial()
+	max := b.config.max()
+	for i := 1; i < b.failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	if jitter := b.config.Jitter; jitter > 0 {
+		if jitter > 1 {
+			jitter = 1
+		}
+		delay -= time.Duration(float64(delay) * jitter * b.random())
+	}
+	b.updateMetrics(delay)
+	return delay
+}
+
+// succeeded records a successful watch, which closes the circuit.
+func (b *watchBackoff) succeeded() {
+	b.failures = 0
+	b.updateMetrics(0)
+}
+
+func (b *watchBackoff) updateMetrics(delay time.Duration) {
+	if b.isOpen() {
+		watchCircuitOpen.Set(1)
+	} else {
+		watchCircuitOpen.Set(0)
+	}
+	watchRetryDelay.Set(delay.Seconds())
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchBackoff(t *testing.T) {
+	b := newWatchBackoff(WatchBackoffConfig{
+		Initial:          time.Second,
+		Max:              time.Second * 10,
+		CircuitThreshold: 3,
+	})
+	expected := []time.Duration{time.Second, time.Second * 2, time.Second * 4, time.Second * 8, time.Second * 10, time.Second * 10}
+	for i, e := range expected {
+		if d := b.failed(); d != e {
+			t.Errorf("Expected delay %s after %d failures, got %s", e, i+1, d)
+		}
+		if open := i+1 >= 3; b.isOpen() != open {
+			t.Errorf("Expected circuit open=%v after %d failures", open, i+1)
+		}
+	}
+	b.succeeded()
+	if b.isOpen() {
+		t.Errorf("Expected circuit closed after success")
+	}
+	if d := b.failed(); d != time.Second {
+		t.Errorf("Expected initial delay after success, got %s", d)
+	}
+
+	// Jitter subtracts up to the given fraction of the delay
+	b = newWatchBackoff(WatchBackoffConfig{Initial: time.Second * 10, Jitter: 0.5})
+	b.random = func() float64 { return 0.5 }
+	if d := b.failed(); d != time.Millisecond*7500 {
+		t.Errorf("Expected delay 7.5s with jitter, got %s", d)
+	}
+}
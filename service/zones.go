@@ -0,0 +1,46 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"github.com/pulcy/robin/service/backend"
+)
+
+// preferLocalZone turns the instances of zone-aware services that are not in the zone
+// of this load-balancer into backup servers, so traffic only crosses zones when
+// no local instance is available.
+// Services without any instance in the local zone are left untouched.
+func (s *Service) preferLocalZone(services backend.ServiceRegistrations) backend.ServiceRegistrations {
+	if s.Zone == "" {
+		return services
+	}
+	result := make(backend.ServiceRegistrations, 0, len(services))
+	for _, sr := range services {
+		if sr.ZoneAware && sr.Instances.HasMetadata(backend.MetadataZone, s.Zone) {
+			instances := make(backend.ServiceInstances, 0, len(sr.Instances))
+			for _, instance := range sr.Instances {
+				if instance.Metadata[backend.MetadataZone] != s.Zone {
+					instance.Backup = true
+				}
+				instances = append(instances, instance)
+			}
+			sr.Instances = instances
+			// Spread the load over all other zones when falling back
+			sr.AllBackups = true
+		}
+		result = append(result, sr)
+	}
+	return result
+}
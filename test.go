@@ -0,0 +1,105 @@
+// Copyright (c) 2016 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pulcy/robin/haproxy"
+	"github.com/pulcy/robin/service"
+)
+
+var (
+	cmdTest = &cobra.Command{
+		Use:   "test",
+		Short: "Test routing of synthetic requests against the rendered config",
+		Long:  "Test routing of synthetic requests against the rendered config. HAProxy is started with the config of the declared services on ephemeral ports, with stub backends for all services.",
+		Run:   cmdTestRun,
+	}
+
+	testArgs struct {
+		requestsPath   string
+		haproxyPath    string
+		haproxyVersion string
+		sslCertsFolder string
+		forceSsl       bool
+	}
+)
+
+func init() {
+	cmdTest.Flags().StringVar(&testArgs.requestsPath, "requests", "", "Path of the file (YAML or JSON) declaring the services & requests to test")
+	cmdTest.Flags().StringVar(&testArgs.haproxyPath, "haproxy", "haproxy", "Path of the haproxy executable")
+	cmdTest.Flags().StringVar(&testArgs.haproxyVersion, "haproxy-version", "", "Version of HAProxy (e.g. 2.4) to generate native directives for. If empty, legacy directives are generated")
+	cmdTest.Flags().StringVar(&testArgs.sslCertsFolder, "ssl-certs", defaultSslCertsFolder, "Folder containing SSL certificate")
+	cmdTest.Flags().BoolVar(&testArgs.forceSsl, "force-ssl", defaultForceSsl, "Redirect HTTP to HTTPS")
+	cmdMain.AddCommand(cmdTest)
+}
+
+func cmdTestRun(cmd *cobra.Command, args []string) {
+	if testArgs.requestsPath == "" {
+		Exitf("Please specify --requests")
+	}
+	raw, err := ioutil.ReadFile(testArgs.requestsPath)
+	if err != nil {
+		Exitf("Cannot read %s: %v", testArgs.requestsPath, err)
+	}
+	spec, err := service.ParseReplaySpec(raw)
+	if err != nil {
+		Exitf("Cannot parse %s: %v", testArgs.requestsPath, err)
+	}
+	var haproxyVersion haproxy.Version
+	if testArgs.haproxyVersion != "" {
+		haproxyVersion, err = haproxy.ParseVersion(testArgs.haproxyVersion)
+		if err != nil {
+			Exitf("Invalid haproxy-version: %v", err)
+		}
+	}
+
+	s := service.NewService(service.ServiceConfig{
+		HaproxyPath:    testArgs.haproxyPath,
+		HaproxyVersion: haproxyVersion,
+		SslCertsFolder: testArgs.sslCertsFolder,
+		ForceSsl:       testArgs.forceSsl,
+		PrivateHost:    "127.0.0.1",
+	}, service.ServiceDependencies{
+		Logger: log,
+	})
+	results, err := s.Replay(spec)
+	if err != nil {
+		Exitf("Test failed: %v", err)
+	}
+	failed := 0
+	for i, r := range results {
+		name := r.Request.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d %s://%s%s", i+1, r.Request.Scheme, r.Request.Host, r.Request.Path)
+		}
+		if r.Passed() {
+			fmt.Printf("PASS %s\n", name)
+		} else {
+			failed++
+			for _, f := range r.Failures {
+				fmt.Printf("FAIL %s: %s\n", name, f)
+			}
+		}
+	}
+	if failed > 0 {
+		Exitf("%d of %d requests failed", failed, len(results))
+	}
+	fmt.Printf("All %d requests passed\n", len(results))
+}